@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/standrze/rogue/internal/assertion"
+	"github.com/standrze/rogue/internal/capture"
+	"github.com/standrze/rogue/internal/filter"
+	"github.com/standrze/rogue/internal/logger"
+)
+
+var assertCmd = &cobra.Command{
+	Use:   "assert [session]",
+	Short: "Check a session against assertions, failing on violations for CI",
+	Long: `Check the flows of a session against the assertions in --rules and exit
+non-zero if any is violated, for proxy-based checks in CI pipelines. Without a
+session a capture is run first, ended by --duration, --max-requests or
+--url-trigger as with rogue capture, and the sessions it writes are checked.
+
+Each assertion has a name and one condition written in the filter language of
+rogue sessions filter, with @name referring to filters.saved:
+
+    assertions:
+      - name: no analytics
+        none: host =~ "(^|\\.)analytics\\.com$"
+      - name: fast API
+        where: host == "api.example.com"
+        all: duration < 500
+      - name: no server errors
+        none: status >= 500
+      - name: logged in
+        some: path == "/login" && status == 200
+
+none fails if any flow matches, all if any flow does not, and some if none
+does; where limits the flows an assertion looks at. Durations are in
+milliseconds. A request without a response has status 0 and duration 0.
+
+The report is printed on standard output, and the proxy's messages during a
+capture on standard error.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+		file, _ := cmd.Flags().GetString("rules")
+		assertions, err := assertion.Load(file, filter.Saved(cfg.Filters))
+		if err != nil {
+			return err
+		}
+
+		var sessions []string
+		stop := captureStop(cmd)
+		if len(args) == 1 {
+			if stop != (capture.Stop{}) {
+				return fmt.Errorf("--duration, --max-requests and --url-trigger run a capture and cannot be given with a session")
+			}
+			path, err := resolveSession(cfg, args[0])
+			if err != nil {
+				return err
+			}
+			sessions = []string{path}
+		} else {
+			if stop == (capture.Stop{}) {
+				return fmt.Errorf("give a session, or a --duration, --max-requests or --url-trigger to capture one")
+			}
+			if sessions, err = runCapture(stop, cmd.ErrOrStderr()); err != nil {
+				return err
+			}
+			if len(sessions) == 0 {
+				return fmt.Errorf("the capture wrote no session")
+			}
+		}
+
+		var flows []*logger.Flow
+		for _, path := range sessions {
+			fs, err := logger.LoadFlows(path)
+			if err != nil {
+				return err
+			}
+			flows = append(flows, fs...)
+		}
+		results := assertion.Check(assertions, flows)
+
+		if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+			enc := json.NewEncoder(cmd.OutOrStdout())
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(results); err != nil {
+				return err
+			}
+		} else {
+			assertion.Write(cmd.OutOrStdout(), assertions, results)
+		}
+		if n := assertion.Failed(results); n > 0 {
+			return fmt.Errorf("%d of %d assertions failed", n, len(results))
+		}
+		return nil
+	},
+}
+
+func init() {
+	assertCmd.Flags().String("rules", "assertions.yaml", "File of assertions to check")
+	assertCmd.Flags().Bool("json", false, "Print the results as JSON")
+	captureStopFlags(assertCmd)
+}
@@ -0,0 +1,171 @@
+package cmd
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/standrze/rogue/internal/bench"
+	"github.com/standrze/rogue/internal/config"
+	"github.com/standrze/rogue/internal/logger"
+	"github.com/standrze/rogue/internal/proxy"
+)
+
+var benchCmd = &cobra.Command{
+	Use:   "bench",
+	Short: "Drive synthetic load through the proxy and report its performance",
+	Long: `Start a local origin and send requests to it through a proxy, then report
+throughput, latency percentiles and allocations per request.
+
+By default the proxy is started in this process with the configured logging
+settings and rules, writing its session to a temporary directory, so the
+allocations reported include the proxy's. With --proxy the load goes to a
+running instance instead; with --tls it must trust the origin's self-signed
+certificate, for instance by listing 127.0.0.1 in tls.skip_verify_hosts.
+
+--rate paces the requests; without it each of the --concurrency workers
+sends its next request as soon as the last one is answered. --size may be
+given several times to request bodies of different sizes in turn.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+
+		var opts bench.Options
+		opts.Rate, _ = cmd.Flags().GetFloat64("rate")
+		opts.Concurrency, _ = cmd.Flags().GetInt("concurrency")
+		opts.Duration, _ = cmd.Flags().GetDuration("duration")
+		opts.Requests, _ = cmd.Flags().GetInt("requests")
+		opts.Sizes, _ = cmd.Flags().GetIntSlice("size")
+		opts.BodySize, _ = cmd.Flags().GetInt("body-size")
+		secure, _ := cmd.Flags().GetBool("tls")
+		if opts.Duration <= 0 && opts.Requests <= 0 {
+			return fmt.Errorf("give a --duration or a number of --requests")
+		}
+
+		// The origin outlives the proxy, which is stopped first.
+		origin := bench.NewOrigin(secure)
+		defer origin.Close()
+
+		external := cmd.Flags().Changed("proxy")
+		if external {
+			if opts.Proxy, err = proxyAddress(cmd, cfg.Proxy); err != nil {
+				return err
+			}
+			useLegacyCA(&cfg.Certificate)
+			if secure {
+				if opts.RootCAs, err = proxyRoots(cfg.Certificate.CertPath); err != nil {
+					return err
+				}
+			}
+		} else {
+			stop, err := benchProxy(cfg, &opts)
+			if err != nil {
+				return err
+			}
+			defer stop()
+		}
+
+		res, err := bench.Run(cmd.Context(), origin.URL, opts)
+		if err != nil {
+			return err
+		}
+		if external {
+			// Only the load generator's allocations would be counted.
+			res.AllocsPerRequest, res.AllocBytesPerRequest = 0, 0
+		}
+		if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+			enc := json.NewEncoder(cmd.OutOrStdout())
+			enc.SetIndent("", "  ")
+			return enc.Encode(res)
+		}
+		bench.Write(cmd.OutOrStdout(), res)
+		return nil
+	},
+}
+
+// benchProxy starts a proxy in this process with the logging settings and
+// rules of cfg and a throwaway CA and session directory, and points opts
+// at it.
+func benchProxy(cfg *config.Config, opts *bench.Options) (func(), error) {
+	_, vars, err := environment(cfg, "")
+	if err != nil {
+		return nil, err
+	}
+	set, err := loadRules(cfg.Rules.Files, vars)
+	if err != nil {
+		return nil, err
+	}
+	queuePolicy, err := logger.ParseQueuePolicy(cfg.Logging.QueuePolicy)
+	if err != nil {
+		return nil, err
+	}
+	queueSize := 0
+	if cfg.Logging.Async {
+		queueSize = cfg.Logging.QueueSize
+	}
+	dir, err := os.MkdirTemp("", "rogue-bench-")
+	if err != nil {
+		return nil, err
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	main := &proxy.Listener{Mode: proxy.ModeHTTP, Listener: l}
+
+	certPath := filepath.Join(dir, "ca.crt")
+	p, sl := proxy.NewProxyServer(
+		proxy.WithMainListener(main),
+		proxy.WithCert(certPath, filepath.Join(dir, "ca.key")),
+		proxy.WithSessionDir(filepath.Join(dir, "logs")),
+		proxy.WithAsyncLogging(queueSize, queuePolicy, seconds(cfg.Logging.SyncInterval)),
+		proxy.WithLogging(
+			cfg.Logging.LogRequests,
+			cfg.Logging.LogResponses,
+			cfg.Logging.LogHeaders,
+			cfg.Logging.LogBody,
+			cfg.Logging.MaxBodySize,
+		),
+		// The origin's certificate is self-signed.
+		proxy.WithTLSPolicy(proxy.TLSPolicy{SkipVerifyHosts: []string{"127.0.0.1"}}),
+		proxy.WithRules(set),
+	)
+	stop := func() {
+		p.Close()
+		sl.Close()
+		os.RemoveAll(dir)
+	}
+	go p.Serve(main.Listener)
+	opts.Proxy = l.Addr().String()
+
+	data, err := os.ReadFile(certPath)
+	if err != nil {
+		stop()
+		return nil, err
+	}
+	opts.RootCAs = x509.NewCertPool()
+	opts.RootCAs.AppendCertsFromPEM(data)
+	return stop, nil
+}
+
+func init() {
+	benchCmd.Flags().String("proxy", "", "Address of a running proxy to load instead of one started in process")
+	benchCmd.Flags().Bool("tls", false, "Send requests over HTTPS through CONNECT tunnels")
+	benchCmd.Flags().Float64("rate", 0, "Requests per second in total (default as fast as possible)")
+	benchCmd.Flags().Int("concurrency", 16, "Requests in flight at once")
+	benchCmd.Flags().Duration("duration", 10*time.Second, "How long to send requests for")
+	benchCmd.Flags().Int("requests", 0, "Stop after this many requests")
+	benchCmd.Flags().IntSlice("size", []int{1024}, "Response body size in bytes; repeat for a mix")
+	benchCmd.Flags().Int("body-size", 0, "Send POST requests with bodies of this many bytes")
+	benchCmd.Flags().Bool("json", false, "Print the result as JSON")
+}
@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/spf13/cobra"
+	"github.com/standrze/rogue/internal/capture"
+)
+
+var captureCmd = &cobra.Command{
+	Use:   "capture",
+	Short: "Record a session until a time, a request count or a URL ends it",
+	Long: `Run the proxy like rogue start and stop once the capture has what it is for:
+after --duration, after --max-requests requests, or once a request matching
+--url-trigger is seen. * in --url-trigger matches any run of characters; a URL
+without one also matches the URLs it is a prefix of, such as with a query. The
+capture ends with the response to the request that triggers it, or ` + fmt.Sprint(capture.ResponseWait) + `
+later if none arrives. Conditions combine; whichever is met first ends it.
+
+The paths of the session files written are printed on standard output when
+the capture ends, one per line, and everything else on standard error, so
+scripts can take the session from the output:
+
+    session=$(rogue capture --duration 10m --url-trigger https://app.example.com/checkout)`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		stop := captureStop(cmd)
+		if stop == (capture.Stop{}) {
+			return fmt.Errorf("give a --duration, --max-requests or --url-trigger to end the capture")
+		}
+		sessions, err := runCapture(stop, cmd.ErrOrStderr())
+		for _, path := range sessions {
+			fmt.Fprintln(cmd.OutOrStdout(), path)
+		}
+		return err
+	},
+}
+
+// runCapture runs the proxy until stop ends the capture and returns the
+// paths of the session files written. The proxy's messages go to out,
+// leaving standard output to the caller.
+func runCapture(stop capture.Stop, out io.Writer) ([]string, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	if stdoutSink(cfg.Logging) {
+		return nil, fmt.Errorf("logging.stdout cannot be used with a capture, which keeps standard output for its results")
+	}
+	_, vars, err := environment(cfg, "")
+	if err != nil {
+		return nil, err
+	}
+	set, err := loadRules(cfg.Rules.Files, vars)
+	if err != nil {
+		return nil, err
+	}
+
+	watch, err := capture.New(stop)
+	if err != nil {
+		return nil, err
+	}
+	err = runProxy(cfg, set, watch, out)
+	return watch.Sessions(), err
+}
+
+// captureStopFlags adds the flags ending a capture to cmd.
+func captureStopFlags(cmd *cobra.Command) {
+	cmd.Flags().Duration("duration", 0, "End the capture after this long, e.g. 10m")
+	cmd.Flags().Int("max-requests", 0, "End the capture after this many requests")
+	cmd.Flags().String("url-trigger", "", "End the capture once a request to a matching URL is seen")
+}
+
+// captureStop reads the flags added by captureStopFlags.
+func captureStop(cmd *cobra.Command) capture.Stop {
+	var stop capture.Stop
+	stop.Duration, _ = cmd.Flags().GetDuration("duration")
+	stop.MaxRequests, _ = cmd.Flags().GetInt("max-requests")
+	stop.URL, _ = cmd.Flags().GetString("url-trigger")
+	return stop
+}
+
+func init() {
+	captureStopFlags(captureCmd)
+}
@@ -0,0 +1,369 @@
+package cmd
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/standrze/rogue/internal/cert"
+	"github.com/standrze/rogue/internal/config"
+	"github.com/standrze/rogue/internal/onboard"
+	"github.com/standrze/rogue/internal/qr"
+	"github.com/standrze/rogue/internal/sidecar"
+)
+
+var certCmd = &cobra.Command{
+	Use:   "cert",
+	Short: "Manage the certificate authority used for interception",
+}
+
+var certCreateIntermediateCmd = &cobra.Command{
+	Use:   "create-intermediate",
+	Short: "Issue an intermediate signing CA under an existing root",
+	Long: `Issue an intermediate CA signed by an organization's root CA and write it to the
+configured certificate paths. Leaf certificates minted by rogue then chain to a root
+that managed devices already trust, without handing rogue the root key at runtime.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+
+		rootCert, _ := cmd.Flags().GetString("root-cert")
+		rootKey, _ := cmd.Flags().GetString("root-key")
+		commonName, _ := cmd.Flags().GetString("common-name")
+		days, _ := cmd.Flags().GetInt("days")
+		force, _ := cmd.Flags().GetBool("force")
+		if days <= 0 {
+			days = cfg.Certificate.ValidDays
+		}
+
+		certPath, keyPath := cfg.Certificate.CertPath, cfg.Certificate.KeyPath
+		if cert.Exists(certPath, keyPath) && !force {
+			return fmt.Errorf("%s already exists; pass --force to replace it", certPath)
+		}
+
+		keyOpts, err := keyOptions(cfg.Certificate)
+		if err != nil {
+			return err
+		}
+		rootPassphrase := func() ([]byte, error) {
+			return promptPassphrase(fmt.Sprintf("Passphrase for %s: ", rootKey))
+		}
+
+		if err := cert.CreateIntermediate(rootCert, rootKey, rootPassphrase, cfg.Certificate.Organization, commonName, days, certPath, keyPath, keyOpts); err != nil {
+			return err
+		}
+		fmt.Printf("Wrote intermediate CA to %s and its key to %s\n", certPath, keyPath)
+		return nil
+	},
+}
+
+var certMigrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Move a CA from the working directory to the configured location",
+	Long: `Earlier versions kept the CA in certs/ under the working directory. migrate moves
+it to the configured certificate paths, by default in the user's data directory, and
+restricts the key to certificate.key_perm.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+		perm, err := cfg.Certificate.KeyFileMode()
+		if err != nil {
+			return err
+		}
+
+		fromCert, _ := cmd.Flags().GetString("from-cert")
+		fromKey, _ := cmd.Flags().GetString("from-key")
+		certPath, keyPath := cfg.Certificate.CertPath, cfg.Certificate.KeyPath
+		if err := cert.Migrate(fromCert, fromKey, certPath, keyPath, perm); err != nil {
+			return err
+		}
+		fmt.Printf("Moved CA to %s and its key to %s\n", certPath, keyPath)
+		return nil
+	},
+}
+
+var certServeCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Host the CA on a page phones can install it from",
+	Long: `Serve a page with the CA certificate and instructions for installing it on
+Android and iOS, and print a QR code of its address to scan with the phone.
+The page offers the certificate for Android, a configuration profile for iOS
+and the certificate as PEM for everything else.
+
+The address uses the first LAN address of this machine unless --host names
+another; the phone must be able to reach it. Stop serving with Ctrl-C.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+		ca, err := caCertificate(cfg)
+		if err != nil {
+			return err
+		}
+
+		listen, _ := cmd.Flags().GetString("listen")
+		host, _ := cmd.Flags().GetString("host")
+		l, err := net.Listen("tcp", listen)
+		if err != nil {
+			return err
+		}
+		if host == "" {
+			host = lanAddress()
+		}
+		url := fmt.Sprintf("http://%s/", net.JoinHostPort(host, fmt.Sprint(l.Addr().(*net.TCPAddr).Port)))
+		code, err := qr.Encode(url)
+		if err != nil {
+			l.Close()
+			return err
+		}
+		out := cmd.OutOrStdout()
+		fmt.Fprintf(out, "Serving %s at %s\n", ca.Subject.CommonName, url)
+		code.WriteTerminal(out)
+
+		srv := &http.Server{Handler: &onboard.Handler{CA: ca}, ReadHeaderTimeout: 10 * time.Second}
+		ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		go func() {
+			<-ctx.Done()
+			shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			srv.Shutdown(shutdown)
+		}()
+		if err := srv.Serve(l); !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+		return nil
+	},
+}
+
+var certAndroidMagiskCmd = &cobra.Command{
+	Use:   "android-magisk",
+	Short: "Write a Magisk module adding the CA to Android's system store",
+	Long: `Write a Magisk module that installs the CA as a system certificate on a
+rooted Android device. Apps trust system CAs without opting in, unlike CAs a
+user installs. On Android 14 and later the module also mounts the CA into the
+Conscrypt APEX, where the system store moved.
+
+Install the zip from the Modules tab of the Magisk app and reboot.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+		ca, err := caCertificate(cfg)
+		if err != nil {
+			return err
+		}
+
+		out, _ := cmd.Flags().GetString("out")
+		f, err := os.Create(out)
+		if err != nil {
+			return err
+		}
+		if err := onboard.WriteMagisk(f, ca); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+		fmt.Printf("Wrote Magisk module for %s to %s\n", ca.Subject.CommonName, out)
+		return nil
+	},
+}
+
+// caCertificate returns the certificate devices must trust: the last one
+// in the CA file, which is the root when the file holds a chain.
+func caCertificate(cfg *config.Config) (*x509.Certificate, error) {
+	data, err := envPEM(caCertEnv)
+	if err != nil {
+		return nil, err
+	}
+	name := caCertEnv
+	if data == nil {
+		useLegacyCA(&cfg.Certificate)
+		name = cfg.Certificate.CertPath
+		if data, err = os.ReadFile(name); err != nil {
+			return nil, fmt.Errorf("reading the CA certificate (start the proxy once to generate it): %w", err)
+		}
+	}
+	var ca *x509.Certificate
+	for rest := data; ; {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			break
+		}
+		if block.Type == "CERTIFICATE" {
+			if ca, err = x509.ParseCertificate(block.Bytes); err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+		}
+	}
+	if ca == nil {
+		return nil, fmt.Errorf("no certificates in %s", name)
+	}
+	return ca, nil
+}
+
+// listenURL is the base URL of a server on addr, naming the LAN address
+// when it listens on all interfaces.
+func listenURL(addr net.Addr) string {
+	host, port, _ := net.SplitHostPort(addr.String())
+	if ip := net.ParseIP(host); ip == nil || ip.IsUnspecified() {
+		host = lanAddress()
+	}
+	return "http://" + net.JoinHostPort(host, port)
+}
+
+// lanAddress returns an IPv4 address of this machine that other devices on
+// its network can likely reach, or the loopback address.
+func lanAddress() string {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return "127.0.0.1"
+	}
+	for _, a := range addrs {
+		if n, ok := a.(*net.IPNet); ok && n.IP.To4() != nil && !n.IP.IsLoopback() && !n.IP.IsLinkLocalUnicast() {
+			return n.IP.String()
+		}
+	}
+	return "127.0.0.1"
+}
+
+// keyOptions describes how generated CA keys are stored.
+func keyOptions(c config.CertificateConfig) (cert.KeyOptions, error) {
+	var opts cert.KeyOptions
+	var err error
+	if opts.Perm, err = c.KeyFileMode(); err != nil {
+		return opts, err
+	}
+	if c.EncryptKey {
+		if opts.Passphrase, err = keyPassphrase(c)(); err != nil {
+			return opts, err
+		}
+	}
+	return opts, nil
+}
+
+// Environment variables holding a CA as PEM. With a _FILE suffix they name
+// files instead, such as mounted secrets.
+const (
+	caCertEnv = "ROGUE_CA_CERT"
+	caKeyEnv  = "ROGUE_CA_KEY"
+)
+
+// caPEM returns the CA to sign with when it does not come from the
+// configured paths: the one in the environment, the sidecar's secret in the
+// namespace of pod, or with certificate.stateless a new one that lasts as
+// long as the process. It returns nil for the configured paths.
+func caPEM(cfg *config.Config, pod *sidecar.Pod) (certPEM, keyPEM []byte, err error) {
+	if certPEM, err = envPEM(caCertEnv); err != nil {
+		return nil, nil, err
+	}
+	if keyPEM, err = envPEM(caKeyEnv); err != nil {
+		return nil, nil, err
+	}
+	if certPEM != nil || keyPEM != nil {
+		if certPEM == nil || keyPEM == nil {
+			return nil, nil, fmt.Errorf("set both %s and %s, or neither", caCertEnv, caKeyEnv)
+		}
+		return certPEM, keyPEM, nil
+	}
+	if pod != nil && cfg.Sidecar.CASecret != "" {
+		if certPEM, keyPEM, err = secretCA(pod.Namespace, cfg.Sidecar.CASecret); err != nil {
+			return nil, nil, fmt.Errorf("sidecar.ca_secret: %w", err)
+		}
+		return certPEM, keyPEM, nil
+	}
+	c := cfg.Certificate
+	if !c.Stateless {
+		return nil, nil, nil
+	}
+	if !c.AutoGenerate {
+		return nil, nil, fmt.Errorf("certificate.stateless: no CA in %s and %s and generation is disabled", caCertEnv, caKeyEnv)
+	}
+	if certPEM, keyPEM, err = cert.NewSelfSigned(c.Organization, c.CommonName, c.ValidDays); err != nil {
+		return nil, nil, err
+	}
+	fmt.Fprintf(os.Stderr, "Generated a CA for this run only; clients must trust:\n%s", certPEM)
+	return certPEM, keyPEM, nil
+}
+
+// envPEM reads the variable name, or the file named by name_FILE. It
+// returns nil if neither is set.
+func envPEM(name string) ([]byte, error) {
+	if v := os.Getenv(name); v != "" {
+		return []byte(v), nil
+	}
+	path := os.Getenv(name + "_FILE")
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s_FILE: %w", name, err)
+	}
+	return data, nil
+}
+
+// useLegacyCA falls back to a CA left in the working directory by earlier
+// versions when there is none at the default location.
+func useLegacyCA(c *config.CertificateConfig) {
+	def := config.DefaultConfig().Certificate
+	if c.CertPath != def.CertPath || c.KeyPath != def.KeyPath ||
+		cert.Exists(c.CertPath, c.KeyPath) || !cert.Exists(config.LegacyCertPath, config.LegacyKeyPath) {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Using the CA in %s; run `rogue cert migrate` to move it to %s\n",
+		filepath.Dir(config.LegacyCertPath), filepath.Dir(c.CertPath))
+	c.CertPath, c.KeyPath = config.LegacyCertPath, config.LegacyKeyPath
+}
+
+// warnKeyPerm reports a CA key that is accessible beyond perm.
+func warnKeyPerm(path string, perm os.FileMode) {
+	if runtime.GOOS == "windows" {
+		return
+	}
+	info, err := os.Stat(path)
+	if err == nil && info.Mode().Perm()&^perm != 0 {
+		fmt.Fprintf(os.Stderr, "Warning: %s has mode %04o; restrict it with chmod %04o %s\n", path, info.Mode().Perm(), perm, path)
+	}
+}
+
+func init() {
+	certCreateIntermediateCmd.Flags().String("root-cert", "", "PEM certificate of the root CA")
+	certCreateIntermediateCmd.Flags().String("root-key", "", "PEM private key of the root CA")
+	certCreateIntermediateCmd.Flags().String("common-name", "Rogue Intermediate CA", "Common name of the intermediate")
+	certCreateIntermediateCmd.Flags().Int("days", 0, "Validity in days (default certificate.valid_days)")
+	certCreateIntermediateCmd.Flags().Bool("force", false, "Overwrite an existing CA at the configured paths")
+	certCreateIntermediateCmd.MarkFlagRequired("root-cert")
+	certCreateIntermediateCmd.MarkFlagRequired("root-key")
+
+	certMigrateCmd.Flags().String("from-cert", config.LegacyCertPath, "Current CA certificate")
+	certMigrateCmd.Flags().String("from-key", config.LegacyKeyPath, "Current CA key")
+
+	certServeCmd.Flags().String("listen", ":8089", "Address to serve the page on")
+	certServeCmd.Flags().String("host", "", "Host name or address phones reach this machine at (default a LAN address)")
+
+	certAndroidMagiskCmd.Flags().StringP("out", "o", "rogue-ca-magisk.zip", "File to write the module to")
+
+	certCmd.AddCommand(certCreateIntermediateCmd, certMigrateCmd, certServeCmd, certAndroidMagiskCmd)
+}
@@ -0,0 +1,129 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/spf13/cobra"
+	"github.com/standrze/rogue/internal/config"
+	"github.com/standrze/rogue/internal/rules"
+)
+
+// Names of the files config export-running writes.
+const (
+	exportConfigFile = "config.json"
+	exportRulesFile  = "rules.yaml"
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect and export configuration",
+}
+
+var configExportRunningCmd = &cobra.Command{
+	Use:   "export-running",
+	Short: "Write the configuration and rules of the running proxy to files",
+	Long: `Snapshot the running proxy through its admin server: the configuration it
+was started with, after flags and environment variables were applied, and its
+rules, including those added through the admin API. They are written to
+config.json and rules.yaml in --dir, with rules.files pointing at the rules, so
+starting rogue in that directory sets up the same proxy again.
+
+Passphrases, tokens and header values are not served by the admin server and
+are written as REDACTED, except the admin token, which is taken from the local
+configuration. Relative paths are kept as the running proxy had them.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+
+		dir, _ := cmd.Flags().GetString("dir")
+		force, _ := cmd.Flags().GetBool("force")
+		configPath := filepath.Join(dir, exportConfigFile)
+		rulesPath := filepath.Join(dir, exportRulesFile)
+		if !force {
+			for _, p := range []string{configPath, rulesPath} {
+				if _, err := os.Stat(p); !errors.Is(err, fs.ErrNotExist) {
+					return fmt.Errorf("%s already exists; use --force to overwrite it", p)
+				}
+			}
+		}
+
+		var running config.Config
+		if err := adminJSON(cfg.Admin, "/config", &running); err != nil {
+			return err
+		}
+		var live struct {
+			Rules []rules.Rule `json:"rules"`
+		}
+		if err := adminJSON(cfg.Admin, "/rules", &live); err != nil {
+			return err
+		}
+
+		if running.Admin.Token == config.Redacted {
+			running.Admin.Token = cfg.Admin.Token
+		}
+		running.Rules.Files = []string{exportRulesFile}
+
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+		if err := rules.Save(rulesPath, live.Rules); err != nil {
+			return err
+		}
+		if err := running.Save(configPath); err != nil {
+			return err
+		}
+
+		out := cmd.OutOrStdout()
+		fmt.Fprintf(out, "Wrote %s and %s (%d rules)\n", configPath, rulesPath, len(live.Rules))
+		_, redacted := running.Redact()
+		redacted = slices.DeleteFunc(redacted, func(k string) bool { return k == "admin.token" })
+		if len(redacted) > 0 {
+			fmt.Fprintln(out, "Fill in the redacted settings before starting it:")
+			for _, k := range redacted {
+				fmt.Fprintf(out, "  %s\n", k)
+			}
+		}
+		return nil
+	},
+}
+
+// adminJSON decodes the response to a GET of path on the admin server into
+// v.
+func adminJSON(c config.AdminConfig, path string, v any) error {
+	res, err := adminRequest(c, http.MethodGet, path, nil)
+	if err != nil {
+		return fmt.Errorf("reaching the admin server (is admin.enabled set on the running proxy?): %w", err)
+	}
+	defer res.Body.Close()
+	if err := adminStatus(res, http.StatusOK); err != nil {
+		return err
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// configHandler serves cfg, with its secrets redacted, as JSON.
+func configHandler(cfg *config.Config) http.Handler {
+	redacted, _ := cfg.Redact()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(redacted)
+	})
+}
+
+func init() {
+	configExportRunningCmd.Flags().String("dir", "rogue-export", "Directory to write config.json and rules.yaml to")
+	configExportRunningCmd.Flags().Bool("force", false, "Overwrite files left by an earlier export")
+	configCmd.AddCommand(configExportRunningCmd)
+}
@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/spf13/cobra"
+	"github.com/standrze/rogue/internal/config"
+	"github.com/standrze/rogue/internal/cookies"
+	"github.com/standrze/rogue/internal/logger"
+)
+
+var cookiesCmd = &cobra.Command{
+	Use:   "cookies [session]",
+	Short: "List the cookies set and sent through the proxy",
+	Long: `List the cookies in the cookie jar of the running proxy, fetched from its admin
+server, or with a session argument the cookies seen in that recorded session.
+Sessions log only the first value of each header, so a response setting several
+cookies contributes only its first.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+
+		host, _ := cmd.Flags().GetString("host")
+		name, _ := cmd.Flags().GetString("name")
+		filter := cookies.Filter{Host: host, Name: name}
+
+		var cs []cookies.Cookie
+		if len(args) == 1 {
+			cs, err = sessionCookies(cfg, args[0], filter)
+		} else {
+			cs, err = liveCookies(cfg.Admin, filter)
+		}
+		if err != nil {
+			return err
+		}
+
+		if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+			enc := json.NewEncoder(cmd.OutOrStdout())
+			enc.SetIndent("", "  ")
+			return enc.Encode(cs)
+		}
+		cookies.Write(cmd.OutOrStdout(), cs)
+		return nil
+	},
+}
+
+// sessionCookies fills a jar from the flows of a recorded session.
+func sessionCookies(cfg *config.Config, session string, filter cookies.Filter) ([]cookies.Cookie, error) {
+	path, err := resolveSession(cfg, session)
+	if err != nil {
+		return nil, err
+	}
+	flows, err := logger.LoadFlows(path)
+	if err != nil {
+		return nil, err
+	}
+
+	jar := cookies.NewJar()
+	for _, f := range flows {
+		if f.Request == nil {
+			continue
+		}
+		u, err := url.Parse(f.Request.URL)
+		if err != nil {
+			continue
+		}
+		jar.ObserveRequest(u.Hostname(), flowHeader(f.Request.Headers), f.Request.Timestamp)
+		if f.Response != nil {
+			jar.ObserveResponse(u, flowHeader(f.Response.Headers), f.Response.Timestamp)
+		}
+	}
+	return jar.Cookies(filter), nil
+}
+
+func flowHeader(m map[string]string) http.Header {
+	h := make(http.Header, len(m))
+	for k, v := range m {
+		h.Set(k, v)
+	}
+	return h
+}
+
+// liveCookies fetches the jar of the proxy whose admin server is c.
+func liveCookies(c config.AdminConfig, filter cookies.Filter) ([]cookies.Cookie, error) {
+	if !c.Enabled {
+		return nil, fmt.Errorf("the admin server is disabled; set admin.enabled or pass a session")
+	}
+	q := url.Values{}
+	if filter.Host != "" {
+		q.Set("host", filter.Host)
+	}
+	if filter.Name != "" {
+		q.Set("name", filter.Name)
+	}
+	res, err := adminRequest(c, http.MethodGet, "/cookies?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if err := adminStatus(res, http.StatusOK); err != nil {
+		return nil, err
+	}
+	var cs []cookies.Cookie
+	return cs, json.NewDecoder(res.Body).Decode(&cs)
+}
+
+func init() {
+	cookiesCmd.Flags().String("host", "", "Only cookies sent to this host")
+	cookiesCmd.Flags().String("name", "", "Only cookies whose name matches this glob")
+	cookiesCmd.Flags().Bool("json", false, "Print the cookies as JSON")
+}
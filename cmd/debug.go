@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/standrze/rogue/internal/config"
+)
+
+var debugCmd = &cobra.Command{
+	Use:   "debug",
+	Short: "Troubleshoot a running proxy",
+}
+
+// debugProfiles are fetched by debug dump: the admin path and the file
+// each is written to.
+var debugProfiles = []struct{ path, file string }{
+	{"/debug/pprof/goroutine?debug=2", "goroutines.txt"},
+	{"/debug/pprof/goroutine", "goroutine.pprof"},
+	{"/debug/pprof/heap", "heap.pprof"},
+	{"/debug/pprof/allocs", "allocs.pprof"},
+	{"/debug/pprof/threadcreate", "threadcreate.pprof"},
+	{"/debug/vars", "vars.json"},
+}
+
+var debugDumpCmd = &cobra.Command{
+	Use:   "dump",
+	Short: "Capture goroutine and heap profiles from the running proxy",
+	Long: `Fetch runtime profiles from the running proxy's admin server into a
+directory, for troubleshooting hangs and leaks: the stacks of all goroutines
+as text and as a profile, the heap and allocation profiles, thread creation
+and the expvar variables, which include memory statistics. --cpu adds a CPU
+profile taken over that long.
+
+The proxy must run with admin.enabled and admin.debug. Profiles are read
+with go tool pprof, as in go tool pprof -top heap.pprof.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+		dir, _ := cmd.Flags().GetString("out")
+		if dir == "" {
+			dir = "rogue-debug-" + time.Now().Format("20060102-150405")
+		}
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+
+		for _, p := range debugProfiles {
+			if err := fetchProfile(cfg.Admin, p.path, filepath.Join(dir, p.file), 30*time.Second); err != nil {
+				return err
+			}
+		}
+		if d, _ := cmd.Flags().GetDuration("cpu"); d > 0 {
+			fmt.Fprintf(cmd.ErrOrStderr(), "Profiling the CPU for %s\n", d)
+			path := fmt.Sprintf("/debug/pprof/profile?seconds=%d", max(int(d.Seconds()), 1))
+			if err := fetchProfile(cfg.Admin, path, filepath.Join(dir, "cpu.pprof"), d+30*time.Second); err != nil {
+				return err
+			}
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Wrote profiles to %s\n", dir)
+		return nil
+	},
+}
+
+// fetchProfile writes the admin server's response to path to file.
+func fetchProfile(c config.AdminConfig, path, file string, timeout time.Duration) error {
+	res, err := adminRequestTimeout(c, http.MethodGet, path, nil, timeout)
+	if err != nil {
+		return fmt.Errorf("reaching the admin server (are admin.enabled and admin.debug set on the running proxy?): %w", err)
+	}
+	defer res.Body.Close()
+	if err := adminStatus(res, http.StatusOK); err != nil {
+		return err
+	}
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, res.Body); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func init() {
+	debugDumpCmd.Flags().String("out", "", "Directory to write the profiles to (default rogue-debug-<time>)")
+	debugDumpCmd.Flags().Duration("cpu", 0, "Also take a CPU profile over this long")
+	debugCmd.AddCommand(debugDumpCmd)
+}
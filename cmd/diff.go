@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/standrze/rogue/internal/diff"
+	"github.com/standrze/rogue/internal/logger"
+)
+
+var diffCmd = &cobra.Command{
+	Use:   "diff <session-a> <session-b>",
+	Short: "Compare the responses recorded in two sessions",
+	Long: `Match the requests of two sessions by method and URL and report the ones whose
+responses differ in status, headers or body, and the ones found in only one
+session. JSON bodies are compared field by field; other bodies line by line.
+Repeated requests are paired in the order they were made.`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+
+		var flows [2][]*logger.Flow
+		for i, name := range args {
+			path, err := resolveSession(cfg, name)
+			if err != nil {
+				return err
+			}
+			if flows[i], err = logger.LoadFlows(path); err != nil {
+				return err
+			}
+		}
+
+		ignore, _ := cmd.Flags().GetStringSlice("ignore-header")
+		r := diff.Compare(flows[0], flows[1], diff.Options{IgnoreHeaders: ignore})
+
+		if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(r); err != nil {
+				return err
+			}
+		} else {
+			diff.Write(os.Stdout, r)
+		}
+
+		if exit, _ := cmd.Flags().GetBool("exit-code"); exit && !r.Empty() {
+			os.Exit(1)
+		}
+		return nil
+	},
+}
+
+func init() {
+	diffCmd.Flags().StringSlice("ignore-header", diff.DefaultIgnoredHeaders, "Response headers left out of the comparison")
+	diffCmd.Flags().Bool("json", false, "Print the differences as JSON")
+	diffCmd.Flags().Bool("exit-code", false, "Exit with status 1 when the sessions differ")
+}
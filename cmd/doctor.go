@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/standrze/rogue/internal/display"
+	"github.com/standrze/rogue/internal/doctor"
+)
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Run an end-to-end self-test of interception and logging",
+	Long: `Start a throwaway proxy with the configured CA and send HTTP and HTTPS requests
+through it to local origins. doctor checks the CA and the clock, that intercepted
+certificates chain to the CA, and that exchanges are logged, then prints a summary.
+Nothing is written to the configured session directory.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+		policy, err := tlsPolicy(cfg.TLS)
+		if err != nil {
+			return err
+		}
+		useLegacyCA(&cfg.Certificate)
+		f, err := display.New(cfg.Display.Timezone, cfg.Display.Locale)
+		if err != nil {
+			return err
+		}
+
+		r := doctor.Run(doctor.Options{
+			CertPath:   cfg.Certificate.CertPath,
+			KeyPath:    cfg.Certificate.KeyPath,
+			Passphrase: keyPassphrase(cfg.Certificate),
+			TLSPolicy:  policy,
+			Formatter:  f,
+		})
+		for _, c := range r.Checks {
+			fmt.Printf("%-4s  %-28s %s\n", c.Status, c.Name, c.Detail)
+		}
+		if r.Failed() {
+			return errors.New("self-test failed")
+		}
+		return nil
+	},
+}
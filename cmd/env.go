@@ -0,0 +1,199 @@
+package cmd
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/spf13/cobra"
+	"github.com/standrze/rogue/internal/config"
+	"github.com/standrze/rogue/internal/env"
+)
+
+// envVar selects an environment like --env.
+const envVar = "ROGUE_ENV"
+
+var envCmd = &cobra.Command{
+	Use:   "env",
+	Short: "Manage environments of variables for rules and replayed requests",
+	Long: `Environments are named sets of variables, such as "staging" and "prod", kept in
+environments.file. Rules and mocks, request files given to rogue send and
+requests sent from the repeater refer to them as {{name}}, so the same rules
+and captured workflows can be pointed at another target by switching
+environments.
+
+The environment used is the one named by --env, then ROGUE_ENV, then
+environments.active, and otherwise the one chosen with rogue env use.
+Without one, {{name}} is left as written.`,
+}
+
+var envListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the environments, marking the one in use",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, f, err := loadEnvironments()
+		if err != nil {
+			return err
+		}
+		active, _, err := f.Select(cfg.Environments.Active)
+		if err != nil {
+			return err
+		}
+		if len(f.Environments) == 0 {
+			fmt.Fprintf(cmd.OutOrStdout(), "No environments in %s\n", cfg.Environments.File)
+			return nil
+		}
+		for _, name := range f.Names() {
+			mark := " "
+			if name == active {
+				mark = "*"
+			}
+			n := len(f.Environments[name])
+			noun := "variables"
+			if n == 1 {
+				noun = "variable"
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "%s %s (%d %s)\n", mark, name, n, noun)
+		}
+		return nil
+	},
+}
+
+var envShowCmd = &cobra.Command{
+	Use:   "show [environment]",
+	Short: "Print the variables of an environment, by default the one in use",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, f, err := loadEnvironments()
+		if err != nil {
+			return err
+		}
+		name, vars, err := f.Select(cmp.Or(append(args, cfg.Environments.Active)...))
+		if err != nil {
+			return err
+		}
+		if name == "" {
+			return fmt.Errorf("no environment is in use; name one or choose it with rogue env use")
+		}
+		for _, k := range slices.Sorted(maps.Keys(vars)) {
+			fmt.Fprintf(cmd.OutOrStdout(), "%s=%s\n", k, vars[k])
+		}
+		return nil
+	},
+}
+
+var envSetCmd = &cobra.Command{
+	Use:   "set <environment> <name=value>...",
+	Short: "Set variables, creating the environment if needed",
+	Args:  cobra.MinimumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if !env.ValidName(args[0]) {
+			return fmt.Errorf("invalid environment name %q", args[0])
+		}
+		vars, err := env.ParseAssignments(args[1:])
+		if err != nil {
+			return err
+		}
+		return updateEnvironments(func(f *env.File) error {
+			f.Environments[args[0]] = f.Environments[args[0]].With(vars)
+			return nil
+		})
+	},
+}
+
+var envUnsetCmd = &cobra.Command{
+	Use:   "unset <environment> <name>...",
+	Short: "Remove variables from an environment",
+	Args:  cobra.MinimumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return updateEnvironments(func(f *env.File) error {
+			vars, ok := f.Environments[args[0]]
+			if !ok {
+				return fmt.Errorf("no environment %q", args[0])
+			}
+			for _, name := range args[1:] {
+				delete(vars, name)
+			}
+			return nil
+		})
+	},
+}
+
+var envDeleteCmd = &cobra.Command{
+	Use:   "delete <environment>",
+	Short: "Remove an environment",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return updateEnvironments(func(f *env.File) error {
+			if _, ok := f.Environments[args[0]]; !ok {
+				return fmt.Errorf("no environment %q", args[0])
+			}
+			delete(f.Environments, args[0])
+			if f.Active == args[0] {
+				f.Active = ""
+			}
+			return nil
+		})
+	},
+}
+
+var envUseCmd = &cobra.Command{
+	Use:   "use [environment]",
+	Short: "Choose the environment to use, or none without an argument",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return updateEnvironments(func(f *env.File) error {
+			f.Active = ""
+			if len(args) == 0 {
+				return nil
+			}
+			if _, _, err := f.Select(args[0]); err != nil {
+				return err
+			}
+			f.Active = args[0]
+			return nil
+		})
+	},
+}
+
+// loadEnvironments reads environments.file.
+func loadEnvironments() (*config.Config, *env.File, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+	f, err := env.Load(cfg.Environments.File)
+	if err != nil {
+		return nil, nil, fmt.Errorf("environments: %w", err)
+	}
+	return cfg, f, nil
+}
+
+// updateEnvironments applies edit to environments.file and saves it.
+func updateEnvironments(edit func(*env.File) error) error {
+	cfg, f, err := loadEnvironments()
+	if err != nil {
+		return err
+	}
+	if err := edit(f); err != nil {
+		return err
+	}
+	return f.Save(cfg.Environments.File)
+}
+
+// environment returns the name and variables of the environment name or,
+// when it is empty, of the one selected as rogue env describes. It returns
+// no variables when none is selected.
+func environment(cfg *config.Config, name string) (string, env.Vars, error) {
+	f, err := env.Load(cfg.Environments.File)
+	if err != nil {
+		return "", nil, fmt.Errorf("environments: %w", err)
+	}
+	return f.Select(cmp.Or(name, cfg.Environments.Active))
+}
+
+func init() {
+	envCmd.AddCommand(envListCmd, envShowCmd, envSetCmd, envUnsetCmd, envDeleteCmd, envUseCmd)
+}
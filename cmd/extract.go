@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/spf13/cobra"
+	"github.com/standrze/rogue/internal/extract"
+	"github.com/standrze/rogue/internal/logger"
+	"github.com/standrze/rogue/internal/viewer"
+)
+
+const extractLong = `Write the bodies of successful responses in a session to --out, each named by
+its SHA-256 with an extension for its type (or with --names url after the URL
+it came from), and record in manifest.json the URLs and flows each came from.
+Identical bodies are written once, and running the command again adds to the
+same directory. Ranged downloads whose parts were all recorded are written as
+the whole file. Bodies are only as complete as logging.max_body_size allowed;
+truncated ones are skipped unless --include-truncated is given.
+
+--filter selects flows as in rogue tail: method=GET, text to search for, or
+where=<expression>.`
+
+var extractCmd = &cobra.Command{
+	Use:   "extract <session>",
+	Short: "Write response bodies out as files",
+	Long:  extractLong,
+	Args:  cobra.ExactArgs(1),
+	RunE:  extractBodies,
+}
+
+var sessionsExtractCmd = &cobra.Command{
+	Use:   "extract <session>",
+	Short: "Write response bodies out as files",
+	Long:  extractLong,
+	Args:  cobra.ExactArgs(1),
+	RunE:  extractBodies,
+}
+
+// extractBodies runs extract and sessions extract.
+func extractBodies(cmd *cobra.Command, args []string) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	path, err := resolveSession(cfg, args[0])
+	if err != nil {
+		return err
+	}
+	flows, err := logger.LoadFlows(path)
+	if err != nil {
+		return err
+	}
+	if terms, _ := cmd.Flags().GetStringArray("filter"); len(terms) > 0 {
+		flt, err := viewer.ParseFilter(terms, cfg.Filters)
+		if err != nil {
+			return err
+		}
+		flows = slices.DeleteFunc(flows, func(f *logger.Flow) bool { return !flt.Match(f) })
+	}
+
+	names, _ := cmd.Flags().GetString("names")
+	naming, err := extract.ParseNaming(names)
+	if err != nil {
+		return err
+	}
+	out, _ := cmd.Flags().GetString("out")
+	var f extract.Filter
+	f.ContentTypes, _ = cmd.Flags().GetStringArray("content-type")
+	f.Host, _ = cmd.Flags().GetString("host")
+	f.MinSize, _ = cmd.Flags().GetInt64("min-size")
+	f.Truncated, _ = cmd.Flags().GetBool("include-truncated")
+
+	res, err := extract.Extract(flows, out, f, naming)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "%d bodies matched, %d new files in %s\n", res.Matched, res.New, out)
+	if res.Skipped > 0 {
+		fmt.Fprintf(cmd.OutOrStdout(), "%d skipped: truncated, not recorded or incomplete downloads\n", res.Skipped)
+	}
+	return nil
+}
+
+// addExtractFlags adds the flags read by extractBodies.
+func addExtractFlags(c *cobra.Command) {
+	c.Flags().StringP("out", "o", "artifacts", "Directory to write files and the manifest to")
+	c.Flags().StringArray("content-type", nil, "Only extract these media types; globs such as image/* are allowed (repeatable)")
+	c.Flags().String("host", "", "Only extract responses from hosts matching this glob")
+	c.Flags().Int64("min-size", 0, "Skip bodies smaller than this many bytes")
+	c.Flags().Bool("include-truncated", false, "Also write bodies cut short by logging.max_body_size")
+	c.Flags().StringArray("filter", nil, "Only extract matching flows: method=M, where=<expression>, or text to search for")
+	c.Flags().String("names", "hash", "Name files by content hash or after their URL (hash or url)")
+}
+
+func init() {
+	addExtractFlags(extractCmd)
+}
@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/standrze/rogue/internal/findings"
+	"github.com/standrze/rogue/internal/logger"
+)
+
+var findingsCmd = &cobra.Command{
+	Use:   "findings [session]",
+	Short: "Report the security issues found passively in a session",
+	Long: `Report the issues the proxy's passive checks recorded in a session (default:
+the latest), most severe first. The checks are:
+
+  security-headers      HTML pages without CSP, X-Frame-Options, nosniff or HSTS
+  insecure-cookie       cookies set without Secure (over HTTPS) or HttpOnly
+  basic-auth-plaintext  Basic credentials sent over plain HTTP
+  jwt-alg-none          JWTs whose header names the "none" algorithm
+  verbose-error         error responses with stack traces or database errors
+  mixed-content         HTTPS pages loading resources over plain HTTP
+
+Each issue is reported once per host while the proxy runs. Turn checks off
+with findings.disable, or all of them with findings.enabled.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+		name := "latest"
+		if len(args) == 1 {
+			name = args[0]
+		}
+		p, err := resolveSession(cfg, name)
+		if err != nil {
+			return err
+		}
+		entries, err := logger.ReadSession(p)
+		if err != nil {
+			return err
+		}
+		found, err := findings.FromEntries(entries)
+		if err != nil {
+			return err
+		}
+
+		severity, _ := cmd.Flags().GetString("severity")
+		switch severity {
+		case findings.High, findings.Medium, findings.Low:
+			found = findings.AtLeast(found, severity)
+		default:
+			return fmt.Errorf("invalid --severity %q (want high, medium or low)", severity)
+		}
+		if checks, _ := cmd.Flags().GetStringSlice("check"); len(checks) > 0 {
+			for _, c := range checks {
+				if !slices.Contains(findings.Checks, c) {
+					return fmt.Errorf("unknown check %q (have %s)", c, strings.Join(findings.Checks, ", "))
+				}
+			}
+			found = slices.DeleteFunc(found, func(f findings.Finding) bool { return !slices.Contains(checks, f.Check) })
+		}
+		if host, _ := cmd.Flags().GetString("host"); host != "" {
+			if _, err := path.Match(host, ""); err != nil {
+				return fmt.Errorf("invalid --host pattern %q", host)
+			}
+			found = slices.DeleteFunc(found, func(f findings.Finding) bool {
+				ok, _ := path.Match(host, f.Host())
+				return !ok
+			})
+		}
+
+		if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+			findings.Sort(found)
+			if found == nil {
+				found = []findings.Finding{}
+			}
+			enc := json.NewEncoder(cmd.OutOrStdout())
+			enc.SetIndent("", "  ")
+			return enc.Encode(found)
+		}
+		findings.Write(cmd.OutOrStdout(), found)
+		return nil
+	},
+}
+
+func init() {
+	findingsCmd.Flags().String("severity", findings.Low, "Only report findings at least this severe: high, medium or low")
+	findingsCmd.Flags().StringSlice("check", nil, "Only report these checks (comma-separated or repeated)")
+	findingsCmd.Flags().String("host", "", "Only report findings for hosts matching this glob")
+	findingsCmd.Flags().Bool("json", false, "Print the findings as JSON")
+}
@@ -0,0 +1,206 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/standrze/rogue/internal/config"
+	"github.com/standrze/rogue/internal/fuzz"
+	"github.com/standrze/rogue/internal/logger"
+	"github.com/standrze/rogue/internal/proxy"
+	"github.com/standrze/rogue/internal/rawhttp"
+	"github.com/standrze/rogue/internal/repeater"
+	"github.com/standrze/rogue/internal/viewer"
+)
+
+var fuzzCmd = &cobra.Command{
+	Use:   "fuzz --request-id <id> --position <kind:name> (--wordlist <file> | --generate <spec>)",
+	Short: "Send variants of a captured request and report anomalous responses",
+	Long: `Replace positions of a captured request with payloads, send each variant
+through the running proxy and report the responses whose status or size differ
+from those of the request as captured.
+
+Positions are header:NAME, query:NAME or json:PATH, a dotted path into a JSON
+body such as user.id or items.0.name. Each position is fuzzed in turn with
+every payload while the others keep their captured values.
+
+Payloads are the lines of --wordlist files ("-" for standard input) and the
+values of --generate generators:
+
+  range:FROM-TO[:STEP]  integers from FROM to TO
+  repeat:TEXT:MAX       TEXT repeated 1, 2, 4, ... up to MAX times
+  special               probes for injection and parsing flaws
+
+A response is anomalous when the request failed, its status differs from the
+baseline's, or its size differs by more than the payload's length plus
+--length-delta bytes. Only anomalous responses are printed unless --all is
+given. Variants are recorded in the proxy's session, tagged fuzz and
+fuzz:<request-id>.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+
+		id, _ := cmd.Flags().GetString("request-id")
+		if id == "" {
+			return fmt.Errorf("--request-id is required")
+		}
+		var positions []fuzz.Position
+		specs, _ := cmd.Flags().GetStringArray("position")
+		for _, s := range specs {
+			p, err := fuzz.ParsePosition(s)
+			if err != nil {
+				return err
+			}
+			positions = append(positions, p)
+		}
+		if len(positions) == 0 {
+			return fmt.Errorf("give at least one --position")
+		}
+		payloads, err := fuzzPayloads(cmd)
+		if err != nil {
+			return err
+		}
+
+		session, _ := cmd.Flags().GetString("session")
+		flow, err := findFlow(cfg, session, id)
+		if err != nil {
+			return err
+		}
+		req, err := repeater.Request(flow)
+		if err != nil {
+			return fmt.Errorf("flow %s: %w", id, err)
+		}
+
+		rn := &fuzz.Runner{}
+		rn.Concurrency, _ = cmd.Flags().GetInt("concurrency")
+		rn.LengthDelta, _ = cmd.Flags().GetInt64("length-delta")
+		if target, _ := cmd.Flags().GetString("target"); target != "" {
+			u, err := url.Parse(target)
+			if err != nil || u.Host == "" {
+				return fmt.Errorf("invalid --target %q (want scheme://host[:port])", target)
+			}
+			rn.Options.Scheme, rn.Options.Host = u.Scheme, u.Host
+		}
+		rn.Options.Insecure, _ = cmd.Flags().GetBool("insecure")
+		if direct, _ := cmd.Flags().GetBool("direct"); !direct {
+			if rn.Options.Proxy, err = proxyAddress(cmd, cfg.Proxy); err != nil {
+				return err
+			}
+			useLegacyCA(&cfg.Certificate)
+			if rn.Options.RootCAs, err = proxyRoots(cfg.Certificate.CertPath); err != nil {
+				return err
+			}
+			tags, _ := cmd.Flags().GetStringArray("tag")
+			tags = append([]string{"fuzz", "fuzz:" + id}, tags...)
+			rn.Prepare = func(r *rawhttp.Request) { r.Set(proxy.TagHeader, strings.Join(tags, ",")) }
+		}
+
+		out := cmd.OutOrStdout()
+		asJSON, _ := cmd.Flags().GetBool("json")
+		all, _ := cmd.Flags().GetBool("all")
+		var anomalies int
+		report := func(res fuzz.Result) {
+			if res.Anomalous {
+				anomalies++
+			}
+			if asJSON || !all && !res.Anomalous && res.Position != "" {
+				return
+			}
+			fuzz.WriteResult(out, res)
+		}
+		fmt.Fprintf(cmd.ErrOrStderr(), "Sending %d variants of %s %s\n", len(positions)*len(payloads), flow.Request.Method, flow.Request.URL)
+		base, results, err := rn.Run(cmd.Context(), req, positions, payloads, report)
+		if err != nil {
+			return err
+		}
+		if asJSON {
+			enc := json.NewEncoder(out)
+			enc.SetIndent("", "  ")
+			return enc.Encode(map[string]any{"baseline": base, "results": results})
+		}
+		fmt.Fprintf(cmd.ErrOrStderr(), "%d of %d responses anomalous\n", anomalies, len(results))
+		return nil
+	},
+}
+
+// fuzzPayloads gathers the payloads of --wordlist and --generate.
+func fuzzPayloads(cmd *cobra.Command) ([]string, error) {
+	var payloads []string
+	lists, _ := cmd.Flags().GetStringArray("wordlist")
+	for _, name := range lists {
+		words, err := fuzz.ReadWordlist(name)
+		if err != nil {
+			return nil, err
+		}
+		payloads = append(payloads, words...)
+	}
+	gens, _ := cmd.Flags().GetStringArray("generate")
+	for _, spec := range gens {
+		values, err := fuzz.Generate(spec)
+		if err != nil {
+			return nil, err
+		}
+		payloads = append(payloads, values...)
+	}
+	if len(payloads) == 0 {
+		return nil, fmt.Errorf("give a --wordlist or --generate with at least one payload")
+	}
+	if len(payloads) > fuzz.MaxPayloads {
+		return nil, fmt.Errorf("%d payloads is more than the limit of %d", len(payloads), fuzz.MaxPayloads)
+	}
+	return payloads, nil
+}
+
+// findFlow loads flow id from session, or when session is empty from the
+// newest session that has it.
+func findFlow(cfg *config.Config, session, id string) (*logger.Flow, error) {
+	var flow *logger.Flow
+	if session == "" {
+		f, _, err := viewer.NewArchive(cfg.Logging.SessionDir).Find(id)
+		if err != nil {
+			return nil, err
+		}
+		flow = f
+	} else {
+		path, err := resolveSession(cfg, session)
+		if err != nil {
+			return nil, err
+		}
+		flows, err := logger.LoadFlows(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range flows {
+			if f.ID == id {
+				flow = f
+			}
+		}
+	}
+	if flow == nil || flow.Request == nil {
+		return nil, fmt.Errorf("no request %s in the sessions of %s", id, cfg.Logging.SessionDir)
+	}
+	return flow, nil
+}
+
+func init() {
+	fuzzCmd.Flags().String("request-id", "", "ID of the captured request to fuzz")
+	fuzzCmd.Flags().String("session", "", "Session holding the request (default: search every session, newest first)")
+	fuzzCmd.Flags().StringArrayP("position", "p", nil, "Position to fuzz: header:NAME, query:NAME or json:PATH (repeatable)")
+	fuzzCmd.Flags().StringArrayP("wordlist", "w", nil, "File of payloads, one per line (repeatable)")
+	fuzzCmd.Flags().StringArrayP("generate", "g", nil, "Payload generator: range:FROM-TO[:STEP], repeat:TEXT:MAX or special (repeatable)")
+	fuzzCmd.Flags().IntP("concurrency", "c", 4, "Variants to send at once")
+	fuzzCmd.Flags().Int64("length-delta", 0, "Bytes a response may differ in size, beyond the payload's length, without being anomalous")
+	fuzzCmd.Flags().Bool("all", false, "Print every response, not only anomalous ones")
+	fuzzCmd.Flags().Bool("json", false, "Print the baseline and every result as JSON")
+	fuzzCmd.Flags().StringArray("tag", nil, "Extra tag for the recorded variants (repeatable)")
+	fuzzCmd.Flags().String("target", "", "Origin to send to, as scheme://host[:port], instead of the request's own")
+	fuzzCmd.Flags().String("proxy", "", "Proxy address to send through (default: the configured proxy)")
+	fuzzCmd.Flags().Bool("direct", false, "Send straight to the origin instead of through the proxy")
+	fuzzCmd.Flags().BoolP("insecure", "k", false, "Do not verify TLS certificates")
+}
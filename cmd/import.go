@@ -0,0 +1,164 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/standrze/rogue/internal/codec"
+	"github.com/standrze/rogue/internal/doh"
+	"github.com/standrze/rogue/internal/gitproto"
+	"github.com/standrze/rogue/internal/graphql"
+	"github.com/standrze/rogue/internal/grpcdecode"
+	"github.com/standrze/rogue/internal/importer"
+	"github.com/standrze/rogue/internal/jwt"
+	"github.com/standrze/rogue/internal/logger"
+	"github.com/standrze/rogue/internal/registry"
+	"github.com/standrze/rogue/internal/s3"
+	"github.com/standrze/rogue/internal/scope"
+)
+
+var importCmd = &cobra.Command{
+	Use:   "import <file>",
+	Short: "Convert a HAR file or packet capture into a session",
+	Long: `Read the exchanges of a HAR file or a pcap/pcapng packet capture and write
+them to a new session in logging.session_dir, so they can be viewed, queried,
+diffed, replayed and exported like a recorded session. --format is taken from
+the file extension when not given.
+
+Packet captures are imported as far as they carry HTTP/1.x: plaintext
+connections, and TLS connections whose secrets are in the --tls-keys key log
+(the file SSLKEYLOGFILE names) or embedded in a pcapng file. HTTP/2 and
+connections without keys are counted and skipped.
+
+Traffic outside the configured scope is left out, and the logging settings
+for headers and bodies apply as when recording.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+		format, _ := cmd.Flags().GetString("format")
+		if format == "" {
+			format = captureFormat(args[0])
+		}
+		inScope, err := scope.New(cfg.Scope.Include, cfg.Scope.Exclude)
+		if err != nil {
+			return fmt.Errorf("scope: %w", err)
+		}
+		protoSchema, protoTypes, err := protobufSchema(cfg.Protobuf)
+		if err != nil {
+			return err
+		}
+
+		f, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		var exchanges []importer.Exchange
+		var rep importer.Report
+		switch format {
+		case "har":
+			exchanges, err = importer.ReadHAR(f)
+		case "pcap":
+			var keys *importer.KeyLog
+			if path, _ := cmd.Flags().GetString("tls-keys"); path != "" {
+				if keys, err = readKeyLog(path); err != nil {
+					return err
+				}
+			}
+			exchanges, rep, err = importer.ReadPcap(f, keys)
+		case "":
+			return fmt.Errorf("cannot tell the format of %s; give --format har or pcap", args[0])
+		default:
+			return fmt.Errorf("unknown format %q (want har or pcap)", format)
+		}
+		if err != nil {
+			return err
+		}
+
+		total := len(exchanges)
+		exchanges = slices.DeleteFunc(exchanges, func(ex importer.Exchange) bool {
+			return !inScope.Contains(ex.Request.URL)
+		})
+
+		sl, err := logger.NewSessionLogger(cfg.Logging.SessionDir, cfg.Logging.LogHeaders, cfg.Logging.LogBody, cfg.Logging.MaxBodySize)
+		if err != nil {
+			return err
+		}
+		// Bodies are decoded as when recording, except that gRPC servers
+		// are not asked for their descriptors.
+		sl.SetBodyDecoder(logger.DecoderChain{
+			&grpcdecode.Decoder{Schema: protoSchema},
+			&grpcdecode.ProtobufDecoder{Schema: protoSchema, Types: protoTypes},
+			gitproto.Decoder{},
+			registry.ManifestDecoder{},
+			doh.Decoder{},
+			codec.BodyDecoder{},
+		})
+		sl.SetProtocolDetector(logger.DetectorChain{
+			registry.Detector{LogBlobs: cfg.Logging.LogRegistryBlobs},
+			s3.Detector{},
+			doh.Detector{},
+		})
+		sl.SetJWTDecoder(jwt.Decoder{})
+		sl.SetGraphQLParser(graphql.Parser{})
+		if cfg.Logging.RedactS3Signatures {
+			sl.SetRedactor(s3.Redactor{})
+		}
+		err = importer.Write(sl, exchanges)
+		if cerr := sl.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+
+		out := cmd.OutOrStdout()
+		fmt.Fprintf(out, "Imported %d exchanges into %s\n", len(exchanges), sl.GetSessionName())
+		if n := total - len(exchanges); n > 0 {
+			fmt.Fprintf(out, "%d out of scope\n", n)
+		}
+		if rep.Encrypted > 0 {
+			fmt.Fprintf(out, "%d TLS connections skipped without keys\n", rep.Encrypted)
+		}
+		if rep.HTTP2 > 0 {
+			fmt.Fprintf(out, "%d HTTP/2 connections skipped\n", rep.HTTP2)
+		}
+		if rep.Other > 0 {
+			fmt.Fprintf(out, "%d connections skipped that were not HTTP\n", rep.Other)
+		}
+		return nil
+	},
+}
+
+// captureFormat guesses the format of a capture from its file extension.
+func captureFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".har":
+		return "har"
+	case ".pcap", ".pcapng", ".cap":
+		return "pcap"
+	}
+	return ""
+}
+
+func readKeyLog(path string) (*importer.KeyLog, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return importer.ReadKeyLog(f)
+}
+
+func init() {
+	importCmd.Flags().String("format", "", "Format of the file: har or pcap (default from the extension)")
+	importCmd.Flags().String("tls-keys", "", "Key log (SSLKEYLOGFILE) to decrypt TLS connections in a packet capture")
+}
@@ -0,0 +1,139 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/standrze/rogue/internal/jwt"
+)
+
+var jwtCmd = &cobra.Command{
+	Use:   "jwt",
+	Short: "Decode JWTs and re-sign edited ones",
+}
+
+var jwtDecodeCmd = &cobra.Command{
+	Use:   "decode <token|->",
+	Short: "Print the header and claims of a JWT",
+	Long: `Print the header and claims of a JWT, given as an argument or, with -, on stdin.
+The signature is not verified. Timestamps in exp, nbf and iat are shown as times.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		t, err := readToken(args[0])
+		if err != nil {
+			return err
+		}
+		out := cmd.OutOrStdout()
+		for _, part := range []struct {
+			name string
+			data json.RawMessage
+		}{{"header", t.Header}, {"claims", t.Claims}} {
+			var buf bytes.Buffer
+			json.Indent(&buf, part.data, "", "  ")
+			fmt.Fprintf(out, "%s: %s\n", part.name, buf.Bytes())
+		}
+		var claims map[string]any
+		json.Unmarshal(t.Claims, &claims)
+		for _, name := range []string{"iat", "nbf", "exp"} {
+			if n, ok := claims[name].(float64); ok {
+				at := time.Unix(int64(n), 0)
+				note := ""
+				if name == "exp" && at.Before(time.Now()) {
+					note = " (expired)"
+				}
+				fmt.Fprintf(out, "%s: %s%s\n", name, at.Format(time.RFC3339), note)
+			}
+		}
+		return nil
+	},
+}
+
+var jwtSignCmd = &cobra.Command{
+	Use:   "sign <token|->",
+	Short: "Edit a JWT and sign it again",
+	Long: `Edit the header and claims of a JWT and sign it again, for testing how a server
+checks tokens. --claim and --header take NAME=VALUE, where a VALUE that parses as JSON
+(a number, true, an object) is used as such and anything else as a string; --unset
+removes claims. HMAC algorithms are signed with --key; RSA and ECDSA ones with the PEM
+private key in --key-file. --alg none strips the signature.
+
+  rogue jwt sign "$TOKEN" --claim role=admin --claim exp=4102444800 --key secret
+  rogue jwt sign "$TOKEN" --alg none`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		t, err := readToken(args[0])
+		if err != nil {
+			return err
+		}
+		edit := jwt.Edit{Header: map[string]any{}, Claims: map[string]any{}}
+		edit.Alg, _ = cmd.Flags().GetString("alg")
+		for flag, dst := range map[string]map[string]any{"claim": edit.Claims, "header": edit.Header} {
+			pairs, _ := cmd.Flags().GetStringArray(flag)
+			for _, pair := range pairs {
+				name, value, ok := strings.Cut(pair, "=")
+				if !ok || name == "" {
+					return fmt.Errorf("invalid --%s %q (want NAME=VALUE)", flag, pair)
+				}
+				dec := json.NewDecoder(strings.NewReader(value))
+				dec.UseNumber()
+				var v any
+				if dec.Decode(&v) != nil || dec.More() {
+					v = value
+				}
+				dst[name] = v
+			}
+		}
+		unset, _ := cmd.Flags().GetStringSlice("unset")
+		for _, name := range unset {
+			edit.Claims[name] = nil
+		}
+
+		key, _ := cmd.Flags().GetString("key")
+		keyFile, _ := cmd.Flags().GetString("key-file")
+		material := []byte(key)
+		if keyFile != "" {
+			if key != "" {
+				return fmt.Errorf("--key and --key-file cannot be combined")
+			}
+			if material, err = os.ReadFile(keyFile); err != nil {
+				return err
+			}
+		}
+		signed, err := jwt.Resign(t, edit, material)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(cmd.OutOrStdout(), signed)
+		return nil
+	},
+}
+
+// readToken parses arg, or the first line of stdin when arg is -.
+func readToken(arg string) (*jwt.Token, error) {
+	if arg == "-" {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return nil, fmt.Errorf("reading a token from stdin: %w", err)
+		}
+		arg = line
+	}
+	arg = strings.TrimSpace(arg)
+	arg = strings.TrimSpace(strings.TrimPrefix(strings.TrimPrefix(arg, "Bearer "), "bearer "))
+	return jwt.Parse(arg)
+}
+
+func init() {
+	jwtSignCmd.Flags().String("alg", "", "Sign with this algorithm instead of the token's (HS256, RS256, ES256, none, ...)")
+	jwtSignCmd.Flags().StringArray("claim", nil, "Set a claim, as NAME=VALUE (repeatable)")
+	jwtSignCmd.Flags().StringArray("header", nil, "Set a header member, as NAME=VALUE (repeatable)")
+	jwtSignCmd.Flags().StringSlice("unset", nil, "Remove these claims")
+	jwtSignCmd.Flags().String("key", "", "HMAC secret to sign with")
+	jwtSignCmd.Flags().String("key-file", "", "PEM private key to sign with, for RSA and ECDSA algorithms")
+	jwtCmd.AddCommand(jwtDecodeCmd, jwtSignCmd)
+}
@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/standrze/rogue/internal/openapi"
+	"github.com/standrze/rogue/internal/rules"
+)
+
+var mockCmd = &cobra.Command{
+	Use:   "mock",
+	Short: "Serve mock responses generated from an API description",
+	Long: `Generate a mock rule for every operation of an OpenAPI 3 spec, answering with its
+example (or a schema-conformant) response, and start the proxy with them. Requests
+that no operation matches are proxied as usual. With --out, the rules are written
+to a file instead so they can be edited and loaded through rules.files.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		spec, _ := cmd.Flags().GetString("openapi")
+		host, _ := cmd.Flags().GetString("match-host")
+		out, _ := cmd.Flags().GetString("out")
+
+		doc, err := openapi.Load(spec)
+		if err != nil {
+			return err
+		}
+		mocks := openapi.MockRules(doc, host)
+
+		if out != "" {
+			if err := rules.Save(out, mocks); err != nil {
+				return err
+			}
+			fmt.Printf("Wrote %d mock rules to %s\n", len(mocks), out)
+			return nil
+		}
+
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+		_, vars, err := environment(cfg, "")
+		if err != nil {
+			return err
+		}
+		// Configured rules come first so hand-written overrides win.
+		set, err := loadRules(cfg.Rules.Files, vars)
+		if err != nil {
+			return err
+		}
+		set.Add(mocks...)
+
+		fmt.Fprintf(cmd.OutOrStdout(), "Mocking %d operations from %s\n", len(mocks), spec)
+		return runProxy(cfg, set, nil, cmd.OutOrStdout())
+	},
+}
+
+func init() {
+	mockCmd.Flags().String("openapi", "", "OpenAPI 3 spec file or URL")
+	mockCmd.Flags().String("match-host", "", `Host to mock instead of the spec's first server ("*" for any)`)
+	mockCmd.Flags().StringP("out", "o", "", "Write the generated rules to a file instead of serving them")
+	mockCmd.MarkFlagRequired("openapi")
+}
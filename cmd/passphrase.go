@@ -0,0 +1,41 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/standrze/rogue/internal/cert"
+	"github.com/standrze/rogue/internal/config"
+	"golang.org/x/term"
+)
+
+// keyPassphraseEnv supplies the CA key passphrase when the config does not.
+const keyPassphraseEnv = "ROGUE_KEY_PASSPHRASE"
+
+// keyPassphrase resolves the CA key passphrase from the config, the
+// environment or, failing both, an interactive prompt.
+func keyPassphrase(c config.CertificateConfig) cert.PassphraseFunc {
+	return func() ([]byte, error) {
+		if c.KeyPassphrase != "" {
+			return []byte(c.KeyPassphrase), nil
+		}
+		if p := os.Getenv(keyPassphraseEnv); p != "" {
+			return []byte(p), nil
+		}
+		return promptPassphrase(fmt.Sprintf("Passphrase for %s: ", c.KeyPath))
+	}
+}
+
+// promptPassphrase reads a passphrase from the terminal without echoing it.
+func promptPassphrase(prompt string) ([]byte, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return nil, errors.New("no passphrase configured; set certificate.key_passphrase or " + keyPassphraseEnv)
+	}
+
+	fmt.Fprint(os.Stderr, prompt)
+	p, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	return p, err
+}
@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/spf13/cobra"
+	"github.com/standrze/rogue/internal/logger"
+	"github.com/standrze/rogue/internal/playback"
+)
+
+var playbackCmd = &cobra.Command{
+	Use:   "playback <session>",
+	Short: "Serve the responses of a recorded session as a mock server",
+	Long: `Start a server that answers requests with the responses recorded in a session,
+so captures of a real API can stand in for it. Point clients at the server as
+their base URL, or use it as a plain HTTP proxy to match on the host too.
+
+Requests are matched on the parts given with --match: method, host, path, query
+and body. Query parameters are compared regardless of order and JSON bodies
+regardless of whitespace and member order. A request recorded several times is
+answered with each recording in turn, then with the last one. Requests nothing
+matches get a 404.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+
+		path, err := resolveSession(cfg, args[0])
+		if err != nil {
+			return err
+		}
+		flows, err := logger.LoadFlows(path)
+		if err != nil {
+			return err
+		}
+
+		names, _ := cmd.Flags().GetStringSlice("match")
+		criteria, err := playback.ParseCriteria(names)
+		if err != nil {
+			return err
+		}
+		criteria.IgnoreParams, _ = cmd.Flags().GetStringSlice("ignore-param")
+
+		s := playback.New(flows, criteria)
+		if s.Len() == 0 {
+			return fmt.Errorf("%s has no complete flows to play back", filepath.Base(path))
+		}
+		s.OnMiss = func(r *http.Request) {
+			fmt.Fprintf(os.Stderr, "no match: %s %s\n", r.Method, r.URL.RequestURI())
+		}
+
+		host, _ := cmd.Flags().GetString("host")
+		port, _ := cmd.Flags().GetInt("port")
+		addr := net.JoinHostPort(host, strconv.Itoa(port))
+
+		fmt.Printf("Playing back %s (%d flows) on http://%s\n", filepath.Base(path), s.Len(), addr)
+		return http.ListenAndServe(addr, s)
+	},
+}
+
+func init() {
+	playbackCmd.Flags().IntP("port", "p", 9000, "Port for the playback server")
+	playbackCmd.Flags().String("host", "127.0.0.1", "Host for the playback server")
+	playbackCmd.Flags().StringSlice("match", []string{"method", "path", "query"}, "Request parts to match on: method, host, path, query, body")
+	playbackCmd.Flags().StringSlice("ignore-param", nil, "Query parameter to leave out of matching (repeatable)")
+}
@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+const profileConfig = `{
+  "proxy": {"host": "127.0.0.1", "port": 8080},
+  "logging": {"session_dir": "logs"},
+  "profiles": {
+    "mobile": {"proxy": {"port": 9090}},
+    "ci": {"logging": {"session_dir": "ci-sessions"}},
+    "a/b": {},
+    "a\\b": {}
+  }
+}`
+
+// configResult is the part of the config profiles change.
+type configResult struct {
+	port       int
+	host       string
+	sessionDir string
+}
+
+// useProfile loads the config in a directory holding profileConfig with
+// --profile name and, unless it is empty, --port port.
+func useProfile(t *testing.T, name, port string) (*configResult, error) {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(profileConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(dir)
+
+	profile := rootCmd.PersistentFlags().Lookup("profile")
+	portFlag := startCmd.Flags().Lookup("port")
+	t.Cleanup(func() {
+		profile.Value.Set("")
+		profile.Changed = false
+		portFlag.Value.Set(portFlag.DefValue)
+		portFlag.Changed = false
+		viper.Set("logging.session_dir", nil)
+	})
+	rootCmd.PersistentFlags().Set("profile", name)
+	if port != "" {
+		startCmd.Flags().Set("port", port)
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	return &configResult{port: cfg.Proxy.Port, host: cfg.Proxy.Host, sessionDir: cfg.Logging.SessionDir}, nil
+}
+
+func TestProfiles(t *testing.T) {
+	for _, tc := range []struct {
+		name, profile, port string
+		want                configResult
+	}{
+		{"profile over the file", "mobile", "", configResult{9090, "127.0.0.1", filepath.Join("logs", "mobile")}},
+		{"flag over the profile", "mobile", "7000", configResult{7000, "127.0.0.1", filepath.Join("logs", "mobile")}},
+		{"profile session dir", "ci", "", configResult{8080, "127.0.0.1", "ci-sessions"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := useProfile(t, tc.profile, tc.port)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *got != tc.want {
+				t.Errorf("got %+v, want %+v", *got, tc.want)
+			}
+		})
+	}
+}
+
+func TestProfileNames(t *testing.T) {
+	for _, name := range []string{"", " ", "..", "../mobile", "a/b", `a\b`} {
+		if _, err := useProfile(t, name, ""); err == nil || !strings.Contains(err.Error(), "invalid profile name") {
+			t.Errorf("profile %q: %v", name, err)
+		}
+	}
+	if _, err := useProfile(t, "unknown", ""); err == nil {
+		t.Error("unknown profile accepted")
+	}
+}
@@ -4,18 +4,62 @@ Copyright © 2025 Stephen Andrzejewski sandrzejewski@berkeley.edu
 package cmd
 
 import (
+	"cmp"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"io"
+	"maps"
 	"net"
+	"net/netip"
+	"net/url"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/charmbracelet/fang"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"github.com/standrze/rogue/internal/admin"
+	"github.com/standrze/rogue/internal/adminrpc"
+	"github.com/standrze/rogue/internal/budget"
+	"github.com/standrze/rogue/internal/cache"
+	"github.com/standrze/rogue/internal/capture"
 	"github.com/standrze/rogue/internal/config"
+	"github.com/standrze/rogue/internal/cookies"
+	"github.com/standrze/rogue/internal/display"
+	"github.com/standrze/rogue/internal/env"
+	"github.com/standrze/rogue/internal/events"
+	"github.com/standrze/rogue/internal/filter"
+	"github.com/standrze/rogue/internal/findings"
+	"github.com/standrze/rogue/internal/grpcdecode"
+	"github.com/standrze/rogue/internal/listen"
+	"github.com/standrze/rogue/internal/live"
+	"github.com/standrze/rogue/internal/logger"
+	"github.com/standrze/rogue/internal/metrics"
+	"github.com/standrze/rogue/internal/openapi"
+	"github.com/standrze/rogue/internal/pac"
+	"github.com/standrze/rogue/internal/pages"
+	"github.com/standrze/rogue/internal/plugin"
 	"github.com/standrze/rogue/internal/proxy"
+	"github.com/standrze/rogue/internal/rawhttp"
+	"github.com/standrze/rogue/internal/repeater"
+	"github.com/standrze/rogue/internal/rules"
+	"github.com/standrze/rogue/internal/scope"
+	"github.com/standrze/rogue/internal/sensitive"
+	"github.com/standrze/rogue/internal/share"
+	"github.com/standrze/rogue/internal/sidecar"
+	"github.com/standrze/rogue/internal/sink"
+	"github.com/standrze/rogue/internal/tracing"
+	"github.com/standrze/rogue/internal/viewer"
+	"github.com/standrze/rogue/internal/webhook"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // rootCmd represents the base command when called without any subcommands
@@ -34,87 +78,1187 @@ var startCmd = &cobra.Command{
 	Use:   "start",
 	Short: "Launch the Rogue proxy server instance",
 	RunE: func(cmd *cobra.Command, args []string) error {
-		// Set defaults
-		defaultConfig := config.DefaultConfig()
-		viper.SetDefault("proxy.port", defaultConfig.Proxy.Port)
-		viper.SetDefault("proxy.host", defaultConfig.Proxy.Host)
-		viper.SetDefault("proxy.timeout", defaultConfig.Proxy.Timeout)
-		viper.SetDefault("certificate.auto_generate", defaultConfig.Certificate.AutoGenerate)
-		viper.SetDefault("certificate.organization", defaultConfig.Certificate.Organization)
-		viper.SetDefault("certificate.common_name", defaultConfig.Certificate.CommonName)
-		viper.SetDefault("certificate.valid_days", defaultConfig.Certificate.ValidDays)
-		viper.SetDefault("certificate.cert_path", defaultConfig.Certificate.CertPath)
-		viper.SetDefault("certificate.key_path", defaultConfig.Certificate.KeyPath)
-		viper.SetDefault("logging.session_dir", defaultConfig.Logging.SessionDir)
-		viper.SetDefault("logging.log_requests", defaultConfig.Logging.LogRequests)
-		viper.SetDefault("logging.log_responses", defaultConfig.Logging.LogResponses)
-		viper.SetDefault("logging.log_headers", defaultConfig.Logging.LogHeaders)
-		viper.SetDefault("logging.log_body", defaultConfig.Logging.LogBody)
-		viper.SetDefault("logging.max_body_size", defaultConfig.Logging.MaxBodySize)
-
-		viper.SetConfigName("config")
-		viper.SetConfigType("json")
-		viper.AddConfigPath(".")
-		if err := viper.ReadInConfig(); err != nil {
-			if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
-				return err
-			}
-			// Config file not found; ignore error if desired or warn user
-			fmt.Println("No config file found, using defaults and flags")
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
 		}
 
-		var cfg config.Config
-		if err := viper.Unmarshal(&cfg); err != nil {
+		_, vars, err := environment(cfg, "")
+		if err != nil {
 			return err
 		}
+		set, err := loadRules(cfg.Rules.Files, vars)
+		if err != nil {
+			return err
+		}
+		restoreStaleSystemProxy(cmd.OutOrStdout(), cmd.ErrOrStderr())
+
+		return runProxy(cfg, set, nil, cmd.OutOrStdout())
+	},
+}
+
+// shareFile keeps share links in the session directory.
+const shareFile = ".shares"
+
+// loadRules reads the configured rule files into one set, in order, with
+// the references to vars expanded when they are not nil.
+func loadRules(files []string, vars env.Vars) (*rules.Set, error) {
+	set := rules.NewSet()
+	for _, f := range files {
+		rs, err := rules.Load(f)
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; vars != nil && i < len(rs); i++ {
+			if rs[i], err = rs[i].Expand(vars.Expand); err != nil {
+				return nil, fmt.Errorf("%s: rule %q: %w", f, rs[i].Name, err)
+			}
+		}
+		set.Add(rs...)
+	}
+	return set, nil
+}
+
+// runProxy serves the proxy described by cfg until it fails, the process is
+// signalled or, when it is not nil, watch ends the capture. Status messages
+// are printed to out.
+func runProxy(cfg *config.Config, set *rules.Set, watch *capture.Watcher, out io.Writer) error {
+	policy, err := tlsPolicy(cfg.TLS)
+	if err != nil {
+		return err
+	}
+	keyLog, err := openKeyLog(cfg.TLS.KeyLogFile)
+	if err != nil {
+		return fmt.Errorf("tls.key_log_file: %w", err)
+	}
+	if keyLog != nil {
+		defer keyLog.Close()
+		policy.KeyLog = keyLog
+	}
+
+	keyPerm, err := cfg.Certificate.KeyFileMode()
+	if err != nil {
+		return err
+	}
+	var pod *sidecar.Pod
+	if cfg.Sidecar.Enabled {
+		if pod, err = sidecar.LoadPod(cfg.Sidecar.PodInfoDir); err != nil {
+			return fmt.Errorf("sidecar.pod_info_dir: %w", err)
+		}
+		// Traffic arrives redirected by the rules of rogue sidecar init.
+		cfg.Proxy.Mode = proxy.ModeTransparent
+	}
+	caCert, caKey, err := caPEM(cfg, pod)
+	if err != nil {
+		return err
+	}
+	if caCert == nil {
+		useLegacyCA(&cfg.Certificate)
+		warnKeyPerm(cfg.Certificate.KeyPath, keyPerm)
+	}
+
+	profiles := headerProfiles(cfg.Headers.Profiles)
+	if err := profiles.Validate(); err != nil {
+		return err
+	}
+	if err := profiles.Check(cfg.Headers.Global...); err != nil {
+		return err
+	}
+	for _, r := range set.Rules() {
+		if err := profiles.Check(r.Headers...); err != nil {
+			return fmt.Errorf("rule %q: %w", r.Name, err)
+		}
+	}
+	jar := cookies.NewJar()
+
+	queuePolicy, err := logger.ParseQueuePolicy(cfg.Logging.QueuePolicy)
+	if err != nil {
+		return err
+	}
+	queueSize := 0
+	if cfg.Logging.Async {
+		queueSize = cfg.Logging.QueueSize
+	}
 
-		fmt.Printf("Starting Rogue on %s:%d\n", cfg.Proxy.Host, cfg.Proxy.Port)
-
-		p, sl := proxy.NewProxyServer(
-			proxy.WithPort(cfg.Proxy.Port),
-			proxy.WithHost(cfg.Proxy.Host),
-			proxy.WithCert(cfg.Certificate.CertPath, cfg.Certificate.KeyPath),
-			proxy.WithSessionDir(cfg.Logging.SessionDir),
-			proxy.WithLogging(
-				cfg.Logging.LogRequests,
-				cfg.Logging.LogResponses,
-				cfg.Logging.LogHeaders,
-				cfg.Logging.LogBody,
-				cfg.Logging.MaxBodySize,
-			),
+	sinks, err := logSinks(cfg.Logging.Sinks)
+	if err != nil {
+		return err
+	}
+	if cfg.Logging.Stdout {
+		sinks = append(sinks, proxy.LogSink{Sink: sink.NewWriter(os.Stdout), Options: logger.SinkOptions{Name: sink.TypeStdout}})
+	}
+	if stdoutSink(cfg.Logging) {
+		// Standard output carries entries only; status messages go to
+		// stderr.
+		out = os.Stderr
+	}
+	if watch != nil {
+		sinks = append(sinks, proxy.LogSink{Sink: watch, Options: logger.SinkOptions{Name: "capture"}})
+	}
+	var hub *live.Hub
+	var broker *events.Broker
+	if cfg.Admin.Enabled {
+		hub = live.NewHub(cfg.Admin.LivePreview)
+		broker = events.NewBroker(cfg.Filters)
+		sinks = append(sinks,
+			proxy.LogSink{Sink: hub, Options: logger.SinkOptions{Name: "live"}},
+			proxy.LogSink{Sink: broker, Options: logger.SinkOptions{Name: "events"}},
 		)
-		defer sl.Close()
+	}
+
+	responseCache, err := openCache(cfg, out)
+	if err != nil {
+		return err
+	}
 
-		l, err := net.Listen("tcp", fmt.Sprintf("%s:%d", cfg.Proxy.Host, cfg.Proxy.Port))
+	var validator *openapi.Validator
+	if cfg.Validation.Spec != "" {
+		doc, err := openapi.Load(cfg.Validation.Spec)
 		if err != nil {
 			return err
 		}
+		if validator, err = openapi.NewValidator(doc); err != nil {
+			return err
+		}
+	}
 
-		// Create a channel to listen for OS signals
-		sigChan := make(chan os.Signal, 1)
-		signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	var pageSet *pages.Pages
+	if cfg.Pages.Enabled {
+		if pageSet, err = pages.New(cfg.Pages.Dir); err != nil {
+			return err
+		}
+	}
 
-		// Create a channel to listen for server errors
-		errChan := make(chan error, 1)
+	var plugins []*plugin.Plugin
+	if cfg.Plugins.Enabled {
+		timeout := time.Duration(cfg.Plugins.TimeoutMS) * time.Millisecond
+		if plugins, err = plugin.Load(cfg.Plugins.Dir, timeout); err != nil {
+			return fmt.Errorf("plugins: %w", err)
+		}
+		for _, p := range plugins {
+			defer p.Close()
+			fmt.Fprintf(out, "Loaded plugin %s (%s)\n", p.Name, filepath.Base(p.Path))
+		}
+	}
+
+	var scanner *findings.Scanner
+	if cfg.Findings.Enabled {
+		if scanner, err = findings.NewScanner(cfg.Findings.Disable); err != nil {
+			return fmt.Errorf("findings.disable: %w", err)
+		}
+	}
+
+	var detector *sensitive.Scanner
+	var sensitiveWarn io.Writer
+	if cfg.Sensitive.Enabled {
+		if detector, err = sensitive.NewScanner(cfg.Sensitive.Disable, cfg.Sensitive.Detectors); err != nil {
+			return fmt.Errorf("sensitive: %w", err)
+		}
+		if cfg.Sensitive.Warn {
+			sensitiveWarn = os.Stderr
+		}
+	}
+
+	inScope, err := scope.New(cfg.Scope.Include, cfg.Scope.Exclude)
+	if err != nil {
+		return fmt.Errorf("scope: %w", err)
+	}
+
+	protoSchema, protoTypes, err := protobufSchema(cfg.Protobuf)
+	if err != nil {
+		return err
+	}
+
+	hsts, err := proxy.ParseHSTSMode(cfg.Proxy.HSTS)
+	if err != nil {
+		return fmt.Errorf("proxy.hsts: %w", err)
+	}
+	if hsts != "" {
+		fmt.Fprintln(os.Stderr, "Warning: removing HSTS from responses (proxy.hsts); clients behind the proxy can be downgraded to plain HTTP")
+	}
+
+	trusted, err := listen.ParseTrusted(cfg.Proxy.ProxyProtocolTrusted)
+	if err != nil {
+		return fmt.Errorf("proxy.proxy_protocol_trusted: %w", err)
+	}
+	limits := cfg.Proxy.Limits
+	limiter, err := proxy.NewLimiter(proxy.Limits{
+		MaxConnections:          limits.MaxConnections,
+		MaxConnectionsPerClient: limits.MaxConnectionsPerClient,
+		RequestRate:             limits.RequestsPerSecond,
+		RequestBurst:            limits.RequestBurst,
+		RatePolicy:              limits.RatePolicy,
+		Bandwidth:               limits.BandwidthBytesPerSecond,
+	})
+	if err != nil {
+		return fmt.Errorf("proxy.limits: %w", err)
+	}
+	if dir := limits.BodySpillDir; dir != "" {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return fmt.Errorf("proxy.limits.body_spill_dir: %w", err)
+		}
+	}
+	// Interception can be switched at runtime through the gRPC admin API.
+	interception := &proxy.Interception{}
+	chain, err := upstreamProxy(cfg.Proxy.UpstreamProxy)
+	if err != nil {
+		return fmt.Errorf("proxy.upstream_proxy: %w", err)
+	}
+	l, activated, err := mainListener(cfg.Proxy, trusted)
+	if err != nil {
+		return err
+	}
+	// Closing a Unix listener removes its socket file.
+	defer l.Close()
+	mode, err := proxy.ParseListenMode(cfg.Proxy.Mode)
+	if err != nil {
+		return fmt.Errorf("proxy.mode: %w", err)
+	}
+	main := &proxy.Listener{Mode: mode, Listener: limiter.Listener(l)}
+	if err := listenerTLS(main, cfg.Proxy.CertPath, cfg.Proxy.KeyPath, cfg.Proxy.ClientCA); err != nil {
+		return fmt.Errorf("proxy: %w", err)
+	}
+	listeners, err := openListeners(cfg.Listeners, trusted)
+	if err != nil {
+		return err
+	}
+	listeners = append(listeners, activated...)
+
+	if mode == proxy.ModeHTTP {
+		fmt.Fprintf(out, "Starting Rogue on %s\n", l.Addr())
+	} else {
+		fmt.Fprintf(out, "Starting Rogue on %s (%s)\n", l.Addr(), mode)
+	}
+	for _, l := range listeners {
+		fmt.Fprintf(out, "Also listening on %s (%s)\n", l.Listener.Addr(), l.Mode)
+	}
+	if profile := viper.GetString("profile"); profile != "" {
+		fmt.Fprintf(out, "Profile %s; sessions in %s\n", profile, cfg.Logging.SessionDir)
+	}
+	if name, _, err := environment(cfg, ""); err != nil {
+		return err
+	} else if name != "" {
+		fmt.Fprintf(out, "Environment %s\n", name)
+	}
+	var labels map[string]string
+	if pod != nil {
+		labels = pod.Labels
+		fmt.Fprintf(out, "Sidecar of pod %s in namespace %s\n", pod.Name, pod.Namespace)
+	}
+
+	reg := metrics.NewRegistry()
+
+	var tracer trace.Tracer
+	if cfg.Tracing.Endpoint != "" {
+		tp, err := tracing.NewProvider(context.Background(), tracing.Config{
+			Endpoint:    cfg.Tracing.Endpoint,
+			Headers:     cfg.Tracing.Headers,
+			ServiceName: cfg.Tracing.ServiceName,
+			SampleRatio: cfg.Tracing.SampleRatio,
+		})
+		if err != nil {
+			return err
+		}
+		defer tp.Shutdown(context.Background())
+		tracer = tp.Tracer("github.com/standrze/rogue")
+		fmt.Fprintf(out, "Exporting traces to %s\n", cfg.Tracing.Endpoint)
+	}
+
+	p, sl := proxy.NewProxyServer(
+		proxy.WithPort(cfg.Proxy.Port),
+		proxy.WithHost(cfg.Proxy.Host),
+		proxy.WithCert(cfg.Certificate.CertPath, cfg.Certificate.KeyPath),
+		proxy.WithCAPEM(caCert, caKey),
+		proxy.WithSessionDir(cfg.Logging.SessionDir),
+		proxy.WithSessionResume(cfg.Logging.ResumeLastSession),
+		proxy.WithSessionRotation(cfg.Logging.RotateSize, seconds(cfg.Logging.RotateInterval), cfg.Logging.RotateCompress),
+		proxy.WithAsyncLogging(queueSize, queuePolicy, seconds(cfg.Logging.SyncInterval)),
+		proxy.WithSessionFile(cfg.Logging.SessionFile),
+		proxy.WithLogSinks(sinks...),
+		proxy.WithLogDegradation(logger.DegradePolicy{
+			Bodies:      cfg.Logging.Degrade.BodiesAt,
+			Headers:     cfg.Logging.Degrade.HeadersAt,
+			Sample:      cfg.Logging.Degrade.SampleAt,
+			SampleEvery: cfg.Logging.Degrade.SampleEvery,
+		}),
+		proxy.WithTracing(tracer, cfg.Tracing.Propagate),
+		proxy.WithRequestIDHeader(cfg.Proxy.RequestIDHeader),
+		proxy.WithTLSPolicy(policy),
+		proxy.WithCAGeneration(cfg.Certificate.AutoGenerate),
+		proxy.WithKeyPassphrase(keyPassphrase(cfg.Certificate), cfg.Certificate.EncryptKey),
+		proxy.WithKeyPerm(keyPerm),
+		proxy.WithCertCache(cfg.Certificate.CacheSize, cfg.Certificate.WarmHosts),
+		proxy.WithKeyPool(cfg.Certificate.KeyPoolSize),
+		proxy.WithMetrics(reg),
+		proxy.WithRules(set),
+		proxy.WithValidation(validator, cfg.Validation.Responses, cfg.Validation.Reject),
+		proxy.WithPages(pageSet, cfg.Pages.TrustHost),
+		proxy.WithPlugins(cfg.Plugins.MaxBodySize, plugins...),
+		proxy.WithFindings(scanner),
+		proxy.WithSensitiveData(detector, sensitiveWarn),
+		proxy.WithScope(inScope),
+		proxy.WithProtobufSchema(protoSchema, protoTypes...),
+		proxy.WithMainListener(main),
+		proxy.WithListeners(listeners...),
+		proxy.WithBindings(bindings(cfg.Proxy.Bind)...),
+		proxy.WithDialStrategy(dialStrategy(cfg.Proxy.Dial)),
+		proxy.WithDNS(dnsConfig(cfg.DNS)),
+		proxy.WithHeaderProfiles(profiles, cfg.Headers.Global...),
+		proxy.WithCookieJar(jar),
+		proxy.WithCache(responseCache),
+		proxy.WithLimiter(limiter),
+		proxy.WithInterception(interception),
+		proxy.WithUpstreamProxy(chain),
+		proxy.WithTimeouts(proxy.Timeouts{
+			Request:        seconds(cfg.Proxy.Timeout),
+			Dial:           seconds(cfg.Proxy.DialTimeout),
+			TLSHandshake:   seconds(cfg.Proxy.TLSHandshakeTimeout),
+			ResponseHeader: seconds(cfg.Proxy.ResponseHeaderTimeout),
+			Idle:           seconds(cfg.Proxy.IdleTimeout),
+		}),
+		proxy.WithSSEEventLogging(cfg.Logging.LogSSEEvents),
+		proxy.WithRegistryBlobs(cfg.Logging.LogRegistryBlobs),
+		proxy.WithServerCertLogging(cfg.Logging.LogServerCerts),
+		proxy.WithTunnelLogging(cfg.Logging.LogTunnels, cfg.Logging.TunnelSampleSize),
+		proxy.WithAltSvcStripping(cfg.Proxy.StripAltSvc),
+		proxy.WithHSTS(hsts),
+		proxy.WithBodyBudget(budget.New(limits.BodyMemoryBytes, limits.BodySpillDir)),
+		proxy.WithS3SignatureRedaction(cfg.Logging.RedactS3Signatures),
+		proxy.WithLabels(labels),
+		proxy.WithLogging(
+			cfg.Logging.LogRequests,
+			cfg.Logging.LogResponses,
+			cfg.Logging.LogHeaders,
+			cfg.Logging.LogBody,
+			cfg.Logging.MaxBodySize,
+		),
+	)
+	defer sl.Close()
+
+	var onClose []func(logger.SessionFile)
+	if cfg.Webhook.URL != "" {
+		n := webhook.New(webhook.Config{
+			URL:            cfg.Webhook.URL,
+			Headers:        cfg.Webhook.Headers,
+			IncludeFile:    cfg.Webhook.IncludeFile,
+			LocationPrefix: cfg.Webhook.LocationPrefix,
+			Timeout:        seconds(cfg.Webhook.Timeout),
+			Retries:        cfg.Webhook.Retries,
+		})
+		onClose = append(onClose, func(f logger.SessionFile) {
+			if err := n.Notify(f); err != nil {
+				fmt.Fprintf(os.Stderr, "Webhook for %s failed: %v\n", f.Name, err)
+			}
+		})
+	}
+	if watch != nil {
+		onClose = append(onClose, watch.SessionClosed)
+	}
+	if len(onClose) > 0 {
+		sl.SetCloseHook(func(f logger.SessionFile) {
+			for _, fn := range onClose {
+				fn(f)
+			}
+		})
+	}
+
+	// Create a channel to listen for OS signals
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+
+	// Create a channel to listen for server errors
+	errChan := make(chan error, 4)
+	go func() {
+		errChan <- p.Serve(main.Listener)
+	}()
+	// Written once the proxy serves, so scripts can wait for the file.
+	if cfg.Proxy.AddrFile != "" {
+		if err := os.WriteFile(cfg.Proxy.AddrFile, []byte(l.Addr().String()+"\n"), 0o644); err != nil {
+			return err
+		}
+		defer os.Remove(cfg.Proxy.AddrFile)
+	}
+
+	if cfg.Admin.Enabled {
+		al, err := net.Listen("tcp", fmt.Sprintf("%s:%d", cfg.Admin.Host, cfg.Admin.Port))
+		if err != nil {
+			return err
+		}
+		srv := admin.New()
+		srv.SetToken(cfg.Admin.Token)
+		srv.Handle("GET /metrics", reg)
+		srv.Handle("/rules", &rules.Handler{Set: set, Profiles: profiles})
+		srv.Handle("/cookies", jar)
+		srv.Handle("GET /config", configHandler(cfg))
+		if cfg.Admin.Debug {
+			srv.HandleDebug()
+		}
+		formatter, err := display.New(cfg.Display.Timezone, cfg.Display.Locale)
+		if err != nil {
+			return err
+		}
+		archive := viewer.NewArchive(cfg.Logging.SessionDir)
+		archive.SetFormatter(formatter)
+		flows := archive.Handler()
+		srv.Handle("GET /flows/", flows)
+		srv.Handle("GET /api/flows/", flows)
+		srv.Handle("GET /api/live", hub)
+		srv.Handle("GET /events", broker)
+
+		store, err := share.Open(filepath.Join(cfg.Logging.SessionDir, shareFile))
+		if err != nil {
+			return fmt.Errorf("loading share links: %w", err)
+		}
+		shares := &share.Handler{Store: store, SessionDir: cfg.Logging.SessionDir, Formatter: formatter, Filters: cfg.Filters, BaseURL: cfg.Admin.ShareURL}
+		shareAdmin := shares.Admin()
+		srv.Handle("/shares", shareAdmin)
+		srv.Handle("/shares/", shareAdmin)
+		if cfg.Admin.ShareListen != "" {
+			shl, err := net.Listen("tcp", cfg.Admin.ShareListen)
+			if err != nil {
+				return fmt.Errorf("admin: share listener: %w", err)
+			}
+			if shares.BaseURL == "" {
+				shares.BaseURL = listenURL(shl.Addr())
+			}
+			pub := admin.New()
+			pub.HandlePublic("GET /share/", shares.Public())
+			defer pub.Shutdown(context.Background())
+			fmt.Fprintf(out, "Share links at %s/share/<id>/\n", strings.TrimSuffix(shares.BaseURL, "/"))
+			go func() {
+				errChan <- pub.Serve(shl)
+			}()
+		} else {
+			srv.HandlePublic("GET /share/", shares.Public())
+		}
+		if opts, ok := repeaterOptions(cfg, mode, l.Addr()); ok {
+			if caCert != nil {
+				var ok bool
+				if opts.RootCAs, ok = pemRoots(caCert); !ok {
+					return fmt.Errorf("no certificates in the CA certificate (%s or sidecar.ca_secret)", caCertEnv)
+				}
+			} else if opts.RootCAs, err = proxyRoots(cfg.Certificate.CertPath); err != nil {
+				return err
+			}
+			rp := repeater.New(archive, opts)
+			// Environments are read on every send to pick up rogue env changes.
+			rp.Env = func(name string) (env.Vars, error) {
+				_, vars, err := environment(cfg, name)
+				return vars, err
+			}
+			rh := rp.Handler()
+			srv.Handle("/repeater", rh)
+			srv.Handle("/repeater/", rh)
+		} else {
+			fmt.Fprintln(os.Stderr, "Not serving the repeater: the proxy does not listen for plain HTTP on TCP")
+		}
+		if cfg.PAC.Enabled {
+			if h, ok := pacHandler(cfg, l.Addr()); ok {
+				srv.Handle("GET /proxy.pac", h)
+				srv.Handle("GET /wpad.dat", h)
+				fmt.Fprintf(out, "PAC file at http://%s/proxy.pac\n", al.Addr())
+			} else {
+				fmt.Fprintln(os.Stderr, "Not serving a PAC file: the proxy does not listen on TCP; set pac.proxy")
+			}
+		}
+		defer srv.Shutdown(context.Background())
+
+		fmt.Fprintf(out, "Admin server on %s; flows at http://%s/flows/<id>\n", al.Addr(), al.Addr())
 		go func() {
-			errChan <- p.Serve(l)
+			errChan <- srv.Serve(al)
 		}()
 
-		// Block until a signal is received or the server returns an error
+		if cfg.Admin.GRPCPort != 0 {
+			gl, err := net.Listen("tcp", net.JoinHostPort(cfg.Admin.Host, strconv.Itoa(cfg.Admin.GRPCPort)))
+			if err != nil {
+				return err
+			}
+			rpc := (&adminrpc.Server{
+				Rules:        set,
+				Profiles:     profiles,
+				Hub:          hub,
+				Interception: interception,
+				Token:        cfg.Admin.Token,
+			}).GRPC()
+			defer rpc.Stop()
+			fmt.Fprintf(out, "gRPC admin API on %s\n", gl.Addr())
+			go func() {
+				errChan <- rpc.Serve(gl)
+			}()
+		}
+	}
+
+	var captureDone <-chan struct{}
+	if watch != nil {
+		captureDone = watch.Done()
+	}
+
+	// Block until a signal is received, the capture ends or the server
+	// returns an error
+	select {
+	case <-sigChan:
+		fmt.Fprintln(out, "\nReceived shutdown signal, closing session...")
+		exitAfter(sigChan, seconds(cfg.Proxy.ShutdownTimeout))
+		return nil
+	case <-captureDone:
+		fmt.Fprintf(out, "Ending the capture (%s), closing session...\n", watch.Reason())
+		exitAfter(sigChan, seconds(cfg.Proxy.ShutdownTimeout))
+		return nil
+	case err := <-errChan:
+		return err
+	}
+}
+
+// exitAfter ends the process if closing down takes longer than grace or
+// another signal arrives, so that a container stops before it is killed.
+func exitAfter(sigChan <-chan os.Signal, grace time.Duration) {
+	var timeout <-chan time.Time
+	if grace > 0 {
+		timeout = time.After(grace)
+	}
+	go func() {
 		select {
 		case <-sigChan:
-			fmt.Println("\nReceived shutdown signal, closing session...")
-			return nil
-		case err := <-errChan:
+			fmt.Fprintln(os.Stderr, "Received a second signal, exiting")
+		case <-timeout:
+			fmt.Fprintf(os.Stderr, "Shutdown took longer than %s, exiting\n", grace)
+		}
+		os.Exit(1)
+	}()
+}
+
+// pacHandler builds the PAC file handler for a proxy listening on addr. It
+// reports false when clients could not be pointed at addr.
+func pacHandler(cfg *config.Config, addr net.Addr) (*pac.Handler, bool) {
+	h := &pac.Handler{
+		Proxy:  cfg.PAC.Proxy,
+		HTTPS:  strings.EqualFold(cfg.Proxy.Mode, proxy.ModeHTTPS),
+		Direct: append(append([]string(nil), cfg.PAC.IgnoreHosts...), cfg.TLS.PassthroughHosts...),
+	}
+	if tcp, ok := addr.(*net.TCPAddr); ok {
+		h.Port = tcp.Port
+	}
+	return h, h.Proxy != "" || h.Port != 0
+}
+
+// repeaterOptions sends repeated requests through the proxy listening on
+// addr, so they are recorded. It reports false when the proxy cannot be
+// reached as a plain HTTP proxy over TCP.
+func repeaterOptions(cfg *config.Config, mode string, addr net.Addr) (rawhttp.Options, bool) {
+	tcp, ok := addr.(*net.TCPAddr)
+	if !ok || mode != proxy.ModeHTTP || cfg.Proxy.CertPath != "" {
+		return rawhttp.Options{}, false
+	}
+	host := "127.0.0.1"
+	if tcp.IP != nil && !tcp.IP.IsUnspecified() {
+		host = tcp.IP.String()
+	}
+	return rawhttp.Options{Proxy: net.JoinHostPort(host, strconv.Itoa(tcp.Port))}, true
+}
+
+// mainListener opens the proxy's primary socket: the first one passed by
+// systemd socket activation, proxy.listen, or proxy.host and proxy.port.
+// Further activated sockets are returned as additional HTTP listeners. All
+// of them expect PROXY protocol headers from trusted peers when
+// proxy.proxy_protocol is set.
+func mainListener(c config.ProxyConfig, trusted []netip.Prefix) (net.Listener, []proxy.Listener, error) {
+	wrap := func(l net.Listener) net.Listener {
+		if c.ProxyProtocol {
+			return listen.ProxyProtocol(l, trusted)
+		}
+		return l
+	}
+
+	activated, err := listen.Activated()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(activated) > 0 {
+		var extra []proxy.Listener
+		for _, l := range activated[1:] {
+			extra = append(extra, proxy.Listener{Mode: proxy.ModeHTTP, Listener: wrap(l)})
+		}
+		return wrap(activated[0]), extra, nil
+	}
+
+	addr := c.Listen
+	if addr == "" {
+		addr = net.JoinHostPort(c.Host, strconv.Itoa(c.Port))
+	}
+	l, err := listen.ListenRetry(addr, seconds(c.RetryBind))
+	if err != nil {
+		return nil, nil, err
+	}
+	return wrap(l), nil, nil
+}
+
+// openListeners binds the additional listeners from the config.
+func openListeners(cfgs []config.ListenerConfig, trusted []netip.Prefix) ([]proxy.Listener, error) {
+	var out []proxy.Listener
+	for _, c := range cfgs {
+		mode, err := proxy.ParseListenMode(c.Mode)
+		if err != nil {
+			return nil, err
+		}
+		l := proxy.Listener{Mode: mode}
+		if err := listenerTLS(&l, c.CertPath, c.KeyPath, c.ClientCA); err != nil {
+			return nil, err
+		}
+		addr := net.JoinHostPort(c.Host, strconv.Itoa(c.Port))
+		if l.Listener, err = net.Listen("tcp", addr); err != nil {
+			return nil, listen.Explain(addr, err)
+		}
+		if c.ProxyProtocol {
+			l.Listener = listen.ProxyProtocol(l.Listener, trusted)
+		}
+		out = append(out, l)
+	}
+	return out, nil
+}
+
+// listenerTLS loads the certificate an https listener presents and the CAs
+// its clients' certificates must be signed by.
+func listenerTLS(l *proxy.Listener, certPath, keyPath, clientCA string) error {
+	if certPath != "" {
+		cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+		if err != nil {
 			return err
 		}
-	},
+		l.Certificate = &cert
+	}
+	if clientCA != "" {
+		data, err := os.ReadFile(clientCA)
+		if err != nil {
+			return err
+		}
+		l.ClientCAs = x509.NewCertPool()
+		if !l.ClientCAs.AppendCertsFromPEM(data) {
+			return fmt.Errorf("no certificates in %s", clientCA)
+		}
+	}
+	return nil
+}
+
+func bindings(cfgs []config.BindConfig) []proxy.Binding {
+	var out []proxy.Binding
+	for _, c := range cfgs {
+		out = append(out, proxy.Binding{Hosts: c.Hosts, Address: c.Address, Interface: c.Interface})
+	}
+	return out
+}
+
+// protobufSchema loads the descriptor sets and checks the message types
+// named in c.
+func protobufSchema(c config.ProtobufConfig) (*grpcdecode.Schema, []grpcdecode.MessageType, error) {
+	var schema *grpcdecode.Schema
+	if len(c.Descriptors) > 0 {
+		var err error
+		if schema, err = grpcdecode.LoadSchema(c.Descriptors...); err != nil {
+			return nil, nil, fmt.Errorf("protobuf.descriptors: %w", err)
+		}
+	}
+	var types []grpcdecode.MessageType
+	for _, m := range c.Messages {
+		for _, name := range []string{m.Request, m.Response} {
+			if _, ok := schema.Message(name); name != "" && !ok {
+				return nil, nil, fmt.Errorf("protobuf.messages: unknown message type %q", name)
+			}
+		}
+		types = append(types, grpcdecode.MessageType(m))
+	}
+	return schema, types, nil
+}
+
+func dialStrategy(c config.DialConfig) proxy.DialStrategy {
+	s := proxy.DialStrategy{
+		Family:        c.Family,
+		FallbackDelay: time.Duration(c.FallbackDelayMS) * time.Millisecond,
+	}
+	if !c.HappyEyeballs {
+		s.FallbackDelay = -1
+	}
+	for _, r := range c.Resolvers {
+		s.Resolvers = append(s.Resolvers, proxy.Resolver{Hosts: r.Hosts, Server: r.Server})
+	}
+	return s
+}
+
+func dnsConfig(c config.DNSConfig) proxy.DNS {
+	d := proxy.DNS{Server: c.Server}
+	for _, o := range c.Overrides {
+		d.Overrides = append(d.Overrides, proxy.Override{Host: o.Host, Addresses: o.Addresses})
+	}
+	return d
+}
+
+// upstreamProxy reads the upstream proxy URL, which may leave out the
+// scheme and carry the credentials.
+func upstreamProxy(c config.UpstreamProxyConfig) (proxy.UpstreamProxy, error) {
+	if c.URL == "" {
+		return proxy.UpstreamProxy{}, nil
+	}
+	raw := c.URL
+	if !strings.Contains(raw, "://") {
+		raw = "http://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return proxy.UpstreamProxy{}, err
+	}
+	if u.Scheme != "http" {
+		return proxy.UpstreamProxy{}, fmt.Errorf("unsupported scheme %q (only http proxies are supported)", u.Scheme)
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "80")
+	}
+	up := proxy.UpstreamProxy{
+		Address:  addr,
+		Auth:     strings.ToLower(c.Auth),
+		Username: c.Username,
+		Password: cmp.Or(c.Password, os.Getenv("ROGUE_UPSTREAM_PROXY_PASSWORD")),
+		NoProxy:  c.NoProxy,
+		Kerberos: proxy.Kerberos{
+			Config: c.Kerberos.Config,
+			Keytab: c.Kerberos.Keytab,
+			CCache: c.Kerberos.CCache,
+			SPN:    c.Kerberos.SPN,
+		},
+	}
+	if u.User != nil {
+		up.Username = cmp.Or(up.Username, u.User.Username())
+		if p, ok := u.User.Password(); ok && up.Password == "" {
+			up.Password = p
+		}
+	}
+	if up.Auth == "" && up.Username != "" {
+		up.Auth = proxy.ProxyAuthBasic
+	}
+	return up, nil
+}
+
+func headerProfiles(cfgs map[string]config.HeaderProfileConfig) rules.Profiles {
+	ops := func(c config.HeaderOpsConfig) rules.HeaderOps {
+		return rules.HeaderOps{Add: c.Add, Set: c.Set, Remove: c.Remove}
+	}
+	out := rules.Profiles{}
+	for name, c := range cfgs {
+		out[name] = rules.HeaderProfile{Request: ops(c.Request), Response: ops(c.Response)}
+	}
+	return out
+}
+
+// openCache creates the response cache when it is enabled, loading the
+// responses of cache.sessions into it and reporting them to out.
+func openCache(cfg *config.Config, out io.Writer) (*cache.Cache, error) {
+	c := cfg.Cache
+	if !c.Enabled && !c.Offline {
+		return nil, nil
+	}
+	rc, err := cache.New(cache.Options{
+		Dir:                c.Dir,
+		MaxEntries:         c.MaxEntries,
+		MaxBodySize:        c.MaxBodySize,
+		IgnoreCacheControl: c.IgnoreCacheControl,
+		Offline:            c.Offline,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("opening the response cache: %w", err)
+	}
+	for _, name := range c.Sessions {
+		path, err := resolveSession(cfg, name)
+		if err != nil {
+			return nil, err
+		}
+		flows, err := logger.LoadFlows(path)
+		if err != nil {
+			return nil, err
+		}
+		n, err := rc.Seed(flows)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(out, "Cached %d responses from %s\n", n, filepath.Base(path))
+	}
+	if c.Offline {
+		fmt.Fprintln(out, "Offline: answering only from the response cache")
+	}
+	return rc, nil
+}
+
+// logSinks creates the configured remote sinks. Unnamed sinks are named
+// after their type, numbered if there are several.
+func logSinks(cfgs []config.SinkConfig) ([]proxy.LogSink, error) {
+	var out []proxy.LogSink
+	seen := map[string]int{}
+	for _, c := range cfgs {
+		s, err := sink.New(sink.Config{
+			Type:     c.Type,
+			URL:      c.URL,
+			Headers:  c.Headers,
+			Network:  c.Network,
+			Address:  c.Address,
+			Facility: c.Facility,
+			Tag:      c.Tag,
+			Topic:    c.Topic,
+			Timeout:  seconds(c.Timeout),
+		})
+		if err != nil {
+			return nil, err
+		}
+		name := c.Name
+		if name == "" {
+			name = c.Type
+			if seen[c.Type]++; seen[c.Type] > 1 {
+				name += strconv.Itoa(seen[c.Type])
+			}
+		}
+		out = append(out, proxy.LogSink{Sink: s, Options: logger.SinkOptions{
+			Name:          name,
+			BatchSize:     c.BatchSize,
+			FlushInterval: time.Duration(c.FlushInterval) * time.Millisecond,
+			QueueSize:     c.QueueSize,
+		}})
+	}
+	return out, nil
+}
+
+// dockerEnv turns on the settings suited to running in a container.
+const dockerEnv = "ROGUE_DOCKER"
+
+// useDockerDefaults makes the defaults those of --no-color, --log-json and
+// --stateless-certs, and shortens the shutdown timeout to stay within
+// docker stop's grace period. The config file and flags still override
+// them.
+func useDockerDefaults() {
+	viper.SetDefault("display.no_color", true)
+	viper.SetDefault("logging.stdout", true)
+	viper.SetDefault("certificate.stateless", true)
+	viper.SetDefault("proxy.shutdown_timeout", 5)
+}
+
+// stdoutSink reports whether entries are written to standard output.
+func stdoutSink(c config.LoggingConfig) bool {
+	return c.Stdout || slices.ContainsFunc(c.Sinks, func(s config.SinkConfig) bool {
+		return s.Type == sink.TypeStdout
+	})
+}
+
+// tlsPolicy parses the version and cipher suite names from the config.
+func tlsPolicy(c config.TLSConfig) (proxy.TLSPolicy, error) {
+	policy := proxy.TLSPolicy{
+		SkipVerifyHosts:  c.SkipVerifyHosts,
+		PassthroughHosts: c.PassthroughHosts,
+	}
+
+	versions := []struct {
+		name string
+		dst  *uint16
+	}{
+		{c.ClientMinVersion, &policy.ClientMinVersion},
+		{c.ClientMaxVersion, &policy.ClientMaxVersion},
+		{c.UpstreamMinVersion, &policy.UpstreamMinVersion},
+		{c.UpstreamMaxVersion, &policy.UpstreamMaxVersion},
+	}
+	for _, v := range versions {
+		parsed, err := proxy.ParseTLSVersion(v.name)
+		if err != nil {
+			return policy, err
+		}
+		*v.dst = parsed
+	}
+
+	var err error
+	if policy.Fingerprint, err = proxy.ParseFingerprint(c.UpstreamFingerprint); err != nil {
+		return policy, err
+	}
+	if policy.ClientCipherSuites, err = proxy.ParseCipherSuites(c.ClientCipherSuites); err != nil {
+		return policy, err
+	}
+	if policy.UpstreamCipherSuites, err = proxy.ParseCipherSuites(c.UpstreamCipherSuites); err != nil {
+		return policy, err
+	}
+	for _, cc := range c.UpstreamClientCerts {
+		cert, err := tls.LoadX509KeyPair(cc.CertPath, cc.KeyPath)
+		if err != nil {
+			return policy, fmt.Errorf("tls.upstream_client_certs: %w", err)
+		}
+		if cert.Leaf != nil && time.Now().After(cert.Leaf.NotAfter) {
+			fmt.Fprintf(os.Stderr, "Client certificate %s expired on %s\n", cc.CertPath, cert.Leaf.NotAfter.Format(time.DateOnly))
+		}
+		policy.ClientCerts = append(policy.ClientCerts, proxy.ClientCert{Hosts: cc.Hosts, Certificate: cert})
+	}
+
+	return policy, nil
+}
+
+// openKeyLog opens the key log at path, or at SSLKEYLOGFILE when path is
+// empty, for appending. It returns nil when neither is set.
+func openKeyLog(path string) (*os.File, error) {
+	if path == "" {
+		path = os.Getenv("SSLKEYLOGFILE")
+	}
+	if path == "" {
+		return nil, nil
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(os.Stderr, "Writing TLS session keys to %s; anyone who can read it can decrypt captured traffic\n", path)
+	return f, nil
+}
+
+func seconds(n int) time.Duration {
+	return time.Duration(n) * time.Second
+}
+
+// loadConfig merges defaults, config.json and bound flags into the effective
+// configuration shared by all commands.
+func loadConfig() (*config.Config, error) {
+	// Set defaults
+	defaultConfig := config.DefaultConfig()
+	viper.SetDefault("proxy.port", defaultConfig.Proxy.Port)
+	viper.SetDefault("proxy.host", defaultConfig.Proxy.Host)
+	viper.SetDefault("proxy.listen", defaultConfig.Proxy.Listen)
+	viper.SetDefault("proxy.mode", defaultConfig.Proxy.Mode)
+	viper.SetDefault("proxy.cert_path", defaultConfig.Proxy.CertPath)
+	viper.SetDefault("proxy.key_path", defaultConfig.Proxy.KeyPath)
+	viper.SetDefault("proxy.client_ca", defaultConfig.Proxy.ClientCA)
+	viper.SetDefault("proxy.retry_bind", defaultConfig.Proxy.RetryBind)
+	viper.SetDefault("proxy.addr_file", defaultConfig.Proxy.AddrFile)
+	viper.SetDefault("proxy.timeout", defaultConfig.Proxy.Timeout)
+	viper.SetDefault("proxy.dial_timeout", defaultConfig.Proxy.DialTimeout)
+	viper.SetDefault("proxy.tls_handshake_timeout", defaultConfig.Proxy.TLSHandshakeTimeout)
+	viper.SetDefault("proxy.response_header_timeout", defaultConfig.Proxy.ResponseHeaderTimeout)
+	viper.SetDefault("proxy.idle_timeout", defaultConfig.Proxy.IdleTimeout)
+	viper.SetDefault("proxy.request_id_header", defaultConfig.Proxy.RequestIDHeader)
+	viper.SetDefault("proxy.shutdown_timeout", defaultConfig.Proxy.ShutdownTimeout)
+	viper.SetDefault("proxy.proxy_protocol", defaultConfig.Proxy.ProxyProtocol)
+	viper.SetDefault("proxy.proxy_protocol_trusted", defaultConfig.Proxy.ProxyProtocolTrusted)
+	viper.SetDefault("proxy.strip_alt_svc", defaultConfig.Proxy.StripAltSvc)
+	viper.SetDefault("proxy.hsts", defaultConfig.Proxy.HSTS)
+	viper.SetDefault("proxy.bind", defaultConfig.Proxy.Bind)
+	viper.SetDefault("proxy.dial.family", defaultConfig.Proxy.Dial.Family)
+	viper.SetDefault("proxy.dial.happy_eyeballs", defaultConfig.Proxy.Dial.HappyEyeballs)
+	viper.SetDefault("proxy.dial.fallback_delay_ms", defaultConfig.Proxy.Dial.FallbackDelayMS)
+	viper.SetDefault("proxy.dial.resolvers", defaultConfig.Proxy.Dial.Resolvers)
+	viper.SetDefault("proxy.limits.max_connections", defaultConfig.Proxy.Limits.MaxConnections)
+	viper.SetDefault("proxy.limits.max_connections_per_client", defaultConfig.Proxy.Limits.MaxConnectionsPerClient)
+	viper.SetDefault("proxy.limits.requests_per_second", defaultConfig.Proxy.Limits.RequestsPerSecond)
+	viper.SetDefault("proxy.limits.request_burst", defaultConfig.Proxy.Limits.RequestBurst)
+	viper.SetDefault("proxy.limits.rate_policy", defaultConfig.Proxy.Limits.RatePolicy)
+	viper.SetDefault("proxy.limits.bandwidth_bytes_per_second", defaultConfig.Proxy.Limits.BandwidthBytesPerSecond)
+	viper.SetDefault("proxy.limits.body_memory_bytes", defaultConfig.Proxy.Limits.BodyMemoryBytes)
+	viper.SetDefault("proxy.limits.body_spill_dir", defaultConfig.Proxy.Limits.BodySpillDir)
+	viper.SetDefault("proxy.upstream_proxy.url", defaultConfig.Proxy.UpstreamProxy.URL)
+	viper.SetDefault("proxy.upstream_proxy.auth", defaultConfig.Proxy.UpstreamProxy.Auth)
+	viper.SetDefault("proxy.upstream_proxy.username", defaultConfig.Proxy.UpstreamProxy.Username)
+	viper.SetDefault("proxy.upstream_proxy.password", defaultConfig.Proxy.UpstreamProxy.Password)
+	viper.SetDefault("proxy.upstream_proxy.no_proxy", defaultConfig.Proxy.UpstreamProxy.NoProxy)
+	viper.SetDefault("proxy.upstream_proxy.kerberos.config", defaultConfig.Proxy.UpstreamProxy.Kerberos.Config)
+	viper.SetDefault("proxy.upstream_proxy.kerberos.keytab", defaultConfig.Proxy.UpstreamProxy.Kerberos.Keytab)
+	viper.SetDefault("proxy.upstream_proxy.kerberos.ccache", defaultConfig.Proxy.UpstreamProxy.Kerberos.CCache)
+	viper.SetDefault("proxy.upstream_proxy.kerberos.spn", defaultConfig.Proxy.UpstreamProxy.Kerberos.SPN)
+	viper.SetDefault("listeners", defaultConfig.Listeners)
+	viper.SetDefault("certificate.auto_generate", defaultConfig.Certificate.AutoGenerate)
+	viper.SetDefault("certificate.organization", defaultConfig.Certificate.Organization)
+	viper.SetDefault("certificate.common_name", defaultConfig.Certificate.CommonName)
+	viper.SetDefault("certificate.valid_days", defaultConfig.Certificate.ValidDays)
+	viper.SetDefault("certificate.cert_path", defaultConfig.Certificate.CertPath)
+	viper.SetDefault("certificate.key_path", defaultConfig.Certificate.KeyPath)
+	viper.SetDefault("certificate.key_perm", defaultConfig.Certificate.KeyPerm)
+	viper.SetDefault("certificate.key_passphrase", defaultConfig.Certificate.KeyPassphrase)
+	viper.SetDefault("certificate.encrypt_key", defaultConfig.Certificate.EncryptKey)
+	viper.SetDefault("certificate.cache_size", defaultConfig.Certificate.CacheSize)
+	viper.SetDefault("certificate.key_pool_size", defaultConfig.Certificate.KeyPoolSize)
+	viper.SetDefault("certificate.warm_hosts", defaultConfig.Certificate.WarmHosts)
+	viper.SetDefault("certificate.stateless", defaultConfig.Certificate.Stateless)
+	viper.SetDefault("logging.session_dir", defaultConfig.Logging.SessionDir)
+	viper.SetDefault("logging.resume_last_session", defaultConfig.Logging.ResumeLastSession)
+	viper.SetDefault("logging.rotate_size", defaultConfig.Logging.RotateSize)
+	viper.SetDefault("logging.rotate_interval", defaultConfig.Logging.RotateInterval)
+	viper.SetDefault("logging.rotate_compress", defaultConfig.Logging.RotateCompress)
+	viper.SetDefault("logging.async", defaultConfig.Logging.Async)
+	viper.SetDefault("logging.queue_size", defaultConfig.Logging.QueueSize)
+	viper.SetDefault("logging.queue_policy", defaultConfig.Logging.QueuePolicy)
+	viper.SetDefault("logging.sync_interval", defaultConfig.Logging.SyncInterval)
+	viper.SetDefault("logging.session_file", defaultConfig.Logging.SessionFile)
+	viper.SetDefault("logging.sinks", defaultConfig.Logging.Sinks)
+	viper.SetDefault("logging.stdout", defaultConfig.Logging.Stdout)
+	viper.SetDefault("logging.degrade.bodies_at", defaultConfig.Logging.Degrade.BodiesAt)
+	viper.SetDefault("logging.degrade.headers_at", defaultConfig.Logging.Degrade.HeadersAt)
+	viper.SetDefault("logging.degrade.sample_at", defaultConfig.Logging.Degrade.SampleAt)
+	viper.SetDefault("logging.degrade.sample_every", defaultConfig.Logging.Degrade.SampleEvery)
+	viper.SetDefault("logging.log_requests", defaultConfig.Logging.LogRequests)
+	viper.SetDefault("logging.log_responses", defaultConfig.Logging.LogResponses)
+	viper.SetDefault("logging.log_headers", defaultConfig.Logging.LogHeaders)
+	viper.SetDefault("logging.log_body", defaultConfig.Logging.LogBody)
+	viper.SetDefault("logging.max_body_size", defaultConfig.Logging.MaxBodySize)
+	viper.SetDefault("logging.log_sse_events", defaultConfig.Logging.LogSSEEvents)
+	viper.SetDefault("logging.log_registry_blobs", defaultConfig.Logging.LogRegistryBlobs)
+	viper.SetDefault("logging.log_server_certs", defaultConfig.Logging.LogServerCerts)
+	viper.SetDefault("logging.log_tunnels", defaultConfig.Logging.LogTunnels)
+	viper.SetDefault("logging.tunnel_sample_size", defaultConfig.Logging.TunnelSampleSize)
+	viper.SetDefault("logging.redact_s3_signatures", defaultConfig.Logging.RedactS3Signatures)
+	viper.SetDefault("tls.client_min_version", defaultConfig.TLS.ClientMinVersion)
+	viper.SetDefault("tls.client_max_version", defaultConfig.TLS.ClientMaxVersion)
+	viper.SetDefault("tls.client_cipher_suites", defaultConfig.TLS.ClientCipherSuites)
+	viper.SetDefault("tls.upstream_min_version", defaultConfig.TLS.UpstreamMinVersion)
+	viper.SetDefault("tls.upstream_max_version", defaultConfig.TLS.UpstreamMaxVersion)
+	viper.SetDefault("tls.upstream_cipher_suites", defaultConfig.TLS.UpstreamCipherSuites)
+	viper.SetDefault("tls.skip_verify_hosts", defaultConfig.TLS.SkipVerifyHosts)
+	viper.SetDefault("tls.upstream_fingerprint", defaultConfig.TLS.UpstreamFingerprint)
+	viper.SetDefault("tls.upstream_client_certs", defaultConfig.TLS.UpstreamClientCerts)
+	viper.SetDefault("tls.key_log_file", defaultConfig.TLS.KeyLogFile)
+	viper.SetDefault("tls.passthrough_hosts", defaultConfig.TLS.PassthroughHosts)
+	viper.SetDefault("rules.files", defaultConfig.Rules.Files)
+	viper.SetDefault("headers.profiles", defaultConfig.Headers.Profiles)
+	viper.SetDefault("headers.global", defaultConfig.Headers.Global)
+	viper.SetDefault("dns.overrides", defaultConfig.DNS.Overrides)
+	viper.SetDefault("dns.server", defaultConfig.DNS.Server)
+	viper.SetDefault("cache.enabled", defaultConfig.Cache.Enabled)
+	viper.SetDefault("cache.dir", defaultConfig.Cache.Dir)
+	viper.SetDefault("cache.max_entries", defaultConfig.Cache.MaxEntries)
+	viper.SetDefault("cache.max_body_size", defaultConfig.Cache.MaxBodySize)
+	viper.SetDefault("cache.ignore_cache_control", defaultConfig.Cache.IgnoreCacheControl)
+	viper.SetDefault("cache.offline", defaultConfig.Cache.Offline)
+	viper.SetDefault("cache.sessions", defaultConfig.Cache.Sessions)
+	viper.SetDefault("findings.enabled", defaultConfig.Findings.Enabled)
+	viper.SetDefault("findings.disable", defaultConfig.Findings.Disable)
+	viper.SetDefault("sensitive.enabled", defaultConfig.Sensitive.Enabled)
+	viper.SetDefault("sensitive.disable", defaultConfig.Sensitive.Disable)
+	viper.SetDefault("sensitive.detectors", defaultConfig.Sensitive.Detectors)
+	viper.SetDefault("sensitive.warn", defaultConfig.Sensitive.Warn)
+	viper.SetDefault("scope.include", defaultConfig.Scope.Include)
+	viper.SetDefault("scope.exclude", defaultConfig.Scope.Exclude)
+	viper.SetDefault("protobuf.descriptors", defaultConfig.Protobuf.Descriptors)
+	viper.SetDefault("protobuf.messages", defaultConfig.Protobuf.Messages)
+	viper.SetDefault("validation.spec", defaultConfig.Validation.Spec)
+	viper.SetDefault("validation.responses", defaultConfig.Validation.Responses)
+	viper.SetDefault("validation.reject", defaultConfig.Validation.Reject)
+	viper.SetDefault("pages.enabled", defaultConfig.Pages.Enabled)
+	viper.SetDefault("pages.dir", defaultConfig.Pages.Dir)
+	viper.SetDefault("pages.trust_host", defaultConfig.Pages.TrustHost)
+	viper.SetDefault("plugins.enabled", defaultConfig.Plugins.Enabled)
+	viper.SetDefault("plugins.dir", defaultConfig.Plugins.Dir)
+	viper.SetDefault("plugins.timeout_ms", defaultConfig.Plugins.TimeoutMS)
+	viper.SetDefault("plugins.max_body_size", defaultConfig.Plugins.MaxBodySize)
+	viper.SetDefault("admin.enabled", defaultConfig.Admin.Enabled)
+	viper.SetDefault("admin.host", defaultConfig.Admin.Host)
+	viper.SetDefault("admin.port", defaultConfig.Admin.Port)
+	viper.SetDefault("admin.token", defaultConfig.Admin.Token)
+	viper.SetDefault("admin.share_listen", defaultConfig.Admin.ShareListen)
+	viper.SetDefault("admin.share_url", defaultConfig.Admin.ShareURL)
+	viper.SetDefault("admin.live_preview", defaultConfig.Admin.LivePreview)
+	viper.SetDefault("admin.grpc_port", defaultConfig.Admin.GRPCPort)
+	viper.SetDefault("admin.debug", defaultConfig.Admin.Debug)
+	viper.SetDefault("export.templates", defaultConfig.Export.Templates)
+	viper.SetDefault("display.timezone", defaultConfig.Display.Timezone)
+	viper.SetDefault("display.locale", defaultConfig.Display.Locale)
+	viper.SetDefault("display.no_color", defaultConfig.Display.NoColor)
+	viper.SetDefault("pac.enabled", defaultConfig.PAC.Enabled)
+	viper.SetDefault("pac.proxy", defaultConfig.PAC.Proxy)
+	viper.SetDefault("pac.ignore_hosts", defaultConfig.PAC.IgnoreHosts)
+	viper.SetDefault("webhook.url", defaultConfig.Webhook.URL)
+	viper.SetDefault("webhook.headers", defaultConfig.Webhook.Headers)
+	viper.SetDefault("webhook.include_file", defaultConfig.Webhook.IncludeFile)
+	viper.SetDefault("webhook.location_prefix", defaultConfig.Webhook.LocationPrefix)
+	viper.SetDefault("webhook.timeout", defaultConfig.Webhook.Timeout)
+	viper.SetDefault("webhook.retries", defaultConfig.Webhook.Retries)
+	viper.SetDefault("tracing.endpoint", defaultConfig.Tracing.Endpoint)
+	viper.SetDefault("tracing.headers", defaultConfig.Tracing.Headers)
+	viper.SetDefault("tracing.service_name", defaultConfig.Tracing.ServiceName)
+	viper.SetDefault("tracing.propagate", defaultConfig.Tracing.Propagate)
+	viper.SetDefault("tracing.sample_ratio", defaultConfig.Tracing.SampleRatio)
+	viper.SetDefault("sidecar.enabled", defaultConfig.Sidecar.Enabled)
+	viper.SetDefault("sidecar.pod_info_dir", defaultConfig.Sidecar.PodInfoDir)
+	viper.SetDefault("sidecar.ca_secret", defaultConfig.Sidecar.CASecret)
+	viper.SetDefault("sidecar.ports", defaultConfig.Sidecar.Ports)
+	viper.SetDefault("sidecar.proxy_uid", defaultConfig.Sidecar.ProxyUID)
+	viper.SetDefault("sidecar.exclude_cidrs", defaultConfig.Sidecar.ExcludeCIDRs)
+	viper.SetDefault("environments.file", defaultConfig.Environments.File)
+	viper.SetDefault("environments.active", defaultConfig.Environments.Active)
+	if docker, _ := strconv.ParseBool(os.Getenv(dockerEnv)); docker {
+		useDockerDefaults()
+	}
+
+	viper.SetConfigName("config")
+	viper.SetConfigType("json")
+	viper.AddConfigPath(".")
+	if err := viper.ReadInConfig(); err != nil {
+		if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
+			return nil, err
+		}
+		// Config file not found; ignore error if desired or warn user
+		fmt.Fprintln(os.Stderr, "No config file found, using defaults and flags")
+	}
+	if err := applyProfile(viper.GetString("profile")); err != nil {
+		return nil, err
+	}
+
+	var cfg config.Config
+	if err := viper.Unmarshal(&cfg); err != nil {
+		return nil, err
+	}
+	if err := filter.Saved(cfg.Filters).Check(); err != nil {
+		return nil, fmt.Errorf("filters: %w", err)
+	}
+	if cfg.Display.NoColor {
+		os.Setenv("NO_COLOR", "1")
+	}
+
+	return &cfg, nil
+}
+
+// applyProfile merges the block of the named profile in the config file's
+// profiles over the rest of the file. Flags still take precedence. Unless
+// the profile sets its own session directory, its sessions are kept in a
+// subdirectory named after it.
+func applyProfile(name string) error {
+	if name == "" && !viper.IsSet("profile") {
+		return nil
+	}
+	if err := checkProfileName(name); err != nil {
+		return err
+	}
+	profiles := viper.GetStringMap("profiles")
+	// Viper lowercases keys.
+	block, ok := profiles[strings.ToLower(name)]
+	if !ok {
+		names := slices.Sorted(maps.Keys(profiles))
+		if len(names) == 0 {
+			return fmt.Errorf("unknown profile %q: the config file defines no profiles", name)
+		}
+		return fmt.Errorf("unknown profile %q (defined: %s)", name, strings.Join(names, ", "))
+	}
+	settings, ok := block.(map[string]any)
+	if !ok {
+		return fmt.Errorf("profile %q must be an object", name)
+	}
+	sessionDir := viper.GetString("logging.session_dir")
+	if err := viper.MergeConfigMap(settings); err != nil {
+		return fmt.Errorf("profile %q: %w", name, err)
+	}
+	if logging, ok := settings["logging"].(map[string]any); !ok || logging["session_dir"] == nil {
+		viper.Set("logging.session_dir", filepath.Join(sessionDir, name))
+	}
+	return nil
+}
+
+// checkProfileName reports an error if name could not be a directory
+// within the session directory.
+func checkProfileName(name string) error {
+	if strings.TrimSpace(name) == "" || name == "." || strings.Contains(name, "..") || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("invalid profile name %q: it names a subdirectory of logging.session_dir, so it cannot be empty or contain path separators or \"..\"", name)
+	}
+	return nil
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.ma=in(). It only needs to happen once to the rootCmd.
 func Execute() {
-	rootCmd.AddCommand(startCmd)
+	rootCmd.AddCommand(startCmd, captureCmd, assertCmd, sessionsCmd, certCmd, mockCmd, doctorCmd, rulesCmd, statsCmd, diffCmd, tailCmd, extractCmd, importCmd, benchCmd, debugCmd, cookiesCmd, envCmd, sidecarCmd, systemProxyCmd, sendCmd, fuzzCmd, findingsCmd, jwtCmd, playbackCmd, configCmd)
 	if err := fang.Execute(context.Background(), rootCmd); err != nil {
 		os.Exit(1)
 	}
@@ -132,7 +1276,29 @@ func init() {
 	rootCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 	startCmd.Flags().IntP("port", "p", 8080, "Port for proxy server")
 	startCmd.Flags().String("host", "127.0.0.1", "Host for proxy server")
+	startCmd.Flags().Bool("offline", false, "Answer only from the response cache")
+	startCmd.Flags().Int("retry-bind", 0, "Keep retrying for up to this many seconds while the port is in use")
+	startCmd.Flags().String("addr-file", "", "Write the address the proxy listens on to this file, e.g. with --port 0")
+	startCmd.Flags().Bool("no-color", false, "Print no colors, as with NO_COLOR")
+	startCmd.Flags().Bool("log-json", false, "Write session entries to stdout as JSON lines and messages to stderr")
+	startCmd.Flags().Bool("stateless-certs", false, "Take the CA from ROGUE_CA_CERT and ROGUE_CA_KEY or generate one in memory, never writing to disk")
+
+	rootCmd.PersistentFlags().String("timezone", "", "Time zone for displayed times, e.g. UTC or Europe/Berlin (default: as recorded)")
+	rootCmd.PersistentFlags().String("profile", "", "Use a named profile from the config file's profiles")
+	rootCmd.PersistentFlags().String("locale", "", "Locale for displayed numbers, e.g. de-DE (default: from the environment)")
+	rootCmd.PersistentFlags().String("env", "", "Environment whose variables rules and replayed requests use (default: "+envVar+" or rogue env use)")
 
 	viper.BindPFlag("proxy.port", startCmd.Flags().Lookup("port"))
 	viper.BindPFlag("proxy.host", startCmd.Flags().Lookup("host"))
+	viper.BindPFlag("cache.offline", startCmd.Flags().Lookup("offline"))
+	viper.BindPFlag("proxy.retry_bind", startCmd.Flags().Lookup("retry-bind"))
+	viper.BindPFlag("proxy.addr_file", startCmd.Flags().Lookup("addr-file"))
+	viper.BindPFlag("display.no_color", startCmd.Flags().Lookup("no-color"))
+	viper.BindPFlag("logging.stdout", startCmd.Flags().Lookup("log-json"))
+	viper.BindPFlag("certificate.stateless", startCmd.Flags().Lookup("stateless-certs"))
+	viper.BindPFlag("display.timezone", rootCmd.PersistentFlags().Lookup("timezone"))
+	viper.BindPFlag("display.locale", rootCmd.PersistentFlags().Lookup("locale"))
+	viper.BindPFlag("profile", rootCmd.PersistentFlags().Lookup("profile"))
+	viper.BindPFlag("environments.active", rootCmd.PersistentFlags().Lookup("env"))
+	viper.BindEnv("environments.active", envVar)
 }
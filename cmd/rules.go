@@ -0,0 +1,343 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/standrze/rogue/internal/config"
+	"github.com/standrze/rogue/internal/logger"
+	"github.com/standrze/rogue/internal/rules"
+	"go.yaml.in/yaml/v3"
+)
+
+// defaultRulesFile is written to when rules.files is empty.
+const defaultRulesFile = "rules.yaml"
+
+var rulesCmd = &cobra.Command{
+	Use:   "rules",
+	Short: "Create and inspect request rules",
+}
+
+var rulesCreateCmd = &cobra.Command{
+	Use:   "create <session> <request-id>",
+	Short: "Build a rule from a recorded flow, step by step",
+	Long: `Walk through creating a rule from a recorded flow: choose which parts of the
+request to match, choose the action, and preview the rule against the captured
+exchange. The rule is appended to the rules file (--file, or the first of
+rules.files) and, when the admin server is enabled, to the rules of the running
+proxy.`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+
+		path, err := resolveSession(cfg, args[0])
+		if err != nil {
+			return err
+		}
+		flows, err := logger.LoadFlows(path)
+		if err != nil {
+			return err
+		}
+		var flow *logger.Flow
+		for _, f := range flows {
+			if f.ID == args[1] {
+				flow = f
+			}
+		}
+		if flow == nil || flow.Request == nil {
+			return fmt.Errorf("no request %s in %s", args[1], path)
+		}
+
+		file, _ := cmd.Flags().GetString("file")
+		if file == "" {
+			file = defaultRulesFile
+			if len(cfg.Rules.Files) > 0 {
+				file = cfg.Rules.Files[0]
+			}
+		}
+		// The rule is checked against the files as written.
+		set, err := loadRules(cfg.Rules.Files, nil)
+		if err != nil {
+			return err
+		}
+
+		w := &wizard{in: bufio.NewReader(cmd.InOrStdin()), out: cmd.OutOrStdout()}
+		rule, ok, err := w.build(exchange(flow), set, file)
+		if err != nil || !ok {
+			return err
+		}
+
+		if err := rules.Append(file, rule); err != nil {
+			return err
+		}
+		fmt.Fprintf(w.out, "Added %q to %s\n", rule.Name, file)
+		if !inRulesFiles(cfg, file) {
+			fmt.Fprintf(w.out, "Add %s to rules.files to load it at startup\n", file)
+		}
+
+		if cfg.Admin.Enabled {
+			if err := addLiveRule(cfg.Admin, rule); err != nil {
+				fmt.Fprintf(w.out, "Not applied to a running proxy: %v\n", err)
+			} else {
+				fmt.Fprintln(w.out, "Applied to the running proxy")
+			}
+		}
+		return nil
+	},
+}
+
+// exchange converts a logged flow for the rule builder.
+func exchange(f *logger.Flow) rules.Exchange {
+	ex := rules.Exchange{Method: f.Request.Method, URL: f.Request.URL}
+	if f.Response != nil {
+		ex.Status = f.Response.StatusCode
+		ex.Headers = f.Response.Headers
+		if body, err := f.Response.RawBody(); err == nil {
+			ex.Body = string(body)
+		}
+		ex.Truncated = f.Response.Truncated
+	}
+	return ex
+}
+
+func inRulesFiles(cfg *config.Config, file string) bool {
+	for _, f := range cfg.Rules.Files {
+		if f == file {
+			return true
+		}
+	}
+	return false
+}
+
+// addLiveRule appends rule to the rules of the proxy whose admin server
+// is described by c.
+func addLiveRule(c config.AdminConfig, rule rules.Rule) error {
+	body, err := json.Marshal(rule)
+	if err != nil {
+		return err
+	}
+	res, err := adminRequest(c, http.MethodPost, "/rules", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	return adminStatus(res, http.StatusCreated)
+}
+
+// adminRequest sends a request to the admin server described by c, with
+// its token if one is set.
+func adminRequest(c config.AdminConfig, method, path string, body io.Reader) (*http.Response, error) {
+	return adminRequestTimeout(c, method, path, body, 5*time.Second)
+}
+
+// adminRequestTimeout is adminRequest for requests that may take longer,
+// such as profiles.
+func adminRequestTimeout(c config.AdminConfig, method, path string, body io.Reader, timeout time.Duration) (*http.Response, error) {
+	u := "http://" + net.JoinHostPort(c.Host, strconv.Itoa(c.Port)) + path
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	client := &http.Client{Timeout: timeout}
+	return client.Do(req)
+}
+
+// adminStatus turns an unexpected admin server response into an error.
+func adminStatus(res *http.Response, want int) error {
+	if res.StatusCode != want {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("%s: %s %s", res.Request.URL, res.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// wizard asks the questions of rules create.
+type wizard struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+// ask prints question with its default and returns the answer, or def
+// for an empty one.
+func (w *wizard) ask(question, def string) (string, error) {
+	if def != "" {
+		fmt.Fprintf(w.out, "%s [%s]: ", question, def)
+	} else {
+		fmt.Fprintf(w.out, "%s: ", question)
+	}
+	line, err := w.in.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", err
+	}
+	if line = strings.TrimSpace(line); line == "" {
+		return def, nil
+	}
+	return line, nil
+}
+
+func (w *wizard) confirm(question string) (bool, error) {
+	a, err := w.ask(question+" (y/n)", "y")
+	if err != nil {
+		return false, err
+	}
+	return strings.EqualFold(a, "y") || strings.EqualFold(a, "yes"), nil
+}
+
+// build asks for the rule's match, action and name, previews it and reports
+// whether it should be saved.
+func (w *wizard) build(ex rules.Exchange, set *rules.Set, file string) (rules.Rule, bool, error) {
+	var rule rules.Rule
+	match, err := rules.MatchExchange(ex)
+	if err != nil {
+		return rule, false, err
+	}
+
+	fmt.Fprintf(w.out, "Building a rule from %s %s\n\n", ex.Method, ex.URL)
+	fmt.Fprintln(w.out, `1. Match: keep each value, edit it (globs and {param} segments are allowed) or enter "*" for any.`)
+	method, err := w.ask("   Method", match.Methods[0])
+	if err != nil {
+		return rule, false, err
+	}
+	if match.Host, err = w.ask("   Host", match.Host); err != nil {
+		return rule, false, err
+	}
+	if match.Path, err = w.ask("   Path", match.Path); err != nil {
+		return rule, false, err
+	}
+	match.Methods = []string{strings.ToUpper(method)}
+	if method == "*" {
+		match.Methods = nil
+	}
+	if match.Host == "*" {
+		match.Host = ""
+	}
+	if match.Path == "*" {
+		match.Path = ""
+	}
+	rule.Match = match
+
+	fmt.Fprintln(w.out, "\n2. Action:")
+	replay, faithful := rules.ReplayMock(ex)
+	if ex.Status != 0 {
+		fmt.Fprintf(w.out, "   1) Replay the captured response (%d %s, %d bytes)\n", ex.Status, http.StatusText(ex.Status), len(ex.Body))
+	} else {
+		fmt.Fprintln(w.out, "   1) Replay the captured response (none was recorded)")
+	}
+	fmt.Fprintln(w.out, "   2) Respond with a custom status and body")
+	choice, err := w.ask("   Choose", "1")
+	if err != nil {
+		return rule, false, err
+	}
+	switch {
+	case choice == "1" && ex.Status != 0:
+		rule.Mock = replay
+		if !faithful {
+			fmt.Fprintln(w.out, "   Note: the recorded body is truncated or content-encoded; edit the rule's body before relying on it.")
+		}
+	case choice == "1", choice == "2":
+		if rule.Mock, err = w.customMock(replay); err != nil {
+			return rule, false, err
+		}
+	default:
+		return rule, false, fmt.Errorf("unknown action %q", choice)
+	}
+
+	if rule.Name, err = w.ask("\n3. Name", rules.DefaultName(rule.Match)); err != nil {
+		return rule, false, err
+	}
+
+	if err := w.preview(rule, ex, set); err != nil {
+		return rule, false, err
+	}
+	ok, err := w.confirm(fmt.Sprintf("Save to %s and apply it", file))
+	return rule, ok, err
+}
+
+func (w *wizard) customMock(captured *rules.Mock) (*rules.Mock, error) {
+	m := &rules.Mock{Headers: map[string]string{}}
+	status, err := w.ask("   Status", "200")
+	if err != nil {
+		return nil, err
+	}
+	if m.Status, err = strconv.Atoi(status); err != nil || http.StatusText(m.Status) == "" {
+		return nil, fmt.Errorf("invalid status %q", status)
+	}
+	contentType, err := w.ask("   Content-Type", cmp.Or(captured.Headers["Content-Type"], "text/plain"))
+	if err != nil {
+		return nil, err
+	}
+	m.Headers["Content-Type"] = contentType
+	if m.Body, err = w.ask("   Body (one line)", ""); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// preview shows the rule and its effect on the captured exchange.
+func (w *wizard) preview(rule rules.Rule, ex rules.Exchange, set *rules.Set) error {
+	data, err := yaml.Marshal(rule)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w.out, "\n4. Preview:\n\n%s\n\n", indent(string(data), "   "))
+
+	req, err := ex.Request()
+	if err != nil {
+		return err
+	}
+	p := set.Preview(rule, req)
+	switch {
+	case !p.Matches:
+		fmt.Fprintln(w.out, "   The rule does NOT match the captured request.")
+	case p.Shadowed != "":
+		fmt.Fprintf(w.out, "   The captured request is already matched by %q, which comes first and will still win.\n", p.Shadowed)
+	default:
+		fmt.Fprintln(w.out, "   The captured request would now be answered with:")
+		fmt.Fprintf(w.out, "\n   HTTP/1.1 %d %s\n", cmp.Or(rule.Mock.Status, http.StatusOK), http.StatusText(cmp.Or(rule.Mock.Status, http.StatusOK)))
+		names := make([]string, 0, len(rule.Mock.Headers))
+		for k := range rule.Mock.Headers {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		for _, k := range names {
+			fmt.Fprintf(w.out, "   %s: %s\n", k, rule.Mock.Headers[k])
+		}
+		body := rule.Mock.Body
+		if len(body) > 500 {
+			body = body[:500] + "..."
+		}
+		fmt.Fprintf(w.out, "\n%s\n", indent(body, "   "))
+	}
+	fmt.Fprintln(w.out)
+	return nil
+}
+
+func indent(s, prefix string) string {
+	lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	return prefix + strings.Join(lines, "\n"+prefix)
+}
+
+func init() {
+	rulesCreateCmd.Flags().StringP("file", "f", "", "Rules file to append to (default: the first of rules.files, or "+defaultRulesFile+")")
+	rulesCmd.AddCommand(rulesCreateCmd)
+}
@@ -0,0 +1,205 @@
+package cmd
+
+import (
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/standrze/rogue/internal/config"
+	"github.com/standrze/rogue/internal/env"
+	"github.com/standrze/rogue/internal/rawhttp"
+)
+
+var sendCmd = &cobra.Command{
+	Use:   "send <file>...",
+	Short: "Send raw HTTP request files through the proxy",
+	Long: `Send requests saved as raw HTTP text, such as Burp Suite request files, through
+the running proxy so they are recorded in its session. Headers are sent exactly
+as written, with their case, order, duplicates and spacing, and lines may end in
+CRLF or LF. A file of "-" is read from standard input.
+
+References to variables such as {{base_url}} are replaced with those of the
+environment in use (see rogue env), or named with --env; --var sets further
+variables or overrides them.
+
+The origin comes from an absolute URL in the request line or from the Host
+header over HTTPS; --target overrides it, for example --target http://127.0.0.1:3000.
+Content-Length is updated to the body as saved unless --keep-length is given.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+
+		_, vars, err := environment(cfg, "")
+		if err != nil {
+			return err
+		}
+		assignments, _ := cmd.Flags().GetStringArray("var")
+		over, err := env.ParseAssignments(assignments)
+		if err != nil {
+			return err
+		}
+		if len(over) > 0 {
+			vars = vars.With(over)
+		}
+
+		var opts rawhttp.Options
+		target, _ := cmd.Flags().GetString("target")
+		if vars != nil {
+			if target, err = vars.Expand(target); err != nil {
+				return fmt.Errorf("--target: %w", err)
+			}
+		}
+		if target != "" {
+			u, err := url.Parse(target)
+			if err != nil || u.Host == "" {
+				return fmt.Errorf("invalid --target %q (want scheme://host[:port])", target)
+			}
+			opts.Scheme, opts.Host = u.Scheme, u.Host
+		}
+		opts.Insecure, _ = cmd.Flags().GetBool("insecure")
+		if direct, _ := cmd.Flags().GetBool("direct"); !direct {
+			if opts.Proxy, err = proxyAddress(cmd, cfg.Proxy); err != nil {
+				return err
+			}
+			useLegacyCA(&cfg.Certificate)
+			if opts.RootCAs, err = proxyRoots(cfg.Certificate.CertPath); err != nil {
+				return err
+			}
+		}
+		keep, _ := cmd.Flags().GetBool("keep-length")
+		headersOnly, _ := cmd.Flags().GetBool("head")
+
+		for _, name := range args {
+			req, err := readRequestFile(name, vars)
+			if err != nil {
+				return err
+			}
+			if !keep {
+				req.FixContentLength()
+			}
+			res, err := rawhttp.Send(cmd.Context(), req, opts)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			err = writeResponse(cmd.OutOrStdout(), res, headersOnly)
+			res.Body.Close()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
+
+// readRequestFile parses a request file, or standard input for "-", with
+// the references to vars expanded when they are not nil.
+func readRequestFile(name string, vars env.Vars) (*rawhttp.Request, error) {
+	var data []byte
+	var err error
+	if name == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if vars != nil {
+		text, err := vars.Expand(string(data))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		data = []byte(text)
+	}
+	req, err := rawhttp.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return req, nil
+}
+
+// proxyAddress returns --proxy, or the TCP address the configured proxy
+// listens on, with wildcard hosts replaced by the loopback address.
+func proxyAddress(cmd *cobra.Command, c config.ProxyConfig) (string, error) {
+	if addr, _ := cmd.Flags().GetString("proxy"); addr != "" {
+		return addr, nil
+	}
+	host, port := c.Host, strconv.Itoa(c.Port)
+	if c.Listen != "" {
+		addr, ok := strings.CutPrefix(c.Listen, "tcp://")
+		if !ok {
+			return "", fmt.Errorf("the proxy listens on %s; give its TCP address with --proxy", c.Listen)
+		}
+		var err error
+		if host, port, err = net.SplitHostPort(addr); err != nil {
+			return "", err
+		}
+	}
+	if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+		host = "127.0.0.1"
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// proxyRoots trusts the proxy's CA as well as the system roots, as the
+// proxy presents its own certificates for intercepted hosts. A CA passed in
+// the environment is preferred to the one at certPath.
+func proxyRoots(certPath string) (*x509.CertPool, error) {
+	data, err := envPEM(caCertEnv)
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		if data, err = os.ReadFile(certPath); err != nil {
+			return nil, fmt.Errorf("reading the CA certificate: %w", err)
+		}
+	}
+	roots, ok := pemRoots(data)
+	if !ok {
+		return nil, fmt.Errorf("no certificates in %s", certPath)
+	}
+	return roots, nil
+}
+
+// pemRoots is the system roots plus the certificates in data. It reports
+// false if data holds none.
+func pemRoots(data []byte) (*x509.CertPool, bool) {
+	roots, err := x509.SystemCertPool()
+	if err != nil {
+		roots = x509.NewCertPool()
+	}
+	return roots, roots.AppendCertsFromPEM(data)
+}
+
+// writeResponse prints the status line and headers of res and, unless
+// headersOnly, its body.
+func writeResponse(w io.Writer, res *http.Response, headersOnly bool) error {
+	fmt.Fprintf(w, "%s %s\n", res.Proto, res.Status)
+	res.Header.Write(w)
+	fmt.Fprintln(w)
+	if headersOnly {
+		return nil
+	}
+	_, err := io.Copy(w, res.Body)
+	return err
+}
+
+func init() {
+	sendCmd.Flags().String("target", "", "Origin to send to, as scheme://host[:port], instead of the request's own")
+	sendCmd.Flags().String("proxy", "", "Proxy address to send through (default: the configured proxy)")
+	sendCmd.Flags().Bool("direct", false, "Send straight to the origin instead of through the proxy")
+	sendCmd.Flags().Bool("keep-length", false, "Send Content-Length as written instead of matching the body")
+	sendCmd.Flags().BoolP("insecure", "k", false, "Do not verify TLS certificates")
+	sendCmd.Flags().BoolP("head", "I", false, "Print only the status line and headers of responses")
+	sendCmd.Flags().StringArray("var", nil, "Set a variable for {{name}} references, as name=value (repeatable)")
+}
@@ -0,0 +1,423 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/standrze/rogue/internal/artifact"
+	"github.com/standrze/rogue/internal/config"
+	"github.com/standrze/rogue/internal/display"
+	"github.com/standrze/rogue/internal/export"
+	"github.com/standrze/rogue/internal/filter"
+	"github.com/standrze/rogue/internal/logger"
+	"github.com/standrze/rogue/internal/scope"
+	"github.com/standrze/rogue/internal/share"
+	"github.com/standrze/rogue/internal/viewer"
+)
+
+var sessionsCmd = &cobra.Command{
+	Use:   "sessions",
+	Short: "Inspect and work with recorded capture sessions",
+}
+
+var sessionsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List recorded sessions",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+
+		sessions, err := logger.ListSessions(cfg.Logging.SessionDir)
+		if err != nil {
+			return err
+		}
+		for _, s := range sessions {
+			fmt.Println(s)
+		}
+		return nil
+	},
+}
+
+var sessionsAnnotateCmd = &cobra.Command{
+	Use:   "annotate <session> <request-id>",
+	Short: "Star a flow or attach an analyst comment or tags to it",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+
+		path, err := resolveSession(cfg, args[0])
+		if err != nil {
+			return err
+		}
+
+		star, _ := cmd.Flags().GetBool("star")
+		comment, _ := cmd.Flags().GetString("comment")
+		tags, _ := cmd.Flags().GetStringArray("tag")
+		if !star && comment == "" && len(tags) == 0 {
+			return fmt.Errorf("nothing to record: pass --star, --comment and/or --tag")
+		}
+		for _, t := range tags {
+			if strings.TrimSpace(t) == "" {
+				return fmt.Errorf("tags cannot be empty")
+			}
+		}
+
+		return logger.AppendEntry(path, "annotation", logger.Annotation{
+			Timestamp: time.Now(),
+			RequestID: args[1],
+			Starred:   star,
+			Comment:   comment,
+			Tags:      tags,
+		})
+	},
+}
+
+var sessionsExportCmd = &cobra.Command{
+	Use:   "export <session>",
+	Short: "Export starred and annotated flows as a Markdown or HTML write-up",
+	Long: `Export starred and annotated flows as a report. --template selects the built-in
+"markdown" or "html" template, a template named in export.templates, or a Go template
+file; see the README for the data passed to templates. --tag keeps only flows with
+one of the given tags, and --where only flows matching a filter expression. Flows
+outside the configured scope are left out unless --all-scope is given.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+
+		path, err := resolveSession(cfg, args[0])
+		if err != nil {
+			return err
+		}
+
+		flows, err := logger.LoadFlows(path)
+		if err != nil {
+			return err
+		}
+
+		f, err := display.New(cfg.Display.Timezone, cfg.Display.Locale)
+		if err != nil {
+			return err
+		}
+		name, _ := cmd.Flags().GetString("template")
+		tmpl, err := export.LoadTemplate(name, cfg.Export.Templates, f)
+		if err != nil {
+			return err
+		}
+
+		out := os.Stdout
+		if name, _ := cmd.Flags().GetString("out"); name != "" {
+			f, err := os.Create(name)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			out = f
+		}
+
+		flows = export.Annotated(flows)
+		if all, _ := cmd.Flags().GetBool("all-scope"); !all {
+			s, err := scope.New(cfg.Scope.Include, cfg.Scope.Exclude)
+			if err != nil {
+				return fmt.Errorf("scope: %w", err)
+			}
+			flows = slices.DeleteFunc(flows, func(f *logger.Flow) bool { return f.Request != nil && !s.ContainsURL(f.Request.URL) })
+		}
+		if tags, _ := cmd.Flags().GetStringArray("tag"); len(tags) > 0 {
+			flows = export.Tagged(flows, tags)
+		}
+		if where, _ := cmd.Flags().GetString("where"); where != "" {
+			expr, err := filter.Saved(cfg.Filters).Parse(where)
+			if err != nil {
+				return err
+			}
+			flows = slices.DeleteFunc(flows, func(f *logger.Flow) bool { return !expr.Match(f) })
+		}
+
+		title := fmt.Sprintf("Investigation notes: %s", filepath.Base(path))
+		return tmpl.Execute(out, title, flows)
+	},
+}
+
+var sessionsServeCmd = &cobra.Command{
+	Use:   "serve <session>",
+	Short: "Serve a read-only web viewer for a recorded session",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+
+		path, err := resolveSession(cfg, args[0])
+		if err != nil {
+			return err
+		}
+
+		flows, err := logger.LoadFlows(path)
+		if err != nil {
+			return err
+		}
+
+		host, _ := cmd.Flags().GetString("host")
+		port, _ := cmd.Flags().GetInt("port")
+		addr := net.JoinHostPort(host, strconv.Itoa(port))
+
+		f, err := display.New(cfg.Display.Timezone, cfg.Display.Locale)
+		if err != nil {
+			return err
+		}
+		v := viewer.New(filepath.Base(path), flows)
+		v.SetFormatter(f)
+		v.SetSavedFilters(cfg.Filters)
+
+		fmt.Printf("Serving %s (%d flows) on http://%s\n", filepath.Base(path), len(flows), addr)
+		return http.ListenAndServe(addr, v.Handler())
+	},
+}
+
+var sessionsTailCmd = &cobra.Command{
+	Use:   "tail <session>",
+	Short: "Print flows as they are recorded",
+	Long: `Print a line per completed flow of a session, following it as the proxy writes
+it until the session is closed or the command is interrupted. With --collapse,
+a flow with the same method, URL and status as one of the last --window rows is
+counted on that row instead of printed, so polling does not drown out other
+traffic.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return followFlows(cmd, args[0])
+	},
+}
+
+var sessionsDownloadsCmd = &cobra.Command{
+	Use:   "downloads <session>",
+	Short: "Stitch ranged downloads into one record per resource",
+	Long: `Group the 206 Partial Content responses of a session by URL and ETag and report,
+for each resource, its size, the bytes fetched, the number of parts and the
+ranges still missing. Sessions must be recorded with headers.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+
+		path, err := resolveSession(cfg, args[0])
+		if err != nil {
+			return err
+		}
+		flows, err := logger.LoadFlows(path)
+		if err != nil {
+			return err
+		}
+
+		artifacts := artifact.Stitch(flows)
+		if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+			enc := json.NewEncoder(cmd.OutOrStdout())
+			enc.SetIndent("", "  ")
+			return enc.Encode(artifacts)
+		}
+		artifact.Write(cmd.OutOrStdout(), artifacts)
+		return nil
+	},
+}
+
+var sessionsTunnelsCmd = &cobra.Command{
+	Use:   "tunnels <session>",
+	Short: "List tunnels relayed without interception",
+	Long: `List the CONNECT tunnels of a session that were passed through or carried
+neither TLS nor HTTP, with their byte counts, duration and TLS server name.
+--hex adds the hex dumps of their first bytes each way. Sessions must be
+recorded with logging.log_tunnels.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+
+		path, err := resolveSession(cfg, args[0])
+		if err != nil {
+			return err
+		}
+		entries, err := logger.ReadSession(path)
+		if err != nil {
+			return err
+		}
+		tunnels, err := logger.Tunnels(entries)
+		if err != nil {
+			return err
+		}
+
+		out := cmd.OutOrStdout()
+		if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+			enc := json.NewEncoder(out)
+			enc.SetIndent("", "  ")
+			return enc.Encode(tunnels)
+		}
+		if len(tunnels) == 0 {
+			fmt.Fprintln(out, "No tunnels")
+			return nil
+		}
+		withHex, _ := cmd.Flags().GetBool("hex")
+		for _, t := range tunnels {
+			fmt.Fprintf(out, "%s  %-11s %s", t.Timestamp.Format(time.TimeOnly), t.Reason, t.Host)
+			if t.SNI != "" {
+				fmt.Fprintf(out, " (SNI %s)", t.SNI)
+			}
+			if t.Error != "" {
+				fmt.Fprintf(out, "  failed: %s\n", t.Error)
+				continue
+			}
+			fmt.Fprintf(out, "  %d bytes up, %d down in %s\n", t.BytesUp, t.BytesDown, time.Duration(t.DurationMS)*time.Millisecond)
+			if withHex {
+				for _, dump := range []struct{ label, hex string }{{"up", t.UpSample}, {"down", t.DownSample}} {
+					if dump.hex != "" {
+						fmt.Fprintf(out, "  %s:\n%s", dump.label, dump.hex)
+					}
+				}
+			}
+		}
+		return nil
+	},
+}
+
+var sessionsShareCmd = &cobra.Command{
+	Use:   "share <session> [filter...]",
+	Short: "Create a password-protected, expiring link to a session",
+	Long: `Create a read-only link to a session on the admin server of the running proxy.
+Filter terms narrow the link to matching flows, as in the viewer's search box:
+method=POST, status=5xx, where=<expression> or free text. Without --password a random one is
+generated and printed. Send the link and the password separately.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+
+		path, err := resolveSession(cfg, args[0])
+		if err != nil {
+			return err
+		}
+		filter, err := viewer.ParseFilter(args[1:], cfg.Filters)
+		if err != nil {
+			return err
+		}
+		password, _ := cmd.Flags().GetString("password")
+		generated := password == ""
+		if generated {
+			b := make([]byte, 12)
+			rand.Read(b)
+			password = base64.RawURLEncoding.EncodeToString(b)
+		}
+		expires, _ := cmd.Flags().GetDuration("expires")
+
+		body, err := json.Marshal(share.Request{
+			Session:  filepath.Base(path),
+			Password: password,
+			Expires:  expires.String(),
+			Filter:   filter,
+		})
+		if err != nil {
+			return err
+		}
+		res, err := adminRequest(cfg.Admin, http.MethodPost, "/shares", bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+		if err := adminStatus(res, http.StatusCreated); err != nil {
+			return err
+		}
+		var link share.Link
+		if err := json.NewDecoder(res.Body).Decode(&link); err != nil {
+			return err
+		}
+
+		out := cmd.OutOrStdout()
+		if link.URL == "" {
+			link.URL = "http://" + net.JoinHostPort(cfg.Admin.Host, strconv.Itoa(cfg.Admin.Port)) + link.Path
+		}
+		fmt.Fprintln(out, link.URL)
+		fmt.Fprintf(out, "Expires %s\n", link.Expires.Local().Format(time.RFC1123))
+		if generated {
+			fmt.Fprintf(out, "Password: %s\n", password)
+		}
+		return nil
+	},
+}
+
+// resolveSession maps a session argument to a file path. It accepts a path,
+// a session name inside the configured session directory, or "latest".
+func resolveSession(cfg *config.Config, name string) (string, error) {
+	if name == "latest" {
+		sessions, err := logger.ListSessions(cfg.Logging.SessionDir)
+		if err != nil {
+			return "", err
+		}
+		if len(sessions) == 0 {
+			return "", fmt.Errorf("no sessions found in %s", cfg.Logging.SessionDir)
+		}
+		sort.Strings(sessions)
+		name = sessions[len(sessions)-1]
+	}
+
+	if _, err := os.Stat(name); err == nil {
+		return name, nil
+	}
+
+	path := filepath.Join(cfg.Logging.SessionDir, name)
+	if _, err := os.Stat(path); err != nil {
+		return "", fmt.Errorf("session %q not found", name)
+	}
+	return path, nil
+}
+
+func init() {
+	sessionsAnnotateCmd.Flags().Bool("star", false, "Star the flow")
+	sessionsAnnotateCmd.Flags().StringP("comment", "m", "", "Analyst comment to attach")
+	sessionsAnnotateCmd.Flags().StringArray("tag", nil, "Tag to attach; may be repeated")
+	sessionsExportCmd.Flags().StringArray("tag", nil, "Only export flows with this tag; may be repeated")
+	sessionsExportCmd.Flags().String("where", "", "Only export flows matching a filter expression")
+	sessionsExportCmd.Flags().StringP("out", "o", "", "Write the report to a file instead of stdout")
+	sessionsExportCmd.Flags().StringP("template", "t", export.FormatMarkdown, "Report template: markdown, html, a name from export.templates or a file")
+	sessionsExportCmd.Flags().Bool("all-scope", false, "Also export flows outside the configured scope")
+
+	sessionsServeCmd.Flags().IntP("port", "p", 9000, "Port for the viewer")
+	sessionsServeCmd.Flags().String("host", "127.0.0.1", "Host for the viewer")
+
+	sessionsDownloadsCmd.Flags().Bool("json", false, "Print the downloads as JSON")
+	sessionsTunnelsCmd.Flags().Bool("json", false, "Print the tunnels as JSON")
+	sessionsTunnelsCmd.Flags().Bool("hex", false, "Print hex dumps of the first bytes sent each way")
+
+	addExtractFlags(sessionsExtractCmd)
+
+	sessionsShareCmd.Flags().String("password", "", "Password for the link (default: a random one, printed)")
+	sessionsShareCmd.Flags().Duration("expires", share.DefaultTTL, "How long the link lasts")
+
+	addTailFlags(sessionsTailCmd)
+
+	sessionsCmd.AddCommand(sessionsListCmd, sessionsAnnotateCmd, sessionsDownloadsCmd, sessionsExportCmd, sessionsExtractCmd, sessionsServeCmd, sessionsShareCmd, sessionsTailCmd, sessionsTunnelsCmd)
+}
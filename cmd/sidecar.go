@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/standrze/rogue/internal/sidecar"
+)
+
+var sidecarCmd = &cobra.Command{
+	Use:   "sidecar",
+	Short: "Run rogue as an egress sidecar in a Kubernetes pod",
+}
+
+var sidecarInitCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Redirect the pod's outbound connections to the proxy with iptables",
+	Long: `Add iptables rules to the pod's network namespace that redirect outbound
+TCP connections to sidecar.ports to the proxy on proxy.port, except those
+made by sidecar.proxy_uid (the user the proxy runs as), to loopback
+addresses and to sidecar.exclude_cidrs.
+
+Run it in an init container with the NET_ADMIN capability, and run the
+proxy with sidecar.enabled as the user sidecar.proxy_uid. --dry-run prints
+the iptables commands instead.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+		rules, err := sidecar.Redirect{
+			ProxyPort:    cfg.Proxy.Port,
+			ProxyUID:     cfg.Sidecar.ProxyUID,
+			Ports:        cfg.Sidecar.Ports,
+			ExcludeCIDRs: cfg.Sidecar.ExcludeCIDRs,
+		}.Rules()
+		if err != nil {
+			return fmt.Errorf("sidecar: %w", err)
+		}
+
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		for _, r := range rules {
+			if dryRun {
+				fmt.Fprintln(cmd.OutOrStdout(), "iptables "+strings.Join(r, " "))
+				continue
+			}
+			out, err := exec.Command("iptables", r...).CombinedOutput()
+			if err != nil {
+				return fmt.Errorf("iptables %s: %w: %s", strings.Join(r, " "), err, strings.TrimSpace(string(out)))
+			}
+		}
+		if !dryRun {
+			fmt.Fprintf(cmd.OutOrStdout(), "Redirecting ports %v to %d\n", cfg.Sidecar.Ports, cfg.Proxy.Port)
+		}
+		return nil
+	},
+}
+
+// secretCA fetches the CA from the kubernetes.io/tls secret name in
+// namespace, using the pod's service account.
+func secretCA(namespace, name string) (certPEM, keyPEM []byte, err error) {
+	if namespace == "" {
+		return nil, nil, fmt.Errorf("the pod's namespace is unknown; set POD_NAMESPACE")
+	}
+	client, err := sidecar.InCluster()
+	if err != nil {
+		return nil, nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if certPEM, keyPEM, err = client.CA(ctx, namespace, name); err != nil {
+		return nil, nil, err
+	}
+	fmt.Fprintf(os.Stderr, "Signing with the CA in secret %s/%s\n", namespace, name)
+	return certPEM, keyPEM, nil
+}
+
+func init() {
+	sidecarInitCmd.Flags().Bool("dry-run", false, "Print the iptables commands instead of running them")
+	sidecarCmd.AddCommand(sidecarInitCmd)
+}
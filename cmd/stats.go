@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/standrze/rogue/internal/display"
+	"github.com/standrze/rogue/internal/logger"
+	"github.com/standrze/rogue/internal/stats"
+)
+
+var statsCmd = &cobra.Command{
+	Use:   "stats <session>",
+	Short: "Print aggregate statistics for a recorded session",
+	Long: `Print request counts per host and status code, bytes sent and received, latency
+percentiles, failure hints by class, redirect chains by length and final
+status, and the slowest and largest exchanges of a session. Latency is the
+time from a request being logged to its response headers being logged.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+
+		path, err := resolveSession(cfg, args[0])
+		if err != nil {
+			return err
+		}
+		entries, err := logger.ReadSession(path)
+		if err != nil {
+			return err
+		}
+		flows, err := logger.BuildFlows(entries)
+		if err != nil {
+			return err
+		}
+
+		top, _ := cmd.Flags().GetInt("top")
+		s := stats.Compute(flows, top)
+		s.CountHints(entries)
+
+		if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(s)
+		}
+
+		f, err := display.New(cfg.Display.Timezone, cfg.Display.Locale)
+		if err != nil {
+			return err
+		}
+		stats.Write(os.Stdout, s, f)
+		return nil
+	},
+}
+
+func init() {
+	statsCmd.Flags().IntP("top", "n", 5, "How many of the slowest and largest exchanges to list")
+	statsCmd.Flags().Bool("json", false, "Print the statistics as JSON")
+}
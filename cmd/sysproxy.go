@@ -0,0 +1,166 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/standrze/rogue/internal/config"
+	"github.com/standrze/rogue/internal/sysproxy"
+)
+
+// systemProxyPoll is how often enable checks that the proxy still runs.
+const systemProxyPoll = 2 * time.Second
+
+var systemProxyCmd = &cobra.Command{
+	Use:   "system-proxy",
+	Short: "Point the operating system's proxy settings at the running proxy",
+}
+
+var systemProxyEnableCmd = &cobra.Command{
+	Use:   "enable",
+	Short: "Send the system's HTTP and HTTPS traffic through the running proxy",
+	Long: `Set the HTTP and HTTPS proxy of the operating system or desktop to the
+running proxy: every enabled network service on macOS, the WinINET settings
+on Windows, or GNOME and KDE settings on Linux.
+
+The settings are saved first and put back once the proxy stops accepting
+connections or this command is interrupted. With --detach they stay until
+rogue system-proxy disable. If this command was killed, the settings are
+restored by disable, or by the next enable or rogue start once the proxy
+they point at is gone. Clients need to trust the CA for HTTPS.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+		addr, err := proxyAddress(cmd, cfg.Proxy)
+		if err != nil {
+			return err
+		}
+		host, p, err := net.SplitHostPort(addr)
+		if err != nil {
+			return err
+		}
+		port, err := strconv.Atoi(p)
+		if err != nil {
+			return err
+		}
+		if !proxyUp(addr) {
+			return fmt.Errorf("no proxy is listening on %s; run rogue start first", addr)
+		}
+
+		platform, err := sysproxy.Detect()
+		if err != nil {
+			return err
+		}
+		stateFile, err := systemProxyState()
+		if err != nil {
+			return err
+		}
+		restoreStaleSystemProxy(cmd.OutOrStdout(), cmd.ErrOrStderr())
+		if err := sysproxy.Enable(platform, sysproxy.Exec, stateFile, host, port); err != nil {
+			// Put back whatever was changed before the failure.
+			sysproxy.Disable(sysproxy.Exec, stateFile)
+			return err
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Sending %s HTTP and HTTPS traffic through %s\n", platform.Name(), addr)
+		if detach, _ := cmd.Flags().GetBool("detach"); detach {
+			return nil
+		}
+
+		ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		tick := time.NewTicker(systemProxyPoll)
+		defer tick.Stop()
+	wait:
+		for {
+			select {
+			case <-ctx.Done():
+				break wait
+			case <-tick.C:
+				if !proxyUp(addr) {
+					fmt.Fprintf(cmd.ErrOrStderr(), "The proxy on %s stopped\n", addr)
+					break wait
+				}
+			}
+		}
+		if _, err := sysproxy.Disable(sysproxy.Exec, stateFile); err != nil {
+			return fmt.Errorf("restoring the proxy settings (retry with rogue system-proxy disable): %w", err)
+		}
+		fmt.Fprintln(cmd.OutOrStdout(), "Restored the previous proxy settings")
+		return nil
+	},
+}
+
+var systemProxyDisableCmd = &cobra.Command{
+	Use:   "disable",
+	Short: "Restore the proxy settings saved by enable",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		stateFile, err := systemProxyState()
+		if err != nil {
+			return err
+		}
+		restored, err := sysproxy.Disable(sysproxy.Exec, stateFile)
+		if err != nil {
+			return err
+		}
+		if !restored {
+			fmt.Fprintln(cmd.OutOrStdout(), "The proxy settings were not changed by rogue")
+			return nil
+		}
+		fmt.Fprintln(cmd.OutOrStdout(), "Restored the previous proxy settings")
+		return nil
+	},
+}
+
+// systemProxyState is where enable saves the settings it replaces.
+func systemProxyState() (string, error) {
+	dir, err := config.DataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "system-proxy.json"), nil
+}
+
+// restoreStaleSystemProxy puts back the settings left behind by an enable
+// that was killed, once the proxy they point at is gone.
+func restoreStaleSystemProxy(out, errOut io.Writer) {
+	stateFile, err := systemProxyState()
+	if err != nil {
+		return
+	}
+	state, err := sysproxy.RestoreStale(sysproxy.Exec, stateFile, proxyUp)
+	if err != nil {
+		fmt.Fprintf(errOut, "Warning: the proxy settings saved in %s could not be restored (retry with rogue system-proxy disable): %v\n", stateFile, err)
+		return
+	}
+	if state != nil {
+		fmt.Fprintf(out, "Restored the %s proxy settings left pointing at %s by an earlier rogue system-proxy enable\n", state.Platform, state.Proxy)
+	}
+}
+
+// proxyUp reports whether something accepts connections on addr.
+func proxyUp(addr string) bool {
+	conn, err := net.DialTimeout("tcp", addr, time.Second)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+func init() {
+	systemProxyEnableCmd.Flags().String("proxy", "", "Address of the proxy (default from proxy.host and proxy.port)")
+	systemProxyEnableCmd.Flags().Bool("detach", false, "Leave the settings in place and exit")
+	systemProxyCmd.AddCommand(systemProxyEnableCmd, systemProxyDisableCmd)
+}
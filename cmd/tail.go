@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/standrze/rogue/internal/display"
+	"github.com/standrze/rogue/internal/logger"
+	"github.com/standrze/rogue/internal/tail"
+	"github.com/standrze/rogue/internal/viewer"
+	"golang.org/x/term"
+)
+
+// followInterval is how often a followed session is checked for new
+// entries.
+const followInterval = 250 * time.Millisecond
+
+var tailCmd = &cobra.Command{
+	Use:   "tail",
+	Short: "Follow the active session, one line per request",
+	Long: `Print a line per completed flow of the active session (or --session) as the
+proxy records it, with status codes colored by class. --filter narrows the
+flows shown and may be repeated:
+
+  --filter method=POST     only POST requests
+  --filter status=5xx      only server errors (or an exact code, status=404)
+  --filter api/orders      only flows whose URL or body contains the text
+  --filter 'where=host =~ "api\\." && status >= 500'
+                           only flows matching a filter expression; @name
+                           refers to an expression saved under filters`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		session, _ := cmd.Flags().GetString("session")
+		return followFlows(cmd, session)
+	},
+}
+
+// followFlows prints the flows of the named session as they are recorded,
+// following the flags added by addTailFlags.
+func followFlows(cmd *cobra.Command, name string) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	path, err := resolveSession(cfg, name)
+	if err != nil {
+		return err
+	}
+
+	terms, _ := cmd.Flags().GetStringArray("filter")
+	flt, err := viewer.ParseFilter(terms, cfg.Filters)
+	if err != nil {
+		return err
+	}
+
+	f, err := display.New(cfg.Display.Timezone, cfg.Display.Locale)
+	if err != nil {
+		return err
+	}
+	collapse := 0
+	if c, _ := cmd.Flags().GetBool("collapse"); c {
+		collapse, _ = cmd.Flags().GetInt("window")
+	}
+	terminal := term.IsTerminal(int(os.Stdout.Fd()))
+	p := tail.New(os.Stdout, f, terminal, collapse)
+	if len(terms) > 0 {
+		p.Match = flt.Match
+	}
+	noColor, _ := cmd.Flags().GetBool("no-color")
+	p.Color = terminal && !noColor && os.Getenv("NO_COLOR") == ""
+	defer p.Flush()
+
+	ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return logger.FollowSession(ctx, path, followInterval, p.Add)
+}
+
+// addTailFlags adds the flags read by followFlows.
+func addTailFlags(c *cobra.Command) {
+	c.Flags().StringArray("filter", nil, "Only show matching flows: method=M, status=404 or status=5xx, where=<expression>, or text to search for")
+	c.Flags().Bool("collapse", false, "Fold repeated identical flows into one row with a counter")
+	c.Flags().Int("window", 10, "How many recent rows --collapse folds repeats into")
+	c.Flags().Bool("no-color", false, "Do not color status codes")
+}
+
+func init() {
+	tailCmd.Flags().String("session", "latest", "Session to follow")
+	addTailFlags(tailCmd)
+}
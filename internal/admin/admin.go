@@ -0,0 +1,111 @@
+// Package admin serves rogue's management endpoints, such as metrics, on a
+// port separate from the proxy.
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Server is the admin HTTP server. Features register their endpoints with
+// Handle before Serve is called. Requests from other sites' pages are
+// refused, so a page the user visits cannot drive the server.
+type Server struct {
+	mux    *http.ServeMux
+	srv    *http.Server
+	token  string
+	public map[string]bool
+	csrf   *http.CrossOriginProtection
+	logins *Lockout
+	// stop cancels the context of every request, ending streams.
+	stop context.CancelFunc
+}
+
+func New() *Server {
+	mux := http.NewServeMux()
+	base, stop := context.WithCancel(context.Background())
+	s := &Server{mux: mux, public: map[string]bool{}, csrf: http.NewCrossOriginProtection(), logins: NewLockout(), stop: stop}
+	s.srv = &http.Server{
+		Handler:           http.HandlerFunc(s.serve),
+		ReadHeaderTimeout: 10 * time.Second,
+		BaseContext:       func(net.Listener) context.Context { return base },
+	}
+	s.HandlePublic("GET /healthz", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok\n"))
+	}))
+	return s
+}
+
+// Handle registers handler for pattern, using http.ServeMux syntax.
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+// HandlePublic registers handler for pattern, served without the token.
+func (s *Server) HandlePublic(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+	s.public[pattern] = true
+}
+
+// SetToken requires requests to all but public endpoints to carry token as
+// a bearer token, or the cookie of a browser logged in with it at /login.
+// An empty token leaves the server open.
+func (s *Server) SetToken(token string) {
+	if token != "" && s.token == "" {
+		s.HandlePublic("GET /login", http.HandlerFunc(s.handleLoginForm))
+		s.HandlePublic("POST /login", http.HandlerFunc(s.handleLogin))
+	}
+	s.token = token
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	if err := s.csrf.Check(r); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if s.token != "" {
+		if _, pattern := s.mux.Handler(r); !s.public[pattern] && !s.authorized(r) {
+			if browsing(r) {
+				http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+				return
+			}
+			w.Header().Set("WWW-Authenticate", `Bearer realm="rogue admin"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) authorized(r *http.Request) bool {
+	if got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return subtle.ConstantTimeCompare([]byte(got), []byte(s.token)) == 1
+	}
+	return s.loggedIn(r)
+}
+
+// Handler returns the server's request multiplexer.
+func (s *Server) Handler() http.Handler {
+	return s.mux
+}
+
+// Serve accepts admin connections on l until Shutdown is called.
+func (s *Server) Serve(l net.Listener) error {
+	err := s.srv.Serve(l)
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+// Shutdown stops the server, waiting for active requests up to ctx. Streams
+// such as the live feed are ended rather than waited for.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.stop()
+	return s.srv.Shutdown(ctx)
+}
@@ -0,0 +1,28 @@
+package admin
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"sync"
+)
+
+var publishOnce sync.Once
+
+// HandleDebug serves the runtime profiles of net/http/pprof under
+// /debug/pprof/ and the variables of expvar, with the goroutine count, at
+// /debug/vars. Both reveal internals of the process and are guarded by the
+// token like every other endpoint.
+func (s *Server) HandleDebug() {
+	publishOnce.Do(func() {
+		expvar.Publish("goroutines", expvar.Func(func() any { return runtime.NumGoroutine() }))
+	})
+	s.Handle("GET /debug/pprof/", http.HandlerFunc(pprof.Index))
+	s.Handle("GET /debug/pprof/cmdline", http.HandlerFunc(pprof.Cmdline))
+	s.Handle("GET /debug/pprof/profile", http.HandlerFunc(pprof.Profile))
+	s.Handle("GET /debug/pprof/symbol", http.HandlerFunc(pprof.Symbol))
+	s.Handle("POST /debug/pprof/symbol", http.HandlerFunc(pprof.Symbol))
+	s.Handle("GET /debug/pprof/trace", http.HandlerFunc(pprof.Trace))
+	s.Handle("GET /debug/vars", expvar.Handler())
+}
@@ -0,0 +1,156 @@
+package admin
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"html/template"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cookieName holds the session of a browser logged in with the token.
+const cookieName = "rogue_admin"
+
+// sessionValue is the cookie proving a login with token. It is derived
+// from the token, so it stays valid across restarts and is invalidated by
+// changing the token.
+func sessionValue(token string) string {
+	m := hmac.New(sha256.New, []byte(token))
+	m.Write([]byte("rogue admin session"))
+	return base64.RawURLEncoding.EncodeToString(m.Sum(nil))
+}
+
+func (s *Server) loggedIn(r *http.Request) bool {
+	c, err := r.Cookie(cookieName)
+	return err == nil && subtle.ConstantTimeCompare([]byte(c.Value), []byte(sessionValue(s.token))) == 1
+}
+
+// browsing reports whether r is a page load by a browser, which is sent to
+// the login page rather than refused.
+func browsing(r *http.Request) bool {
+	return r.Method == http.MethodGet && strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+func (s *Server) handleLoginForm(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	loginPage.Execute(w, map[string]string{"Next": safeNext(r.FormValue("next"))})
+}
+
+func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	next := safeNext(r.PostFormValue("next"))
+	client := ClientIP(r)
+	switch {
+	case !s.logins.Allow(client):
+		http.Error(w, "too many failed attempts, try again later", http.StatusTooManyRequests)
+		return
+	case subtle.ConstantTimeCompare([]byte(r.PostFormValue("token")), []byte(s.token)) != 1:
+		s.logins.Fail(client)
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusUnauthorized)
+		loginPage.Execute(w, map[string]string{"Next": next, "Error": "Wrong token."})
+		return
+	}
+	s.logins.Succeed(client)
+	http.SetCookie(w, &http.Cookie{
+		Name:     cookieName,
+		Value:    sessionValue(s.token),
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
+
+// safeNext keeps redirects after login on the admin server.
+func safeNext(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/shares/new"
+	}
+	return next
+}
+
+// ClientIP returns the address r came from, without the port.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Lockout refuses clients after repeated failed password attempts, so
+// passwords cannot be guessed at speed. It is safe for concurrent use.
+type Lockout struct {
+	// Max failures are allowed within Window; a client that reaches it is
+	// refused until Window has passed since its first failure.
+	Max    int
+	Window time.Duration
+
+	mu      sync.Mutex
+	clients map[string]*failures
+}
+
+type failures struct {
+	n     int
+	first time.Time
+}
+
+// NewLockout allows five failures a minute.
+func NewLockout() *Lockout {
+	return &Lockout{Max: 5, Window: time.Minute}
+}
+
+// Allow reports whether client may make another attempt.
+func (l *Lockout) Allow(client string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	f := l.clients[client]
+	return f == nil || f.n < l.Max || time.Since(f.first) >= l.Window
+}
+
+// Fail records a failed attempt by client.
+func (l *Lockout) Fail(client string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if l.clients == nil {
+		l.clients = map[string]*failures{}
+	}
+	for c, f := range l.clients {
+		if now.Sub(f.first) >= l.Window {
+			delete(l.clients, c)
+		}
+	}
+	f := l.clients[client]
+	if f == nil {
+		f = &failures{first: now}
+		l.clients[client] = f
+	}
+	f.n++
+}
+
+// Succeed forgets the failures of client.
+func (l *Lockout) Succeed(client string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.clients, client)
+}
+
+var loginPage = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Log in · Rogue</title></head>
+<body style="font-family: system-ui, sans-serif; margin: 2rem">
+<h1>Rogue admin</h1>
+{{with .Error}}<p style="color: #b00">{{.}}</p>{{end}}
+<form method="post" action="/login">
+<input type="hidden" name="next" value="{{.Next}}">
+<p><label>Token <input type="password" name="token" required autofocus></label></p>
+<p><button>Log in</button></p>
+</form>
+</body></html>
+`))
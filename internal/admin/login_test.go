@@ -0,0 +1,90 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestLogin(t *testing.T) {
+	s := New()
+	s.SetToken("s3cret")
+	s.Handle("GET /page", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("page"))
+	}))
+	s.Handle("POST /page", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("posted"))
+	}))
+	do := func(req *http.Request) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		s.srv.Handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Browsers are sent to log in; other clients are refused.
+	req := httptest.NewRequest("GET", "/page", nil)
+	req.Header.Set("Accept", "text/html")
+	if rec := do(req); rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/login?next=%2Fpage" {
+		t.Errorf("browser: %d %s", rec.Code, rec.Header().Get("Location"))
+	}
+	if rec := do(httptest.NewRequest("GET", "/page", nil)); rec.Code != http.StatusUnauthorized {
+		t.Errorf("API client: %d", rec.Code)
+	}
+
+	login := func(token, next string) *httptest.ResponseRecorder {
+		form := url.Values{"token": {token}, "next": {next}}
+		req := httptest.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return do(req)
+	}
+	if rec := login("wrong", "/page"); rec.Code != http.StatusUnauthorized || len(rec.Result().Cookies()) != 0 {
+		t.Errorf("wrong token: %d", rec.Code)
+	}
+	rec := login("s3cret", "//evil.example/")
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/shares/new" {
+		t.Errorf("login: %d %s", rec.Code, rec.Header().Get("Location"))
+	}
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || !cookies[0].HttpOnly || cookies[0].SameSite != http.SameSiteStrictMode {
+		t.Fatalf("cookies = %v", cookies)
+	}
+
+	req = httptest.NewRequest("GET", "/page", nil)
+	req.AddCookie(cookies[0])
+	if rec := do(req); rec.Code != http.StatusOK {
+		t.Errorf("logged in: %d", rec.Code)
+	}
+	// Other sites' pages cannot post with the cookie.
+	req = httptest.NewRequest("POST", "/page", nil)
+	req.AddCookie(cookies[0])
+	req.Header.Set("Sec-Fetch-Site", "cross-site")
+	if rec := do(req); rec.Code != http.StatusForbidden {
+		t.Errorf("cross-site post: %d", rec.Code)
+	}
+	req.Header.Set("Sec-Fetch-Site", "same-origin")
+	if rec := do(req); rec.Code != http.StatusOK {
+		t.Errorf("same-origin post: %d", rec.Code)
+	}
+}
+
+func TestLockout(t *testing.T) {
+	l := NewLockout()
+	for range l.Max {
+		if !l.Allow("a") {
+			t.Fatal("refused before the limit")
+		}
+		l.Fail("a")
+	}
+	if l.Allow("a") {
+		t.Error("allowed past the limit")
+	}
+	if !l.Allow("b") {
+		t.Error("other client refused")
+	}
+	l.Succeed("a")
+	if !l.Allow("a") {
+		t.Error("refused after success")
+	}
+}
@@ -0,0 +1,100 @@
+package adminrpc
+
+import (
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	_ "google.golang.org/protobuf/types/known/structpb"
+)
+
+// fileName is the path of the contract in proto/.
+const fileName = "rogue/admin/v1/admin.proto"
+
+// descriptor is proto/rogue/admin/v1/admin.proto as a FileDescriptorProto,
+// since the messages are handled dynamically rather than generated.
+// TestContract keeps the two in step.
+const descriptor = `
+name: "rogue/admin/v1/admin.proto"
+package: "rogue.admin.v1"
+dependency: "google/protobuf/struct.proto"
+syntax: "proto3"
+message_type {
+  name: "Rule"
+  field { name: "name" number: 1 label: LABEL_OPTIONAL type: TYPE_STRING }
+  field { name: "disabled" number: 2 label: LABEL_OPTIONAL type: TYPE_BOOL }
+  field { name: "definition" number: 3 label: LABEL_OPTIONAL type: TYPE_MESSAGE type_name: ".google.protobuf.Struct" }
+}
+message_type {
+  name: "ListRulesRequest"
+}
+message_type {
+  name: "ListRulesResponse"
+  field { name: "rules" number: 1 label: LABEL_REPEATED type: TYPE_MESSAGE type_name: ".rogue.admin.v1.Rule" }
+}
+message_type {
+  name: "CreateRuleRequest"
+  field { name: "rule" number: 1 label: LABEL_OPTIONAL type: TYPE_MESSAGE type_name: ".rogue.admin.v1.Rule" }
+}
+message_type {
+  name: "UpdateRuleRequest"
+  field { name: "name" number: 1 label: LABEL_OPTIONAL type: TYPE_STRING }
+  field { name: "rule" number: 2 label: LABEL_OPTIONAL type: TYPE_MESSAGE type_name: ".rogue.admin.v1.Rule" }
+}
+message_type {
+  name: "DeleteRuleRequest"
+  field { name: "name" number: 1 label: LABEL_OPTIONAL type: TYPE_STRING }
+}
+message_type {
+  name: "DeleteRuleResponse"
+}
+message_type {
+  name: "WatchTrafficRequest"
+  field { name: "preview" number: 1 label: LABEL_OPTIONAL type: TYPE_INT32 }
+  field { name: "types" number: 2 label: LABEL_REPEATED type: TYPE_STRING }
+}
+message_type {
+  name: "TrafficEvent"
+  field { name: "type" number: 1 label: LABEL_OPTIONAL type: TYPE_STRING }
+  field { name: "data" number: 2 label: LABEL_OPTIONAL type: TYPE_MESSAGE type_name: ".google.protobuf.Struct" }
+  field { name: "missed" number: 3 label: LABEL_OPTIONAL type: TYPE_INT32 }
+}
+message_type {
+  name: "GetInterceptionRequest"
+}
+message_type {
+  name: "Interception"
+  field { name: "enabled" number: 1 label: LABEL_OPTIONAL type: TYPE_BOOL }
+  field { name: "passthrough_hosts" number: 2 label: LABEL_REPEATED type: TYPE_STRING }
+}
+service {
+  name: "Admin"
+  method { name: "ListRules" input_type: ".rogue.admin.v1.ListRulesRequest" output_type: ".rogue.admin.v1.ListRulesResponse" }
+  method { name: "CreateRule" input_type: ".rogue.admin.v1.CreateRuleRequest" output_type: ".rogue.admin.v1.Rule" }
+  method { name: "UpdateRule" input_type: ".rogue.admin.v1.UpdateRuleRequest" output_type: ".rogue.admin.v1.Rule" }
+  method { name: "DeleteRule" input_type: ".rogue.admin.v1.DeleteRuleRequest" output_type: ".rogue.admin.v1.DeleteRuleResponse" }
+  method { name: "WatchTraffic" input_type: ".rogue.admin.v1.WatchTrafficRequest" output_type: ".rogue.admin.v1.TrafficEvent" server_streaming: true }
+  method { name: "GetInterception" input_type: ".rogue.admin.v1.GetInterceptionRequest" output_type: ".rogue.admin.v1.Interception" }
+  method { name: "SetInterception" input_type: ".rogue.admin.v1.Interception" output_type: ".rogue.admin.v1.Interception" }
+}
+`
+
+// service describes the Admin service. The file is registered globally so
+// the reflection service can serve it.
+var service protoreflect.ServiceDescriptor
+
+func init() {
+	var fdp descriptorpb.FileDescriptorProto
+	if err := prototext.Unmarshal([]byte(descriptor), &fdp); err != nil {
+		panic(err)
+	}
+	fd, err := protodesc.NewFile(&fdp, protoregistry.GlobalFiles)
+	if err != nil {
+		panic(err)
+	}
+	if err := protoregistry.GlobalFiles.RegisterFile(fd); err != nil {
+		panic(err)
+	}
+	service = fd.Services().ByName("Admin")
+}
@@ -0,0 +1,328 @@
+// Package adminrpc serves the gRPC admin API, rogue.admin.v1.Admin, for
+// rich clients such as desktop and IDE integrations. It covers rule
+// management, a stream of live traffic and TLS interception control. The
+// contract is proto/rogue/admin/v1/admin.proto; the server also answers
+// gRPC reflection, so tools like grpcurl need no local copy.
+package adminrpc
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/standrze/rogue/internal/live"
+	"github.com/standrze/rogue/internal/proxy"
+	"github.com/standrze/rogue/internal/rules"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// Server implements the Admin service over the proxy's live state.
+type Server struct {
+	Rules *rules.Set
+	// Profiles, if set, are the header profiles rules may name.
+	Profiles     rules.Profiles
+	Hub          *live.Hub
+	Interception *proxy.Interception
+	// Token, when set, must be sent as "authorization: Bearer <token>"
+	// metadata with every call.
+	Token string
+}
+
+// GRPC returns a gRPC server serving s and reflection.
+func (s *Server) GRPC() *grpc.Server {
+	g := grpc.NewServer(grpc.UnaryInterceptor(s.authUnary), grpc.StreamInterceptor(s.authStream))
+	g.RegisterService(&grpc.ServiceDesc{
+		ServiceName: string(service.FullName()),
+		HandlerType: (*any)(nil),
+		Methods: []grpc.MethodDesc{
+			unary("ListRules", (*Server).listRules),
+			unary("CreateRule", (*Server).createRule),
+			unary("UpdateRule", (*Server).updateRule),
+			unary("DeleteRule", (*Server).deleteRule),
+			unary("GetInterception", (*Server).getInterception),
+			unary("SetInterception", (*Server).setInterception),
+		},
+		Streams: []grpc.StreamDesc{{
+			StreamName:    "WatchTraffic",
+			ServerStreams: true,
+			Handler: func(srv any, stream grpc.ServerStream) error {
+				return srv.(*Server).watchTraffic(stream)
+			},
+		}},
+		Metadata: fileName,
+	}, s)
+	reflection.Register(g)
+	return g
+}
+
+func (s *Server) authorized(ctx context.Context) error {
+	if s.Token == "" {
+		return nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, v := range md.Get("authorization") {
+		if got, ok := strings.CutPrefix(v, "Bearer "); ok && subtle.ConstantTimeCompare([]byte(got), []byte(s.Token)) == 1 {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "missing or wrong admin token")
+}
+
+func (s *Server) authUnary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if err := s.authorized(ctx); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (s *Server) authStream(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := s.authorized(ss.Context()); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
+
+// unary adapts fn, working on the JSON forms of the method's messages, to
+// a gRPC method.
+func unary[In, Out any](name string, fn func(*Server, In) (Out, error)) grpc.MethodDesc {
+	m := service.Methods().ByName(protoreflect.Name(name))
+	return grpc.MethodDesc{
+		MethodName: name,
+		Handler: func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+			req := dynamicpb.NewMessage(m.Input())
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+			handler := func(ctx context.Context, req any) (any, error) {
+				var in In
+				if err := fromMessage(req.(proto.Message), &in); err != nil {
+					return nil, status.Error(codes.InvalidArgument, err.Error())
+				}
+				out, err := fn(srv.(*Server), in)
+				if err != nil {
+					return nil, err
+				}
+				return toMessage(out, m.Output())
+			}
+			if interceptor == nil {
+				return handler(ctx, req)
+			}
+			info := &grpc.UnaryServerInfo{Server: srv, FullMethod: fmt.Sprintf("/%s/%s", service.FullName(), name)}
+			return interceptor(ctx, req, info, handler)
+		},
+	}
+}
+
+// fromMessage decodes m into v through its JSON form, with field names as
+// in the contract.
+func fromMessage(m proto.Message, v any) error {
+	b, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// toMessage encodes v as a message of type desc through its JSON form.
+func toMessage(v any, desc protoreflect.MessageDescriptor) (proto.Message, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	m := dynamicpb.NewMessage(desc)
+	if err := protojson.Unmarshal(b, m); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return m, nil
+}
+
+// ruleMessage is the JSON form of a Rule message: the name and disabled
+// flag, and the rest of the rule as in rules files.
+type ruleMessage struct {
+	Name       string         `json:"name"`
+	Disabled   bool           `json:"disabled,omitempty"`
+	Definition map[string]any `json:"definition,omitempty"`
+}
+
+func newRuleMessage(r rules.Rule) (ruleMessage, error) {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return ruleMessage{}, err
+	}
+	var def map[string]any
+	if err := json.Unmarshal(b, &def); err != nil {
+		return ruleMessage{}, err
+	}
+	delete(def, "name")
+	delete(def, "disabled")
+	return ruleMessage{Name: r.Name, Disabled: r.Disabled, Definition: def}, nil
+}
+
+// rule checks and returns the rule m describes.
+func (s *Server) rule(m ruleMessage) (rules.Rule, error) {
+	def := map[string]any{}
+	for k, v := range m.Definition {
+		def[k] = v
+	}
+	def["name"], def["disabled"] = m.Name, m.Disabled
+	b, err := json.Marshal(def)
+	if err != nil {
+		return rules.Rule{}, status.Error(codes.InvalidArgument, err.Error())
+	}
+	var r rules.Rule
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.DisallowUnknownFields()
+	err = d.Decode(&r)
+	if err == nil {
+		err = r.Validate()
+	}
+	if err == nil && s.Profiles != nil {
+		err = s.Profiles.Check(r.Headers...)
+	}
+	if err != nil {
+		return rules.Rule{}, status.Errorf(codes.InvalidArgument, "invalid rule: %v", err)
+	}
+	return r, nil
+}
+
+type listRulesResponse struct {
+	Rules []ruleMessage `json:"rules"`
+}
+
+func (s *Server) listRules(struct{}) (listRulesResponse, error) {
+	var out listRulesResponse
+	for _, r := range s.Rules.Rules() {
+		m, err := newRuleMessage(r)
+		if err != nil {
+			return out, err
+		}
+		out.Rules = append(out.Rules, m)
+	}
+	return out, nil
+}
+
+type createRuleRequest struct {
+	Rule ruleMessage `json:"rule"`
+}
+
+func (s *Server) createRule(req createRuleRequest) (ruleMessage, error) {
+	r, err := s.rule(req.Rule)
+	if err != nil {
+		return ruleMessage{}, err
+	}
+	s.Rules.Add(r)
+	return newRuleMessage(r)
+}
+
+type updateRuleRequest struct {
+	Name string      `json:"name"`
+	Rule ruleMessage `json:"rule"`
+}
+
+func (s *Server) updateRule(req updateRuleRequest) (ruleMessage, error) {
+	r, err := s.rule(req.Rule)
+	if err != nil {
+		return ruleMessage{}, err
+	}
+	if !s.Rules.Update(req.Name, r) {
+		return ruleMessage{}, status.Errorf(codes.NotFound, "no rule named %q", req.Name)
+	}
+	return newRuleMessage(r)
+}
+
+type deleteRuleRequest struct {
+	Name string `json:"name"`
+}
+
+func (s *Server) deleteRule(req deleteRuleRequest) (struct{}, error) {
+	if !s.Rules.Delete(req.Name) {
+		return struct{}{}, status.Errorf(codes.NotFound, "no rule named %q", req.Name)
+	}
+	return struct{}{}, nil
+}
+
+type interception struct {
+	Enabled          bool     `json:"enabled"`
+	PassthroughHosts []string `json:"passthrough_hosts,omitempty"`
+}
+
+func (s *Server) getInterception(struct{}) (interception, error) {
+	if s.Interception == nil {
+		return interception{}, status.Error(codes.Unavailable, "interception control is not available")
+	}
+	enabled, hosts := s.Interception.Get()
+	return interception{Enabled: enabled, PassthroughHosts: hosts}, nil
+}
+
+func (s *Server) setInterception(req interception) (interception, error) {
+	if s.Interception == nil {
+		return interception{}, status.Error(codes.Unavailable, "interception control is not available")
+	}
+	s.Interception.Set(req.Enabled, req.PassthroughHosts)
+	return s.getInterception(struct{}{})
+}
+
+type watchTrafficRequest struct {
+	Preview int      `json:"preview"`
+	Types   []string `json:"types"`
+}
+
+type trafficEvent struct {
+	Type   string          `json:"type"`
+	Data   json.RawMessage `json:"data,omitempty"`
+	Missed int             `json:"missed,omitempty"`
+}
+
+// watchTraffic streams session entries as they are recorded.
+func (s *Server) watchTraffic(stream grpc.ServerStream) error {
+	if s.Hub == nil {
+		return status.Error(codes.Unavailable, "live traffic is not available")
+	}
+	m := service.Methods().ByName("WatchTraffic")
+	msg := dynamicpb.NewMessage(m.Input())
+	if err := stream.RecvMsg(msg); err != nil {
+		return err
+	}
+	var req watchTrafficRequest
+	if err := fromMessage(msg, &req); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	preview := req.Preview
+	if preview <= 0 {
+		preview = s.Hub.Preview
+	}
+
+	missed := 0
+	err := s.Hub.Watch(stream.Context(), preview, func(typ string, data []byte, n int) error {
+		missed += n
+		if len(req.Types) > 0 && !slices.Contains(req.Types, typ) {
+			return nil
+		}
+		// Struct holds objects only.
+		if !strings.HasPrefix(strings.TrimSpace(string(data)), "{") {
+			data, _ = json.Marshal(map[string]json.RawMessage{"value": data})
+		}
+		ev, err := toMessage(trafficEvent{Type: typ, Data: data, Missed: missed}, m.Output())
+		if err != nil {
+			return err
+		}
+		missed = 0
+		return stream.SendMsg(ev)
+	})
+	if err == context.Canceled {
+		return nil
+	}
+	return err
+}
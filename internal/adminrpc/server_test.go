@@ -0,0 +1,174 @@
+package adminrpc
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/standrze/rogue/internal/live"
+	"github.com/standrze/rogue/internal/proxy"
+	"github.com/standrze/rogue/internal/rules"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// TestContract checks that the published .proto, without its comments,
+// declares exactly what the server implements.
+func TestContract(t *testing.T) {
+	src, err := os.ReadFile("../../proto/" + fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	comments := regexp.MustCompile(`(?m)^\s*//.*\n`)
+	want := strings.TrimSpace(regexp.MustCompile(`\n{2,}`).ReplaceAllString(comments.ReplaceAllString(string(src), ""), "\n\n"))
+	if got := printFile(service.ParentFile()); got != want {
+		t.Errorf("proto/%s is out of step with the descriptor; want:\n%s", fileName, got)
+	}
+}
+
+// printFile writes fd in the layout of the published .proto.
+func printFile(fd protoreflect.FileDescriptor) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "syntax = %q;\n\npackage %s;\n\n", fd.Syntax(), fd.Package())
+	for i := range fd.Imports().Len() {
+		fmt.Fprintf(&b, "import %q;\n\n", fd.Imports().Get(i).Path())
+	}
+	name := func(d protoreflect.Descriptor) string {
+		if d.ParentFile().Package() == fd.Package() {
+			return string(d.Name())
+		}
+		return string(d.FullName())
+	}
+	svc := fd.Services().Get(0)
+	fmt.Fprintf(&b, "service %s {\n", svc.Name())
+	for i := range svc.Methods().Len() {
+		m := svc.Methods().Get(i)
+		stream := ""
+		if m.IsStreamingServer() {
+			stream = "stream "
+		}
+		fmt.Fprintf(&b, "  rpc %s(%s) returns (%s%s);\n", m.Name(), name(m.Input()), stream, name(m.Output()))
+	}
+	b.WriteString("}\n")
+	for i := range fd.Messages().Len() {
+		m := fd.Messages().Get(i)
+		fmt.Fprintf(&b, "\nmessage %s {\n", m.Name())
+		for j := range m.Fields().Len() {
+			f := m.Fields().Get(j)
+			typ := f.Kind().String()
+			if f.Message() != nil {
+				typ = name(f.Message())
+			}
+			repeated := ""
+			if f.Cardinality() == protoreflect.Repeated {
+				repeated = "repeated "
+			}
+			fmt.Fprintf(&b, "  %s%s %s = %d;\n", repeated, typ, f.Name(), f.Number())
+		}
+		b.WriteString("}\n")
+	}
+	return strings.TrimSpace(b.String())
+}
+
+func TestServer(t *testing.T) {
+	set := rules.NewSet(rules.Rule{Name: "mock", Match: rules.Match{Path: "/a"}, Mock: &rules.Mock{Status: 201}})
+	hub := live.NewHub(4)
+	s := &Server{Rules: set, Hub: hub, Interception: &proxy.Interception{}, Token: "secret"}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := s.GRPC()
+	go g.Serve(l)
+	defer g.Stop()
+
+	conn, err := grpc.NewClient(l.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer secret")
+
+	call := func(ctx context.Context, method, in string) (string, error) {
+		m := service.Methods().ByName(protoreflect.Name(method))
+		req, res := dynamicpb.NewMessage(m.Input()), dynamicpb.NewMessage(m.Output())
+		if err := protojson.Unmarshal([]byte(in), req); err != nil {
+			t.Fatal(err)
+		}
+		err := conn.Invoke(ctx, "/rogue.admin.v1.Admin/"+method, req, res)
+		out, _ := protojson.MarshalOptions{UseProtoNames: true}.Marshal(res)
+		return strings.ReplaceAll(string(out), " ", ""), err
+	}
+
+	if _, err := call(context.Background(), "ListRules", `{}`); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Call without the token: %v", err)
+	}
+	if out, err := call(ctx, "ListRules", `{}`); err != nil || !strings.Contains(out, `"name":"mock"`) || !strings.Contains(out, `"status":201`) {
+		t.Errorf("ListRules: %s, %v", out, err)
+	}
+	if _, err := call(ctx, "CreateRule", `{"rule":{"name":"bad","definition":{"mock":{},"block":{}}}}`); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("CreateRule with an invalid rule: %v", err)
+	}
+	if _, err := call(ctx, "CreateRule", `{"rule":{"name":"block","definition":{"match":{"host":"ads.*"},"block":{"status":404}}}}`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := call(ctx, "UpdateRule", `{"name":"mock","rule":{"name":"mock","disabled":true,"definition":{"match":{"path":"/a"}}}}`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := call(ctx, "DeleteRule", `{"name":"block"}`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := call(ctx, "DeleteRule", `{"name":"block"}`); status.Code(err) != codes.NotFound {
+		t.Errorf("Deleting a missing rule: %v", err)
+	}
+	if got := set.Rules(); len(got) != 1 || !got[0].Disabled || got[0].Mock != nil {
+		t.Errorf("Rules after edits: %+v", got)
+	}
+
+	if out, err := call(ctx, "SetInterception", `{"enabled":true,"passthrough_hosts":["*.bank.example"]}`); err != nil || out != `{"enabled":true,"passthrough_hosts":["*.bank.example"]}` {
+		t.Errorf("SetInterception: %s, %v", out, err)
+	}
+
+	wctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	m := service.Methods().ByName("WatchTraffic")
+	stream, err := conn.NewStream(wctx, &grpc.StreamDesc{ServerStreams: true}, "/rogue.admin.v1.Admin/WatchTraffic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := dynamicpb.NewMessage(m.Input())
+	protojson.Unmarshal([]byte(`{"types":["response"]}`), req)
+	if err := stream.SendMsg(req); err != nil {
+		t.Fatal(err)
+	}
+	stream.CloseSend()
+	// Send until the stream has subscribed.
+	go func() {
+		for wctx.Err() == nil {
+			hub.Send([][]byte{
+				[]byte(`{"type":"request","data":{"request_id":"a1"}}`),
+				[]byte(`{"type":"response","data":{"request_id":"a1","status_code":200,"body":"hello world","body_size":11}}`),
+			})
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+	ev := dynamicpb.NewMessage(m.Output())
+	if err := stream.RecvMsg(ev); err != nil {
+		t.Fatal(err)
+	}
+	out, _ := protojson.MarshalOptions{UseProtoNames: true}.Marshal(ev)
+	if s := strings.ReplaceAll(string(out), " ", ""); !strings.Contains(s, `"type":"response"`) || !strings.Contains(s, `"body":"hell"`) {
+		t.Errorf("Event %s, want a response with a 4 byte preview", s)
+	}
+}
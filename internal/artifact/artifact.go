@@ -0,0 +1,302 @@
+// Package artifact stitches ranged downloads, fetched as many 206 Partial
+// Content responses, back into one record per resource: how large it is,
+// which byte ranges were fetched and whether every byte was.
+package artifact
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/standrze/rogue/internal/logger"
+)
+
+// maxTracked bounds the downloads a Tracker follows at once; the least
+// recently active is forgotten first.
+const maxTracked = 1000
+
+// Range is a span of bytes, inclusive at both ends as in Content-Range.
+type Range struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+}
+
+// Len returns the number of bytes in r.
+func (r Range) Len() int64 { return r.End - r.Start + 1 }
+
+// Part is one partial response of a download.
+type Part struct {
+	FlowID string
+	URL    string
+	// ContentRange is the response's Content-Range header.
+	ContentRange string
+	// Validator is the ETag, or failing that the Last-Modified date, that
+	// ties parts to one version of the resource.
+	Validator   string
+	ContentType string
+	Time        time.Time
+}
+
+// Artifact is a resource assembled from the parts fetched of it.
+type Artifact struct {
+	// ID is the ID of the flow that fetched the first part.
+	ID          string `json:"id"`
+	URL         string `json:"url"`
+	Validator   string `json:"validator,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	// Size is the full size of the resource, or -1 when the server did not
+	// say.
+	Size int64 `json:"size"`
+	// Received counts the distinct bytes fetched and Fetched every byte
+	// fetched, so the difference is what was downloaded more than once.
+	Received int64     `json:"received"`
+	Fetched  int64     `json:"fetched"`
+	Ranges   []Range   `json:"ranges"`
+	Flows    []string  `json:"flows"`
+	Complete bool      `json:"complete"`
+	First    time.Time `json:"first"`
+	Last     time.Time `json:"last"`
+}
+
+// Missing returns the ranges of the resource not yet fetched. It is empty
+// when the size is unknown.
+func (a *Artifact) Missing() []Range {
+	if a.Size < 0 {
+		return nil
+	}
+	var out []Range
+	next := int64(0)
+	for _, r := range a.Ranges {
+		if r.Start > next {
+			out = append(out, Range{next, r.Start - 1})
+		}
+		next = max(next, r.End+1)
+	}
+	if next < a.Size {
+		out = append(out, Range{next, a.Size - 1})
+	}
+	return out
+}
+
+// add merges r into the fetched ranges.
+func (a *Artifact) add(r Range) {
+	a.Fetched += r.Len()
+	ranges := append(a.Ranges, r)
+	slices.SortFunc(ranges, func(x, y Range) int { return cmp.Compare(x.Start, y.Start) })
+	merged := ranges[:1]
+	for _, r := range ranges[1:] {
+		last := &merged[len(merged)-1]
+		if r.Start <= last.End+1 {
+			last.End = max(last.End, r.End)
+			continue
+		}
+		merged = append(merged, r)
+	}
+	a.Ranges = merged
+
+	a.Received = 0
+	for _, r := range merged {
+		a.Received += r.Len()
+	}
+	a.Complete = a.Size >= 0 && a.Received == a.Size
+}
+
+// ParseContentRange parses a "bytes first-last/size" header. size is -1
+// for "*".
+func ParseContentRange(s string) (r Range, size int64, ok bool) {
+	spec, found := strings.CutPrefix(strings.TrimSpace(s), "bytes ")
+	if !found {
+		return r, 0, false
+	}
+	span, total, found := strings.Cut(spec, "/")
+	if !found {
+		return r, 0, false
+	}
+	first, last, found := strings.Cut(span, "-")
+	if !found {
+		return r, 0, false
+	}
+	var err1, err2 error
+	r.Start, err1 = strconv.ParseInt(first, 10, 64)
+	r.End, err2 = strconv.ParseInt(last, 10, 64)
+	if err1 != nil || err2 != nil || r.Start < 0 || r.End < r.Start {
+		return r, 0, false
+	}
+	size = -1
+	if total != "*" {
+		n, err := strconv.ParseInt(total, 10, 64)
+		if err != nil || n <= r.End {
+			return r, 0, false
+		}
+		size = n
+	}
+	return r, size, true
+}
+
+// Tracker assembles artifacts from parts as they are seen. It is safe for
+// concurrent use.
+type Tracker struct {
+	mu        sync.Mutex
+	artifacts map[string]*Artifact
+}
+
+// NewTracker returns an empty Tracker.
+func NewTracker() *Tracker {
+	return &Tracker{artifacts: map[string]*Artifact{}}
+}
+
+// Observe adds p to its artifact and returns a copy of the artifact. It
+// reports false for parts without a usable Content-Range. Parts whose size
+// disagrees with the artifact's start a new artifact, as the resource has
+// changed.
+func (t *Tracker) Observe(p Part) (Artifact, bool) {
+	r, size, ok := ParseContentRange(p.ContentRange)
+	if !ok {
+		return Artifact{}, false
+	}
+	key := p.URL + " " + p.Validator
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	a := t.artifacts[key]
+	if a == nil || a.Size != size {
+		if len(t.artifacts) >= maxTracked {
+			t.evict()
+		}
+		a = &Artifact{
+			ID:          p.FlowID,
+			URL:         p.URL,
+			Validator:   p.Validator,
+			ContentType: p.ContentType,
+			Size:        size,
+			First:       p.Time,
+		}
+		t.artifacts[key] = a
+	}
+	a.add(r)
+	a.Flows = append(a.Flows, p.FlowID)
+	a.Last = p.Time
+	return a.clone(), true
+}
+
+// Forget stops tracking the artifact a, so that a later download of the
+// same resource starts a new one.
+func (t *Tracker) Forget(a Artifact) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	key := a.URL + " " + a.Validator
+	if cur := t.artifacts[key]; cur != nil && cur.ID == a.ID {
+		delete(t.artifacts, key)
+	}
+}
+
+// All returns copies of the tracked artifacts in the order they were
+// started.
+func (t *Tracker) All() []Artifact {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := make([]Artifact, 0, len(t.artifacts))
+	for _, a := range t.artifacts {
+		out = append(out, a.clone())
+	}
+	slices.SortStableFunc(out, func(x, y Artifact) int { return x.First.Compare(y.First) })
+	return out
+}
+
+// evict forgets the least recently active artifact. t.mu must be held.
+func (t *Tracker) evict() {
+	var oldest string
+	for k, a := range t.artifacts {
+		if oldest == "" || a.Last.Before(t.artifacts[oldest].Last) {
+			oldest = k
+		}
+	}
+	delete(t.artifacts, oldest)
+}
+
+func (a *Artifact) clone() Artifact {
+	c := *a
+	c.Ranges = slices.Clone(a.Ranges)
+	c.Flows = slices.Clone(a.Flows)
+	return c
+}
+
+// FlowPart returns the part fetched by a recorded flow, if it is a 206
+// response with a Content-Range.
+func FlowPart(f *logger.Flow) (Part, bool) {
+	if f.Request == nil || f.Response == nil || f.Response.StatusCode != 206 {
+		return Part{}, false
+	}
+	h := f.Response.Headers
+	if h["Content-Range"] == "" {
+		return Part{}, false
+	}
+	return Part{
+		FlowID:       f.ID,
+		URL:          f.Request.URL,
+		ContentRange: h["Content-Range"],
+		Validator:    cmp.Or(h["Etag"], h["Last-Modified"]),
+		ContentType:  h["Content-Type"],
+		Time:         f.Response.Timestamp,
+	}, true
+}
+
+// Stitch assembles the ranged downloads of a recorded session.
+func Stitch(flows []*logger.Flow) []Artifact {
+	t := NewTracker()
+	for _, f := range flows {
+		if p, ok := FlowPart(f); ok {
+			t.Observe(p)
+		}
+	}
+	return t.All()
+}
+
+// Write prints one line per artifact: whether it is complete, the bytes
+// received of its size, the number of parts and its URL, followed by the
+// missing ranges of incomplete ones.
+func Write(w io.Writer, artifacts []Artifact) {
+	if len(artifacts) == 0 {
+		fmt.Fprintln(w, "No ranged downloads")
+		return
+	}
+	for _, a := range artifacts {
+		state, size := "partial", "?"
+		if a.Complete {
+			state = "complete"
+		}
+		if a.Size >= 0 {
+			size = strconv.FormatInt(a.Size, 10)
+		}
+		fmt.Fprintf(w, "%-8s %s/%s bytes in %d parts  %s\n", state, strconv.FormatInt(a.Received, 10), size, len(a.Flows), display(a.URL))
+		if dup := a.Fetched - a.Received; dup > 0 {
+			fmt.Fprintf(w, "         %d bytes fetched more than once\n", dup)
+		}
+		if missing := a.Missing(); len(missing) > 0 {
+			spans := make([]string, len(missing))
+			for i, r := range missing {
+				spans[i] = fmt.Sprintf("%d-%d", r.Start, r.End)
+			}
+			fmt.Fprintf(w, "         missing %s\n", strings.Join(spans, ", "))
+		}
+	}
+}
+
+// display drops the query of long URLs, which for signed download links
+// is mostly credentials.
+func display(raw string) string {
+	if len(raw) <= 100 {
+		return raw
+	}
+	if u, err := url.Parse(raw); err == nil && u.RawQuery != "" {
+		u.RawQuery = ""
+		return u.String() + "?..."
+	}
+	return raw
+}
@@ -0,0 +1,64 @@
+package artifact
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseContentRange(t *testing.T) {
+	tests := []struct {
+		in   string
+		r    Range
+		size int64
+		ok   bool
+	}{
+		{"bytes 0-99/1000", Range{0, 99}, 1000, true},
+		{"bytes 900-999/*", Range{900, 999}, -1, true},
+		{"bytes */1000", Range{}, 0, false},
+		{"bytes 10-5/1000", Range{}, 0, false},
+		{"bytes 0-999/1000", Range{0, 999}, 1000, true},
+		{"bytes 0-1000/1000", Range{}, 0, false},
+		{"items 0-1/2", Range{}, 0, false},
+	}
+	for _, tt := range tests {
+		r, size, ok := ParseContentRange(tt.in)
+		if ok != tt.ok || (ok && (r != tt.r || size != tt.size)) {
+			t.Errorf("ParseContentRange(%q) = %v, %d, %v", tt.in, r, size, ok)
+		}
+	}
+}
+
+func TestTracker(t *testing.T) {
+	tr := NewTracker()
+	now := time.Now()
+	part := func(id, cr, etag string) Part {
+		return Part{FlowID: id, URL: "http://dl.example/file.iso", ContentRange: cr, Validator: etag, Time: now}
+	}
+
+	tr.Observe(part("1", "bytes 0-399/1000", `"v1"`))
+	a, _ := tr.Observe(part("2", "bytes 600-999/1000", `"v1"`))
+	if a.Complete || a.Received != 800 {
+		t.Fatalf("after two parts: %+v", a)
+	}
+	if m := a.Missing(); len(m) != 1 || m[0] != (Range{400, 599}) {
+		t.Errorf("missing = %v", m)
+	}
+
+	// A new version of the file is a separate download.
+	if b, _ := tr.Observe(part("3", "bytes 0-99/1000", `"v2"`)); b.ID != "3" {
+		t.Errorf("new validator joined the old artifact: %+v", b)
+	}
+
+	a, _ = tr.Observe(part("4", "bytes 300-699/1000", `"v1"`))
+	if !a.Complete || a.Received != 1000 || a.Fetched != 1200 || len(a.Ranges) != 1 || len(a.Flows) != 3 {
+		t.Errorf("after overlapping part: %+v", a)
+	}
+
+	tr.Forget(a)
+	if all := tr.All(); len(all) != 1 || all[0].Validator != `"v2"` {
+		t.Errorf("after Forget: %+v", all)
+	}
+	if _, ok := tr.Observe(part("5", "", "")); ok {
+		t.Error("observed a part without Content-Range")
+	}
+}
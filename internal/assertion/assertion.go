@@ -0,0 +1,217 @@
+// Package assertion checks the flows of a session against assertions
+// written in the filter language, such as that no request went to an
+// analytics host or that no response was a server error, for use in CI.
+package assertion
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/standrze/rogue/internal/filter"
+	"github.com/standrze/rogue/internal/logger"
+	"go.yaml.in/yaml/v3"
+)
+
+// Assertion is a condition on the flows of a session. Exactly one of None,
+// All and Some is set: no flow may match None, every flow must match All,
+// or at least one must match Some. Where, if set, limits the flows looked
+// at to those it matches.
+type Assertion struct {
+	Name  string `json:"name" yaml:"name"`
+	Where string `json:"where,omitempty" yaml:"where,omitempty"`
+	None  string `json:"none,omitempty" yaml:"none,omitempty"`
+	All   string `json:"all,omitempty" yaml:"all,omitempty"`
+	Some  string `json:"some,omitempty" yaml:"some,omitempty"`
+
+	where, cond *filter.Expr
+}
+
+// file is the on-disk layout of an assertions file.
+type file struct {
+	Assertions []Assertion `json:"assertions" yaml:"assertions"`
+}
+
+// Load reads assertions from a YAML or JSON file, chosen by extension, and
+// parses their expressions, resolving @name from saved.
+func Load(name string, saved filter.Saved) ([]Assertion, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var f file
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".json":
+		d := json.NewDecoder(bytes.NewReader(data))
+		d.DisallowUnknownFields()
+		err = d.Decode(&f)
+	default:
+		d := yaml.NewDecoder(bytes.NewReader(data))
+		d.KnownFields(true)
+		err = d.Decode(&f)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if len(f.Assertions) == 0 {
+		return nil, fmt.Errorf("%s: no assertions", name)
+	}
+	for i := range f.Assertions {
+		if err := f.Assertions[i].compile(saved); err != nil {
+			return nil, fmt.Errorf("%s: assertion %q: %w", name, f.Assertions[i].Name, err)
+		}
+	}
+	return f.Assertions, nil
+}
+
+func (a *Assertion) compile(saved filter.Saved) error {
+	if a.Name == "" {
+		return errors.New("assertions need a name")
+	}
+	set := 0
+	for _, s := range []string{a.None, a.All, a.Some} {
+		if s != "" {
+			set++
+		}
+	}
+	if set != 1 {
+		return errors.New("set exactly one of none, all and some")
+	}
+	var err error
+	if a.Where != "" {
+		if a.where, err = saved.Parse(a.Where); err != nil {
+			return fmt.Errorf("where: %w", err)
+		}
+	}
+	if a.cond, err = saved.Parse(a.None + a.All + a.Some); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Kind returns "none", "all" or "some".
+func (a *Assertion) Kind() string {
+	switch {
+	case a.None != "":
+		return "none"
+	case a.All != "":
+		return "all"
+	}
+	return "some"
+}
+
+// MaxExamples bounds the failing flows kept in a Result.
+const MaxExamples = 5
+
+// Result is the outcome of an assertion.
+type Result struct {
+	Name   string `json:"name"`
+	Passed bool   `json:"passed"`
+	// Checked counts the flows the assertion looked at, and Failed those
+	// that broke it: the matches of none, or the misses of all.
+	Checked int `json:"checked"`
+	Failed  int `json:"failed"`
+	// Examples are the first failing flows.
+	Examples []Example `json:"examples,omitempty"`
+}
+
+// Example identifies a flow breaking an assertion.
+type Example struct {
+	ID     string `json:"id"`
+	Method string `json:"method,omitempty"`
+	URL    string `json:"url,omitempty"`
+	Status int    `json:"status,omitempty"`
+}
+
+// Check evaluates assertions against flows.
+func Check(assertions []Assertion, flows []*logger.Flow) []Result {
+	results := make([]Result, len(assertions))
+	for i := range assertions {
+		results[i] = assertions[i].check(flows)
+	}
+	return results
+}
+
+func (a *Assertion) check(flows []*logger.Flow) Result {
+	r := Result{Name: a.Name}
+	kind, matched := a.Kind(), 0
+	for _, f := range flows {
+		if f.Request == nil || !a.where.Match(f) {
+			continue
+		}
+		r.Checked++
+		ok := a.cond.Match(f)
+		if ok {
+			matched++
+		}
+		if kind == "none" && ok || kind == "all" && !ok {
+			r.Failed++
+			if len(r.Examples) < MaxExamples {
+				r.Examples = append(r.Examples, example(f))
+			}
+		}
+	}
+	r.Passed = r.Failed == 0 && (kind != "some" || matched > 0)
+	return r
+}
+
+func example(f *logger.Flow) Example {
+	e := Example{ID: f.ID, Method: f.Request.Method, URL: f.Request.URL}
+	if f.Response != nil {
+		e.Status = f.Response.StatusCode
+	}
+	return e
+}
+
+// Write prints results as a report, one line per assertion followed by
+// examples of the flows failing it.
+func Write(w io.Writer, assertions []Assertion, results []Result) {
+	for i, r := range results {
+		mark := "PASS"
+		if !r.Passed {
+			mark = "FAIL"
+		}
+		a := &assertions[i]
+		fmt.Fprintf(w, "%s  %s  (%s %s", mark, r.Name, a.Kind(), a.cond)
+		if a.where != nil {
+			fmt.Fprintf(w, " where %s", a.where)
+		}
+		flows := "flows"
+		if r.Checked == 1 {
+			flows = "flow"
+		}
+		fmt.Fprintf(w, "; %d %s checked)\n", r.Checked, flows)
+		switch {
+		case r.Passed:
+		case r.Failed == 0:
+			fmt.Fprintln(w, "      no flow matched")
+		default:
+			for _, e := range r.Examples {
+				status := "-"
+				if e.Status != 0 {
+					status = fmt.Sprint(e.Status)
+				}
+				fmt.Fprintf(w, "      %s %s %s %s\n", e.ID, status, e.Method, e.URL)
+			}
+			if more := r.Failed - len(r.Examples); more > 0 {
+				fmt.Fprintf(w, "      and %d more\n", more)
+			}
+		}
+	}
+}
+
+// Failed counts the results that did not pass.
+func Failed(results []Result) int {
+	n := 0
+	for _, r := range results {
+		if !r.Passed {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,135 @@
+package assertion
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/standrze/rogue/internal/filter"
+	"github.com/standrze/rogue/internal/logger"
+)
+
+func flow(id, url string, status int, took time.Duration) *logger.Flow {
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	f := &logger.Flow{ID: id, Request: &logger.RequestLog{Timestamp: start, Method: "GET", URL: url}}
+	if status != 0 {
+		f.Response = &logger.ResponseLog{Timestamp: start.Add(took), StatusCode: status}
+	}
+	return f
+}
+
+func writeFile(t *testing.T, name, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestCheck(t *testing.T) {
+	path := writeFile(t, "assertions.yaml", `assertions:
+  - name: no analytics
+    none: host =~ "(^|\\.)analytics\\.com$"
+  - name: fast API
+    where: host == "api.example.com"
+    all: duration < 500
+  - name: no server errors
+    none: "@errors"
+  - name: logged in
+    some: path == "/login"
+  - name: no redirects
+    none: status >= 300 && status < 400
+`)
+	assertions, err := Load(path, filter.Saved{"errors": "status >= 500"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	flows := []*logger.Flow{
+		flow("1", "https://api.example.com/a", 200, 100*time.Millisecond),
+		flow("2", "https://api.example.com/b", 200, 900*time.Millisecond),
+		flow("3", "https://t.analytics.com/collect", 204, 10*time.Millisecond),
+		flow("4", "https://www.example.com/", 502, 10*time.Millisecond),
+		// Without a response: status 0, duration 0.
+		flow("5", "https://api.example.com/c", 0, 0),
+	}
+	results := Check(assertions, flows)
+	want := []struct {
+		passed          bool
+		checked, failed int
+		ids             string
+	}{
+		{false, 5, 1, "3"},
+		{false, 3, 1, "2"},
+		{false, 5, 1, "4"},
+		{false, 5, 0, ""},
+		{true, 5, 0, ""},
+	}
+	for i, w := range want {
+		r := results[i]
+		var ids []string
+		for _, e := range r.Examples {
+			ids = append(ids, e.ID)
+		}
+		if r.Passed != w.passed || r.Checked != w.checked || r.Failed != w.failed || strings.Join(ids, ",") != w.ids {
+			t.Errorf("%s: result = %+v", r.Name, r)
+		}
+	}
+	if n := Failed(results); n != 4 {
+		t.Errorf("Failed = %d, want 4", n)
+	}
+	if e := results[2].Examples[0]; e.Status != 502 || e.URL != "https://www.example.com/" {
+		t.Errorf("example = %+v", e)
+	}
+
+	var b strings.Builder
+	Write(&b, assertions, results)
+	for _, s := range []string{
+		"FAIL  fast API  (all duration < 500 where host == \"api.example.com\"; 3 flows checked)\n      2 200 GET https://api.example.com/b\n",
+		"FAIL  logged in  (some path == \"/login\"; 5 flows checked)\n      no flow matched\n",
+		"PASS  no redirects",
+	} {
+		if !strings.Contains(b.String(), s) {
+			t.Errorf("report lacks %q:\n%s", s, b.String())
+		}
+	}
+}
+
+func TestCheckExamples(t *testing.T) {
+	a, err := Load(writeFile(t, "a.json", `{"assertions": [{"name": "no errors", "none": "status >= 500"}]}`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var flows []*logger.Flow
+	for i := range MaxExamples + 3 {
+		flows = append(flows, flow(string(rune('a'+i)), "http://x/", 500, 0))
+	}
+	r := Check(a, flows)[0]
+	if r.Failed != MaxExamples+3 || len(r.Examples) != MaxExamples {
+		t.Errorf("result = %+v", r)
+	}
+	var b strings.Builder
+	Write(&b, a, []Result{r})
+	if !strings.Contains(b.String(), "and 3 more\n") {
+		t.Errorf("report:\n%s", b.String())
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	for _, tc := range []struct{ data, want string }{
+		{"assertions: []\n", "no assertions"},
+		{"assertions:\n  - none: status >= 500\n", "need a name"},
+		{"assertions:\n  - name: a\n", "exactly one"},
+		{"assertions:\n  - name: a\n    none: status >= 500\n    all: status < 500\n", "exactly one"},
+		{"assertions:\n  - name: a\n    none: status >=\n", `assertion "a"`},
+		{"assertions:\n  - name: a\n    where: nope == 1\n    none: status >= 500\n", "where"},
+		{"assertions:\n  - name: a\n    never: status >= 500\n", "never"},
+	} {
+		_, err := Load(writeFile(t, "a.yaml", tc.data), nil)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("Load(%q) error = %v, want %q", tc.data, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+// Package bench drives synthetic load through a proxy to a local origin
+// and measures throughput, latency and allocations, so changes to the
+// proxy's performance can be compared.
+package bench
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"runtime"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Options describes a load test.
+type Options struct {
+	// Proxy is the host:port of the proxy under test.
+	Proxy string
+	// RootCAs verify the certificates the proxy presents for intercepted
+	// hosts.
+	RootCAs *x509.CertPool
+	// Rate is the requests per second sent in total; 0 sends them as fast
+	// as the workers allow.
+	Rate float64
+	// Concurrency is how many requests are in flight at once; 0 means one.
+	Concurrency int
+	// Duration bounds the test, and Requests, if set, the number of
+	// requests sent; whichever ends it first wins.
+	Duration time.Duration
+	Requests int
+	// Sizes are the response body sizes requested in turn; empty means
+	// 1 KiB.
+	Sizes []int
+	// BodySize, if set, sends POST requests with bodies of this many bytes
+	// instead of GETs.
+	BodySize int
+}
+
+// Result reports a load test.
+type Result struct {
+	Requests int           `json:"requests"`
+	Errors   int           `json:"errors"`
+	Elapsed  time.Duration `json:"elapsed_ns"`
+	// BytesReceived totals the response bodies read.
+	BytesReceived int64   `json:"bytes_received"`
+	RequestsPerS  float64 `json:"requests_per_second"`
+	BytesPerS     float64 `json:"bytes_per_second"`
+	// Latencies are from sending a request to reading its whole response,
+	// over the requests that succeeded.
+	P50 time.Duration `json:"p50_ns"`
+	P90 time.Duration `json:"p90_ns"`
+	P99 time.Duration `json:"p99_ns"`
+	Max time.Duration `json:"max_ns"`
+	// AllocsPerRequest and AllocBytesPerRequest count the allocations of
+	// the whole process: the load generator, its origin and the proxy when
+	// it runs in the same process.
+	AllocsPerRequest     float64 `json:"allocs_per_request"`
+	AllocBytesPerRequest float64 `json:"alloc_bytes_per_request"`
+	// FirstError is the first failure seen, if any.
+	FirstError string `json:"first_error,omitempty"`
+}
+
+// NewOrigin starts a local origin answering /?size=N with N bytes. It
+// serves HTTPS with a self-signed certificate when secure is set, so the
+// proxy must not verify it.
+func NewOrigin(secure bool) *httptest.Server {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		n, _ := strconv.Atoi(r.URL.Query().Get("size"))
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Length", strconv.Itoa(n))
+		for n > 0 {
+			k := min(n, len(payload))
+			w.Write(payload[:k])
+			n -= k
+		}
+	})
+	if secure {
+		return httptest.NewTLSServer(h)
+	}
+	return httptest.NewServer(h)
+}
+
+var payload = bytes.Repeat([]byte("rogue bench payload\n"), 1<<12)
+
+// Run sends requests to origin, the URL of a server from NewOrigin,
+// through the proxy until the test ends or ctx is done. HTTPS origins are
+// reached through CONNECT tunnels.
+func Run(ctx context.Context, origin string, o Options) (*Result, error) {
+	proxyURL, err := url.Parse("http://" + o.Proxy)
+	if err != nil {
+		return nil, fmt.Errorf("proxy address %q: %w", o.Proxy, err)
+	}
+	workers := max(o.Concurrency, 1)
+	transport := &http.Transport{
+		Proxy:               http.ProxyURL(proxyURL),
+		TLSClientConfig:     &tls.Config{RootCAs: o.RootCAs},
+		MaxIdleConnsPerHost: workers,
+		DisableCompression:  true,
+	}
+	defer transport.CloseIdleConnections()
+	client := &http.Client{Transport: transport}
+	sizes := o.Sizes
+	if len(sizes) == 0 {
+		sizes = []int{1024}
+	}
+	var body []byte
+	if o.BodySize > 0 {
+		body = bytes.Repeat([]byte{'x'}, o.BodySize)
+	}
+
+	if o.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.Duration)
+		defer cancel()
+	}
+	var tick <-chan time.Time
+	if o.Rate > 0 {
+		t := time.NewTicker(time.Duration(float64(time.Second) / o.Rate))
+		defer t.Stop()
+		tick = t.C
+	}
+
+	var (
+		mu        sync.Mutex
+		res       Result
+		latencies []time.Duration
+		sent      int
+	)
+	// next hands out the request numbers, pacing them at the rate.
+	next := func() (int, bool) {
+		if tick != nil {
+			select {
+			case <-tick:
+			case <-ctx.Done():
+				return 0, false
+			}
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if ctx.Err() != nil || o.Requests > 0 && sent >= o.Requests {
+			return 0, false
+		}
+		sent++
+		return sent - 1, true
+	}
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				n, ok := next()
+				if !ok {
+					return
+				}
+				size := sizes[n%len(sizes)]
+				t := time.Now()
+				got, err := send(ctx, client, origin, size, body)
+				d := time.Since(t)
+				if err != nil && ctx.Err() != nil {
+					// Cut short by the end of the test.
+					return
+				}
+				mu.Lock()
+				res.Requests++
+				if err != nil {
+					res.Errors++
+					if res.FirstError == "" {
+						res.FirstError = err.Error()
+					}
+				} else {
+					res.BytesReceived += got
+					latencies = append(latencies, d)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	res.Elapsed = time.Since(start)
+	runtime.ReadMemStats(&after)
+
+	if secs := res.Elapsed.Seconds(); secs > 0 {
+		res.RequestsPerS = float64(res.Requests) / secs
+		res.BytesPerS = float64(res.BytesReceived) / secs
+	}
+	if res.Requests > 0 {
+		res.AllocsPerRequest = float64(after.Mallocs-before.Mallocs) / float64(res.Requests)
+		res.AllocBytesPerRequest = float64(after.TotalAlloc-before.TotalAlloc) / float64(res.Requests)
+	}
+	slices.Sort(latencies)
+	res.P50, res.P90, res.P99 = percentile(latencies, 50), percentile(latencies, 90), percentile(latencies, 99)
+	if len(latencies) > 0 {
+		res.Max = latencies[len(latencies)-1]
+	}
+	return &res, nil
+}
+
+// send makes one request for size bytes, returning how many were read.
+func send(ctx context.Context, client *http.Client, origin string, size int, body []byte) (int64, error) {
+	method, r := http.MethodGet, io.Reader(nil)
+	if body != nil {
+		method, r = http.MethodPost, bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, origin+"/?size="+strconv.Itoa(size), r)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	n, err := io.Copy(io.Discard, resp.Body)
+	if err != nil {
+		return n, err
+	}
+	if resp.StatusCode != http.StatusOK || n != int64(size) {
+		return n, fmt.Errorf("got %s with %d of %d bytes", resp.Status, n, size)
+	}
+	return n, nil
+}
+
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	return sorted[(len(sorted)-1)*p/100]
+}
+
+// Write prints r for people.
+func Write(w io.Writer, r *Result) {
+	fmt.Fprintf(w, "Requests:     %d in %s (%d errors)\n", r.Requests, r.Elapsed.Round(time.Millisecond), r.Errors)
+	fmt.Fprintf(w, "Throughput:   %.1f req/s, %.2f MB/s\n", r.RequestsPerS, r.BytesPerS/1e6)
+	fmt.Fprintf(w, "Latency:      p50 %s  p90 %s  p99 %s  max %s\n", ms(r.P50), ms(r.P90), ms(r.P99), ms(r.Max))
+	if r.AllocsPerRequest > 0 {
+		fmt.Fprintf(w, "Allocations:  %.0f allocs, %.0f bytes per request\n", r.AllocsPerRequest, r.AllocBytesPerRequest)
+	}
+	if r.FirstError != "" {
+		fmt.Fprintf(w, "First error:  %s\n", r.FirstError)
+	}
+}
+
+func ms(d time.Duration) string {
+	return fmt.Sprintf("%.2fms", float64(d)/float64(time.Millisecond))
+}
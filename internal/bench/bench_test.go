@@ -0,0 +1,45 @@
+package bench
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	origin := NewOrigin(false)
+	defer origin.Close()
+	// A bare forward proxy for plain HTTP.
+	fwd := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.RequestURI = ""
+		resp, err := http.DefaultTransport.RoundTrip(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer resp.Body.Close()
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+	}))
+	defer fwd.Close()
+
+	res, err := Run(context.Background(), origin.URL, Options{
+		Proxy:       strings.TrimPrefix(fwd.URL, "http://"),
+		Concurrency: 4,
+		Requests:    20,
+		Sizes:       []int{10, 1000},
+		BodySize:    5,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Requests != 20 || res.Errors != 0 || res.BytesReceived != 10*10+10*1000 {
+		t.Errorf("result = %+v", res)
+	}
+	if res.P50 <= 0 || res.P50 > res.Max || res.RequestsPerS <= 0 {
+		t.Errorf("latencies = %v %v, rate %v", res.P50, res.Max, res.RequestsPerS)
+	}
+}
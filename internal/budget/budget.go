@@ -0,0 +1,139 @@
+// Package budget bounds the memory that bodies buffered in flight hold
+// across all exchanges, so many clients downloading large files at once
+// cannot exhaust it.
+package budget
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// chunk is how much of a body is charged at a time while it is read.
+const chunk = 32 << 10
+
+// Budget is a shared allowance of bytes. A nil Budget is unlimited.
+type Budget struct {
+	limit int64
+	// dir, if set, receives bodies being captured for the log once the
+	// budget is spent.
+	dir string
+
+	used, spilled, refused atomic.Int64
+}
+
+// New returns a budget of limit bytes, or nil for an unlimited one when
+// limit is not positive. Captured bodies spill to temporary files in
+// spillDir once it is spent; without one they are captured no further.
+func New(limit int64, spillDir string) *Budget {
+	if limit <= 0 {
+		return nil
+	}
+	return &Budget{limit: limit, dir: spillDir}
+}
+
+// Stats reports the state of a budget.
+type Stats struct {
+	Limit int64
+	Used  int64
+	// Spilled counts bodies moved to temporary files and Refused those
+	// left unbuffered or captured in part for want of room.
+	Spilled int64
+	Refused int64
+}
+
+func (b *Budget) Stats() Stats {
+	if b == nil {
+		return Stats{}
+	}
+	return Stats{Limit: b.limit, Used: b.used.Load(), Spilled: b.spilled.Load(), Refused: b.refused.Load()}
+}
+
+// Reserve charges n bytes to the budget, reporting false, and charging
+// nothing, if that would overspend it.
+func (b *Budget) Reserve(n int) bool {
+	if b == nil {
+		return true
+	}
+	if b.used.Add(int64(n)) > b.limit {
+		b.used.Add(-int64(n))
+		return false
+	}
+	return true
+}
+
+// Release gives back n bytes charged by Reserve.
+func (b *Budget) Release(n int) {
+	if b != nil {
+		b.used.Add(-int64(n))
+	}
+}
+
+// Spill returns a temporary file for a captured body that no longer fits,
+// or nil if bodies are not spilled. The caller removes it.
+func (b *Budget) Spill() (*os.File, error) {
+	if b == nil || b.dir == "" {
+		return nil, nil
+	}
+	f, err := os.CreateTemp(b.dir, "rogue-body-*")
+	if err == nil {
+		b.spilled.Add(1)
+	}
+	return f, err
+}
+
+// Refuse counts a body left unbuffered, or captured no further, for want
+// of room.
+func (b *Budget) Refuse() {
+	if b != nil {
+		b.refused.Add(1)
+	}
+}
+
+// Buffer reads up to limit bytes of body into memory, charged to b. When
+// the whole body fits, ok is set and data holds it; rest replays it and
+// gives the charge back when closed. Otherwise, because the body is longer
+// than limit or b ran out, rest is the body as it was, reassembled from
+// what was read and the remainder, and data is nil.
+func (b *Budget) Buffer(body io.ReadCloser, limit int64) (data []byte, rest io.ReadCloser, ok bool, err error) {
+	var buf bytes.Buffer
+	charged := 0
+	release := func() { b.Release(charged) }
+	for int64(buf.Len()) <= limit {
+		n := int(min(chunk, limit+1-int64(buf.Len())))
+		if !b.Reserve(n) {
+			b.Refuse()
+			return nil, &heldBody{Reader: io.MultiReader(bytes.NewReader(buf.Bytes()), body), Closer: body, release: release}, false, nil
+		}
+		charged += n
+		m, err := io.CopyN(&buf, body, int64(n))
+		if err == io.EOF || (err == nil && m < int64(n)) {
+			break
+		}
+		if err != nil {
+			release()
+			body.Close()
+			return nil, nil, false, err
+		}
+	}
+	if int64(buf.Len()) > limit {
+		return nil, &heldBody{Reader: io.MultiReader(bytes.NewReader(buf.Bytes()), body), Closer: body, release: release}, false, nil
+	}
+	body.Close()
+	return buf.Bytes(), &heldBody{Reader: bytes.NewReader(buf.Bytes()), Closer: io.NopCloser(nil), release: release}, true, nil
+}
+
+// heldBody gives back the charge for its buffered bytes when closed.
+type heldBody struct {
+	io.Reader
+	io.Closer
+	release func()
+	once    sync.Once
+}
+
+func (h *heldBody) Close() error {
+	h.once.Do(h.release)
+	return h.Closer.Close()
+}
@@ -0,0 +1,58 @@
+package budget
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestBuffer(t *testing.T) {
+	b := New(1<<20, "")
+	body := strings.Repeat("x", 100)
+
+	data, rest, ok, err := b.Buffer(io.NopCloser(strings.NewReader(body)), 1000)
+	if err != nil || !ok || string(data) != body {
+		t.Fatalf("Buffer = %q, %v, %v", data, ok, err)
+	}
+	if b.Stats().Used == 0 {
+		t.Error("a buffered body is not charged")
+	}
+	if got, _ := io.ReadAll(rest); string(got) != body {
+		t.Errorf("rest = %q", got)
+	}
+	rest.Close()
+	if used := b.Stats().Used; used != 0 {
+		t.Errorf("%d bytes charged after close", used)
+	}
+
+	// Bodies longer than the limit come back whole.
+	_, rest, ok, err = b.Buffer(io.NopCloser(strings.NewReader(body)), 10)
+	if err != nil || ok {
+		t.Fatalf("a long body was buffered: %v", err)
+	}
+	if got, _ := io.ReadAll(rest); string(got) != body {
+		t.Errorf("rest of a long body = %q", got)
+	}
+	rest.Close()
+
+	// So do bodies the budget has no room for.
+	small := New(chunk, "")
+	long := strings.Repeat("y", 3*chunk)
+	_, rest, ok, err = small.Buffer(io.NopCloser(strings.NewReader(long)), 1<<20)
+	if err != nil || ok {
+		t.Fatalf("a body over the budget was buffered: %v", err)
+	}
+	if got, _ := io.ReadAll(rest); string(got) != long {
+		t.Errorf("rest of a body over the budget has %d bytes", len(got))
+	}
+	rest.Close()
+	if s := small.Stats(); s.Used != 0 || s.Refused != 1 {
+		t.Errorf("stats = %+v", s)
+	}
+
+	// A nil budget is unlimited.
+	var unlimited *Budget
+	if data, _, ok, _ := unlimited.Buffer(io.NopCloser(strings.NewReader(body)), 1000); !ok || string(data) != body {
+		t.Error("an unlimited budget did not buffer")
+	}
+}
@@ -0,0 +1,381 @@
+// Package cache keeps responses to GET requests so repeated requests can be
+// answered without the origin. Entries live in memory and, when a
+// directory is given, on disk, where they survive restarts and can serve a
+// whole browsing session offline.
+package cache
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/standrze/rogue/internal/logger"
+)
+
+// storableStatus are the status codes responses may be cached with.
+var storableStatus = []int{200, 203, 204, 300, 301, 308, 404, 405, 410, 414, 501}
+
+// hopHeaders describe a connection rather than a response and are not
+// stored.
+var hopHeaders = []string{"Connection", "Keep-Alive", "Proxy-Connection", "Transfer-Encoding", "Upgrade", "Trailer", "Content-Length"}
+
+// ErrOffline is the reason given for requests an offline cache cannot
+// answer.
+var ErrOffline = errors.New("not in the cache and rogue is offline")
+
+// Options configures a Cache.
+type Options struct {
+	// Dir, if set, stores entries on disk as well as in memory.
+	Dir string
+	// MaxEntries bounds the entries held in memory. Zero means 1000.
+	MaxEntries int
+	// MaxBodySize is the largest body stored. Zero means 10 MiB.
+	MaxBodySize int64
+	// IgnoreCacheControl stores every GET response with a cacheable status
+	// and serves it for as long as it is kept, whatever the headers say.
+	IgnoreCacheControl bool
+	// Offline serves only from the cache, whether entries are fresh or not.
+	Offline bool
+}
+
+// Entry is a stored response.
+type Entry struct {
+	Key    string      `json:"key"`
+	Status int         `json:"status"`
+	Header http.Header `json:"header"`
+	Body   []byte      `json:"body"`
+	Stored time.Time   `json:"stored"`
+	// Vary holds the request headers named by the response's Vary header,
+	// which a request must repeat to be served this entry.
+	Vary map[string]string `json:"vary,omitempty"`
+}
+
+// Age is how long ago e was stored.
+func (e *Entry) Age(now time.Time) time.Duration {
+	return max(now.Sub(e.Stored), 0)
+}
+
+// Stats counts cache lookups and the entries held.
+type Stats struct {
+	Hits, Misses, Stored uint64
+	Entries              int
+}
+
+// Cache is a response cache safe for concurrent use.
+type Cache struct {
+	opts Options
+
+	mu    sync.Mutex
+	lru   *list.List
+	items map[string]*list.Element
+
+	hits, misses, stored atomic.Uint64
+}
+
+// New returns a cache, creating opts.Dir if needed.
+func New(opts Options) (*Cache, error) {
+	if opts.MaxEntries <= 0 {
+		opts.MaxEntries = 1000
+	}
+	if opts.MaxBodySize <= 0 {
+		opts.MaxBodySize = 10 << 20
+	}
+	if opts.Dir != "" {
+		if err := os.MkdirAll(opts.Dir, 0o755); err != nil {
+			return nil, err
+		}
+	}
+	return &Cache{opts: opts, lru: list.New(), items: map[string]*list.Element{}}, nil
+}
+
+// Offline reports whether the cache is the only source of responses.
+func (c *Cache) Offline() bool { return c.opts.Offline }
+
+// MaxBodySize is the largest body Put accepts.
+func (c *Cache) MaxBodySize() int64 { return c.opts.MaxBodySize }
+
+// Key identifies the entry for req.
+func Key(req *http.Request) string {
+	return http.MethodGet + " " + req.URL.String()
+}
+
+// Get returns the entry to answer req with: a matching entry that is fresh
+// or, offline or ignoring Cache-Control, any matching entry.
+func (c *Cache) Get(req *http.Request) (*Entry, bool) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		c.misses.Add(1)
+		return nil, false
+	}
+	e := c.lookup(Key(req))
+	ok := e != nil && e.matches(req)
+	if ok && !c.opts.Offline && !c.opts.IgnoreCacheControl {
+		ok = !noCache(req.Header) && e.fresh(time.Now())
+	}
+	if !ok {
+		c.misses.Add(1)
+		return nil, false
+	}
+	c.hits.Add(1)
+	return e, true
+}
+
+// Storable reports whether res, the response to req, may be stored.
+func (c *Cache) Storable(req *http.Request, res *http.Response) bool {
+	if req.Method != http.MethodGet || !slices.Contains(storableStatus, res.StatusCode) {
+		return false
+	}
+	if strings.TrimSpace(res.Header.Get("Vary")) == "*" {
+		return false
+	}
+	if c.opts.IgnoreCacheControl {
+		return true
+	}
+	reqCC, resCC := directives(req.Header), directives(res.Header)
+	if _, ok := reqCC["no-store"]; ok {
+		return false
+	}
+	if _, ok := resCC["no-store"]; ok {
+		return false
+	}
+	if _, ok := resCC["private"]; ok {
+		return false
+	}
+	if req.Header.Get("Authorization") != "" {
+		_, public := resCC["public"]
+		_, shared := resCC["s-maxage"]
+		return public || shared
+	}
+	return true
+}
+
+// Put stores the response to req with body, which must be complete.
+func (c *Cache) Put(req *http.Request, status int, header http.Header, body []byte) error {
+	return c.store(newEntry(req, status, header, body, time.Now()))
+}
+
+func newEntry(req *http.Request, status int, header http.Header, body []byte, stored time.Time) *Entry {
+	e := &Entry{
+		Key:    Key(req),
+		Status: status,
+		Header: header.Clone(),
+		Body:   body,
+		Stored: stored,
+	}
+	for _, h := range hopHeaders {
+		e.Header.Del(h)
+	}
+	for _, name := range varyNames(header) {
+		if e.Vary == nil {
+			e.Vary = map[string]string{}
+		}
+		e.Vary[name] = req.Header.Get(name)
+	}
+	return e
+}
+
+func (c *Cache) store(e *Entry) error {
+	if int64(len(e.Body)) > c.opts.MaxBodySize {
+		return nil
+	}
+	c.stored.Add(1)
+	c.remember(e)
+	if c.opts.Dir == "" {
+		return nil
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	name := c.file(e.Key)
+	tmp := name + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, name)
+}
+
+// lookup returns the entry stored under key from memory or disk.
+func (c *Cache) lookup(key string) *Entry {
+	c.mu.Lock()
+	if el, ok := c.items[key]; ok {
+		c.lru.MoveToFront(el)
+		c.mu.Unlock()
+		return el.Value.(*Entry)
+	}
+	c.mu.Unlock()
+
+	if c.opts.Dir == "" {
+		return nil
+	}
+	data, err := os.ReadFile(c.file(key))
+	if err != nil {
+		return nil
+	}
+	var e Entry
+	if err := json.Unmarshal(data, &e); err != nil || e.Key != key {
+		return nil
+	}
+	c.remember(&e)
+	return &e
+}
+
+// remember keeps e in memory, evicting the least recently used entry.
+func (c *Cache) remember(e *Entry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[e.Key]; ok {
+		el.Value = e
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.items[e.Key] = c.lru.PushFront(e)
+	if c.lru.Len() > c.opts.MaxEntries {
+		last := c.lru.Back()
+		c.lru.Remove(last)
+		delete(c.items, last.Value.(*Entry).Key)
+	}
+}
+
+func (c *Cache) file(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(c.opts.Dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// Stats returns a snapshot of the cache counters.
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	n := c.lru.Len()
+	c.mu.Unlock()
+	return Stats{Hits: c.hits.Load(), Misses: c.misses.Load(), Stored: c.stored.Load(), Entries: n}
+}
+
+// Seed stores the GET responses recorded in flows, so a captured browsing
+// session can be replayed offline. Responses whose bodies were truncated
+// or not logged are skipped. It returns how many were stored.
+func (c *Cache) Seed(flows []*logger.Flow) (int, error) {
+	n := 0
+	for _, f := range flows {
+		if f.Request == nil || f.Response == nil || f.Request.Method != http.MethodGet ||
+			f.Response.Truncated || !slices.Contains(storableStatus, f.Response.StatusCode) {
+			continue
+		}
+		if f.Response.Body == "" && f.Response.BodySize > 0 {
+			continue
+		}
+		req, err := http.NewRequest(http.MethodGet, f.Request.URL, nil)
+		if err != nil {
+			continue
+		}
+		for k, v := range f.Request.Headers {
+			req.Header.Set(k, v)
+		}
+		header := http.Header{}
+		for k, v := range f.Response.Headers {
+			header.Set(k, v)
+		}
+		body, err := f.Response.RawBody()
+		if err != nil {
+			continue
+		}
+		if err := c.store(newEntry(req, f.Response.StatusCode, header, body, f.Response.Timestamp)); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// matches reports whether req repeats the headers e varies on.
+func (e *Entry) matches(req *http.Request) bool {
+	for name, value := range e.Vary {
+		if req.Header.Get(name) != value {
+			return false
+		}
+	}
+	return true
+}
+
+// fresh reports whether e may still be served without the origin.
+func (e *Entry) fresh(now time.Time) bool {
+	cc := directives(e.Header)
+	if _, ok := cc["no-cache"]; ok {
+		return false
+	}
+	lifetime, ok := e.lifetime(cc)
+	return ok && e.Age(now) < lifetime
+}
+
+// lifetime is how long e stays fresh, from s-maxage, max-age or Expires.
+func (e *Entry) lifetime(cc map[string]string) (time.Duration, bool) {
+	for _, d := range []string{"s-maxage", "max-age"} {
+		if v, ok := cc[d]; ok {
+			secs, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return 0, false
+			}
+			return time.Duration(secs) * time.Second, true
+		}
+	}
+	if v := e.Header.Get("Expires"); v != "" {
+		expires, err := http.ParseTime(v)
+		if err != nil {
+			return 0, false
+		}
+		date := e.Stored
+		if d, err := http.ParseTime(e.Header.Get("Date")); err == nil {
+			date = d
+		}
+		return expires.Sub(date), true
+	}
+	return 0, false
+}
+
+// noCache reports whether a request asks not to be answered from a cache.
+func noCache(h http.Header) bool {
+	cc := directives(h)
+	if _, ok := cc["no-cache"]; ok {
+		return true
+	}
+	if cc["max-age"] == "0" {
+		return true
+	}
+	_, ok := cc["no-store"]
+	return ok || (len(cc) == 0 && strings.EqualFold(h.Get("Pragma"), "no-cache"))
+}
+
+// directives parses the Cache-Control header of h.
+func directives(h http.Header) map[string]string {
+	cc := map[string]string{}
+	for _, v := range h.Values("Cache-Control") {
+		for _, part := range strings.Split(v, ",") {
+			name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+			if name != "" {
+				cc[strings.ToLower(name)] = strings.Trim(value, `"`)
+			}
+		}
+	}
+	return cc
+}
+
+func varyNames(h http.Header) []string {
+	var names []string
+	for _, v := range h.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	return names
+}
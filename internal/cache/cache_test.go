@@ -0,0 +1,157 @@
+package cache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/standrze/rogue/internal/logger"
+)
+
+func response(status int, headers ...string) *http.Response {
+	res := &http.Response{StatusCode: status, Header: http.Header{}}
+	for i := 0; i+1 < len(headers); i += 2 {
+		res.Header.Add(headers[i], headers[i+1])
+	}
+	return res
+}
+
+func TestFreshness(t *testing.T) {
+	c, err := New(Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	put := func(url string, res *http.Response) {
+		req := httptest.NewRequest("GET", url, nil)
+		if !c.Storable(req, res) {
+			t.Fatalf("%s: not storable", url)
+		}
+		c.Put(req, res.StatusCode, res.Header, []byte("body"))
+	}
+	put("http://a.test/fresh", response(200, "Cache-Control", "public, max-age=60"))
+	put("http://a.test/expired", response(200, "Cache-Control", "max-age=0"))
+	put("http://a.test/expires", response(200, "Expires", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)))
+	put("http://a.test/revalidate", response(200, "Cache-Control", "no-cache"))
+	put("http://a.test/no-lifetime", response(200))
+
+	tests := map[string]bool{
+		"/fresh":       true,
+		"/expired":     false,
+		"/expires":     true,
+		"/revalidate":  false,
+		"/no-lifetime": false,
+		"/missing":     false,
+	}
+	for path, want := range tests {
+		if _, got := c.Get(httptest.NewRequest("GET", "http://a.test"+path, nil)); got != want {
+			t.Errorf("%s: hit %v, want %v", path, got, want)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "http://a.test/fresh", nil)
+	req.Header.Set("Cache-Control", "no-cache")
+	if _, ok := c.Get(req); ok {
+		t.Error("Request with no-cache served from the cache")
+	}
+	if s := c.Stats(); s.Hits != 2 || s.Entries != 5 {
+		t.Errorf("Stats %+v", s)
+	}
+}
+
+func TestStorable(t *testing.T) {
+	c, _ := New(Options{})
+	get := httptest.NewRequest("GET", "http://a.test/", nil)
+	auth := httptest.NewRequest("GET", "http://a.test/", nil)
+	auth.Header.Set("Authorization", "Bearer x")
+
+	tests := []struct {
+		name string
+		req  *http.Request
+		res  *http.Response
+		want bool
+	}{
+		{"ok", get, response(200), true},
+		{"post", httptest.NewRequest("POST", "http://a.test/", nil), response(200), false},
+		{"server error", get, response(500), false},
+		{"no-store", get, response(200, "Cache-Control", "no-store"), false},
+		{"private", get, response(200, "Cache-Control", "private, max-age=60"), false},
+		{"vary star", get, response(200, "Vary", "*"), false},
+		{"authorized", auth, response(200, "Cache-Control", "max-age=60"), false},
+		{"authorized public", auth, response(200, "Cache-Control", "public, max-age=60"), true},
+	}
+	for _, tt := range tests {
+		if got := c.Storable(tt.req, tt.res); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	ignore, _ := New(Options{IgnoreCacheControl: true})
+	if !ignore.Storable(get, response(200, "Cache-Control", "no-store")) {
+		t.Error("no-store response not stored when ignoring Cache-Control")
+	}
+}
+
+func TestVary(t *testing.T) {
+	c, _ := New(Options{IgnoreCacheControl: true})
+	req := httptest.NewRequest("GET", "http://a.test/", nil)
+	req.Header.Set("Accept-Language", "de")
+	c.Put(req, 200, http.Header{"Vary": {"accept-language"}, "Content-Length": {"4"}}, []byte("body"))
+
+	e, ok := c.Get(req)
+	if !ok {
+		t.Fatal("Expected a hit for the same Accept-Language")
+	}
+	if e.Header.Get("Content-Length") != "" {
+		t.Error("Content-Length was stored")
+	}
+	other := httptest.NewRequest("GET", "http://a.test/", nil)
+	other.Header.Set("Accept-Language", "fr")
+	if _, ok := c.Get(other); ok {
+		t.Error("Served a response varying on Accept-Language to another language")
+	}
+}
+
+func TestDiskAndOffline(t *testing.T) {
+	dir := t.TempDir()
+	c, _ := New(Options{Dir: dir})
+	req := httptest.NewRequest("GET", "http://a.test/page", nil)
+	c.Put(req, 200, http.Header{"Content-Type": {"text/html"}}, []byte("<p>hi</p>"))
+
+	// A new cache over the same directory, offline, serves the stale entry.
+	offline, _ := New(Options{Dir: dir, Offline: true})
+	e, ok := offline.Get(req)
+	if !ok || string(e.Body) != "<p>hi</p>" || e.Header.Get("Content-Type") != "text/html" {
+		t.Fatalf("Got %+v, %v", e, ok)
+	}
+	if _, ok := offline.Get(httptest.NewRequest("GET", "http://a.test/other", nil)); ok {
+		t.Error("Hit for a URL never stored")
+	}
+}
+
+func TestSeed(t *testing.T) {
+	stored := time.Now().Add(-time.Hour)
+	flows := []*logger.Flow{
+		{
+			Request:  &logger.RequestLog{Method: "GET", URL: "http://a.test/app.js"},
+			Response: &logger.ResponseLog{Timestamp: stored, StatusCode: 200, Headers: map[string]string{"Content-Type": "text/javascript"}, Body: "x=1", BodySize: 3},
+		},
+		{
+			Request:  &logger.RequestLog{Method: "GET", URL: "http://a.test/big"},
+			Response: &logger.ResponseLog{StatusCode: 200, Body: "par", BodySize: 1000, Truncated: true},
+		},
+		{
+			Request:  &logger.RequestLog{Method: "POST", URL: "http://a.test/form"},
+			Response: &logger.ResponseLog{StatusCode: 200, Body: "ok", BodySize: 2},
+		},
+	}
+	c, _ := New(Options{Offline: true})
+	n, err := c.Seed(flows)
+	if err != nil || n != 1 {
+		t.Fatalf("Seeded %d (%v), want 1", n, err)
+	}
+	e, ok := c.Get(httptest.NewRequest("GET", "http://a.test/app.js", nil))
+	if !ok || string(e.Body) != "x=1" || !e.Stored.Equal(stored) {
+		t.Errorf("Got %+v, %v", e, ok)
+	}
+}
@@ -0,0 +1,166 @@
+// Package capture ends a capture session once it has what it was started
+// for: after a time, a number of requests, or a request to a given URL.
+package capture
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/standrze/rogue/internal/logger"
+)
+
+// ResponseWait is how long the capture waits for the response to the
+// request that ends it before stopping without it.
+const ResponseWait = 10 * time.Second
+
+// Stop says when a capture ends. Zero fields never end it.
+type Stop struct {
+	Duration time.Duration
+	// MaxRequests ends the capture with the response to the request that
+	// reaches it.
+	MaxRequests int
+	// URL ends the capture with the response to the first request whose
+	// URL matches it. * matches any run of characters; a URL without one
+	// also matches the URLs it is a prefix of, such as with a query.
+	URL string
+}
+
+// Watcher is a session sink that watches the entries written for the
+// conditions of a Stop, and collects the session files of the capture.
+type Watcher struct {
+	stop  Stop
+	url   *regexp.Regexp
+	done  chan struct{}
+	timer *time.Timer
+
+	mu       sync.Mutex
+	requests int
+	// last is the ID of the request whose response ends the capture, for
+	// the reason given by ending.
+	last, ending string
+	reason       string
+	sessions     []string
+}
+
+// New returns a watcher for s. The duration counts from now.
+func New(s Stop) (*Watcher, error) {
+	if s.Duration < 0 || s.MaxRequests < 0 {
+		return nil, fmt.Errorf("the duration and request limit cannot be negative")
+	}
+	w := &Watcher{stop: s, done: make(chan struct{})}
+	if s.URL != "" {
+		pattern := regexp.QuoteMeta(s.URL)
+		if strings.Contains(s.URL, "*") {
+			pattern = strings.ReplaceAll(pattern, `\*`, ".*") + "$"
+		}
+		w.url = regexp.MustCompile("(?i)^" + pattern)
+	}
+	if s.Duration > 0 {
+		w.timer = time.AfterFunc(s.Duration, func() {
+			w.end(fmt.Sprintf("%s elapsed", s.Duration))
+		})
+	}
+	return w, nil
+}
+
+// Done is closed once the capture should end.
+func (w *Watcher) Done() <-chan struct{} {
+	return w.done
+}
+
+// Reason says why the capture ended.
+func (w *Watcher) Reason() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.reason
+}
+
+func (w *Watcher) end(reason string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.endLocked(reason)
+}
+
+func (w *Watcher) endLocked(reason string) {
+	if w.reason != "" {
+		return
+	}
+	w.reason = reason
+	close(w.done)
+}
+
+// entry is the part of a session entry the watcher reads.
+type entry struct {
+	Type string `json:"type"`
+	Data struct {
+		URL       string `json:"url"`
+		RequestID string `json:"request_id"`
+	} `json:"data"`
+}
+
+func (w *Watcher) Send(entries [][]byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, data := range entries {
+		var e entry
+		if json.Unmarshal(data, &e) != nil {
+			continue
+		}
+		switch e.Type {
+		case "request":
+			w.request(e.Data.RequestID, e.Data.URL)
+		case "response":
+			if w.last != "" && e.Data.RequestID == w.last {
+				w.endLocked(w.ending)
+			}
+		}
+	}
+	return nil
+}
+
+// request counts a request and picks it as the last one when it meets a
+// condition. w.mu must be held.
+func (w *Watcher) request(id, url string) {
+	w.requests++
+	if w.last != "" || w.reason != "" {
+		return
+	}
+	switch {
+	case w.url != nil && w.url.MatchString(url):
+		w.ending = "saw " + url
+	case w.stop.MaxRequests > 0 && w.requests >= w.stop.MaxRequests:
+		w.ending = fmt.Sprintf("%d requests", w.requests)
+	default:
+		return
+	}
+	w.last = id
+	time.AfterFunc(ResponseWait, func() {
+		w.end(w.ending + " (no response)")
+	})
+}
+
+func (w *Watcher) Close() error {
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	return nil
+}
+
+// SessionClosed records a session file of the capture, for use as the
+// session logger's close hook.
+func (w *Watcher) SessionClosed(f logger.SessionFile) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.sessions = append(w.sessions, f.Path)
+}
+
+// Sessions returns the paths of the session files written, in order.
+func (w *Watcher) Sessions() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]string(nil), w.sessions...)
+}
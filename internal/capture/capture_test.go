@@ -0,0 +1,80 @@
+package capture
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func request(id, url string) []byte {
+	return fmt.Appendf(nil, `{"type":"request","data":{"request_id":%q,"url":%q}}`, id, url)
+}
+
+func response(id string) []byte {
+	return fmt.Appendf(nil, `{"type":"response","data":{"request_id":%q,"status_code":200}}`, id)
+}
+
+func ended(w *Watcher) bool {
+	select {
+	case <-w.Done():
+		return true
+	default:
+		return false
+	}
+}
+
+func TestURLTrigger(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, url string
+		match        bool
+	}{
+		{"https://app.example.com/checkout", "https://app.example.com/checkout?step=2", true},
+		{"https://app.example.com/checkout", "https://app.example.com/cart", false},
+		{"https://*.example.com/*/done", "https://pay.example.com/order/done", true},
+		{"https://*.example.com/*/done", "https://pay.example.com/order/done?x", false},
+	} {
+		w, err := New(Stop{URL: tc.pattern})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Send([][]byte{request("1", "https://other.test/"), response("1"), request("2", tc.url)})
+		if ended(w) {
+			t.Fatalf("%s: ended before the response", tc.pattern)
+		}
+		w.Send([][]byte{response("2")})
+		if ended(w) != tc.match {
+			t.Errorf("%s ~ %s: ended = %v", tc.pattern, tc.url, ended(w))
+		}
+		if tc.match && w.Reason() != "saw "+tc.url {
+			t.Errorf("reason = %q", w.Reason())
+		}
+	}
+}
+
+func TestMaxRequests(t *testing.T) {
+	w, _ := New(Stop{MaxRequests: 2})
+	w.Send([][]byte{request("a", "http://a.test/"), request("b", "http://a.test/"), request("c", "http://a.test/"), response("a")})
+	if ended(w) {
+		t.Fatal("ended on the response to the first request")
+	}
+	w.Send([][]byte{response("b")})
+	if !ended(w) || w.Reason() != "2 requests" {
+		t.Errorf("ended = %v, reason %q", ended(w), w.Reason())
+	}
+}
+
+func TestDuration(t *testing.T) {
+	w, _ := New(Stop{Duration: 10 * time.Millisecond})
+	defer w.Close()
+	select {
+	case <-w.Done():
+	case <-time.After(time.Second):
+		t.Fatal("the duration did not end the capture")
+	}
+	if w.Reason() != "10ms elapsed" {
+		t.Errorf("reason = %q", w.Reason())
+	}
+	if _, err := New(Stop{MaxRequests: -1}); err == nil {
+		t.Error("negative limit accepted")
+	}
+}
@@ -1,30 +1,85 @@
 package cert
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"math/big"
 	"os"
 	"path/filepath"
 	"time"
+
+	"github.com/youmark/pkcs8"
 )
 
-func GenerateSelfSigned(org, commonName string, validDays int, certPath, keyPath string) error {
-	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+// PassphraseFunc supplies the passphrase protecting a private key. It is only
+// called when the key on disk is encrypted.
+type PassphraseFunc func() ([]byte, error)
+
+// DefaultKeyPerm is the mode private keys are written with unless
+// KeyOptions says otherwise.
+const DefaultKeyPerm os.FileMode = 0600
+
+// KeyOptions controls how a generated private key is stored.
+type KeyOptions struct {
+	// Passphrase encrypts the key when non-empty.
+	Passphrase []byte
+	// Perm is the key file mode; zero means DefaultKeyPerm.
+	Perm os.FileMode
+}
+
+// GenerateSelfSigned creates a root CA, storing its key as described by opts.
+func GenerateSelfSigned(org, commonName string, validDays int, certPath, keyPath string, opts KeyOptions) error {
+	der, priv, err := selfSigned(org, commonName, validDays)
 	if err != nil {
 		return err
 	}
 
+	if err := makeDirs(certPath, keyPath); err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		return err
+	}
+	return writeKey(keyPath, priv, opts)
+}
+
+// NewSelfSigned creates a root CA in memory, returning the certificate and
+// its unencrypted PKCS#8 key as PEM. Nothing is written to disk.
+func NewSelfSigned(org, commonName string, validDays int) (certPEM, keyPEM []byte, err error) {
+	der, priv, err := selfSigned(org, commonName, validDays)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key}), nil
+}
+
+// selfSigned creates the certificate and key of a root CA.
+func selfSigned(org, commonName string, validDays int) ([]byte, *rsa.PrivateKey, error) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	notBefore := time.Now()
 	notAfter := notBefore.Add(time.Duration(validDays) * 24 * time.Hour)
 
 	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
 	template := x509.Certificate{
@@ -41,37 +96,48 @@ func GenerateSelfSigned(org, commonName string, validDays int, certPath, keyPath
 		IsCA:                  true,
 	}
 
-	derBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, &priv.PublicKey, priv)
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &priv.PublicKey, priv)
 	if err != nil {
-		return err
-	}
-
-	if err := os.MkdirAll(filepath.Dir(certPath), 0755); err != nil {
-		return err
+		return nil, nil, err
 	}
+	return der, priv, nil
+}
 
-	certOut, err := os.Create(certPath)
-	if err != nil {
-		return err
+// makeDirs creates the directories holding the CA. Ones that did not exist
+// are private to the user since they may hold the key.
+func makeDirs(paths ...string) error {
+	for _, p := range paths {
+		if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+			return err
+		}
 	}
-	defer certOut.Close()
+	return nil
+}
 
-	if err := pem.Encode(certOut, &pem.Block{Type: "CERTIFICATE", Bytes: derBytes}); err != nil {
-		return err
+// writeKey stores priv as PKCS#8, encrypted with PBES2 (PBKDF2 and
+// AES-256-CBC) when a passphrase is given. The mode is applied even when an
+// existing key is overwritten.
+func writeKey(path string, priv any, opts KeyOptions) error {
+	block := &pem.Block{Type: "PRIVATE KEY"}
+	var err error
+	if len(opts.Passphrase) > 0 {
+		block.Type = "ENCRYPTED PRIVATE KEY"
+		block.Bytes, err = pkcs8.MarshalPrivateKey(priv, opts.Passphrase, nil)
+	} else {
+		block.Bytes, err = x509.MarshalPKCS8PrivateKey(priv)
 	}
-
-	keyOut, err := os.Create(keyPath)
 	if err != nil {
 		return err
 	}
-	defer keyOut.Close()
 
-	privBytes, err := x509.MarshalPKCS8PrivateKey(priv)
-	if err != nil {
+	perm := opts.Perm
+	if perm == 0 {
+		perm = DefaultKeyPerm
+	}
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), perm); err != nil {
 		return err
 	}
-
-	return pem.Encode(keyOut, &pem.Block{Type: "PRIVATE KEY", Bytes: privBytes})
+	return os.Chmod(path, perm)
 }
 
 func Exists(certPath, keyPath string) bool {
@@ -80,36 +146,177 @@ func Exists(certPath, keyPath string) bool {
 	return certErr == nil && keyErr == nil
 }
 
-func Load(certPath, keyPath string) (*x509.Certificate, any, error) {
-	certPEM, err := os.ReadFile(certPath)
+func Load(certPath, keyPath string, passphrase PassphraseFunc) (*x509.Certificate, any, error) {
+	chain, key, err := LoadChain(certPath, keyPath, passphrase)
 	if err != nil {
 		return nil, nil, err
 	}
+	return chain[0], key, nil
+}
 
-	block, _ := pem.Decode(certPEM)
-	if block == nil {
-		return nil, nil, fmt.Errorf("failed to parse certificate PEM")
-	}
-
-	cert, err := x509.ParseCertificate(block.Bytes)
+// LoadChain loads a signing CA and its key. The certificate file may hold
+// the CA followed by the intermediates up to an organization's root, which
+// are then presented to clients alongside minted leaf certificates. Keys may
+// be PKCS#8, PKCS#1 or SEC 1 encoded; encrypted PKCS#8 keys are unlocked with
+// the passphrase, which may be nil if none is available.
+func LoadChain(certPath, keyPath string, passphrase PassphraseFunc) ([]*x509.Certificate, crypto.Signer, error) {
+	certPEM, err := os.ReadFile(certPath)
 	if err != nil {
 		return nil, nil, err
 	}
-
 	keyPEM, err := os.ReadFile(keyPath)
 	if err != nil {
 		return nil, nil, err
 	}
+	return parseChain(certPEM, keyPEM, certPath, keyPath, passphrase)
+}
+
+// ParseChain is LoadChain for a CA held in memory, such as one passed in
+// the environment.
+func ParseChain(certPEM, keyPEM []byte, passphrase PassphraseFunc) ([]*x509.Certificate, crypto.Signer, error) {
+	return parseChain(certPEM, keyPEM, "CA certificate", "CA key", passphrase)
+}
+
+// parseChain parses the CA and key of LoadChain; certName and keyName
+// identify them in errors.
+func parseChain(certPEM, keyPEM []byte, certName, keyName string, passphrase PassphraseFunc) ([]*x509.Certificate, crypto.Signer, error) {
+	var chain []*x509.Certificate
+	for rest := certPEM; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		c, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, nil, err
+		}
+		chain = append(chain, c)
+	}
+	if len(chain) == 0 {
+		return nil, nil, fmt.Errorf("failed to parse certificate PEM")
+	}
 
-	block, _ = pem.Decode(keyPEM)
+	ca := chain[0]
+	if !ca.IsCA || ca.KeyUsage&x509.KeyUsageCertSign == 0 {
+		return nil, nil, fmt.Errorf("%s: %q is not a CA certificate allowed to sign certificates", certName, ca.Subject.CommonName)
+	}
+
+	block, _ := pem.Decode(keyPEM)
 	if block == nil {
 		return nil, nil, fmt.Errorf("failed to parse private key PEM")
 	}
 
-	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	key, err := parsePrivateKey(block, passphrase)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, fmt.Errorf("%s: %w", keyName, err)
+	}
+	if !publicKeysEqual(ca.PublicKey, key.Public()) {
+		return nil, nil, fmt.Errorf("%s does not match the certificate in %s", keyName, certName)
+	}
+
+	return chain, key, nil
+}
+
+func parsePrivateKey(block *pem.Block, passphrase PassphraseFunc) (crypto.Signer, error) {
+	var key any
+	var err error
+	switch block.Type {
+	case "ENCRYPTED PRIVATE KEY":
+		if passphrase == nil {
+			return nil, errors.New("private key is encrypted and no passphrase is available")
+		}
+		var pw []byte
+		if pw, err = passphrase(); err != nil {
+			return nil, err
+		}
+		key, _, err = pkcs8.ParsePrivateKey(block.Bytes, pw)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+	return signer, nil
+}
+
+func publicKeysEqual(a, b crypto.PublicKey) bool {
+	k, ok := a.(interface{ Equal(crypto.PublicKey) bool })
+	return ok && k.Equal(b)
+}
+
+// CreateIntermediate issues an intermediate signing CA under an existing
+// root, so leaf certificates chain to a root that devices already trust. The
+// intermediate is written to certPath followed by the root's chain, and its
+// key is stored as described by opts.
+func CreateIntermediate(rootCertPath, rootKeyPath string, rootPassphrase PassphraseFunc, org, commonName string, validDays int, certPath, keyPath string, opts KeyOptions) error {
+	rootChain, rootKey, err := LoadChain(rootCertPath, rootKeyPath, rootPassphrase)
+	if err != nil {
+		return err
+	}
+	root := rootChain[0]
+
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+
+	notBefore := time.Now()
+	notAfter := notBefore.Add(time.Duration(validDays) * 24 * time.Hour)
+	if notAfter.After(root.NotAfter) {
+		notAfter = root.NotAfter
+	}
+
+	template := x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject: pkix.Name{
+			Organization: []string{org},
+			CommonName:   commonName,
+		},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		// Only leaf certificates may be issued below the intermediate.
+		MaxPathLenZero: true,
+	}
+
+	derBytes, err := x509.CreateCertificate(rand.Reader, &template, root, priv.Public(), rootKey)
+	if err != nil {
+		return err
+	}
+
+	if err := makeDirs(certPath, keyPath); err != nil {
+		return err
+	}
+
+	var chainPEM []byte
+	chainPEM = append(chainPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: derBytes})...)
+	for _, c := range rootChain {
+		chainPEM = append(chainPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Raw})...)
+	}
+	if err := os.WriteFile(certPath, chainPEM, 0644); err != nil {
+		return err
 	}
 
-	return cert, key, nil
+	return writeKey(keyPath, priv, opts)
 }
@@ -0,0 +1,132 @@
+package cert
+
+import (
+	"crypto/x509"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCreateIntermediate(t *testing.T) {
+	dir := t.TempDir()
+	rootCert, rootKey := filepath.Join(dir, "root.crt"), filepath.Join(dir, "root.key")
+	if err := GenerateSelfSigned("Example Corp", "Example Root", 30, rootCert, rootKey, KeyOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	certPath, keyPath := filepath.Join(dir, "ca.crt"), filepath.Join(dir, "ca.key")
+	if err := CreateIntermediate(rootCert, rootKey, nil, "Example Corp", "Rogue Intermediate", 365, certPath, keyPath, KeyOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	chain, key, err := LoadChain(certPath, keyPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chain) != 2 || chain[1].Subject.CommonName != "Example Root" {
+		t.Fatalf("Expected intermediate followed by root, got %d certificates", len(chain))
+	}
+	if key == nil {
+		t.Fatal("Expected a signing key")
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(chain[1])
+	if _, err := chain[0].Verify(x509.VerifyOptions{Roots: roots}); err != nil {
+		t.Errorf("Intermediate does not chain to the root: %v", err)
+	}
+	if !chain[0].NotAfter.Equal(chain[1].NotAfter) {
+		t.Error("Expected intermediate validity to be capped by the root")
+	}
+
+	// A key that does not belong to the certificate is rejected.
+	if _, _, err := LoadChain(certPath, rootKey, nil); err == nil {
+		t.Error("Expected mismatched key to be rejected")
+	}
+}
+
+func TestEncryptedKey(t *testing.T) {
+	dir := t.TempDir()
+	certPath, keyPath := filepath.Join(dir, "ca.crt"), filepath.Join(dir, "ca.key")
+	if err := GenerateSelfSigned("Rogue Proxy", "Rogue CA", 30, certPath, keyPath, KeyOptions{Passphrase: []byte("hunter2")}); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("Expected key file mode 0600, got %o", perm)
+	}
+	keyPEM, _ := os.ReadFile(keyPath)
+	if !strings.Contains(string(keyPEM), "ENCRYPTED PRIVATE KEY") {
+		t.Fatal("Expected an encrypted PKCS#8 key")
+	}
+
+	passphrase := func(p string) PassphraseFunc {
+		return func() ([]byte, error) { return []byte(p), nil }
+	}
+	if _, _, err := LoadChain(certPath, keyPath, passphrase("hunter2")); err != nil {
+		t.Errorf("Failed to load with the right passphrase: %v", err)
+	}
+	if _, _, err := LoadChain(certPath, keyPath, passphrase("wrong")); err == nil {
+		t.Error("Expected a wrong passphrase to be rejected")
+	}
+	if _, _, err := LoadChain(certPath, keyPath, nil); err == nil {
+		t.Error("Expected an encrypted key without a passphrase to be rejected")
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	dir := t.TempDir()
+	oldCert, oldKey := filepath.Join(dir, "certs", "ca.crt"), filepath.Join(dir, "certs", "ca.key")
+	if err := GenerateSelfSigned("Rogue Proxy", "Rogue CA", 30, oldCert, oldKey, KeyOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	// Keys written by earlier versions were world readable.
+	if err := os.Chmod(oldKey, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	newCert, newKey := filepath.Join(dir, "data", "rogue", "ca.crt"), filepath.Join(dir, "data", "rogue", "ca.key")
+	if err := Migrate(oldCert, oldKey, newCert, newKey, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if Exists(oldCert, oldKey) || !Exists(newCert, newKey) {
+		t.Fatal("Expected the CA to be moved")
+	}
+	if info, _ := os.Stat(newKey); info.Mode().Perm() != 0600 {
+		t.Errorf("Expected migrated key mode 0600, got %o", info.Mode().Perm())
+	}
+	if _, _, err := LoadChain(newCert, newKey, nil); err != nil {
+		t.Errorf("Migrated CA does not load: %v", err)
+	}
+
+	if err := Migrate(oldCert, oldKey, newCert, newKey, 0600); err == nil {
+		t.Error("Expected migrating a missing CA to fail")
+	}
+}
+
+func TestNewSelfSigned(t *testing.T) {
+	certPEM, keyPEM, err := NewSelfSigned("Rogue Proxy", "Rogue CA", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain, key, err := ParseChain(certPEM, keyPEM, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chain) != 1 || chain[0].Subject.CommonName != "Rogue CA" || key == nil {
+		t.Fatalf("chain = %d certificates, key %v", len(chain), key)
+	}
+
+	_, otherKey, err := NewSelfSigned("Rogue Proxy", "Other CA", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ParseChain(certPEM, otherKey, nil); err == nil {
+		t.Error("Expected mismatched key to be rejected")
+	}
+}
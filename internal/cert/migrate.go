@@ -0,0 +1,66 @@
+package cert
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// Migrate moves a CA from its old location to a new one, for example from
+// the working directory into the data directory, and restricts the key file
+// to perm. Existing files at the destination are never replaced.
+func Migrate(oldCertPath, oldKeyPath, newCertPath, newKeyPath string, perm os.FileMode) error {
+	if !Exists(oldCertPath, oldKeyPath) {
+		return fmt.Errorf("no CA found at %s and %s", oldCertPath, oldKeyPath)
+	}
+	for _, p := range []string{newCertPath, newKeyPath} {
+		if _, err := os.Stat(p); err == nil {
+			return fmt.Errorf("%s already exists", p)
+		}
+	}
+
+	if err := makeDirs(newCertPath, newKeyPath); err != nil {
+		return err
+	}
+	// The key is restricted before it is moved so it is never exposed at
+	// the new location.
+	if err := os.Chmod(oldKeyPath, perm); err != nil {
+		return err
+	}
+	if err := moveFile(oldKeyPath, newKeyPath); err != nil {
+		return err
+	}
+	return moveFile(oldCertPath, newCertPath)
+}
+
+// moveFile renames src to dst, copying when they are on different file
+// systems.
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
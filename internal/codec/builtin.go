@@ -0,0 +1,297 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/standrze/rogue/internal/grpcdecode"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func builtins() []Spec {
+	return []Spec{
+		{Name: "msgpack", MediaTypes: []string{"application/msgpack", "application/x-msgpack", "application/vnd.msgpack"}, Binary: true, Codec: MsgPack{}},
+		{Name: "protobuf", MediaTypes: []string{"application/x-protobuf", "application/protobuf", "application/vnd.google.protobuf"}, Binary: true, Codec: Protobuf{}},
+		{Name: "form", MediaTypes: []string{"application/x-www-form-urlencoded"}, Codec: Form{}},
+		{Name: "xml", MediaTypes: []string{"application/xml", "text/xml", "+xml"}, Codec: XML{}},
+		{Name: "json", MediaTypes: []string{"application/json", "text/json", "+json"}, Codec: JSON{}},
+	}
+}
+
+// JSON decodes into generic values, keeping numbers exact, and encodes
+// indented.
+type JSON struct{}
+
+func (JSON) Decode(body []byte) (any, error) {
+	d := json.NewDecoder(bytes.NewReader(body))
+	d.UseNumber()
+	var v any
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func (JSON) Encode(v any) ([]byte, error) {
+	return json.MarshalIndent(v, "", "  ")
+}
+
+// Form decodes URL-encoded forms into url.Values.
+type Form struct{}
+
+func (Form) Decode(body []byte) (any, error) {
+	return url.ParseQuery(string(body))
+}
+
+func (Form) Encode(v any) ([]byte, error) {
+	switch vals := v.(type) {
+	case url.Values:
+		return []byte(vals.Encode()), nil
+	case map[string]any:
+		form := url.Values{}
+		for k, raw := range vals {
+			switch x := raw.(type) {
+			case []any:
+				for _, item := range x {
+					form.Add(k, fmt.Sprint(item))
+				}
+			default:
+				form.Add(k, fmt.Sprint(x))
+			}
+		}
+		return []byte(form.Encode()), nil
+	}
+	return nil, fmt.Errorf("form: cannot encode %T", v)
+}
+
+// XMLNode is a generic XML element. Names keep their namespace prefix so a
+// document re-encodes as written.
+type XMLNode struct {
+	Name     string            `json:"name"`
+	Attrs    map[string]string `json:"attrs,omitempty"`
+	Text     string            `json:"text,omitempty"`
+	Children []*XMLNode        `json:"children,omitempty"`
+}
+
+// XML decodes a document into its root XMLNode.
+type XML struct{}
+
+func xmlName(n xml.Name) string {
+	if n.Space != "" {
+		return n.Space + ":" + n.Local
+	}
+	return n.Local
+}
+
+func (XML) Decode(body []byte) (any, error) {
+	d := xml.NewDecoder(bytes.NewReader(body))
+	var stack []*XMLNode
+	var root *XMLNode
+	for {
+		tok, err := d.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			n := &XMLNode{Name: xmlName(t.Name)}
+			for _, a := range t.Attr {
+				if n.Attrs == nil {
+					n.Attrs = make(map[string]string)
+				}
+				n.Attrs[xmlName(a.Name)] = a.Value
+			}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.Children = append(parent.Children, n)
+			} else if root == nil {
+				root = n
+			}
+			stack = append(stack, n)
+		case xml.EndElement:
+			if len(stack) == 0 {
+				return nil, errors.New("xml: unexpected end element")
+			}
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			if len(stack) > 0 {
+				stack[len(stack)-1].Text += strings.TrimSpace(string(t))
+			}
+		}
+	}
+	if root == nil {
+		return nil, errors.New("xml: no root element")
+	}
+	return root, nil
+}
+
+func (XML) Encode(v any) ([]byte, error) {
+	root, err := toXMLNode(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	e := xml.NewEncoder(&buf)
+	e.Indent("", "  ")
+	if err := encodeXMLNode(e, root); err != nil {
+		return nil, err
+	}
+	if err := e.Flush(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func toXMLNode(v any) (*XMLNode, error) {
+	if n, ok := v.(*XMLNode); ok {
+		return n, nil
+	}
+	// Generic JSON form, as returned by an editor.
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var n XMLNode
+	if err := json.Unmarshal(raw, &n); err != nil {
+		return nil, fmt.Errorf("xml: %w", err)
+	}
+	return &n, nil
+}
+
+func encodeXMLNode(e *xml.Encoder, n *XMLNode) error {
+	start := xml.StartElement{Name: xml.Name{Local: n.Name}}
+	for k, v := range n.Attrs {
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: k}, Value: v})
+	}
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	if n.Text != "" {
+		if err := e.EncodeToken(xml.CharData(n.Text)); err != nil {
+			return err
+		}
+	}
+	for _, c := range n.Children {
+		if err := encodeXMLNode(e, c); err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(start.End())
+}
+
+// Protobuf decodes messages without a schema into wire fields.
+type Protobuf struct{}
+
+func (Protobuf) Decode(body []byte) (any, error) {
+	return grpcdecode.DecodeWire(body)
+}
+
+func (Protobuf) Encode(v any) ([]byte, error) {
+	fields, ok := v.([]grpcdecode.WireField)
+	if !ok {
+		raw, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		if fields, err = wireFieldsFromJSON(raw); err != nil {
+			return nil, fmt.Errorf("protobuf: %w", err)
+		}
+	}
+	return grpcdecode.EncodeWire(fields)
+}
+
+// wireFieldsFromJSON rebuilds wire fields from their JSON rendering, keeping
+// 64-bit integers exact.
+func wireFieldsFromJSON(raw []byte) ([]grpcdecode.WireField, error) {
+	var generic []struct {
+		Field int             `json:"field"`
+		Type  string          `json:"type"`
+		Value json.RawMessage `json:"value"`
+	}
+	if err := json.Unmarshal(raw, &generic); err != nil {
+		return nil, err
+	}
+
+	fields := make([]grpcdecode.WireField, len(generic))
+	for i, g := range generic {
+		f := grpcdecode.WireField{Field: g.Field, Type: g.Type}
+		switch g.Type {
+		case "message":
+			nested, err := wireFieldsFromJSON(g.Value)
+			if err != nil {
+				return nil, err
+			}
+			f.Value = nested
+		case "varint", "fixed64", "fixed32":
+			n, err := strconv.ParseUint(string(g.Value), 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("field %d: %w", g.Field, err)
+			}
+			f.Value = n
+		default:
+			var s string
+			if err := json.Unmarshal(g.Value, &s); err != nil {
+				return nil, fmt.Errorf("field %d: %w", g.Field, err)
+			}
+			f.Value = s
+		}
+		fields[i] = f
+	}
+	return fields, nil
+}
+
+// MsgPack decodes MessagePack into generic values.
+type MsgPack struct{}
+
+func (MsgPack) Decode(body []byte) (any, error) {
+	d := msgpack.NewDecoder(bytes.NewReader(body))
+	// String keys keep the result marshalable as JSON.
+	d.SetMapDecoder(func(d *msgpack.Decoder) (any, error) {
+		return d.DecodeUntypedMap()
+	})
+	d.UseLooseInterfaceDecoding(true)
+	var v any
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+	return jsonSafe(v), nil
+}
+
+func (MsgPack) Encode(v any) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+// jsonSafe converts maps with non-string keys, which encoding/json rejects.
+func jsonSafe(v any) any {
+	switch x := v.(type) {
+	case map[any]any:
+		m := make(map[string]any, len(x))
+		for k, val := range x {
+			m[fmt.Sprint(k)] = jsonSafe(val)
+		}
+		return m
+	case map[string]any:
+		for k, val := range x {
+			x[k] = jsonSafe(val)
+		}
+		return x
+	case []any:
+		for i, val := range x {
+			x[i] = jsonSafe(val)
+		}
+		return x
+	}
+	return v
+}
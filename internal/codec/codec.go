@@ -0,0 +1,101 @@
+// Package codec maps content types to body encoders and decoders. Logging,
+// exports and any feature that edits bodies look codecs up here, so a codec
+// registered once is understood everywhere.
+package codec
+
+import (
+	"mime"
+	"strings"
+	"sync"
+)
+
+// Codec converts between a body and a structured value. Encode must accept
+// the values Decode returns, as well as their generic JSON form
+// (map[string]any, []any, ...) after a round trip through an editor.
+type Codec interface {
+	Decode(body []byte) (any, error)
+	Encode(v any) ([]byte, error)
+}
+
+// Spec describes a registered codec.
+type Spec struct {
+	// Name identifies the codec in logs and exports, e.g. "json".
+	Name string
+	// MediaTypes are matched exactly, or by structured syntax suffix when
+	// they start with "+" (e.g. "+json").
+	MediaTypes []string
+	// Binary marks formats that are unreadable as text, so their decoded
+	// form is worth logging next to the raw body.
+	Binary bool
+	Codec  Codec
+}
+
+// Registry resolves content types to codecs. It is safe for concurrent use.
+type Registry struct {
+	mu    sync.RWMutex
+	specs []Spec
+}
+
+// Register adds s. Later registrations take precedence, so built-in codecs
+// can be overridden.
+func (r *Registry) Register(s Spec) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.specs = append([]Spec{s}, r.specs...)
+}
+
+// Lookup returns the codec for contentType. Exact media type matches win
+// over suffix matches.
+func (r *Registry) Lookup(contentType string) (Spec, bool) {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return Spec{}, false
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, s := range r.specs {
+		for _, m := range s.MediaTypes {
+			if m == mt {
+				return s, true
+			}
+		}
+	}
+	for _, s := range r.specs {
+		for _, m := range s.MediaTypes {
+			if strings.HasPrefix(m, "+") && strings.HasSuffix(mt, m) {
+				return s, true
+			}
+		}
+	}
+	return Spec{}, false
+}
+
+// Specs returns the registered codecs, most recent first.
+func (r *Registry) Specs() []Spec {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]Spec(nil), r.specs...)
+}
+
+// Default holds the built-in codecs and everything added through Register.
+var Default = newDefault()
+
+func newDefault() *Registry {
+	r := &Registry{}
+	for _, s := range builtins() {
+		r.Register(s)
+	}
+	return r
+}
+
+// Register adds s to the default registry.
+func Register(s Spec) {
+	Default.Register(s)
+}
+
+// Lookup resolves contentType in the default registry.
+func Lookup(contentType string) (Spec, bool) {
+	return Default.Lookup(contentType)
+}
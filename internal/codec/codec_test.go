@@ -0,0 +1,137 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/standrze/rogue/internal/logger"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func TestLookup(t *testing.T) {
+	tests := map[string]string{
+		"application/json; charset=utf-8":   "json",
+		"application/problem+json":          "json",
+		"application/soap+xml":              "xml",
+		"application/x-www-form-urlencoded": "form",
+		"application/x-protobuf":            "protobuf",
+		"application/msgpack":               "msgpack",
+	}
+	for ct, want := range tests {
+		spec, ok := Lookup(ct)
+		if !ok || spec.Name != want {
+			t.Errorf("Lookup(%q) = %q, %v; want %q", ct, spec.Name, ok, want)
+		}
+	}
+	if _, ok := Lookup("image/png"); ok {
+		t.Error("Lookup(image/png) should not match")
+	}
+}
+
+type upper struct{}
+
+func (upper) Decode(b []byte) (any, error) { return strings.ToUpper(string(b)), nil }
+func (upper) Encode(v any) ([]byte, error) { return []byte(v.(string)), nil }
+
+func TestRegisterOverrides(t *testing.T) {
+	r := newDefault()
+	r.Register(Spec{Name: "shout", MediaTypes: []string{"application/json"}, Codec: upper{}})
+
+	out, ok := r.Pretty("application/json", []byte(`{"a":1}`))
+	if !ok || string(out) != `{"A":1}` {
+		t.Errorf("Pretty() = %q, %v; want the registered codec to win", out, ok)
+	}
+	// Suffix matches still reach the built-in codec.
+	if spec, _ := r.Lookup("application/ld+json"); spec.Name != "json" {
+		t.Errorf("Lookup(+json) = %q, want json", spec.Name)
+	}
+}
+
+func TestRoundTrips(t *testing.T) {
+	pb := []byte{0x08, 0x96, 0x01, 0x12, 0x02, 'h', 'i'}
+	xmlDoc := []byte(`<a:root xmlns:a="urn:x" id="1"><item>one</item></a:root>`)
+	mp, _ := msgpack.Marshal(map[string]any{"n": 1, "s": "x"})
+
+	for _, tt := range []struct {
+		name string
+		body []byte
+	}{
+		{"protobuf", pb},
+		{"xml", xmlDoc},
+		{"form", []byte("a=1&b=2&b=3")},
+		{"msgpack", mp},
+	} {
+		var spec Spec
+		for _, s := range Default.Specs() {
+			if s.Name == tt.name {
+				spec = s
+			}
+		}
+
+		v, err := spec.Codec.Decode(tt.body)
+		if err != nil {
+			t.Fatalf("%s: decode: %v", tt.name, err)
+		}
+		// Simulate an editor round trip through JSON.
+		raw, err := json.Marshal(v)
+		if err != nil {
+			t.Fatalf("%s: marshal: %v", tt.name, err)
+		}
+		var generic any
+		json.Unmarshal(raw, &generic)
+
+		out, err := spec.Codec.Encode(generic)
+		if err != nil {
+			t.Fatalf("%s: encode: %v", tt.name, err)
+		}
+		again, err := spec.Codec.Decode(out)
+		if err != nil {
+			t.Fatalf("%s: decode re-encoded: %v", tt.name, err)
+		}
+		rawAgain, _ := json.Marshal(again)
+		if !bytes.Equal(raw, rawAgain) {
+			t.Errorf("%s: round trip changed value\n got %s\nwant %s", tt.name, rawAgain, raw)
+		}
+	}
+}
+
+func TestBodyDecoderSkipsText(t *testing.T) {
+	d := BodyDecoder{}
+	if got := d.DecodeBody(logger.BodyMeta{ContentType: "application/json"}, []byte(`{}`)); got != nil {
+		t.Errorf("Expected JSON to be left alone, got %+v", got)
+	}
+	got := d.DecodeBody(logger.BodyMeta{ContentType: "application/x-protobuf"}, []byte{0x08, 0x01})
+	if got == nil || got.Format != "protobuf" || got.Error != "" {
+		t.Errorf("Expected protobuf to be decoded, got %+v", got)
+	}
+}
+
+func TestRender(t *testing.T) {
+	for _, tt := range []struct {
+		contentType, body, want string
+	}{
+		{"application/x-www-form-urlencoded", "q=caf%C3%A9+au+lait&id=1&id=2", "q = café au lait\nid = 1\nid = 2\n"},
+		{"", `{"a":[1]}`, "{\n  \"a\": [\n    1\n  ]\n}"},
+		{"text/plain", "<a><b>x</b></a>", "<a>\n  <b>x</b>\n</a>"},
+		{"text/html", "<p>hi</p>", "<p>hi</p>"},
+		{"text/plain", "{not json", "{not json"},
+	} {
+		if got := Render(tt.contentType, tt.body, nil); got != tt.want {
+			t.Errorf("Render(%q, %q) = %q, want %q", tt.contentType, tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestImageURI(t *testing.T) {
+	if got, ok := ImageURI("image/png", "iVBORw0KGgo=", "base64"); !ok || got != "data:image/png;base64,iVBORw0KGgo=" {
+		t.Errorf("png: %q %v", got, ok)
+	}
+	if _, ok := ImageURI("image/svg+xml", "<svg/>", ""); ok {
+		t.Error("SVG should not be inlined")
+	}
+	if _, ok := ImageURI("image/png", "not base64!", "base64"); ok {
+		t.Error("a corrupt body should not be inlined")
+	}
+}
@@ -0,0 +1,48 @@
+package codec
+
+import "github.com/standrze/rogue/internal/logger"
+
+// BodyDecoder adapts a Registry to logger.BodyDecoder. Only binary formats
+// are decoded; text bodies are already readable in the log.
+type BodyDecoder struct {
+	Registry *Registry
+}
+
+func (d BodyDecoder) DecodeBody(meta logger.BodyMeta, body []byte) *logger.Decoded {
+	r := d.Registry
+	if r == nil {
+		r = Default
+	}
+
+	spec, ok := r.Lookup(meta.ContentType)
+	if !ok || !spec.Binary {
+		return nil
+	}
+
+	decoded := &logger.Decoded{Format: spec.Name}
+	v, err := spec.Codec.Decode(body)
+	if err != nil {
+		decoded.Error = err.Error()
+		return decoded
+	}
+	decoded.Value = v
+	return decoded
+}
+
+// Pretty re-renders body through the codec for contentType, e.g. indenting
+// JSON. It reports false when no codec applies or the body does not decode.
+func (r *Registry) Pretty(contentType string, body []byte) ([]byte, bool) {
+	spec, ok := r.Lookup(contentType)
+	if !ok || spec.Binary {
+		return nil, false
+	}
+	v, err := spec.Codec.Decode(body)
+	if err != nil {
+		return nil, false
+	}
+	out, err := spec.Codec.Encode(v)
+	if err != nil {
+		return nil, false
+	}
+	return out, true
+}
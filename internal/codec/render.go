@@ -0,0 +1,112 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/url"
+	"strings"
+
+	"github.com/standrze/rogue/internal/logger"
+)
+
+// Render makes a logged body readable for people: binary formats are shown
+// in their decoded form, forms as one decoded field per line and text
+// formats are pretty-printed by their codec. Bodies without a useful
+// Content-Type are sniffed for JSON and XML.
+func Render(contentType, body string, decoded *logger.Decoded) string {
+	if decoded != nil && decoded.Value != nil {
+		if out, err := json.MarshalIndent(decoded.Value, "", "  "); err == nil {
+			return fmt.Sprintf("[decoded %s]\n%s", decoded.Format, out)
+		}
+	}
+	if spec, ok := Default.Lookup(contentType); ok && spec.Name == "form" {
+		if fields, err := Fields(body); err == nil && len(fields) > 0 {
+			var b strings.Builder
+			for _, f := range fields {
+				fmt.Fprintf(&b, "%s = %s\n", f.Name, f.Value)
+			}
+			return b.String()
+		}
+	}
+	if out, ok := Default.Pretty(Sniff(contentType, []byte(body)), []byte(body)); ok {
+		return string(out)
+	}
+	return body
+}
+
+// Sniff returns contentType, or for a missing or plain-text one the JSON
+// or XML media type when body looks like either.
+func Sniff(contentType string, body []byte) string {
+	mt, _, _ := mime.ParseMediaType(contentType)
+	if mt != "" && mt != "text/plain" && mt != "application/octet-stream" {
+		return contentType
+	}
+	switch trimmed := bytes.TrimSpace(body); {
+	case len(trimmed) == 0:
+	case (trimmed[0] == '{' || trimmed[0] == '[') && json.Valid(trimmed):
+		return "application/json"
+	case trimmed[0] == '<' && !bytes.HasPrefix(bytes.ToLower(trimmed), []byte("<!doctype html")):
+		return "application/xml"
+	}
+	return contentType
+}
+
+// Field is one decoded name and value of a form or query string.
+type Field struct {
+	Name  string
+	Value string
+}
+
+// Fields decodes a URL-encoded form or query string, keeping the order the
+// fields were sent in.
+func Fields(s string) ([]Field, error) {
+	var fields []Field
+	for _, part := range strings.Split(s, "&") {
+		if part == "" {
+			continue
+		}
+		name, value, _ := strings.Cut(part, "=")
+		name, err := url.QueryUnescape(name)
+		if err != nil {
+			return nil, err
+		}
+		value, err = url.QueryUnescape(value)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, Field{Name: name, Value: value})
+	}
+	return fields, nil
+}
+
+// imageTypes are the image formats browsers render from a data URI without
+// running anything, which rules out SVG.
+var imageTypes = map[string]bool{
+	"image/png":                true,
+	"image/jpeg":               true,
+	"image/gif":                true,
+	"image/webp":               true,
+	"image/avif":               true,
+	"image/bmp":                true,
+	"image/x-icon":             true,
+	"image/vnd.microsoft.icon": true,
+}
+
+// ImageURI returns a data URI for a logged image body, given as the body
+// and its logged encoding, so reports can show it inline. It reports false
+// for other content types and for bodies that do not decode.
+func ImageURI(contentType, body, encoding string) (string, bool) {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil || !imageTypes[mt] || body == "" {
+		return "", false
+	}
+	if encoding != "base64" {
+		body = base64.StdEncoding.EncodeToString([]byte(body))
+	} else if _, err := base64.StdEncoding.DecodeString(body); err != nil {
+		return "", false
+	}
+	return "data:" + mt + ";base64," + body, true
+}
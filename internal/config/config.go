@@ -2,7 +2,9 @@ package config
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
+	"slices"
 )
 
 type LoggingConfig struct {
@@ -12,6 +14,74 @@ type LoggingConfig struct {
 	LogHeaders   bool   `json:"log_headers" mapstructure:"log_headers"`
 	LogBody      bool   `json:"log_body" mapstructure:"log_body"`
 	MaxBodySize  int    `json:"max_body_size" mapstructure:"max_body_size"`
+	LogSSEEvents bool   `json:"log_sse_events" mapstructure:"log_sse_events"`
+	// LogRegistryBlobs keeps container image layer bodies, which are
+	// otherwise only measured.
+	LogRegistryBlobs bool `json:"log_registry_blobs" mapstructure:"log_registry_blobs"`
+	LogServerCerts   bool `json:"log_server_certs" mapstructure:"log_server_certs"`
+	// LogTunnels records CONNECT tunnels relayed without interception,
+	// with hex dumps of their first TunnelSampleSize bytes each way.
+	LogTunnels       bool `json:"log_tunnels" mapstructure:"log_tunnels"`
+	TunnelSampleSize int  `json:"tunnel_sample_size" mapstructure:"tunnel_sample_size"`
+	// RedactS3Signatures masks SigV4 signatures and session tokens.
+	RedactS3Signatures bool `json:"redact_s3_signatures" mapstructure:"redact_s3_signatures"`
+	// ResumeLastSession appends to the newest session after a restart.
+	ResumeLastSession bool `json:"resume_last_session" mapstructure:"resume_last_session"`
+	// RotateSize (bytes) and RotateInterval (seconds) start a new session
+	// file once exceeded; zero disables them. RotateCompress gzips rotated
+	// files.
+	RotateSize     int64 `json:"rotate_size" mapstructure:"rotate_size"`
+	RotateInterval int   `json:"rotate_interval" mapstructure:"rotate_interval"`
+	RotateCompress bool  `json:"rotate_compress" mapstructure:"rotate_compress"`
+	// Async writes entries from a background goroutine fed by a queue of
+	// QueueSize entries. QueuePolicy is "block", "drop" or "degrade" for
+	// when the queue fills up, and the file is synced every SyncInterval
+	// seconds.
+	Async        bool   `json:"async" mapstructure:"async"`
+	QueueSize    int    `json:"queue_size" mapstructure:"queue_size"`
+	QueuePolicy  string `json:"queue_policy" mapstructure:"queue_policy"`
+	SyncInterval int    `json:"sync_interval" mapstructure:"sync_interval"`
+	// SessionFile writes entries to session files in SessionDir; turn it
+	// off to only ship them to Sinks.
+	SessionFile bool          `json:"session_file" mapstructure:"session_file"`
+	Sinks       []SinkConfig  `json:"sinks" mapstructure:"sinks"`
+	Degrade     DegradeConfig `json:"degrade" mapstructure:"degrade"`
+	// Stdout writes every entry as a line of JSON to standard output, like
+	// a sink of type "stdout", and moves rogue's own messages to standard
+	// error.
+	Stdout bool `json:"stdout" mapstructure:"stdout"`
+}
+
+// DegradeConfig is used by the "degrade" queue policy. Bodies, headers and
+// all but one flow in SampleEvery are left out once the queue is BodiesAt,
+// HeadersAt and SampleAt full (fractions of QueueSize; zero skips a level).
+type DegradeConfig struct {
+	BodiesAt    float64 `json:"bodies_at" mapstructure:"bodies_at"`
+	HeadersAt   float64 `json:"headers_at" mapstructure:"headers_at"`
+	SampleAt    float64 `json:"sample_at" mapstructure:"sample_at"`
+	SampleEvery int     `json:"sample_every" mapstructure:"sample_every"`
+}
+
+// SinkConfig ships session entries to a remote collector. Type is "http"
+// (URL receives JSON arrays of entries), "syslog" (RFC 5424 to Address over
+// Network), "kafka" (records produced to Topic through the Kafka REST
+// proxy at URL) or "stdout" (a line of JSON per entry). Entries are sent in batches of BatchSize or every
+// FlushInterval milliseconds; up to QueueSize wait while a batch is sent.
+// Timeout is in seconds.
+type SinkConfig struct {
+	Type          string            `json:"type" mapstructure:"type"`
+	Name          string            `json:"name" mapstructure:"name"`
+	URL           string            `json:"url" mapstructure:"url"`
+	Headers       map[string]string `json:"headers" mapstructure:"headers"`
+	Network       string            `json:"network" mapstructure:"network"`
+	Address       string            `json:"address" mapstructure:"address"`
+	Facility      string            `json:"facility" mapstructure:"facility"`
+	Tag           string            `json:"tag" mapstructure:"tag"`
+	Topic         string            `json:"topic" mapstructure:"topic"`
+	BatchSize     int               `json:"batch_size" mapstructure:"batch_size"`
+	FlushInterval int               `json:"flush_interval" mapstructure:"flush_interval"`
+	QueueSize     int               `json:"queue_size" mapstructure:"queue_size"`
+	Timeout       int               `json:"timeout" mapstructure:"timeout"`
 }
 
 type CertificateConfig struct {
@@ -21,42 +91,549 @@ type CertificateConfig struct {
 	ValidDays    int    `json:"valid_days" mapstructure:"valid_days"`
 	CertPath     string `json:"cert_path" mapstructure:"cert_path"`
 	KeyPath      string `json:"key_path" mapstructure:"key_path"`
+	// KeyPerm is the octal file mode generated keys are written with.
+	KeyPerm string `json:"key_perm" mapstructure:"key_perm"`
+	// KeyPassphrase unlocks an encrypted CA key. It falls back to the
+	// ROGUE_KEY_PASSPHRASE environment variable and then a prompt.
+	KeyPassphrase string `json:"key_passphrase" mapstructure:"key_passphrase"`
+	// EncryptKey encrypts generated CA keys with the passphrase.
+	EncryptKey bool `json:"encrypt_key" mapstructure:"encrypt_key"`
+	// CacheSize bounds the number of leaf certificates kept in memory.
+	CacheSize int `json:"cache_size" mapstructure:"cache_size"`
+	// KeyPoolSize is the number of leaf keys generated ahead of need. Each
+	// leaf gets its own key; zero shares one key across all leaves.
+	KeyPoolSize int `json:"key_pool_size" mapstructure:"key_pool_size"`
+	// WarmHosts are minted at startup so their first handshake is fast.
+	WarmHosts []string `json:"warm_hosts" mapstructure:"warm_hosts"`
+	// Stateless generates a CA in memory for each run instead of using
+	// CertPath and KeyPath. Either way, a CA passed as PEM in ROGUE_CA_CERT
+	// and ROGUE_CA_KEY, or in the files named by ROGUE_CA_CERT_FILE and
+	// ROGUE_CA_KEY_FILE, takes precedence.
+	Stateless bool `json:"stateless" mapstructure:"stateless"`
 }
 
-type ProxyConfig struct {
-	Port    int    `json:"port" mapstructure:"port"`
+// AdminConfig controls the management server exposing metrics.
+type AdminConfig struct {
+	Enabled bool   `json:"enabled" mapstructure:"enabled"`
 	Host    string `json:"host" mapstructure:"host"`
-	Timeout int    `json:"timeout" mapstructure:"timeout"`
+	Port    int    `json:"port" mapstructure:"port"`
+	// Token, when set, must be sent as a bearer token to every endpoint
+	// except /healthz and share links, or entered at /login by browsers.
+	Token string `json:"token" mapstructure:"token"`
+	// ShareListen, if set, serves share links on this address instead of
+	// the admin port, so they can be reached without exposing the rest.
+	ShareListen string `json:"share_listen" mapstructure:"share_listen"`
+	// ShareURL is the base of the links handed out, such as
+	// https://share.example.com behind a reverse proxy. By default it is
+	// derived from ShareListen.
+	ShareURL string `json:"share_url" mapstructure:"share_url"`
+	// LivePreview is how many bytes of each body the live stream sends.
+	LivePreview int `json:"live_preview" mapstructure:"live_preview"`
+	// GRPCPort, when set, serves the gRPC admin API on Host at this port.
+	GRPCPort int `json:"grpc_port" mapstructure:"grpc_port"`
+	// Debug serves runtime profiles at /debug/pprof/ and expvar variables
+	// at /debug/vars.
+	Debug bool `json:"debug" mapstructure:"debug"`
+}
+
+// PACConfig controls the proxy auto-config file served on the admin port.
+type PACConfig struct {
+	Enabled bool `json:"enabled" mapstructure:"enabled"`
+	// Proxy is the address clients are told to use. When empty, the host
+	// name the PAC file is fetched from is combined with proxy.port.
+	Proxy string `json:"proxy" mapstructure:"proxy"`
+	// IgnoreHosts are sent directly to the origin, bypassing the proxy,
+	// along with tls.passthrough_hosts.
+	IgnoreHosts []string `json:"ignore_hosts" mapstructure:"ignore_hosts"`
+}
+
+type ProxyConfig struct {
+	Port int    `json:"port" mapstructure:"port"`
+	Host string `json:"host" mapstructure:"host"`
+	// Listen replaces Host and Port with an address such as
+	// "unix:///var/run/rogue.sock" or "tcp://127.0.0.1:8080".
+	Listen string `json:"listen" mapstructure:"listen"`
+	// Mode, CertPath, KeyPath and ClientCA are like those of listeners, for
+	// the main listener.
+	Mode     string `json:"mode" mapstructure:"mode"`
+	CertPath string `json:"cert_path" mapstructure:"cert_path"`
+	KeyPath  string `json:"key_path" mapstructure:"key_path"`
+	ClientCA string `json:"client_ca" mapstructure:"client_ca"`
+	// RetryBind keeps retrying, for up to this many seconds, when the
+	// address is in use.
+	RetryBind int `json:"retry_bind" mapstructure:"retry_bind"`
+	// AddrFile, if set, receives the address the proxy listens on once it
+	// is bound, for scripts starting it on port 0.
+	AddrFile string `json:"addr_file" mapstructure:"addr_file"`
+	// Timeouts are in seconds. Zero disables the limit, except for Timeout,
+	// the idle limit on client connections, where it means five minutes.
+	Timeout               int  `json:"timeout" mapstructure:"timeout"`
+	DialTimeout           int  `json:"dial_timeout" mapstructure:"dial_timeout"`
+	TLSHandshakeTimeout   int  `json:"tls_handshake_timeout" mapstructure:"tls_handshake_timeout"`
+	ResponseHeaderTimeout int  `json:"response_header_timeout" mapstructure:"response_header_timeout"`
+	IdleTimeout           int  `json:"idle_timeout" mapstructure:"idle_timeout"`
+	RequestIDHeader       bool `json:"request_id_header" mapstructure:"request_id_header"`
+	// ShutdownTimeout is how many seconds a signalled proxy waits for the
+	// admin server, tracing and sinks to finish before exiting anyway.
+	ShutdownTimeout int `json:"shutdown_timeout" mapstructure:"shutdown_timeout"`
+	// ProxyProtocol expects a HAProxy PROXY protocol header on connections
+	// to the main listener from ProxyProtocolTrusted addresses (IPs or CIDR
+	// prefixes; empty trusts every peer).
+	ProxyProtocol        bool     `json:"proxy_protocol" mapstructure:"proxy_protocol"`
+	ProxyProtocolTrusted []string `json:"proxy_protocol_trusted" mapstructure:"proxy_protocol_trusted"`
+	// StripAltSvc removes HTTP/3 alternatives from Alt-Svc response
+	// headers so clients do not move to QUIC, which is not intercepted.
+	StripAltSvc bool `json:"strip_alt_svc" mapstructure:"strip_alt_svc"`
+	// HSTS is "strip" to remove Strict-Transport-Security response headers
+	// or "expire" to set them to max-age=0, for testing downgrades only.
+	HSTS string `json:"hsts" mapstructure:"hsts"`
+	// Bind chooses the local address of upstream connections; the first
+	// entry matching the origin applies.
+	Bind []BindConfig `json:"bind" mapstructure:"bind"`
+	// Dial chooses how origins are resolved and connected to.
+	Dial DialConfig `json:"dial" mapstructure:"dial"`
+	// Limits keeps one client from overwhelming a shared proxy.
+	Limits LimitsConfig `json:"limits" mapstructure:"limits"`
+	// UpstreamProxy chains origin connections through another proxy.
+	UpstreamProxy UpstreamProxyConfig `json:"upstream_proxy" mapstructure:"upstream_proxy"`
+}
+
+// UpstreamProxyConfig names a proxy to reach origins through, such as
+// "http://proxy.corp:3128". Auth is empty, "basic", "ntlm" or "negotiate"
+// for Kerberos; Username may be "DOMAIN\user" for NTLM or "user@REALM" for
+// Kerberos and Password falls back to the ROGUE_UPSTREAM_PROXY_PASSWORD
+// environment variable. NoProxy lists host patterns connected to directly.
+type UpstreamProxyConfig struct {
+	URL      string         `json:"url" mapstructure:"url"`
+	Auth     string         `json:"auth" mapstructure:"auth"`
+	Username string         `json:"username" mapstructure:"username"`
+	Password string         `json:"password" mapstructure:"password"`
+	NoProxy  []string       `json:"no_proxy" mapstructure:"no_proxy"`
+	Kerberos KerberosConfig `json:"kerberos" mapstructure:"kerberos"`
+}
+
+// KerberosConfig locates the credentials of "negotiate" authentication:
+// the krb5.conf (KRB5_CONFIG or /etc/krb5.conf by default), a keytab for
+// the username, or else the credential cache kinit writes (KRB5CCNAME or
+// /tmp/krb5cc_<uid> by default) when there is no password either. SPN is
+// the proxy's service principal, HTTP/<proxy host> by default.
+type KerberosConfig struct {
+	Config string `json:"config" mapstructure:"config"`
+	Keytab string `json:"keytab" mapstructure:"keytab"`
+	CCache string `json:"ccache" mapstructure:"ccache"`
+	SPN    string `json:"spn" mapstructure:"spn"`
+}
+
+// LimitsConfig bounds client connections (all clients together, which
+// makes further clients wait, and per client address, which closes the
+// excess), the requests per second of each client address with bursts of
+// RequestBurst, and the bytes per second of all client traffic. RatePolicy
+// is "reject" to answer requests over the rate with 429 or "delay" to hold
+// them back. BodyMemoryBytes bounds the memory held by bodies buffered in
+// flight; once it is spent, bodies being captured for the log spill to
+// temporary files in BodySpillDir, or without one are captured no
+// further. Zero disables a limit.
+type LimitsConfig struct {
+	MaxConnections          int     `json:"max_connections" mapstructure:"max_connections"`
+	MaxConnectionsPerClient int     `json:"max_connections_per_client" mapstructure:"max_connections_per_client"`
+	RequestsPerSecond       float64 `json:"requests_per_second" mapstructure:"requests_per_second"`
+	RequestBurst            int     `json:"request_burst" mapstructure:"request_burst"`
+	RatePolicy              string  `json:"rate_policy" mapstructure:"rate_policy"`
+	BandwidthBytesPerSecond int64   `json:"bandwidth_bytes_per_second" mapstructure:"bandwidth_bytes_per_second"`
+	BodyMemoryBytes         int64   `json:"body_memory_bytes" mapstructure:"body_memory_bytes"`
+	BodySpillDir            string  `json:"body_spill_dir" mapstructure:"body_spill_dir"`
+}
+
+// DialConfig sets the upstream dial strategy. Family is "ipv4" or "ipv6"
+// to use only that family, "prefer-ipv4" or "prefer-ipv6" to try it first,
+// or empty for the resolver's order. With HappyEyeballs the other family is
+// raced after FallbackDelayMS (300 when zero); without it addresses are
+// tried one at a time.
+type DialConfig struct {
+	Family          string           `json:"family" mapstructure:"family"`
+	HappyEyeballs   bool             `json:"happy_eyeballs" mapstructure:"happy_eyeballs"`
+	FallbackDelayMS int              `json:"fallback_delay_ms" mapstructure:"fallback_delay_ms"`
+	Resolvers       []ResolverConfig `json:"resolvers" mapstructure:"resolvers"`
+}
+
+// ResolverConfig sends the DNS queries of hosts matching Hosts (globs) to
+// Server, an IP address with an optional port.
+type ResolverConfig struct {
+	Hosts  []string `json:"hosts" mapstructure:"hosts"`
+	Server string   `json:"server" mapstructure:"server"`
+}
+
+// BindConfig connects to origins matching Hosts (globs; empty matches all)
+// from Address or from the addresses of Interface.
+type BindConfig struct {
+	Hosts     []string `json:"hosts" mapstructure:"hosts"`
+	Address   string   `json:"address" mapstructure:"address"`
+	Interface string   `json:"interface" mapstructure:"interface"`
+}
+
+// ListenerConfig is an additional address accepting clients besides
+// proxy.host:proxy.port. Mode is "http", "https" (explicit proxy over TLS)
+// or "transparent" (redirected traffic).
+type ListenerConfig struct {
+	Mode string `json:"mode" mapstructure:"mode"`
+	Host string `json:"host" mapstructure:"host"`
+	Port int    `json:"port" mapstructure:"port"`
+	// CertPath and KeyPath hold the certificate of an https listener. When
+	// empty, one is minted from the CA.
+	CertPath string `json:"cert_path" mapstructure:"cert_path"`
+	KeyPath  string `json:"key_path" mapstructure:"key_path"`
+	// ClientCA is a PEM file of CA certificates. An https listener then
+	// only accepts clients presenting a certificate one of them signed.
+	ClientCA string `json:"client_ca" mapstructure:"client_ca"`
+	// ProxyProtocol is like proxy.proxy_protocol for this listener.
+	ProxyProtocol bool `json:"proxy_protocol" mapstructure:"proxy_protocol"`
+}
+
+// TLSConfig restricts versions ("1.0" to "1.3") and cipher suites (IANA
+// names) towards intercepted clients and origin servers.
+type TLSConfig struct {
+	ClientMinVersion     string   `json:"client_min_version" mapstructure:"client_min_version"`
+	ClientMaxVersion     string   `json:"client_max_version" mapstructure:"client_max_version"`
+	ClientCipherSuites   []string `json:"client_cipher_suites" mapstructure:"client_cipher_suites"`
+	UpstreamMinVersion   string   `json:"upstream_min_version" mapstructure:"upstream_min_version"`
+	UpstreamMaxVersion   string   `json:"upstream_max_version" mapstructure:"upstream_max_version"`
+	UpstreamCipherSuites []string `json:"upstream_cipher_suites" mapstructure:"upstream_cipher_suites"`
+	SkipVerifyHosts      []string `json:"skip_verify_hosts" mapstructure:"skip_verify_hosts"`
+	// PassthroughHosts are tunneled to the origin without interception.
+	PassthroughHosts []string `json:"passthrough_hosts" mapstructure:"passthrough_hosts"`
+	// UpstreamFingerprint mimics a browser ClientHello ("chrome", "firefox",
+	// ...) towards origin servers.
+	UpstreamFingerprint string `json:"upstream_fingerprint" mapstructure:"upstream_fingerprint"`
+	// UpstreamClientCerts are presented to origins requiring mutual TLS.
+	UpstreamClientCerts []ClientCertConfig `json:"upstream_client_certs" mapstructure:"upstream_client_certs"`
+	// KeyLogFile receives the session keys of client-facing and upstream
+	// handshakes in NSS key log format. When empty, SSLKEYLOGFILE is used.
+	KeyLogFile string `json:"key_log_file" mapstructure:"key_log_file"`
+}
+
+// ClientCertConfig is a client certificate and key, in PEM files, for the
+// origins matching Hosts (globs; empty matches every origin). CertPath may
+// hold the intermediates after the certificate.
+type ClientCertConfig struct {
+	Hosts    []string `json:"hosts" mapstructure:"hosts"`
+	CertPath string   `json:"cert_path" mapstructure:"cert_path"`
+	KeyPath  string   `json:"key_path" mapstructure:"key_path"`
+}
+
+// RulesConfig lists rule files applied in order; the first match wins.
+type RulesConfig struct {
+	Files []string `json:"files" mapstructure:"files"`
+}
+
+// HeadersConfig defines named header profiles. Those listed in Global
+// apply to every exchange; rules apply others by name.
+type HeadersConfig struct {
+	Profiles map[string]HeaderProfileConfig `json:"profiles" mapstructure:"profiles"`
+	Global   []string                       `json:"global" mapstructure:"global"`
+}
+
+// HeaderProfileConfig edits request and response headers.
+type HeaderProfileConfig struct {
+	Request  HeaderOpsConfig `json:"request" mapstructure:"request"`
+	Response HeaderOpsConfig `json:"response" mapstructure:"response"`
+}
+
+// HeaderOpsConfig removes headers matching Remove globs, then sets and
+// adds values.
+type HeaderOpsConfig struct {
+	Add    map[string]string `json:"add" mapstructure:"add"`
+	Set    map[string]string `json:"set" mapstructure:"set"`
+	Remove []string          `json:"remove" mapstructure:"remove"`
+}
+
+// DNSConfig changes how origins are resolved. Overrides answer for hosts
+// (globs) with fixed addresses, like /etc/hosts; Server resolves the rest:
+// an IP address for plain DNS, "tcp://ip", "tls://host" for DNS over TLS
+// or an https:// URL for DNS over HTTPS.
+type DNSConfig struct {
+	Overrides []DNSOverrideConfig `json:"overrides" mapstructure:"overrides"`
+	Server    string              `json:"server" mapstructure:"server"`
+}
+
+// DNSOverrideConfig gives the addresses of the hosts matching Host.
+type DNSOverrideConfig struct {
+	Host      string   `json:"host" mapstructure:"host"`
+	Addresses []string `json:"addresses" mapstructure:"addresses"`
+}
+
+// CacheConfig answers repeated GET requests from stored responses. Dir keeps
+// them on disk across restarts; empty keeps them in memory only. With
+// IgnoreCacheControl every cacheable response is stored and served
+// regardless of its headers, and Offline serves only from the cache.
+// Sessions are recorded sessions whose responses are loaded at start.
+type CacheConfig struct {
+	Enabled            bool     `json:"enabled" mapstructure:"enabled"`
+	Dir                string   `json:"dir" mapstructure:"dir"`
+	MaxEntries         int      `json:"max_entries" mapstructure:"max_entries"`
+	MaxBodySize        int64    `json:"max_body_size" mapstructure:"max_body_size"`
+	IgnoreCacheControl bool     `json:"ignore_cache_control" mapstructure:"ignore_cache_control"`
+	Offline            bool     `json:"offline" mapstructure:"offline"`
+	Sessions           []string `json:"sessions" mapstructure:"sessions"`
+}
+
+// ValidationConfig checks traffic against an OpenAPI document (file or URL).
+type ValidationConfig struct {
+	Spec      string `json:"spec" mapstructure:"spec"`
+	Responses bool   `json:"responses" mapstructure:"responses"`
+	// Reject answers invalid requests with 400 and replaces invalid
+	// responses with 502 instead of only annotating the flow.
+	Reject bool `json:"reject" mapstructure:"reject"`
+}
+
+// PluginsConfig runs the executables in Dir as request and response
+// modifiers. Each call must be answered within TimeoutMS; bodies over
+// MaxBodySize bytes are not sent to plugins.
+type PluginsConfig struct {
+	Enabled     bool   `json:"enabled" mapstructure:"enabled"`
+	Dir         string `json:"dir" mapstructure:"dir"`
+	TimeoutMS   int    `json:"timeout_ms" mapstructure:"timeout_ms"`
+	MaxBodySize int64  `json:"max_body_size" mapstructure:"max_body_size"`
+}
+
+// PagesConfig controls the HTML pages rogue serves in place of bare error
+// responses.
+type PagesConfig struct {
+	Enabled bool `json:"enabled" mapstructure:"enabled"`
+	// Dir holds templates replacing built-in pages of the same file name.
+	Dir string `json:"dir" mapstructure:"dir"`
+	// TrustHost serves the CA certificate and trust instructions.
+	TrustHost string `json:"trust_host" mapstructure:"trust_host"`
+}
+
+// ExportConfig names report templates for sessions export.
+type ExportConfig struct {
+	// Templates maps template names to Go template files. Files ending in
+	// .html are rendered with HTML escaping.
+	Templates map[string]string `json:"templates" mapstructure:"templates"`
+}
+
+// DisplayConfig controls how times and numbers are shown in human-readable
+// output. Timezone is an IANA name, "UTC" or "Local"; empty keeps recorded
+// offsets. Locale is a BCP 47 tag; empty uses the environment.
+type DisplayConfig struct {
+	Timezone string `json:"timezone" mapstructure:"timezone"`
+	Locale   string `json:"locale" mapstructure:"locale"`
+	// NoColor turns off colored output, as does the NO_COLOR variable.
+	NoColor bool `json:"no_color" mapstructure:"no_color"`
+}
+
+// WebhookConfig posts a manifest of every completed session file, on rotation
+// and on shutdown, to URL. Timeout is in seconds.
+type WebhookConfig struct {
+	URL     string            `json:"url" mapstructure:"url"`
+	Headers map[string]string `json:"headers" mapstructure:"headers"`
+	// IncludeFile uploads the session file with the manifest.
+	IncludeFile bool `json:"include_file" mapstructure:"include_file"`
+	// LocationPrefix is prepended to the file name to give the manifest's
+	// location, such as the bucket URL the session directory is synced to.
+	LocationPrefix string `json:"location_prefix" mapstructure:"location_prefix"`
+	Timeout        int    `json:"timeout" mapstructure:"timeout"`
+	Retries        int    `json:"retries" mapstructure:"retries"`
+}
+
+// FindingsConfig controls the passive security checks run on traffic.
+// Disable names checks to skip, such as security-headers.
+type FindingsConfig struct {
+	Enabled bool     `json:"enabled" mapstructure:"enabled"`
+	Disable []string `json:"disable" mapstructure:"disable"`
+}
+
+// ScopeConfig limits the traffic rogue records, intercepts, scans and
+// exports to URLs matching Include (everything when empty) and not Exclude.
+// Patterns are [SCHEME://]HOST[/PATH] with globs, such as *.example.com or
+// api.example.com/v2/*.
+type ScopeConfig struct {
+	Include []string `json:"include" mapstructure:"include"`
+	Exclude []string `json:"exclude" mapstructure:"exclude"`
+}
+
+// SensitiveConfig controls the detection of sensitive data in traffic.
+// Disable names built-in detectors to skip, such as email. Detectors maps
+// the names of custom detectors to regular expressions. Warn also prints a
+// line to stderr for each flow carrying sensitive data.
+type SensitiveConfig struct {
+	Enabled   bool              `json:"enabled" mapstructure:"enabled"`
+	Disable   []string          `json:"disable" mapstructure:"disable"`
+	Detectors map[string]string `json:"detectors" mapstructure:"detectors"`
+	Warn      bool              `json:"warn" mapstructure:"warn"`
+}
+
+// ProtobufConfig gives protobuf bodies a schema. Descriptors are descriptor
+// set files (protoc --include_imports --descriptor_set_out); Messages names
+// the message types exchanged with matching URLs.
+type ProtobufConfig struct {
+	Descriptors []string                `json:"descriptors" mapstructure:"descriptors"`
+	Messages    []ProtobufMessageConfig `json:"messages" mapstructure:"messages"`
+}
+
+// ProtobufMessageConfig names the request and response message types, such
+// as acme.v1.GetUserRequest, for URLs matching the Host and Path globs.
+type ProtobufMessageConfig struct {
+	Host     string `json:"host" mapstructure:"host"`
+	Path     string `json:"path" mapstructure:"path"`
+	Request  string `json:"request" mapstructure:"request"`
+	Response string `json:"response" mapstructure:"response"`
+}
+
+// TracingConfig exports an OpenTelemetry span per exchange to the OTLP/HTTP
+// collector at Endpoint, such as http://localhost:4318. Propagate sets the
+// traceparent header sent upstream to the proxy's span. SampleRatio is the
+// fraction of new traces recorded.
+type TracingConfig struct {
+	Endpoint    string            `json:"endpoint" mapstructure:"endpoint"`
+	Headers     map[string]string `json:"headers" mapstructure:"headers"`
+	ServiceName string            `json:"service_name" mapstructure:"service_name"`
+	Propagate   bool              `json:"propagate" mapstructure:"propagate"`
+	SampleRatio float64           `json:"sample_ratio" mapstructure:"sample_ratio"`
+}
+
+// SidecarConfig runs rogue as an egress sidecar in a Kubernetes pod. The
+// main listener is then transparent and the pod's labels, read from the
+// downward API volume at PodInfoDir, are attached to every logged exchange.
+// CASecret names a kubernetes.io/tls secret in the pod's namespace to sign
+// with. Ports, ProxyUID and ExcludeCIDRs are used by rogue sidecar init to
+// redirect the pod's outbound connections to the proxy.
+type SidecarConfig struct {
+	Enabled      bool     `json:"enabled" mapstructure:"enabled"`
+	PodInfoDir   string   `json:"pod_info_dir" mapstructure:"pod_info_dir"`
+	CASecret     string   `json:"ca_secret" mapstructure:"ca_secret"`
+	Ports        []int    `json:"ports" mapstructure:"ports"`
+	ProxyUID     int      `json:"proxy_uid" mapstructure:"proxy_uid"`
+	ExcludeCIDRs []string `json:"exclude_cidrs" mapstructure:"exclude_cidrs"`
+}
+
+// EnvironmentsConfig locates the environments whose variables rules and
+// replayed requests refer to as {{name}}. Active selects one over the one
+// chosen with rogue env use.
+type EnvironmentsConfig struct {
+	File   string `json:"file" mapstructure:"file"`
+	Active string `json:"active" mapstructure:"active"`
 }
 
 type Config struct {
 	Proxy       ProxyConfig       `json:"proxy" mapstructure:"proxy"`
+	Listeners   []ListenerConfig  `json:"listeners" mapstructure:"listeners"`
 	Certificate CertificateConfig `json:"certificate" mapstructure:"certificate"`
 	Logging     LoggingConfig     `json:"logging" mapstructure:"logging"`
+	TLS         TLSConfig         `json:"tls" mapstructure:"tls"`
+	Admin       AdminConfig       `json:"admin" mapstructure:"admin"`
+	PAC         PACConfig         `json:"pac" mapstructure:"pac"`
+	Rules       RulesConfig       `json:"rules" mapstructure:"rules"`
+	Validation  ValidationConfig  `json:"validation" mapstructure:"validation"`
+	Pages       PagesConfig       `json:"pages" mapstructure:"pages"`
+	Plugins     PluginsConfig     `json:"plugins" mapstructure:"plugins"`
+	Export      ExportConfig      `json:"export" mapstructure:"export"`
+	Display     DisplayConfig     `json:"display" mapstructure:"display"`
+	Webhook     WebhookConfig     `json:"webhook" mapstructure:"webhook"`
+	Tracing     TracingConfig     `json:"tracing" mapstructure:"tracing"`
+	Headers     HeadersConfig     `json:"headers" mapstructure:"headers"`
+	DNS         DNSConfig         `json:"dns" mapstructure:"dns"`
+	Cache       CacheConfig       `json:"cache" mapstructure:"cache"`
+	Findings    FindingsConfig    `json:"findings" mapstructure:"findings"`
+	Sensitive   SensitiveConfig   `json:"sensitive" mapstructure:"sensitive"`
+	Scope       ScopeConfig       `json:"scope" mapstructure:"scope"`
+	Protobuf    ProtobufConfig    `json:"protobuf" mapstructure:"protobuf"`
+	Sidecar     SidecarConfig     `json:"sidecar" mapstructure:"sidecar"`
+	// Filters are saved filter expressions, referred to as @name.
+	Filters map[string]string `json:"filters" mapstructure:"filters"`
+	// Environments hold variables for rules and replayed requests.
+	Environments EnvironmentsConfig `json:"environments" mapstructure:"environments"`
 }
 
 func DefaultConfig() *Config {
+	certPath, keyPath := defaultCAPaths()
 	return &Config{
 		Proxy: ProxyConfig{
-			Port:    8080,
-			Host:    "0.0.0.0",
-			Timeout: 30,
+			Port:                  8080,
+			Host:                  "0.0.0.0",
+			Mode:                  "http",
+			Timeout:               30,
+			DialTimeout:           10,
+			TLSHandshakeTimeout:   10,
+			ResponseHeaderTimeout: 60,
+			IdleTimeout:           90,
+			ShutdownTimeout:       10,
+			Dial:                  DialConfig{HappyEyeballs: true},
+			Limits:                LimitsConfig{RatePolicy: "reject"},
 		},
 		Certificate: CertificateConfig{
 			AutoGenerate: true,
 			Organization: "Rogue Proxy",
 			CommonName:   "Rogue CA",
 			ValidDays:    365,
-			CertPath:     "certs/ca.crt",
-			KeyPath:      "certs/ca.key",
+			CertPath:     certPath,
+			KeyPath:      keyPath,
+			KeyPerm:      "0600",
+			CacheSize:    1024,
+			KeyPoolSize:  16,
 		},
 		Logging: LoggingConfig{
-			SessionDir:   "logs",
-			LogRequests:  true,
-			LogResponses: true,
-			LogHeaders:   true,
-			LogBody:      true,
-			MaxBodySize:  1024 * 1024, // 1MB
+			SessionDir:       "logs",
+			LogRequests:      true,
+			LogResponses:     true,
+			LogHeaders:       true,
+			LogBody:          true,
+			MaxBodySize:      1024 * 1024, // 1MB
+			LogSSEEvents:     true,
+			LogServerCerts:   true,
+			TunnelSampleSize: 256,
+			Async:            true,
+			QueueSize:        4096,
+			QueuePolicy:      "block",
+			SyncInterval:     1,
+			SessionFile:      true,
+			Degrade: DegradeConfig{
+				BodiesAt:    0.5,
+				HeadersAt:   0.75,
+				SampleAt:    0.9,
+				SampleEvery: 10,
+			},
+		},
+		Admin: AdminConfig{
+			Host:        "127.0.0.1",
+			Port:        8081,
+			LivePreview: 1024,
+		},
+		PAC: PACConfig{
+			Enabled: true,
+		},
+		Validation: ValidationConfig{
+			Responses: true,
+		},
+		Pages: PagesConfig{
+			Enabled:   true,
+			TrustHost: "rogue.proxy",
+		},
+		Plugins: PluginsConfig{
+			Dir:         "plugins",
+			TimeoutMS:   2000,
+			MaxBodySize: 1 << 20,
+		},
+		Webhook: WebhookConfig{
+			Timeout: 30,
+			Retries: 3,
+		},
+		Tracing: TracingConfig{
+			ServiceName: "rogue",
+			SampleRatio: 1,
+		},
+		Environments: EnvironmentsConfig{File: "environments.yaml"},
+		Sidecar: SidecarConfig{
+			PodInfoDir: "/etc/podinfo",
+			Ports:      []int{80, 443},
+			ProxyUID:   1337,
+		},
+		Cache: CacheConfig{
+			MaxEntries:  1000,
+			MaxBodySize: 10 << 20,
+		},
+		Findings: FindingsConfig{
+			Enabled: true,
 		},
 	}
 }
@@ -83,3 +660,43 @@ func (c *Config) Save(path string) error {
 
 	return os.WriteFile(path, data, 0644)
 }
+
+// Redacted is what Redact puts in place of a secret.
+const Redacted = "REDACTED"
+
+// Redact returns a copy of c with passphrases, tokens and header values,
+// which often carry credentials, replaced by Redacted, and the keys of the
+// settings it replaced.
+func (c *Config) Redact() (*Config, []string) {
+	out := *c
+	var keys []string
+	secret := func(key string, v *string) {
+		if *v != "" {
+			*v = Redacted
+			keys = append(keys, key)
+		}
+	}
+	headers := func(key string, h map[string]string) map[string]string {
+		if len(h) == 0 {
+			return h
+		}
+		redacted := make(map[string]string, len(h))
+		for name := range h {
+			redacted[name] = Redacted
+			keys = append(keys, key+"."+name)
+		}
+		return redacted
+	}
+
+	secret("admin.token", &out.Admin.Token)
+	secret("certificate.key_passphrase", &out.Certificate.KeyPassphrase)
+	secret("proxy.upstream_proxy.password", &out.Proxy.UpstreamProxy.Password)
+	out.Webhook.Headers = headers("webhook.headers", c.Webhook.Headers)
+	out.Tracing.Headers = headers("tracing.headers", c.Tracing.Headers)
+	out.Logging.Sinks = slices.Clone(c.Logging.Sinks)
+	for i := range out.Logging.Sinks {
+		out.Logging.Sinks[i].Headers = headers(fmt.Sprintf("logging.sinks[%d].headers", i), c.Logging.Sinks[i].Headers)
+	}
+	slices.Sort(keys)
+	return &out, keys
+}
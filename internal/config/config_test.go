@@ -0,0 +1,29 @@
+package config
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	c := DefaultConfig()
+	c.Admin.Token = "s3cret"
+	c.Proxy.UpstreamProxy.Password = "hunter2"
+	c.Webhook.Headers = map[string]string{"Authorization": "Bearer x"}
+	c.Logging.Sinks = []SinkConfig{{Type: "http", Headers: map[string]string{"X-Api-Key": "k"}}}
+
+	r, keys := c.Redact()
+	want := []string{"admin.token", "logging.sinks[0].headers.X-Api-Key", "proxy.upstream_proxy.password", "webhook.headers.Authorization"}
+	if !slices.Equal(keys, want) {
+		t.Errorf("Redacted %v, want %v", keys, want)
+	}
+	if r.Admin.Token != Redacted || r.Proxy.UpstreamProxy.Password != Redacted || r.Webhook.Headers["Authorization"] != Redacted || r.Logging.Sinks[0].Headers["X-Api-Key"] != Redacted {
+		t.Errorf("Secrets left in %+v", r)
+	}
+	if c.Admin.Token != "s3cret" || c.Webhook.Headers["Authorization"] != "Bearer x" || c.Logging.Sinks[0].Headers["X-Api-Key"] != "k" {
+		t.Error("Redact changed the original config")
+	}
+	if r.Proxy.Port != c.Proxy.Port {
+		t.Error("Redact changed other settings")
+	}
+}
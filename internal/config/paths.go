@@ -0,0 +1,58 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+)
+
+// Legacy CA locations, relative to the working directory, used before the
+// CA moved to the data directory.
+const (
+	LegacyCertPath = "certs/ca.crt"
+	LegacyKeyPath  = "certs/ca.key"
+)
+
+// DataDir returns the per-user directory rogue keeps its CA in:
+// $XDG_DATA_HOME/rogue (default ~/.local/share/rogue) on Unix,
+// %AppData%\rogue on Windows and ~/Library/Application Support/rogue on
+// macOS.
+func DataDir() (string, error) {
+	switch runtime.GOOS {
+	case "windows", "darwin", "ios":
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(dir, "rogue"), nil
+	}
+
+	if dir := os.Getenv("XDG_DATA_HOME"); filepath.IsAbs(dir) {
+		return filepath.Join(dir, "rogue"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "share", "rogue"), nil
+}
+
+// defaultCAPaths places the CA in the data directory, falling back to the
+// legacy working directory layout when no home directory is known.
+func defaultCAPaths() (certPath, keyPath string) {
+	dir, err := DataDir()
+	if err != nil {
+		return LegacyCertPath, LegacyKeyPath
+	}
+	return filepath.Join(dir, "ca.crt"), filepath.Join(dir, "ca.key")
+}
+
+// KeyFileMode parses KeyPerm as an octal file mode such as "0600".
+func (c CertificateConfig) KeyFileMode() (os.FileMode, error) {
+	perm, err := strconv.ParseUint(c.KeyPerm, 8, 32)
+	if err != nil {
+		return 0, err
+	}
+	return os.FileMode(perm).Perm(), nil
+}
@@ -0,0 +1,289 @@
+// Package cookies keeps a jar of the cookies seen through the proxy: those
+// set by responses and those sent by clients, for inspection while testing
+// session handling or auditing what a site stores.
+package cookies
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxCookies bounds the jar; cookies beyond it are not tracked.
+const maxCookies = 10000
+
+// Cookie is a cookie as last observed.
+type Cookie struct {
+	Name     string    `json:"name"`
+	Value    string    `json:"value"`
+	Domain   string    `json:"domain"`
+	Path     string    `json:"path"`
+	HostOnly bool      `json:"host_only,omitempty"`
+	Expires  time.Time `json:"expires,omitzero"`
+	Secure   bool      `json:"secure,omitempty"`
+	HttpOnly bool      `json:"http_only,omitempty"`
+	SameSite string    `json:"same_site,omitempty"`
+	// Deleted is set when a response last expired the cookie.
+	Deleted bool `json:"deleted,omitempty"`
+	// SetBy is the URL of the last response setting the cookie.
+	SetBy     string    `json:"set_by,omitempty"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	// Sets and Sends count the responses setting the cookie and the
+	// requests carrying it.
+	Sets  int `json:"sets"`
+	Sends int `json:"sends"`
+}
+
+// Filter selects cookies by the host they are sent to and a name glob.
+type Filter struct {
+	Host string
+	Name string
+}
+
+// Match reports whether c passes f.
+func (f Filter) Match(c *Cookie) bool {
+	if f.Name != "" {
+		if ok, _ := path.Match(strings.ToLower(f.Name), strings.ToLower(c.Name)); !ok {
+			return false
+		}
+	}
+	return f.Host == "" || domainMatch(strings.ToLower(f.Host), c.Domain, c.HostOnly)
+}
+
+// Jar holds observed cookies. It is safe for concurrent use.
+type Jar struct {
+	mu      sync.Mutex
+	cookies map[string]*Cookie
+}
+
+// NewJar returns an empty jar.
+func NewJar() *Jar {
+	return &Jar{cookies: map[string]*Cookie{}}
+}
+
+func key(domain, path, name string) string {
+	return domain + "\x00" + path + "\x00" + name
+}
+
+// ObserveResponse records the cookies set by a response to u.
+func (j *Jar) ObserveResponse(u *url.URL, h http.Header, at time.Time) {
+	host := strings.ToLower(u.Hostname())
+	for _, line := range h.Values("Set-Cookie") {
+		sc, err := http.ParseSetCookie(line)
+		if err != nil {
+			continue
+		}
+		domain, hostOnly := host, true
+		if d := strings.ToLower(strings.TrimPrefix(sc.Domain, ".")); d != "" {
+			domain, hostOnly = d, false
+		}
+		p := sc.Path
+		if p == "" || p[0] != '/' {
+			p = defaultPath(u.Path)
+		}
+
+		j.mu.Lock()
+		c := j.entry(domain, p, sc.Name, at)
+		if c != nil {
+			c.Value = sc.Value
+			c.HostOnly = hostOnly
+			c.Expires = sc.Expires
+			if sc.MaxAge > 0 {
+				c.Expires = at.Add(time.Duration(sc.MaxAge) * time.Second)
+			}
+			c.Deleted = sc.MaxAge < 0 || (!sc.Expires.IsZero() && sc.Expires.Before(at))
+			c.Secure, c.HttpOnly = sc.Secure, sc.HttpOnly
+			c.SameSite = sameSite(sc.SameSite)
+			c.SetBy = u.String()
+			c.Sets++
+		}
+		j.mu.Unlock()
+	}
+}
+
+// ObserveRequest records the cookies a client sent to host. Each is
+// attributed to the most specific known cookie of that name the host would
+// be sent, or else to a new host-only cookie.
+func (j *Jar) ObserveRequest(host string, h http.Header, at time.Time) {
+	host = strings.ToLower(host)
+	for _, line := range h.Values("Cookie") {
+		sent, err := http.ParseCookie(line)
+		if err != nil {
+			continue
+		}
+		j.mu.Lock()
+		for _, s := range sent {
+			c := j.sentTo(host, s.Name)
+			if c == nil {
+				if c = j.entry(host, "/", s.Name, at); c == nil {
+					continue
+				}
+				c.HostOnly = true
+			}
+			c.Value = s.Value
+			c.LastSeen = at
+			c.Sends++
+		}
+		j.mu.Unlock()
+	}
+}
+
+// entry returns the cookie for the key, creating it if there is room.
+// j.mu must be held.
+func (j *Jar) entry(domain, path, name string, at time.Time) *Cookie {
+	k := key(domain, path, name)
+	c, ok := j.cookies[k]
+	if !ok {
+		if len(j.cookies) >= maxCookies {
+			return nil
+		}
+		c = &Cookie{Name: name, Domain: domain, Path: path, FirstSeen: at}
+		j.cookies[k] = c
+	}
+	c.LastSeen = at
+	return c
+}
+
+// sentTo finds the cookie called name with the longest domain and path
+// that host matches. j.mu must be held.
+func (j *Jar) sentTo(host, name string) *Cookie {
+	var best *Cookie
+	for _, c := range j.cookies {
+		if c.Name != name || !domainMatch(host, c.Domain, c.HostOnly) {
+			continue
+		}
+		if best == nil || len(c.Domain) > len(best.Domain) ||
+			(len(c.Domain) == len(best.Domain) && len(c.Path) > len(best.Path)) {
+			best = c
+		}
+	}
+	return best
+}
+
+// Cookies returns copies of the cookies passing f, ordered by domain, path
+// and name.
+func (j *Jar) Cookies(f Filter) []Cookie {
+	j.mu.Lock()
+	out := make([]Cookie, 0, len(j.cookies))
+	for _, c := range j.cookies {
+		if f.Match(c) {
+			out = append(out, *c)
+		}
+	}
+	j.mu.Unlock()
+
+	Sort(out)
+	return out
+}
+
+// Clear empties the jar.
+func (j *Jar) Clear() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.cookies = map[string]*Cookie{}
+}
+
+// ServeHTTP lists the jar as JSON on GET, filtered by the host and name
+// query parameters, and empties it on DELETE.
+func (j *Jar) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		q := r.URL.Query()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(j.Cookies(Filter{Host: q.Get("host"), Name: q.Get("name")}))
+	case http.MethodDelete:
+		j.Clear()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Sort orders cookies by domain, path and name.
+func Sort(cs []Cookie) {
+	slices.SortFunc(cs, func(a, b Cookie) int {
+		return cmp.Or(cmp.Compare(a.Domain, b.Domain), cmp.Compare(a.Path, b.Path), cmp.Compare(a.Name, b.Name))
+	})
+}
+
+// Write prints cookies as a table.
+func Write(w io.Writer, cs []Cookie) {
+	if len(cs) == 0 {
+		fmt.Fprintln(w, "No cookies")
+		return
+	}
+	fmt.Fprintf(w, "%-28s %-12s %-24s %-30s %5s %5s  %s\n", "DOMAIN", "PATH", "NAME", "VALUE", "SETS", "SENDS", "ATTRIBUTES")
+	for _, c := range cs {
+		fmt.Fprintf(w, "%-28s %-12s %-24s %-30s %5d %5d  %s\n",
+			clip(c.Domain, 28), clip(c.Path, 12), clip(c.Name, 24), clip(c.Value, 30), c.Sets, c.Sends, attributes(c))
+	}
+}
+
+func attributes(c Cookie) string {
+	var attrs []string
+	if c.Deleted {
+		attrs = append(attrs, "deleted")
+	} else if !c.Expires.IsZero() {
+		attrs = append(attrs, "expires "+c.Expires.UTC().Format(time.RFC3339))
+	}
+	if c.HostOnly {
+		attrs = append(attrs, "host-only")
+	}
+	if c.Secure {
+		attrs = append(attrs, "secure")
+	}
+	if c.HttpOnly {
+		attrs = append(attrs, "httponly")
+	}
+	if c.SameSite != "" {
+		attrs = append(attrs, "samesite="+c.SameSite)
+	}
+	return strings.Join(attrs, ", ")
+}
+
+func clip(s string, n int) string {
+	if r := []rune(s); len(r) > n {
+		return string(r[:n-3]) + "..."
+	}
+	return s
+}
+
+func sameSite(s http.SameSite) string {
+	switch s {
+	case http.SameSiteLaxMode:
+		return "lax"
+	case http.SameSiteStrictMode:
+		return "strict"
+	case http.SameSiteNoneMode:
+		return "none"
+	}
+	return ""
+}
+
+// domainMatch reports whether a cookie for domain is sent to host.
+func domainMatch(host, domain string, hostOnly bool) bool {
+	if host == domain {
+		return true
+	}
+	return !hostOnly && strings.HasSuffix(host, "."+domain)
+}
+
+// defaultPath is the default cookie path for a request path (RFC 6265
+// section 5.1.4).
+func defaultPath(p string) string {
+	i := strings.LastIndex(p, "/")
+	if i <= 0 {
+		return "/"
+	}
+	return p[:i]
+}
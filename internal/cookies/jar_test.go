@@ -0,0 +1,55 @@
+package cookies
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestJar(t *testing.T) {
+	j := NewJar()
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	u, _ := url.Parse("https://www.example.com/account/login")
+
+	h := http.Header{}
+	h.Add("Set-Cookie", "session=abc; Domain=.example.com; Path=/; Secure; HttpOnly; SameSite=Lax; Max-Age=3600")
+	h.Add("Set-Cookie", "csrf=xyz")
+	h.Add("Set-Cookie", "old=1; Max-Age=-1")
+	j.ObserveResponse(u, h, now)
+
+	req := http.Header{}
+	req.Set("Cookie", "session=abc; theme=dark")
+	j.ObserveRequest("api.example.com", req, now.Add(time.Minute))
+
+	all := j.Cookies(Filter{})
+	if len(all) != 4 {
+		t.Fatalf("got %d cookies, want 4: %+v", len(all), all)
+	}
+	byName := map[string]Cookie{}
+	for _, c := range all {
+		byName[c.Name] = c
+	}
+
+	s := byName["session"]
+	if s.Domain != "example.com" || s.HostOnly || !s.Secure || !s.HttpOnly || s.SameSite != "lax" ||
+		s.Sets != 1 || s.Sends != 1 || !s.Expires.Equal(now.Add(time.Hour)) {
+		t.Errorf("session: %+v", s)
+	}
+	if c := byName["csrf"]; c.Domain != "www.example.com" || !c.HostOnly || c.Path != "/account" {
+		t.Errorf("csrf: %+v", c)
+	}
+	if !byName["old"].Deleted {
+		t.Errorf("old should be deleted: %+v", byName["old"])
+	}
+	if c := byName["theme"]; c.Domain != "api.example.com" || c.Sets != 0 || c.Sends != 1 {
+		t.Errorf("theme: %+v", c)
+	}
+
+	if got := j.Cookies(Filter{Host: "api.example.com"}); len(got) != 2 {
+		t.Errorf("cookies sent to api.example.com: %+v", got)
+	}
+	if got := j.Cookies(Filter{Name: "se*"}); len(got) != 1 {
+		t.Errorf("cookies named se*: %+v", got)
+	}
+}
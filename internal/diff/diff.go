@@ -0,0 +1,357 @@
+// Package diff compares two recorded sessions. Requests are matched by
+// method and URL, and the responses of matched requests are compared by
+// status, headers and body, walking JSON bodies field by field.
+package diff
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/standrze/rogue/internal/logger"
+)
+
+// DefaultIgnoredHeaders are response headers that differ between otherwise
+// identical responses.
+var DefaultIgnoredHeaders = []string{"Date", "Age", "Expires", "Last-Modified", "Etag", "Set-Cookie", "X-Request-Id"}
+
+// maxShown bounds the differences printed per exchange.
+const maxShown = 20
+
+// Kinds of difference.
+const (
+	Changed = "changed"
+	Added   = "added"
+	Removed = "removed"
+)
+
+// Difference is one part of a response that is not the same in both
+// sessions. Path is empty for the status, a header name for headers and a
+// JSON path ($.a[0].b) or line number for bodies. A and B are missing for
+// Added and Removed respectively.
+type Difference struct {
+	Kind string `json:"kind"`
+	Path string `json:"path,omitempty"`
+	A    string `json:"a,omitempty"`
+	B    string `json:"b,omitempty"`
+}
+
+// Exchange is a request found in one or both sessions.
+type Exchange struct {
+	Method string `json:"method"`
+	URL    string `json:"url"`
+	IDA    string `json:"id_a,omitempty"`
+	IDB    string `json:"id_b,omitempty"`
+
+	Status  *Difference  `json:"status,omitempty"`
+	Headers []Difference `json:"headers,omitempty"`
+	Body    []Difference `json:"body,omitempty"`
+	// Truncated is set when either body was cut short when logged, so
+	// body differences may be incomplete.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// Changed reports whether the responses differ.
+func (e *Exchange) Changed() bool {
+	return e.Status != nil || len(e.Headers) > 0 || len(e.Body) > 0
+}
+
+// Report is the result of comparing two sessions.
+type Report struct {
+	Unchanged int        `json:"unchanged"`
+	Changed   []Exchange `json:"changed"`
+	OnlyA     []Exchange `json:"only_a"`
+	OnlyB     []Exchange `json:"only_b"`
+}
+
+// Empty reports whether the sessions matched completely.
+func (r *Report) Empty() bool {
+	return len(r.Changed) == 0 && len(r.OnlyA) == 0 && len(r.OnlyB) == 0
+}
+
+// Options tunes a comparison.
+type Options struct {
+	// IgnoreHeaders are left out when comparing headers.
+	IgnoreHeaders []string
+}
+
+// Compare matches the requests of a and b by method and URL and compares
+// their responses. Repeated requests are paired in the order they were made.
+func Compare(a, b []*logger.Flow, opts Options) *Report {
+	ignored := map[string]bool{}
+	for _, h := range opts.IgnoreHeaders {
+		ignored[http.CanonicalHeaderKey(h)] = true
+	}
+
+	pending := map[string][]*logger.Flow{}
+	for _, f := range b {
+		if f.Request != nil {
+			pending[key(f)] = append(pending[key(f)], f)
+		}
+	}
+
+	r := &Report{}
+	for _, fa := range a {
+		if fa.Request == nil {
+			continue
+		}
+		k := key(fa)
+		if len(pending[k]) == 0 {
+			r.OnlyA = append(r.OnlyA, Exchange{Method: fa.Request.Method, URL: fa.Request.URL, IDA: fa.ID})
+			continue
+		}
+		fb := pending[k][0]
+		pending[k] = pending[k][1:]
+
+		e := compare(fa, fb, ignored)
+		if e.Changed() {
+			r.Changed = append(r.Changed, e)
+		} else {
+			r.Unchanged++
+		}
+	}
+	for _, fb := range b {
+		if fb.Request != nil && slices.Contains(pending[key(fb)], fb) {
+			r.OnlyB = append(r.OnlyB, Exchange{Method: fb.Request.Method, URL: fb.Request.URL, IDB: fb.ID})
+		}
+	}
+	return r
+}
+
+func key(f *logger.Flow) string {
+	return f.Request.Method + " " + f.Request.URL
+}
+
+func compare(a, b *logger.Flow, ignored map[string]bool) Exchange {
+	e := Exchange{Method: a.Request.Method, URL: a.Request.URL, IDA: a.ID, IDB: b.ID}
+	ra, rb := a.Response, b.Response
+	if ra == nil || rb == nil {
+		if ra != rb {
+			e.Status = &Difference{Kind: Changed, A: status(ra), B: status(rb)}
+		}
+		return e
+	}
+
+	if ra.StatusCode != rb.StatusCode {
+		e.Status = &Difference{Kind: Changed, A: status(ra), B: status(rb)}
+	}
+	e.Headers = compareHeaders(ra.Headers, rb.Headers, ignored)
+	e.Body = compareBodies(body(ra), body(rb))
+	e.Truncated = ra.Truncated || rb.Truncated
+	return e
+}
+
+func status(r *logger.ResponseLog) string {
+	if r == nil {
+		return "no response"
+	}
+	return fmt.Sprint(r.StatusCode)
+}
+
+// body returns the logged body, or the decoded form of bodies that are not
+// text.
+func body(r *logger.ResponseLog) string {
+	if r.Body == "" && r.Decoded != nil && r.Decoded.Value != nil {
+		if data, err := json.Marshal(r.Decoded.Value); err == nil {
+			return string(data)
+		}
+	}
+	return r.Body
+}
+
+func compareHeaders(a, b map[string]string, ignored map[string]bool) []Difference {
+	names := map[string]bool{}
+	for k := range a {
+		names[k] = true
+	}
+	for k := range b {
+		names[k] = true
+	}
+
+	var diffs []Difference
+	for k := range names {
+		if ignored[http.CanonicalHeaderKey(k)] {
+			continue
+		}
+		va, inA := a[k]
+		vb, inB := b[k]
+		switch {
+		case !inA:
+			diffs = append(diffs, Difference{Kind: Added, Path: k, B: vb})
+		case !inB:
+			diffs = append(diffs, Difference{Kind: Removed, Path: k, A: va})
+		case va != vb:
+			diffs = append(diffs, Difference{Kind: Changed, Path: k, A: va, B: vb})
+		}
+	}
+	slices.SortFunc(diffs, func(x, y Difference) int { return cmp.Compare(x.Path, y.Path) })
+	return diffs
+}
+
+// compareBodies compares JSON bodies structurally and anything else line
+// by line, reporting the first differing line.
+func compareBodies(a, b string) []Difference {
+	if a == b {
+		return nil
+	}
+	if va, ok := parseJSON(a); ok {
+		if vb, ok := parseJSON(b); ok {
+			var diffs []Difference
+			compareJSON("$", va, vb, &diffs)
+			return diffs
+		}
+	}
+
+	la, lb := strings.Split(a, "\n"), strings.Split(b, "\n")
+	for i := 0; ; i++ {
+		path := fmt.Sprintf("line %d", i+1)
+		switch {
+		case i >= len(la):
+			return []Difference{{Kind: Added, Path: path, B: lb[i]}}
+		case i >= len(lb):
+			return []Difference{{Kind: Removed, Path: path, A: la[i]}}
+		case la[i] != lb[i]:
+			return []Difference{{Kind: Changed, Path: path, A: la[i], B: lb[i]}}
+		}
+	}
+}
+
+// parseJSON decodes objects and arrays, keeping numbers as written.
+func parseJSON(s string) (any, bool) {
+	trimmed := strings.TrimSpace(s)
+	if trimmed == "" || (trimmed[0] != '{' && trimmed[0] != '[') {
+		return nil, false
+	}
+	dec := json.NewDecoder(strings.NewReader(trimmed))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, false
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, false
+	}
+	return v, true
+}
+
+func compareJSON(path string, a, b any, diffs *[]Difference) {
+	switch va := a.(type) {
+	case map[string]any:
+		vb, ok := b.(map[string]any)
+		if !ok {
+			break
+		}
+		keys := make([]string, 0, len(va)+len(vb))
+		for k := range va {
+			keys = append(keys, k)
+		}
+		for k := range vb {
+			if _, ok := va[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		slices.Sort(keys)
+		for _, k := range keys {
+			p := path + "." + k
+			xa, inA := va[k]
+			xb, inB := vb[k]
+			switch {
+			case !inA:
+				*diffs = append(*diffs, Difference{Kind: Added, Path: p, B: encode(xb)})
+			case !inB:
+				*diffs = append(*diffs, Difference{Kind: Removed, Path: p, A: encode(xa)})
+			default:
+				compareJSON(p, xa, xb, diffs)
+			}
+		}
+		return
+	case []any:
+		vb, ok := b.([]any)
+		if !ok {
+			break
+		}
+		for i := range max(len(va), len(vb)) {
+			p := fmt.Sprintf("%s[%d]", path, i)
+			switch {
+			case i >= len(va):
+				*diffs = append(*diffs, Difference{Kind: Added, Path: p, B: encode(vb[i])})
+			case i >= len(vb):
+				*diffs = append(*diffs, Difference{Kind: Removed, Path: p, A: encode(va[i])})
+			default:
+				compareJSON(p, va[i], vb[i], diffs)
+			}
+		}
+		return
+	}
+	if ea, eb := encode(a), encode(b); ea != eb {
+		*diffs = append(*diffs, Difference{Kind: Changed, Path: path, A: ea, B: eb})
+	}
+}
+
+func encode(v any) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(v)
+	return strings.TrimSpace(buf.String())
+}
+
+// Write prints r as a text report: "~" marks changed exchanges, "-" those
+// only in the first session and "+" those only in the second.
+func Write(w io.Writer, r *Report) {
+	fmt.Fprintf(w, "%d unchanged, %d changed, %d only in A, %d only in B\n",
+		r.Unchanged, len(r.Changed), len(r.OnlyA), len(r.OnlyB))
+
+	for _, e := range r.Changed {
+		fmt.Fprintf(w, "\n~ %s %s [%s -> %s]\n", e.Method, e.URL, e.IDA, e.IDB)
+		if e.Status != nil {
+			fmt.Fprintf(w, "    status: %s -> %s\n", e.Status.A, e.Status.B)
+		}
+		shown := 0
+		for _, d := range e.Headers {
+			if shown++; shown <= maxShown {
+				fmt.Fprintf(w, "    header %s: %s\n", d.Path, change(d))
+			}
+		}
+		for _, d := range e.Body {
+			if shown++; shown <= maxShown {
+				fmt.Fprintf(w, "    body %s: %s\n", d.Path, change(d))
+			}
+		}
+		if shown > maxShown {
+			fmt.Fprintf(w, "    ... and %d more\n", shown-maxShown)
+		}
+		if e.Truncated {
+			fmt.Fprintln(w, "    (a body was truncated when logged; differences past the cut are not shown)")
+		}
+	}
+	for _, e := range r.OnlyA {
+		fmt.Fprintf(w, "\n- %s %s [%s]\n", e.Method, e.URL, e.IDA)
+	}
+	for _, e := range r.OnlyB {
+		fmt.Fprintf(w, "\n+ %s %s [%s]\n", e.Method, e.URL, e.IDB)
+	}
+}
+
+func change(d Difference) string {
+	switch d.Kind {
+	case Added:
+		return "(missing) -> " + clip(d.B)
+	case Removed:
+		return clip(d.A) + " -> (missing)"
+	}
+	return clip(d.A) + " -> " + clip(d.B)
+}
+
+// clip shortens long values for the text report.
+func clip(s string) string {
+	if r := []rune(s); len(r) > 80 {
+		return string(r[:77]) + "..."
+	}
+	return s
+}
@@ -0,0 +1,86 @@
+package diff
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/standrze/rogue/internal/logger"
+)
+
+func flow(id, method, url string, status int, headers map[string]string, body string) *logger.Flow {
+	return &logger.Flow{
+		ID:       id,
+		Request:  &logger.RequestLog{Method: method, URL: url, RequestID: id},
+		Response: &logger.ResponseLog{StatusCode: status, Headers: headers, Body: body, RequestID: id},
+	}
+}
+
+func TestCompare(t *testing.T) {
+	json := map[string]string{"Content-Type": "application/json", "Date": "Mon"}
+	a := []*logger.Flow{
+		flow("a1", "GET", "http://api/users", 200, json, `{"users":[{"id":1,"name":"ann"},{"id":2}],"total":2}`),
+		flow("a2", "GET", "http://api/health", 200, nil, "ok"),
+		flow("a3", "GET", "http://api/health", 200, nil, "ok"),
+		flow("a4", "DELETE", "http://api/users/1", 204, nil, ""),
+		flow("a5", "GET", "http://api/page", 200, nil, "<h1>\n<p>one</p>"),
+	}
+	b := []*logger.Flow{
+		flow("b1", "GET", "http://api/health", 200, nil, "ok"),
+		flow("b2", "GET", "http://api/users", 500, map[string]string{"Content-Type": "application/json", "Date": "Tue", "Retry-After": "1"},
+			`{"users":[{"id":1,"name":"bob"}], "total": 2, "next": null}`),
+		flow("b3", "GET", "http://api/health", 200, nil, "ok"),
+		flow("b4", "POST", "http://api/users", 201, nil, ""),
+		flow("b5", "GET", "http://api/page", 200, nil, "<h1>\n<p>two</p>"),
+	}
+
+	r := Compare(a, b, Options{IgnoreHeaders: DefaultIgnoredHeaders})
+	if r.Unchanged != 2 || len(r.Changed) != 2 || len(r.OnlyA) != 1 || len(r.OnlyB) != 1 {
+		t.Fatalf("report = %+v", r)
+	}
+	if r.OnlyA[0].IDA != "a4" || r.OnlyB[0].IDB != "b4" {
+		t.Errorf("only = %+v, %+v", r.OnlyA, r.OnlyB)
+	}
+
+	users := r.Changed[0]
+	if users.IDA != "a1" || users.IDB != "b2" || users.Status == nil || users.Status.B != "500" {
+		t.Errorf("users = %+v", users)
+	}
+	if len(users.Headers) != 1 || users.Headers[0] != (Difference{Kind: Added, Path: "Retry-After", B: "1"}) {
+		t.Errorf("headers = %+v", users.Headers)
+	}
+	want := []Difference{
+		{Kind: Added, Path: "$.next", B: "null"},
+		{Kind: Changed, Path: "$.users[0].name", A: `"ann"`, B: `"bob"`},
+		{Kind: Removed, Path: "$.users[1]", A: `{"id":2}`},
+	}
+	if len(users.Body) != len(want) {
+		t.Fatalf("body = %+v, want %+v", users.Body, want)
+	}
+	for i := range want {
+		if users.Body[i] != want[i] {
+			t.Errorf("body[%d] = %+v, want %+v", i, users.Body[i], want[i])
+		}
+	}
+
+	page := r.Changed[1]
+	if len(page.Body) != 1 || page.Body[0] != (Difference{Kind: Changed, Path: "line 2", A: "<p>one</p>", B: "<p>two</p>"}) {
+		t.Errorf("page body = %+v", page.Body)
+	}
+
+	var out bytes.Buffer
+	Write(&out, r)
+	for _, s := range []string{
+		"2 unchanged, 2 changed, 1 only in A, 1 only in B",
+		"~ GET http://api/users [a1 -> b2]",
+		"status: 200 -> 500",
+		"body $.users[0].name: \"ann\" -> \"bob\"",
+		"body $.users[1]: {\"id\":2} -> (missing)",
+		"- DELETE http://api/users/1 [a4]",
+		"+ POST http://api/users [b4]",
+	} {
+		if !strings.Contains(out.String(), s) {
+			t.Errorf("report missing %q:\n%s", s, out.String())
+		}
+	}
+}
@@ -0,0 +1,118 @@
+// Package display formats times and numbers in human-readable output, such
+// as reports and the session viewer, for a chosen time zone and locale.
+// Session files always store RFC 3339 timestamps with their UTC offset.
+package display
+
+import (
+	"os"
+	"strings"
+	"time"
+	// Embedded so --timezone works on hosts without a zoneinfo database.
+	_ "time/tzdata"
+
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+// TimestampLayout shows a full timestamp with its offset, so times from
+// teams in different zones are never ambiguous.
+const TimestampLayout = "2006-01-02 15:04:05 Z07:00"
+
+// Formatter renders values for people.
+type Formatter struct {
+	// loc is the zone times are shown in; nil keeps each time's own offset.
+	loc     *time.Location
+	printer *message.Printer
+}
+
+// Default keeps recorded offsets and formats numbers in English.
+var Default = &Formatter{printer: message.NewPrinter(language.English)}
+
+// New returns a Formatter for timezone and locale. timezone is an IANA name
+// such as "Europe/Berlin", "UTC" or "Local"; empty keeps the offset each
+// time was recorded with. locale is a BCP 47 tag such as "de-DE"; empty
+// takes it from LC_ALL, LC_NUMERIC or LANG.
+func New(timezone, locale string) (*Formatter, error) {
+	f := &Formatter{}
+	switch {
+	case timezone == "":
+	case strings.EqualFold(timezone, "local"):
+		f.loc = time.Local
+	default:
+		loc, err := time.LoadLocation(timezone)
+		if err != nil {
+			return nil, err
+		}
+		f.loc = loc
+	}
+
+	if locale == "" {
+		locale = envLocale()
+	}
+	tag := language.English
+	if locale != "" {
+		t, err := language.Parse(locale)
+		if err != nil {
+			return nil, err
+		}
+		tag = t
+	}
+	f.printer = message.NewPrinter(tag)
+	return f, nil
+}
+
+// envLocale converts the POSIX locale of the environment, such as
+// "de_DE.UTF-8", to a language tag.
+func envLocale() string {
+	for _, v := range []string{"LC_ALL", "LC_NUMERIC", "LANG"} {
+		s := os.Getenv(v)
+		if s == "" {
+			continue
+		}
+		s, _, _ = strings.Cut(s, ".")
+		s, _, _ = strings.Cut(s, "@")
+		if s == "C" || s == "POSIX" {
+			return ""
+		}
+		return strings.ReplaceAll(s, "_", "-")
+	}
+	return ""
+}
+
+// In converts t to the configured zone.
+func (f *Formatter) In(t time.Time) time.Time {
+	if f.loc == nil {
+		return t
+	}
+	return t.In(f.loc)
+}
+
+// Time formats t with layout in the configured zone.
+func (f *Formatter) Time(t time.Time, layout string) string {
+	return f.In(t).Format(layout)
+}
+
+// Timestamp formats t with TimestampLayout in the configured zone.
+func (f *Formatter) Timestamp(t time.Time) string {
+	return f.Time(t, TimestampLayout)
+}
+
+// Number formats an integer or float with the locale's digit grouping and
+// decimal separator.
+func (f *Formatter) Number(n any) string {
+	switch n.(type) {
+	case float32, float64:
+		return f.printer.Sprintf("%.2f", n)
+	}
+	return f.printer.Sprintf("%d", n)
+}
+
+// Funcs returns template functions using f: time (with a layout),
+// timestamp and number.
+func (f *Formatter) Funcs() map[string]any {
+	return map[string]any{
+		"time":      f.Time,
+		"timestamp": f.Timestamp,
+		"number":    f.Number,
+	}
+}
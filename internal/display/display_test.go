@@ -0,0 +1,32 @@
+package display
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFormatter(t *testing.T) {
+	f, err := New("Asia/Tokyo", "de-DE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := time.Date(2025, 1, 2, 23, 30, 0, 0, time.UTC)
+	if got, want := f.Timestamp(ts), "2025-01-03 08:30:00 +09:00"; got != want {
+		t.Errorf("Timestamp = %q, want %q", got, want)
+	}
+	if got, want := f.Number(1234567), "1.234.567"; got != want {
+		t.Errorf("Number = %q, want %q", got, want)
+	}
+	if got, want := f.Number(1234.5), "1.234,50"; got != want {
+		t.Errorf("Number = %q, want %q", got, want)
+	}
+
+	// Without a zone, times keep the offset they were recorded with.
+	if got, want := Default.Timestamp(ts), "2025-01-02 23:30:00 Z"; got != want {
+		t.Errorf("Timestamp = %q, want %q", got, want)
+	}
+
+	if _, err := New("Mars/Olympus", ""); err == nil {
+		t.Error("expected an error for an unknown zone")
+	}
+}
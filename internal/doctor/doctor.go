@@ -0,0 +1,322 @@
+// Package doctor runs an end-to-end self-test of the proxy: it loads the CA,
+// starts a throwaway proxy and sends HTTP and HTTPS requests through it to
+// local origins, checking interception and logging along the way.
+package doctor
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/standrze/rogue/internal/cert"
+	"github.com/standrze/rogue/internal/display"
+	"github.com/standrze/rogue/internal/logger"
+	"github.com/standrze/rogue/internal/mitm"
+	"github.com/standrze/rogue/internal/proxy"
+)
+
+// expiryWarning is how close to expiry the CA is reported.
+const expiryWarning = 30 * 24 * time.Hour
+
+// requestTimeout bounds each request sent through the proxy.
+const requestTimeout = 10 * time.Second
+
+// originBody is what the local origins answer with.
+const originBody = "rogue doctor"
+
+// Status is the outcome of a check.
+type Status int
+
+const (
+	Pass Status = iota
+	Warn
+	Fail
+)
+
+func (s Status) String() string {
+	switch s {
+	case Pass:
+		return "PASS"
+	case Warn:
+		return "WARN"
+	}
+	return "FAIL"
+}
+
+// Check is the result of one step of the self-test.
+type Check struct {
+	Name   string
+	Status Status
+	Detail string
+}
+
+// Report lists the checks run, in order.
+type Report struct {
+	Checks []Check
+}
+
+// Failed reports whether any check failed.
+func (r *Report) Failed() bool {
+	for _, c := range r.Checks {
+		if c.Status == Fail {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Report) add(name string, status Status, format string, args ...any) {
+	r.Checks = append(r.Checks, Check{Name: name, Status: status, Detail: fmt.Sprintf(format, args...)})
+}
+
+// Options describes the CA and TLS settings to test.
+type Options struct {
+	CertPath   string
+	KeyPath    string
+	Passphrase cert.PassphraseFunc
+	TLSPolicy  proxy.TLSPolicy
+	// Now is the time certificates are checked against; zero means the
+	// system clock.
+	Now time.Time
+	// Formatter shows times in the report; nil means display.Default.
+	Formatter *display.Formatter
+}
+
+// Run performs the self-test. Later checks are skipped once one they depend
+// on fails.
+func Run(opts Options) *Report {
+	r := &Report{}
+	now := opts.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+	f := opts.Formatter
+	if f == nil {
+		f = display.Default
+	}
+
+	if !cert.Exists(opts.CertPath, opts.KeyPath) {
+		r.add("CA certificate", Fail, "no CA at %s and %s; rogue start generates one", opts.CertPath, opts.KeyPath)
+		return r
+	}
+	chain, key, err := cert.LoadChain(opts.CertPath, opts.KeyPath, opts.Passphrase)
+	if err != nil {
+		r.add("CA certificate", Fail, "%v", err)
+		return r
+	}
+	ca := chain[0]
+	r.add("CA certificate", Pass, "%s (%s)", ca.Subject.CommonName, opts.CertPath)
+
+	checkValidity(r, f, chain, now)
+	checkSystemTrust(r, chain)
+
+	dir, err := os.MkdirTemp("", "rogue-doctor-")
+	if err != nil {
+		r.add("Proxy", Fail, "%v", err)
+		return r
+	}
+	defer os.RemoveAll(dir)
+
+	mc, err := mitm.NewConfig(ca, key)
+	if err != nil {
+		r.add("Proxy", Fail, "%v", err)
+		return r
+	}
+	plain, secure, err := startOrigins(mc)
+	if err != nil {
+		r.add("Local origins", Fail, "%v", err)
+		return r
+	}
+	defer plain.Close()
+	defer secure.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		r.add("Proxy", Fail, "cannot bind a port: %v", err)
+		return r
+	}
+	defer l.Close()
+
+	// The HTTPS origin's certificate is only trusted by the client, so the
+	// proxy must not verify it.
+	policy := opts.TLSPolicy
+	policy.SkipVerifyHosts = append(append([]string(nil), policy.SkipVerifyHosts...), "127.0.0.1")
+
+	sl, err := startProxy(l, opts, policy, dir)
+	if err != nil {
+		r.add("Proxy", Fail, "%v", err)
+		return r
+	}
+	r.add("Proxy", Pass, "listening on %s", l.Addr())
+
+	proxyURL := &url.URL{Scheme: "http", Host: l.Addr().String()}
+	roots := x509.NewCertPool()
+	roots.AddCert(chain[len(chain)-1])
+	client := &http.Client{
+		Timeout: requestTimeout,
+		Transport: &http.Transport{
+			Proxy:           http.ProxyURL(proxyURL),
+			TLSClientConfig: &tls.Config{RootCAs: roots},
+		},
+	}
+
+	if _, err := get(client, "http://"+plain.Addr().String()+"/"); err != nil {
+		r.add("HTTP through proxy", Fail, "%v", err)
+	} else {
+		r.add("HTTP through proxy", Pass, "fetched http://%s/", plain.Addr())
+	}
+
+	res, err := get(client, "https://"+secure.Addr().String()+"/")
+	if err != nil {
+		r.add("HTTPS interception", Fail, "%v", err)
+	} else {
+		checkLeaf(r, f, res.TLS, ca, now)
+	}
+
+	sl.Close()
+	checkSession(r, filepath.Join(dir, sl.GetSessionName()))
+	return r
+}
+
+// startProxy serves a proxy on l that logs to dir. NewProxyServer reports
+// setup errors by panicking, so they are recovered here.
+func startProxy(l net.Listener, opts Options, policy proxy.TLSPolicy, dir string) (sl *logger.SessionLogger, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = fmt.Errorf("%v", v)
+		}
+	}()
+	p, sl := proxy.NewProxyServer(
+		proxy.WithCert(opts.CertPath, opts.KeyPath),
+		proxy.WithCAGeneration(false),
+		proxy.WithKeyPassphrase(opts.Passphrase, false),
+		proxy.WithSessionDir(dir),
+		proxy.WithTLSPolicy(policy),
+		proxy.WithServerCertLogging(false),
+	)
+	go p.Serve(l)
+	return sl, nil
+}
+
+// startOrigins serves originBody over plain HTTP and over HTTPS with a
+// certificate for 127.0.0.1 signed by the CA.
+func startOrigins(mc *mitm.Config) (plain, secure net.Listener, err error) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, originBody)
+	})
+
+	if plain, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+		return nil, nil, err
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		plain.Close()
+		return nil, nil, err
+	}
+	secure = tls.NewListener(l, mc.TLSForHost("127.0.0.1"))
+
+	go http.Serve(plain, handler)
+	go http.Serve(secure, handler)
+	return plain, secure, nil
+}
+
+func get(client *http.Client, u string) (*http.Response, error) {
+	res, err := client.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK || string(body) != originBody {
+		return nil, fmt.Errorf("unexpected response %s: %.200q", res.Status, body)
+	}
+	return res, nil
+}
+
+func checkValidity(r *Report, f *display.Formatter, chain []*x509.Certificate, now time.Time) {
+	for _, c := range chain {
+		switch {
+		case now.Before(c.NotBefore):
+			r.add("CA validity", Fail, "%s is not valid until %s; check the system clock", c.Subject.CommonName, f.Timestamp(c.NotBefore))
+			return
+		case now.After(c.NotAfter):
+			r.add("CA validity", Fail, "%s expired on %s; regenerate it with rogue cert", c.Subject.CommonName, f.Timestamp(c.NotAfter))
+			return
+		case c.NotAfter.Sub(now) < expiryWarning:
+			r.add("CA validity", Warn, "%s expires on %s", c.Subject.CommonName, f.Timestamp(c.NotAfter))
+			return
+		}
+	}
+	r.add("CA validity", Pass, "valid until %s", f.Timestamp(chain[0].NotAfter))
+}
+
+// checkSystemTrust reports whether clients on this machine that use the
+// system store will accept intercepted certificates.
+func checkSystemTrust(r *Report, chain []*x509.Certificate) {
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		r.add("System trust", Warn, "cannot read the system trust store: %v", err)
+		return
+	}
+	intermediates := x509.NewCertPool()
+	for _, c := range chain[1:] {
+		intermediates.AddCert(c)
+	}
+	_, err = chain[0].Verify(x509.VerifyOptions{Roots: pool, Intermediates: intermediates})
+	if err != nil {
+		r.add("System trust", Warn, "the CA is not trusted by this machine; browse to http://rogue.proxy/ through the proxy to install it")
+		return
+	}
+	r.add("System trust", Pass, "the CA is trusted by this machine")
+}
+
+// checkLeaf confirms the origin's certificate was replaced by one the CA
+// signed and that it is valid now.
+func checkLeaf(r *Report, f *display.Formatter, cs *tls.ConnectionState, ca *x509.Certificate, now time.Time) {
+	if cs == nil || len(cs.PeerCertificates) == 0 {
+		r.add("HTTPS interception", Fail, "no certificate was presented")
+		return
+	}
+	leaf := cs.PeerCertificates[0]
+	if err := leaf.CheckSignatureFrom(ca); err != nil {
+		r.add("HTTPS interception", Fail, "the certificate for %s was not signed by the CA: %v", leaf.Subject.CommonName, err)
+		return
+	}
+	r.add("HTTPS interception", Pass, "certificate for %s signed by %s", leaf.Subject.CommonName, ca.Subject.CommonName)
+
+	if now.Before(leaf.NotBefore) || now.After(leaf.NotAfter) {
+		r.add("Minted certificate validity", Fail, "valid %s to %s; check the system clock",
+			f.Timestamp(leaf.NotBefore), f.Timestamp(leaf.NotAfter))
+		return
+	}
+	r.add("Minted certificate validity", Pass, "valid until %s", f.Timestamp(leaf.NotAfter))
+}
+
+// checkSession confirms both exchanges were recorded.
+func checkSession(r *Report, path string) {
+	entries, err := logger.ReadSession(path)
+	if err != nil {
+		r.add("Session logging", Fail, "%v", err)
+		return
+	}
+	counts := map[string]int{}
+	for _, e := range entries {
+		counts[e.Type]++
+	}
+	if counts["request"] < 2 || counts["response"] < 2 {
+		r.add("Session logging", Fail, "expected 2 requests and 2 responses, found %d and %d", counts["request"], counts["response"])
+		return
+	}
+	r.add("Session logging", Pass, "%d requests and %d responses recorded", counts["request"], counts["response"])
+}
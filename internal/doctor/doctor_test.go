@@ -0,0 +1,30 @@
+package doctor
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/standrze/rogue/internal/cert"
+)
+
+func TestRun(t *testing.T) {
+	tmpDir := t.TempDir()
+	certPath, keyPath := filepath.Join(tmpDir, "ca.crt"), filepath.Join(tmpDir, "ca.key")
+	if err := cert.GenerateSelfSigned("Rogue Proxy", "Rogue CA", 365, certPath, keyPath, cert.KeyOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	r := Run(Options{CertPath: certPath, KeyPath: keyPath})
+	for _, c := range r.Checks {
+		t.Logf("%s %s: %s", c.Status, c.Name, c.Detail)
+	}
+	if r.Failed() {
+		t.Fatal("self-test failed")
+	}
+
+	r = Run(Options{CertPath: certPath, KeyPath: keyPath, Now: time.Now().AddDate(2, 0, 0)})
+	if !r.Failed() || r.Checks[1].Name != "CA validity" || r.Checks[1].Status != Fail {
+		t.Errorf("expired CA not reported: %+v", r.Checks)
+	}
+}
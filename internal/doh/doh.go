@@ -0,0 +1,204 @@
+// Package doh decodes DNS-over-HTTPS (RFC 8484) messages so lookups made by
+// DoH clients show up as names, types and answers in the session log.
+package doh
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/standrze/rogue/internal/logger"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// ContentType is the media type of DoH bodies.
+const ContentType = "application/dns-message"
+
+// Question is one entry of the question section.
+type Question struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Class string `json:"class,omitempty"`
+}
+
+// Record is one resource record.
+type Record struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	TTL  uint32 `json:"ttl"`
+	Data string `json:"data"`
+}
+
+// Message is the readable form of a DNS message.
+type Message struct {
+	ID          uint16     `json:"id"`
+	Response    bool       `json:"response"`
+	Opcode      string     `json:"opcode,omitempty"`
+	RCode       string     `json:"rcode,omitempty"`
+	Flags       []string   `json:"flags,omitempty"`
+	Questions   []Question `json:"questions"`
+	Answers     []Record   `json:"answers,omitempty"`
+	Authorities []Record   `json:"authorities,omitempty"`
+	Additionals []Record   `json:"additionals,omitempty"`
+}
+
+// Parse decodes a wire-format DNS message.
+func Parse(b []byte) (*Message, error) {
+	var m dnsmessage.Message
+	if err := m.Unpack(b); err != nil {
+		return nil, err
+	}
+
+	msg := &Message{
+		ID:       m.ID,
+		Response: m.Response,
+	}
+	if m.OpCode != 0 {
+		msg.Opcode = fmt.Sprint(m.OpCode)
+	}
+	if m.Response {
+		msg.RCode = strings.TrimPrefix(m.RCode.String(), "RCode")
+	}
+	for _, f := range []struct {
+		set  bool
+		name string
+	}{
+		{m.Authoritative, "aa"},
+		{m.Truncated, "tc"},
+		{m.RecursionDesired, "rd"},
+		{m.RecursionAvailable, "ra"},
+		{m.AuthenticData, "ad"},
+		{m.CheckingDisabled, "cd"},
+	} {
+		if f.set {
+			msg.Flags = append(msg.Flags, f.name)
+		}
+	}
+
+	for _, q := range m.Questions {
+		question := Question{Name: q.Name.String(), Type: typeName(q.Type)}
+		if q.Class != dnsmessage.ClassINET {
+			question.Class = strings.TrimPrefix(q.Class.String(), "Class")
+		}
+		msg.Questions = append(msg.Questions, question)
+	}
+	msg.Answers = records(m.Answers)
+	msg.Authorities = records(m.Authorities)
+	msg.Additionals = records(m.Additionals)
+	return msg, nil
+}
+
+func typeName(t dnsmessage.Type) string {
+	return strings.TrimPrefix(t.String(), "Type")
+}
+
+func records(rrs []dnsmessage.Resource) []Record {
+	var out []Record
+	for _, rr := range rrs {
+		// EDNS pseudo-records carry no answer data.
+		if rr.Header.Type == dnsmessage.TypeOPT {
+			continue
+		}
+		out = append(out, Record{
+			Name: rr.Header.Name.String(),
+			Type: typeName(rr.Header.Type),
+			TTL:  rr.Header.TTL,
+			Data: rdata(rr.Body),
+		})
+	}
+	return out
+}
+
+// rdata renders a record body in zone file presentation format.
+func rdata(body dnsmessage.ResourceBody) string {
+	switch r := body.(type) {
+	case *dnsmessage.AResource:
+		return netip.AddrFrom4(r.A).String()
+	case *dnsmessage.AAAAResource:
+		return netip.AddrFrom16(r.AAAA).String()
+	case *dnsmessage.CNAMEResource:
+		return r.CNAME.String()
+	case *dnsmessage.NSResource:
+		return r.NS.String()
+	case *dnsmessage.PTRResource:
+		return r.PTR.String()
+	case *dnsmessage.MXResource:
+		return fmt.Sprintf("%d %s", r.Pref, r.MX)
+	case *dnsmessage.SRVResource:
+		return fmt.Sprintf("%d %d %d %s", r.Priority, r.Weight, r.Port, r.Target)
+	case *dnsmessage.SOAResource:
+		return fmt.Sprintf("%s %s %d %d %d %d %d", r.NS, r.MBox, r.Serial, r.Refresh, r.Retry, r.Expire, r.MinTTL)
+	case *dnsmessage.TXTResource:
+		quoted := make([]string, len(r.TXT))
+		for i, s := range r.TXT {
+			quoted[i] = fmt.Sprintf("%q", s)
+		}
+		return strings.Join(quoted, " ")
+	case *dnsmessage.HTTPSResource:
+		return svcb(&r.SVCBResource)
+	case *dnsmessage.SVCBResource:
+		return svcb(r)
+	case *dnsmessage.UnknownResource:
+		return hex.EncodeToString(r.Data)
+	}
+	return ""
+}
+
+func svcb(r *dnsmessage.SVCBResource) string {
+	parts := []string{fmt.Sprint(r.Priority), r.Target.String()}
+	for _, p := range r.Params {
+		parts = append(parts, p.Key.String()+"="+hex.EncodeToString(p.Value))
+	}
+	return strings.Join(parts, " ")
+}
+
+func isDoH(contentType string) bool {
+	mt, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mt == ContentType
+}
+
+// Decoder implements logger.BodyDecoder for DoH POST bodies and responses.
+type Decoder struct{}
+
+func (Decoder) DecodeBody(meta logger.BodyMeta, body []byte) *logger.Decoded {
+	if !isDoH(meta.ContentType) {
+		return nil
+	}
+
+	d := &logger.Decoded{Format: "dns"}
+	msg, err := Parse(body)
+	if err != nil {
+		d.Error = err.Error()
+		return d
+	}
+	d.Value = msg
+	return d
+}
+
+// Detector implements logger.ProtocolDetector for DoH GET requests, whose
+// query travels base64url-encoded in the dns parameter rather than the body.
+type Detector struct{}
+
+func (Detector) DetectProtocol(req *http.Request, res *http.Response) *logger.Protocol {
+	if req.Method != http.MethodGet || res != nil {
+		return nil
+	}
+	param := req.URL.Query().Get("dns")
+	if param == "" {
+		return nil
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(param, "="))
+	if err != nil {
+		return nil
+	}
+	msg, err := Parse(raw)
+	if err != nil {
+		return nil
+	}
+	return &logger.Protocol{Name: "doh", Fields: msg}
+}
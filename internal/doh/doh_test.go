@@ -0,0 +1,67 @@
+package doh
+
+import (
+	"encoding/base64"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/standrze/rogue/internal/logger"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func pack(t *testing.T, m dnsmessage.Message) []byte {
+	t.Helper()
+	b, err := m.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestDecodeResponse(t *testing.T) {
+	name := dnsmessage.MustNewName("example.com.")
+	body := pack(t, dnsmessage.Message{
+		Header: dnsmessage.Header{ID: 7, Response: true, RecursionDesired: true, RecursionAvailable: true},
+		Questions: []dnsmessage.Question{
+			{Name: name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET},
+		},
+		Answers: []dnsmessage.Resource{{
+			Header: dnsmessage.ResourceHeader{Name: name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 300},
+			Body:   &dnsmessage.AResource{A: [4]byte{93, 184, 216, 34}},
+		}},
+	})
+
+	d := Decoder{}.DecodeBody(logger.BodyMeta{Response: true, ContentType: ContentType}, body)
+	if d == nil || d.Error != "" {
+		t.Fatalf("Expected decoded message, got %+v", d)
+	}
+	msg := d.Value.(*Message)
+	if msg.RCode != "Success" || len(msg.Flags) != 2 {
+		t.Errorf("Unexpected header %+v", msg)
+	}
+	if len(msg.Questions) != 1 || msg.Questions[0] != (Question{Name: "example.com.", Type: "A"}) {
+		t.Errorf("Unexpected questions %+v", msg.Questions)
+	}
+	want := Record{Name: "example.com.", Type: "A", TTL: 300, Data: "93.184.216.34"}
+	if len(msg.Answers) != 1 || msg.Answers[0] != want {
+		t.Errorf("Unexpected answers %+v", msg.Answers)
+	}
+}
+
+func TestDetectGET(t *testing.T) {
+	query := pack(t, dnsmessage.Message{
+		Questions: []dnsmessage.Question{
+			{Name: dnsmessage.MustNewName("rogue.test."), Type: dnsmessage.TypeAAAA, Class: dnsmessage.ClassINET},
+		},
+	})
+	req := httptest.NewRequest("GET", "https://dns.example/dns-query?dns="+base64.RawURLEncoding.EncodeToString(query), nil)
+
+	p := Detector{}.DetectProtocol(req, nil)
+	if p == nil {
+		t.Fatal("Expected DoH GET to be detected")
+	}
+	msg := p.Fields.(*Message)
+	if len(msg.Questions) != 1 || msg.Questions[0].Type != "AAAA" {
+		t.Errorf("Unexpected questions %+v", msg.Questions)
+	}
+}
@@ -0,0 +1,154 @@
+// Package env keeps environments: named sets of variables, such as the
+// base URL and credentials of a staging or production deployment, that
+// rules and replayed requests refer to as {{name}}. Switching environments
+// points the same rules and captured requests at another target.
+package env
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// Vars are the variables of an environment by name.
+type Vars map[string]string
+
+// File is the on-disk layout of an environments file. Active is the
+// environment chosen with rogue env use.
+type File struct {
+	Active       string          `yaml:"active,omitempty"`
+	Environments map[string]Vars `yaml:"environments"`
+}
+
+// Load reads an environments file. A missing file holds no environments.
+func Load(name string) (*File, error) {
+	f := &File{Environments: map[string]Vars{}}
+	data, err := os.ReadFile(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return f, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	d := yaml.NewDecoder(bytes.NewReader(data))
+	d.KnownFields(true)
+	if err := d.Decode(f); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if f.Environments == nil {
+		f.Environments = map[string]Vars{}
+	}
+	for env, vars := range f.Environments {
+		for v := range vars {
+			if !ValidName(v) {
+				return nil, fmt.Errorf("%s: environment %q: invalid variable name %q", name, env, v)
+			}
+		}
+	}
+	return f, nil
+}
+
+// Save writes f to name. Variables often hold credentials, so the file is
+// readable by its owner only.
+func (f *File) Save(name string) error {
+	data, err := yaml.Marshal(f)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(name, data, 0o600)
+}
+
+// Names returns the names of the environments in order.
+func (f *File) Names() []string {
+	return slices.Sorted(maps.Keys(f.Environments))
+}
+
+// Select returns the variables of the environment name, or of the active
+// one when name is empty. It returns nil when neither is set.
+func (f *File) Select(name string) (string, Vars, error) {
+	if name == "" {
+		name = f.Active
+	}
+	if name == "" {
+		return "", nil, nil
+	}
+	vars, ok := f.Environments[name]
+	if !ok {
+		if len(f.Environments) == 0 {
+			return "", nil, fmt.Errorf("no environment %q; create it with rogue env set %s name=value", name, name)
+		}
+		return "", nil, fmt.Errorf("no environment %q (have %s)", name, strings.Join(f.Names(), ", "))
+	}
+	if vars == nil {
+		vars = Vars{}
+	}
+	return name, vars, nil
+}
+
+var (
+	validName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+	reference = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_.-]*)\s*\}\}`)
+)
+
+// ValidName reports whether name can be used as a variable.
+func ValidName(name string) bool {
+	return validName.MatchString(name)
+}
+
+// With returns a copy of v with the variables of over added, replacing
+// those of the same name.
+func (v Vars) With(over Vars) Vars {
+	out := maps.Clone(v)
+	if out == nil {
+		out = Vars{}
+	}
+	maps.Copy(out, over)
+	return out
+}
+
+// Expand replaces the references to variables in s, written {{name}} with
+// optional spaces inside the braces. Referring to a variable v does not
+// define is an error, so misspelt names are not sent on as they are.
+func (v Vars) Expand(s string) (string, error) {
+	if !strings.Contains(s, "{{") {
+		return s, nil
+	}
+	var missing []string
+	out := reference.ReplaceAllStringFunc(s, func(ref string) string {
+		name := reference.FindStringSubmatch(ref)[1]
+		value, ok := v[name]
+		if !ok {
+			if !slices.Contains(missing, name) {
+				missing = append(missing, name)
+			}
+			return ref
+		}
+		return value
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("undefined variable %s", strings.Join(missing, ", "))
+	}
+	return out, nil
+}
+
+// ParseAssignments parses name=value pairs, as given on the command line.
+func ParseAssignments(args []string) (Vars, error) {
+	vars := Vars{}
+	for _, a := range args {
+		name, value, ok := strings.Cut(a, "=")
+		if !ok || !ValidName(name) {
+			return nil, fmt.Errorf("invalid variable %q (want name=value)", a)
+		}
+		vars[name] = value
+	}
+	return vars, nil
+}
@@ -0,0 +1,77 @@
+package env
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExpand(t *testing.T) {
+	vars := Vars{"base_url": "https://staging.test", "token": "abc"}
+	got, err := vars.Expand("GET {{base_url}}/me HTTP/1.1\r\nAuthorization: Bearer {{ token }}\r\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "GET https://staging.test/me HTTP/1.1\r\nAuthorization: Bearer abc\r\n"; got != want {
+		t.Errorf("Expand = %q, want %q", got, want)
+	}
+	if _, err := vars.Expand("{{tokn}} {{tokn}} {{user}}"); err == nil || err.Error() != "undefined variable tokn, user" {
+		t.Errorf("err = %v", err)
+	}
+	if got, _ := vars.Expand("{x} {{ }} {{1a}}"); got != "{x} {{ }} {{1a}}" {
+		t.Errorf("non-references expanded: %q", got)
+	}
+	if got, _ := vars.With(Vars{"token": "xyz"}).Expand("{{token}}"); got != "xyz" || vars["token"] != "abc" {
+		t.Errorf("With = %q, original %q", got, vars["token"])
+	}
+}
+
+func TestFile(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "environments.yaml")
+	f, err := Load(name)
+	if err != nil || len(f.Environments) != 0 {
+		t.Fatalf("Load of a missing file = %+v, %v", f, err)
+	}
+	if _, vars, err := f.Select(""); vars != nil || err != nil {
+		t.Errorf("Select with none active = %v, %v", vars, err)
+	}
+
+	f.Environments["staging"] = Vars{"base_url": "https://staging.test"}
+	f.Environments["prod"] = Vars{"base_url": "https://prod.test"}
+	f.Active = "staging"
+	if err := f.Save(name); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := os.Stat(name); info.Mode().Perm() != 0o600 {
+		t.Errorf("mode = %v", info.Mode())
+	}
+
+	f, err = Load(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env, vars, _ := f.Select(""); env != "staging" || vars["base_url"] != "https://staging.test" {
+		t.Errorf("active = %s %v", env, vars)
+	}
+	if _, vars, _ := f.Select("prod"); vars["base_url"] != "https://prod.test" {
+		t.Errorf("prod = %v", vars)
+	}
+	if _, _, err := f.Select("dev"); err == nil || err.Error() != `no environment "dev" (have prod, staging)` {
+		t.Errorf("err = %v", err)
+	}
+
+	os.WriteFile(name, []byte("environments:\n  a:\n    bad name: x\n"), 0o600)
+	if _, err := Load(name); err == nil {
+		t.Error("invalid variable name loaded")
+	}
+}
+
+func TestParseAssignments(t *testing.T) {
+	vars, err := ParseAssignments([]string{"base_url=https://a.test/?x=1", "empty="})
+	if err != nil || vars["base_url"] != "https://a.test/?x=1" || vars["empty"] != "" {
+		t.Errorf("ParseAssignments = %v, %v", vars, err)
+	}
+	if _, err := ParseAssignments([]string{"novalue"}); err == nil {
+		t.Error("assignment without = accepted")
+	}
+}
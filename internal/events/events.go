@@ -0,0 +1,296 @@
+// Package events streams one structured event per completed exchange over
+// WebSocket, so dashboards, alerting scripts and test harnesses can react
+// to traffic as it happens without parsing session entries themselves.
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/standrze/rogue/internal/filter"
+	"github.com/standrze/rogue/internal/logger"
+	"github.com/standrze/rogue/internal/viewer"
+	"golang.org/x/net/websocket"
+)
+
+// subscriberQueue bounds the events waiting for a slow client. Events
+// beyond it are dropped and the client is told how many it missed.
+const subscriberQueue = 256
+
+// maxPending bounds the requests held while waiting for their responses;
+// the oldest are forgotten first.
+const maxPending = 4096
+
+// Event describes one exchange, sent once its response is logged.
+type Event struct {
+	ID         string    `json:"id"`
+	Time       time.Time `json:"time"`
+	Method     string    `json:"method"`
+	URL        string    `json:"url"`
+	Host       string    `json:"host"`
+	Status     int       `json:"status"`
+	DurationMS int64     `json:"duration_ms"`
+	// RequestSize and ResponseSize are body sizes in bytes.
+	RequestSize  int64  `json:"request_size"`
+	ResponseSize int64  `json:"response_size"`
+	ContentType  string `json:"content_type,omitempty"`
+	ClientIP     string `json:"client_ip,omitempty"`
+	RedirectOf   string `json:"redirect_of,omitempty"`
+}
+
+// Broker is a log sink that pairs requests with their responses and sends
+// an Event for each exchange to the clients connected to it.
+type Broker struct {
+	mu      sync.Mutex
+	pending map[string]*logger.RequestLog
+	order   []string
+	subs    map[*subscriber]struct{}
+	closed  bool
+	saved   filter.Saved
+}
+
+type subscriber struct {
+	filter Filter
+	events chan *Event
+	// missed counts events dropped since the last one delivered.
+	missed int
+}
+
+// Filter selects the events a client receives: the session viewer's
+// search, method, status and expression filters, and a glob on the host.
+type Filter struct {
+	viewer.Filter
+	Host string
+}
+
+// NewBroker returns an empty broker. Filter expressions may refer to the
+// saved filters as @name.
+func NewBroker(saved filter.Saved) *Broker {
+	return &Broker{pending: map[string]*logger.RequestLog{}, subs: map[*subscriber]struct{}{}, saved: saved}
+}
+
+// Send implements logger.Sink.
+func (b *Broker) Send(entries [][]byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, raw := range entries {
+		var e logger.Entry
+		if err := json.Unmarshal(raw, &e); err != nil {
+			continue
+		}
+		switch e.Type {
+		case "request":
+			var req logger.RequestLog
+			if json.Unmarshal(e.Data, &req) == nil && req.RequestID != "" {
+				b.hold(&req)
+			}
+		case "response":
+			var res logger.ResponseLog
+			if json.Unmarshal(e.Data, &res) != nil {
+				continue
+			}
+			req, ok := b.pending[res.RequestID]
+			if !ok {
+				continue
+			}
+			delete(b.pending, res.RequestID)
+			b.publish(&logger.Flow{ID: res.RequestID, Request: req, Response: &res})
+		}
+	}
+	return nil
+}
+
+// hold keeps req until its response arrives.
+func (b *Broker) hold(req *logger.RequestLog) {
+	b.pending[req.RequestID] = req
+	b.order = append(b.order, req.RequestID)
+	for len(b.pending) > maxPending || len(b.order) > 2*maxPending {
+		delete(b.pending, b.order[0])
+		b.order = b.order[1:]
+	}
+}
+
+func (b *Broker) publish(f *logger.Flow) {
+	if len(b.subs) == 0 {
+		return
+	}
+	ev := newEvent(f)
+	for s := range b.subs {
+		if !s.filter.Match(f) {
+			continue
+		}
+		select {
+		case s.events <- ev:
+		default:
+			s.missed++
+		}
+	}
+}
+
+func newEvent(f *logger.Flow) *Event {
+	req, res := f.Request, f.Response
+	ev := &Event{
+		ID:           f.ID,
+		Time:         req.Timestamp,
+		Method:       req.Method,
+		URL:          req.URL,
+		Status:       res.StatusCode,
+		DurationMS:   res.Timestamp.Sub(req.Timestamp).Milliseconds(),
+		RequestSize:  req.BodySize,
+		ResponseSize: res.BodySize,
+		ClientIP:     req.ClientIP,
+		RedirectOf:   req.RedirectOf,
+	}
+	if u, err := url.Parse(req.URL); err == nil {
+		ev.Host = u.Hostname()
+	}
+	for k, v := range res.Headers {
+		if strings.EqualFold(k, "Content-Type") {
+			ev.ContentType = v
+		}
+	}
+	return ev
+}
+
+// Match reports whether f passes the filter.
+func (flt Filter) Match(f *logger.Flow) bool {
+	if flt.Host != "" {
+		u, err := url.Parse(f.Request.URL)
+		if err != nil {
+			return false
+		}
+		if ok, _ := path.Match(strings.ToLower(flt.Host), strings.ToLower(u.Hostname())); !ok {
+			return false
+		}
+	}
+	return flt.Filter.Match(f)
+}
+
+// Close implements logger.Sink, ending every stream.
+func (b *Broker) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for s := range b.subs {
+		close(s.events)
+		delete(b.subs, s)
+	}
+	return nil
+}
+
+func (b *Broker) subscribe(flt Filter) *subscriber {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := &subscriber{filter: flt, events: make(chan *Event, subscriberQueue)}
+	if b.closed {
+		close(s.events)
+		return s
+	}
+	b.subs[s] = struct{}{}
+	return s
+}
+
+func (b *Broker) unsubscribe(s *subscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subs[s]; ok {
+		delete(b.subs, s)
+		close(s.events)
+	}
+}
+
+// takeMissed returns and resets the drop count of s.
+func (b *Broker) takeMissed(s *subscriber) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := s.missed
+	s.missed = 0
+	return n
+}
+
+// ServeHTTP upgrades the request to a WebSocket and sends each matching
+// Event as a JSON text message. The q, method, status, where and host query
+// parameters filter the stream. A {"missed": N} message reports events
+// dropped because the client read too slowly. Messages from the client are
+// ignored. Browsers may only connect from pages served by the same origin,
+// so other sites cannot read the traffic; clients sending no Origin, such as
+// scripts, are accepted.
+func (b *Broker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	vf, err := viewer.FilterFromQuery(q, b.saved)
+	if err == nil && vf.Status != "" {
+		_, err = viewer.ParseFilter([]string{"status=" + vf.Status}, nil)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	flt := Filter{Filter: vf, Host: q.Get("host")}
+	if _, err := path.Match(flt.Host, ""); err != nil {
+		http.Error(w, "invalid host pattern", http.StatusBadRequest)
+		return
+	}
+	websocket.Server{
+		Handshake: checkOrigin,
+		Handler:   func(ws *websocket.Conn) { b.stream(ws, flt) },
+	}.ServeHTTP(w, r)
+}
+
+var errForeignOrigin = errors.New("cross-origin WebSocket request")
+
+// checkOrigin accepts a handshake without an Origin or from the origin the
+// request was sent to.
+func checkOrigin(config *websocket.Config, r *http.Request) error {
+	origin, err := websocket.Origin(config, r)
+	if err != nil {
+		return err
+	}
+	if origin != nil {
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		if origin.Scheme != scheme || !strings.EqualFold(origin.Host, r.Host) {
+			return errForeignOrigin
+		}
+	}
+	config.Origin = origin
+	return nil
+}
+
+func (b *Broker) stream(ws *websocket.Conn, flt Filter) {
+	defer ws.Close()
+	s := b.subscribe(flt)
+	defer b.unsubscribe(s)
+
+	gone := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, ws)
+		close(gone)
+	}()
+	for {
+		select {
+		case <-gone:
+			return
+		case ev, ok := <-s.events:
+			if !ok {
+				return
+			}
+			if n := b.takeMissed(s); n > 0 {
+				if websocket.JSON.Send(ws, map[string]int{"missed": n}) != nil {
+					return
+				}
+			}
+			if websocket.JSON.Send(ws, ev) != nil {
+				return
+			}
+		}
+	}
+}
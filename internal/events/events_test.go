@@ -0,0 +1,134 @@
+package events
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/standrze/rogue/internal/logger"
+	"golang.org/x/net/websocket"
+)
+
+func entry(t *testing.T, typ string, v any) []byte {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.Marshal(logger.Entry{Type: typ, Data: data})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func exchange(t *testing.T, id, method, url string, status int) [][]byte {
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	return [][]byte{
+		entry(t, "request", logger.RequestLog{Timestamp: start, Method: method, URL: url, RequestID: id, BodySize: 3}),
+		entry(t, "response", logger.ResponseLog{
+			Timestamp:  start.Add(25 * time.Millisecond),
+			StatusCode: status,
+			Headers:    map[string]string{"Content-Type": "application/json"},
+			BodySize:   7,
+			RequestID:  id,
+		}),
+	}
+}
+
+func TestBroker(t *testing.T) {
+	b := NewBroker(nil)
+	srv := httptest.NewServer(b)
+	defer srv.Close()
+	defer b.Close()
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/events?host=*.example.com&status=5xx"
+	ws, err := websocket.Dial(url, "", srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	// The subscription is made once the handshake is served, so resend
+	// until the event arrives.
+	got := make(chan Event, 1)
+	go func() {
+		var ev Event
+		if websocket.JSON.Receive(ws, &ev) == nil {
+			got <- ev
+		}
+	}()
+	var ev Event
+	for i := 0; ; i++ {
+		if i == 100 {
+			t.Fatal("no event")
+		}
+		b.Send(exchange(t, "skip-host", "GET", "http://other.test/", 500))
+		b.Send(exchange(t, "skip-status", "GET", "http://api.example.com/", 200))
+		b.Send(exchange(t, "want", "POST", "http://api.example.com/items", 503))
+		select {
+		case ev = <-got:
+		case <-time.After(20 * time.Millisecond):
+			continue
+		}
+		break
+	}
+
+	if ev.ID != "want" || ev.Method != "POST" || ev.Host != "api.example.com" || ev.Status != 503 {
+		t.Errorf("event = %+v", ev)
+	}
+	if ev.DurationMS != 25 || ev.RequestSize != 3 || ev.ResponseSize != 7 || ev.ContentType != "application/json" {
+		t.Errorf("event = %+v", ev)
+	}
+}
+
+func TestBrokerBadFilter(t *testing.T) {
+	b := NewBroker(nil)
+	rec := httptest.NewRecorder()
+	b.ServeHTTP(rec, httptest.NewRequest("GET", "/events?status=abc", nil))
+	if rec.Code != 400 {
+		t.Errorf("status = %d, want 400", rec.Code)
+	}
+}
+
+func TestBrokerPendingBounded(t *testing.T) {
+	b := NewBroker(nil)
+	for i := range maxPending + 10 {
+		b.Send([][]byte{entry(t, "request", logger.RequestLog{Method: "GET", URL: "http://a.test/", RequestID: string(rune('a'+i%26)) + strings.Repeat("x", i)})})
+	}
+	if len(b.pending) > maxPending {
+		t.Errorf("pending = %d, want at most %d", len(b.pending), maxPending)
+	}
+}
+
+func TestBrokerOrigin(t *testing.T) {
+	b := NewBroker(nil)
+	srv := httptest.NewServer(b)
+	defer srv.Close()
+	defer b.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/events"
+
+	// A page on another site must not read the traffic.
+	if ws, err := websocket.Dial(url, "", "http://evil.example"); err == nil {
+		ws.Close()
+		t.Error("upgraded a request from a foreign origin")
+	}
+
+	// Clients that send no Origin are not browsers and are let in.
+	req, _ := http.NewRequest("GET", srv.URL+"/events", nil)
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Errorf("status without an Origin = %d, want 101", resp.StatusCode)
+	}
+}
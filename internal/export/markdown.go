@@ -0,0 +1,47 @@
+package export
+
+import (
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/standrze/rogue/internal/logger"
+)
+
+// excerptSize caps how much of a body is quoted in a write-up.
+const excerptSize = 2048
+
+// Annotated returns the flows that were starred or commented on.
+func Annotated(flows []*logger.Flow) []*logger.Flow {
+	var out []*logger.Flow
+	for _, f := range flows {
+		if len(f.Annotations) > 0 {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// Tagged returns the flows carrying any of tags, compared without regard to
+// case.
+func Tagged(flows []*logger.Flow, tags []string) []*logger.Flow {
+	var out []*logger.Flow
+	for _, f := range flows {
+		if slices.ContainsFunc(f.Tags(), func(t string) bool {
+			return slices.ContainsFunc(tags, func(want string) bool { return strings.EqualFold(t, want) })
+		}) {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// Markdown writes an investigation write-up for flows, suitable as the start
+// of a bug report or finding.
+func Markdown(w io.Writer, title string, flows []*logger.Flow) error {
+	t, err := LoadTemplate(FormatMarkdown, nil, nil)
+	if err != nil {
+		return err
+	}
+	return t.Execute(w, title, flows)
+}
@@ -0,0 +1,60 @@
+package export
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/standrze/rogue/internal/logger"
+)
+
+func TestAnnotated(t *testing.T) {
+	flows := append(testFlows(),
+		&logger.Flow{ID: "plain", Request: &logger.RequestLog{Method: "GET", URL: "https://example.com/"}},
+		&logger.Flow{ID: "noted", Annotations: []logger.Annotation{{Comment: "look here"}}},
+	)
+	got := Annotated(flows)
+	if len(got) != 2 || got[0].ID != "abc" || got[1].ID != "noted" {
+		t.Errorf("Annotated = %v", got)
+	}
+	if got := Annotated(flows[1:2]); got != nil {
+		t.Errorf("Annotated(unannotated) = %v", got)
+	}
+}
+
+func TestMarkdownWriteUp(t *testing.T) {
+	ts := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	flows := append(testFlows(), &logger.Flow{
+		ID:      "big",
+		Request: &logger.RequestLog{Timestamp: ts, Method: "GET", URL: "https://api.example.com/export"},
+		Response: &logger.ResponseLog{
+			Timestamp: ts, StatusCode: 200, Headers: map[string]string{"Content-Type": "text/plain"},
+			Body: strings.Repeat("x", excerptSize+10),
+		},
+		Annotations: []logger.Annotation{{Timestamp: ts, Comment: "too much data"}},
+	}, &logger.Flow{
+		ID:          "pending",
+		Request:     &logger.RequestLog{Timestamp: ts, Method: "DELETE", URL: "https://api.example.com/users/1"},
+		Annotations: []logger.Annotation{{Timestamp: ts, Starred: true}},
+	})
+
+	var b strings.Builder
+	if err := Markdown(&b, "Login findings", flows); err != nil {
+		t.Fatal(err)
+	}
+	md := b.String()
+	for _, want := range []string{
+		"# Login findings\n\n3 flagged exchange(s).\n",
+		"### Response\n\n```http\nHTTP 401\n\ndenied <script>\n```\n",
+		"## 2. GET https://api.example.com/export\n",
+		"\n" + strings.Repeat("x", excerptSize) + "\n[… body truncated]\n```\n",
+		"## 3. DELETE https://api.example.com/users/1 ★\n\n- **Request ID:** `pending`\n- **Sent:** 2025-01-02T03:04:05Z\n\n### Request\n",
+	} {
+		if !strings.Contains(md, want) {
+			t.Errorf("write-up lacks %q:\n%s", want, md)
+		}
+	}
+	if strings.Count(md, "### Response") != 2 || strings.Count(md, "### Notes") != 2 {
+		t.Errorf("unexpected sections:\n%s", md)
+	}
+}
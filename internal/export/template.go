@@ -0,0 +1,231 @@
+package export
+
+import (
+	"embed"
+	"encoding/base64"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/standrze/rogue/internal/codec"
+	"github.com/standrze/rogue/internal/display"
+	"github.com/standrze/rogue/internal/logger"
+)
+
+//go:embed templates/*.tmpl
+var templateFS embed.FS
+
+// Built-in template names.
+const (
+	FormatMarkdown = "markdown"
+	FormatHTML     = "html"
+)
+
+// Report is the data passed to export templates.
+type Report struct {
+	Title     string
+	Generated time.Time
+	Flows     []Flow
+}
+
+// Flow is one exported exchange. N numbers flows from 1.
+type Flow struct {
+	N       int
+	ID      string
+	Heading string
+	Starred bool
+	Tags    []string
+	// Notes are the annotations that carry a comment.
+	Notes    []logger.Annotation
+	Request  *Message
+	Response *Message
+}
+
+// Message is a request or response. StartLine is "METHOD URL" or
+// "HTTP status". Body is pretty-printed or decoded where possible and cut to
+// an excerpt, in which case Truncated is set. Image bodies are described in
+// Body and carried whole in Image as a data URI.
+type Message struct {
+	StartLine string
+	Method    string
+	URL       string
+	Status    int
+	Timestamp time.Time
+	Headers   []Header
+	// Query holds the decoded query parameters of a request URL.
+	Query     []Header
+	Body      string
+	Truncated bool
+	Image     htmltemplate.URL
+}
+
+// Header is a header field; Headers are sorted by name.
+type Header struct {
+	Name  string
+	Value string
+}
+
+// NewReport builds the template data for flows.
+func NewReport(title string, flows []*logger.Flow) *Report {
+	r := &Report{Title: title, Generated: time.Now()}
+	for i, f := range flows {
+		out := Flow{N: i + 1, ID: f.ID, Heading: f.ID, Starred: f.Starred(), Tags: f.Tags()}
+		for _, a := range f.Annotations {
+			if a.Comment != "" {
+				out.Notes = append(out.Notes, a)
+			}
+		}
+		if req := f.Request; req != nil {
+			out.Heading = req.Method + " " + req.URL
+			out.Request = newMessage(req.Method+" "+req.URL, req.Timestamp, req.Headers,
+				req.Body, req.BodyEncoding, req.BodySize, req.Decoded, req.Truncated)
+			out.Request.Method, out.Request.URL = req.Method, req.URL
+			out.Request.Query = queryParams(req.URL)
+		}
+		if res := f.Response; res != nil {
+			out.Response = newMessage(fmt.Sprintf("HTTP %d", res.StatusCode), res.Timestamp, res.Headers,
+				res.Body, res.BodyEncoding, res.BodySize, res.Decoded, res.Truncated)
+			out.Response.Status = res.StatusCode
+		}
+		r.Flows = append(r.Flows, out)
+	}
+	return r
+}
+
+func newMessage(startLine string, ts time.Time, headers map[string]string, raw, encoding string, size int64, decoded *logger.Decoded, truncated bool) *Message {
+	m := &Message{StartLine: startLine, Timestamp: ts, Truncated: truncated}
+	for k, v := range headers {
+		m.Headers = append(m.Headers, Header{Name: k, Value: v})
+	}
+	sort.Slice(m.Headers, func(i, j int) bool { return m.Headers[i].Name < m.Headers[j].Name })
+
+	contentType := headers["Content-Type"]
+	if uri, ok := codec.ImageURI(contentType, raw, encoding); ok && !truncated {
+		if size == 0 {
+			size = int64(len(raw))
+			if encoding == "base64" {
+				b, _ := base64.StdEncoding.DecodeString(raw)
+				size = int64(len(b))
+			}
+		}
+		m.Image = htmltemplate.URL(uri)
+		m.Body = fmt.Sprintf("[%s image, %d bytes]", contentType, size)
+		return m
+	}
+	body := codec.Render(contentType, raw, decoded)
+	if len(body) > excerptSize {
+		body = body[:excerptSize]
+		m.Truncated = true
+	}
+	m.Body = body
+	return m
+}
+
+// queryParams decodes the query string of rawURL.
+func queryParams(rawURL string) []Header {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil
+	}
+	fields, err := codec.Fields(u.RawQuery)
+	if err != nil {
+		return nil
+	}
+	var out []Header
+	for _, f := range fields {
+		out = append(out, Header{Name: f.Name, Value: f.Value})
+	}
+	return out
+}
+
+// funcs returns the template functions, formatting times and numbers
+// with f.
+func funcs(f *display.Formatter) map[string]any {
+	m := map[string]any{
+		"rfc3339":   func(t time.Time) string { return f.Time(t, time.RFC3339) },
+		"hasSuffix": strings.HasSuffix,
+		"lower":     strings.ToLower,
+		"upper":     strings.ToUpper,
+		"join":      strings.Join,
+	}
+	for k, v := range f.Funcs() {
+		m[k] = v
+	}
+	return m
+}
+
+// Template renders a Report.
+type Template struct {
+	execute func(io.Writer, any) error
+}
+
+// LoadTemplate returns the template called name: one of named, which maps
+// names to files, a built-in format, or otherwise a template file path.
+// Files ending in .html or .htm are parsed with html/template so session
+// content is escaped; others with text/template. Times and numbers are
+// formatted with f, or display.Default if nil.
+func LoadTemplate(name string, named map[string]string, f *display.Formatter) (*Template, error) {
+	if f == nil {
+		f = display.Default
+	}
+	// Config keys are lower-cased when loaded.
+	for _, n := range []string{name, strings.ToLower(name)} {
+		if path, ok := named[n]; ok {
+			return parseTemplate(path, f)
+		}
+	}
+	switch name {
+	case FormatMarkdown:
+		return parseBuiltin("markdown.tmpl", false, f)
+	case FormatHTML:
+		return parseBuiltin("html.tmpl", true, f)
+	}
+	if _, err := os.Stat(name); err != nil {
+		return nil, fmt.Errorf("unknown export template %q", name)
+	}
+	return parseTemplate(name, f)
+}
+
+func parseBuiltin(file string, html bool, f *display.Formatter) (*Template, error) {
+	src, err := templateFS.ReadFile("templates/" + file)
+	if err != nil {
+		return nil, err
+	}
+	return newTemplate(file, string(src), html, f)
+}
+
+func parseTemplate(path string, f *display.Formatter) (*Template, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	ext := strings.ToLower(filepath.Ext(path))
+	return newTemplate(filepath.Base(path), string(src), ext == ".html" || ext == ".htm", f)
+}
+
+func newTemplate(name, src string, html bool, f *display.Formatter) (*Template, error) {
+	if html {
+		t, err := htmltemplate.New(name).Funcs(funcs(f)).Parse(src)
+		if err != nil {
+			return nil, err
+		}
+		return &Template{execute: t.Execute}, nil
+	}
+	t, err := texttemplate.New(name).Funcs(funcs(f)).Parse(src)
+	if err != nil {
+		return nil, err
+	}
+	return &Template{execute: t.Execute}, nil
+}
+
+// Execute writes the report for flows.
+func (t *Template) Execute(w io.Writer, title string, flows []*logger.Flow) error {
+	return t.execute(w, NewReport(title, flows))
+}
@@ -0,0 +1,166 @@
+package export
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/standrze/rogue/internal/display"
+	"github.com/standrze/rogue/internal/logger"
+)
+
+func testFlows() []*logger.Flow {
+	ts := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	return []*logger.Flow{{
+		ID: "abc",
+		Request: &logger.RequestLog{
+			Timestamp: ts, Method: "POST", URL: "https://api.example.com/login",
+			Headers: map[string]string{"Content-Type": "application/json"}, Body: `{"user":"bob"}`,
+		},
+		Response:    &logger.ResponseLog{Timestamp: ts, StatusCode: 401, Body: "denied <script>"},
+		Annotations: []logger.Annotation{{Timestamp: ts, Starred: true}, {Timestamp: ts, Comment: "no lockout"}},
+	}}
+}
+
+func TestMarkdown(t *testing.T) {
+	var b strings.Builder
+	if err := Markdown(&b, "Findings", testFlows()); err != nil {
+		t.Fatal(err)
+	}
+	want := "## 1. POST https://api.example.com/login ★\n\n" +
+		"- **Request ID:** `abc`\n- **Sent:** 2025-01-02T03:04:05Z\n- **Status:** 401\n\n" +
+		"### Notes\n\n- _2025-01-02T03:04:05Z_ — no lockout\n\n" +
+		"### Request\n\n```http\nPOST https://api.example.com/login\nContent-Type: application/json\n\n{\n  \"user\": \"bob\"\n}\n```\n"
+	if !strings.Contains(b.String(), want) {
+		t.Errorf("unexpected write-up:\n%s", b.String())
+	}
+}
+
+func TestTags(t *testing.T) {
+	flows := testFlows()
+	flows[0].Annotations = append(flows[0].Annotations,
+		logger.Annotation{Tags: []string{"auth", "brute-force"}},
+		logger.Annotation{Tags: []string{"auth"}})
+	other := &logger.Flow{ID: "def", Annotations: []logger.Annotation{{Tags: []string{"cors"}}}}
+	flows = append(flows, other)
+
+	if got := Tagged(flows, []string{"AUTH"}); len(got) != 1 || got[0].ID != "abc" {
+		t.Errorf("Tagged(auth) = %v", got)
+	}
+	if got := Tagged(flows, []string{"xss"}); len(got) != 0 {
+		t.Errorf("Tagged(xss) = %v", got)
+	}
+
+	var b strings.Builder
+	if err := Markdown(&b, "Findings", flows[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), "- **Request ID:** `abc`\n- **Tags:** auth, brute-force\n") {
+		t.Errorf("tags missing from write-up:\n%s", b.String())
+	}
+}
+
+func TestHTMLEscapesSessionContent(t *testing.T) {
+	tmpl, err := LoadTemplate(FormatHTML, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, "Findings", testFlows()); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(b.String(), "<script>") || !strings.Contains(b.String(), "&lt;script&gt;") {
+		t.Errorf("body not escaped:\n%s", b.String())
+	}
+}
+
+func TestNamedTemplate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "house.md.tmpl")
+	src := `{{range .Flows}}{{.N}}|{{.Request.Method}}|{{.Response.Status}}|{{range .Notes}}{{upper .Comment}}{{end}}{{end}}`
+	if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl, err := LoadTemplate("house", map[string]string{"house": path}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, "Findings", testFlows()); err != nil {
+		t.Fatal(err)
+	}
+	if got := b.String(); got != "1|POST|401|NO LOCKOUT" {
+		t.Errorf("got %q", got)
+	}
+
+	if _, err := LoadTemplate("missing", nil, nil); err == nil {
+		t.Error("expected an error for an unknown template")
+	}
+}
+
+func TestTemplateFormatting(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "times.tmpl")
+	src := `{{range .Flows}}{{rfc3339 .Request.Timestamp}} {{number 12345}}{{end}}`
+	if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := display.New("America/New_York", "fr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl, err := LoadTemplate(path, nil, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, "Findings", testFlows()); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := b.String(), "2025-01-01T22:04:05-05:00 12 345"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestBodyRendering(t *testing.T) {
+	ts := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	flows := []*logger.Flow{{
+		ID: "img",
+		Request: &logger.RequestLog{
+			Timestamp: ts, Method: "POST", URL: "https://shop.example.com/search?q=caf%C3%A9+au+lait&page=2",
+			Headers: map[string]string{"Content-Type": "application/x-www-form-urlencoded"}, Body: "name=J%C3%BCrgen&note=a+%26+b",
+		},
+		Response: &logger.ResponseLog{
+			Timestamp: ts, StatusCode: 200, Headers: map[string]string{"Content-Type": "image/png"},
+			Body: "iVBORw0KGgo=", BodyEncoding: "base64", BodySize: 8,
+		},
+	}}
+
+	var md strings.Builder
+	if err := Markdown(&md, "Findings", flows); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"\nname = Jürgen\nnote = a & b\n```\n",
+		"Query parameters:\n\n- `q` = `café au lait`\n- `page` = `2`\n",
+		"\n[image/png image, 8 bytes]\n```\n",
+	} {
+		if !strings.Contains(md.String(), want) {
+			t.Errorf("write-up lacks %q:\n%s", want, md.String())
+		}
+	}
+
+	tmpl, err := LoadTemplate(FormatHTML, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var html strings.Builder
+	if err := tmpl.Execute(&html, "Findings", flows); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(html.String(), `<img class="thumb" src="data:image/png;base64,iVBORw0KGgo="`) {
+		t.Errorf("no thumbnail in report:\n%s", html.String())
+	}
+}
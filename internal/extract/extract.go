@@ -0,0 +1,417 @@
+// Package extract writes the response bodies of a recorded session out as
+// files named by their SHA-256 or their URL, with a manifest tying each
+// file back to the flows that carried it. Identical bodies are stored once.
+package extract
+
+import (
+	"bytes"
+	"cmp"
+	"compress/flate"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/standrze/rogue/internal/artifact"
+	"github.com/standrze/rogue/internal/logger"
+)
+
+// ManifestFile is the name of the manifest in the output directory.
+const ManifestFile = "manifest.json"
+
+// extensions are the preferred file extensions of common types, where
+// mime.ExtensionsByType would pick an unusual one.
+var extensions = map[string]string{
+	"application/pdf":          ".pdf",
+	"application/zip":          ".zip",
+	"application/json":         ".json",
+	"application/octet-stream": ".bin",
+	"application/javascript":   ".js",
+	"text/javascript":          ".js",
+	"text/html":                ".html",
+	"text/plain":               ".txt",
+	"image/jpeg":               ".jpg",
+	"image/png":                ".png",
+	"image/gif":                ".gif",
+	"image/svg+xml":            ".svg",
+	"image/webp":               ".webp",
+}
+
+// Filter selects the bodies to extract. Empty fields match everything.
+type Filter struct {
+	// ContentTypes are globs matched against the media type, such as
+	// application/pdf or image/*.
+	ContentTypes []string
+	// Host is a glob matched against the request host.
+	Host string
+	// MinSize skips smaller bodies.
+	MinSize int64
+	// Truncated also extracts bodies cut short by logging.max_body_size.
+	Truncated bool
+}
+
+// Naming chooses the names of extracted files.
+type Naming int
+
+const (
+	// ByHash names files by the SHA-256 of their content.
+	ByHash Naming = iota
+	// ByURL names files after the last segment of the URL path they were
+	// first seen at, numbering names that are already taken.
+	ByURL
+)
+
+// ParseNaming parses "hash" or "url".
+func ParseNaming(s string) (Naming, error) {
+	switch s {
+	case "hash":
+		return ByHash, nil
+	case "url":
+		return ByURL, nil
+	}
+	return 0, fmt.Errorf("unknown naming %q (want hash or url)", s)
+}
+
+// Item is one extracted file.
+type Item struct {
+	SHA256      string `json:"sha256"`
+	File        string `json:"file"`
+	Size        int64  `json:"size"`
+	ContentType string `json:"content_type,omitempty"`
+	// URLs and Flows list where the body was seen, in the order found.
+	URLs  []string `json:"urls"`
+	Flows []string `json:"flows"`
+	// Truncated marks bodies that are only the logged prefix of the
+	// original.
+	Truncated bool `json:"truncated,omitempty"`
+	// Encoding is the Content-Encoding the body was left in when it could
+	// not be decoded.
+	Encoding string `json:"encoding,omitempty"`
+}
+
+// Manifest lists the files in an output directory.
+type Manifest struct {
+	Updated time.Time `json:"updated"`
+	Items   []*Item   `json:"items"`
+}
+
+// Result reports what an extraction did.
+type Result struct {
+	// Matched counts bodies that passed the filter, New the files written
+	// for bodies not already in the directory.
+	Matched, New int
+	// Skipped counts matching bodies that were truncated, not recorded or
+	// not decodable.
+	Skipped int
+}
+
+// body is a response body ready to be stored.
+type body struct {
+	flow        string
+	url         string
+	contentType string
+	data        []byte
+	truncated   bool
+	encoding    string
+}
+
+// Extract writes the bodies of flows matching f to dir, named as naming
+// says, and updates its manifest. Ranged downloads whose parts were all
+// recorded in full are written as the assembled resource.
+func Extract(flows []*logger.Flow, dir string, f Filter, naming Naming) (*Result, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	m, err := loadManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	byHash := map[string]*Item{}
+	taken := map[string]bool{ManifestFile: true}
+	for _, it := range m.Items {
+		byHash[it.SHA256] = it
+		taken[strings.ToLower(it.File)] = true
+	}
+
+	res := &Result{}
+	bodies, skipped := collect(flows, f)
+	res.Skipped = skipped
+	for _, b := range bodies {
+		if int64(len(b.data)) < f.MinSize {
+			continue
+		}
+		res.Matched++
+		sum := sha256.Sum256(b.data)
+		hash := hex.EncodeToString(sum[:])
+
+		it := byHash[hash]
+		if it == nil {
+			name := hash + extension(b.contentType, b.url)
+			if naming == ByURL {
+				name = urlName(b.url, extension(b.contentType, b.url), taken)
+			}
+			taken[strings.ToLower(name)] = true
+			it = &Item{
+				SHA256:      hash,
+				File:        name,
+				Size:        int64(len(b.data)),
+				ContentType: b.contentType,
+				Truncated:   b.truncated,
+				Encoding:    b.encoding,
+			}
+			if err := writeFile(filepath.Join(dir, it.File), b.data); err != nil {
+				return nil, err
+			}
+			byHash[hash] = it
+			m.Items = append(m.Items, it)
+			res.New++
+		}
+		if !slices.Contains(it.Flows, b.flow) {
+			it.Flows = append(it.Flows, b.flow)
+		}
+		if !slices.Contains(it.URLs, b.url) {
+			it.URLs = append(it.URLs, b.url)
+		}
+	}
+
+	m.Updated = time.Now()
+	return res, saveManifest(dir, m)
+}
+
+// collect gathers the bodies of flows that match f, assembling ranged
+// downloads.
+func collect(flows []*logger.Flow, f Filter) ([]body, int) {
+	var out []body
+	skipped := 0
+	var parts []*logger.Flow
+	for _, fl := range flows {
+		if fl.Request == nil || fl.Response == nil || !f.matches(fl) {
+			continue
+		}
+		if fl.Response.StatusCode == 206 {
+			parts = append(parts, fl)
+			continue
+		}
+		if fl.Response.StatusCode != 200 {
+			continue
+		}
+		b, ok := flowBody(fl, f.Truncated)
+		if !ok {
+			skipped++
+			continue
+		}
+		out = append(out, b)
+	}
+
+	byID := map[string]*logger.Flow{}
+	for _, fl := range parts {
+		byID[fl.ID] = fl
+	}
+	for _, a := range artifact.Stitch(parts) {
+		b, ok := assemble(a, byID)
+		if !ok {
+			skipped++
+			continue
+		}
+		out = append(out, b)
+	}
+	return out, skipped
+}
+
+func (f Filter) matches(fl *logger.Flow) bool {
+	if f.Host != "" {
+		u, err := url.Parse(fl.Request.URL)
+		if err != nil {
+			return false
+		}
+		if ok, _ := path.Match(strings.ToLower(f.Host), strings.ToLower(u.Hostname())); !ok {
+			return false
+		}
+	}
+	if len(f.ContentTypes) == 0 {
+		return true
+	}
+	mt := mediaType(fl.Response.Headers["Content-Type"])
+	for _, pattern := range f.ContentTypes {
+		if ok, _ := path.Match(strings.ToLower(pattern), mt); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func mediaType(contentType string) string {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(contentType))
+	}
+	return mt
+}
+
+// flowBody returns the response body of fl with its Content-Encoding
+// removed where possible.
+func flowBody(fl *logger.Flow, truncated bool) (body, bool) {
+	r := fl.Response
+	if r.BodySize == 0 || r.Body == "" || (r.Truncated && !truncated) {
+		return body{}, false
+	}
+	data, err := r.RawBody()
+	if err != nil {
+		return body{}, false
+	}
+	b := body{
+		flow:        fl.ID,
+		url:         fl.Request.URL,
+		contentType: mediaType(r.Headers["Content-Type"]),
+		data:        data,
+		truncated:   r.Truncated,
+	}
+	if enc := strings.ToLower(r.Headers["Content-Encoding"]); enc != "" && enc != "identity" {
+		if decoded, err := decode(enc, data); err == nil && !r.Truncated {
+			b.data = decoded
+		} else {
+			b.encoding = enc
+		}
+	}
+	return b, true
+}
+
+// assemble joins the parts of a complete ranged download.
+func assemble(a artifact.Artifact, byID map[string]*logger.Flow) (body, bool) {
+	if !a.Complete {
+		return body{}, false
+	}
+	data := make([]byte, a.Size)
+	for _, id := range a.Flows {
+		fl := byID[id]
+		r, _, ok := artifact.ParseContentRange(fl.Response.Headers["Content-Range"])
+		if !ok || fl.Response.Truncated || fl.Response.Headers["Content-Encoding"] != "" {
+			return body{}, false
+		}
+		part, err := fl.Response.RawBody()
+		if err != nil || int64(len(part)) != r.Len() {
+			return body{}, false
+		}
+		copy(data[r.Start:], part)
+	}
+	return body{
+		flow:        a.ID,
+		url:         a.URL,
+		contentType: mediaType(a.ContentType),
+		data:        data,
+	}, true
+}
+
+func decode(encoding string, data []byte) ([]byte, error) {
+	var r io.Reader
+	switch encoding {
+	case "gzip", "x-gzip":
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		r = zr
+	case "deflate":
+		r = flate.NewReader(bytes.NewReader(data))
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", encoding)
+	}
+	return io.ReadAll(r)
+}
+
+// extension picks a file extension from the media type, or failing that
+// from the URL path.
+func extension(mediaType, rawURL string) string {
+	if ext, ok := extensions[mediaType]; ok {
+		return ext
+	}
+	if exts, _ := mime.ExtensionsByType(mediaType); len(exts) > 0 {
+		return exts[0]
+	}
+	if u, err := url.Parse(rawURL); err == nil {
+		if ext := path.Ext(u.Path); len(ext) > 1 && len(ext) <= 6 {
+			return strings.ToLower(ext)
+		}
+	}
+	return ".bin"
+}
+
+// urlName names a file after the last segment of rawURL's path, or its
+// host for the root, giving it ext when it has no extension and a number
+// when the name is taken. Names are compared without regard to case, as
+// some file systems do.
+func urlName(rawURL, ext string, taken map[string]bool) string {
+	base := ""
+	if u, err := url.Parse(rawURL); err == nil {
+		base = path.Base(u.Path)
+		if base == "/" || base == "." {
+			base = u.Hostname()
+		}
+	}
+	base = strings.Map(func(r rune) rune {
+		if r < ' ' || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, base)
+	base = strings.TrimLeft(base, ".")
+	if base == "" {
+		base = "body"
+	}
+	stem, suffix := base, path.Ext(base)
+	if len(suffix) > 1 && len(suffix) <= 6 {
+		stem = strings.TrimSuffix(base, suffix)
+	} else {
+		suffix = ext
+	}
+	name := stem + suffix
+	for n := 2; taken[strings.ToLower(name)]; n++ {
+		name = fmt.Sprintf("%s-%d%s", stem, n, suffix)
+	}
+	return name
+}
+
+func loadManifest(dir string) (*Manifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, ManifestFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return &Manifest{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("%s: %w", ManifestFile, err)
+	}
+	return &m, nil
+}
+
+func saveManifest(dir string, m *Manifest) error {
+	slices.SortStableFunc(m.Items, func(a, b *Item) int { return cmp.Compare(a.File, b.File) })
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFile(filepath.Join(dir, ManifestFile), append(data, '\n'))
+}
+
+// writeFile writes data to name through a temporary file, so an
+// interrupted run leaves no partial files behind.
+func writeFile(name string, data []byte) error {
+	tmp := name + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, name)
+}
@@ -0,0 +1,122 @@
+package extract
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/standrze/rogue/internal/logger"
+)
+
+func flow(id, url string, status int, headers map[string]string, body []byte) *logger.Flow {
+	r := &logger.ResponseLog{StatusCode: status, Headers: headers, RequestID: id, Body: string(body), BodySize: int64(len(body))}
+	return &logger.Flow{ID: id, Request: &logger.RequestLog{Method: "GET", URL: url, RequestID: id}, Response: r}
+}
+
+func TestExtract(t *testing.T) {
+	pdf := []byte("%PDF-1.7 fake document")
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write([]byte("<svg/>"))
+	zw.Close()
+
+	flows := []*logger.Flow{
+		flow("1", "http://docs.example/a.pdf", 200, map[string]string{"Content-Type": "application/pdf"}, pdf),
+		flow("2", "http://mirror.example/b.pdf", 200, map[string]string{"Content-Type": "application/pdf"}, pdf),
+		flow("3", "http://docs.example/", 200, map[string]string{"Content-Type": "text/html"}, []byte("<html>")),
+		flow("4", "http://img.example/logo", 200, map[string]string{"Content-Type": "image/svg+xml", "Content-Encoding": "gzip"}, nil),
+		flow("5", "http://big.example/file", 206, map[string]string{"Content-Type": "application/pdf", "Content-Range": "bytes 0-4/10"}, []byte("%PDF-")),
+		flow("6", "http://big.example/file", 206, map[string]string{"Content-Type": "application/pdf", "Content-Range": "bytes 5-9/10"}, []byte("12345")),
+	}
+	// Compressed bodies are binary, which sessions store as base64.
+	flows[3].Response.Body, flows[3].Response.BodyEncoding = base64.StdEncoding.EncodeToString(gz.Bytes()), "base64"
+	flows[3].Response.BodySize = int64(gz.Len())
+
+	dir := t.TempDir()
+	res, err := Extract(flows, dir, Filter{ContentTypes: []string{"application/pdf", "image/*"}}, ByHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Matched != 4 || res.New != 3 {
+		t.Errorf("Matched %d, new %d; want 4 and 3", res.Matched, res.New)
+	}
+
+	sum := sha256.Sum256(pdf)
+	name := hex.EncodeToString(sum[:]) + ".pdf"
+	if data, err := os.ReadFile(filepath.Join(dir, name)); err != nil || !bytes.Equal(data, pdf) {
+		t.Errorf("PDF not written as %s: %v", name, err)
+	}
+
+	m, err := loadManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := map[string]*Item{}
+	for _, it := range m.Items {
+		found[it.File] = it
+	}
+	if it := found[name]; it == nil || len(it.URLs) != 2 || len(it.Flows) != 2 {
+		t.Errorf("Duplicate PDF not recorded once with both sources: %+v", it)
+	}
+	for _, it := range m.Items {
+		data, _ := os.ReadFile(filepath.Join(dir, it.File))
+		switch it.ContentType {
+		case "image/svg+xml":
+			if string(data) != "<svg/>" {
+				t.Errorf("Compressed body not decoded: %q", data)
+			}
+		case "application/pdf":
+			if it.File != name && string(data) != "%PDF-12345" {
+				t.Errorf("Ranged download not assembled: %q", data)
+			}
+		}
+	}
+
+	// A second run adds nothing new.
+	if res, err := Extract(flows, dir, Filter{ContentTypes: []string{"application/pdf"}}, ByHash); err != nil || res.New != 0 {
+		t.Errorf("Second run: %+v, %v", res, err)
+	}
+}
+
+func TestExtractByURL(t *testing.T) {
+	png := map[string]string{"Content-Type": "image/png"}
+	flows := []*logger.Flow{
+		flow("1", "http://cdn.example/img/logo.png?v=3", 200, png, []byte("one")),
+		flow("2", "http://other.example/logo.png", 200, png, []byte("two")),
+		flow("3", "http://cdn.example/avatar/42", 200, png, []byte("three")),
+		flow("4", "http://cdn.example/", 200, map[string]string{"Content-Type": "text/html"}, []byte("<html>")),
+		flow("5", "http://mirror.example/LOGO.png", 200, png, []byte("one")),
+	}
+	dir := t.TempDir()
+	if _, err := Extract(flows, dir, Filter{}, ByURL); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{
+		"logo.png":         "one",
+		"logo-2.png":       "two",
+		"42.png":           "three",
+		"cdn.example.html": "<html>",
+	} {
+		if data, err := os.ReadFile(filepath.Join(dir, name)); err != nil || string(data) != want {
+			t.Errorf("%s = %q, %v; want %q", name, data, err, want)
+		}
+	}
+	// Identical bodies are still written once.
+	if _, err := os.Stat(filepath.Join(dir, "LOGO.png")); err == nil {
+		t.Error("duplicate body written again")
+	}
+
+	// Later runs keep clear of the names already used.
+	more := []*logger.Flow{flow("6", "http://new.example/logo.png", 200, png, []byte("four"))}
+	if _, err := Extract(more, dir, Filter{}, ByURL); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "logo-3.png")); err != nil || string(data) != "four" {
+		t.Errorf("logo-3.png = %q, %v", data, err)
+	}
+}
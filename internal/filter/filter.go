@@ -0,0 +1,404 @@
+// Package filter implements the flow filter expression language shared by
+// tail, the session viewer, share links and the /events stream:
+//
+//	host =~ "api\\." && status >= 500 && method == "POST"
+//
+// An expression compares fields of a flow with literals, and combines
+// comparisons with &&, ||, ! and parentheses. A field on its own is true
+// when it is set. Strings are double-quoted with Go escapes; numbers may
+// have a fraction. @name refers to a saved expression.
+//
+// Fields holding text take ==, !=, contains, =~ and !~ (regular
+// expressions); method, host, header values and tags compare without regard
+// to case. A comparison with tag or operation (the GraphQL operation names)
+// holds when any of the flow's values matches, or for != and !~ when none
+// does. Numeric fields also take <, <=, > and >=, and =~ against their
+// decimal form. A field the flow lacks, such as status before a response,
+// is "" or 0.
+package filter
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/standrze/rogue/internal/logger"
+)
+
+// Expr is a parsed filter expression.
+type Expr struct {
+	root node
+}
+
+// Saved maps names to expressions that others refer to as @name.
+type Saved map[string]string
+
+// Parse parses an expression without saved references.
+func Parse(src string) (*Expr, error) {
+	return Saved(nil).Parse(src)
+}
+
+// Parse parses an expression, resolving @name from s.
+func (s Saved) Parse(src string) (*Expr, error) {
+	root, err := s.parse(src, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Expr{root: root}, nil
+}
+
+// Check parses every saved expression, so mistakes are reported at startup
+// rather than on first use.
+func (s Saved) Check() error {
+	names := make([]string, 0, len(s))
+	for name := range s {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, err := s.parse("@"+name, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s Saved) parse(src string, using []string) (node, error) {
+	toks, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks, saved: s, using: using}
+	n, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, p.errorf(t, "unexpected %s", t)
+	}
+	return n, nil
+}
+
+// Match reports whether f satisfies the expression. A nil Expr matches
+// every flow.
+func (e *Expr) Match(f *logger.Flow) bool {
+	return e == nil || e.root.match(f)
+}
+
+// String returns the expression in canonical form, with saved references
+// expanded so it can be parsed again on its own.
+func (e *Expr) String() string {
+	if e == nil {
+		return ""
+	}
+	return e.root.String()
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (e *Expr) MarshalText() ([]byte, error) {
+	return []byte(e.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (e *Expr) UnmarshalText(b []byte) error {
+	p, err := Parse(string(b))
+	if err != nil {
+		return err
+	}
+	*e = *p
+	return nil
+}
+
+type node interface {
+	match(*logger.Flow) bool
+	String() string
+}
+
+type and struct{ l, r node }
+
+func (n and) match(f *logger.Flow) bool { return n.l.match(f) && n.r.match(f) }
+func (n and) String() string            { return group(n.l, "||") + " && " + group(n.r, "||") }
+
+type or struct{ l, r node }
+
+func (n or) match(f *logger.Flow) bool { return n.l.match(f) || n.r.match(f) }
+func (n or) String() string            { return n.l.String() + " || " + n.r.String() }
+
+type not struct{ x node }
+
+func (n not) match(f *logger.Flow) bool { return !n.x.match(f) }
+func (n not) String() string {
+	switch n.x.(type) {
+	case and, or, cmp:
+		return "!(" + n.x.String() + ")"
+	}
+	return "!" + n.x.String()
+}
+
+// group parenthesizes n when it is an op expression, which binds more
+// loosely than the operator it sits under.
+func group(n node, op string) string {
+	if _, ok := n.(or); ok && op == "||" {
+		return "(" + n.String() + ")"
+	}
+	return n.String()
+}
+
+// set is a field standing on its own.
+type set struct{ f field }
+
+func (n set) match(fl *logger.Flow) bool {
+	if n.f.numeric() {
+		return n.f.number(fl) != 0
+	}
+	return n.f.text(fl) != ""
+}
+func (n set) String() string { return n.f.String() }
+
+type cmp struct {
+	f   field
+	op  string
+	str string
+	num float64
+	re  *regexp.Regexp
+}
+
+func (n cmp) match(fl *logger.Flow) bool {
+	switch n.f.name {
+	case "tag":
+		return n.matchAny(fl.Tags())
+	case "operation":
+		return n.matchAny(fl.GraphQLOperations())
+	}
+	if !n.f.numeric() {
+		return n.matchText(n.f.text(fl))
+	}
+	if n.op == "=~" || n.op == "!~" {
+		return n.matchText(strconv.FormatFloat(n.f.number(fl), 'f', -1, 64))
+	}
+	v := n.f.number(fl)
+	switch n.op {
+	case "==":
+		return v == n.num
+	case "!=":
+		return v != n.num
+	case "<":
+		return v < n.num
+	case "<=":
+		return v <= n.num
+	case ">":
+		return v > n.num
+	case ">=":
+		return v >= n.num
+	}
+	return false
+}
+
+// matchAny reports whether any of values matches, or for != and !~ whether
+// none of them matches the positive form.
+func (n cmp) matchAny(values []string) bool {
+	negated := n.op == "!=" || n.op == "!~"
+	pos := n
+	switch n.op {
+	case "!=":
+		pos.op = "=="
+	case "!~":
+		pos.op = "=~"
+	}
+	for _, v := range values {
+		if pos.matchText(v) {
+			return !negated
+		}
+	}
+	return negated
+}
+
+func (n cmp) matchText(s string) bool {
+	switch n.op {
+	case "=~", "!~":
+		return n.re.MatchString(s) == (n.op == "=~")
+	case "contains":
+		sub := n.str
+		if n.f.folds() {
+			s, sub = strings.ToLower(s), strings.ToLower(sub)
+		}
+		return strings.Contains(s, sub)
+	}
+	eq := s == n.str
+	if n.f.folds() {
+		eq = strings.EqualFold(s, n.str)
+	}
+	return eq == (n.op == "==")
+}
+
+func (n cmp) String() string {
+	lit := strconv.Quote(n.str)
+	if n.f.numeric() && n.op != "=~" && n.op != "!~" {
+		lit = strconv.FormatFloat(n.num, 'f', -1, 64)
+	}
+	return n.f.String() + " " + n.op + " " + lit
+}
+
+// field is a named property of a flow; key is the header name for header
+// fields.
+type field struct {
+	name string
+	key  string
+}
+
+// fields lists the fields and whether each is numeric.
+var fields = map[string]bool{
+	"id":              false,
+	"method":          false,
+	"url":             false,
+	"scheme":          false,
+	"host":            false,
+	"path":            false,
+	"query":           false,
+	"client_ip":       false,
+	"content_type":    false,
+	"request_body":    false,
+	"response_body":   false,
+	"request_header":  false,
+	"response_header": false,
+	"tag":             false,
+	"note":            false,
+	"operation":       false,
+	"status":          true,
+	"duration":        true,
+	"request_size":    true,
+	"response_size":   true,
+	"starred":         true,
+}
+
+func (f field) numeric() bool { return fields[f.name] }
+
+func (f field) folds() bool {
+	return f.name == "method" || f.name == "host" || f.name == "tag" || f.key != ""
+}
+
+func (f field) String() string {
+	if f.key != "" {
+		return f.name + "[" + strconv.Quote(f.key) + "]"
+	}
+	return f.name
+}
+
+func (f field) text(fl *logger.Flow) string {
+	req, res := fl.Request, fl.Response
+	if req == nil {
+		req = &logger.RequestLog{}
+	}
+	if res == nil {
+		res = &logger.ResponseLog{}
+	}
+	switch f.name {
+	case "id":
+		return fl.ID
+	case "method":
+		return req.Method
+	case "url":
+		return req.URL
+	case "scheme", "host", "path", "query":
+		u, err := url.Parse(req.URL)
+		if err != nil {
+			return ""
+		}
+		switch f.name {
+		case "scheme":
+			return u.Scheme
+		case "host":
+			return u.Hostname()
+		case "path":
+			return u.Path
+		}
+		return u.RawQuery
+	case "client_ip":
+		return req.ClientIP
+	case "content_type":
+		return header(res.Headers, "Content-Type")
+	case "request_body":
+		return req.Body
+	case "response_body":
+		return res.Body
+	case "request_header":
+		return header(req.Headers, f.key)
+	case "response_header":
+		return header(res.Headers, f.key)
+	case "tag":
+		return strings.Join(fl.Tags(), ",")
+	case "operation":
+		return strings.Join(fl.GraphQLOperations(), ",")
+	case "note":
+		var notes []string
+		for _, a := range fl.Annotations {
+			if a.Comment != "" {
+				notes = append(notes, a.Comment)
+			}
+		}
+		return strings.Join(notes, "\n")
+	}
+	return ""
+}
+
+func (f field) number(fl *logger.Flow) float64 {
+	req, res := fl.Request, fl.Response
+	switch f.name {
+	case "status":
+		if res != nil {
+			return float64(res.StatusCode)
+		}
+	case "duration":
+		if req != nil && res != nil {
+			return float64(res.Timestamp.Sub(req.Timestamp).Milliseconds())
+		}
+	case "request_size":
+		if req != nil {
+			return float64(req.BodySize)
+		}
+	case "response_size":
+		if res != nil {
+			return float64(res.BodySize)
+		}
+	case "starred":
+		if fl.Starred() {
+			return 1
+		}
+	}
+	return 0
+}
+
+func header(h map[string]string, name string) string {
+	if v, ok := h[name]; ok {
+		return v
+	}
+	for k, v := range h {
+		if strings.EqualFold(k, name) {
+			return v
+		}
+	}
+	return ""
+}
+
+// FieldNames returns the names of the fields, for help texts.
+func FieldNames() []string {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Error is a syntax error at a byte offset of the expression.
+type Error struct {
+	Pos int
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("filter: column %d: %s", e.Pos+1, e.Msg)
+}
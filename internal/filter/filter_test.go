@@ -0,0 +1,244 @@
+package filter
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/standrze/rogue/internal/logger"
+)
+
+func testFlow() *logger.Flow {
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	return &logger.Flow{
+		ID: "abc",
+		Request: &logger.RequestLog{
+			Timestamp: start,
+			Method:    "POST",
+			URL:       "https://api.example.com/v1/orders?page=2",
+			Headers:   map[string]string{"Authorization": "Bearer t0ken"},
+			Body:      `{"item":42}`,
+			BodySize:  11,
+			ClientIP:  "10.0.0.7",
+		},
+		Response: &logger.ResponseLog{
+			Timestamp:  start.Add(1500 * time.Millisecond),
+			StatusCode: 503,
+			Headers:    map[string]string{"Content-Type": "application/json"},
+			Body:       `{"error":"busy"}`,
+			BodySize:   16,
+		},
+	}
+}
+
+func TestMatch(t *testing.T) {
+	f := testFlow()
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{`host =~ "api\\." && status >= 500 && method == "POST"`, true},
+		{`method == "post"`, true},
+		{`method != "GET"`, true},
+		{`status == 503`, true},
+		{`status < 500`, false},
+		{`status =~ "^5"`, true},
+		{`duration > 1000 && duration <= 1500`, true},
+		{`path == "/v1/orders" && query contains "page"`, true},
+		{`scheme == "https"`, true},
+		{`url contains "orders" || status == 200`, true},
+		{`!(status >= 500)`, false},
+		{`!url contains "nope"`, true},
+		{`request_header["authorization"] contains "bearer"`, true},
+		{`response_header["X-Missing"]`, false},
+		{`content_type =~ "json$"`, true},
+		{`request_body contains "42" && response_body !~ "ok"`, true},
+		{`request_size == 11 && response_size > 15.5`, true},
+		{`client_ip == "10.0.0.7" && id == "abc"`, true},
+		{`(status == 200 || status == 503) && method == "GET"`, false},
+		{`status == 200 || status == 503 && method == "POST"`, true},
+	}
+	for _, tt := range tests {
+		e, err := Parse(tt.expr)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.expr, err)
+			continue
+		}
+		if got := e.Match(f); got != tt.want {
+			t.Errorf("%q matched %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestMissingResponse(t *testing.T) {
+	f := testFlow()
+	f.Response = nil
+	for expr, want := range map[string]bool{
+		`status == 0`:               true,
+		`status`:                    false,
+		`content_type == ""`:        true,
+		`duration > 0`:              false,
+		`response_header["A"]`:      false,
+		`!response_body`:            true,
+		`method == "POST"`:          true,
+		`response_body contains ""`: true,
+	} {
+		if got := mustParse(t, expr).Match(f); got != want {
+			t.Errorf("%q matched %v, want %v", expr, got, want)
+		}
+	}
+}
+
+func TestAnnotations(t *testing.T) {
+	f := testFlow()
+	f.Annotations = []logger.Annotation{
+		{Tags: []string{"auth", "IDOR"}},
+		{Starred: true, Comment: "leaks the order of another user"},
+		{Tags: []string{"auth"}},
+	}
+	plain := testFlow()
+	for expr, want := range map[string][2]bool{
+		`tag == "idor"`:            {true, false},
+		`tag != "idor"`:            {false, true},
+		`tag =~ "^au"`:             {true, false},
+		`tag !~ "^au"`:             {false, true},
+		`tag`:                      {true, false},
+		`note contains "order"`:    {true, false},
+		`starred && tag == "auth"`: {true, false},
+		`!starred`:                 {false, true},
+		`starred == 0`:             {false, true},
+	} {
+		e := mustParse(t, expr)
+		if got := e.Match(f); got != want[0] {
+			t.Errorf("%q matched the annotated flow: %v, want %v", expr, got, want[0])
+		}
+		if got := e.Match(plain); got != want[1] {
+			t.Errorf("%q matched the plain flow: %v, want %v", expr, got, want[1])
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		expr, want string
+	}{
+		{``, "column 1: expected a field"},
+		{`hots == "a"`, `unknown field "hots"`},
+		{`status == "500"`, "status is a number"},
+		{`host > "a"`, "host is text"},
+		{`status contains 5`, "contains needs a text field"},
+		{`host =~ "("`, "invalid regular expression"},
+		{`host == "a`, "column 9: unterminated string"},
+		{`host == "a" &&`, "expected a field"},
+		{`(host == "a"`, `expected ")"`},
+		{`host == "a" status == 1`, `unexpected "status"`},
+		{`request_header == "a"`, "needs a header name"},
+		{`host["a"] == "b"`, "takes no [key]"},
+		{`host == 'a'`, `unexpected '\''`},
+		{`@nope`, `no saved filter named "nope"`},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.expr)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Parse(%q) = %v, want error containing %q", tt.expr, err, tt.want)
+		}
+	}
+}
+
+func TestSaved(t *testing.T) {
+	saved := Saved{
+		"errors":   `status >= 500`,
+		"api":      `host =~ "^api\\."`,
+		"api-fail": `@api && @errors`,
+		"loop":     `@loop2`,
+		"loop2":    `status == 1 || @loop`,
+	}
+	e, err := saved.Parse(`@API-fail && method == "POST"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !e.Match(testFlow()) {
+		t.Error("saved filters did not match")
+	}
+	if got, want := e.String(), `host =~ "^api\\." && status >= 500 && method == "POST"`; got != want {
+		t.Errorf("String() = %s, want %s", got, want)
+	}
+
+	if _, err := saved.Parse(`@loop`); err == nil || !strings.Contains(err.Error(), "refers to itself") {
+		t.Errorf("cycle: err = %v", err)
+	}
+	if err := saved.Check(); err == nil {
+		t.Error("Check accepted a cycle")
+	}
+	delete(saved, "loop")
+	delete(saved, "loop2")
+	if err := saved.Check(); err != nil {
+		t.Errorf("Check: %v", err)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, src := range []string{
+		`!(status == 200 || status == 204) && (method == "GET" || method == "HEAD")`,
+		`!response_body && request_header["X-A"] != "b\n"`,
+	} {
+		e := mustParse(t, src)
+		again, err := Parse(e.String())
+		if err != nil {
+			t.Fatalf("reparsing %s: %v", e, err)
+		}
+		if again.String() != e.String() {
+			t.Errorf("round trip: %s became %s", e, again)
+		}
+	}
+
+	var v struct {
+		Where *Expr `json:"where,omitempty"`
+	}
+	if err := json.Unmarshal([]byte(`{"where":"status >= 500"}`), &v); err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out map[string]string
+	json.Unmarshal(b, &out)
+	if out["where"] != "status >= 500" {
+		t.Errorf("JSON = %s", b)
+	}
+	if err := json.Unmarshal([]byte(`{"where":"status >"}`), &v); err == nil {
+		t.Error("invalid expression unmarshaled")
+	}
+}
+
+func mustParse(t *testing.T, src string) *Expr {
+	t.Helper()
+	e, err := Parse(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestGraphQLOperation(t *testing.T) {
+	f := testFlow()
+	f.Request.GraphQL = []logger.GraphQLOperation{{Name: "GetOrder", Type: "query"}, {Name: "TrackView", Type: "mutation"}}
+	plain := testFlow()
+	for expr, want := range map[string][2]bool{
+		`operation == "GetOrder"`:  {true, false},
+		`operation == "getorder"`:  {false, false},
+		`operation != "TrackView"`: {false, true},
+		`operation =~ "^Track"`:    {true, false},
+		`operation`:                {true, false},
+	} {
+		e := mustParse(t, expr)
+		if got := e.Match(f); got != want[0] {
+			t.Errorf("%q matched the GraphQL flow: %v, want %v", expr, got, want[0])
+		}
+		if got := e.Match(plain); got != want[1] {
+			t.Errorf("%q matched the plain flow: %v, want %v", expr, got, want[1])
+		}
+	}
+}
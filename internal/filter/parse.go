@@ -0,0 +1,299 @@
+package filter
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokOp
+	tokRef
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of expression"
+	case tokString:
+		return "string " + t.text
+	case tokRef:
+		return "@" + t.text
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+// ops lists the operators, longest first so "==" is not read as "=".
+var ops = []string{"&&", "||", "==", "!=", "=~", "!~", "<=", ">=", "<", ">", "!", "(", ")", "[", "]"}
+
+func lex(src string) ([]token, error) {
+	var toks []token
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '"':
+			j := i + 1
+			for ; j < len(src) && src[j] != '"'; j++ {
+				if src[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(src) {
+				return nil, &Error{i, "unterminated string"}
+			}
+			s, err := strconv.Unquote(src[i : j+1])
+			if err != nil {
+				return nil, &Error{i, "invalid string " + src[i:j+1]}
+			}
+			toks = append(toks, token{tokString, s, i})
+			i = j + 1
+		case c >= '0' && c <= '9':
+			j := i
+			for j < len(src) && (src[j] >= '0' && src[j] <= '9' || src[j] == '.') {
+				j++
+			}
+			toks = append(toks, token{tokNumber, src[i:j], i})
+			i = j
+		case c == '@' || isIdentStart(c):
+			j := i + 1
+			for j < len(src) && (isIdentStart(src[j]) || src[j] >= '0' && src[j] <= '9' || c == '@' && src[j] == '-') {
+				j++
+			}
+			if c == '@' {
+				if j == i+1 {
+					return nil, &Error{i, "@ must be followed by a saved filter name"}
+				}
+				toks = append(toks, token{tokRef, src[i+1 : j], i})
+			} else {
+				toks = append(toks, token{tokIdent, src[i:j], i})
+			}
+			i = j
+		default:
+			op := ""
+			for _, o := range ops {
+				if strings.HasPrefix(src[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return nil, &Error{i, fmt.Sprintf("unexpected %q", c)}
+			}
+			toks = append(toks, token{tokOp, op, i})
+			i += len(op)
+		}
+	}
+	return append(toks, token{tokEOF, "", len(src)}), nil
+}
+
+func isIdentStart(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_'
+}
+
+type parser struct {
+	toks  []token
+	i     int
+	saved Saved
+	// using holds the saved filters being expanded, to catch cycles.
+	using []string
+}
+
+func (p *parser) peek() token { return p.toks[p.i] }
+
+func (p *parser) next() token {
+	t := p.toks[p.i]
+	if t.kind != tokEOF {
+		p.i++
+	}
+	return t
+}
+
+func (p *parser) accept(op string) bool {
+	if t := p.peek(); t.kind == tokOp && t.text == op {
+		p.i++
+		return true
+	}
+	return false
+}
+
+func (p *parser) errorf(t token, format string, args ...any) error {
+	return &Error{t.pos, fmt.Sprintf(format, args...)}
+}
+
+func (p *parser) or() (node, error) {
+	l, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("||") {
+		r, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		l = or{l, r}
+	}
+	return l, nil
+}
+
+func (p *parser) and() (node, error) {
+	l, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("&&") {
+		r, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		l = and{l, r}
+	}
+	return l, nil
+}
+
+func (p *parser) unary() (node, error) {
+	if p.accept("!") {
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return not{x}, nil
+	}
+	return p.primary()
+}
+
+func (p *parser) primary() (node, error) {
+	t := p.next()
+	switch {
+	case t.kind == tokOp && t.text == "(":
+		n, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(")") {
+			return nil, p.errorf(p.peek(), "expected \")\", got %s", p.peek())
+		}
+		return n, nil
+	case t.kind == tokRef:
+		return p.ref(t)
+	case t.kind == tokIdent:
+		f, err := p.field(t)
+		if err != nil {
+			return nil, err
+		}
+		return p.comparison(f)
+	}
+	return nil, p.errorf(t, "expected a field, @name or \"(\", got %s", t)
+}
+
+// ref expands a saved filter in place.
+func (p *parser) ref(t token) (node, error) {
+	name := strings.ToLower(t.text)
+	src, ok := p.saved[name]
+	if !ok {
+		return nil, p.errorf(t, "no saved filter named %q", t.text)
+	}
+	if slices.Contains(p.using, name) {
+		return nil, p.errorf(t, "saved filter %q refers to itself", t.text)
+	}
+	n, err := p.saved.parse(src, append(slices.Clone(p.using), name))
+	if err != nil {
+		return nil, fmt.Errorf("in @%s: %w", name, err)
+	}
+	return n, nil
+}
+
+func (p *parser) field(t token) (field, error) {
+	name := strings.ToLower(t.text)
+	if _, ok := fields[name]; !ok {
+		return field{}, p.errorf(t, "unknown field %q (have %s)", t.text, strings.Join(FieldNames(), ", "))
+	}
+	f := field{name: name}
+	header := name == "request_header" || name == "response_header"
+	if !p.accept("[") {
+		if header {
+			return f, p.errorf(t, "%s needs a header name, as in %s[\"Content-Type\"]", name, name)
+		}
+		return f, nil
+	}
+	if !header {
+		return f, p.errorf(t, "%s takes no [key]", name)
+	}
+	k := p.next()
+	if k.kind != tokString || k.text == "" {
+		return f, p.errorf(k, "expected a header name in quotes, got %s", k)
+	}
+	if !p.accept("]") {
+		return f, p.errorf(p.peek(), "expected \"]\", got %s", p.peek())
+	}
+	f.key = k.text
+	return f, nil
+}
+
+func (p *parser) comparison(f field) (node, error) {
+	t := p.peek()
+	op := ""
+	switch {
+	case t.kind == tokOp && slices.Contains([]string{"==", "!=", "=~", "!~", "<", "<=", ">", ">="}, t.text):
+		op = t.text
+	case t.kind == tokIdent && strings.EqualFold(t.text, "contains"):
+		op = "contains"
+	default:
+		return set{f}, nil
+	}
+	p.next()
+	lit := p.next()
+
+	n := cmp{f: f, op: op}
+	switch op {
+	case "=~", "!~":
+		if lit.kind != tokString {
+			return nil, p.errorf(lit, "%s needs a regular expression in quotes, got %s", op, lit)
+		}
+		re, err := regexp.Compile(lit.text)
+		if err != nil {
+			return nil, p.errorf(lit, "invalid regular expression: %v", err)
+		}
+		n.str, n.re = lit.text, re
+		return n, nil
+	case "contains":
+		if f.numeric() {
+			return nil, p.errorf(t, "%s is a number; contains needs a text field", f)
+		}
+	case "<", "<=", ">", ">=":
+		if !f.numeric() {
+			return nil, p.errorf(t, "%s is text; %s needs a numeric field", f, op)
+		}
+	}
+	if f.numeric() {
+		if lit.kind != tokNumber {
+			return nil, p.errorf(lit, "%s is a number, got %s", f, lit)
+		}
+		v, err := strconv.ParseFloat(lit.text, 64)
+		if err != nil {
+			return nil, p.errorf(lit, "invalid number %s", lit.text)
+		}
+		n.num = v
+		return n, nil
+	}
+	if lit.kind != tokString {
+		return nil, p.errorf(lit, "%s is text and needs a string in quotes, got %s", f, lit)
+	}
+	n.str = lit.text
+	return n, nil
+}
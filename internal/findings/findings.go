@@ -0,0 +1,342 @@
+// Package findings passively checks proxied exchanges for common security
+// issues: missing security headers, cookies without Secure or HttpOnly,
+// Basic credentials sent over plain HTTP, unsigned JWTs, verbose server
+// errors and mixed content. Nothing is sent; only the traffic seen is
+// examined.
+package findings
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Severities, from most to least severe.
+const (
+	High   = "high"
+	Medium = "medium"
+	Low    = "low"
+)
+
+// The checks, by the names findings carry and checks are disabled by.
+const (
+	CheckSecurityHeaders = "security-headers"
+	CheckInsecureCookie  = "insecure-cookie"
+	CheckBasicAuth       = "basic-auth-plaintext"
+	CheckJWTNone         = "jwt-alg-none"
+	CheckVerboseError    = "verbose-error"
+	CheckMixedContent    = "mixed-content"
+)
+
+// Checks lists every check.
+var Checks = []string{CheckSecurityHeaders, CheckInsecureCookie, CheckBasicAuth, CheckJWTNone, CheckVerboseError, CheckMixedContent}
+
+// Finding is an issue seen in an exchange. It is recorded in the session as
+// a "finding" entry.
+type Finding struct {
+	Timestamp time.Time `json:"timestamp"`
+	RequestID string    `json:"request_id,omitempty"`
+	Check     string    `json:"check"`
+	Severity  string    `json:"severity"`
+	URL       string    `json:"url"`
+	Title     string    `json:"title"`
+	Detail    string    `json:"detail,omitempty"`
+}
+
+// Host returns the host name of the finding's URL.
+func (f Finding) Host() string {
+	u, err := url.Parse(f.URL)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
+
+// MaxBody is how much of a response body the body checks look at.
+const MaxBody = 256 << 10
+
+// maxSeen bounds the issues a Scanner remembers having reported.
+const maxSeen = 10000
+
+// Scanner runs the enabled checks and reports each issue once per host, so
+// a missing header is not reported for every page of a site.
+type Scanner struct {
+	disabled []string
+
+	mu   sync.Mutex
+	seen map[string]bool
+}
+
+// NewScanner returns a scanner running every check but those in disabled.
+func NewScanner(disabled []string) (*Scanner, error) {
+	for _, name := range disabled {
+		if !slices.Contains(Checks, name) {
+			return nil, fmt.Errorf("unknown check %q (have %s)", name, strings.Join(Checks, ", "))
+		}
+	}
+	return &Scanner{disabled: disabled, seen: map[string]bool{}}, nil
+}
+
+// Headers checks the request and the response headers of an exchange.
+func (s *Scanner) Headers(req *http.Request, res *http.Response) []Finding {
+	var out []Finding
+	out = append(out, basicAuth(req)...)
+	out = append(out, jwtNone(req, res)...)
+	if res != nil {
+		out = append(out, securityHeaders(req, res)...)
+		out = append(out, insecureCookies(req, res)...)
+	}
+	return s.filter(req, out)
+}
+
+// Body checks the start of a response body, decoded from any
+// Content-Encoding.
+func (s *Scanner) Body(req *http.Request, res *http.Response, body []byte) []Finding {
+	var out []Finding
+	out = append(out, verboseError(res, body)...)
+	out = append(out, mixedContent(req, res, body)...)
+	return s.filter(req, out)
+}
+
+// filter drops disabled checks and issues already reported, and fills in
+// the time. Callers fill in the URL and request ID.
+func (s *Scanner) filter(req *http.Request, found []Finding) []Finding {
+	if len(found) == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []Finding
+	for _, f := range found {
+		if slices.Contains(s.disabled, f.Check) {
+			continue
+		}
+		key := f.Check + "\x00" + req.Host + "\x00" + f.Title + "\x00" + f.Detail
+		if s.seen[key] {
+			continue
+		}
+		if len(s.seen) >= maxSeen {
+			clear(s.seen)
+		}
+		s.seen[key] = true
+		f.Timestamp = time.Now()
+		out = append(out, f)
+	}
+	return out
+}
+
+func isHTTPS(req *http.Request) bool {
+	return req.URL.Scheme == "https" || req.TLS != nil
+}
+
+func isHTML(res *http.Response) bool {
+	mt, _, _ := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	return mt == "text/html" || mt == "application/xhtml+xml"
+}
+
+func securityHeaders(req *http.Request, res *http.Response) []Finding {
+	if !isHTML(res) || res.StatusCode >= 300 && res.StatusCode < 400 {
+		return nil
+	}
+	var missing []string
+	csp := res.Header.Get("Content-Security-Policy")
+	if csp == "" {
+		missing = append(missing, "Content-Security-Policy")
+	}
+	if res.Header.Get("X-Frame-Options") == "" && !strings.Contains(csp, "frame-ancestors") {
+		missing = append(missing, "X-Frame-Options")
+	}
+	if !strings.EqualFold(res.Header.Get("X-Content-Type-Options"), "nosniff") {
+		missing = append(missing, "X-Content-Type-Options")
+	}
+	if isHTTPS(req) && res.Header.Get("Strict-Transport-Security") == "" {
+		missing = append(missing, "Strict-Transport-Security")
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	return []Finding{{
+		Check:    CheckSecurityHeaders,
+		Severity: Low,
+		Title:    "Missing security headers",
+		Detail:   strings.Join(missing, ", "),
+	}}
+}
+
+func insecureCookies(req *http.Request, res *http.Response) []Finding {
+	var out []Finding
+	for _, c := range res.Cookies() {
+		var lacks []string
+		if isHTTPS(req) && !c.Secure {
+			lacks = append(lacks, "Secure")
+		}
+		if !c.HttpOnly {
+			lacks = append(lacks, "HttpOnly")
+		}
+		if len(lacks) == 0 {
+			continue
+		}
+		severity := Low
+		if slices.Contains(lacks, "Secure") {
+			severity = Medium
+		}
+		out = append(out, Finding{
+			Check:    CheckInsecureCookie,
+			Severity: severity,
+			Title:    "Cookie " + c.Name + " set without " + strings.Join(lacks, " and "),
+			Detail:   c.Name,
+		})
+	}
+	return out
+}
+
+func basicAuth(req *http.Request) []Finding {
+	if isHTTPS(req) {
+		return nil
+	}
+	user, _, ok := req.BasicAuth()
+	if !ok {
+		return nil
+	}
+	return []Finding{{
+		Check:    CheckBasicAuth,
+		Severity: High,
+		Title:    "Basic credentials sent over plain HTTP",
+		Detail:   "user " + user,
+	}}
+}
+
+// jwtPattern matches the header and payload of a JWT.
+var jwtPattern = regexp.MustCompile(`eyJ[A-Za-z0-9_-]{5,}\.eyJ[A-Za-z0-9_-]*\.[A-Za-z0-9_-]*`)
+
+func jwtNone(req *http.Request, res *http.Response) []Finding {
+	type source struct{ where, value string }
+	var sources []source
+	for _, name := range []string{"Authorization", "Cookie"} {
+		for _, v := range req.Header.Values(name) {
+			sources = append(sources, source{"request " + name, v})
+		}
+	}
+	if req.URL.RawQuery != "" {
+		sources = append(sources, source{"query string", req.URL.RawQuery})
+	}
+	if res != nil {
+		for _, name := range []string{"Set-Cookie", "Authorization"} {
+			for _, v := range res.Header.Values(name) {
+				sources = append(sources, source{"response " + name, v})
+			}
+		}
+	}
+	var out []Finding
+	for _, src := range sources {
+		for _, tok := range jwtPattern.FindAllString(src.value, -1) {
+			if !unsigned(tok) {
+				continue
+			}
+			out = append(out, Finding{
+				Check:    CheckJWTNone,
+				Severity: High,
+				Title:    "JWT with alg none in the " + src.where,
+				Detail:   src.where,
+			})
+			break
+		}
+	}
+	return out
+}
+
+// unsigned reports whether a JWT's header names the "none" algorithm.
+func unsigned(tok string) bool {
+	head, _, _ := strings.Cut(tok, ".")
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(head, "="))
+	if err != nil {
+		return false
+	}
+	var h struct {
+		Alg string `json:"alg"`
+	}
+	return json.Unmarshal(data, &h) == nil && strings.EqualFold(h.Alg, "none")
+}
+
+// errorPatterns match stack traces and error dumps of common platforms.
+var errorPatterns = []struct {
+	name string
+	re   *regexp.Regexp
+}{
+	{"Python traceback", regexp.MustCompile(`Traceback \(most recent call last\)`)},
+	{"Java stack trace", regexp.MustCompile(`(?m)^\s*at [a-zA-Z_$][\w$]*(\.[\w$<>]+)+\([\w$]+\.java:\d+\)`)},
+	{"Go panic", regexp.MustCompile(`\bgoroutine \d+ \[[a-z ]+(, \d+ minutes)?\]:`)},
+	{".NET exception", regexp.MustCompile(`System\.[A-Za-z.]+Exception|Server Error in '[^']*' Application`)},
+	{"PHP error", regexp.MustCompile(`(Fatal error|Warning|Parse error)</b>: .* on line <b>\d+|PHP (Fatal error|Warning):`)},
+	{"Node.js stack trace", regexp.MustCompile(`(?m)^\s*at .+ \((/|[A-Z]:\\|node:)[^)]+:\d+:\d+\)`)},
+	{"Ruby stack trace", regexp.MustCompile(`\.rb:\d+:in ` + "`")},
+	{"SQL error", regexp.MustCompile(`SQLSTATE\[|ORA-\d{5}|You have an error in your SQL syntax|PG::[A-Za-z]+Error|SQLite3::`)},
+	{"debug page", regexp.MustCompile(`Whoops! There was an error|DEBUG = True|Werkzeug Debugger`)},
+}
+
+func verboseError(res *http.Response, body []byte) []Finding {
+	if res.StatusCode < 400 || len(body) == 0 {
+		return nil
+	}
+	var out []Finding
+	for _, p := range errorPatterns {
+		if p.re.Match(body) {
+			out = append(out, Finding{
+				Check:    CheckVerboseError,
+				Severity: Medium,
+				Title:    fmt.Sprintf("%d response exposes a %s", res.StatusCode, p.name),
+				Detail:   p.name,
+			})
+		}
+	}
+	return out
+}
+
+// mixedPattern matches elements of an HTML page loading plain HTTP URLs.
+var mixedPattern = regexp.MustCompile(`(?i)<(script|iframe|link|img|audio|video|source|embed|object|form)\b[^>]*?\s(?:src|href|action|data)\s*=\s*["']?(http://[^"'\s>]+)`)
+
+// activeElements can change the page they load into, so mixing them in is
+// more severe.
+var activeElements = []string{"script", "iframe", "link", "embed", "object", "form"}
+
+func mixedContent(req *http.Request, res *http.Response, body []byte) []Finding {
+	if !isHTTPS(req) || !isHTML(res) {
+		return nil
+	}
+	severity := ""
+	urls := map[string]bool{}
+	for _, m := range mixedPattern.FindAllSubmatch(body, -1) {
+		urls[string(m[2])] = true
+		if slices.Contains(activeElements, strings.ToLower(string(m[1]))) {
+			severity = Medium
+		} else if severity == "" {
+			severity = Low
+		}
+	}
+	if len(urls) == 0 {
+		return nil
+	}
+	list := make([]string, 0, len(urls))
+	for u := range urls {
+		list = append(list, u)
+	}
+	sort.Strings(list)
+	if len(list) > 5 {
+		list = append(list[:5], fmt.Sprintf("and %d more", len(urls)-5))
+	}
+	return []Finding{{
+		Check:    CheckMixedContent,
+		Severity: severity,
+		Title:    "HTTPS page loads content over plain HTTP",
+		Detail:   strings.Join(list, ", "),
+	}}
+}
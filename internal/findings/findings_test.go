@@ -0,0 +1,131 @@
+package findings
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/standrze/rogue/internal/logger"
+)
+
+func exchange(url string, header http.Header, status int) (*http.Request, *http.Response) {
+	req := httptest.NewRequest("GET", url, nil)
+	res := &http.Response{StatusCode: status, Header: header, Request: req}
+	return req, res
+}
+
+func checks(found []Finding) []string {
+	var out []string
+	for _, f := range found {
+		out = append(out, f.Check+": "+f.Title+" ("+f.Detail+")")
+	}
+	return out
+}
+
+func TestHeaders(t *testing.T) {
+	s, _ := NewScanner(nil)
+	req, res := exchange("https://shop.test/", http.Header{
+		"Content-Type":           {"text/html; charset=utf-8"},
+		"X-Content-Type-Options": {"nosniff"},
+		"Set-Cookie":             {"session=abc; Path=/", "pref=1; Secure; HttpOnly", "track=2; Secure"},
+	}, 200)
+	got := checks(s.Headers(req, res))
+	want := []string{
+		"security-headers: Missing security headers (Content-Security-Policy, X-Frame-Options, Strict-Transport-Security)",
+		"insecure-cookie: Cookie session set without Secure and HttpOnly (session)",
+		"insecure-cookie: Cookie track set without HttpOnly (track)",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("findings:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// The same issues on the same host are reported once.
+	if again := s.Headers(req, res); len(again) != 0 {
+		t.Errorf("reported again: %v", checks(again))
+	}
+
+	// JSON responses need no page headers, and plain HTTP cookies need not
+	// be Secure.
+	req, res = exchange("http://api.test/", http.Header{"Content-Type": {"application/json"}, "Set-Cookie": {"id=1; HttpOnly"}}, 200)
+	if found := s.Headers(req, res); len(found) != 0 {
+		t.Errorf("unexpected findings: %v", checks(found))
+	}
+}
+
+func TestBasicAuthAndJWT(t *testing.T) {
+	s, _ := NewScanner(nil)
+	req, res := exchange("http://intranet.test/", http.Header{}, 200)
+	req.SetBasicAuth("admin", "hunter2")
+	found := s.Headers(req, res)
+	if len(found) != 1 || found[0].Check != CheckBasicAuth || found[0].Severity != High || strings.Contains(found[0].Detail, "hunter2") {
+		t.Errorf("basic auth: %v", checks(found))
+	}
+
+	none := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`))
+	hs := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"1"}`))
+	req, res = exchange("https://api.test/me", http.Header{"Set-Cookie": {"t=" + hs + "." + payload + ".sig; Secure; HttpOnly"}}, 200)
+	req.Header.Set("Authorization", "Bearer "+none+"."+payload+".")
+	found = s.Headers(req, res)
+	if len(found) != 1 || found[0].Check != CheckJWTNone || found[0].Detail != "request Authorization" {
+		t.Errorf("jwt: %v", checks(found))
+	}
+}
+
+func TestBody(t *testing.T) {
+	s, _ := NewScanner([]string{CheckSecurityHeaders})
+	req, res := exchange("https://app.test/orders", http.Header{"Content-Type": {"text/html"}}, 500)
+	body := []byte("<h1>Error</h1><pre>Traceback (most recent call last):\n  File \"app.py\"</pre>" +
+		`<script src="http://cdn.test/a.js"></script><img src='http://img.test/x.png'>`)
+	got := checks(s.Body(req, res, body))
+	want := []string{
+		"verbose-error: 500 response exposes a Python traceback (Python traceback)",
+		"mixed-content: HTTPS page loads content over plain HTTP (http://cdn.test/a.js, http://img.test/x.png)",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("findings:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if found := s.Headers(req, res); len(found) != 0 {
+		t.Errorf("a disabled check ran: %v", checks(found))
+	}
+
+	_, ok := exchange("https://app.test/", http.Header{}, 200)
+	if found := verboseError(ok, []byte("Traceback (most recent call last)")); len(found) != 0 {
+		t.Error("a successful response was flagged as an error")
+	}
+	if _, err := NewScanner([]string{"nope"}); err == nil {
+		t.Error("NewScanner accepted an unknown check")
+	}
+}
+
+func TestReport(t *testing.T) {
+	var entries []logger.Entry
+	for _, f := range []Finding{
+		{Check: CheckSecurityHeaders, Severity: Low, URL: "https://b.test/", Title: "Missing security headers"},
+		{Check: CheckBasicAuth, Severity: High, URL: "http://a.test:8080/", Title: "Basic credentials sent over plain HTTP", RequestID: "r1"},
+	} {
+		data, _ := json.Marshal(f)
+		entries = append(entries, logger.Entry{Type: "finding", Data: data})
+	}
+	entries = append(entries, logger.Entry{Type: "request", Data: json.RawMessage(`{}`)})
+	found, err := FromEntries(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 2 || found[1].Host() != "a.test" {
+		t.Fatalf("FromEntries = %+v", found)
+	}
+	if high := AtLeast(found, High); len(high) != 1 || high[0].Check != CheckBasicAuth {
+		t.Errorf("AtLeast(high) = %+v", high)
+	}
+	var buf bytes.Buffer
+	Write(&buf, found)
+	out := buf.String()
+	if !strings.HasPrefix(out, "2 findings: 1 high, 0 medium, 1 low\n") || strings.Index(out, "[high]") > strings.Index(out, "[low]") {
+		t.Errorf("report:\n%s", out)
+	}
+}
@@ -0,0 +1,88 @@
+package findings
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+
+	"github.com/standrze/rogue/internal/logger"
+)
+
+// FromEntries returns the findings recorded in session entries.
+func FromEntries(entries []logger.Entry) ([]Finding, error) {
+	var out []Finding
+	for _, e := range entries {
+		if e.Type != "finding" {
+			continue
+		}
+		var f Finding
+		if err := json.Unmarshal(e.Data, &f); err != nil {
+			return nil, err
+		}
+		out = append(out, f)
+	}
+	return out, nil
+}
+
+// rank orders severities from most to least severe.
+func rank(severity string) int {
+	switch severity {
+	case High:
+		return 0
+	case Medium:
+		return 1
+	case Low:
+		return 2
+	}
+	return 3
+}
+
+// AtLeast returns the findings at least as severe as severity.
+func AtLeast(found []Finding, severity string) []Finding {
+	return slices.DeleteFunc(slices.Clone(found), func(f Finding) bool { return rank(f.Severity) > rank(severity) })
+}
+
+// Sort orders findings by severity, then host, check and title.
+func Sort(found []Finding) {
+	sort.SliceStable(found, func(i, j int) bool {
+		a, b := found[i], found[j]
+		if rank(a.Severity) != rank(b.Severity) {
+			return rank(a.Severity) < rank(b.Severity)
+		}
+		if a.Host() != b.Host() {
+			return a.Host() < b.Host()
+		}
+		if a.Check != b.Check {
+			return a.Check < b.Check
+		}
+		return a.Title < b.Title
+	})
+}
+
+// Write prints findings as a report, most severe first.
+func Write(w io.Writer, found []Finding) {
+	if len(found) == 0 {
+		fmt.Fprintln(w, "No findings")
+		return
+	}
+	found = slices.Clone(found)
+	Sort(found)
+	counts := map[string]int{}
+	for _, f := range found {
+		counts[f.Severity]++
+	}
+	fmt.Fprintf(w, "%d findings: %d high, %d medium, %d low\n", len(found), counts[High], counts[Medium], counts[Low])
+	for _, f := range found {
+		fmt.Fprintf(w, "\n[%s] %s\n  %s\n", f.Severity, f.Title, f.URL)
+		if f.Detail != "" {
+			fmt.Fprintf(w, "  %s\n", f.Detail)
+		}
+		fmt.Fprintf(w, "  check %s", f.Check)
+		if f.RequestID != "" {
+			fmt.Fprintf(w, ", flow %s", f.RequestID)
+		}
+		fmt.Fprintln(w)
+	}
+}
@@ -0,0 +1,161 @@
+// Package fuzz mutates positions of a captured request with payloads,
+// sends the variants and flags responses that differ from the original's.
+//
+// Positions are attacked one at a time, each with every payload, while the
+// others keep their captured values (Burp Suite's "sniper" attack).
+package fuzz
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/standrze/rogue/internal/rawhttp"
+)
+
+// Position is a part of a request that payloads replace.
+type Position struct {
+	// Kind is "header", "query" or "json".
+	Kind string
+	// Name is the header or query parameter name, or for JSON a dotted path
+	// such as user.id or items.0.name.
+	Name string
+}
+
+func (p Position) String() string { return p.Kind + ":" + p.Name }
+
+// ParsePosition parses kind:name, such as header:X-Api-Key, query:id or
+// json:user.id.
+func ParsePosition(s string) (Position, error) {
+	kind, name, ok := strings.Cut(s, ":")
+	kind = strings.ToLower(kind)
+	if !ok || name == "" {
+		return Position{}, fmt.Errorf("invalid position %q (want header:NAME, query:NAME or json:PATH)", s)
+	}
+	switch kind {
+	case "header", "query", "json":
+	default:
+		return Position{}, fmt.Errorf("invalid position %q: unknown kind %q (want header, query or json)", s, kind)
+	}
+	return Position{Kind: kind, Name: name}, nil
+}
+
+// Apply returns a copy of r with payload at p and Content-Length fixed.
+func Apply(r *rawhttp.Request, p Position, payload string) (*rawhttp.Request, error) {
+	out := *r
+	out.Headers = append([]rawhttp.Header(nil), r.Headers...)
+	switch p.Kind {
+	case "header":
+		out.Set(p.Name, payload)
+	case "query":
+		out.Target = setQuery(r.Target, p.Name, payload)
+	case "json":
+		body, err := setJSON(r.Body, p.Name, payload)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", p, err)
+		}
+		out.Body = body
+		out.FixContentLength()
+	}
+	return &out, nil
+}
+
+// setQuery replaces the value of the query parameter name in target, or
+// adds it, keeping the order and encoding of the other parameters.
+func setQuery(target, name, value string) string {
+	path, query, _ := strings.Cut(target, "?")
+	query, frag, hasFrag := strings.Cut(query, "#")
+	param := url.QueryEscape(name) + "=" + url.QueryEscape(value)
+	var params []string
+	found := false
+	if query != "" {
+		params = strings.Split(query, "&")
+	}
+	for i, kv := range params {
+		k, _, _ := strings.Cut(kv, "=")
+		if k, err := url.QueryUnescape(k); err == nil && k == name {
+			params[i] = param
+			found = true
+		}
+	}
+	if !found {
+		params = append(params, param)
+	}
+	out := path + "?" + strings.Join(params, "&")
+	if hasFrag {
+		out += "#" + frag
+	}
+	return out
+}
+
+// setJSON sets the field at a dotted path of a JSON body. A payload
+// replacing a string stays a string; otherwise a payload that is itself a
+// JSON number, boolean or null is written as such.
+func setJSON(body []byte, path, payload string) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var doc any
+	if err := dec.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("the body is not JSON: %w", err)
+	}
+	keys := strings.Split(path, ".")
+	parent := doc
+	for i, key := range keys {
+		last := i == len(keys)-1
+		switch v := parent.(type) {
+		case map[string]any:
+			old, ok := v[key]
+			if !ok {
+				return nil, fmt.Errorf("no field %q", strings.Join(keys[:i+1], "."))
+			}
+			if last {
+				v[key] = jsonValue(old, payload)
+			}
+			parent = old
+		case []any:
+			n, err := strconv.Atoi(key)
+			if err != nil || n < 0 || n >= len(v) {
+				return nil, fmt.Errorf("no element %q", strings.Join(keys[:i+1], "."))
+			}
+			if last {
+				v[n] = jsonValue(v[n], payload)
+			}
+			parent = v[n]
+		default:
+			return nil, fmt.Errorf("%q is not an object or array", strings.Join(keys[:i], "."))
+		}
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(doc); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+func jsonValue(old any, payload string) any {
+	if _, ok := old.(string); ok {
+		return payload
+	}
+	if payload == "true" || payload == "false" || payload == "null" {
+		return json.RawMessage(payload)
+	}
+	if _, err := strconv.ParseFloat(payload, 64); err == nil {
+		return json.Number(payload)
+	}
+	return payload
+}
+
+// Check reports whether every position applies to r.
+func Check(r *rawhttp.Request, positions []Position) error {
+	for _, p := range positions {
+		if _, err := Apply(r, p, "x"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
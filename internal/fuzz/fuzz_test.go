@@ -0,0 +1,143 @@
+package fuzz
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/standrze/rogue/internal/rawhttp"
+)
+
+func request(t *testing.T, raw string) *rawhttp.Request {
+	t.Helper()
+	r, err := rawhttp.Parse([]byte(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestApply(t *testing.T) {
+	r := request(t, "POST /api?b=2&id=7#top HTTP/1.1\r\nHost: a.test\r\nX-Api-Key: k\r\nContent-Length: 40\r\n\r\n"+
+		`{"user":{"id":7,"name":"ann"},"items":[{"q":1}]}`)
+
+	tests := []struct {
+		pos, payload, want string
+	}{
+		{"header:x-api-key", "evil", "X-Api-Key: evil\r\n"},
+		{"query:id", "1 OR 1=1", "POST /api?b=2&id=1+OR+1%3D1#top "},
+		{"query:new", "x", "POST /api?b=2&id=7&new=x#top "},
+		{"json:user.id", "8", `{"items":[{"q":1}],"user":{"id":8,"name":"ann"}}`},
+		{"json:user.id", "abc", `"user":{"id":"abc"`},
+		{"json:user.name", "42", `"name":"42"`},
+		{"json:items.0.q", "null", `"items":[{"q":null}]`},
+		{"json:user.name", "<b>", `"name":"<b>"`},
+	}
+	for _, tt := range tests {
+		p, err := ParsePosition(tt.pos)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := Apply(r, p, tt.payload)
+		if err != nil {
+			t.Errorf("%s=%q: %v", tt.pos, tt.payload, err)
+			continue
+		}
+		if got := string(out.Bytes(out.Target)); !strings.Contains(got, tt.want) {
+			t.Errorf("%s=%q gave\n%s\nwant it to contain %q", tt.pos, tt.payload, got, tt.want)
+		}
+	}
+
+	out, _ := Apply(r, Position{"json", "user.id"}, "12345")
+	if got := out.Get("Content-Length"); got != "52" {
+		t.Errorf("Content-Length = %s, want 52", got)
+	}
+	if r.Get("X-Api-Key") != "k" || !strings.Contains(string(r.Body), `"id":7`) {
+		t.Error("Apply changed the original request")
+	}
+
+	for _, pos := range []string{"json:user.missing", "json:items.5", "json:user.id.x"} {
+		p, _ := ParsePosition(pos)
+		if err := Check(r, []Position{p}); err == nil {
+			t.Errorf("Check accepted %s", pos)
+		}
+	}
+	for _, pos := range []string{"cookie:a", "header:", "query"} {
+		if _, err := ParsePosition(pos); err == nil {
+			t.Errorf("ParsePosition accepted %q", pos)
+		}
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	tests := map[string]string{
+		"range:1-5":    "1,2,3,4,5",
+		"range:0-10:5": "0,5,10",
+		"repeat:A:8":   "A,AA,AAAA,AAAAAAAA",
+		"repeat:a:b:2": "a:b,a:ba:b",
+	}
+	for spec, want := range tests {
+		got, err := Generate(spec)
+		if err != nil {
+			t.Errorf("%s: %v", spec, err)
+			continue
+		}
+		if strings.Join(got, ",") != want {
+			t.Errorf("%s = %v, want %s", spec, got, want)
+		}
+	}
+	if got, _ := Generate("special"); len(got) == 0 {
+		t.Error("special made no payloads")
+	}
+	for _, spec := range []string{"range:5-1", "range:1-x", "range:0-1000000", "repeat:A", "repeat:A:0", "words"} {
+		if _, err := Generate(spec); err == nil {
+			t.Errorf("Generate accepted %q", spec)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch id := r.URL.Query().Get("id"); id {
+		case "1":
+			w.Write([]byte("ok"))
+		case "2":
+			w.Write([]byte(strings.Repeat("x", 500)))
+		case "3":
+			http.Error(w, "no", http.StatusForbidden)
+		default:
+			w.Write([]byte("id" + id))
+		}
+	}))
+	defer origin.Close()
+
+	r := request(t, "GET "+origin.URL+"/?id=1 HTTP/1.1\r\nHost: "+strings.TrimPrefix(origin.URL, "http://")+"\r\n\r\n")
+	var tagged atomic.Int32
+	rn := &Runner{Concurrency: 2, Prepare: func(r *rawhttp.Request) { r.Set("X-Rogue-Tag", "fuzz"); tagged.Add(1) }}
+	var reported int
+	base, results, err := rn.Run(context.Background(), r, []Position{{"query", "id"}}, []string{"1", "2", "3", "long-reflected"}, func(Result) { reported++ })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if base.Status != 200 || base.Size != 2 {
+		t.Errorf("baseline = %+v", base)
+	}
+	if len(results) != 4 || reported != 5 || tagged.Load() != 5 {
+		t.Fatalf("results = %d, reported = %d, tagged = %d", len(results), reported, tagged.Load())
+	}
+	want := []bool{false, true, true, false}
+	for i, res := range results {
+		if res.N != i+1 || res.Position != "query:id" || res.Anomalous != want[i] {
+			t.Errorf("result %d = %+v, want anomalous %v", i, res, want[i])
+		}
+	}
+	if !results[3].Reflected || results[0].Reflected {
+		t.Errorf("reflection: %+v, %+v", results[0], results[3])
+	}
+	if results[1].SizeDelta != 498 {
+		t.Errorf("size delta = %d, want 498", results[1].SizeDelta)
+	}
+}
@@ -0,0 +1,103 @@
+package fuzz
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// MaxPayloads bounds the payloads of a run, so a typo in a range does not
+// send millions of requests.
+const MaxPayloads = 100000
+
+// special probes for common injection and parsing flaws.
+var special = []string{
+	"", " ", "'", `"`, "`", "\\", ";", "|", "&", "<", ">", "%00", "%0d%0a",
+	"../../../../etc/passwd", "..\\..\\..\\windows\\win.ini",
+	"' OR '1'='1", `" OR "1"="1`, "1;SELECT 1", "<script>alert(1)</script>",
+	"{{7*7}}", "${7*7}", "<%= 7*7 %>", "$(id)", "-1", "0", "2147483648",
+	"9999999999999999999", "1e309", "NaN", "null", "true", "[]", "{}",
+}
+
+// ReadWordlist returns the non-empty lines of a wordlist file, or of
+// standard input for "-". Lines starting with # are comments.
+func ReadWordlist(name string) ([]string, error) {
+	var r io.Reader = os.Stdin
+	if name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	var words []string
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		line := strings.TrimSuffix(sc.Text(), "\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		words = append(words, line)
+		if len(words) > MaxPayloads {
+			return nil, fmt.Errorf("%s has more than %d payloads", name, MaxPayloads)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return words, nil
+}
+
+// Generate returns the payloads of a generator:
+//
+//	range:FROM-TO[:STEP]  integers from FROM to TO
+//	repeat:TEXT:MAX       TEXT repeated 1, 2, 4, ... up to MAX times
+//	special               probes for injection and parsing flaws
+func Generate(spec string) ([]string, error) {
+	kind, arg, _ := strings.Cut(spec, ":")
+	switch kind {
+	case "special":
+		return append([]string(nil), special...), nil
+	case "range":
+		bounds, step, _ := strings.Cut(arg, ":")
+		from, to, ok := strings.Cut(bounds, "-")
+		lo, err1 := strconv.Atoi(from)
+		hi, err2 := strconv.Atoi(to)
+		by := 1
+		var err3 error
+		if step != "" {
+			by, err3 = strconv.Atoi(step)
+		}
+		if !ok || err1 != nil || err2 != nil || err3 != nil || by <= 0 || hi < lo {
+			return nil, fmt.Errorf("invalid generator %q (want range:FROM-TO[:STEP])", spec)
+		}
+		if (hi-lo)/by >= MaxPayloads {
+			return nil, fmt.Errorf("generator %q makes more than %d payloads", spec, MaxPayloads)
+		}
+		var out []string
+		for n := lo; n <= hi; n += by {
+			out = append(out, strconv.Itoa(n))
+		}
+		return out, nil
+	case "repeat":
+		i := strings.LastIndex(arg, ":")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid generator %q (want repeat:TEXT:MAX)", spec)
+		}
+		limit, err := strconv.Atoi(arg[i+1:])
+		if err != nil || limit < 1 || limit > 16<<20 || limit*i > 16<<20 {
+			return nil, fmt.Errorf("invalid generator %q (want repeat:TEXT:MAX, at most 16 MiB)", spec)
+		}
+		var out []string
+		for n := 1; n <= limit; n *= 2 {
+			out = append(out, strings.Repeat(arg[:i], n))
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("unknown generator %q (want range, repeat or special)", spec)
+}
@@ -0,0 +1,159 @@
+package fuzz
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/standrze/rogue/internal/rawhttp"
+)
+
+// Result is the response to one variant. The baseline, the request as
+// captured, has no Position.
+type Result struct {
+	N          int    `json:"n"`
+	Position   string `json:"position,omitempty"`
+	Payload    string `json:"payload"`
+	Status     int    `json:"status,omitempty"`
+	Size       int64  `json:"size"`
+	DurationMS int64  `json:"duration_ms"`
+	Error      string `json:"error,omitempty"`
+	SizeDelta  int64  `json:"size_delta"`
+	Anomalous  bool   `json:"anomalous"`
+	// Reflected marks responses that echo a payload of four bytes or more.
+	Reflected bool `json:"reflected,omitempty"`
+}
+
+const reflectLimit = 1 << 20
+
+// Runner sends the variants of a request.
+type Runner struct {
+	Options rawhttp.Options
+	// Concurrency is how many variants are in flight at once; 0 means one.
+	Concurrency int
+	// LengthDelta is how far, in bytes, a response may differ in size from
+	// the baseline, beyond the size of the payload, without being flagged.
+	LengthDelta int64
+	// Prepare, if set, is applied to a copy of every request before it is
+	// sent, as to tag it. It is called from several goroutines at once.
+	Prepare func(*rawhttp.Request)
+}
+
+// Run sends the request as it is once, as a baseline, and then once per
+// position and payload, calling report with each result as it completes.
+// It returns the baseline and the variants in order.
+func (rn *Runner) Run(ctx context.Context, r *rawhttp.Request, positions []Position, payloads []string, report func(Result)) (Result, []Result, error) {
+	if err := Check(r, positions); err != nil {
+		return Result{}, nil, err
+	}
+	base := rn.send(ctx, r, "")
+	if base.Error != "" {
+		return base, nil, fmt.Errorf("sending the request as captured: %s", base.Error)
+	}
+	if report != nil {
+		report(base)
+	}
+
+	results := make([]Result, len(positions)*len(payloads))
+	var mu sync.Mutex
+	sem := make(chan struct{}, max(rn.Concurrency, 1))
+	var wg sync.WaitGroup
+	for i, p := range positions {
+		for j, payload := range payloads {
+			if ctx.Err() != nil {
+				break
+			}
+			n := i*len(payloads) + j
+			sem <- struct{}{}
+			wg.Add(1)
+			go func() {
+				defer func() { <-sem; wg.Done() }()
+				variant, _ := Apply(r, p, payload)
+				res := rn.send(ctx, variant, payload)
+				res.N, res.Position = n+1, p.String()
+				rn.compare(base, &res)
+				results[n] = res
+				if report != nil {
+					mu.Lock()
+					report(res)
+					mu.Unlock()
+				}
+			}()
+		}
+	}
+	wg.Wait()
+	return base, results, ctx.Err()
+}
+
+// compare flags res when its status, or its size beyond the payload's own
+// length, differs from the baseline's, or when it failed.
+func (rn *Runner) compare(base Result, res *Result) {
+	res.SizeDelta = res.Size - base.Size
+	delta := res.SizeDelta
+	if delta < 0 {
+		delta = -delta
+	}
+	res.Anomalous = res.Error != "" || res.Status != base.Status || delta > rn.LengthDelta+int64(len(res.Payload))
+}
+
+func (rn *Runner) send(ctx context.Context, r *rawhttp.Request, payload string) Result {
+	if rn.Prepare != nil {
+		c := *r
+		c.Headers = append([]rawhttp.Header(nil), r.Headers...)
+		rn.Prepare(&c)
+		r = &c
+	}
+	res := Result{Payload: payload}
+	start := time.Now()
+	resp, err := rawhttp.Send(ctx, r, rn.Options)
+	if err != nil {
+		res.DurationMS, res.Error = time.Since(start).Milliseconds(), err.Error()
+		return res
+	}
+	defer resp.Body.Close()
+	// Only the start of the body is searched for the payload.
+	head, err := io.ReadAll(io.LimitReader(resp.Body, reflectLimit))
+	var rest int64
+	if err == nil {
+		rest, err = io.Copy(io.Discard, resp.Body)
+	}
+	res.Status, res.Size, res.DurationMS = resp.StatusCode, int64(len(head))+rest, time.Since(start).Milliseconds()
+	if err != nil {
+		res.Error = err.Error()
+	}
+	res.Reflected = len(payload) >= 4 && bytes.Contains(head, []byte(payload))
+	return res
+}
+
+// WriteResult prints res as one line: its number, status, size and how it
+// differs from the baseline, duration, position and payload.
+func WriteResult(w io.Writer, res Result) {
+	status := strconv.Itoa(res.Status)
+	if res.Error != "" {
+		status = "ERR"
+	}
+	var flags []string
+	if res.Anomalous {
+		flags = append(flags, "anomalous")
+	}
+	if res.Reflected {
+		flags = append(flags, "reflected")
+	}
+	if res.Error != "" {
+		flags = append(flags, res.Error)
+	}
+	where := "baseline"
+	if res.Position != "" {
+		where = res.Position + " " + strconv.Quote(res.Payload)
+	}
+	fmt.Fprintf(w, "%5d  %3s  %8d %+8d  %5dms  %s", res.N, status, res.Size, res.SizeDelta, res.DurationMS, where)
+	if len(flags) > 0 {
+		fmt.Fprintf(w, "  [%s]", strings.Join(flags, ", "))
+	}
+	fmt.Fprintln(w)
+}
@@ -0,0 +1,198 @@
+// Package gitproto summarizes git smart HTTP traffic (ref advertisements,
+// upload-pack and receive-pack exchanges) from its pkt-line framing.
+package gitproto
+
+import (
+	"bytes"
+	"errors"
+	"mime"
+	"strconv"
+	"strings"
+
+	"github.com/standrze/rogue/internal/logger"
+)
+
+// maxProgress caps how many sideband progress messages are kept.
+const maxProgress = 20
+
+// Ref is an advertised reference.
+type Ref struct {
+	Name string `json:"name"`
+	OID  string `json:"oid"`
+}
+
+// RefUpdate is a receive-pack command updating a reference.
+type RefUpdate struct {
+	Ref string `json:"ref"`
+	Old string `json:"old"`
+	New string `json:"new"`
+}
+
+// Summary is the readable form of one git smart HTTP body.
+type Summary struct {
+	Service      string      `json:"service"`
+	Kind         string      `json:"kind"`
+	Capabilities []string    `json:"capabilities,omitempty"`
+	Refs         []Ref       `json:"refs,omitempty"`
+	Command      string      `json:"command,omitempty"`
+	Args         []string    `json:"args,omitempty"`
+	Wants        []string    `json:"wants,omitempty"`
+	Haves        []string    `json:"haves,omitempty"`
+	Done         bool        `json:"done,omitempty"`
+	Updates      []RefUpdate `json:"updates,omitempty"`
+	Acks         []string    `json:"acks,omitempty"`
+	Progress     []string    `json:"progress,omitempty"`
+	Errors       []string    `json:"errors,omitempty"`
+	PackBytes    int         `json:"pack_bytes,omitempty"`
+}
+
+// Decoder implements logger.BodyDecoder for git smart HTTP content types.
+type Decoder struct{}
+
+// serviceFor maps a content type such as
+// application/x-git-upload-pack-advertisement to its service and body kind.
+func serviceFor(contentType string) (service, kind string, ok bool) {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil || !strings.HasPrefix(mt, "application/x-git-") {
+		return "", "", false
+	}
+	rest := strings.TrimPrefix(mt, "application/x-")
+	for _, k := range []string{"advertisement", "request", "result"} {
+		if s, found := strings.CutSuffix(rest, "-"+k); found {
+			return s, k, true
+		}
+	}
+	return "", "", false
+}
+
+func (Decoder) DecodeBody(meta logger.BodyMeta, body []byte) *logger.Decoded {
+	service, kind, ok := serviceFor(meta.ContentType)
+	if !ok {
+		return nil
+	}
+
+	s, err := Parse(service, kind, body)
+	d := &logger.Decoded{Format: "git", Value: s}
+	if err != nil {
+		d.Error = err.Error()
+	}
+	return d
+}
+
+// Parse summarizes a git smart HTTP body. Parsing stops at the first
+// malformed or truncated packet, keeping what was read so far.
+func Parse(service, kind string, body []byte) (*Summary, error) {
+	s := &Summary{Service: service, Kind: kind}
+
+	first := true
+	for len(body) > 0 {
+		// Pushes, and fetches without side-band, carry the pack unframed
+		// after the pkt-lines.
+		if bytes.HasPrefix(body, []byte("PACK")) {
+			s.PackBytes += len(body)
+			break
+		}
+
+		if len(body) < 4 {
+			return s, errors.New("truncated pkt-line")
+		}
+		n, err := strconv.ParseUint(string(body[:4]), 16, 16)
+		if err != nil {
+			return s, errors.New("invalid pkt-line length")
+		}
+		if n < 4 {
+			// flush-pkt, delim-pkt or response-end-pkt
+			body = body[4:]
+			continue
+		}
+		if int(n) > len(body) {
+			return s, errors.New("truncated pkt-line")
+		}
+		payload := body[4:n]
+		body = body[n:]
+
+		s.line(payload, first)
+		first = false
+	}
+
+	return s, nil
+}
+
+func (s *Summary) line(payload []byte, first bool) {
+	if s.Kind == "result" && len(payload) > 0 && payload[0] <= 3 {
+		s.sideband(payload)
+		return
+	}
+
+	text := strings.TrimSuffix(string(payload), "\n")
+
+	// Capabilities ride on the first ref after a NUL, or on the first
+	// command of a push.
+	if line, caps, found := strings.Cut(text, "\x00"); found {
+		text = line
+		s.Capabilities = append(s.Capabilities, strings.Fields(caps)...)
+	}
+
+	switch {
+	case strings.HasPrefix(text, "# service="):
+		return
+	case s.Kind == "advertisement":
+		if first && text == "version 2" {
+			s.Capabilities = append(s.Capabilities, text)
+			return
+		}
+		if oid, name, ok := strings.Cut(text, " "); ok && isOID(oid) {
+			s.Refs = append(s.Refs, Ref{Name: name, OID: oid})
+		} else {
+			s.Capabilities = append(s.Capabilities, text)
+		}
+	case strings.HasPrefix(text, "command="):
+		s.Command = strings.TrimPrefix(text, "command=")
+	case strings.HasPrefix(text, "want "):
+		s.Wants = append(s.Wants, strings.Fields(text)[1])
+	case strings.HasPrefix(text, "have "):
+		s.Haves = append(s.Haves, strings.Fields(text)[1])
+	case text == "done":
+		s.Done = true
+	case strings.HasPrefix(text, "ACK") || text == "NAK":
+		s.Acks = append(s.Acks, text)
+	case s.Service == "git-receive-pack" && s.Kind == "request":
+		if f := strings.Fields(text); len(f) == 3 && isOID(f[0]) && isOID(f[1]) {
+			s.Updates = append(s.Updates, RefUpdate{Old: f[0], New: f[1], Ref: f[2]})
+			return
+		}
+		s.Args = append(s.Args, text)
+	default:
+		s.Args = append(s.Args, text)
+	}
+}
+
+// sideband handles multiplexed upload-pack output: band 1 carries pack
+// data, 2 progress text and 3 fatal errors.
+func (s *Summary) sideband(payload []byte) {
+	band, data := payload[0], payload[1:]
+	switch band {
+	case 1:
+		s.PackBytes += len(data)
+	case 2:
+		for _, msg := range strings.FieldsFunc(string(data), func(r rune) bool { return r == '\r' || r == '\n' }) {
+			if len(s.Progress) < maxProgress {
+				s.Progress = append(s.Progress, msg)
+			}
+		}
+	case 3:
+		s.Errors = append(s.Errors, strings.TrimSpace(string(data)))
+	}
+}
+
+func isOID(s string) bool {
+	if len(s) != 40 && len(s) != 64 {
+		return false
+	}
+	for _, c := range s {
+		if !strings.ContainsRune("0123456789abcdef", c) {
+			return false
+		}
+	}
+	return true
+}
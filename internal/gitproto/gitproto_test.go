@@ -0,0 +1,114 @@
+package gitproto
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// pkt frames s as a pkt-line.
+func pkt(s string) string {
+	return fmt.Sprintf("%04x%s", len(s)+4, s)
+}
+
+func TestParse(t *testing.T) {
+	var (
+		zero = strings.Repeat("0", 40)
+		a    = strings.Repeat("a", 40)
+		b    = strings.Repeat("b", 40)
+		pack = "PACK\x00\x00\x00\x02\x00\x00\x00\x01data"
+	)
+	for _, tc := range []struct {
+		name, service, kind string
+		body                string
+		want                Summary
+		err                 string
+	}{
+		{
+			name: "advertisement", service: "git-upload-pack", kind: "advertisement",
+			body: pkt("# service=git-upload-pack\n") + "0000" +
+				pkt(a+" HEAD\x00multi_ack side-band-64k symref=HEAD:refs/heads/main\n") +
+				pkt(a+" refs/heads/main\n") + pkt(b+" refs/tags/v1\n") + "0000",
+			want: Summary{
+				Capabilities: []string{"multi_ack", "side-band-64k", "symref=HEAD:refs/heads/main"},
+				Refs:         []Ref{{Name: "HEAD", OID: a}, {Name: "refs/heads/main", OID: a}, {Name: "refs/tags/v1", OID: b}},
+			},
+		},
+		{
+			name: "protocol v2 advertisement", service: "git-upload-pack", kind: "advertisement",
+			body: pkt("version 2\n") + pkt("ls-refs=unborn\n") + pkt("fetch=shallow\n") + "0000",
+			want: Summary{Capabilities: []string{"version 2", "ls-refs=unborn", "fetch=shallow"}},
+		},
+		{
+			name: "want have done", service: "git-upload-pack", kind: "request",
+			body: pkt("want "+a+" multi_ack_detailed ofs-delta\n") + pkt("want "+b+"\n") + "0000" +
+				pkt("have "+zero+"\n") + pkt("done\n"),
+			want: Summary{Wants: []string{a, b}, Haves: []string{zero}, Done: true},
+		},
+		{
+			name: "protocol v2 command", service: "git-upload-pack", kind: "request",
+			body: pkt("command=ls-refs\n") + pkt("agent=git/2.45\n") + "0001" + pkt("peel\n") + "0000",
+			want: Summary{Command: "ls-refs", Args: []string{"agent=git/2.45", "peel"}},
+		},
+		{
+			name: "receive-pack updates", service: "git-receive-pack", kind: "request",
+			body: pkt(zero+" "+a+" refs/heads/topic\x00report-status side-band-64k\n") +
+				pkt(a+" "+b+" refs/heads/main\n") + "0000" + pack,
+			want: Summary{
+				Capabilities: []string{"report-status", "side-band-64k"},
+				Updates:      []RefUpdate{{Ref: "refs/heads/topic", Old: zero, New: a}, {Ref: "refs/heads/main", Old: a, New: b}},
+				PackBytes:    len(pack),
+			},
+		},
+		{
+			name: "sideband", service: "git-upload-pack", kind: "result",
+			body: pkt("ACK "+a+"\n") + pkt("\x01"+pack) +
+				pkt("\x02Counting objects: 1\rCounting objects: 2, done.\n") +
+				pkt("\x03remote: access denied\n") + "0000",
+			want: Summary{
+				Acks:      []string{"ACK " + a},
+				PackBytes: len(pack),
+				Progress:  []string{"Counting objects: 1", "Counting objects: 2, done."},
+				Errors:    []string{"remote: access denied"},
+			},
+		},
+		{
+			name: "unframed pack", service: "git-upload-pack", kind: "result",
+			body: pkt("NAK\n") + pack,
+			want: Summary{Acks: []string{"NAK"}, PackBytes: len(pack)},
+		},
+		{
+			name: "truncated length", service: "git-upload-pack", kind: "request",
+			body: pkt("want "+a+"\n") + "00",
+			want: Summary{Wants: []string{a}},
+			err:  "truncated pkt-line",
+		},
+		{
+			name: "truncated payload", service: "git-upload-pack", kind: "request",
+			body: pkt("want "+a+"\n") + "0032want " + b[:10],
+			want: Summary{Wants: []string{a}},
+			err:  "truncated pkt-line",
+		},
+		{
+			name: "invalid length", service: "git-upload-pack", kind: "request",
+			body: pkt("want "+a+"\n") + "zz10done",
+			want: Summary{Wants: []string{a}},
+			err:  "invalid pkt-line length",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := Parse(tc.service, tc.kind, []byte(tc.body))
+			if tc.err == "" && err != nil {
+				t.Fatal(err)
+			}
+			if tc.err != "" && (err == nil || err.Error() != tc.err) {
+				t.Errorf("err = %v, want %q", err, tc.err)
+			}
+			tc.want.Service, tc.want.Kind = tc.service, tc.kind
+			if !reflect.DeepEqual(*got, tc.want) {
+				t.Errorf("got  %+v\nwant %+v", *got, tc.want)
+			}
+		})
+	}
+}
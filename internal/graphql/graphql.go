@@ -0,0 +1,164 @@
+// Package graphql recognises GraphQL requests and extracts their operations,
+// so the many POSTs to one /graphql endpoint can be told apart in the log,
+// in filters and by rules.
+package graphql
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// MaxBody bounds how much of a request body is read to find its
+// operations. Larger requests are not recognised.
+const MaxBody = 1 << 20
+
+// Operation is one GraphQL operation of a request.
+type Operation struct {
+	// Name is the operation name, from operationName or the document. It
+	// is empty for anonymous operations.
+	Name string
+	// Type is query, mutation or subscription.
+	Type      string
+	Query     string
+	Variables json.RawMessage
+}
+
+// Operations returns the operations carried by req: the query parameters
+// of a GET, or a JSON or application/graphql POST body, batched or not.
+// The body is put back for later readers. It returns nil for requests that
+// are not GraphQL.
+func Operations(req *http.Request) []Operation {
+	if p, ok := req.Body.(*peeked); ok {
+		return p.ops
+	}
+	if req.Method == http.MethodGet {
+		return fromQuery(req.URL.Query())
+	}
+	if req.Body == nil || req.Body == http.NoBody || req.Header.Get("Content-Encoding") != "" {
+		return nil
+	}
+	mt, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if mt != "" && mt != "application/json" && mt != "application/graphql" && mt != "application/graphql+json" {
+		return nil
+	}
+
+	head, err := io.ReadAll(io.LimitReader(req.Body, MaxBody+1))
+	p := &peeked{ReadCloser: struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(head), req.Body), req.Body}}
+	req.Body = p
+	if err != nil || len(head) > MaxBody {
+		return nil
+	}
+	if mt == "application/graphql" {
+		p.ops = document("", string(head), nil)
+	} else {
+		p.ops = Parse(head)
+	}
+	return p.ops
+}
+
+// peeked is a request body that has been looked at, remembering what was
+// found so other callers need not read it again.
+type peeked struct {
+	io.ReadCloser
+	ops []Operation
+}
+
+// request is a GraphQL-over-HTTP request body.
+type request struct {
+	Query         *string         `json:"query"`
+	OperationName string          `json:"operationName"`
+	Variables     json.RawMessage `json:"variables"`
+	Extensions    struct {
+		PersistedQuery json.RawMessage `json:"persistedQuery"`
+	} `json:"extensions"`
+}
+
+// Parse returns the operations of a JSON request body, which holds one
+// request object or a batch of them. Bodies that are not GraphQL yield
+// nil.
+func Parse(body []byte) []Operation {
+	body = bytes.TrimSpace(body)
+	var batch []request
+	if len(body) > 0 && body[0] == '[' {
+		if json.Unmarshal(body, &batch) != nil {
+			return nil
+		}
+	} else {
+		var r request
+		if json.Unmarshal(body, &r) != nil {
+			return nil
+		}
+		batch = []request{r}
+	}
+
+	var ops []Operation
+	for _, r := range batch {
+		switch {
+		case r.Query != nil:
+			found := document(r.OperationName, *r.Query, r.Variables)
+			if found == nil {
+				return nil
+			}
+			ops = append(ops, found...)
+		case r.Extensions.PersistedQuery != nil:
+			// A persisted query sends its hash instead of the document.
+			ops = append(ops, Operation{Name: r.OperationName, Variables: variables(r.Variables)})
+		default:
+			return nil
+		}
+	}
+	return ops
+}
+
+func fromQuery(q url.Values) []Operation {
+	if !q.Has("query") {
+		return nil
+	}
+	return document(q.Get("operationName"), q.Get("query"), json.RawMessage(q.Get("variables")))
+}
+
+var (
+	comments = regexp.MustCompile(`#[^\n]*`)
+	// definition finds the operation definitions of a document; shorthand
+	// queries ({ ... }) have neither keyword nor name.
+	definition = regexp.MustCompile(`(?:^|[}\s])(query|mutation|subscription)\b\s*([_A-Za-z][_0-9A-Za-z]*)?`)
+	graphqlDoc = regexp.MustCompile(`^(?:\{|(?:query|mutation|subscription|fragment)\b)`)
+)
+
+// document returns the operation selected by name from the document
+// query, or nil if query does not look like GraphQL.
+func document(name, query string, vars json.RawMessage) []Operation {
+	text := strings.TrimSpace(comments.ReplaceAllString(query, ""))
+	if !graphqlDoc.MatchString(text) {
+		return nil
+	}
+	op := Operation{Name: name, Type: "query", Query: query, Variables: variables(vars)}
+	if name == "" && text[0] == '{' {
+		return []Operation{op}
+	}
+	for i, m := range definition.FindAllStringSubmatch(text, -1) {
+		if name == "" && i == 0 || name != "" && m[2] == name {
+			op.Type, op.Name = m[1], m[2]
+			break
+		}
+	}
+	return []Operation{op}
+}
+
+// variables keeps vars if they are a JSON object.
+func variables(vars json.RawMessage) json.RawMessage {
+	vars = bytes.TrimSpace(vars)
+	if len(vars) == 0 || vars[0] != '{' || !json.Valid(vars) {
+		return nil
+	}
+	return vars
+}
@@ -0,0 +1,78 @@
+package graphql
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		body string
+		want []Operation
+	}{
+		{`{"query":"query GetUser($id: ID!) { user(id: $id) { name } }","variables":{"id":"7"}}`,
+			[]Operation{{Name: "GetUser", Type: "query", Variables: []byte(`{"id":"7"}`)}}},
+		{`{"query":"# create\nmutation { addItem(id: 1) { id } }"}`,
+			[]Operation{{Type: "mutation"}}},
+		{`{"query":"{ me { name } }"}`,
+			[]Operation{{Type: "query"}}},
+		{`{"query":"query A { a } mutation B { b }","operationName":"B"}`,
+			[]Operation{{Name: "B", Type: "mutation"}}},
+		{`[{"query":"query A { a }"},{"query":"subscription OnB { b }","variables":null}]`,
+			[]Operation{{Name: "A", Type: "query"}, {Name: "OnB", Type: "subscription"}}},
+		{`{"operationName":"Feed","extensions":{"persistedQuery":{"version":1,"sha256Hash":"abc"}}}`,
+			[]Operation{{Name: "Feed"}}},
+		{`{"query":"red shoes"}`, nil},
+		{`{"item":42}`, nil},
+		{`not json`, nil},
+	}
+	for _, tt := range tests {
+		got := Parse([]byte(tt.body))
+		if len(got) != len(tt.want) {
+			t.Errorf("Parse(%s) = %+v, want %+v", tt.body, got, tt.want)
+			continue
+		}
+		for i, op := range got {
+			w := tt.want[i]
+			if op.Name != w.Name || op.Type != w.Type || string(op.Variables) != string(w.Variables) {
+				t.Errorf("Parse(%s)[%d] = %+v, want %+v", tt.body, i, op, w)
+			}
+		}
+	}
+}
+
+func TestOperations(t *testing.T) {
+	body := `{"query":"query GetUser { me { id } }"}`
+	req, _ := http.NewRequest("POST", "https://api.example.com/graphql", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if ops := Operations(req); len(ops) != 1 || ops[0].Name != "GetUser" {
+		t.Errorf("POST: %+v", ops)
+	}
+	// A second look is answered without reading the body again, and the
+	// body is still whole.
+	if ops := Operations(req); len(ops) != 1 || ops[0].Name != "GetUser" {
+		t.Errorf("second look: %+v", ops)
+	}
+	if got, _ := io.ReadAll(req.Body); string(got) != body {
+		t.Errorf("body = %q", got)
+	}
+
+	req, _ = http.NewRequest("POST", "https://api.example.com/graphql", strings.NewReader("mutation Logout { logout }"))
+	req.Header.Set("Content-Type", "application/graphql")
+	if ops := Operations(req); len(ops) != 1 || ops[0].Name != "Logout" || ops[0].Type != "mutation" {
+		t.Errorf("application/graphql: %+v", ops)
+	}
+
+	req, _ = http.NewRequest("GET", `https://api.example.com/graphql?query=query+Feed+%7B+items+%7D&variables=%7B%22n%22%3A3%7D`, nil)
+	if ops := Operations(req); len(ops) != 1 || ops[0].Name != "Feed" || string(ops[0].Variables) != `{"n":3}` {
+		t.Errorf("GET: %+v", ops)
+	}
+
+	req, _ = http.NewRequest("POST", "https://api.example.com/upload", strings.NewReader("query GetUser { a }"))
+	req.Header.Set("Content-Type", "text/plain")
+	if ops := Operations(req); ops != nil {
+		t.Errorf("text/plain: %+v", ops)
+	}
+}
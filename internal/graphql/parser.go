@@ -0,0 +1,18 @@
+package graphql
+
+import (
+	"net/http"
+
+	"github.com/standrze/rogue/internal/logger"
+)
+
+// Parser implements logger.GraphQLParser.
+type Parser struct{}
+
+func (Parser) ParseGraphQL(req *http.Request) []logger.GraphQLOperation {
+	var out []logger.GraphQLOperation
+	for _, op := range Operations(req) {
+		out = append(out, logger.GraphQLOperation{Name: op.Name, Type: op.Type, Query: op.Query, Variables: op.Variables})
+	}
+	return out
+}
@@ -0,0 +1,118 @@
+// Package grpcdecode renders gRPC message bodies for the session log, using
+// descriptors fetched through server reflection when available and the raw
+// protobuf wire format otherwise.
+package grpcdecode
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"mime"
+	"strings"
+
+	"github.com/standrze/rogue/internal/logger"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// Message is one decoded gRPC message.
+type Message struct {
+	Compressed bool            `json:"compressed,omitempty"`
+	JSON       json.RawMessage `json:"json,omitempty"`
+	Wire       []WireField     `json:"wire,omitempty"`
+	Error      string          `json:"error,omitempty"`
+}
+
+// Decoder implements logger.BodyDecoder for application/grpc bodies,
+// including gRPC-Web. Methods the Resolver has no descriptors for are
+// looked up in Schema, if set.
+type Decoder struct {
+	Resolver *Resolver
+	Schema   *Schema
+}
+
+// NewDecoder returns a decoder backed by a fresh reflection cache.
+func NewDecoder() *Decoder {
+	return &Decoder{Resolver: NewResolver()}
+}
+
+// IsGRPC reports whether contentType denotes a gRPC or gRPC-Web body.
+func IsGRPC(contentType string) bool {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mt == "application/grpc" || strings.HasPrefix(mt, "application/grpc+") ||
+		mt == "application/grpc-web" || strings.HasPrefix(mt, "application/grpc-web+")
+}
+
+func (d *Decoder) DecodeBody(meta logger.BodyMeta, body []byte) *logger.Decoded {
+	if !IsGRPC(meta.ContentType) {
+		return nil
+	}
+
+	var desc protoreflect.MessageDescriptor
+	format := "grpc-wire"
+	if d.Resolver != nil {
+		if in, out, ok := d.Resolver.Method(meta.Host, meta.Secure, meta.Path); ok {
+			desc = in
+			if meta.Response {
+				desc = out
+			}
+			format = "grpc"
+		}
+	}
+	if desc == nil {
+		if in, out, ok := d.Schema.Method(meta.Path); ok {
+			desc = in
+			if meta.Response {
+				desc = out
+			}
+			format = "grpc"
+		}
+	}
+
+	var messages []Message
+	for _, f := range ParseFrames(body) {
+		messages = append(messages, decodeFrame(f, desc))
+	}
+
+	return &logger.Decoded{Format: format, Value: messages}
+}
+
+func decodeFrame(f Frame, desc protoreflect.MessageDescriptor) Message {
+	m := Message{Compressed: f.Compressed}
+
+	data := f.Data
+	if f.Compressed {
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			m.Error = "unsupported compression"
+			return m
+		}
+		if data, err = io.ReadAll(zr); err != nil {
+			m.Error = err.Error()
+			return m
+		}
+	}
+
+	if desc != nil {
+		msg := dynamicpb.NewMessage(desc)
+		if err := proto.Unmarshal(data, msg); err == nil {
+			if out, err := protojson.Marshal(msg); err == nil {
+				m.JSON = out
+				return m
+			}
+		}
+	}
+
+	wire, err := DecodeWire(data)
+	if err != nil {
+		m.Error = err.Error()
+	}
+	m.Wire = wire
+	return m
+}
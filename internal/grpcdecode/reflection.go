@@ -0,0 +1,226 @@
+package grpcdecode
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	rpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// failureTTL is how long a host that could not be reflected is left alone
+// before trying again.
+const failureTTL = 5 * time.Minute
+
+// reflectTimeout bounds a single reflection round trip.
+const reflectTimeout = 10 * time.Second
+
+type serviceEntry struct {
+	files    *protoregistry.Files
+	err      error
+	fetched  time.Time
+	inFlight bool
+}
+
+// Resolver fetches and caches service descriptors from upstream servers via
+// the gRPC reflection service, per host and service. Lookups never block on
+// the network; a miss starts a background fetch and the caller falls back
+// to wire-format output.
+type Resolver struct {
+	// Dial, if set, connects to servers instead of net.Dialer, so lookups
+	// take the same route as the proxied traffic.
+	Dial func(ctx context.Context, network, addr string) (net.Conn, error)
+	// TLSConfig, if set, returns the client config for a server; by default
+	// its certificate is verified against the system roots.
+	TLSConfig func(host string) *tls.Config
+
+	mu       sync.Mutex
+	services map[string]*serviceEntry
+}
+
+// NewResolver returns an empty descriptor cache.
+func NewResolver() *Resolver {
+	return &Resolver{services: make(map[string]*serviceEntry)}
+}
+
+// Method returns the input and output types of fullMethod
+// ("/pkg.Service/Method") on host, if they have been fetched already.
+func (r *Resolver) Method(host string, secure bool, fullMethod string) (in, out protoreflect.MessageDescriptor, ok bool) {
+	service, method, found := strings.Cut(strings.TrimPrefix(fullMethod, "/"), "/")
+	if !found {
+		return nil, nil, false
+	}
+
+	key := host + "/" + service
+	r.mu.Lock()
+	e, cached := r.services[key]
+	if !cached || (e.err != nil && !e.inFlight && time.Since(e.fetched) > failureTTL) {
+		if !cached {
+			e = &serviceEntry{}
+			r.services[key] = e
+		}
+		e.inFlight = true
+		go r.fetch(host, secure, service, e)
+	}
+	files := e.files
+	r.mu.Unlock()
+
+	if files == nil {
+		return nil, nil, false
+	}
+	return methodTypes(files, service, method)
+}
+
+// methodTypes returns the input and output types of service's method as
+// declared in files.
+func methodTypes(files *protoregistry.Files, service, method string) (in, out protoreflect.MessageDescriptor, ok bool) {
+	d, err := files.FindDescriptorByName(protoreflect.FullName(service))
+	if err != nil {
+		return nil, nil, false
+	}
+	sd, isService := d.(protoreflect.ServiceDescriptor)
+	if !isService {
+		return nil, nil, false
+	}
+	md := sd.Methods().ByName(protoreflect.Name(method))
+	if md == nil {
+		return nil, nil, false
+	}
+	return md.Input(), md.Output(), true
+}
+
+func (r *Resolver) fetch(host string, secure bool, service string, e *serviceEntry) {
+	files, err := r.reflectFiles(host, secure, service)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	e.inFlight = false
+	e.fetched = time.Now()
+	e.err = err
+	if err == nil {
+		e.files = files
+	}
+}
+
+// reflectFiles asks host for the file declaring service and every file it
+// transitively imports.
+func (r *Resolver) reflectFiles(host string, secure bool, service string) (*protoregistry.Files, error) {
+	addr := host
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		if secure {
+			addr = net.JoinHostPort(host, "443")
+		} else {
+			addr = net.JoinHostPort(host, "80")
+		}
+	}
+	creds := insecure.NewCredentials()
+	if secure {
+		name, _, _ := net.SplitHostPort(addr)
+		cfg := &tls.Config{ServerName: name}
+		if r.TLSConfig != nil {
+			cfg = r.TLSConfig(name)
+		}
+		creds = credentials.NewTLS(cfg)
+	}
+	opts := []grpc.DialOption{grpc.WithTransportCredentials(creds)}
+	if r.Dial != nil {
+		opts = append(opts, grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			return r.Dial(ctx, "tcp", addr)
+		}))
+	}
+
+	// The passthrough scheme hands the address to the dialer unresolved,
+	// so DNS overrides apply.
+	conn, err := grpc.NewClient("passthrough:///"+addr, opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), reflectTimeout)
+	defer cancel()
+
+	stream, err := rpb.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.CloseSend()
+
+	protos := make(map[string]*descriptorpb.FileDescriptorProto)
+	request := func(req *rpb.ServerReflectionRequest) error {
+		if err := stream.Send(req); err != nil {
+			return err
+		}
+		res, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		if errRes := res.GetErrorResponse(); errRes != nil {
+			return fmt.Errorf("reflection: %s", errRes.GetErrorMessage())
+		}
+		for _, raw := range res.GetFileDescriptorResponse().GetFileDescriptorProto() {
+			fd := &descriptorpb.FileDescriptorProto{}
+			if err := proto.Unmarshal(raw, fd); err != nil {
+				return err
+			}
+			protos[fd.GetName()] = fd
+		}
+		return nil
+	}
+
+	if err := request(&rpb.ServerReflectionRequest{
+		MessageRequest: &rpb.ServerReflectionRequest_FileContainingSymbol{FileContainingSymbol: service},
+	}); err != nil {
+		return nil, err
+	}
+
+	// Servers may omit dependencies they assume the client already has, such
+	// as the well-known types, which are taken from the linked-in registry.
+	for {
+		var missing []string
+		for _, fd := range protos {
+			for _, dep := range fd.GetDependency() {
+				if _, ok := protos[dep]; ok {
+					continue
+				}
+				if known, err := protoregistry.GlobalFiles.FindFileByPath(dep); err == nil {
+					protos[dep] = protodesc.ToFileDescriptorProto(known)
+					continue
+				}
+				missing = append(missing, dep)
+			}
+		}
+		if len(missing) == 0 {
+			break
+		}
+		for _, dep := range missing {
+			if err := request(&rpb.ServerReflectionRequest{
+				MessageRequest: &rpb.ServerReflectionRequest_FileByFilename{FileByFilename: dep},
+			}); err != nil {
+				return nil, err
+			}
+			if _, ok := protos[dep]; !ok {
+				return nil, fmt.Errorf("reflection: server did not return %s", dep)
+			}
+		}
+	}
+
+	set := &descriptorpb.FileDescriptorSet{}
+	for _, fd := range protos {
+		set.File = append(set.File, fd)
+	}
+	return protodesc.NewFiles(set)
+}
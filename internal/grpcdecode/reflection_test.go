@@ -0,0 +1,66 @@
+package grpcdecode
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+func TestResolverMethod(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer()
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+	reflection.Register(srv)
+	go srv.Serve(l)
+	defer srv.Stop()
+
+	// The host name only resolves through the dialer.
+	var dials atomic.Int32
+	r := NewResolver()
+	r.Dial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if addr != "grpc.test:50051" {
+			t.Errorf("dialed %s", addr)
+		}
+		dials.Add(1)
+		return (&net.Dialer{}).DialContext(ctx, network, l.Addr().String())
+	}
+
+	// Each service on the host is fetched when it is first seen.
+	for _, tc := range []struct{ method, in, out string }{
+		{"/grpc.health.v1.Health/Check", "grpc.health.v1.HealthCheckRequest", "grpc.health.v1.HealthCheckResponse"},
+		{"/grpc.reflection.v1.ServerReflection/ServerReflectionInfo", "grpc.reflection.v1.ServerReflectionRequest", "grpc.reflection.v1.ServerReflectionResponse"},
+	} {
+		var in, out protoreflect.MessageDescriptor
+		ok := false
+		for deadline := time.Now().Add(10 * time.Second); !ok && time.Now().Before(deadline); {
+			if in, out, ok = r.Method("grpc.test:50051", false, tc.method); !ok {
+				time.Sleep(20 * time.Millisecond)
+			}
+		}
+		if !ok {
+			t.Fatalf("%s was not resolved", tc.method)
+		}
+		if string(in.FullName()) != tc.in || string(out.FullName()) != tc.out {
+			t.Errorf("%s: types %s, %s", tc.method, in.FullName(), out.FullName())
+		}
+	}
+	if n := dials.Load(); n != 2 {
+		t.Errorf("%d dials, want one per service", n)
+	}
+
+	// Unknown methods of a fetched service stay unresolved.
+	if _, _, ok := r.Method("grpc.test:50051", false, "/grpc.health.v1.Health/Nope"); ok {
+		t.Error("unknown method resolved")
+	}
+}
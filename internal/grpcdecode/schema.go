@@ -0,0 +1,172 @@
+package grpcdecode
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/standrze/rogue/internal/logger"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// Schema holds message and service descriptors loaded from descriptor set
+// files, as written by protoc --include_imports --descriptor_set_out. A nil
+// Schema knows no types.
+type Schema struct {
+	files *protoregistry.Files
+}
+
+// LoadSchema reads the descriptor sets at paths. A file may repeat one
+// already read, as sets generated for separate services often share
+// imports.
+func LoadSchema(paths ...string) (*Schema, error) {
+	set := &descriptorpb.FileDescriptorSet{}
+	seen := make(map[string]bool)
+	for _, p := range paths {
+		raw, err := os.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+		var fds descriptorpb.FileDescriptorSet
+		if err := proto.Unmarshal(raw, &fds); err != nil {
+			return nil, fmt.Errorf("%s: not a descriptor set: %w", p, err)
+		}
+		for _, fd := range fds.GetFile() {
+			if !seen[fd.GetName()] {
+				seen[fd.GetName()] = true
+				set.File = append(set.File, fd)
+			}
+		}
+	}
+	files, err := protodesc.NewFiles(set)
+	if err != nil {
+		return nil, err
+	}
+	return &Schema{files: files}, nil
+}
+
+// Message returns the message type called name, e.g. "acme.v1.User".
+func (s *Schema) Message(name string) (protoreflect.MessageDescriptor, bool) {
+	if s == nil {
+		return nil, false
+	}
+	d, err := s.files.FindDescriptorByName(protoreflect.FullName(name))
+	if err != nil {
+		return nil, false
+	}
+	md, ok := d.(protoreflect.MessageDescriptor)
+	return md, ok
+}
+
+// Method returns the input and output types of fullMethod
+// ("/pkg.Service/Method").
+func (s *Schema) Method(fullMethod string) (in, out protoreflect.MessageDescriptor, ok bool) {
+	if s == nil {
+		return nil, nil, false
+	}
+	service, method, found := strings.Cut(strings.TrimPrefix(fullMethod, "/"), "/")
+	if !found {
+		return nil, nil, false
+	}
+	return methodTypes(s.files, service, method)
+}
+
+// MessageType names the message types of the protobuf bodies sent to and
+// received from matching URLs. Host and Path are globs as in path.Match;
+// empty ones match anything.
+type MessageType struct {
+	Host     string
+	Path     string
+	Request  string
+	Response string
+}
+
+func (t MessageType) matches(host, urlPath string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if t.Host != "" {
+		if ok, _ := path.Match(strings.ToLower(t.Host), strings.ToLower(host)); !ok {
+			return false
+		}
+	}
+	if t.Path != "" {
+		if ok, _ := path.Match(t.Path, urlPath); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// protobufTypes are the media types of plain protobuf bodies.
+var protobufTypes = map[string]bool{
+	"application/x-protobuf":          true,
+	"application/protobuf":            true,
+	"application/vnd.google.protobuf": true,
+}
+
+// ProtobufDecoder implements logger.BodyDecoder for plain protobuf bodies
+// whose type is known: named by the messageType or proto parameter of the
+// Content-Type, or by the first of Types matching the URL. Other bodies are
+// left to the schema-less decoder.
+type ProtobufDecoder struct {
+	Schema *Schema
+	Types  []MessageType
+}
+
+func (d *ProtobufDecoder) DecodeBody(meta logger.BodyMeta, body []byte) *logger.Decoded {
+	mt, params, err := mime.ParseMediaType(meta.ContentType)
+	if err != nil || !protobufTypes[mt] {
+		return nil
+	}
+	desc, ok := d.messageType(meta, params)
+	if !ok {
+		return nil
+	}
+
+	decoded := &logger.Decoded{Format: "protobuf"}
+	msg := dynamicpb.NewMessage(desc)
+	if err := proto.Unmarshal(body, msg); err != nil {
+		decoded.Error = fmt.Sprintf("%s: %v", desc.FullName(), err)
+		return decoded
+	}
+	out, err := protojson.Marshal(msg)
+	if err != nil {
+		decoded.Error = err.Error()
+		return decoded
+	}
+	decoded.Value = json.RawMessage(out)
+	return decoded
+}
+
+func (d *ProtobufDecoder) messageType(meta logger.BodyMeta, params map[string]string) (protoreflect.MessageDescriptor, bool) {
+	for _, p := range []string{"messagetype", "proto"} {
+		if name := params[p]; name != "" {
+			return d.Schema.Message(name)
+		}
+	}
+	for _, t := range d.Types {
+		if !t.matches(meta.Host, meta.Path) {
+			continue
+		}
+		name := t.Request
+		if meta.Response {
+			name = t.Response
+		}
+		if name == "" {
+			return nil, false
+		}
+		return d.Schema.Message(name)
+	}
+	return nil, false
+}
@@ -0,0 +1,97 @@
+package grpcdecode
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/standrze/rogue/internal/logger"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+const usersProto = `
+file {
+  name: "acme/v1/users.proto"
+  package: "acme.v1"
+  syntax: "proto3"
+  message_type {
+    name: "User"
+    field { name: "name" number: 1 label: LABEL_OPTIONAL type: TYPE_STRING json_name: "name" }
+    field { name: "id" number: 2 label: LABEL_OPTIONAL type: TYPE_INT32 json_name: "id" }
+  }
+  service {
+    name: "Users"
+    method { name: "Get" input_type: ".acme.v1.User" output_type: ".acme.v1.User" }
+  }
+}`
+
+func testSchema(t *testing.T) *Schema {
+	t.Helper()
+	var set descriptorpb.FileDescriptorSet
+	if err := prototext.Unmarshal([]byte(usersProto), &set); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := proto.Marshal(&set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "users.pb")
+	if err := os.WriteFile(path, raw, 0644); err != nil {
+		t.Fatal(err)
+	}
+	// The same set twice, as when two services share imports.
+	s, err := LoadSchema(path, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func decodedJSON(t *testing.T, d *logger.Decoded) string {
+	t.Helper()
+	if d == nil {
+		t.Fatal("body not decoded")
+	}
+	out, err := json.Marshal(d.Value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func TestProtobufDecoder(t *testing.T) {
+	s := testSchema(t)
+	// name = "ada", id = 7
+	body := []byte{0x0a, 0x03, 'a', 'd', 'a', 0x10, 0x07}
+
+	d := &ProtobufDecoder{Schema: s, Types: []MessageType{{Host: "api.example.com", Path: "/users/*", Response: "acme.v1.User"}}}
+	got := d.DecodeBody(logger.BodyMeta{ContentType: `application/x-protobuf; messageType="acme.v1.User"`}, body)
+	if js := decodedJSON(t, got); got.Format != "protobuf" || js != `{"name":"ada","id":7}` {
+		t.Errorf("by Content-Type: %s %s", got.Format, js)
+	}
+	got = d.DecodeBody(logger.BodyMeta{Response: true, ContentType: "application/x-protobuf", Host: "api.example.com:443", Path: "/users/7"}, body)
+	if js := decodedJSON(t, got); js != `{"name":"ada","id":7}` {
+		t.Errorf("by URL: %s", js)
+	}
+	// Requests to the same URL have no type, so the schema-less decoder
+	// gets them.
+	if got := d.DecodeBody(logger.BodyMeta{ContentType: "application/x-protobuf", Host: "api.example.com", Path: "/users/7"}, body); got != nil {
+		t.Errorf("untyped request decoded: %+v", got)
+	}
+	if got := d.DecodeBody(logger.BodyMeta{ContentType: "application/x-protobuf; proto=acme.v1.User"}, []byte{0x0a, 0x09}); got == nil || got.Error == "" {
+		t.Errorf("corrupt body: %+v", got)
+	}
+}
+
+func TestDecoderSchemaFallback(t *testing.T) {
+	d := &Decoder{Schema: testSchema(t)}
+	msg := []byte{0x0a, 0x03, 'a', 'd', 'a'}
+	body := append([]byte{0, 0, 0, 0, byte(len(msg))}, msg...)
+	got := d.DecodeBody(logger.BodyMeta{ContentType: "application/grpc", Path: "/acme.v1.Users/Get"}, body)
+	if js := decodedJSON(t, got); got.Format != "grpc" || js != `[{"json":{"name":"ada"}}]` {
+		t.Errorf("got %s %s", got.Format, js)
+	}
+}
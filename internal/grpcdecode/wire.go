@@ -0,0 +1,205 @@
+package grpcdecode
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxDepth limits how deep length-delimited fields are speculatively parsed
+// as nested messages.
+const maxDepth = 8
+
+// WireField is a schema-less rendering of one protobuf field.
+type WireField struct {
+	Field int    `json:"field"`
+	Type  string `json:"type"`
+	Value any    `json:"value"`
+}
+
+// Frame is a single length-prefixed gRPC message.
+type Frame struct {
+	Compressed bool
+	Data       []byte
+}
+
+// ParseFrames splits a gRPC body into its messages. A trailing partial frame,
+// as left by body truncation, is dropped.
+func ParseFrames(body []byte) []Frame {
+	var frames []Frame
+	for len(body) >= 5 {
+		n := binary.BigEndian.Uint32(body[1:5])
+		if uint64(len(body)-5) < uint64(n) {
+			break
+		}
+		frames = append(frames, Frame{Compressed: body[0] == 1, Data: body[5 : 5+n]})
+		body = body[5+n:]
+	}
+	return frames
+}
+
+// DecodeWire renders a protobuf message without its schema. Length-delimited
+// fields are shown as nested messages when they parse as such, otherwise as
+// text or hex.
+func DecodeWire(b []byte) ([]WireField, error) {
+	return decodeWire(b, 0)
+}
+
+func decodeWire(b []byte, depth int) ([]WireField, error) {
+	var fields []WireField
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return nil, errors.New("invalid field key")
+		}
+		b = b[n:]
+
+		num, wt := int(key>>3), key&7
+		if num == 0 {
+			return nil, errors.New("invalid field number 0")
+		}
+
+		switch wt {
+		case 0:
+			v, n := binary.Uvarint(b)
+			if n <= 0 {
+				return nil, errors.New("invalid varint")
+			}
+			b = b[n:]
+			fields = append(fields, WireField{Field: num, Type: "varint", Value: v})
+		case 1:
+			if len(b) < 8 {
+				return nil, errors.New("truncated fixed64")
+			}
+			fields = append(fields, WireField{Field: num, Type: "fixed64", Value: binary.LittleEndian.Uint64(b)})
+			b = b[8:]
+		case 2:
+			l, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < l {
+				return nil, errors.New("truncated length-delimited field")
+			}
+			data := b[n : n+int(l)]
+			b = b[n+int(l):]
+			fields = append(fields, lengthDelimited(num, data, depth))
+		case 5:
+			if len(b) < 4 {
+				return nil, errors.New("truncated fixed32")
+			}
+			fields = append(fields, WireField{Field: num, Type: "fixed32", Value: binary.LittleEndian.Uint32(b)})
+			b = b[4:]
+		default:
+			return nil, fmt.Errorf("unsupported wire type %d", wt)
+		}
+	}
+	return fields, nil
+}
+
+// lengthDelimited guesses what a length-delimited field holds. Printable text
+// is preferred over a nested message because short strings frequently parse
+// as valid messages too.
+func lengthDelimited(num int, data []byte, depth int) WireField {
+	if isPrintable(data) {
+		return WireField{Field: num, Type: "string", Value: string(data)}
+	}
+	if depth < maxDepth && len(data) > 0 {
+		if nested, err := decodeWire(data, depth+1); err == nil {
+			return WireField{Field: num, Type: "message", Value: nested}
+		}
+	}
+	return WireField{Field: num, Type: "bytes", Value: hex.EncodeToString(data)}
+}
+
+func isPrintable(data []byte) bool {
+	if !utf8.Valid(data) {
+		return false
+	}
+	for _, r := range string(data) {
+		if !unicode.IsPrint(r) && !unicode.IsSpace(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// EncodeWire is the inverse of DecodeWire. Nested messages may be given as
+// []WireField and bytes as hex, as DecodeWire renders them.
+func EncodeWire(fields []WireField) ([]byte, error) {
+	var b []byte
+	for _, f := range fields {
+		if f.Field <= 0 {
+			return nil, fmt.Errorf("invalid field number %d", f.Field)
+		}
+		key := func(wt uint64) { b = binary.AppendUvarint(b, uint64(f.Field)<<3|wt) }
+
+		switch f.Type {
+		case "varint", "fixed64", "fixed32":
+			n, err := wireUint(f.Value)
+			if err != nil {
+				return nil, fmt.Errorf("field %d: %w", f.Field, err)
+			}
+			switch f.Type {
+			case "varint":
+				key(0)
+				b = binary.AppendUvarint(b, n)
+			case "fixed64":
+				key(1)
+				b = binary.LittleEndian.AppendUint64(b, n)
+			default:
+				key(5)
+				b = binary.LittleEndian.AppendUint32(b, uint32(n))
+			}
+		case "string", "bytes", "message":
+			data, err := wirePayload(f)
+			if err != nil {
+				return nil, fmt.Errorf("field %d: %w", f.Field, err)
+			}
+			key(2)
+			b = binary.AppendUvarint(b, uint64(len(data)))
+			b = append(b, data...)
+		default:
+			return nil, fmt.Errorf("field %d: unknown type %q", f.Field, f.Type)
+		}
+	}
+	return b, nil
+}
+
+func wireUint(v any) (uint64, error) {
+	switch n := v.(type) {
+	case uint64:
+		return n, nil
+	case uint32:
+		return uint64(n), nil
+	case int:
+		return uint64(n), nil
+	case int64:
+		return uint64(n), nil
+	case float64:
+		return uint64(n), nil
+	}
+	return 0, fmt.Errorf("numeric value expected, got %T", v)
+}
+
+func wirePayload(f WireField) ([]byte, error) {
+	switch f.Type {
+	case "string":
+		s, ok := f.Value.(string)
+		if !ok {
+			return nil, fmt.Errorf("string value expected, got %T", f.Value)
+		}
+		return []byte(s), nil
+	case "bytes":
+		s, ok := f.Value.(string)
+		if !ok {
+			return nil, fmt.Errorf("hex value expected, got %T", f.Value)
+		}
+		return hex.DecodeString(s)
+	}
+	nested, ok := f.Value.([]WireField)
+	if !ok {
+		return nil, fmt.Errorf("nested fields expected, got %T", f.Value)
+	}
+	return EncodeWire(nested)
+}
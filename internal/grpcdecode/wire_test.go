@@ -0,0 +1,31 @@
+package grpcdecode
+
+import (
+	"testing"
+)
+
+func TestParseFramesAndDecodeWire(t *testing.T) {
+	// Field 1 = varint 150, field 2 = "hi", followed by a truncated frame.
+	msg := []byte{0x08, 0x96, 0x01, 0x12, 0x02, 'h', 'i'}
+	body := append([]byte{0, 0, 0, 0, byte(len(msg))}, msg...)
+	body = append(body, 0, 0, 0, 0, 9, 0x08)
+
+	frames := ParseFrames(body)
+	if len(frames) != 1 {
+		t.Fatalf("Expected 1 complete frame, got %d", len(frames))
+	}
+
+	fields, err := DecodeWire(frames[0].Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fields) != 2 {
+		t.Fatalf("Expected 2 fields, got %d", len(fields))
+	}
+	if fields[0].Field != 1 || fields[0].Value != uint64(150) {
+		t.Errorf("Unexpected first field: %+v", fields[0])
+	}
+	if fields[1].Field != 2 || fields[1].Type != "string" || fields[1].Value != "hi" {
+		t.Errorf("Unexpected second field: %+v", fields[1])
+	}
+}
@@ -0,0 +1,137 @@
+package importer
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// har is the part of an HTTP Archive (HAR 1.2) that is imported.
+type har struct {
+	Log struct {
+		Entries []harEntry `json:"entries"`
+	} `json:"log"`
+}
+
+type harEntry struct {
+	StartedDateTime time.Time `json:"startedDateTime"`
+	// Time is the length of the exchange in milliseconds.
+	Time    float64 `json:"time"`
+	Request struct {
+		Method   string     `json:"method"`
+		URL      string     `json:"url"`
+		Headers  []harValue `json:"headers"`
+		PostData *struct {
+			Text   string     `json:"text"`
+			Params []harValue `json:"params"`
+		} `json:"postData"`
+	} `json:"request"`
+	Response struct {
+		Status  int        `json:"status"`
+		Headers []harValue `json:"headers"`
+		Content struct {
+			Text     string `json:"text"`
+			Encoding string `json:"encoding"`
+		} `json:"content"`
+	} `json:"response"`
+	Timings struct {
+		Receive float64 `json:"receive"`
+	} `json:"timings"`
+}
+
+type harValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// ReadHAR reads the exchanges of a HAR file, as saved by browser developer
+// tools and most proxies. Entries without a response, such as aborted
+// requests, have a nil Response.
+//
+// HAR holds response bodies decoded, so their Content-Encoding header is
+// dropped.
+func ReadHAR(r io.Reader) ([]Exchange, error) {
+	var h har
+	if err := json.NewDecoder(r).Decode(&h); err != nil {
+		return nil, fmt.Errorf("reading HAR: %w", err)
+	}
+
+	exchanges := make([]Exchange, 0, len(h.Log.Entries))
+	for i, e := range h.Log.Entries {
+		ex, err := harExchange(e)
+		if err != nil {
+			return nil, fmt.Errorf("HAR entry %d: %w", i+1, err)
+		}
+		exchanges = append(exchanges, ex)
+	}
+	return exchanges, nil
+}
+
+func harExchange(e harEntry) (Exchange, error) {
+	var body []byte
+	if pd := e.Request.PostData; pd != nil {
+		body = []byte(pd.Text)
+		if pd.Text == "" && len(pd.Params) > 0 {
+			form := url.Values{}
+			for _, p := range pd.Params {
+				form.Add(p.Name, p.Value)
+			}
+			body = []byte(form.Encode())
+		}
+	}
+	req, err := http.NewRequest(e.Request.Method, e.Request.URL, bytes.NewReader(body))
+	if err != nil {
+		return Exchange{}, err
+	}
+	req.Header = harHeader(e.Request.Headers)
+	if host := req.Header.Get("Host"); host != "" {
+		req.Host = host
+	}
+
+	ex := Exchange{Request: req, Sent: e.StartedDateTime}
+	if e.Response.Status <= 0 {
+		return ex, nil
+	}
+
+	content := e.Response.Content
+	respBody := []byte(content.Text)
+	if content.Encoding == "base64" {
+		if respBody, err = base64.StdEncoding.DecodeString(content.Text); err != nil {
+			return Exchange{}, fmt.Errorf("response body: %w", err)
+		}
+	}
+	resp := &http.Response{
+		StatusCode:    e.Response.Status,
+		Header:        harHeader(e.Response.Headers),
+		Body:          io.NopCloser(bytes.NewReader(respBody)),
+		ContentLength: int64(len(respBody)),
+		Request:       req,
+	}
+	resp.Header.Del("Content-Encoding")
+	ex.Response = resp
+
+	// The response began to arrive when the exchange, less the time spent
+	// receiving it, was over.
+	wait := max(e.Time-max(e.Timings.Receive, 0), 0)
+	ex.Received = e.StartedDateTime.Add(time.Duration(wait * float64(time.Millisecond)))
+	return ex, nil
+}
+
+// harHeader builds a header from HAR name/value pairs, leaving out the
+// pseudo-headers HTTP/2 exchanges are recorded with.
+func harHeader(values []harValue) http.Header {
+	h := http.Header{}
+	for _, v := range values {
+		if strings.HasPrefix(v.Name, ":") {
+			continue
+		}
+		h.Add(v.Name, v.Value)
+	}
+	return h
+}
@@ -0,0 +1,103 @@
+package importer
+
+import (
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/standrze/rogue/internal/logger"
+)
+
+const testHAR = `{"log": {"version": "1.2", "entries": [
+  {
+    "startedDateTime": "2026-03-01T12:00:01.000Z",
+    "time": 120,
+    "request": {
+      "method": "POST", "url": "https://api.test/login", "httpVersion": "HTTP/2",
+      "headers": [{"name": ":authority", "value": "api.test"}, {"name": "content-type", "value": "application/x-www-form-urlencoded"}],
+      "postData": {"mimeType": "application/x-www-form-urlencoded", "params": [{"name": "user", "value": "a"}]}
+    },
+    "response": {
+      "status": 200,
+      "headers": [{"name": "content-type", "value": "application/octet-stream"}, {"name": "content-encoding", "value": "gzip"}],
+      "content": {"size": 3, "mimeType": "application/octet-stream", "text": "/wAB", "encoding": "base64"}
+    },
+    "timings": {"send": 1, "wait": 99, "receive": 20}
+  },
+  {
+    "startedDateTime": "2026-03-01T12:00:00.000Z",
+    "time": 0,
+    "request": {"method": "GET", "url": "http://api.test/", "headers": []},
+    "response": {"status": 0, "headers": [], "content": {}}
+  }
+]}}`
+
+func TestReadHAR(t *testing.T) {
+	exchanges, err := ReadHAR(strings.NewReader(testHAR))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(exchanges) != 2 {
+		t.Fatalf("got %d exchanges", len(exchanges))
+	}
+
+	login := exchanges[0]
+	if body, _ := io.ReadAll(login.Request.Body); string(body) != "user=a" {
+		t.Errorf("form body %q", body)
+	}
+	if len(login.Request.Header) != 1 {
+		t.Errorf("request headers %v", login.Request.Header)
+	}
+	if body, _ := io.ReadAll(login.Response.Body); string(body) != "\xff\x00\x01" {
+		t.Errorf("response body %q", body)
+	}
+	if login.Response.Header.Get("Content-Encoding") != "" {
+		t.Error("the Content-Encoding of a decoded body was kept")
+	}
+	if want := time.Date(2026, 3, 1, 12, 0, 1, 100e6, time.UTC); !login.Received.Equal(want) {
+		t.Errorf("received at %v, want %v", login.Received, want)
+	}
+	if exchanges[1].Response != nil {
+		t.Error("an aborted request has a response")
+	}
+
+	if _, err := ReadHAR(strings.NewReader(`{"log": {"entries": [{"request": {"method": "GET", "url": ":bad"}}]}}`)); err == nil {
+		t.Error("ReadHAR accepted a bad URL")
+	}
+}
+
+func TestWrite(t *testing.T) {
+	exchanges, err := ReadHAR(strings.NewReader(testHAR))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	sl, err := logger.NewSessionLogger(dir, true, true, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Write(sl, exchanges); err != nil {
+		t.Fatal(err)
+	}
+	sl.Close()
+
+	flows, err := logger.LoadFlows(filepath.Join(dir, sl.GetSessionName()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Flows are in the order they were sent, with their captured times.
+	if len(flows) != 2 || flows[0].Request.URL != "http://api.test/" || flows[0].Response != nil {
+		t.Fatalf("flows = %+v", flows)
+	}
+	login := flows[1]
+	if login.Request.Body != "user=a" || login.Response == nil || login.Response.StatusCode != http.StatusOK ||
+		login.Response.BodyEncoding != "base64" || login.Response.BodySize != 3 {
+		t.Errorf("login flow = %+v %+v", login.Request, login.Response)
+	}
+	if want := time.Date(2026, 3, 1, 12, 0, 1, 0, time.UTC); !login.Request.Timestamp.Equal(want) {
+		t.Errorf("request logged at %v", login.Request.Timestamp)
+	}
+}
@@ -0,0 +1,177 @@
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Report counts the TCP connections of a packet capture and those that
+// could not be imported.
+type Report struct {
+	Connections int
+	// Encrypted connections are TLS without keys for them.
+	Encrypted int
+	// HTTP2 connections are not imported.
+	HTTP2 int
+	// Other connections carried something other than HTTP.
+	Other int
+}
+
+// ReadPcap reads the HTTP/1.x exchanges of a pcap or pcapng capture. TLS
+// connections are decrypted when keys, or a key log embedded in a pcapng
+// file, has secrets for them; keys may be nil.
+func ReadPcap(r io.Reader, keys *KeyLog) ([]Exchange, Report, error) {
+	var rep Report
+	c, err := readCapture(r)
+	if err != nil {
+		return nil, rep, err
+	}
+	if len(c.keyLogs) > 0 {
+		keys = keys.clone()
+		for _, kl := range c.keyLogs {
+			keys.read(bytes.NewReader(kl))
+		}
+	}
+
+	var exchanges []Exchange
+	for _, cn := range connections(c) {
+		client, server := cn.sides()
+		if client == nil {
+			continue
+		}
+		rep.Connections++
+		up, down := client.reassemble(), server.reassemble()
+		scheme := "http"
+
+		if isClientHello(up.data) {
+			var ok bool
+			if up, down, ok = decryptTLS(up, down, keys); !ok {
+				rep.Encrypted++
+				continue
+			}
+			scheme = "https"
+		}
+		if bytes.HasPrefix(up.data, []byte("PRI * HTTP/2.0")) {
+			rep.HTTP2++
+			continue
+		}
+		found := readHTTP(up, down, scheme, client.addr.String())
+		if len(found) == 0 {
+			rep.Other++
+			continue
+		}
+		exchanges = append(exchanges, found...)
+	}
+	return exchanges, rep, nil
+}
+
+// sides returns the client and server halves of cn. Without the handshake,
+// the client is the side whose first bytes look like a request.
+func (cn *conn) sides() (client, server *half) {
+	if cn.client != nil {
+		return cn.client, cn.peer(cn.client)
+	}
+	for _, h := range []*half{cn.a, cn.b} {
+		if len(h.segments) > 0 {
+			first := h.reassemble().data
+			if isClientHello(first) || looksLikeRequest(first) {
+				return h, cn.peer(h)
+			}
+		}
+	}
+	return nil, nil
+}
+
+var methods = []string{"GET ", "POST ", "PUT ", "DELETE ", "HEAD ", "OPTIONS ", "PATCH ", "CONNECT ", "TRACE ", "PRI "}
+
+func looksLikeRequest(b []byte) bool {
+	for _, m := range methods {
+		if bytes.HasPrefix(b, []byte(m)) {
+			return true
+		}
+	}
+	return false
+}
+
+// cursor reads a stream from the start, keeping track of when the next
+// byte to be parsed was captured.
+type cursor struct {
+	s  *stream
+	r  *bytes.Reader
+	br *bufio.Reader
+}
+
+func newCursor(s *stream) *cursor {
+	r := bytes.NewReader(s.data)
+	return &cursor{s: s, r: r, br: bufio.NewReader(r)}
+}
+
+func (c *cursor) time() time.Time {
+	return c.s.timeAt(len(c.s.data) - c.r.Len() - c.br.Buffered())
+}
+
+// readHTTP parses the requests sent on a connection and pairs them with
+// the responses that came back, in order. Parsing stops at bytes that are
+// not HTTP/1.x, and after a CONNECT or protocol switch, since what follows
+// is tunnelled.
+func readHTTP(up, down *stream, scheme, clientAddr string) []Exchange {
+	reqs, resps := newCursor(up), newCursor(down)
+	var exchanges []Exchange
+	responses := true
+	for {
+		sent := reqs.time()
+		req, err := http.ReadRequest(reqs.br)
+		if err != nil {
+			break
+		}
+		body, _ := io.ReadAll(req.Body)
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		req.ContentLength = int64(len(body))
+		if req.URL.Host == "" {
+			req.URL.Host = req.Host
+		}
+		if req.URL.Scheme == "" {
+			req.URL.Scheme = scheme
+		}
+		req.RemoteAddr = clientAddr
+		req.RequestURI = ""
+		ex := Exchange{Request: req, Sent: sent}
+
+		if responses {
+			ex.Response, ex.Received = readResponse(resps, req)
+			responses = ex.Response != nil
+		}
+		exchanges = append(exchanges, ex)
+
+		if resp := ex.Response; resp != nil && (req.Method == http.MethodConnect && resp.StatusCode/100 == 2 ||
+			resp.StatusCode == http.StatusSwitchingProtocols) {
+			break
+		}
+	}
+	return exchanges
+}
+
+// readResponse reads the response to req, skipping interim 1xx responses.
+// Bodies cut short by the end of the capture are kept as far as they go.
+func readResponse(c *cursor, req *http.Request) (*http.Response, time.Time) {
+	for {
+		received := c.time()
+		resp, err := http.ReadResponse(c.br, req)
+		if err != nil {
+			return nil, time.Time{}
+		}
+		body, err := io.ReadAll(resp.Body)
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, time.Time{}
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		if resp.StatusCode >= 100 && resp.StatusCode < 200 && resp.StatusCode != http.StatusSwitchingProtocols {
+			continue
+		}
+		return resp, received
+	}
+}
@@ -0,0 +1,64 @@
+// Package importer reads captures made with other tools, HAR files and
+// packet captures, so they can be written out as rogue sessions and queried,
+// diffed, replayed and exported like traffic the proxy recorded itself.
+package importer
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/standrze/rogue/internal/logger"
+)
+
+// Exchange is a request and its response, if one was captured. Bodies are
+// held in memory and can be read once.
+type Exchange struct {
+	Request  *http.Request
+	Response *http.Response
+	// Sent is when the request started; Received is when its response
+	// started to arrive.
+	Sent     time.Time
+	Received time.Time
+}
+
+// Write logs exchanges to sl in the order they were sent, with the times
+// they were captured at. Each gets a new request ID.
+func Write(sl *logger.SessionLogger, exchanges []Exchange) error {
+	sort.SliceStable(exchanges, func(i, j int) bool { return exchanges[i].Sent.Before(exchanges[j].Sent) })
+	for i, ex := range exchanges {
+		id := fmt.Sprintf("import-%d", i+1)
+		reqLog := sl.CaptureRequest(ex.Request, id)
+		reqLog.Timestamp = ex.Sent
+		body, err := sl.StreamEntry("request", reqLog, ex.Request.Body)
+		if err := drain(body, err); err != nil {
+			return err
+		}
+		if ex.Response == nil {
+			continue
+		}
+
+		ex.Response.Request = ex.Request
+		respLog := sl.CaptureResponse(ex.Response, id)
+		respLog.Timestamp = ex.Received
+		body, err = sl.StreamEntry("response", respLog, ex.Response.Body)
+		if err := drain(body, err); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// drain consumes a body returned by StreamEntry so its entry is written.
+func drain(body io.ReadCloser, err error) error {
+	if err != nil || body == nil {
+		return err
+	}
+	if _, err := io.Copy(io.Discard, body); err != nil {
+		body.Close()
+		return err
+	}
+	return body.Close()
+}
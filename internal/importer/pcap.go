@@ -0,0 +1,358 @@
+package importer
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"time"
+)
+
+// packet is a captured frame and the link type it was captured on.
+type packet struct {
+	ts   time.Time
+	link uint32
+	data []byte
+}
+
+// capture is what a pcap or pcapng file holds: its frames and, for
+// pcapng, the TLS key logs embedded in decryption secrets blocks.
+type capture struct {
+	packets []packet
+	keyLogs [][]byte
+}
+
+// readCapture reads a pcap or pcapng file, telling them apart by their
+// magic numbers.
+func readCapture(r io.Reader) (*capture, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(4)
+	if err != nil {
+		return nil, fmt.Errorf("reading capture: %w", err)
+	}
+	if binary.BigEndian.Uint32(magic) == 0x0a0d0d0a {
+		return readPcapng(br)
+	}
+	return readPcap(br)
+}
+
+// readPcap reads a classic libpcap file in either byte order and with
+// micro- or nanosecond timestamps.
+func readPcap(r io.Reader) (*capture, error) {
+	var hdr [24]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, fmt.Errorf("reading pcap header: %w", err)
+	}
+	var order binary.ByteOrder
+	var nanos bool
+	switch binary.LittleEndian.Uint32(hdr[:4]) {
+	case 0xa1b2c3d4:
+		order = binary.LittleEndian
+	case 0xa1b23c4d:
+		order, nanos = binary.LittleEndian, true
+	case 0xd4c3b2a1:
+		order = binary.BigEndian
+	case 0x4d3cb2a1:
+		order, nanos = binary.BigEndian, true
+	default:
+		return nil, errors.New("not a pcap or pcapng file")
+	}
+	link := order.Uint32(hdr[20:24]) & 0x0fffffff
+
+	c := &capture{}
+	var rec [16]byte
+	for {
+		if _, err := io.ReadFull(r, rec[:]); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				// A capture cut short ends at its last whole packet.
+				return c, nil
+			}
+			return nil, err
+		}
+		sec, frac := int64(order.Uint32(rec[0:4])), int64(order.Uint32(rec[4:8]))
+		if !nanos {
+			frac *= 1000
+		}
+		data := make([]byte, order.Uint32(rec[8:12]))
+		if _, err := io.ReadFull(r, data); err != nil {
+			return c, nil
+		}
+		c.packets = append(c.packets, packet{ts: time.Unix(sec, frac), link: link, data: data})
+	}
+}
+
+// pcapng block types.
+const (
+	blockSection     = 0x0a0d0d0a
+	blockInterface   = 0x00000001
+	blockSimple      = 0x00000003
+	blockEnhanced    = 0x00000006
+	blockDecryption  = 0x0000000a
+	secretsTLSKeyLog = 0x544c534b
+)
+
+// pcapngInterface is what an interface description block says about the
+// packets captured on it.
+type pcapngInterface struct {
+	link uint32
+	// unitsPerSecond is the timestamp resolution.
+	unitsPerSecond uint64
+}
+
+// readPcapng reads the sections of a pcapng file. Blocks other than
+// packets, interfaces and TLS key logs are skipped.
+func readPcapng(r io.Reader) (*capture, error) {
+	c := &capture{}
+	var order binary.ByteOrder = binary.LittleEndian
+	var ifaces []pcapngInterface
+	for {
+		var hdr [8]byte
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return c, nil
+			}
+			return nil, err
+		}
+		blockType := binary.LittleEndian.Uint32(hdr[:4])
+		if blockType == blockSection {
+			// The byte-order magic decides how the rest is read.
+			var bom [4]byte
+			if _, err := io.ReadFull(r, bom[:]); err != nil {
+				return c, nil
+			}
+			order = binary.LittleEndian
+			if binary.BigEndian.Uint32(bom[:]) == 0x1a2b3c4d {
+				order = binary.BigEndian
+			}
+			ifaces = nil
+			length := order.Uint32(hdr[4:8])
+			if length < 16 {
+				return nil, errors.New("pcapng: bad section header")
+			}
+			if _, err := io.CopyN(io.Discard, r, int64(length-12)); err != nil {
+				return c, nil
+			}
+			continue
+		}
+
+		blockType = order.Uint32(hdr[:4])
+		length := order.Uint32(hdr[4:8])
+		if length < 12 || length%4 != 0 {
+			return nil, fmt.Errorf("pcapng: bad block length %d", length)
+		}
+		body := make([]byte, length-8)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return c, nil
+		}
+		body = body[:len(body)-4]
+
+		switch blockType {
+		case blockInterface:
+			if len(body) < 8 {
+				continue
+			}
+			ifaces = append(ifaces, pcapngInterface{
+				link:           uint32(order.Uint16(body[0:2])),
+				unitsPerSecond: tsResolution(order, body[8:]),
+			})
+		case blockEnhanced:
+			if len(body) < 20 {
+				continue
+			}
+			id := order.Uint32(body[0:4])
+			ts := uint64(order.Uint32(body[4:8]))<<32 | uint64(order.Uint32(body[8:12]))
+			capLen := order.Uint32(body[12:16])
+			if int(id) >= len(ifaces) || uint64(len(body)-20) < uint64(capLen) {
+				continue
+			}
+			iface := ifaces[id]
+			c.packets = append(c.packets, packet{
+				ts:   unitsToTime(ts, iface.unitsPerSecond),
+				link: iface.link,
+				data: body[20 : 20+capLen],
+			})
+		case blockSimple:
+			// Simple packets have no timestamp and belong to the first
+			// interface.
+			if len(body) < 4 || len(ifaces) == 0 {
+				continue
+			}
+			c.packets = append(c.packets, packet{link: ifaces[0].link, data: body[4:]})
+		case blockDecryption:
+			if len(body) < 8 || order.Uint32(body[0:4]) != secretsTLSKeyLog {
+				continue
+			}
+			n := order.Uint32(body[4:8])
+			if uint64(len(body)-8) >= uint64(n) {
+				c.keyLogs = append(c.keyLogs, body[8:8+n])
+			}
+		}
+	}
+}
+
+// tsResolution returns the if_tsresol option of an interface block's
+// options, as timestamp units per second. The default is microseconds.
+func tsResolution(order binary.ByteOrder, opts []byte) uint64 {
+	for len(opts) >= 4 {
+		code, n := order.Uint16(opts[0:2]), int(order.Uint16(opts[2:4]))
+		if code == 0 || len(opts) < 4+n {
+			break
+		}
+		if code == 9 && n >= 1 {
+			v := opts[4]
+			if v&0x80 != 0 {
+				return 1 << min(v&0x7f, 30)
+			}
+			units := uint64(1)
+			for range min(v, 9) {
+				units *= 10
+			}
+			return units
+		}
+		opts = opts[4+(n+3)/4*4:]
+	}
+	return 1_000_000
+}
+
+func unitsToTime(ts, unitsPerSecond uint64) time.Time {
+	sec, frac := ts/unitsPerSecond, ts%unitsPerSecond
+	return time.Unix(int64(sec), int64(frac*uint64(time.Second)/unitsPerSecond))
+}
+
+// Link types packets are decoded from.
+const (
+	linkNull     = 0
+	linkEthernet = 1
+	linkRaw      = 101
+	linkLoop     = 108
+	linkSLL      = 113
+	linkIPv4     = 228
+	linkIPv6     = 229
+	linkSLL2     = 276
+)
+
+// segment is a TCP segment with the addresses it travelled between.
+type segment struct {
+	ts       time.Time
+	src, dst netip.AddrPort
+	seq      uint32
+	flags    uint8
+	payload  []byte
+}
+
+// TCP flags.
+const (
+	tcpFIN = 0x01
+	tcpSYN = 0x02
+	tcpRST = 0x04
+	tcpACK = 0x10
+)
+
+// decodeSegment returns the TCP segment carried by p. Packets of other
+// protocols, link types that are not understood and IP fragments are
+// reported as not ok.
+func decodeSegment(p packet) (segment, bool) {
+	ip, ok := linkPayload(p.link, p.data)
+	if !ok || len(ip) < 1 {
+		return segment{}, false
+	}
+
+	var src, dst netip.Addr
+	var tcp []byte
+	switch ip[0] >> 4 {
+	case 4:
+		if len(ip) < 20 {
+			return segment{}, false
+		}
+		ihl := int(ip[0]&0x0f) * 4
+		total := int(binary.BigEndian.Uint16(ip[2:4]))
+		fragment := binary.BigEndian.Uint16(ip[6:8])
+		if ip[9] != 6 || fragment&0x3fff != 0 || ihl < 20 || total < ihl || len(ip) < ihl {
+			return segment{}, false
+		}
+		// Captures often pad short frames, or offload segmentation so the
+		// IP length is left at zero.
+		if total > ihl && total <= len(ip) {
+			ip = ip[:total]
+		}
+		src, dst = netip.AddrFrom4([4]byte(ip[12:16])), netip.AddrFrom4([4]byte(ip[16:20]))
+		tcp = ip[ihl:]
+	case 6:
+		if len(ip) < 40 {
+			return segment{}, false
+		}
+		if n := 40 + int(binary.BigEndian.Uint16(ip[4:6])); n > 40 && n <= len(ip) {
+			ip = ip[:n]
+		}
+		src, dst = netip.AddrFrom16([16]byte(ip[8:24])), netip.AddrFrom16([16]byte(ip[24:40]))
+		next, rest := ip[6], ip[40:]
+		for next != 6 {
+			// Hop-by-hop, routing and destination options are skipped;
+			// fragments and anything else end the search.
+			if next != 0 && next != 43 && next != 60 || len(rest) < 8 {
+				return segment{}, false
+			}
+			n := (int(rest[1]) + 1) * 8
+			if len(rest) < n {
+				return segment{}, false
+			}
+			next, rest = rest[0], rest[n:]
+		}
+		tcp = rest
+	default:
+		return segment{}, false
+	}
+
+	if len(tcp) < 20 {
+		return segment{}, false
+	}
+	off := int(tcp[12]>>4) * 4
+	if off < 20 || len(tcp) < off {
+		return segment{}, false
+	}
+	return segment{
+		ts:      p.ts,
+		src:     netip.AddrPortFrom(src, binary.BigEndian.Uint16(tcp[0:2])),
+		dst:     netip.AddrPortFrom(dst, binary.BigEndian.Uint16(tcp[2:4])),
+		seq:     binary.BigEndian.Uint32(tcp[4:8]),
+		flags:   tcp[13],
+		payload: tcp[off:],
+	}, true
+}
+
+// linkPayload strips the link-layer header of a frame, returning the IP
+// packet it carries.
+func linkPayload(link uint32, data []byte) ([]byte, bool) {
+	switch link {
+	case linkEthernet:
+		if len(data) < 14 {
+			return nil, false
+		}
+		etherType, rest := binary.BigEndian.Uint16(data[12:14]), data[14:]
+		// VLAN tags, single or stacked.
+		for (etherType == 0x8100 || etherType == 0x88a8) && len(rest) >= 4 {
+			etherType, rest = binary.BigEndian.Uint16(rest[2:4]), rest[4:]
+		}
+		return rest, etherType == 0x0800 || etherType == 0x86dd
+	case linkSLL:
+		if len(data) < 16 {
+			return nil, false
+		}
+		return data[16:], true
+	case linkSLL2:
+		if len(data) < 20 {
+			return nil, false
+		}
+		return data[20:], true
+	case linkNull, linkLoop:
+		if len(data) < 4 {
+			return nil, false
+		}
+		return data[4:], true
+	case linkRaw, linkIPv4, linkIPv6:
+		return data, true
+	}
+	return nil, false
+}
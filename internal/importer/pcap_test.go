@@ -0,0 +1,296 @@
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// chunk is data sent on a test connection, by the client when up is set.
+type chunk struct {
+	up   bool
+	data []byte
+}
+
+var (
+	clientAddr = [4]byte{10, 0, 0, 1}
+	serverAddr = [4]byte{10, 0, 0, 2}
+	epoch      = time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+)
+
+// frames turns a conversation into Ethernet frames of a TCP connection
+// from clientPort to port 80, with its handshake and a millisecond between
+// packets.
+func frames(clientPort uint16, chunks []chunk) []packet {
+	var pkts []packet
+	ts := epoch
+	seq := map[bool]uint32{true: 1000, false: 5000}
+	add := func(up bool, flags uint8, payload []byte) {
+		pkts = append(pkts, packet{ts: ts, link: linkEthernet, data: tcpFrame(up, clientPort, seq[up], flags, payload)})
+		ts = ts.Add(time.Millisecond)
+	}
+	add(true, tcpSYN, nil)
+	add(false, tcpSYN|tcpACK, nil)
+	seq[true]++
+	seq[false]++
+	for _, c := range chunks {
+		add(c.up, tcpACK, c.data)
+		seq[c.up] += uint32(len(c.data))
+	}
+	add(true, tcpFIN|tcpACK, nil)
+	return pkts
+}
+
+func tcpFrame(up bool, clientPort uint16, seq uint32, flags uint8, payload []byte) []byte {
+	src, dst := clientAddr, serverAddr
+	sport, dport := clientPort, uint16(80)
+	if !up {
+		src, dst, sport, dport = dst, src, dport, sport
+	}
+	tcp := make([]byte, 20, 20+len(payload))
+	binary.BigEndian.PutUint16(tcp[0:], sport)
+	binary.BigEndian.PutUint16(tcp[2:], dport)
+	binary.BigEndian.PutUint32(tcp[4:], seq)
+	tcp[12] = 5 << 4
+	tcp[13] = flags
+	tcp = append(tcp, payload...)
+
+	ip := make([]byte, 20, 20+len(tcp))
+	ip[0] = 0x45
+	binary.BigEndian.PutUint16(ip[2:], uint16(20+len(tcp)))
+	ip[8], ip[9] = 64, 6
+	copy(ip[12:], src[:])
+	copy(ip[16:], dst[:])
+	ip = append(ip, tcp...)
+
+	eth := make([]byte, 14, 14+len(ip))
+	binary.BigEndian.PutUint16(eth[12:], 0x0800)
+	return append(eth, ip...)
+}
+
+func writePcap(pkts []packet) []byte {
+	var b bytes.Buffer
+	hdr := make([]byte, 24)
+	binary.LittleEndian.PutUint32(hdr[0:], 0xa1b2c3d4)
+	binary.LittleEndian.PutUint16(hdr[4:], 2)
+	binary.LittleEndian.PutUint16(hdr[6:], 4)
+	binary.LittleEndian.PutUint32(hdr[16:], 65535)
+	binary.LittleEndian.PutUint32(hdr[20:], linkEthernet)
+	b.Write(hdr)
+	for _, p := range pkts {
+		rec := make([]byte, 16)
+		binary.LittleEndian.PutUint32(rec[0:], uint32(p.ts.Unix()))
+		binary.LittleEndian.PutUint32(rec[4:], uint32(p.ts.Nanosecond()/1000))
+		binary.LittleEndian.PutUint32(rec[8:], uint32(len(p.data)))
+		binary.LittleEndian.PutUint32(rec[12:], uint32(len(p.data)))
+		b.Write(rec)
+		b.Write(p.data)
+	}
+	return b.Bytes()
+}
+
+// writePcapng writes pkts to a pcapng file with nanosecond timestamps and
+// keyLog in a decryption secrets block.
+func writePcapng(pkts []packet, keyLog []byte) []byte {
+	var b bytes.Buffer
+	block := func(typ uint32, body []byte) {
+		for len(body)%4 != 0 {
+			body = append(body, 0)
+		}
+		n := uint32(12 + len(body))
+		binary.Write(&b, binary.LittleEndian, typ)
+		binary.Write(&b, binary.LittleEndian, n)
+		b.Write(body)
+		binary.Write(&b, binary.LittleEndian, n)
+	}
+	shb := binary.LittleEndian.AppendUint32(nil, 0x1a2b3c4d)
+	shb = append(shb, 1, 0, 0, 0, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff)
+	block(blockSection, shb)
+	// Link type, snap length and an if_tsresol option of 10^-9.
+	idb := []byte{linkEthernet, 0, 0, 0, 0, 0, 0, 0, 9, 0, 1, 0, 9, 0, 0, 0, 0, 0, 0, 0}
+	block(blockInterface, idb)
+	dsb := binary.LittleEndian.AppendUint32(nil, secretsTLSKeyLog)
+	dsb = binary.LittleEndian.AppendUint32(dsb, uint32(len(keyLog)))
+	block(blockDecryption, append(dsb, keyLog...))
+	for _, p := range pkts {
+		ts := uint64(p.ts.UnixNano())
+		epb := binary.LittleEndian.AppendUint32(nil, 0)
+		epb = binary.LittleEndian.AppendUint32(epb, uint32(ts>>32))
+		epb = binary.LittleEndian.AppendUint32(epb, uint32(ts))
+		epb = binary.LittleEndian.AppendUint32(epb, uint32(len(p.data)))
+		epb = binary.LittleEndian.AppendUint32(epb, uint32(len(p.data)))
+		block(blockEnhanced, append(epb, p.data...))
+	}
+	return b.Bytes()
+}
+
+func TestReadPcapPlaintext(t *testing.T) {
+	pkts := frames(40000, []chunk{
+		{true, []byte("GET /a?x=1 HTTP/1.1\r\nHost: shop.test\r\n\r\n")},
+		{false, []byte("HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nhello")},
+		{true, []byte("POST /b HTTP/1.1\r\nHost: shop.test\r\nContent-Length: 7\r\n\r\n")},
+		{true, []byte("payload")},
+		{false, []byte("HTTP/1.1 100 Continue\r\n\r\nHTTP/1.1 201 Created\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n0\r\n\r\n")},
+	})
+	// A retransmitted segment is dropped, and segments captured out of
+	// order are put back in sequence.
+	pkts = append(pkts[:4], append([]packet{pkts[3]}, pkts[4:]...)...)
+	pkts[5], pkts[6] = pkts[6], pkts[5]
+	// Another connection that is not HTTP.
+	pkts = append(pkts, frames(40001, []chunk{{true, []byte("SSH-2.0-OpenSSH_9.6\r\n")}})...)
+
+	exchanges, rep, err := ReadPcap(bytes.NewReader(writePcap(pkts)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep != (Report{Connections: 2, Other: 1}) {
+		t.Errorf("report = %+v", rep)
+	}
+	if len(exchanges) != 2 {
+		t.Fatalf("got %d exchanges", len(exchanges))
+	}
+
+	first, second := exchanges[0], exchanges[1]
+	if first.Request.URL.String() != "http://shop.test/a?x=1" || first.Request.RemoteAddr != "10.0.0.1:40000" {
+		t.Errorf("first request %s from %s", first.Request.URL, first.Request.RemoteAddr)
+	}
+	if !first.Sent.Equal(epoch.Add(2*time.Millisecond)) || !first.Received.Equal(epoch.Add(3*time.Millisecond)) {
+		t.Errorf("first exchange at %v, %v", first.Sent, first.Received)
+	}
+	if body, _ := io.ReadAll(first.Response.Body); string(body) != "hello" {
+		t.Errorf("first response body %q", body)
+	}
+	if body, _ := io.ReadAll(second.Request.Body); string(body) != "payload" {
+		t.Errorf("second request body %q", body)
+	}
+	if second.Response.StatusCode != http.StatusCreated {
+		t.Errorf("second response status %d", second.Response.StatusCode)
+	}
+	if body, _ := io.ReadAll(second.Response.Body); string(body) != "abc" {
+		t.Errorf("second response body %q", body)
+	}
+}
+
+// recorder records what is written each way on a connection.
+type recorder struct {
+	net.Conn
+	up     bool
+	mu     *sync.Mutex
+	chunks *[]chunk
+}
+
+func (r *recorder) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	*r.chunks = append(*r.chunks, chunk{r.up, append([]byte(nil), p...)})
+	r.mu.Unlock()
+	return r.Conn.Write(p)
+}
+
+// tlsConversation runs a request and response over TLS and returns what
+// was sent on the wire and the key log the client wrote.
+func tlsConversation(t *testing.T, config *tls.Config) ([]chunk, []byte) {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{SerialNumber: big.NewInt(1), DNSNames: []string{"api.test"}, NotAfter: time.Now().Add(time.Hour)}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Certificates = []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}
+
+	var mu sync.Mutex
+	var chunks []chunk
+	c, s := net.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		conn := tls.Server(&recorder{Conn: s, mu: &mu, chunks: &chunks}, config)
+		defer conn.Close()
+		if _, err := http.ReadRequest(bufio.NewReader(conn)); err != nil {
+			done <- err
+			return
+		}
+		_, err := io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Length: 6\r\n\r\nsecret")
+		done <- err
+	}()
+
+	var keyLog bytes.Buffer
+	conn := tls.Client(&recorder{Conn: c, up: true, mu: &mu, chunks: &chunks}, &tls.Config{
+		InsecureSkipVerify: true,
+		KeyLogWriter:       &keyLog,
+	})
+	if _, err := io.WriteString(conn, "GET /me HTTP/1.1\r\nHost: api.test\r\n\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(conn); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	return chunks, keyLog.Bytes()
+}
+
+func TestReadPcapTLS(t *testing.T) {
+	for name, config := range map[string]*tls.Config{
+		"TLS 1.3":         {MinVersion: tls.VersionTLS13},
+		"TLS 1.2 AES-GCM": {MaxVersion: tls.VersionTLS12, CipherSuites: []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256}},
+		"TLS 1.2 ChaCha":  {MaxVersion: tls.VersionTLS12, CipherSuites: []uint16{tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			chunks, keyLog := tlsConversation(t, config)
+			pkts := frames(40000, chunks)
+
+			// Without keys the connection is only counted.
+			exchanges, rep, err := ReadPcap(bytes.NewReader(writePcap(pkts)), nil)
+			if err != nil || len(exchanges) != 0 || rep.Encrypted != 1 {
+				t.Fatalf("without keys: %d exchanges, %+v, %v", len(exchanges), rep, err)
+			}
+
+			keys, err := ReadKeyLog(bytes.NewReader(keyLog))
+			if err != nil {
+				t.Fatal(err)
+			}
+			exchanges, _, err = ReadPcap(bytes.NewReader(writePcap(pkts)), keys)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Keys embedded in a pcapng file work alike.
+			embedded, _, err := ReadPcap(bytes.NewReader(writePcapng(pkts, keyLog)), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, found := range [][]Exchange{exchanges, embedded} {
+				if len(found) != 1 || found[0].Response == nil {
+					t.Fatalf("got %+v", found)
+				}
+				body, _ := io.ReadAll(found[0].Response.Body)
+				if found[0].Request.URL.String() != "https://api.test/me" || string(body) != "secret" {
+					t.Errorf("got %s with %q", found[0].Request.URL, body)
+				}
+			}
+			if !embedded[0].Sent.Equal(exchanges[0].Sent) {
+				t.Errorf("pcapng time %v, pcap time %v", embedded[0].Sent, exchanges[0].Sent)
+			}
+		})
+	}
+}
+
+func TestReadCaptureRejectsOtherFiles(t *testing.T) {
+	if _, _, err := ReadPcap(strings.NewReader("not a capture at all"), nil); err == nil {
+		t.Error("ReadPcap accepted a text file")
+	}
+}
@@ -0,0 +1,163 @@
+package importer
+
+import (
+	"net/netip"
+	"sort"
+	"time"
+)
+
+// stream is the bytes sent one way on a connection, with the times they
+// were captured at.
+type stream struct {
+	data  []byte
+	marks []mark
+	// gap is set when bytes are missing, and data ends before them.
+	gap bool
+}
+
+// mark records that the bytes from offset on were captured at ts.
+type mark struct {
+	offset int
+	ts     time.Time
+}
+
+// append adds b, captured at ts, to the end of s.
+func (s *stream) append(b []byte, ts time.Time) {
+	if len(b) == 0 {
+		return
+	}
+	s.marks = append(s.marks, mark{offset: len(s.data), ts: ts})
+	s.data = append(s.data, b...)
+}
+
+// timeAt returns when the byte at offset was captured.
+func (s *stream) timeAt(offset int) time.Time {
+	i := sort.Search(len(s.marks), func(i int) bool { return s.marks[i].offset > offset })
+	if i == 0 {
+		if len(s.marks) > 0 {
+			return s.marks[0].ts
+		}
+		return time.Time{}
+	}
+	return s.marks[i-1].ts
+}
+
+// half collects the segments sent one way on a connection.
+type half struct {
+	addr     netip.AddrPort
+	isn      uint32
+	syn      bool
+	segments []segment
+}
+
+// conn is a TCP connection. client is the side that opened it, when the
+// handshake was captured.
+type conn struct {
+	first    time.Time
+	a, b     *half
+	client   *half
+	finished bool
+}
+
+// connKey identifies a connection by its endpoints, whichever way the
+// packet went.
+type connKey struct {
+	lo, hi netip.AddrPort
+}
+
+func keyOf(s segment) connKey {
+	if s.src.Compare(s.dst) < 0 {
+		return connKey{s.src, s.dst}
+	}
+	return connKey{s.dst, s.src}
+}
+
+// connections groups the TCP segments of a capture into connections, in
+// the order they started. A SYN on the endpoints of a connection that has
+// finished starts a new one.
+func connections(c *capture) []*conn {
+	var all []*conn
+	open := map[connKey]*conn{}
+	for _, p := range c.packets {
+		s, ok := decodeSegment(p)
+		if !ok {
+			continue
+		}
+		key := keyOf(s)
+		cn := open[key]
+		if cn != nil && cn.finished && s.flags&tcpSYN != 0 && s.flags&tcpACK == 0 {
+			cn = nil
+		}
+		if cn == nil {
+			cn = &conn{first: s.ts, a: &half{addr: s.src}, b: &half{addr: s.dst}}
+			open[key] = cn
+			all = append(all, cn)
+		}
+
+		h := cn.a
+		if s.src != h.addr {
+			h = cn.b
+		}
+		if s.flags&tcpSYN != 0 {
+			h.isn, h.syn = s.seq, true
+			if s.flags&tcpACK == 0 {
+				cn.client = h
+			} else if cn.client == nil {
+				cn.client = cn.peer(h)
+			}
+		}
+		if s.flags&(tcpFIN|tcpRST) != 0 {
+			cn.finished = true
+		}
+		if len(s.payload) > 0 {
+			h.segments = append(h.segments, s)
+		}
+	}
+	return all
+}
+
+func (cn *conn) peer(h *half) *half {
+	if h == cn.a {
+		return cn.b
+	}
+	return cn.a
+}
+
+// reassemble puts the segments of h in sequence order, dropping
+// retransmissions. Without the SYN the stream starts at the lowest
+// sequence number seen. It stops at the first missing bytes.
+func (h *half) reassemble() *stream {
+	s := &stream{}
+	if len(h.segments) == 0 {
+		return s
+	}
+	base := h.isn + 1
+	if !h.syn {
+		base = h.segments[0].seq
+		for _, seg := range h.segments[1:] {
+			if int32(seg.seq-base) < 0 {
+				base = seg.seq
+			}
+		}
+	}
+
+	segs := make([]segment, len(h.segments))
+	copy(segs, h.segments)
+	offset := func(seg segment) int64 { return int64(int32(seg.seq - base)) }
+	sort.SliceStable(segs, func(i, j int) bool { return offset(segs[i]) < offset(segs[j]) })
+
+	var next int64
+	for _, seg := range segs {
+		off, end := offset(seg), offset(seg)+int64(len(seg.payload))
+		if end <= next {
+			continue
+		}
+		if off > next {
+			s.gap = true
+			break
+		}
+		s.append(seg.payload[next-off:], seg.ts)
+		next = end
+	}
+	return s
+}
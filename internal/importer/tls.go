@@ -0,0 +1,452 @@
+package importer
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"hash"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// KeyLog holds TLS secrets in the NSS key log format that browsers and
+// most TLS libraries write to the file named by SSLKEYLOGFILE. They are
+// looked up by the client random of a connection.
+type KeyLog struct {
+	secrets map[string]map[string][]byte
+}
+
+// ReadKeyLog reads a key log. Lines it does not understand are ignored.
+func ReadKeyLog(r io.Reader) (*KeyLog, error) {
+	k := &KeyLog{}
+	return k, k.read(r)
+}
+
+func (k *KeyLog) read(r io.Reader) error {
+	if k.secrets == nil {
+		k.secrets = map[string]map[string][]byte{}
+	}
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) != 3 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		random, err1 := hex.DecodeString(fields[1])
+		secret, err2 := hex.DecodeString(fields[2])
+		if err1 != nil || err2 != nil || len(random) != 32 {
+			continue
+		}
+		id := string(random)
+		if k.secrets[id] == nil {
+			k.secrets[id] = map[string][]byte{}
+		}
+		k.secrets[id][fields[0]] = secret
+	}
+	return sc.Err()
+}
+
+// clone returns a copy of k that can be added to; k may be nil.
+func (k *KeyLog) clone() *KeyLog {
+	c := &KeyLog{secrets: map[string]map[string][]byte{}}
+	if k != nil {
+		for id, s := range k.secrets {
+			c.secrets[id] = s
+		}
+	}
+	return c
+}
+
+// secret returns the secret of the given label for a client random.
+func (k *KeyLog) secret(random []byte, label string) []byte {
+	if k == nil {
+		return nil
+	}
+	return k.secrets[string(random)][label]
+}
+
+// TLS record content types.
+const (
+	recordChangeCipherSpec = 20
+	recordHandshake        = 22
+	recordApplicationData  = 23
+)
+
+// isClientHello reports whether b starts with a TLS handshake record
+// holding a ClientHello.
+func isClientHello(b []byte) bool {
+	return len(b) >= 6 && b[0] == recordHandshake && b[1] == 3 && b[5] == 1
+}
+
+// suite describes the AEAD of a cipher suite and the hash of its key
+// derivation.
+type suite struct {
+	keyLen int
+	chacha bool
+	hash   func() hash.Hash
+}
+
+// suites are the cipher suites whose records can be decrypted: the AEAD
+// suites of TLS 1.2 and those of TLS 1.3. CBC suites are not supported.
+var suites = map[uint16]suite{
+	0x009c: {16, false, sha256.New}, // TLS_RSA_WITH_AES_128_GCM_SHA256
+	0x009d: {32, false, sha512.New384},
+	0x009e: {16, false, sha256.New}, // TLS_DHE_RSA_WITH_AES_128_GCM_SHA256
+	0x009f: {32, false, sha512.New384},
+	0xc02b: {16, false, sha256.New}, // TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256
+	0xc02c: {32, false, sha512.New384},
+	0xc02f: {16, false, sha256.New}, // TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256
+	0xc030: {32, false, sha512.New384},
+	0xcca8: {32, true, sha256.New}, // TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256
+	0xcca9: {32, true, sha256.New},
+	0xccaa: {32, true, sha256.New},
+	0x1301: {16, false, sha256.New}, // TLS_AES_128_GCM_SHA256
+	0x1302: {32, false, sha512.New384},
+	0x1303: {32, true, sha256.New}, // TLS_CHACHA20_POLY1305_SHA256
+}
+
+func (s suite) aead(key []byte) cipher.AEAD {
+	if s.chacha {
+		a, _ := chacha20poly1305.New(key)
+		return a
+	}
+	block, _ := aes.NewCipher(key)
+	a, _ := cipher.NewGCM(block)
+	return a
+}
+
+// record is a TLS record and where in its stream it began.
+type record struct {
+	typ      byte
+	header   []byte
+	fragment []byte
+	offset   int
+}
+
+// records splits a stream into TLS records, leaving out a partial one at
+// the end.
+func records(s *stream) []record {
+	var recs []record
+	for off := 0; off+5 <= len(s.data); {
+		n := int(binary.BigEndian.Uint16(s.data[off+3 : off+5]))
+		if off+5+n > len(s.data) {
+			break
+		}
+		recs = append(recs, record{
+			typ:      s.data[off],
+			header:   s.data[off : off+5],
+			fragment: s.data[off+5 : off+5+n],
+			offset:   off,
+		})
+		off += 5 + n
+	}
+	return recs
+}
+
+// handshakeMessages returns the handshake messages carried by the
+// plaintext handshake records of recs, up to the first encrypted one.
+func handshakeMessages(recs []record) [][]byte {
+	var buf []byte
+	for _, r := range recs {
+		if r.typ == recordChangeCipherSpec || r.typ == recordApplicationData {
+			break
+		}
+		if r.typ == recordHandshake {
+			buf = append(buf, r.fragment...)
+		}
+	}
+	var msgs [][]byte
+	for len(buf) >= 4 {
+		n := 4 + (int(buf[1])<<16 | int(buf[2])<<8 | int(buf[3]))
+		if len(buf) < n {
+			break
+		}
+		msgs = append(msgs, buf[:n])
+		buf = buf[n:]
+	}
+	return msgs
+}
+
+// helloRetryRandom is the server random of a HelloRetryRequest.
+var helloRetryRandom, _ = hex.DecodeString("cf21ad74e59a6111be1d8c021e65b891c2a211167abb8c5e079e09e2c8a8339c")
+
+// serverHello is what decryption needs from a ServerHello.
+type serverHello struct {
+	random []byte
+	suite  uint16
+	tls13  bool
+}
+
+// parseServerHello finds the ServerHello among msgs, passing over a
+// HelloRetryRequest.
+func parseServerHello(msgs [][]byte) (serverHello, bool) {
+	for _, m := range msgs {
+		if m[0] != 2 || len(m) < 4+2+32+1 {
+			continue
+		}
+		b := m[4:]
+		sh := serverHello{random: b[2:34]}
+		if string(sh.random) == string(helloRetryRandom) {
+			continue
+		}
+		b = b[34:]
+		if len(b) < 1+int(b[0])+3 {
+			return sh, false
+		}
+		b = b[1+int(b[0]):]
+		sh.suite = binary.BigEndian.Uint16(b[0:2])
+		b = b[3:]
+		if len(b) >= 2 {
+			exts := b[2:]
+			for len(exts) >= 4 {
+				typ, n := binary.BigEndian.Uint16(exts[0:2]), int(binary.BigEndian.Uint16(exts[2:4]))
+				if len(exts) < 4+n {
+					break
+				}
+				// supported_versions selecting TLS 1.3.
+				if typ == 43 && n == 2 && binary.BigEndian.Uint16(exts[4:6]) == 0x0304 {
+					sh.tls13 = true
+				}
+				exts = exts[4+n:]
+			}
+		}
+		return sh, true
+	}
+	return serverHello{}, false
+}
+
+// clientRandom returns the random of the ClientHello among msgs.
+func clientRandom(msgs [][]byte) []byte {
+	for _, m := range msgs {
+		if m[0] == 1 && len(m) >= 4+2+32 {
+			return m[6:38]
+		}
+	}
+	return nil
+}
+
+// decryptTLS returns the application data of a TLS connection, given the
+// records sent each way. It reports false when keys has no secrets for the
+// connection or its cipher suite is not supported.
+func decryptTLS(up, down *stream, keys *KeyLog) (*stream, *stream, bool) {
+	upRecs, downRecs := records(up), records(down)
+	random := clientRandom(handshakeMessages(upRecs))
+	sh, ok := parseServerHello(handshakeMessages(downRecs))
+	s, known := suites[sh.suite]
+	if random == nil || !ok || !known {
+		return nil, nil, false
+	}
+
+	if sh.tls13 {
+		clientKeys := tls13Keys(s, keys.secret(random, "CLIENT_HANDSHAKE_TRAFFIC_SECRET"), keys.secret(random, "CLIENT_TRAFFIC_SECRET_0"))
+		serverKeys := tls13Keys(s, keys.secret(random, "SERVER_HANDSHAKE_TRAFFIC_SECRET"), keys.secret(random, "SERVER_TRAFFIC_SECRET_0"))
+		if clientKeys.app == nil || serverKeys.app == nil {
+			return nil, nil, false
+		}
+		return clientKeys.decrypt(up, upRecs), serverKeys.decrypt(down, downRecs), true
+	}
+
+	master := keys.secret(random, "CLIENT_RANDOM")
+	if master == nil {
+		return nil, nil, false
+	}
+	ivLen := 4
+	if s.chacha {
+		ivLen = 12
+	}
+	block := prf(s.hash, master, "key expansion", append(append([]byte{}, sh.random...), random...), 2*s.keyLen+2*ivLen)
+	clientKey, serverKey := block[:s.keyLen], block[s.keyLen:2*s.keyLen]
+	clientIV, serverIV := block[2*s.keyLen:2*s.keyLen+ivLen], block[2*s.keyLen+ivLen:]
+	return tls12Decrypt(s, s.aead(clientKey), clientIV, up, upRecs),
+		tls12Decrypt(s, s.aead(serverKey), serverIV, down, downRecs), true
+}
+
+// prf is the TLS 1.2 pseudorandom function.
+func prf(h func() hash.Hash, secret []byte, label string, seed []byte, n int) []byte {
+	seed = append([]byte(label), seed...)
+	mac := hmac.New(h, secret)
+	mac.Write(seed)
+	a := mac.Sum(nil)
+	var out []byte
+	for len(out) < n {
+		mac.Reset()
+		mac.Write(a)
+		mac.Write(seed)
+		out = mac.Sum(out)
+		mac.Reset()
+		mac.Write(a)
+		a = mac.Sum(nil)
+	}
+	return out[:n]
+}
+
+// tls12Decrypt decrypts the records sent one way after its
+// ChangeCipherSpec. Decryption stops at the first record that fails.
+func tls12Decrypt(s suite, aead cipher.AEAD, iv []byte, raw *stream, recs []record) *stream {
+	out := &stream{}
+	var seq uint64
+	encrypted := false
+	for _, r := range recs {
+		if !encrypted {
+			encrypted = r.typ == recordChangeCipherSpec
+			continue
+		}
+		frag := r.fragment
+		nonce := make([]byte, len(iv))
+		copy(nonce, iv)
+		if s.chacha {
+			xorSeq(nonce, seq)
+		} else {
+			if len(frag) < 8 {
+				break
+			}
+			nonce = append(nonce, frag[:8]...)
+			frag = frag[8:]
+		}
+		if len(frag) < aead.Overhead() {
+			break
+		}
+		var ad [13]byte
+		binary.BigEndian.PutUint64(ad[:8], seq)
+		copy(ad[8:11], r.header[:3])
+		binary.BigEndian.PutUint16(ad[11:], uint16(len(frag)-aead.Overhead()))
+		plain, err := aead.Open(nil, nonce, frag, ad[:])
+		if err != nil {
+			out.gap = true
+			break
+		}
+		seq++
+		if r.typ == recordApplicationData {
+			out.append(plain, raw.timeAt(r.offset))
+		}
+	}
+	return out
+}
+
+// tls13Traffic holds the keys of one direction of a TLS 1.3 connection.
+type tls13Traffic struct {
+	handshake, app     cipher.AEAD
+	handshakeIV, appIV []byte
+}
+
+func tls13Keys(s suite, handshake, app []byte) tls13Traffic {
+	var t tls13Traffic
+	if handshake != nil {
+		t.handshake, t.handshakeIV = trafficKey(s, handshake)
+	}
+	if app != nil {
+		t.app, t.appIV = trafficKey(s, app)
+	}
+	return t
+}
+
+// trafficKey derives the key and IV of a TLS 1.3 traffic secret.
+func trafficKey(s suite, secret []byte) (cipher.AEAD, []byte) {
+	key := expandLabel(s.hash, secret, "key", s.keyLen)
+	iv := expandLabel(s.hash, secret, "iv", 12)
+	return s.aead(key), iv
+}
+
+// expandLabel is HKDF-Expand-Label with an empty context.
+func expandLabel(h func() hash.Hash, secret []byte, label string, n int) []byte {
+	label = "tls13 " + label
+	info := make([]byte, 0, 4+len(label))
+	info = binary.BigEndian.AppendUint16(info, uint16(n))
+	info = append(info, byte(len(label)))
+	info = append(info, label...)
+	info = append(info, 0)
+	out, _ := hkdf.Expand(h, secret, string(info), n)
+	return out
+}
+
+// decrypt decrypts the encrypted records sent one way. Records are read
+// with the handshake keys until the Finished message, then with the
+// application keys. Without handshake secrets, or for early data that
+// neither key opens, records are tried with the application keys.
+// Decryption stops at the first application record that fails, such as
+// after a key update.
+func (t tls13Traffic) decrypt(raw *stream, recs []record) *stream {
+	out := &stream{}
+	aead, iv := t.handshake, t.handshakeIV
+	inHandshake := aead != nil
+	if !inHandshake {
+		aead, iv = t.app, t.appIV
+	}
+	var seq uint64
+	var hs []byte
+	for _, r := range recs {
+		if r.typ != recordApplicationData {
+			continue
+		}
+		plain, err := open13(aead, iv, seq, r)
+		if err != nil && inHandshake {
+			if plain, err = open13(t.app, t.appIV, 0, r); err == nil {
+				aead, iv, seq, inHandshake = t.app, t.appIV, 0, false
+			} else {
+				continue
+			}
+		}
+		if err != nil {
+			out.gap = true
+			break
+		}
+		seq++
+
+		// The inner content type is the last byte that is not padding.
+		i := len(plain) - 1
+		for i >= 0 && plain[i] == 0 {
+			i--
+		}
+		if i < 0 {
+			continue
+		}
+		typ, content := plain[i], plain[:i]
+		switch {
+		case typ == recordApplicationData && !inHandshake:
+			out.append(content, raw.timeAt(r.offset))
+		case typ == recordHandshake && inHandshake:
+			hs = append(hs, content...)
+			if finished(hs) {
+				aead, iv, seq, inHandshake = t.app, t.appIV, 0, false
+			}
+		}
+	}
+	return out
+}
+
+// finished reports whether the handshake messages in hs include Finished.
+func finished(hs []byte) bool {
+	for len(hs) >= 4 {
+		if hs[0] == 20 {
+			return true
+		}
+		n := 4 + (int(hs[1])<<16 | int(hs[2])<<8 | int(hs[3]))
+		if len(hs) < n {
+			break
+		}
+		hs = hs[n:]
+	}
+	return false
+}
+
+func open13(aead cipher.AEAD, iv []byte, seq uint64, r record) ([]byte, error) {
+	nonce := make([]byte, len(iv))
+	copy(nonce, iv)
+	xorSeq(nonce, seq)
+	return aead.Open(nil, nonce, r.fragment, r.header)
+}
+
+// xorSeq mixes a record sequence number into a nonce.
+func xorSeq(nonce []byte, seq uint64) {
+	for i := 0; i < 8; i++ {
+		nonce[len(nonce)-1-i] ^= byte(seq >> (8 * i))
+	}
+}
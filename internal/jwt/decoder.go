@@ -0,0 +1,93 @@
+package jwt
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/standrze/rogue/internal/logger"
+)
+
+// Decoder implements logger.JWTDecoder for tokens in the Authorization
+// header and in cookies.
+type Decoder struct{}
+
+func (Decoder) DecodeJWTs(h http.Header, response bool) []logger.JWT {
+	var out []logger.JWT
+	add := func(source, value string) {
+		for _, raw := range Pattern.FindAllString(value, -1) {
+			if t, err := Parse(raw); err == nil {
+				out = append(out, logger.JWT{Source: source, Token: raw, Header: t.Header, Claims: t.Claims})
+			}
+		}
+	}
+	if response {
+		for _, c := range (&http.Response{Header: h}).Cookies() {
+			add("Set-Cookie "+c.Name, c.Value)
+		}
+		return out
+	}
+	for _, v := range h.Values("Authorization") {
+		add("Authorization", v)
+	}
+	for _, line := range h.Values("Cookie") {
+		cs, _ := http.ParseCookie(line)
+		for _, c := range cs {
+			add("cookie "+c.Name, c.Value)
+		}
+	}
+	return out
+}
+
+// Find returns the first token in h, from the named cookie or, when cookie
+// is empty, from the Authorization header.
+func Find(h http.Header, cookie string) (*Token, bool) {
+	var values []string
+	if cookie == "" {
+		values = h.Values("Authorization")
+	} else {
+		for _, line := range h.Values("Cookie") {
+			cs, _ := http.ParseCookie(line)
+			for _, c := range cs {
+				if c.Name == cookie {
+					values = append(values, c.Value)
+				}
+			}
+		}
+	}
+	for _, v := range values {
+		for _, raw := range Pattern.FindAllString(v, -1) {
+			if t, err := Parse(raw); err == nil {
+				return t, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// Replace swaps the token old for new in h, in the named cookie or, when
+// cookie is empty, in the Authorization header.
+func Replace(h http.Header, cookie, old, new string) {
+	name := "Authorization"
+	if cookie != "" {
+		name = "Cookie"
+	}
+	values := h.Values(name)
+	for i, v := range values {
+		if cookie == "" {
+			values[i] = strings.ReplaceAll(v, old, new)
+			continue
+		}
+		cs, err := http.ParseCookie(v)
+		if err != nil {
+			continue
+		}
+		parts := make([]string, len(cs))
+		for j, c := range cs {
+			if c.Name == cookie {
+				c.Value = strings.ReplaceAll(c.Value, old, new)
+			}
+			parts[j] = c.Name + "=" + c.Value
+		}
+		values[i] = strings.Join(parts, "; ")
+	}
+}
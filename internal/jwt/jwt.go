@@ -0,0 +1,215 @@
+// Package jwt decodes the JSON Web Tokens carried in traffic and signs
+// edited ones, for testing how servers check them. Tokens are never
+// verified.
+package jwt
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+	"slices"
+	"strings"
+
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+)
+
+// Pattern matches a JWT in a header value.
+var Pattern = regexp.MustCompile(`eyJ[A-Za-z0-9_-]{5,}\.eyJ[A-Za-z0-9_-]*\.[A-Za-z0-9_-]*`)
+
+// Algorithms lists the algorithms Sign supports.
+var Algorithms = []string{"none", "HS256", "HS384", "HS512", "RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}
+
+// Token is a decoded JWT.
+type Token struct {
+	Raw string
+	// Header and Claims are the decoded JSON of the first two segments.
+	Header    json.RawMessage
+	Claims    json.RawMessage
+	Signature string
+}
+
+// Parse decodes a compact JWT without verifying it.
+func Parse(raw string) (*Token, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("a JWT has three dot-separated parts")
+	}
+	t := &Token{Raw: raw, Signature: parts[2]}
+	for i, dst := range []*json.RawMessage{&t.Header, &t.Claims} {
+		data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[i], "="))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", []string{"header", "claims"}[i], err)
+		}
+		if !json.Valid(data) || !bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+			return nil, fmt.Errorf("%s is not a JSON object", []string{"header", "claims"}[i])
+		}
+		*dst = data
+	}
+	return t, nil
+}
+
+// Alg returns the algorithm the token's header names.
+func (t *Token) Alg() string {
+	var h struct {
+		Alg string `json:"alg"`
+	}
+	json.Unmarshal(t.Header, &h)
+	return h.Alg
+}
+
+// Edit changes a token before it is signed again. Members of Header and
+// Claims replace those of the token; nil values remove them. Alg, when
+// set, replaces the algorithm.
+type Edit struct {
+	Alg    string
+	Header map[string]any
+	Claims map[string]any
+}
+
+// Resign applies edit to t and signs the result with key: an HMAC secret,
+// or a PEM private key for the RSA and ECDSA algorithms.
+func Resign(t *Token, edit Edit, key []byte) (string, error) {
+	header, err := decodeObject(t.Header)
+	if err != nil {
+		return "", err
+	}
+	claims, err := decodeObject(t.Claims)
+	if err != nil {
+		return "", err
+	}
+	merge(header, edit.Header)
+	merge(claims, edit.Claims)
+	if edit.Alg != "" {
+		header["alg"] = edit.Alg
+	}
+	return Sign(header, claims, key)
+}
+
+// Sign encodes header and claims and signs them with the algorithm the
+// header names.
+func Sign(header, claims map[string]any, key []byte) (string, error) {
+	alg, _ := header["alg"].(string)
+	if !slices.Contains(Algorithms, alg) {
+		return "", fmt.Errorf("unsupported alg %q (have %s)", alg, strings.Join(Algorithms, ", "))
+	}
+	var parts []string
+	for _, v := range []map[string]any{header, claims} {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, base64.RawURLEncoding.EncodeToString(data))
+	}
+	input := parts[0] + "." + parts[1]
+	sig, err := signature(alg, []byte(input), key)
+	if err != nil {
+		return "", err
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+func signature(alg string, input, key []byte) ([]byte, error) {
+	if alg == "none" {
+		return nil, nil
+	}
+	hash := map[string]crypto.Hash{"256": crypto.SHA256, "384": crypto.SHA384, "512": crypto.SHA512}[alg[2:]]
+	if alg[:2] == "HS" {
+		if len(key) == 0 {
+			return nil, fmt.Errorf("%s needs a secret", alg)
+		}
+		mac := hmac.New(hash.New, key)
+		mac.Write(input)
+		return mac.Sum(nil), nil
+	}
+
+	priv, err := privateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", alg, err)
+	}
+	h := hash.New()
+	h.Write(input)
+	digest := h.Sum(nil)
+	switch k := priv.(type) {
+	case *rsa.PrivateKey:
+		switch alg[:2] {
+		case "RS":
+			return rsa.SignPKCS1v15(rand.Reader, k, hash, digest)
+		case "PS":
+			return rsa.SignPSS(rand.Reader, k, hash, digest, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		}
+	case *ecdsa.PrivateKey:
+		if alg[:2] == "ES" {
+			r, s, err := ecdsa.Sign(rand.Reader, k, digest)
+			if err != nil {
+				return nil, err
+			}
+			// JWS signatures are r and s as fixed-size big-endian numbers.
+			size := (k.Curve.Params().BitSize + 7) / 8
+			return append(fixed(r, size), fixed(s, size)...), nil
+		}
+	}
+	return nil, fmt.Errorf("a %T cannot sign %s", priv, alg)
+}
+
+// privateKey parses a PEM-encoded PKCS #1, PKCS #8 or SEC 1 private key.
+func privateKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("the key is not PEM encoded")
+	}
+	if k, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return k, nil
+	}
+	if k, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return k, nil
+	}
+	k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, errors.New("the key is not an RSA or ECDSA private key")
+	}
+	signer, ok := k.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported key type %T", k)
+	}
+	return signer, nil
+}
+
+func fixed(n *big.Int, size int) []byte {
+	out := make([]byte, size)
+	return n.FillBytes(out)
+}
+
+func decodeObject(data json.RawMessage) (map[string]any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var m map[string]any
+	if err := dec.Decode(&m); err != nil {
+		return nil, err
+	}
+	if m == nil {
+		m = map[string]any{}
+	}
+	return m, nil
+}
+
+func merge(dst, edits map[string]any) {
+	for k, v := range edits {
+		if v == nil {
+			delete(dst, k)
+		} else {
+			dst[k] = v
+		}
+	}
+}
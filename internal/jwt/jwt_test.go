@@ -0,0 +1,133 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+const token = "eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9.eyJzdWIiOiIxIiwicm9sZSI6InVzZXIifQ.sig"
+
+func segments(t *testing.T, signed string) (input string, header, claims string, sig []byte) {
+	t.Helper()
+	parts := strings.Split(signed, ".")
+	if len(parts) != 3 {
+		t.Fatalf("%q is not a JWT", signed)
+	}
+	h, _ := base64.RawURLEncoding.DecodeString(parts[0])
+	c, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	sig, _ = base64.RawURLEncoding.DecodeString(parts[2])
+	return parts[0] + "." + parts[1], string(h), string(c), sig
+}
+
+func TestParse(t *testing.T) {
+	tok, err := Parse(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tok.Alg() != "HS256" || string(tok.Claims) != `{"sub":"1","role":"user"}` || tok.Signature != "sig" {
+		t.Errorf("Parse = %+v", tok)
+	}
+	for _, bad := range []string{"a.b", "eyJ!.eyJ.x", "bnVsbA.eyJ9.x"} {
+		if _, err := Parse(bad); err == nil {
+			t.Errorf("Parse(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestResign(t *testing.T) {
+	tok, _ := Parse(token)
+	signed, err := Resign(tok, Edit{Claims: map[string]any{"role": "admin", "sub": nil}}, []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	input, header, claims, sig := segments(t, signed)
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(input))
+	if header != `{"alg":"HS256","typ":"JWT"}` || claims != `{"role":"admin"}` || !hmac.Equal(sig, mac.Sum(nil)) {
+		t.Errorf("HS256: %s %s %x", header, claims, sig)
+	}
+
+	signed, _ = Resign(tok, Edit{Alg: "none"}, nil)
+	if _, header, _, sig := segments(t, signed); header != `{"alg":"none","typ":"JWT"}` || len(sig) != 0 || !strings.HasSuffix(signed, ".") {
+		t.Errorf("none: %s", signed)
+	}
+
+	if _, err := Resign(tok, Edit{}, nil); err == nil {
+		t.Error("HS256 signed without a secret")
+	}
+	if _, err := Resign(tok, Edit{Alg: "XS256"}, []byte("k")); err == nil {
+		t.Error("signed with an unknown algorithm")
+	}
+}
+
+func TestResignAsymmetric(t *testing.T) {
+	tok, _ := Parse(token)
+
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	pemKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)})
+	signed, err := Resign(tok, Edit{Alg: "RS256"}, pemKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	input, _, _, sig := segments(t, signed)
+	digest := sha256.Sum256([]byte(input))
+	if err := rsa.VerifyPKCS1v15(&rsaKey.PublicKey, crypto.SHA256, digest[:], sig); err != nil {
+		t.Errorf("RS256: %v", err)
+	}
+
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, _ := x509.MarshalPKCS8PrivateKey(ecKey)
+	signed, err = Resign(tok, Edit{Alg: "ES256"}, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	input, _, _, sig = segments(t, signed)
+	digest = sha256.Sum256([]byte(input))
+	r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+	if len(sig) != 64 || !ecdsa.Verify(&ecKey.PublicKey, digest[:], r, s) {
+		t.Error("ES256 signature does not verify")
+	}
+
+	if _, err := Resign(tok, Edit{Alg: "ES256"}, pemKey); err == nil {
+		t.Error("an RSA key signed ES256")
+	}
+}
+
+func TestDecoder(t *testing.T) {
+	req := http.Header{
+		"Authorization": {"Bearer " + token},
+		"Cookie":        {"theme=dark; session=" + token},
+	}
+	found := Decoder{}.DecodeJWTs(req, false)
+	if len(found) != 2 || found[0].Source != "Authorization" || found[1].Source != "cookie session" || found[1].Token != token {
+		t.Errorf("request JWTs = %+v", found)
+	}
+	res := http.Header{"Set-Cookie": {"id=" + token + "; HttpOnly", "plain=1"}}
+	if found := (Decoder{}).DecodeJWTs(res, true); len(found) != 1 || found[0].Source != "Set-Cookie id" {
+		t.Errorf("response JWTs = %+v", found)
+	}
+
+	if tok, ok := Find(req, "session"); !ok || tok.Raw != token {
+		t.Errorf("Find(session) = %v, %v", tok, ok)
+	}
+	if _, ok := Find(req, "theme"); ok {
+		t.Error("Find(theme) found a token")
+	}
+	Replace(req, "session", token, "new")
+	Replace(req, "", token, "other")
+	if req.Get("Cookie") != "theme=dark; session=new" || req.Get("Authorization") != "Bearer other" {
+		t.Errorf("Replace left %v", req)
+	}
+}
@@ -0,0 +1,126 @@
+// Package listen opens the sockets rogue serves on: TCP and Unix domain
+// addresses, and sockets passed in by systemd socket activation.
+package listen
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// firstActivatedFD is SD_LISTEN_FDS_START.
+const firstActivatedFD = 3
+
+// Listen opens addr, which is "unix:///path/to.sock", "tcp://host:port" or
+// a bare "host:port". A stale socket file left by an earlier run is
+// replaced; other files are not.
+func Listen(addr string) (net.Listener, error) {
+	if path, ok := strings.CutPrefix(addr, "unix://"); ok {
+		if err := removeStaleSocket(path); err != nil {
+			return nil, err
+		}
+		return net.Listen("unix", path)
+	}
+	return net.Listen("tcp", strings.TrimPrefix(addr, "tcp://"))
+}
+
+// ListenRetry is Listen, retrying for up to wait while the address is in
+// use, as when a previous instance is still shutting down. Attempts back off
+// from 100ms to 2s. Errors explain how to recover from common failures.
+func ListenRetry(addr string, wait time.Duration) (net.Listener, error) {
+	deadline := time.Now().Add(wait)
+	delay := 100 * time.Millisecond
+	for {
+		l, err := Listen(addr)
+		if err == nil {
+			return l, nil
+		}
+		if !errors.Is(err, syscall.EADDRINUSE) || time.Now().Add(delay).After(deadline) {
+			return nil, Explain(addr, err)
+		}
+		time.Sleep(delay)
+		delay = min(delay*2, 2*time.Second)
+	}
+}
+
+// Explain adds what to do about an error binding addr when the cause is
+// known: the address being in use, or a privileged port.
+func Explain(addr string, err error) error {
+	switch {
+	case errors.Is(err, syscall.EADDRINUSE):
+		return fmt.Errorf("%w\n%s is already in use by another process. Stop it, choose another port with --port, "+
+			"use --port 0 to pick a free one, or --retry-bind to wait for it to be released", err, addr)
+	case errors.Is(err, fs.ErrPermission):
+		if _, port, perr := net.SplitHostPort(strings.TrimPrefix(addr, "tcp://")); perr == nil {
+			if n, _ := strconv.Atoi(port); n > 0 && n < 1024 {
+				return fmt.Errorf("%w\nports below 1024 need root or the CAP_NET_BIND_SERVICE capability "+
+					"(setcap cap_net_bind_service=+ep $(which rogue)); or use a port above 1023", err)
+			}
+		}
+		return fmt.Errorf("%w\nnot permitted to listen on %s", err, addr)
+	}
+	return err
+}
+
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&fs.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+	// Only remove the socket if nothing is serving on it.
+	if c, err := net.Dial("unix", path); err == nil {
+		c.Close()
+		return fmt.Errorf("%s is in use", path)
+	}
+	return os.Remove(path)
+}
+
+// Activated returns the listening sockets passed by systemd, in the order
+// of the socket unit, or nil when the process was not socket activated. The
+// activation variables are cleared so child processes do not inherit them.
+func Activated() ([]net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil, nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	listeners := make([]net.Listener, 0, n)
+	for i := 0; i < n; i++ {
+		name := "LISTEN_FD_" + strconv.Itoa(firstActivatedFD+i)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		f := os.NewFile(uintptr(firstActivatedFD+i), name)
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("activated socket %s: %w", name, err)
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
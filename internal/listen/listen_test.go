@@ -0,0 +1,149 @@
+package listen
+
+import (
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rogue.sock")
+
+	l, err := Listen("unix://" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Listen("unix://" + path); err == nil {
+		t.Error("Expected a socket in use not to be replaced")
+	}
+	c, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+
+	// A socket left behind by a crashed process is replaced.
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
+	l, err = Listen("unix://" + path)
+	if err != nil {
+		t.Fatalf("Stale socket not replaced: %v", err)
+	}
+	l.Close()
+
+	file := filepath.Join(t.TempDir(), "config.json")
+	os.WriteFile(file, []byte("{}"), 0644)
+	if _, err := Listen("unix://" + file); err == nil {
+		t.Error("Expected a regular file not to be replaced")
+	}
+}
+
+func TestNotActivated(t *testing.T) {
+	t.Setenv("LISTEN_PID", "1")
+	t.Setenv("LISTEN_FDS", "1")
+	if ls, err := Activated(); err != nil || ls != nil {
+		t.Errorf("Sockets for another process were used: %v %v", ls, err)
+	}
+}
+
+func TestProxyProtocol(t *testing.T) {
+	v2 := append([]byte("\r\n\r\n\x00\r\nQUIT\n"), 0x21, 0x11, 0, 12+3,
+		203, 0, 113, 9, 10, 0, 0, 1, 0x1f, 0x90, 0x0c, 0x38,
+		0x04, 0, 0) // an empty TLV, skipped
+	for _, tc := range []struct {
+		name    string
+		trusted []string
+		header  string
+		remote  string
+		local   string
+		closed  bool
+	}{
+		{name: "v1", header: "PROXY TCP4 198.51.100.7 10.0.0.1 4242 8080\r\n", remote: "198.51.100.7:4242", local: "10.0.0.1:8080"},
+		{name: "v1 IPv6", header: "PROXY TCP6 2001:db8::7 2001:db8::1 4242 443\r\n", remote: "[2001:db8::7]:4242", local: "[2001:db8::1]:443"},
+		{name: "v2", header: string(v2), remote: "203.0.113.9:8080", local: "10.0.0.1:3128"},
+		{name: "v1 unknown", header: "PROXY UNKNOWN\r\n", remote: "127.0.0.1"},
+		{name: "missing", header: "GET / HTTP/1.1\r\n", closed: true},
+		{name: "untrusted peer", trusted: []string{"192.0.2.0/24"}, header: "", remote: "127.0.0.1"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			trusted, err := ParseTrusted(tc.trusted)
+			if err != nil {
+				t.Fatal(err)
+			}
+			inner, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			l := ProxyProtocol(inner, trusted)
+			defer l.Close()
+
+			c, err := net.Dial("tcp", inner.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			c.Write([]byte(tc.header + "hello"))
+
+			accepted := make(chan net.Conn, 1)
+			go func() {
+				if conn, err := l.Accept(); err == nil {
+					accepted <- conn
+				}
+			}()
+			var conn net.Conn
+			select {
+			case conn = <-accepted:
+			case <-time.After(500 * time.Millisecond):
+			}
+			if tc.closed {
+				if conn != nil {
+					t.Fatalf("accepted a connection without a header from %s", conn.RemoteAddr())
+				}
+				return
+			}
+			if conn == nil {
+				t.Fatal("connection not accepted")
+			}
+			defer conn.Close()
+
+			if got := conn.RemoteAddr().String(); !strings.HasPrefix(got, tc.remote) {
+				t.Errorf("remote = %s, want %s", got, tc.remote)
+			}
+			if tc.local != "" && conn.LocalAddr().String() != tc.local {
+				t.Errorf("local = %s, want %s", conn.LocalAddr(), tc.local)
+			}
+			buf := make([]byte, 5)
+			if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "hello" {
+				t.Errorf("payload = %q, %v", buf, err)
+			}
+		})
+	}
+}
+
+func TestListenRetry(t *testing.T) {
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := busy.Addr().String()
+
+	_, err = ListenRetry(addr, 0)
+	if err == nil || !strings.Contains(err.Error(), "--port 0") {
+		t.Fatalf("Expected an explained address in use error, got %v", err)
+	}
+
+	// The address is released while ListenRetry waits for it.
+	go func() {
+		time.Sleep(150 * time.Millisecond)
+		busy.Close()
+	}()
+	l, err := ListenRetry(addr, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Retrying did not bind once the address was free: %v", err)
+	}
+	l.Close()
+}
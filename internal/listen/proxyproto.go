@@ -0,0 +1,257 @@
+package listen
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// headerTimeout bounds waiting for a PROXY protocol header.
+const headerTimeout = 10 * time.Second
+
+// v2Signature starts every PROXY protocol v2 header.
+var v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// maxV1Header is the longest v1 header, including its CRLF.
+const maxV1Header = 107
+
+// ParseTrusted parses a list of CIDR prefixes and bare IP addresses.
+func ParseTrusted(ss []string) ([]netip.Prefix, error) {
+	var out []netip.Prefix
+	for _, s := range ss {
+		if p, err := netip.ParsePrefix(s); err == nil {
+			out = append(out, p.Masked())
+			continue
+		}
+		a, err := netip.ParseAddr(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address or prefix %q", s)
+		}
+		out = append(out, netip.PrefixFrom(a.Unmap(), a.Unmap().BitLen()))
+	}
+	return out, nil
+}
+
+// ProxyProtocol wraps l so that connections from trusted peers start with a
+// HAProxy PROXY protocol header, v1 or v2, giving the address of the real
+// client. Accepted connections report that client as their remote address
+// and the address it connected to as their local one. Connections from
+// trusted peers without a valid header are closed. An empty trusted list
+// trusts every peer; Unix domain peers are always trusted.
+func ProxyProtocol(l net.Listener, trusted []netip.Prefix) net.Listener {
+	p := &proxyProtoListener{
+		Listener: l,
+		trusted:  trusted,
+		conns:    make(chan net.Conn),
+		done:     make(chan struct{}),
+	}
+	go p.acceptLoop()
+	return p
+}
+
+type proxyProtoListener struct {
+	net.Listener
+	trusted []netip.Prefix
+	conns   chan net.Conn
+	done    chan struct{}
+	once    sync.Once
+	err     error
+}
+
+func (p *proxyProtoListener) acceptLoop() {
+	for {
+		c, err := p.Listener.Accept()
+		if err != nil {
+			p.stop(err)
+			return
+		}
+		// Reading the header waits for the peer, so it must not hold up
+		// accepting others.
+		go p.handshake(c)
+	}
+}
+
+func (p *proxyProtoListener) handshake(c net.Conn) {
+	conn := c
+	if p.trusts(c.RemoteAddr()) {
+		br := bufio.NewReaderSize(c, 256)
+		c.SetReadDeadline(time.Now().Add(headerTimeout))
+		src, dst, err := readHeader(br)
+		c.SetReadDeadline(time.Time{})
+		if err != nil {
+			c.Close()
+			return
+		}
+		pc := &proxiedConn{Conn: c, r: br, remote: c.RemoteAddr(), local: c.LocalAddr()}
+		if src != nil {
+			pc.remote, pc.local = src, dst
+		}
+		conn = pc
+	}
+
+	select {
+	case p.conns <- conn:
+	case <-p.done:
+		c.Close()
+	}
+}
+
+func (p *proxyProtoListener) trusts(addr net.Addr) bool {
+	tcp, ok := addr.(*net.TCPAddr)
+	if !ok || len(p.trusted) == 0 {
+		return true
+	}
+	ip, _ := netip.AddrFromSlice(tcp.IP)
+	for _, t := range p.trusted {
+		if t.Contains(ip.Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *proxyProtoListener) stop(err error) {
+	p.once.Do(func() {
+		p.err = err
+		close(p.done)
+	})
+}
+
+func (p *proxyProtoListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-p.conns:
+		return c, nil
+	case <-p.done:
+		return nil, p.err
+	}
+}
+
+func (p *proxyProtoListener) Close() error {
+	err := p.Listener.Close()
+	p.stop(net.ErrClosed)
+	return err
+}
+
+// proxiedConn is a connection whose header has been read, reporting the
+// addresses the header carried.
+type proxiedConn struct {
+	net.Conn
+	r             *bufio.Reader
+	remote, local net.Addr
+}
+
+func (c *proxiedConn) Read(p []byte) (int, error) { return c.r.Read(p) }
+func (c *proxiedConn) RemoteAddr() net.Addr       { return c.remote }
+func (c *proxiedConn) LocalAddr() net.Addr        { return c.local }
+func (c *proxiedConn) NetConn() net.Conn          { return c.Conn }
+
+// readHeader reads a v1 or v2 header. The addresses are nil for headers
+// that do not describe a proxied TCP connection, such as health checks.
+func readHeader(r *bufio.Reader) (src, dst net.Addr, err error) {
+	start, err := r.Peek(len(v2Signature))
+	if err != nil {
+		return nil, nil, err
+	}
+	if bytes.Equal(start, v2Signature) {
+		return readV2(r)
+	}
+	if bytes.HasPrefix(start, []byte("PROXY ")) {
+		return readV1(r)
+	}
+	return nil, nil, errors.New("missing PROXY protocol header")
+}
+
+// readV1 parses "PROXY TCP4 <src> <dst> <sport> <dport>\r\n".
+func readV1(r *bufio.Reader) (src, dst net.Addr, err error) {
+	var line []byte
+	for len(line) < maxV1Header {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	s, ok := strings.CutSuffix(string(line), "\r\n")
+	if !ok {
+		return nil, nil, errors.New("PROXY v1 header is too long")
+	}
+
+	fields := strings.Split(s, " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, nil, fmt.Errorf("malformed PROXY v1 header %q", s)
+	}
+	if src, err = tcpAddr(fields[2], fields[4]); err != nil {
+		return nil, nil, err
+	}
+	if dst, err = tcpAddr(fields[3], fields[5]); err != nil {
+		return nil, nil, err
+	}
+	return src, dst, nil
+}
+
+func tcpAddr(ip, port string) (*net.TCPAddr, error) {
+	a, err := netip.ParseAddr(ip)
+	if err != nil {
+		return nil, fmt.Errorf("PROXY v1 header: %w", err)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("PROXY v1 header: invalid port %q", port)
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(a, uint16(p))), nil
+}
+
+// readV2 parses the binary header: signature, version and command,
+// address family, length and the addresses, followed by TLVs that are
+// skipped.
+func readV2(r *bufio.Reader) (src, dst net.Addr, err error) {
+	var head [16]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return nil, nil, err
+	}
+	if head[12]>>4 != 2 {
+		return nil, nil, fmt.Errorf("unsupported PROXY protocol version %d", head[12]>>4)
+	}
+	body := make([]byte, binary.BigEndian.Uint16(head[14:]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, nil, err
+	}
+
+	// LOCAL connections come from the balancer itself.
+	if head[12]&0x0f == 0 {
+		return nil, nil, nil
+	}
+	var size int
+	switch head[13] {
+	case 0x11: // TCP over IPv4
+		size = 4
+	case 0x21: // TCP over IPv6
+		size = 16
+	default:
+		return nil, nil, nil
+	}
+	if len(body) < 2*size+4 {
+		return nil, nil, errors.New("PROXY v2 header is too short for its addresses")
+	}
+	srcIP, _ := netip.AddrFromSlice(body[:size])
+	dstIP, _ := netip.AddrFromSlice(body[size : 2*size])
+	ports := body[2*size:]
+	src = net.TCPAddrFromAddrPort(netip.AddrPortFrom(srcIP, binary.BigEndian.Uint16(ports)))
+	dst = net.TCPAddrFromAddrPort(netip.AddrPortFrom(dstIP, binary.BigEndian.Uint16(ports[2:])))
+	return src, dst, nil
+}
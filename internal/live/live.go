@@ -0,0 +1,214 @@
+// Package live streams session entries to browsers and scripts as Server-
+// Sent Events while the proxy records them. Request and response bodies
+// are cut to a short preview so a busy stream stays light; the complete
+// bodies stay in the session and are fetched per flow when needed.
+package live
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/standrze/rogue/internal/logger"
+)
+
+// heartbeat is how often an idle stream is written to, so proxies and
+// browsers keep the connection open.
+const heartbeat = 15 * time.Second
+
+// subscriberQueue bounds the entries waiting for a slow client. Entries
+// beyond it are dropped and the client is told how many it missed.
+const subscriberQueue = 256
+
+// Hub is a log sink that fans entries out to the clients streaming from it.
+type Hub struct {
+	// Preview is how many bytes of each body are sent; clients may ask for
+	// fewer, or none, with ?preview=N.
+	Preview int
+
+	mu     sync.Mutex
+	subs   map[*subscriber]struct{}
+	closed bool
+}
+
+type subscriber struct {
+	entries chan []byte
+	preview int
+	// missed counts entries dropped since the last one delivered.
+	missed int
+}
+
+// NewHub returns a hub sending previews of up to preview bytes.
+func NewHub(preview int) *Hub {
+	return &Hub{Preview: preview, subs: map[*subscriber]struct{}{}}
+}
+
+// Send implements logger.Sink.
+func (h *Hub) Send(entries [][]byte) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, e := range entries {
+		for s := range h.subs {
+			s.put(e)
+		}
+	}
+	return nil
+}
+
+// Close implements logger.Sink, ending every stream.
+func (h *Hub) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for s := range h.subs {
+		close(s.entries)
+		delete(h.subs, s)
+	}
+	return nil
+}
+
+func (s *subscriber) put(entry []byte) {
+	select {
+	case s.entries <- entry:
+	default:
+		s.missed++
+	}
+}
+
+func (h *Hub) subscribe(preview int) *subscriber {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := &subscriber{entries: make(chan []byte, subscriberQueue), preview: preview}
+	if h.closed {
+		close(s.entries)
+		return s
+	}
+	h.subs[s] = struct{}{}
+	return s
+}
+
+func (h *Hub) unsubscribe(s *subscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subs[s]; ok {
+		delete(h.subs, s)
+		close(s.entries)
+	}
+}
+
+// takeMissed returns and resets the drop count of s.
+func (h *Hub) takeMissed(s *subscriber) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	n := s.missed
+	s.missed = 0
+	return n
+}
+
+// ServeHTTP streams entries as events named by their type, with the entry
+// data as the event data. A "missed" event reports entries dropped because
+// the client read too slowly.
+func (h *Hub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	preview := h.Preview
+	if v := r.URL.Query().Get("preview"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "preview must be a number of bytes", http.StatusBadRequest)
+			return
+		}
+		preview = min(n, h.Preview)
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	s := h.subscribe(preview)
+	defer h.unsubscribe(s)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	t := time.NewTicker(heartbeat)
+	defer t.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-t.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case entry, ok := <-s.entries:
+			if !ok {
+				return
+			}
+			if n := h.takeMissed(s); n > 0 {
+				fmt.Fprintf(w, "event: missed\ndata: %d\n\n", n)
+			}
+			typ, data, err := previewEntry(entry, s.preview)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", typ, data)
+		}
+		flusher.Flush()
+	}
+}
+
+// Watch calls fn with the type and data of each entry, bodies cut to
+// preview bytes, and the number of entries dropped before it because fn
+// kept up too slowly. It returns when ctx ends, the hub closes or fn fails.
+func (h *Hub) Watch(ctx context.Context, preview int, fn func(typ string, data []byte, missed int) error) error {
+	s := h.subscribe(min(preview, h.Preview))
+	defer h.unsubscribe(s)
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case entry, ok := <-s.entries:
+			if !ok {
+				return nil
+			}
+			missed := h.takeMissed(s)
+			typ, data, err := previewEntry(entry, s.preview)
+			if err != nil {
+				continue
+			}
+			if err := fn(typ, data, missed); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// previewEntry splits an entry into its type and data, cutting request
+// and response bodies to n bytes.
+func previewEntry(entry []byte, n int) (string, []byte, error) {
+	var e logger.Entry
+	if err := json.Unmarshal(entry, &e); err != nil {
+		return "", nil, err
+	}
+	var v interface{ TrimBody(int) bool }
+	switch e.Type {
+	case "request":
+		v = &logger.RequestLog{}
+	case "response":
+		v = &logger.ResponseLog{}
+	default:
+		return e.Type, e.Data, nil
+	}
+	if err := json.Unmarshal(e.Data, v); err != nil {
+		return "", nil, err
+	}
+	if !v.TrimBody(n) {
+		return e.Type, e.Data, nil
+	}
+	data, err := json.Marshal(v)
+	return e.Type, data, err
+}
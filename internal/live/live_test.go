@@ -0,0 +1,96 @@
+package live
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/standrze/rogue/internal/logger"
+)
+
+// next reads the next event from the stream, skipping comments.
+func next(t *testing.T, r *bufio.Reader) (string, string) {
+	t.Helper()
+	var event, data string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && event != "":
+			return event, data
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func TestHub(t *testing.T) {
+	hub := NewHub(4)
+	srv := httptest.NewServer(hub)
+	defer srv.Close()
+
+	res, err := http.Get(srv.URL + "?preview=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if ct := res.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type %q", ct)
+	}
+
+	// Wait for the subscription before sending.
+	for deadline := time.Now().Add(time.Second); ; {
+		hub.mu.Lock()
+		n := len(hub.subs)
+		hub.mu.Unlock()
+		if n == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("client never subscribed")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	hub.Send([][]byte{
+		[]byte(`{"type":"response","data":{"request_id":"a1","status_code":200,"body":"hello world","body_size":11}}`),
+		[]byte(`{"type":"annotation","data":{"request_id":"a1","comment":"note"}}`),
+	})
+
+	r := bufio.NewReader(res.Body)
+	event, data := next(t, r)
+	if event != "response" {
+		t.Fatalf("Got event %q, want response", event)
+	}
+	var rl logger.ResponseLog
+	if err := json.Unmarshal([]byte(data), &rl); err != nil {
+		t.Fatal(err)
+	}
+	if rl.Body != "he" || !rl.Previewed || rl.BodySize != 11 {
+		t.Errorf("Got body %q (preview %v, size %d), want a 2 byte preview of 11", rl.Body, rl.Previewed, rl.BodySize)
+	}
+	if event, data := next(t, r); event != "annotation" || !strings.Contains(data, `"note"`) {
+		t.Errorf("Got %s %s, want the annotation unchanged", event, data)
+	}
+
+	hub.Close()
+	if _, err := r.ReadString('\n'); err == nil {
+		t.Error("Stream still open after Close")
+	}
+}
+
+func TestHubPreviewParam(t *testing.T) {
+	rec := httptest.NewRecorder()
+	NewHub(10).ServeHTTP(rec, httptest.NewRequest("GET", "/api/live?preview=lots", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Got %d, want 400", rec.Code)
+	}
+}
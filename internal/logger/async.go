@@ -0,0 +1,194 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Queue policies decide what happens to entries when the write queue is
+// full.
+const (
+	// QueueBlock makes the proxy wait for the writer.
+	QueueBlock = "block"
+	// QueueDrop discards the entry and counts it.
+	QueueDrop = "drop"
+	// QueueDegrade trims entries as the queue fills, following a
+	// DegradePolicy, and drops them once it is full.
+	QueueDegrade = "degrade"
+)
+
+// maxBatch bounds how many queued entries are written before flushing.
+const maxBatch = 256
+
+// ParseQueuePolicy validates a queue policy. Empty means QueueBlock.
+func ParseQueuePolicy(s string) (string, error) {
+	switch s {
+	case "", QueueBlock:
+		return QueueBlock, nil
+	case QueueDrop:
+		return QueueDrop, nil
+	case QueueDegrade:
+		return QueueDegrade, nil
+	}
+	return "", fmt.Errorf("unknown queue policy %q (want block, drop or degrade)", s)
+}
+
+// QueueStats reports the state of the asynchronous write queue.
+type QueueStats struct {
+	Length   int
+	Capacity int
+	Dropped  uint64
+	// Level and SampledOut are only set under QueueDegrade.
+	Level      DegradeLevel
+	SampledOut uint64
+}
+
+// entryQueue hands encoded entries to a writer goroutine.
+type entryQueue struct {
+	entries chan []byte
+	drop    bool
+	dropped atomic.Uint64
+	degrade *degrader
+
+	// mu guards closed; senders hold it shared so close waits for them.
+	mu     sync.RWMutex
+	closed bool
+	done   chan struct{}
+	err    error
+}
+
+// SetAsync moves writing to a background goroutine fed by a queue of size
+// entries, so logging does not wait for the disk. Entries are written in
+// batches and the file is synced every syncInterval, if positive. policy is
+// QueueBlock, QueueDrop or QueueDegrade. It must be called before the first entry is
+// written. It has no effect on a logger without a session file.
+func (sl *SessionLogger) SetAsync(size int, policy string, syncInterval time.Duration) {
+	if sl.sessionFile == nil {
+		return
+	}
+	q := &entryQueue{
+		entries: make(chan []byte, max(size, 1)),
+		drop:    policy == QueueDrop || policy == QueueDegrade,
+		done:    make(chan struct{}),
+	}
+	if policy == QueueDegrade {
+		q.degrade = &degrader{policy: DefaultDegradePolicy}
+	}
+	sl.queue = q
+	go sl.drain(q, syncInterval)
+}
+
+// QueueStats returns a snapshot of the write queue. It is zero when writes
+// are synchronous.
+func (sl *SessionLogger) QueueStats() QueueStats {
+	q := sl.queue
+	if q == nil {
+		return QueueStats{}
+	}
+	s := QueueStats{Length: len(q.entries), Capacity: cap(q.entries), Dropped: q.dropped.Load()}
+	if q.degrade != nil {
+		s.Level = q.degrade.current()
+		s.SampledOut = q.degrade.sampled.Load()
+	}
+	return s
+}
+
+// degradeLevel returns the current degradation level.
+func (sl *SessionLogger) degradeLevel() DegradeLevel {
+	if q := sl.queue; q != nil && q.degrade != nil {
+		return q.degrade.current()
+	}
+	return DegradeNone
+}
+
+func (q *entryQueue) put(entry []byte) error {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		return os.ErrClosed
+	}
+
+	if !q.drop {
+		q.entries <- entry
+		return nil
+	}
+	select {
+	case q.entries <- entry:
+	default:
+		q.dropped.Add(1)
+	}
+	return nil
+}
+
+// close stops accepting entries and waits for the queued ones to be
+// written, returning the first write error.
+func (q *entryQueue) close() error {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.entries)
+	}
+	q.mu.Unlock()
+
+	<-q.done
+	return q.err
+}
+
+func (sl *SessionLogger) drain(q *entryQueue, syncInterval time.Duration) {
+	defer close(q.done)
+
+	var tick <-chan time.Time
+	if syncInterval > 0 {
+		t := time.NewTicker(syncInterval)
+		defer t.Stop()
+		tick = t.C
+	}
+
+	fail := func(err error) {
+		if err != nil && q.err == nil {
+			q.err = err
+		}
+	}
+
+	for {
+		select {
+		case entry, ok := <-q.entries:
+			if !ok {
+				return
+			}
+			sl.mu.Lock()
+			fail(sl.writeLocked(entry))
+			open := sl.writeBatch(q, fail)
+			fail(sl.buf.Flush())
+			sl.mu.Unlock()
+			if !open {
+				return
+			}
+		case <-tick:
+			sl.mu.Lock()
+			fail(sl.buf.Flush())
+			fail(sl.sessionFile.Sync())
+			sl.mu.Unlock()
+		}
+	}
+}
+
+// writeBatch writes entries that are already queued, up to maxBatch. It
+// reports false once the queue is closed and empty. sl.mu must be held.
+func (sl *SessionLogger) writeBatch(q *entryQueue, fail func(error)) bool {
+	for range maxBatch {
+		select {
+		case entry, ok := <-q.entries:
+			if !ok {
+				return false
+			}
+			fail(sl.writeLocked(entry))
+		default:
+			return true
+		}
+	}
+	return true
+}
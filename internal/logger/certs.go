@@ -0,0 +1,78 @@
+package logger
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"time"
+)
+
+// CertificateInfo describes one certificate of a peer's chain.
+type CertificateInfo struct {
+	Subject     string    `json:"subject"`
+	Issuer      string    `json:"issuer"`
+	DNSNames    []string  `json:"dns_names,omitempty"`
+	IPAddresses []string  `json:"ip_addresses,omitempty"`
+	NotBefore   time.Time `json:"not_before"`
+	NotAfter    time.Time `json:"not_after"`
+	Serial      string    `json:"serial"`
+	SHA256      string    `json:"sha256"`
+	IsCA        bool      `json:"is_ca,omitempty"`
+}
+
+// TLSConnectionLog records an upstream TLS handshake and the outcome of
+// verifying the server's certificate chain.
+type TLSConnectionLog struct {
+	Timestamp   time.Time         `json:"timestamp"`
+	Host        string            `json:"host"`
+	Version     string            `json:"version"`
+	CipherSuite string            `json:"cipher_suite"`
+	ALPN        string            `json:"alpn,omitempty"`
+	Chain       []CertificateInfo `json:"chain"`
+	Verified    bool              `json:"verified"`
+	VerifyError string            `json:"verify_error,omitempty"`
+	// Enforced is false when verification was skipped by policy; the
+	// result is still reported.
+	Enforced bool `json:"enforced"`
+}
+
+// DescribeCertificate extracts the fields of c worth auditing.
+func DescribeCertificate(c *x509.Certificate) CertificateInfo {
+	sum := sha256.Sum256(c.Raw)
+	info := CertificateInfo{
+		Subject:   c.Subject.String(),
+		Issuer:    c.Issuer.String(),
+		DNSNames:  c.DNSNames,
+		NotBefore: c.NotBefore,
+		NotAfter:  c.NotAfter,
+		Serial:    c.SerialNumber.Text(16),
+		SHA256:    hex.EncodeToString(sum[:]),
+		IsCA:      c.IsCA,
+	}
+	for _, ip := range c.IPAddresses {
+		info.IPAddresses = append(info.IPAddresses, ip.String())
+	}
+	return info
+}
+
+// CaptureTLSConnection builds the record for a handshake with host.
+// verifyErr is the result of chain verification, enforced or not.
+func CaptureTLSConnection(host string, cs tls.ConnectionState, verifyErr error, enforced bool) *TLSConnectionLog {
+	entry := &TLSConnectionLog{
+		Timestamp:   time.Now(),
+		Host:        host,
+		Version:     tls.VersionName(cs.Version),
+		CipherSuite: tls.CipherSuiteName(cs.CipherSuite),
+		ALPN:        cs.NegotiatedProtocol,
+		Verified:    verifyErr == nil,
+		Enforced:    enforced,
+	}
+	if verifyErr != nil {
+		entry.VerifyError = verifyErr.Error()
+	}
+	for _, c := range cs.PeerCertificates {
+		entry.Chain = append(entry.Chain, DescribeCertificate(c))
+	}
+	return entry
+}
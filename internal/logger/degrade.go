@@ -0,0 +1,197 @@
+package logger
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DegradeLevel is how much detail is left out of entries while the write
+// queue is backed up. Each level includes the ones before it.
+type DegradeLevel int
+
+const (
+	// DegradeNone logs entries in full.
+	DegradeNone DegradeLevel = iota
+	// DegradeBodies measures bodies without keeping them.
+	DegradeBodies
+	// DegradeHeaders also leaves out headers.
+	DegradeHeaders
+	// DegradeSample also logs only one flow in DegradePolicy.SampleEvery.
+	DegradeSample
+)
+
+func (l DegradeLevel) String() string {
+	switch l {
+	case DegradeBodies:
+		return "bodies"
+	case DegradeHeaders:
+		return "headers"
+	case DegradeSample:
+		return "sample"
+	}
+	return "none"
+}
+
+// DegradePolicy sets how full the write queue must be, as a fraction of its
+// capacity, for each level to start. A level is left once the queue drains
+// below half of its threshold.
+type DegradePolicy struct {
+	Bodies      float64
+	Headers     float64
+	Sample      float64
+	SampleEvery int
+}
+
+// DefaultDegradePolicy is used by QueueDegrade until SetDegradePolicy is
+// called.
+var DefaultDegradePolicy = DegradePolicy{Bodies: 0.5, Headers: 0.75, Sample: 0.9, SampleEvery: 10}
+
+// Degradation is written whenever the degradation level changes.
+type Degradation struct {
+	Timestamp   time.Time `json:"timestamp"`
+	Level       string    `json:"level"`
+	Previous    string    `json:"previous"`
+	QueueLength int       `json:"queue_length"`
+	Capacity    int       `json:"queue_capacity"`
+}
+
+// degradable is implemented by entries that can be logged with less detail.
+type degradable interface {
+	degrade(level DegradeLevel)
+	flowID() string
+}
+
+func (r *RequestLog) flowID() string  { return r.RequestID }
+func (r *ResponseLog) flowID() string { return r.RequestID }
+func (e *SSEEventLog) flowID() string { return e.RequestID }
+
+func (r *RequestLog) degrade(level DegradeLevel) {
+	if level >= DegradeBodies {
+		r.Body, r.BodyEncoding, r.Decoded = "", "", nil
+		for i := range r.GraphQL {
+			r.GraphQL[i].Query, r.GraphQL[i].Variables = "", nil
+		}
+		r.Degraded = level.String()
+	}
+	if level >= DegradeHeaders {
+		r.Headers, r.JWTs = nil, nil
+	}
+}
+
+func (r *ResponseLog) degrade(level DegradeLevel) {
+	if level >= DegradeBodies {
+		r.Body, r.BodyEncoding, r.Decoded = "", "", nil
+		r.Degraded = level.String()
+	}
+	if level >= DegradeHeaders {
+		r.Headers, r.JWTs = nil, nil
+	}
+}
+
+func (e *SSEEventLog) degrade(level DegradeLevel) {
+	if level >= DegradeBodies {
+		e.Data = ""
+		e.Degraded = level.String()
+	}
+}
+
+// degrader tracks the degradation level of a queue.
+type degrader struct {
+	policy  DegradePolicy
+	sampled atomic.Uint64
+
+	mu    sync.Mutex
+	level DegradeLevel
+}
+
+// update moves the level to suit a queue holding length of capacity
+// entries and reports the previous level.
+func (d *degrader) update(length, capacity int) (level, prev DegradeLevel) {
+	fill := float64(length) / float64(capacity)
+	thresholds := [...]float64{DegradeBodies: d.policy.Bodies, DegradeHeaders: d.policy.Headers, DegradeSample: d.policy.Sample}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	prev = d.level
+	for d.level < DegradeSample && thresholds[d.level+1] > 0 && fill >= thresholds[d.level+1] {
+		d.level++
+	}
+	for d.level > DegradeNone && (thresholds[d.level] <= 0 || fill < thresholds[d.level]/2) {
+		d.level--
+	}
+	return d.level, prev
+}
+
+// current returns the level without updating it.
+func (d *degrader) current() DegradeLevel {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.level
+}
+
+// keep reports whether the flow id is logged at level.
+func (d *degrader) keep(level DegradeLevel, id string) bool {
+	if level < DegradeSample || id == "" || d.policy.SampleEvery <= 1 {
+		return true
+	}
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	if h.Sum32()%uint32(d.policy.SampleEvery) == 0 {
+		return true
+	}
+	d.sampled.Add(1)
+	return false
+}
+
+// SetDegradePolicy replaces the thresholds of a queue using QueueDegrade.
+// Like SetAsync, it must be called before the first entry is written.
+func (sl *SessionLogger) SetDegradePolicy(p DegradePolicy) {
+	if q := sl.queue; q != nil && q.degrade != nil {
+		q.degrade.policy = p
+	}
+}
+
+// degrade applies the current degradation level to data, recording level
+// changes, and reports whether the entry should still be written.
+func (sl *SessionLogger) degrade(data any) bool {
+	q := sl.queue
+	if q == nil || q.degrade == nil {
+		return true
+	}
+	length, capacity := len(q.entries), cap(q.entries)
+	level, prev := q.degrade.update(length, capacity)
+	if level != prev {
+		sl.writeDegradation(Degradation{
+			Timestamp:   time.Now(),
+			Level:       level.String(),
+			Previous:    prev.String(),
+			QueueLength: length,
+			Capacity:    capacity,
+		})
+	}
+
+	d, ok := data.(degradable)
+	if !ok || level == DegradeNone {
+		return true
+	}
+	if !q.degrade.keep(level, d.flowID()) {
+		return false
+	}
+	d.degrade(level)
+	return true
+}
+
+func (sl *SessionLogger) writeDegradation(d Degradation) {
+	entry, err := json.MarshalIndent(map[string]any{
+		"type": "degradation",
+		"data": d,
+	}, "", "  ")
+	if err != nil {
+		return
+	}
+	sl.toSinks(entry)
+	sl.queue.put(entry)
+}
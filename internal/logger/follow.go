@@ -0,0 +1,83 @@
+package logger
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// FollowSession calls fn with every entry of the session file at path,
+// then waits for more, polling every interval, until the session is closed
+// or ctx is done. Gzipped sessions are complete and are read through.
+func FollowSession(ctx context.Context, path string, interval time.Duration, fn func(Entry) error) error {
+	if strings.HasSuffix(path, ".gz") {
+		entries, err := ReadSession(path)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if err := fn(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(&followReader{ctx: ctx, r: f, interval: interval})
+	tok, err := dec.Token()
+	if err != nil {
+		return ignoreDone(ctx, err)
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+		return fmt.Errorf("session is not a JSON array")
+	}
+	for dec.More() {
+		var e Entry
+		if err := dec.Decode(&e); err != nil {
+			return ignoreDone(ctx, err)
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func ignoreDone(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+// followReader reads a file that is still being written, waiting at the
+// end for more data instead of returning io.EOF.
+type followReader struct {
+	ctx      context.Context
+	r        io.Reader
+	interval time.Duration
+}
+
+func (f *followReader) Read(p []byte) (int, error) {
+	for {
+		n, err := f.r.Read(p)
+		if n > 0 || err != io.EOF {
+			return n, err
+		}
+		select {
+		case <-f.ctx.Done():
+			return 0, f.ctx.Err()
+		case <-time.After(f.interval):
+		}
+	}
+}
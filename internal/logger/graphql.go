@@ -0,0 +1,53 @@
+package logger
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// GraphQLOperation is one GraphQL operation of a request. Query and
+// Variables are only recorded when bodies are logged.
+type GraphQLOperation struct {
+	Name      string          `json:"operation,omitempty"`
+	Type      string          `json:"type,omitempty"`
+	Query     string          `json:"query,omitempty"`
+	Variables json.RawMessage `json:"variables,omitempty"`
+}
+
+// GraphQLParser finds the GraphQL operations of a request, leaving its body
+// readable.
+type GraphQLParser interface {
+	ParseGraphQL(req *http.Request) []GraphQLOperation
+}
+
+// SetGraphQLParser installs p to record the operations of every captured
+// GraphQL request.
+func (sl *SessionLogger) SetGraphQLParser(p GraphQLParser) {
+	sl.graphql = p
+}
+
+func (sl *SessionLogger) parseGraphQL(req *http.Request) []GraphQLOperation {
+	if sl.graphql == nil {
+		return nil
+	}
+	ops := sl.graphql.ParseGraphQL(req)
+	if !sl.logBody {
+		for i := range ops {
+			ops[i].Query, ops[i].Variables = "", nil
+		}
+	}
+	return ops
+}
+
+// GraphQLOperations returns the names of the flow's GraphQL operations,
+// with "" for anonymous ones.
+func (f *Flow) GraphQLOperations() []string {
+	if f.Request == nil {
+		return nil
+	}
+	names := make([]string, 0, len(f.Request.GraphQL))
+	for _, op := range f.Request.GraphQL {
+		names = append(names, op.Name)
+	}
+	return names
+}
@@ -0,0 +1,15 @@
+package logger
+
+import "time"
+
+// HintLog explains a failed exchange: the class of failure, its likely cause
+// and how to fix it.
+type HintLog struct {
+	Timestamp time.Time `json:"timestamp"`
+	RequestID string    `json:"request_id,omitempty"`
+	Host      string    `json:"host"`
+	Class     string    `json:"class"`
+	Error     string    `json:"error"`
+	Cause     string    `json:"cause"`
+	Fix       string    `json:"fix"`
+}
@@ -0,0 +1,42 @@
+package logger
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// JWT is a JSON Web Token found in the headers of a request or response,
+// decoded.
+type JWT struct {
+	// Source is where the token was found, such as "Authorization" or
+	// "cookie session".
+	Source string `json:"source"`
+	// Token is the raw token. It is left out when a redactor is installed.
+	Token  string          `json:"token,omitempty"`
+	Header json.RawMessage `json:"header"`
+	Claims json.RawMessage `json:"claims"`
+}
+
+// JWTDecoder finds and decodes the JWTs in request or response headers.
+type JWTDecoder interface {
+	DecodeJWTs(h http.Header, response bool) []JWT
+}
+
+// SetJWTDecoder installs d to record the JWTs in the headers of every
+// captured request and response.
+func (sl *SessionLogger) SetJWTDecoder(d JWTDecoder) {
+	sl.jwts = d
+}
+
+func (sl *SessionLogger) decodeJWTs(h http.Header, response bool) []JWT {
+	if sl.jwts == nil || h == nil {
+		return nil
+	}
+	found := sl.jwts.DecodeJWTs(h, response)
+	if sl.redactor != nil {
+		for i := range found {
+			found[i].Token = ""
+		}
+	}
+	return found
+}
@@ -0,0 +1,12 @@
+package logger
+
+// SetLabels attaches labels, such as those of the Kubernetes pod the proxy
+// runs beside, to every captured request and tunnel.
+func (sl *SessionLogger) SetLabels(labels map[string]string) {
+	sl.labels = labels
+}
+
+// Labels returns the labels given to SetLabels.
+func (sl *SessionLogger) Labels() map[string]string {
+	return sl.labels
+}
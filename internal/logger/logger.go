@@ -1,13 +1,18 @@
 package logger
 
 import (
-	"bytes"
+	"bufio"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -17,7 +22,29 @@ type RequestLog struct {
 	URL       string            `json:"url"`
 	Headers   map[string]string `json:"headers,omitempty"`
 	Body      string            `json:"body,omitempty"`
-	RequestID string            `json:"request_id"`
+	BodySize  int64             `json:"body_size,omitempty"`
+	Truncated bool              `json:"body_truncated,omitempty"`
+	// BodyEncoding is "base64" for bodies that are not UTF-8 text.
+	BodyEncoding string `json:"body_encoding,omitempty"`
+	// Previewed marks a body cut short for a live view; the session holds
+	// the body as captured.
+	Previewed  bool      `json:"body_preview,omitempty"`
+	Decoded    *Decoded  `json:"decoded,omitempty"`
+	Protocol   *Protocol `json:"protocol,omitempty"`
+	RequestID  string    `json:"request_id"`
+	RedirectOf string    `json:"redirect_of,omitempty"`
+	// Degraded names the degradation level the entry was logged at.
+	Degraded string `json:"degraded,omitempty"`
+	// ClientIP is the address of the client, as given by the PROXY
+	// protocol when the proxy sits behind a load balancer.
+	ClientIP string `json:"client_ip,omitempty"`
+	JWTs     []JWT  `json:"jwts,omitempty"`
+	// GraphQL holds the operations of a GraphQL request.
+	GraphQL []GraphQLOperation `json:"graphql,omitempty"`
+	// Labels are those given to SetLabels.
+	Labels map[string]string `json:"labels,omitempty"`
+
+	meta BodyMeta
 }
 
 type ResponseLog struct {
@@ -25,18 +52,60 @@ type ResponseLog struct {
 	StatusCode int               `json:"status_code"`
 	Headers    map[string]string `json:"headers,omitempty"`
 	Body       string            `json:"body,omitempty"`
-	RequestID  string            `json:"request_id"`
+	BodySize   int64             `json:"body_size,omitempty"`
+	Truncated  bool              `json:"body_truncated,omitempty"`
+	// BodyEncoding is "base64" for bodies that are not UTF-8 text.
+	BodyEncoding string `json:"body_encoding,omitempty"`
+	// Previewed marks a body cut short for a live view; the session holds
+	// the body as captured.
+	Previewed bool          `json:"body_preview,omitempty"`
+	Decoded   *Decoded      `json:"decoded,omitempty"`
+	Protocol  *Protocol     `json:"protocol,omitempty"`
+	RequestID string        `json:"request_id"`
+	Degraded  string        `json:"degraded,omitempty"`
+	Upstream  *UpstreamConn `json:"upstream,omitempty"`
+	JWTs      []JWT         `json:"jwts,omitempty"`
+
+	meta BodyMeta
+}
+
+// SSEEventLog is a single Server-Sent Event observed on a streamed response.
+type SSEEventLog struct {
+	Timestamp time.Time `json:"timestamp"`
+	RequestID string    `json:"request_id"`
+	Event     string    `json:"event,omitempty"`
+	ID        string    `json:"id,omitempty"`
+	Data      string    `json:"data"`
+	Degraded  string    `json:"degraded,omitempty"`
 }
 
 type SessionLogger struct {
+	mu          sync.Mutex
 	sessionFile *os.File
 	sessionName string
 	sessionDir  string
 	logHeaders  bool
 	logBody     bool
 	maxBodySize int
-	encoder     *json.Encoder
 	firstEntry  bool
+	decoder     BodyDecoder
+	budget      BodyBudget
+	detector    ProtocolDetector
+	redactor    Redactor
+	jwts        JWTDecoder
+	graphql     GraphQLParser
+	labels      map[string]string
+
+	buf      *bufio.Writer
+	out      *countingWriter
+	opened   time.Time
+	rotation rotation
+	entries  int
+	onClose  func(SessionFile)
+	// finishing tracks finished files being compressed or handed to onClose.
+	finishing sync.WaitGroup
+	queue     *entryQueue
+	sinks     []*sinkWorker
 }
 
 func NewSessionLogger(sessionDir string, logHeaders, logBody bool, maxBodySize int) (*SessionLogger, error) {
@@ -44,123 +113,350 @@ func NewSessionLogger(sessionDir string, logHeaders, logBody bool, maxBodySize i
 		return nil, err
 	}
 
-	sessionName := fmt.Sprintf("session_%s.json", time.Now().Format("20060102_150405"))
-	sessionPath := filepath.Join(sessionDir, sessionName)
+	file, sessionName, err := createSessionFile(sessionDir)
+	if err != nil {
+		return nil, err
+	}
+
+	sl := &SessionLogger{
+		sessionName: sessionName,
+		sessionDir:  sessionDir,
+		logHeaders:  logHeaders,
+		logBody:     logBody,
+		maxBodySize: maxBodySize,
+	}
+	sl.setFile(file, 0, 0)
+
+	return sl, nil
+}
+
+// NewRemoteLogger returns a logger that keeps no session file, for when
+// entries are only shipped to the sinks added to it.
+func NewRemoteLogger(logHeaders, logBody bool, maxBodySize int) *SessionLogger {
+	return &SessionLogger{
+		logHeaders:  logHeaders,
+		logBody:     logBody,
+		maxBodySize: maxBodySize,
+	}
+}
 
-	file, err := os.Create(sessionPath)
+// createSessionFile creates a new timestamped session file holding the
+// opening bracket. Sessions started within the same second get a numeric
+// suffix.
+func createSessionFile(sessionDir string) (*os.File, string, error) {
+	stamp := time.Now().Format("20060102_150405")
+	for i := 0; ; i++ {
+		sessionName := fmt.Sprintf("session_%s.json", stamp)
+		if i > 0 {
+			sessionName = fmt.Sprintf("session_%s_%d.json", stamp, i)
+		}
+		file, err := os.OpenFile(filepath.Join(sessionDir, sessionName), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+		if errors.Is(err, fs.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return nil, "", err
+		}
+
+		// Start the JSON array
+		if _, err := file.WriteString("[\n"); err != nil {
+			file.Close()
+			return nil, "", err
+		}
+		return file, sessionName, nil
+	}
+}
+
+// setFile directs entries to file, which already holds size bytes and
+// entries entries.
+func (sl *SessionLogger) setFile(file *os.File, size int64, entries int) {
+	sl.sessionFile = file
+	sl.buf = bufio.NewWriterSize(file, 64*1024)
+	sl.out = &countingWriter{w: sl.buf, n: size}
+	sl.firstEntry = entries == 0
+	sl.entries = entries
+	sl.opened = time.Now()
+}
+
+// ResumeSessionLogger appends to the newest session in sessionDir, marking
+// the restart with a "restart" entry, or starts a new session if there is
+// none. Entries cut short by a crash are dropped.
+func ResumeSessionLogger(sessionDir string, logHeaders, logBody bool, maxBodySize int) (*SessionLogger, error) {
+	sessions, err := ListSessions(sessionDir)
 	if err != nil {
 		return nil, err
 	}
+	var sessionName string
+	for _, s := range sessions {
+		// Rotated sessions may have been compressed; only plain ones can be
+		// appended to.
+		if strings.HasPrefix(s, "session_") && filepath.Ext(s) == ".json" {
+			sessionName = s
+		}
+	}
+	if sessionName == "" {
+		return NewSessionLogger(sessionDir, logHeaders, logBody, maxBodySize)
+	}
+	sessionPath := filepath.Join(sessionDir, sessionName)
 
-	// Start the JSON array
-	if _, err := file.WriteString("[\n"); err != nil {
+	file, err := os.OpenFile(sessionPath, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	end, n, err := lastEntryEnd(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: %w", sessionPath, err)
+	}
+	if err := file.Truncate(end); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if _, err := file.Seek(end, io.SeekStart); err != nil {
 		file.Close()
 		return nil, err
 	}
+	if n == 0 {
+		if _, err := file.WriteString("\n"); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
 
 	sl := &SessionLogger{
-		sessionFile: file,
 		sessionName: sessionName,
 		sessionDir:  sessionDir,
 		logHeaders:  logHeaders,
 		logBody:     logBody,
 		maxBodySize: maxBodySize,
-		encoder:     json.NewEncoder(file),
-		firstEntry:  true,
 	}
+	sl.setFile(file, end, n)
 
-	sl.encoder.SetIndent("", "  ")
-
+	if err := sl.WriteEntry("restart", Restart{Timestamp: time.Now(), PreviousEntries: n}); err != nil {
+		sl.Close()
+		return nil, err
+	}
 	return sl, nil
 }
 
-func (sl *SessionLogger) LogRequest(req *http.Request, requestID string) error {
-	reqLog := RequestLog{
+// CaptureRequest builds the log record for req without its body, so callers
+// can enrich it before handing it to StreamEntry.
+func (sl *SessionLogger) CaptureRequest(req *http.Request, requestID string) *RequestLog {
+	reqLog := &RequestLog{
 		Timestamp: time.Now(),
 		Method:    req.Method,
-		URL:       req.URL.String(),
+		URL:       sl.logURL(req.URL),
 		RequestID: requestID,
+		ClientIP:  clientIP(req.RemoteAddr),
+		Labels:    sl.labels,
+		meta: BodyMeta{
+			ContentType: req.Header.Get("Content-Type"),
+			Host:        req.URL.Host,
+			Path:        req.URL.Path,
+			Secure:      req.URL.Scheme == "https",
+		},
+	}
+
+	if sl.detector != nil {
+		reqLog.Protocol = sl.detector.DetectProtocol(req, nil)
 	}
 
 	if sl.logHeaders && req.Header != nil {
 		reqLog.Headers = make(map[string]string)
 		for k, v := range req.Header {
 			if len(v) > 0 {
-				reqLog.Headers[k] = v[0]
+				reqLog.Headers[k] = sl.logHeader(k, v[0])
 			}
 		}
+		reqLog.JWTs = sl.decodeJWTs(req.Header, false)
 	}
+	reqLog.GraphQL = sl.parseGraphQL(req)
 
-	if sl.logBody && req.Body != nil {
-		bodyBytes, err := io.ReadAll(req.Body)
-		if err == nil {
-			logSize := min(len(bodyBytes), sl.maxBodySize)
-			reqLog.Body = string(bodyBytes[:logSize])
-			req.Body = io.NopCloser(bytes.NewReader(bodyBytes))
-		}
-	}
+	return reqLog
+}
 
-	if !sl.firstEntry {
-		if _, err := sl.sessionFile.WriteString(",\n"); err != nil {
-			return err
-		}
+// clientIP returns the IP of a request's RemoteAddr, or "" for clients on
+// Unix domain sockets.
+func clientIP(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
 	}
-	sl.firstEntry = false
-
-	return sl.encoder.Encode(map[string]any{
-		"type": "request",
-		"data": reqLog,
-	})
+	if net.ParseIP(host) == nil {
+		return ""
+	}
+	return host
 }
 
-func (sl *SessionLogger) LogResponse(resp *http.Response, requestID string) error {
-	respLog := ResponseLog{
+// CaptureResponse builds the log record for resp without its body.
+func (sl *SessionLogger) CaptureResponse(resp *http.Response, requestID string) *ResponseLog {
+	respLog := &ResponseLog{
 		Timestamp:  time.Now(),
 		StatusCode: resp.StatusCode,
 		RequestID:  requestID,
+		meta: BodyMeta{
+			Response:    true,
+			ContentType: resp.Header.Get("Content-Type"),
+		},
+	}
+	if resp.Request != nil {
+		respLog.meta.Host = resp.Request.URL.Host
+		respLog.meta.Path = resp.Request.URL.Path
+		respLog.meta.Secure = resp.Request.URL.Scheme == "https"
+		respLog.Upstream = tracedUpstream(resp.Request.Context())
+
+		if sl.detector != nil {
+			respLog.Protocol = sl.detector.DetectProtocol(resp.Request, resp)
+		}
 	}
 
 	if sl.logHeaders && resp.Header != nil {
 		respLog.Headers = make(map[string]string)
 		for k, v := range resp.Header {
 			if len(v) > 0 {
-				respLog.Headers[k] = v[0]
+				respLog.Headers[k] = sl.logHeader(k, v[0])
 			}
 		}
+		respLog.JWTs = sl.decodeJWTs(resp.Header, true)
+	}
+
+	return respLog
+}
+
+// WriteEntry appends a typed entry to the session file. It is safe for
+// concurrent use by modifiers running on different connections. Under
+// QueueDegrade the entry may be trimmed or sampled out first.
+func (sl *SessionLogger) WriteEntry(entryType string, data any) error {
+	if !sl.degrade(data) {
+		return nil
+	}
+	entry, err := json.MarshalIndent(map[string]any{
+		"type": entryType,
+		"data": data,
+	}, "", "  ")
+	if err != nil {
+		return err
 	}
 
-	if sl.logBody && resp.Body != nil {
-		bodyBytes, err := io.ReadAll(resp.Body)
-		if err == nil {
-			logSize := min(len(bodyBytes), sl.maxBodySize)
-			respLog.Body = string(bodyBytes[:logSize])
-			resp.Body = io.NopCloser(bytes.NewReader(bodyBytes))
+	sl.toSinks(entry)
+	if sl.sessionFile == nil {
+		return nil
+	}
+	if sl.queue != nil {
+		return sl.queue.put(entry)
+	}
+
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+
+	if err := sl.writeLocked(entry); err != nil {
+		return err
+	}
+	return sl.buf.Flush()
+}
+
+// writeLocked appends an encoded entry to the session file, rotating it
+// first if due. sl.mu must be held.
+func (sl *SessionLogger) writeLocked(entry []byte) error {
+	if sl.rotation.due(sl.out.n, sl.opened) {
+		if err := sl.rotate(); err != nil {
+			return err
 		}
 	}
 
 	if !sl.firstEntry {
-		if _, err := sl.sessionFile.WriteString(",\n"); err != nil {
+		if _, err := io.WriteString(sl.out, ",\n"); err != nil {
 			return err
 		}
 	}
 	sl.firstEntry = false
 
-	return sl.encoder.Encode(map[string]any{
-		"type": "response",
-		"data": respLog,
-	})
+	if _, err := sl.out.Write(entry); err != nil {
+		return err
+	}
+	sl.entries++
+	_, err := io.WriteString(sl.out, "\n")
+	return err
+}
+
+func (sl *SessionLogger) LogRequest(req *http.Request, requestID string) error {
+	// Capturing may read and replace the body.
+	entry := sl.CaptureRequest(req, requestID)
+	body, err := sl.StreamEntry("request", entry, req.Body)
+	req.Body = body
+	return err
+}
+
+func (sl *SessionLogger) LogResponse(resp *http.Response, requestID string) error {
+	body, err := sl.StreamEntry("response", sl.CaptureResponse(resp, requestID), resp.Body)
+	resp.Body = body
+	return err
 }
 
 func (sl *SessionLogger) Close() error {
-	// End the JSON array
-	if _, err := sl.sessionFile.WriteString("\n]"); err != nil {
-		sl.sessionFile.Close()
+	var err error
+	if sl.queue != nil {
+		err = sl.queue.close()
+	}
+
+	sl.mu.Lock()
+	if sl.sessionFile != nil {
+		if cerr := sl.closeFile(false); err == nil {
+			err = cerr
+		}
+	}
+	sl.mu.Unlock()
+
+	sl.finishing.Wait()
+	if cerr := sl.closeSinks(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// closeFile ends the JSON array, closes the session file and finishes it in
+// the background. sl.mu must be held.
+func (sl *SessionLogger) closeFile(rotated bool) error {
+	sl.buf.WriteString("\n]")
+	err := sl.buf.Flush()
+	if cerr := sl.sessionFile.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
 		return err
 	}
-	return sl.sessionFile.Close()
+
+	f := SessionFile{
+		Name:    sl.sessionName,
+		Path:    filepath.Join(sl.sessionDir, sl.sessionName),
+		Opened:  sl.opened,
+		Closed:  time.Now(),
+		Entries: sl.entries,
+		Rotated: rotated,
+	}
+	compress, onClose := rotated && sl.rotation.compress, sl.onClose
+	if !compress && onClose == nil {
+		return nil
+	}
+	sl.finishing.Add(1)
+	go func() {
+		defer sl.finishing.Done()
+		if compress && compressFile(f.Path) == nil {
+			f.Name += ".gz"
+			f.Path += ".gz"
+		}
+		if onClose != nil {
+			onClose(f)
+		}
+	}()
+	return nil
 }
 
+// GetSessionName returns the name of the session file being written, which
+// changes when the session is rotated.
 func (sl *SessionLogger) GetSessionName() string {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
 	return sl.sessionName
 }
 
@@ -175,7 +471,7 @@ func ListSessions(sessionDir string) ([]string, error) {
 
 	var sessions []string
 	for _, entry := range entries {
-		if !entry.IsDir() && filepath.Ext(entry.Name()) == ".json" {
+		if !entry.IsDir() && isSessionFile(entry.Name()) {
 			sessions = append(sessions, entry.Name())
 		}
 	}
@@ -0,0 +1,40 @@
+package logger
+
+import "net/http"
+
+// Protocol holds structured fields recognised from an exchange's URL and
+// headers, independently of its body.
+type Protocol struct {
+	Name   string `json:"name"`
+	Fields any    `json:"fields,omitempty"`
+	// OmitBody skips capturing the body. Its size is still recorded.
+	OmitBody bool `json:"body_omitted,omitempty"`
+}
+
+// ProtocolDetector recognises application protocols carried over HTTP. It is
+// called with a nil res for requests and returns nil for exchanges it does
+// not handle.
+type ProtocolDetector interface {
+	DetectProtocol(req *http.Request, res *http.Response) *Protocol
+}
+
+// DetectorChain tries each detector in turn and returns the first result.
+type DetectorChain []ProtocolDetector
+
+func (c DetectorChain) DetectProtocol(req *http.Request, res *http.Response) *Protocol {
+	for _, d := range c {
+		if p := d.DetectProtocol(req, res); p != nil {
+			return p
+		}
+	}
+	return nil
+}
+
+// SetProtocolDetector installs d to annotate every captured request and
+// response.
+func (sl *SessionLogger) SetProtocolDetector(d ProtocolDetector) {
+	sl.detector = d
+}
+
+func (r *RequestLog) omitBody() bool  { return r.Protocol != nil && r.Protocol.OmitBody }
+func (r *ResponseLog) omitBody() bool { return r.Protocol != nil && r.Protocol.OmitBody }
@@ -0,0 +1,36 @@
+package logger
+
+import "net/url"
+
+// Redactor rewrites sensitive values before they are written to the log. The
+// proxied exchange itself is never modified.
+type Redactor interface {
+	RedactURL(u *url.URL) string
+	RedactHeader(name, value string) string
+}
+
+// SetRedactor installs r for the URLs and headers of every captured request
+// and response.
+func (sl *SessionLogger) SetRedactor(r Redactor) {
+	sl.redactor = r
+}
+
+func (sl *SessionLogger) logURL(u *url.URL) string {
+	if sl.redactor != nil {
+		return sl.redactor.RedactURL(u)
+	}
+	return u.String()
+}
+
+func (sl *SessionLogger) logHeader(name, value string) string {
+	if sl.redactor != nil {
+		return sl.redactor.RedactHeader(name, value)
+	}
+	return value
+}
+
+// RedactURL returns u as it is written to the log, for entries that refer
+// to a request by URL.
+func (sl *SessionLogger) RedactURL(u *url.URL) string {
+	return sl.logURL(u)
+}
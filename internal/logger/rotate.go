@@ -0,0 +1,106 @@
+package logger
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// rotation limits the size and age of a session file.
+type rotation struct {
+	size     int64
+	interval time.Duration
+	compress bool
+}
+
+func (r rotation) due(size int64, opened time.Time) bool {
+	return (r.size > 0 && size >= r.size) ||
+		(r.interval > 0 && time.Since(opened) >= r.interval)
+}
+
+// SetRotation starts a new session file once the current one reaches size
+// bytes or has been open for interval. Zero disables either limit. With
+// compress, rotated files are gzipped in the background.
+func (sl *SessionLogger) SetRotation(size int64, interval time.Duration, compress bool) {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	sl.rotation = rotation{size: size, interval: interval, compress: compress}
+}
+
+// rotate closes the current session file and continues in a new one. sl.mu
+// must be held.
+func (sl *SessionLogger) rotate() error {
+	file, name, err := createSessionFile(sl.sessionDir)
+	if err != nil {
+		return err
+	}
+
+	err = sl.closeFile(true)
+	sl.sessionName = name
+	sl.setFile(file, int64(len("[\n")), 0)
+	return err
+}
+
+// compressFile replaces path with path.gz. The original is kept if
+// compression fails.
+func compressFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp := path + ".gz.tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	zw.Name = filepath.Base(path)
+
+	_, err = io.Copy(zw, in)
+	if cerr := zw.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path+".gz")
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Remove(path)
+}
+
+// isSessionFile reports whether name is a session file, possibly compressed
+// by rotation.
+func isSessionFile(name string) bool {
+	return strings.HasSuffix(name, ".json") || strings.HasSuffix(name, ".json.gz")
+}
+
+// countingWriter counts the bytes written to a session file.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// SetCloseHook calls fn in the background with every session file once it
+// is complete: closed, and compressed if rotated with compression. Close
+// waits for pending calls.
+func (sl *SessionLogger) SetCloseHook(fn func(SessionFile)) {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	sl.onClose = fn
+}
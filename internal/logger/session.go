@@ -0,0 +1,268 @@
+package logger
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Entry is a raw session log entry as written by WriteEntry.
+type Entry struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// Annotation marks a flow as interesting after the fact, optionally with an
+// analyst comment and tags.
+type Annotation struct {
+	Timestamp time.Time `json:"timestamp"`
+	RequestID string    `json:"request_id"`
+	Starred   bool      `json:"starred,omitempty"`
+	Comment   string    `json:"comment,omitempty"`
+	Tags      []string  `json:"tags,omitempty"`
+}
+
+// Restart marks where a resumed session continues after the proxy was
+// restarted.
+type Restart struct {
+	Timestamp       time.Time `json:"timestamp"`
+	PreviousEntries int       `json:"previous_entries"`
+}
+
+// SessionFile describes a session file that has been closed, either when
+// the logger was closed or when it was rotated.
+type SessionFile struct {
+	Name    string
+	Path    string
+	Opened  time.Time
+	Closed  time.Time
+	Entries int
+	Rotated bool
+}
+
+// Flow groups the entries that belong to a single exchange.
+type Flow struct {
+	ID          string       `json:"id"`
+	Request     *RequestLog  `json:"request,omitempty"`
+	Response    *ResponseLog `json:"response,omitempty"`
+	Annotations []Annotation `json:"annotations,omitempty"`
+}
+
+// Starred reports whether any annotation starred the flow.
+func (f *Flow) Starred() bool {
+	for _, a := range f.Annotations {
+		if a.Starred {
+			return true
+		}
+	}
+	return false
+}
+
+// Tags returns the tags of the flow's annotations, each once, in the order
+// they were first given.
+func (f *Flow) Tags() []string {
+	var tags []string
+	for _, a := range f.Annotations {
+		for _, t := range a.Tags {
+			if !slices.Contains(tags, t) {
+				tags = append(tags, t)
+			}
+		}
+	}
+	return tags
+}
+
+// ReadSession decodes the entries of a session file, which may be gzipped
+// by rotation. Sessions that are still being written, and therefore lack the
+// closing bracket, are read up to the last complete entry.
+func ReadSession(path string) ([]Entry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if strings.HasSuffix(path, ".gz") {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		return DecodeEntries(zr)
+	}
+	return DecodeEntries(f)
+}
+
+// DecodeEntries reads session entries from r, tolerating a truncated tail.
+func DecodeEntries(r io.Reader) ([]Entry, error) {
+	dec := json.NewDecoder(r)
+
+	tok, err := dec.Token()
+	if err != nil {
+		if err == io.EOF {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+		return nil, fmt.Errorf("session is not a JSON array")
+	}
+
+	var entries []Entry
+	for dec.More() {
+		var e Entry
+		if err := dec.Decode(&e); err != nil {
+			if isTruncated(err) {
+				break
+			}
+			return entries, err
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, nil
+}
+
+// lastEntryEnd returns the offset just past the last complete entry in a
+// session file, or past the opening bracket if it has none, along with the
+// number of complete entries.
+func lastEntryEnd(r io.Reader) (int64, int, error) {
+	dec := json.NewDecoder(r)
+
+	tok, err := dec.Token()
+	if err != nil {
+		return 0, 0, err
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+		return 0, 0, fmt.Errorf("session is not a JSON array")
+	}
+
+	end, n := dec.InputOffset(), 0
+	for dec.More() {
+		var e Entry
+		if err := dec.Decode(&e); err != nil {
+			if isTruncated(err) || errors.Is(err, io.EOF) {
+				break
+			}
+			return 0, 0, err
+		}
+		end, n = dec.InputOffset(), n+1
+	}
+	return end, n, nil
+}
+
+// isTruncated reports whether err was caused by the input ending mid-entry,
+// as happens when reading a session that is still being written.
+func isTruncated(err error) bool {
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		return strings.Contains(syntaxErr.Error(), "unexpected end of JSON input")
+	}
+	return errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// BuildFlows groups entries by request ID, in order of first appearance.
+func BuildFlows(entries []Entry) ([]*Flow, error) {
+	var flows []*Flow
+	byID := make(map[string]*Flow)
+
+	flow := func(id string) *Flow {
+		f, ok := byID[id]
+		if !ok {
+			f = &Flow{ID: id}
+			byID[id] = f
+			flows = append(flows, f)
+		}
+		return f
+	}
+
+	for _, e := range entries {
+		switch e.Type {
+		case "request":
+			var r RequestLog
+			if err := json.Unmarshal(e.Data, &r); err != nil {
+				return nil, err
+			}
+			flow(r.RequestID).Request = &r
+		case "response":
+			var r ResponseLog
+			if err := json.Unmarshal(e.Data, &r); err != nil {
+				return nil, err
+			}
+			flow(r.RequestID).Response = &r
+		case "annotation":
+			var a Annotation
+			if err := json.Unmarshal(e.Data, &a); err != nil {
+				return nil, err
+			}
+			f := flow(a.RequestID)
+			f.Annotations = append(f.Annotations, a)
+		}
+	}
+
+	return flows, nil
+}
+
+// LoadFlows reads a session file and groups it into flows.
+func LoadFlows(path string) ([]*Flow, error) {
+	entries, err := ReadSession(path)
+	if err != nil {
+		return nil, err
+	}
+	return BuildFlows(entries)
+}
+
+// AppendEntry adds an entry to a closed session file, keeping it valid JSON.
+func AppendEntry(path, entryType string, data any) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	tailSize := min(info.Size(), 64)
+	tail := make([]byte, tailSize)
+	if _, err := f.ReadAt(tail, info.Size()-tailSize); err != nil {
+		return err
+	}
+
+	trimmed := bytes.TrimRight(tail, " \t\r\n")
+	if len(trimmed) == 0 || trimmed[len(trimmed)-1] != ']' {
+		return fmt.Errorf("session %s is still being written", path)
+	}
+	closeAt := info.Size() - tailSize + int64(len(trimmed)) - 1
+	empty := bytes.HasSuffix(bytes.TrimRight(trimmed[:len(trimmed)-1], " \t\r\n"), []byte("["))
+
+	entry, err := json.MarshalIndent(map[string]any{
+		"type": entryType,
+		"data": data,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if !empty {
+		buf.WriteString(",\n")
+	}
+	buf.Write(entry)
+	buf.WriteString("\n\n]")
+
+	if err := f.Truncate(closeAt); err != nil {
+		return err
+	}
+	_, err = f.WriteAt(buf.Bytes(), closeAt)
+	return err
+}
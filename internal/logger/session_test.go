@@ -0,0 +1,429 @@
+package logger
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestResumeSessionLogger(t *testing.T) {
+	dir := t.TempDir()
+
+	sl, err := ResumeSessionLogger(dir, true, true, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sl.WriteEntry("annotation", Annotation{RequestID: "1"})
+	sl.Close()
+	path := filepath.Join(dir, sl.GetSessionName())
+
+	// A clean shutdown is resumed.
+	sl, err = ResumeSessionLogger(dir, true, true, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sl.GetSessionName() != filepath.Base(path) {
+		t.Fatalf("resumed %s, want %s", sl.GetSessionName(), path)
+	}
+	sl.WriteEntry("annotation", Annotation{RequestID: "2"})
+	sl.Close()
+
+	// So is a crash that left half an entry behind.
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, _ := f.Stat()
+	f.Truncate(info.Size() - 2)
+	f.WriteAt([]byte(`,{"type": "annot`), info.Size()-2)
+	f.Close()
+
+	sl, err = ResumeSessionLogger(dir, true, true, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sl.WriteEntry("annotation", Annotation{RequestID: "3"})
+	sl.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []Entry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		t.Fatalf("resumed session is not valid JSON: %v\n%s", err, data)
+	}
+	var types []string
+	for _, e := range entries {
+		types = append(types, e.Type)
+	}
+	want := []string{"annotation", "restart", "annotation", "restart", "annotation"}
+	if len(types) != len(want) {
+		t.Fatalf("entries = %v, want %v", types, want)
+	}
+	for i := range want {
+		if types[i] != want[i] {
+			t.Fatalf("entries = %v, want %v", types, want)
+		}
+	}
+
+	var r Restart
+	json.Unmarshal(entries[3].Data, &r)
+	if r.PreviousEntries != 3 {
+		t.Errorf("previous entries = %d, want 3", r.PreviousEntries)
+	}
+}
+
+func TestRotation(t *testing.T) {
+	dir := t.TempDir()
+	sl, err := NewSessionLogger(dir, true, true, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sl.SetRotation(200, 0, true)
+	var mu sync.Mutex
+	var closed []SessionFile
+	sl.SetCloseHook(func(f SessionFile) {
+		mu.Lock()
+		defer mu.Unlock()
+		closed = append(closed, f)
+	})
+
+	for i := 0; i < 6; i++ {
+		if err := sl.WriteEntry("annotation", Annotation{RequestID: "1", Comment: "a comment long enough to fill the file"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sl.Close()
+
+	sessions, err := ListSessions(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) < 2 {
+		t.Fatalf("sessions = %v, want rotated files", sessions)
+	}
+
+	total := 0
+	for _, s := range sessions {
+		if s != sl.GetSessionName() && filepath.Ext(s) != ".gz" {
+			t.Errorf("rotated session %s was not compressed", s)
+		}
+		entries, err := ReadSession(filepath.Join(dir, s))
+		if err != nil {
+			t.Fatalf("%s: %v", s, err)
+		}
+		total += len(entries)
+	}
+	if total != 6 {
+		t.Errorf("read %d entries across %v, want 6", total, sessions)
+	}
+
+	if len(closed) != len(sessions) {
+		t.Fatalf("close hook saw %d files, want %d", len(closed), len(sessions))
+	}
+	hooked := 0
+	for _, f := range closed {
+		if f.Rotated != (f.Name != sl.GetSessionName()) {
+			t.Errorf("%s: Rotated = %v", f.Name, f.Rotated)
+		}
+		if _, err := os.Stat(f.Path); err != nil {
+			t.Error(err)
+		}
+		hooked += f.Entries
+	}
+	if hooked != 6 {
+		t.Errorf("close hook counted %d entries, want 6", hooked)
+	}
+}
+
+func TestAsyncWrites(t *testing.T) {
+	dir := t.TempDir()
+	sl, err := NewSessionLogger(dir, true, true, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sl.SetAsync(8, QueueBlock, time.Millisecond)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				sl.WriteEntry("annotation", Annotation{RequestID: "1"})
+			}
+		}()
+	}
+	wg.Wait()
+	if err := sl.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := sl.WriteEntry("annotation", Annotation{}); err == nil {
+		t.Error("expected an error writing to a closed logger")
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, sl.GetSessionName()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []Entry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		t.Fatalf("session is not valid JSON: %v", err)
+	}
+	if len(entries) != 200 || sl.QueueStats().Dropped != 0 {
+		t.Errorf("got %d entries, %d dropped; want 200, 0", len(entries), sl.QueueStats().Dropped)
+	}
+}
+
+type memorySink struct {
+	mu      sync.Mutex
+	batches [][][]byte
+	closed  bool
+}
+
+func (s *memorySink) Send(entries [][]byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.batches = append(s.batches, entries)
+	return nil
+}
+
+func (s *memorySink) Close() error {
+	s.closed = true
+	return nil
+}
+
+func TestDegradedWrites(t *testing.T) {
+	sl, err := NewSessionLogger(t.TempDir(), true, true, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// No writer drains the queue, so its fill is set by the test.
+	q := &entryQueue{entries: make(chan []byte, 10), drop: true, degrade: &degrader{policy: DefaultDegradePolicy}}
+	sl.queue = q
+	fill := func(n int) {
+		for len(q.entries) < n {
+			q.entries <- []byte("{}")
+		}
+		for len(q.entries) > n {
+			<-q.entries
+		}
+	}
+	last := func() []Entry {
+		var entries []Entry
+		for len(q.entries) > 0 {
+			var e Entry
+			if err := json.Unmarshal(<-q.entries, &e); err == nil && e.Type != "" {
+				entries = append(entries, e)
+			}
+		}
+		return entries
+	}
+	request := func(id string) *RequestLog {
+		return &RequestLog{RequestID: id, Headers: map[string]string{"Accept": "*/*"}, Body: "body", BodySize: 4}
+	}
+
+	fill(5)
+	sl.WriteEntry("request", request("a"))
+	entries := last()
+	if len(entries) != 2 || entries[0].Type != "degradation" {
+		t.Fatalf("entries = %+v, want a degradation and the request", entries)
+	}
+	var r RequestLog
+	json.Unmarshal(entries[1].Data, &r)
+	if r.Body != "" || r.BodySize != 4 || r.Headers == nil || r.Degraded != "bodies" {
+		t.Errorf("request at bodies level = %+v", r)
+	}
+
+	fill(8)
+	sl.WriteEntry("request", request("a"))
+	entries = last()
+	r = RequestLog{}
+	json.Unmarshal(entries[len(entries)-1].Data, &r)
+	if r.Headers != nil || r.Degraded != "headers" {
+		t.Errorf("request at headers level = %+v", r)
+	}
+
+	fill(9)
+	for i := range 100 {
+		sl.WriteEntry("request", request(fmt.Sprint(i)))
+		fill(9)
+	}
+	s := sl.QueueStats()
+	if s.Level != DegradeSample || s.SampledOut < 50 || s.SampledOut == 100 {
+		t.Errorf("stats after sampling = %+v", s)
+	}
+
+	fill(10)
+	sl.WriteEntry("request", request("a"))
+	if s := sl.QueueStats(); s.Dropped != 1 {
+		t.Errorf("dropped = %d, want 1", s.Dropped)
+	}
+
+	// Draining below half of each threshold restores full entries.
+	fill(0)
+	sl.WriteEntry("request", request("a"))
+	entries = last()
+	var d Degradation
+	json.Unmarshal(entries[0].Data, &d)
+	if d.Level != "none" || d.Previous != "sample" {
+		t.Errorf("degradation = %+v, want sample to none", d)
+	}
+	r = RequestLog{}
+	json.Unmarshal(entries[1].Data, &r)
+	if r.Body != "body" || r.Degraded != "" {
+		t.Errorf("request after recovery = %+v", r)
+	}
+}
+
+func TestRemoteLoggerSinks(t *testing.T) {
+	sl := NewRemoteLogger(true, true, 1024)
+	sink := &memorySink{}
+	sl.AddSink(sink, SinkOptions{Name: "memory", BatchSize: 2, FlushInterval: time.Hour, QueueSize: 10})
+
+	for i := 0; i < 3; i++ {
+		if err := sl.WriteEntry("annotation", Annotation{RequestID: "1", Comment: "note"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sl.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !sink.closed || len(sink.batches) != 2 || len(sink.batches[0]) != 2 || len(sink.batches[1]) != 1 {
+		t.Fatalf("batches = %q", sink.batches)
+	}
+	var e struct {
+		Type string          `json:"type"`
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(sink.batches[0][0], &e); err != nil || e.Type != "annotation" {
+		t.Errorf("entry %s: %v", sink.batches[0][0], err)
+	}
+	if stats := sl.SinkStats(); stats[0].Sent != 3 || stats[0].Name != "memory" {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestFollowSession(t *testing.T) {
+	dir := t.TempDir()
+	sl, err := NewSessionLogger(dir, true, true, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, sl.GetSessionName())
+
+	go func() {
+		for i := 0; i < 3; i++ {
+			sl.WriteEntry("annotation", Annotation{RequestID: "1", Comment: "note"})
+			time.Sleep(20 * time.Millisecond)
+		}
+		sl.Close()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	n := 0
+	err = FollowSession(ctx, path, 5*time.Millisecond, func(e Entry) error {
+		n++
+		return nil
+	})
+	if err != nil || ctx.Err() != nil {
+		t.Fatalf("err = %v, ctx = %v", err, ctx.Err())
+	}
+	if n != 3 {
+		t.Errorf("followed %d entries, want 3", n)
+	}
+}
+
+func TestLabels(t *testing.T) {
+	dir := t.TempDir()
+	sl, err := NewSessionLogger(dir, true, true, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sl.SetLabels(map[string]string{"app": "web"})
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	if err := sl.LogRequest(req, "1"); err != nil {
+		t.Fatal(err)
+	}
+	sl.Close()
+
+	entries, err := ReadSession(filepath.Join(dir, sl.GetSessionName()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var logged RequestLog
+	if len(entries) != 1 || json.Unmarshal(entries[0].Data, &logged) != nil {
+		t.Fatalf("entries = %+v", entries)
+	}
+	if logged.Labels["app"] != "web" {
+		t.Errorf("labels = %q", logged.Labels)
+	}
+}
+
+func TestAppendEntry(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		entries int
+		open    bool
+	}{
+		{name: "empty", entries: 0},
+		{name: "non-empty", entries: 2},
+		{name: "still being written", entries: 1, open: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			sl, err := NewSessionLogger(dir, true, true, 1024)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < tc.entries; i++ {
+				sl.WriteEntry("annotation", Annotation{RequestID: fmt.Sprint(i)})
+			}
+			if !tc.open {
+				sl.Close()
+			} else {
+				defer sl.Close()
+			}
+			path := filepath.Join(dir, sl.GetSessionName())
+			before, _ := os.ReadFile(path)
+
+			err = AppendEntry(path, "annotation", Annotation{RequestID: "1", Comment: "note"})
+			data, _ := os.ReadFile(path)
+			if tc.open {
+				if err == nil {
+					t.Error("appended to a session still being written")
+				}
+				if string(data) != string(before) {
+					t.Errorf("session changed:\n%s", data)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var entries []Entry
+			if err := json.Unmarshal(data, &entries); err != nil {
+				t.Fatalf("session is not valid JSON: %v\n%s", err, data)
+			}
+			if len(entries) != tc.entries+1 {
+				t.Fatalf("%d entries, want %d", len(entries), tc.entries+1)
+			}
+			var a Annotation
+			json.Unmarshal(entries[tc.entries].Data, &a)
+			if entries[tc.entries].Type != "annotation" || a.Comment != "note" {
+				t.Errorf("appended %+v", entries[tc.entries])
+			}
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Sink ships session entries to a remote collector. Each entry is one
+// compact JSON object of the form {"type":...,"data":...}.
+type Sink interface {
+	Send(entries [][]byte) error
+	Close() error
+}
+
+// SinkOptions controls how entries are batched for a sink. Entries are
+// sent once BatchSize are pending or FlushInterval has passed. Up to
+// QueueSize entries wait while a batch is being sent; beyond that they are
+// dropped so a slow collector never holds up the proxy. Zero values mean
+// single entries, one second and 1024 entries.
+type SinkOptions struct {
+	Name          string
+	BatchSize     int
+	FlushInterval time.Duration
+	QueueSize     int
+}
+
+// defaultSinkQueue is the sink queue size used when none is given.
+const defaultSinkQueue = 1024
+
+// SinkStats counts entries handed to a sink.
+type SinkStats struct {
+	Name    string
+	Sent    uint64
+	Failed  uint64
+	Dropped uint64
+}
+
+// sinkWorker batches entries for one sink on its own goroutine.
+type sinkWorker struct {
+	sink    Sink
+	opts    SinkOptions
+	entries chan []byte
+	done    chan struct{}
+
+	// mu guards closed; senders hold it shared so close waits for them.
+	mu     sync.RWMutex
+	closed bool
+
+	sent, failed, dropped atomic.Uint64
+}
+
+// AddSink sends every entry to s as well as the session file. It must be
+// called before the first entry is written.
+func (sl *SessionLogger) AddSink(s Sink, opts SinkOptions) {
+	opts.BatchSize = max(opts.BatchSize, 1)
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = time.Second
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = defaultSinkQueue
+	}
+	w := &sinkWorker{
+		sink:    s,
+		opts:    opts,
+		entries: make(chan []byte, max(opts.QueueSize, opts.BatchSize)),
+		done:    make(chan struct{}),
+	}
+	sl.sinks = append(sl.sinks, w)
+	go w.run()
+}
+
+// SinkStats returns a snapshot of every sink, in the order they were added.
+func (sl *SessionLogger) SinkStats() []SinkStats {
+	stats := make([]SinkStats, len(sl.sinks))
+	for i, w := range sl.sinks {
+		stats[i] = SinkStats{
+			Name:    w.opts.Name,
+			Sent:    w.sent.Load(),
+			Failed:  w.failed.Load(),
+			Dropped: w.dropped.Load(),
+		}
+	}
+	return stats
+}
+
+// toSinks hands an indented entry to every sink in compact form.
+func (sl *SessionLogger) toSinks(entry []byte) {
+	if len(sl.sinks) == 0 {
+		return
+	}
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, entry); err != nil {
+		return
+	}
+	for _, w := range sl.sinks {
+		w.put(buf.Bytes())
+	}
+}
+
+func (sl *SessionLogger) closeSinks() error {
+	var err error
+	for _, w := range sl.sinks {
+		if cerr := w.close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+func (w *sinkWorker) put(entry []byte) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		return
+	}
+	select {
+	case w.entries <- entry:
+	default:
+		w.dropped.Add(1)
+	}
+}
+
+// close sends the pending entries and closes the sink.
+func (w *sinkWorker) close() error {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.entries)
+	}
+	w.mu.Unlock()
+
+	<-w.done
+	return w.sink.Close()
+}
+
+func (w *sinkWorker) run() {
+	defer close(w.done)
+
+	t := time.NewTicker(w.opts.FlushInterval)
+	defer t.Stop()
+
+	batch := make([][]byte, 0, w.opts.BatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := w.sink.Send(batch); err != nil {
+			w.failed.Add(uint64(len(batch)))
+		} else {
+			w.sent.Add(uint64(len(batch)))
+		}
+		batch = make([][]byte, 0, w.opts.BatchSize)
+	}
+
+	for {
+		select {
+		case entry, ok := <-w.entries:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, entry)
+			if len(batch) >= w.opts.BatchSize {
+				flush()
+			}
+		case <-t.C:
+			flush()
+		}
+	}
+}
@@ -0,0 +1,294 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"unicode/utf8"
+)
+
+// BodyMeta describes the exchange a captured body belongs to, for decoders
+// that need more than the bytes themselves.
+type BodyMeta struct {
+	Response    bool
+	ContentType string
+	Host        string
+	Path        string
+	Secure      bool
+}
+
+// Decoded is a structured rendering of a body that is not readable as text.
+type Decoded struct {
+	Format string `json:"format"`
+	Value  any    `json:"value,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// BodyDecoder turns captured bodies into a structured form for the log. It
+// returns nil for bodies it does not handle.
+type BodyDecoder interface {
+	DecodeBody(meta BodyMeta, body []byte) *Decoded
+}
+
+// DecoderChain tries each decoder in turn and returns the first result.
+type DecoderChain []BodyDecoder
+
+func (c DecoderChain) DecodeBody(meta BodyMeta, body []byte) *Decoded {
+	for _, d := range c {
+		if decoded := d.DecodeBody(meta, body); decoded != nil {
+			return decoded
+		}
+	}
+	return nil
+}
+
+// SetBodyDecoder installs d to decode every captured body before its entry is
+// written.
+func (sl *SessionLogger) SetBodyDecoder(d BodyDecoder) {
+	sl.decoder = d
+}
+
+// bodyRecorder is implemented by entries that carry a captured body.
+type bodyRecorder interface {
+	setBody(captured []byte, size int64, truncated bool)
+	bodyMeta() BodyMeta
+	setDecoded(d *Decoded)
+	omitBody() bool
+}
+
+func (r *RequestLog) bodyMeta() BodyMeta     { return r.meta }
+func (r *RequestLog) setDecoded(d *Decoded)  { r.Decoded = d }
+func (r *ResponseLog) bodyMeta() BodyMeta    { return r.meta }
+func (r *ResponseLog) setDecoded(d *Decoded) { r.Decoded = d }
+
+func (r *RequestLog) setBody(captured []byte, size int64, truncated bool) {
+	r.Body, r.BodyEncoding = encodeBody(captured, truncated)
+	r.BodySize = size
+	r.Truncated = truncated
+}
+
+func (r *ResponseLog) setBody(captured []byte, size int64, truncated bool) {
+	r.Body, r.BodyEncoding = encodeBody(captured, truncated)
+	r.BodySize = size
+	r.Truncated = truncated
+}
+
+// RawBody returns the captured body as it was sent.
+func (r *RequestLog) RawBody() ([]byte, error) { return decodeBody(r.Body, r.BodyEncoding) }
+
+// RawBody returns the captured body as it was received.
+func (r *ResponseLog) RawBody() ([]byte, error) { return decodeBody(r.Body, r.BodyEncoding) }
+
+// TrimBody cuts the body to at most n bytes, reporting whether it did.
+func (r *RequestLog) TrimBody(n int) bool {
+	var ok bool
+	r.Body, r.BodyEncoding, ok = previewBody(r.Body, r.BodyEncoding, n)
+	r.Previewed = r.Previewed || ok
+	return ok
+}
+
+// TrimBody cuts the body to at most n bytes, reporting whether it did.
+func (r *ResponseLog) TrimBody(n int) bool {
+	var ok bool
+	r.Body, r.BodyEncoding, ok = previewBody(r.Body, r.BodyEncoding, n)
+	r.Previewed = r.Previewed || ok
+	return ok
+}
+
+func previewBody(body, encoding string, n int) (string, string, bool) {
+	if len(body) <= n && encoding == "" {
+		return body, encoding, false
+	}
+	raw, err := decodeBody(body, encoding)
+	if err != nil || len(raw) <= n {
+		return body, encoding, false
+	}
+	body, encoding = encodeBody(raw[:n], true)
+	return body, encoding, true
+}
+
+// encodeBody stores text bodies as they are and anything else, which JSON
+// strings cannot hold, as base64. A truncated body may end partway through
+// a character and still counts as text.
+func encodeBody(captured []byte, truncated bool) (string, string) {
+	text := captured
+	if truncated {
+		for i := 0; i < utf8.UTFMax-1; i++ {
+			if r, size := utf8.DecodeLastRune(text); r != utf8.RuneError || size != 1 {
+				break
+			}
+			text = text[:len(text)-1]
+		}
+	}
+	if utf8.Valid(text) {
+		return string(text), ""
+	}
+	return base64.StdEncoding.EncodeToString(captured), "base64"
+}
+
+func decodeBody(body, encoding string) ([]byte, error) {
+	switch encoding {
+	case "":
+		return []byte(body), nil
+	case "base64":
+		return base64.StdEncoding.DecodeString(body)
+	}
+	return nil, fmt.Errorf("unknown body encoding %q", encoding)
+}
+
+// BodyBudget bounds the memory held by bodies captured while their
+// exchanges are in flight.
+type BodyBudget interface {
+	// Reserve charges n bytes, reporting false if there is no room.
+	Reserve(n int) bool
+	Release(n int)
+	// Spill returns a temporary file to capture the rest of a body in once
+	// there is no room, or nil to stop capturing it.
+	Spill() (*os.File, error)
+	// Refuse counts a body whose capture ended for want of room.
+	Refuse()
+}
+
+// SetBodyBudget charges the bodies being captured to b.
+func (sl *SessionLogger) SetBodyBudget(b BodyBudget) {
+	sl.budget = b
+}
+
+// teeBody passes a body through unchanged while keeping at most limit bytes
+// of it for the log, in memory as long as the budget allows and in a spill
+// file after that. The entry is finalized exactly once, when the body hits
+// EOF or is closed, whichever happens first.
+type teeBody struct {
+	rc      io.ReadCloser
+	buf     bytes.Buffer
+	limit   int
+	kept    int
+	total   int64
+	budget  BodyBudget
+	charged int
+	spill   *os.File
+	once    sync.Once
+	done    func(captured []byte, size int64, truncated bool) error
+	doneErr error
+}
+
+func (t *teeBody) Read(p []byte) (int, error) {
+	n, err := t.rc.Read(p)
+	if n > 0 {
+		t.total += int64(n)
+		if room := t.limit - t.kept; room > 0 {
+			t.keep(p[:min(n, room)])
+		}
+	}
+	if err == io.EOF {
+		t.finish()
+	}
+	return n, err
+}
+
+// keep captures b, moving the capture to a spill file when the budget has
+// no room for it, or ending the capture when there is no spill file or it
+// cannot be written.
+func (t *teeBody) keep(b []byte) {
+	if t.spill == nil && t.budget != nil {
+		if t.budget.Reserve(len(b)) {
+			t.charged += len(b)
+		} else if f, err := t.budget.Spill(); err == nil && f != nil {
+			if _, err := f.Write(t.buf.Bytes()); err != nil {
+				f.Close()
+				os.Remove(f.Name())
+				t.refuse()
+				return
+			}
+			t.spill = f
+			t.buf = bytes.Buffer{}
+			t.budget.Release(t.charged)
+			t.charged = 0
+		} else {
+			t.refuse()
+			return
+		}
+	}
+	if t.spill != nil {
+		if _, err := t.spill.Write(b); err != nil {
+			t.refuse()
+			return
+		}
+	} else {
+		t.buf.Write(b)
+	}
+	t.kept += len(b)
+}
+
+// refuse ends the capture with what has been kept so far.
+func (t *teeBody) refuse() {
+	t.limit = t.kept
+	t.budget.Refuse()
+}
+
+func (t *teeBody) Close() error {
+	err := t.rc.Close()
+	t.finish()
+	if err != nil {
+		return err
+	}
+	return t.doneErr
+}
+
+func (t *teeBody) finish() {
+	t.once.Do(func() {
+		captured := t.buf.Bytes()
+		if t.spill != nil {
+			// The spilled capture is read back into memory to be logged, so
+			// it is charged like any other; without room it is not logged.
+			captured = nil
+			if t.budget.Reserve(t.kept) {
+				t.charged = t.kept
+				captured, _ = os.ReadFile(t.spill.Name())
+				captured = captured[:min(len(captured), t.kept)]
+			} else {
+				t.budget.Refuse()
+			}
+			t.spill.Close()
+			os.Remove(t.spill.Name())
+		}
+		t.doneErr = t.done(captured, t.total, t.total > int64(len(captured)))
+		if t.budget != nil {
+			t.budget.Release(t.charged)
+		}
+	})
+}
+
+// StreamEntry writes data as an entry of entryType once body has been
+// consumed, capturing up to the configured maximum body size on the way
+// through. The returned body must replace the original one. If bodies are not
+// logged or there is no body, the entry is written immediately. Bodies a
+// protocol detector asked to omit, or that arrive while the queue is
+// degraded, are only measured.
+func (sl *SessionLogger) StreamEntry(entryType string, data bodyRecorder, body io.ReadCloser) (io.ReadCloser, error) {
+	if !sl.logBody || body == nil || body == http.NoBody {
+		return body, sl.WriteEntry(entryType, data)
+	}
+
+	limit := sl.maxBodySize
+	if data.omitBody() || sl.degradeLevel() >= DegradeBodies {
+		limit = 0
+	}
+
+	return &teeBody{
+		rc:     body,
+		limit:  limit,
+		budget: sl.budget,
+		done: func(captured []byte, size int64, truncated bool) error {
+			data.setBody(captured, size, truncated)
+			if sl.decoder != nil && len(captured) > 0 {
+				data.setDecoded(sl.decoder.DecodeBody(data.bodyMeta(), captured))
+			}
+			return sl.WriteEntry(entryType, data)
+		},
+	}, nil
+}
@@ -0,0 +1,210 @@
+package logger
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestStreamEntryTruncatesWithoutBuffering(t *testing.T) {
+	dir := t.TempDir()
+	sl, err := NewSessionLogger(dir, true, true, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp := &http.Response{
+		StatusCode: 200,
+		Header:     http.Header{},
+		Body:       io.NopCloser(strings.NewReader("hello world")),
+	}
+	if err := sl.LogResponse(resp, "1"); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "hello world" {
+		t.Errorf("Expected body to pass through unchanged, got %q", got)
+	}
+	resp.Body.Close()
+	sl.Close()
+
+	data, err := os.ReadFile(filepath.Join(dir, sl.GetSessionName()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var entries []struct {
+		Type string      `json:"type"`
+		Data ResponseLog `json:"data"`
+	}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		t.Fatalf("Session is not valid JSON: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("Expected 1 entry, got %d", len(entries))
+	}
+	if entries[0].Data.Body != "hell" || entries[0].Data.BodySize != 11 || !entries[0].Data.Truncated {
+		t.Errorf("Unexpected captured body: %+v", entries[0].Data)
+	}
+}
+
+// testBudget is a BodyBudget of room bytes that spills to dir, if set, to
+// files that cannot be written if broken is.
+type testBudget struct {
+	room, used int
+	refused    int
+	dir        string
+	broken     bool
+}
+
+func (b *testBudget) Reserve(n int) bool {
+	if b.used+n > b.room {
+		return false
+	}
+	b.used += n
+	return true
+}
+
+func (b *testBudget) Release(n int) { b.used -= n }
+
+func (b *testBudget) Spill() (*os.File, error) {
+	if b.dir == "" {
+		return nil, nil
+	}
+	f, err := os.CreateTemp(b.dir, "body-*")
+	if err == nil && b.broken {
+		f.Close()
+	}
+	return f, err
+}
+
+func (b *testBudget) Refuse() { b.refused++ }
+
+func TestStreamEntryBudget(t *testing.T) {
+	for _, tc := range []struct {
+		name, want string
+		// held bytes are charged by other exchanges, and given back once
+		// half the body is read if freed is set.
+		room, held int
+		freed      bool
+		spill      bool
+		broken     bool
+		refused    int
+	}{
+		{name: "spill", want: "hello world", room: 11, held: 6, freed: true, spill: true},
+		{name: "no room to read back", want: "", room: 11, held: 6, spill: true, refused: 1},
+		{name: "spill fails", want: "hello", room: 11, held: 6, freed: true, spill: true, broken: true, refused: 1},
+		{name: "stop", want: "hello ", room: 6, refused: 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			sl, err := NewSessionLogger(filepath.Join(dir, "logs"), true, true, 1024)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b := &testBudget{room: tc.room, used: tc.held, broken: tc.broken}
+			if tc.spill {
+				b.dir = dir
+			}
+			sl.SetBodyBudget(b)
+
+			resp := &http.Response{StatusCode: 200, Header: http.Header{}, Body: io.NopCloser(iotest.OneByteReader(strings.NewReader("hello world")))}
+			if err := sl.LogResponse(resp, "1"); err != nil {
+				t.Fatal(err)
+			}
+			half := make([]byte, 8)
+			if _, err := io.ReadFull(resp.Body, half); err != nil {
+				t.Fatal(err)
+			}
+			if tc.freed {
+				b.Release(tc.held)
+			}
+			rest, _ := io.ReadAll(resp.Body)
+			if got := string(half) + string(rest); got != "hello world" {
+				t.Errorf("body passed through as %q", got)
+			}
+			resp.Body.Close()
+			sl.Close()
+			if !tc.freed {
+				b.Release(tc.held)
+			}
+
+			entries, err := ReadSession(filepath.Join(dir, "logs", sl.GetSessionName()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var res ResponseLog
+			if len(entries) != 1 || json.Unmarshal(entries[0].Data, &res) != nil {
+				t.Fatalf("entries = %+v", entries)
+			}
+			if res.Body != tc.want || res.BodySize != 11 || res.Truncated != (len(tc.want) < 11) {
+				t.Errorf("captured %+v", res)
+			}
+			if b.used != 0 {
+				t.Errorf("%d bytes still charged", b.used)
+			}
+			if b.refused != tc.refused {
+				t.Errorf("refused %d bodies, want %d", b.refused, tc.refused)
+			}
+			if spilled, _ := filepath.Glob(filepath.Join(dir, "body-*")); len(spilled) != 0 {
+				t.Errorf("spill files left behind: %v", spilled)
+			}
+		})
+	}
+}
+
+func TestBinaryBodiesRoundTrip(t *testing.T) {
+	tests := []struct {
+		body      []byte
+		truncated bool
+		encoding  string
+	}{
+		{[]byte("plain text"), false, ""},
+		{[]byte("%PDF-1.7\n\xe2\xe3\xcf\xd3\x00"), false, "base64"},
+		// Truncation can split a character; that is still text.
+		{[]byte("caf\xc3"), true, ""},
+		{[]byte("caf\xc3"), false, "base64"},
+	}
+	for _, tt := range tests {
+		r := &ResponseLog{}
+		r.setBody(tt.body, int64(len(tt.body)), tt.truncated)
+		if r.BodyEncoding != tt.encoding {
+			t.Errorf("%q: encoding %q, want %q", tt.body, r.BodyEncoding, tt.encoding)
+		}
+		data, _ := json.Marshal(r)
+		var back ResponseLog
+		json.Unmarshal(data, &back)
+		raw, err := back.RawBody()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := string(tt.body); tt.encoding != "" && string(raw) != want {
+			t.Errorf("%q: round trip gave %q", tt.body, raw)
+		}
+	}
+}
+
+func TestTrimBody(t *testing.T) {
+	r := &ResponseLog{}
+	r.setBody([]byte("\x89PNG\r\n\x1a\n"), 8, false)
+	if !r.TrimBody(4) || !r.Previewed {
+		t.Fatal("Body not trimmed")
+	}
+	if raw, _ := r.RawBody(); string(raw) != "\x89PNG" || r.BodySize != 8 {
+		t.Errorf("Got %q of %d bytes, want the first 4 of 8", raw, r.BodySize)
+	}
+
+	r.setBody([]byte("short"), 5, false)
+	if r.TrimBody(16) || r.Body != "short" {
+		t.Errorf("Short body changed to %q", r.Body)
+	}
+}
@@ -0,0 +1,47 @@
+package logger
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// TunnelLog records a CONNECT tunnel that was relayed without being
+// intercepted, because its host is passed through or because it carried
+// neither TLS nor HTTP.
+type TunnelLog struct {
+	Timestamp time.Time `json:"timestamp"`
+	RequestID string    `json:"request_id"`
+	Host      string    `json:"host"`
+	// SNI is the server name of a TLS ClientHello sent through the tunnel.
+	SNI      string `json:"sni,omitempty"`
+	ClientIP string `json:"client_ip,omitempty"`
+	// Reason is "passthrough" or "not_http".
+	Reason     string `json:"reason"`
+	BytesUp    int64  `json:"bytes_up"`
+	BytesDown  int64  `json:"bytes_down"`
+	DurationMS int64  `json:"duration_ms"`
+	// UpSample and DownSample are hex dumps of the first bytes sent each
+	// way.
+	UpSample   string `json:"up_sample,omitempty"`
+	DownSample string `json:"down_sample,omitempty"`
+	// Error is set when the origin could not be reached.
+	Error  string            `json:"error,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// Tunnels returns the tunnel entries of a session in the order they were
+// written.
+func Tunnels(entries []Entry) ([]TunnelLog, error) {
+	var tunnels []TunnelLog
+	for _, e := range entries {
+		if e.Type != "tunnel" {
+			continue
+		}
+		var t TunnelLog
+		if err := json.Unmarshal(e.Data, &t); err != nil {
+			return nil, err
+		}
+		tunnels = append(tunnels, t)
+	}
+	return tunnels, nil
+}
@@ -0,0 +1,62 @@
+package logger
+
+import (
+	"context"
+	"net"
+	"net/http/httptrace"
+	"sync"
+)
+
+// UpstreamConn is the origin connection a response arrived on, to tell
+// which address and address family a flow actually used.
+type UpstreamConn struct {
+	Address string `json:"address"`
+	Family  string `json:"family"`
+	Reused  bool   `json:"reused,omitempty"`
+}
+
+type upstreamKey struct{}
+
+// upstreamTrace holds the connection reported by the client trace.
+type upstreamTrace struct {
+	mu   sync.Mutex
+	conn *UpstreamConn
+}
+
+// TraceUpstream returns a context that records the origin connection a
+// request made with it is sent on, for CaptureResponse to log.
+func TraceUpstream(ctx context.Context) context.Context {
+	t := &upstreamTrace{}
+	ctx = context.WithValue(ctx, upstreamKey{}, t)
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			addr := info.Conn.RemoteAddr()
+			c := &UpstreamConn{Address: addr.String(), Family: family(addr), Reused: info.Reused}
+			t.mu.Lock()
+			t.conn = c
+			t.mu.Unlock()
+		},
+	})
+}
+
+// tracedUpstream returns the connection recorded in ctx, if any.
+func tracedUpstream(ctx context.Context) *UpstreamConn {
+	t, ok := ctx.Value(upstreamKey{}).(*upstreamTrace)
+	if !ok {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.conn
+}
+
+func family(addr net.Addr) string {
+	tcp, ok := addr.(*net.TCPAddr)
+	switch {
+	case !ok:
+		return addr.Network()
+	case tcp.IP.To4() != nil:
+		return "ipv4"
+	}
+	return "ipv6"
+}
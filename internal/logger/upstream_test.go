@@ -0,0 +1,33 @@
+package logger
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTraceUpstream(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	sl, err := NewSessionLogger(t.TempDir(), false, false, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sl.Close()
+
+	for _, reused := range []bool{false, true} {
+		req, _ := http.NewRequestWithContext(TraceUpstream(context.Background()), "GET", srv.URL, nil)
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		up := sl.CaptureResponse(resp, "1").Upstream
+		if up == nil || up.Address != srv.Listener.Addr().String() || up.Family != "ipv4" || up.Reused != reused {
+			t.Errorf("got upstream %+v, want %s over ipv4, reused %v", up, srv.Listener.Addr(), reused)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+// Package metrics collects rogue's runtime counters and renders them in the
+// Prometheus text exposition format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Kind is the Prometheus metric type.
+type Kind string
+
+const (
+	Counter Kind = "counter"
+	Gauge   Kind = "gauge"
+)
+
+type metric struct {
+	name  string
+	help  string
+	kind  Kind
+	value func() float64
+}
+
+// Registry holds metrics whose values are read when they are scraped, so
+// components keep their own counters and only expose a getter.
+type Registry struct {
+	mu      sync.Mutex
+	metrics map[string]metric
+}
+
+func NewRegistry() *Registry {
+	return &Registry{metrics: make(map[string]metric)}
+}
+
+// Register adds a metric read through value. The name may carry labels, as
+// in name{class="dns"}; metrics sharing a base name form one family.
+// Registering a name twice replaces the earlier metric.
+func (r *Registry) Register(name, help string, kind Kind, value func() float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics[name] = metric{name: name, help: help, kind: kind, value: value}
+}
+
+// Snapshot returns the current value of every metric by name.
+func (r *Registry) Snapshot() map[string]float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	values := make(map[string]float64, len(r.metrics))
+	for name, m := range r.metrics {
+		values[name] = m.value()
+	}
+	return values
+}
+
+// WriteTo writes all metrics, sorted by name, in the text exposition format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	metrics := make([]metric, 0, len(r.metrics))
+	for _, m := range r.metrics {
+		metrics = append(metrics, m)
+	}
+	r.mu.Unlock()
+
+	sort.Slice(metrics, func(i, j int) bool { return metrics[i].name < metrics[j].name })
+
+	var written int64
+	var family string
+	for _, m := range metrics {
+		if base, _, _ := strings.Cut(m.name, "{"); base != family {
+			family = base
+			n, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", base, m.help, base, m.kind)
+			written += int64(n)
+			if err != nil {
+				return written, err
+			}
+		}
+		n, err := fmt.Fprintf(w, "%s %g\n", m.name, m.value())
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// ServeHTTP serves the metrics for Prometheus scrapes.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.WriteTo(w)
+}
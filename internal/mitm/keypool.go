@@ -0,0 +1,89 @@
+package mitm
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"sync"
+	"sync/atomic"
+)
+
+// KeyPool generates leaf key pairs in the background so minting a
+// certificate for a new host does not wait for key generation.
+type KeyPool struct {
+	keys     chan crypto.Signer
+	generate func() (crypto.Signer, error)
+	done     chan struct{}
+	once     sync.Once
+
+	hits, misses atomic.Uint64
+}
+
+// KeyPoolStats reports how often keys were ready when needed.
+type KeyPoolStats struct {
+	Hits      uint64
+	Misses    uint64
+	Available int
+	Capacity  int
+}
+
+// GenerateRSAKey generates a 2048-bit RSA leaf key.
+func GenerateRSAKey() (crypto.Signer, error) {
+	return rsa.GenerateKey(rand.Reader, 2048)
+}
+
+// NewKeyPool keeps up to size keys from generate ready, refilled by workers
+// goroutines until Close is called.
+func NewKeyPool(size, workers int, generate func() (crypto.Signer, error)) *KeyPool {
+	p := &KeyPool{
+		keys:     make(chan crypto.Signer, max(size, 1)),
+		generate: generate,
+		done:     make(chan struct{}),
+	}
+	for range max(workers, 1) {
+		go p.fill()
+	}
+	return p
+}
+
+func (p *KeyPool) fill() {
+	for {
+		key, err := p.generate()
+		if err != nil {
+			// Get reports generation errors on the handshake path.
+			continue
+		}
+		select {
+		case p.keys <- key:
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// Get returns a pre-generated key, or generates one if the pool is empty.
+func (p *KeyPool) Get() (crypto.Signer, error) {
+	select {
+	case key := <-p.keys:
+		p.hits.Add(1)
+		return key, nil
+	default:
+	}
+	p.misses.Add(1)
+	return p.generate()
+}
+
+// Stats returns a snapshot of the pool counters.
+func (p *KeyPool) Stats() KeyPoolStats {
+	return KeyPoolStats{
+		Hits:      p.hits.Load(),
+		Misses:    p.misses.Load(),
+		Available: len(p.keys),
+		Capacity:  cap(p.keys),
+	}
+}
+
+// Close stops the background workers.
+func (p *KeyPool) Close() {
+	p.once.Do(func() { close(p.done) })
+}
@@ -0,0 +1,263 @@
+// Package mitm mints leaf certificates signed by the rogue CA and builds the
+// client-facing TLS configuration used to intercept CONNECT tunnels.
+package mitm
+
+import (
+	"container/list"
+	"crypto"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultCacheSize is the number of leaf certificates kept by default.
+const DefaultCacheSize = 1024
+
+// Config issues per-host leaf certificates and client-facing TLS settings.
+type Config struct {
+	ca       *x509.Certificate
+	chain    [][]byte
+	caKey    crypto.Signer
+	leafKey  crypto.Signer
+	keys     *KeyPool
+	org      string
+	validity time.Duration
+
+	// MinVersion, MaxVersion and CipherSuites restrict the handshake with
+	// intercepted clients. Zero values keep crypto/tls defaults.
+	MinVersion   uint16
+	MaxVersion   uint16
+	CipherSuites []uint16
+	// KeyLogWriter, if set, receives the secrets of client handshakes in
+	// NSS key log format.
+	KeyLogWriter io.Writer
+
+	mu       sync.Mutex
+	capacity int
+	certs    map[string]*list.Element
+	lru      *list.List
+
+	hits, misses, evictions atomic.Uint64
+}
+
+type cacheEntry struct {
+	host string
+	cert *tls.Certificate
+}
+
+// CacheStats reports the effectiveness of the leaf certificate cache.
+type CacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	Size      int
+	Capacity  int
+}
+
+// NewConfig returns a Config that signs leaf certificates with ca and caKey.
+func NewConfig(ca *x509.Certificate, caKey any) (*Config, error) {
+	signer, ok := caKey.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("mitm: CA private key cannot sign")
+	}
+
+	leafKey, err := GenerateRSAKey()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Config{
+		ca:       ca,
+		caKey:    signer,
+		leafKey:  leafKey,
+		org:      "Rogue Proxy",
+		validity: time.Hour,
+		capacity: DefaultCacheSize,
+		certs:    make(map[string]*list.Element),
+		lru:      list.New(),
+	}, nil
+}
+
+// SetChain sets the certificates between the signing CA and the root that
+// are sent to clients along with each leaf, as needed when rogue signs with
+// an intermediate CA.
+func (c *Config) SetChain(chain []*x509.Certificate) {
+	c.chain = nil
+	for _, cert := range chain {
+		c.chain = append(c.chain, cert.Raw)
+	}
+}
+
+// SetOrganization sets the organization written into leaf certificates.
+func (c *Config) SetOrganization(org string) {
+	c.org = org
+}
+
+// SetValidity sets how far around the current time leaf certificates are
+// valid.
+func (c *Config) SetValidity(validity time.Duration) {
+	c.validity = validity
+}
+
+// SetKeyPool gives every leaf certificate its own key, taken from pool.
+// Without a pool all leaves share one key generated by NewConfig.
+func (c *Config) SetKeyPool(pool *KeyPool) {
+	c.keys = pool
+}
+
+// SetCacheSize bounds the number of cached leaf certificates. The least
+// recently used certificates are evicted first.
+func (c *Config) SetCacheSize(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.capacity = max(n, 1)
+	c.evict()
+}
+
+// Stats returns a snapshot of the cache counters.
+func (c *Config) Stats() CacheStats {
+	c.mu.Lock()
+	size, capacity := c.lru.Len(), c.capacity
+	c.mu.Unlock()
+
+	return CacheStats{
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Evictions: c.evictions.Load(),
+		Size:      size,
+		Capacity:  capacity,
+	}
+}
+
+// Warm mints certificates for hosts ahead of the first handshake. Hosts that
+// fail are skipped.
+func (c *Config) Warm(hosts []string) {
+	for _, h := range hosts {
+		c.Cert(h)
+	}
+}
+
+// TLSForHost returns the server-side TLS config for a tunnel to host. The
+// certificate follows the client's SNI and falls back to host.
+func (c *Config) TLSForHost(host string) *tls.Config {
+	return &tls.Config{
+		MinVersion:   c.MinVersion,
+		MaxVersion:   c.MaxVersion,
+		CipherSuites: c.CipherSuites,
+		KeyLogWriter: c.KeyLogWriter,
+		NextProtos:   []string{"http/1.1"},
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			name := hello.ServerName
+			if name == "" {
+				name = host
+			}
+			return c.Cert(name)
+		},
+	}
+}
+
+// Cert returns a leaf certificate for hostname, minting one if no valid
+// certificate is cached.
+func (c *Config) Cert(hostname string) (*tls.Certificate, error) {
+	if h, _, err := net.SplitHostPort(hostname); err == nil {
+		hostname = h
+	}
+
+	c.mu.Lock()
+	if e, ok := c.certs[hostname]; ok {
+		tlsc := e.Value.(*cacheEntry).cert
+		if time.Now().Before(tlsc.Leaf.NotAfter) {
+			c.lru.MoveToFront(e)
+			c.mu.Unlock()
+			c.hits.Add(1)
+			return tlsc, nil
+		}
+	}
+	c.mu.Unlock()
+	c.misses.Add(1)
+
+	tlsc, err := c.mint(hostname)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.certs[hostname]; ok {
+		e.Value.(*cacheEntry).cert = tlsc
+		c.lru.MoveToFront(e)
+	} else {
+		c.certs[hostname] = c.lru.PushFront(&cacheEntry{host: hostname, cert: tlsc})
+		c.evict()
+	}
+
+	return tlsc, nil
+}
+
+// evict drops least recently used certificates beyond capacity. c.mu must
+// be held.
+func (c *Config) evict() {
+	for c.lru.Len() > c.capacity {
+		e := c.lru.Back()
+		c.lru.Remove(e)
+		delete(c.certs, e.Value.(*cacheEntry).host)
+		c.evictions.Add(1)
+	}
+}
+
+func (c *Config) mint(hostname string) (*tls.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			CommonName:   hostname,
+			Organization: []string{c.org},
+		},
+		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		NotBefore:             time.Now().Add(-c.validity),
+		NotAfter:              time.Now().Add(c.validity),
+	}
+	if ip := net.ParseIP(hostname); ip != nil {
+		tmpl.IPAddresses = []net.IP{ip}
+	} else {
+		tmpl.DNSNames = []string{hostname}
+	}
+
+	key := c.leafKey
+	if c.keys != nil {
+		if key, err = c.keys.Get(); err != nil {
+			return nil, err
+		}
+	}
+
+	raw, err := x509.CreateCertificate(rand.Reader, tmpl, c.ca, key.Public(), c.caKey)
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := x509.ParseCertificate(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Certificate{
+		Certificate: append([][]byte{raw, c.ca.Raw}, c.chain...),
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}, nil
+}
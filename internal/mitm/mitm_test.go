@@ -0,0 +1,125 @@
+package mitm
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestConfig(t *testing.T) *Config {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	raw, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := NewConfig(ca, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestCertCacheLRU(t *testing.T) {
+	c := newTestConfig(t)
+	c.SetCacheSize(2)
+	c.Warm([]string{"a.test", "b.test"})
+
+	first, err := c.Cert("a.test:443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Leaf.DNSNames[0] != "a.test" {
+		t.Errorf("Unexpected leaf names %v", first.Leaf.DNSNames)
+	}
+
+	// b.test is now least recently used and makes room for c.test.
+	if _, err := c.Cert("c.test"); err != nil {
+		t.Fatal(err)
+	}
+	again, _ := c.Cert("a.test")
+	if again != first {
+		t.Error("Expected a.test to still be cached")
+	}
+
+	want := CacheStats{Hits: 2, Misses: 3, Evictions: 1, Size: 2, Capacity: 2}
+	if got := c.Stats(); got != want {
+		t.Errorf("Stats() = %+v, want %+v", got, want)
+	}
+}
+
+func TestKeyPool(t *testing.T) {
+	c := newTestConfig(t)
+	pool := NewKeyPool(2, 1, func() (crypto.Signer, error) {
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	})
+	defer pool.Close()
+	c.SetKeyPool(pool)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for pool.Stats().Available < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("pool was not filled")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	a, err := c.Cert("a.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := c.Cert("b.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.PrivateKey == b.PrivateKey {
+		t.Error("leaf certificates share a key")
+	}
+	if s := pool.Stats(); s.Hits != 2 || s.Misses != 0 || s.Capacity != 2 {
+		t.Errorf("stats = %+v, want 2 hits", s)
+	}
+}
+
+func TestKeyLogWriter(t *testing.T) {
+	c := newTestConfig(t)
+	var keys strings.Builder
+	c.KeyLogWriter = &keys
+
+	client, server := net.Pipe()
+	go tls.Server(server, c.TLSForHost("app.test")).Handshake()
+	conn := tls.Client(client, &tls.Config{ServerName: "app.test", InsecureSkipVerify: true})
+	if err := conn.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if !strings.Contains(keys.String(), "SERVER_TRAFFIC_SECRET_0 ") {
+		t.Errorf("key log = %q", keys.String())
+	}
+}
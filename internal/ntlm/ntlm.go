@@ -0,0 +1,196 @@
+// Package ntlm builds the client messages of NTLMv2 authentication, as used
+// by corporate proxies in the NTLM and Negotiate HTTP schemes.
+package ntlm
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"golang.org/x/crypto/md4"
+)
+
+var signature = []byte("NTLMSSP\x00")
+
+// Negotiate flags.
+const (
+	flagUnicode                 = 0x00000001
+	flagRequestTarget           = 0x00000004
+	flagNTLM                    = 0x00000200
+	flagAlwaysSign              = 0x00008000
+	flagExtendedSessionSecurity = 0x00080000
+	flagTargetInfo              = 0x00800000
+	flag128                     = 0x20000000
+	flag56                      = 0x80000000
+)
+
+// negotiateFlags are the capabilities the client offers.
+const negotiateFlags uint32 = flagUnicode | flagRequestTarget | flagNTLM | flagAlwaysSign |
+	flagExtendedSessionSecurity | flagTargetInfo | flag128 | flag56
+
+// avTimestamp is the AV pair carrying the server's time.
+const avTimestamp = 7
+
+// Credentials authenticate a user. Domain may be empty, or given in User
+// as "DOMAIN\user".
+type Credentials struct {
+	Domain, User, Password string
+	// Workstation is sent as the client's name; it may be empty.
+	Workstation string
+}
+
+// Split separates "DOMAIN\user" into its parts.
+func Split(user string) (domain, name string) {
+	if d, u, ok := strings.Cut(user, `\`); ok {
+		return d, u
+	}
+	return "", user
+}
+
+// Negotiate returns the first message of the handshake.
+func Negotiate() []byte {
+	b := make([]byte, 32)
+	copy(b, signature)
+	binary.LittleEndian.PutUint32(b[8:], 1)
+	binary.LittleEndian.PutUint32(b[12:], negotiateFlags)
+	// Empty domain and workstation fields follow.
+	return b
+}
+
+// Challenge is the server's reply to Negotiate.
+type Challenge struct {
+	Flags      uint32
+	Nonce      [8]byte
+	TargetInfo []byte
+}
+
+// ParseChallenge reads the second message of the handshake.
+func ParseChallenge(b []byte) (*Challenge, error) {
+	if len(b) < 32 || !bytes.Equal(b[:8], signature) || binary.LittleEndian.Uint32(b[8:]) != 2 {
+		return nil, errors.New("ntlm: not a challenge message")
+	}
+	c := &Challenge{Flags: binary.LittleEndian.Uint32(b[20:])}
+	copy(c.Nonce[:], b[24:32])
+	if len(b) >= 48 {
+		info, err := field(b, 40)
+		if err != nil {
+			return nil, err
+		}
+		c.TargetInfo = info
+	}
+	return c, nil
+}
+
+// field returns the payload described by the security buffer at off.
+func field(b []byte, off int) ([]byte, error) {
+	n := int(binary.LittleEndian.Uint16(b[off:]))
+	start := int(binary.LittleEndian.Uint32(b[off+4:]))
+	if start+n > len(b) || start < 0 {
+		return nil, errors.New("ntlm: field out of bounds")
+	}
+	return b[start : start+n], nil
+}
+
+// timestamp returns the server time from target info, if present.
+func timestamp(info []byte) ([]byte, bool) {
+	for len(info) >= 4 {
+		id := binary.LittleEndian.Uint16(info)
+		n := int(binary.LittleEndian.Uint16(info[2:]))
+		if id == 0 || len(info) < 4+n {
+			break
+		}
+		if id == avTimestamp && n == 8 {
+			return info[4:12], true
+		}
+		info = info[4+n:]
+	}
+	return nil, false
+}
+
+// Authenticate returns the third message of the handshake, answering c.
+func Authenticate(c *Challenge, creds Credentials) ([]byte, error) {
+	var client [8]byte
+	if _, err := rand.Read(client[:]); err != nil {
+		return nil, err
+	}
+	ts, serverTime := timestamp(c.TargetInfo)
+	if !serverTime {
+		ts = filetime(time.Now())
+	}
+	key := ntowfv2(creds.User, creds.Password, creds.Domain)
+	nt := ntResponse(key, c.Nonce[:], client[:], ts, c.TargetInfo)
+	lm := make([]byte, 24)
+	if !serverTime {
+		// LMv2 is only sent when the server gives no time of its own.
+		mac := hmac.New(md5.New, key)
+		mac.Write(c.Nonce[:])
+		mac.Write(client[:])
+		lm = append(mac.Sum(nil), client[:]...)
+	}
+
+	payloads := [][]byte{lm, nt, unicode(creds.Domain), unicode(creds.User), unicode(creds.Workstation), nil}
+	const header = 64
+	b := make([]byte, header)
+	copy(b, signature)
+	binary.LittleEndian.PutUint32(b[8:], 3)
+	off := header
+	for i, p := range payloads {
+		pos := 12 + 8*i
+		binary.LittleEndian.PutUint16(b[pos:], uint16(len(p)))
+		binary.LittleEndian.PutUint16(b[pos+2:], uint16(len(p)))
+		binary.LittleEndian.PutUint32(b[pos+4:], uint32(off))
+		off += len(p)
+	}
+	binary.LittleEndian.PutUint32(b[60:], c.Flags&negotiateFlags|flagUnicode|flagNTLM)
+	for _, p := range payloads {
+		b = append(b, p...)
+	}
+	return b, nil
+}
+
+// ntowfv2 derives the NTLMv2 key of a user.
+func ntowfv2(user, password, domain string) []byte {
+	h := md4.New()
+	h.Write(unicode(password))
+	mac := hmac.New(md5.New, h.Sum(nil))
+	mac.Write(unicode(strings.ToUpper(user) + domain))
+	return mac.Sum(nil)
+}
+
+// ntResponse is the NTLMv2 response: the proof over the server challenge
+// and a blob of the client challenge, time and target info, then the blob.
+func ntResponse(key, server, client, ts, info []byte) []byte {
+	blob := []byte{1, 1, 0, 0, 0, 0, 0, 0}
+	blob = append(blob, ts...)
+	blob = append(blob, client...)
+	blob = append(blob, 0, 0, 0, 0)
+	blob = append(blob, info...)
+	blob = append(blob, 0, 0, 0, 0)
+	mac := hmac.New(md5.New, key)
+	mac.Write(server)
+	mac.Write(blob)
+	return append(mac.Sum(nil), blob...)
+}
+
+// filetime is t in Windows FILETIME, 100ns intervals since 1601.
+func filetime(t time.Time) []byte {
+	const epochDelta = 116444736000000000
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, uint64(t.UnixNano()/100+epochDelta))
+	return b
+}
+
+func unicode(s string) []byte {
+	u := utf16.Encode([]rune(s))
+	b := make([]byte, 2*len(u))
+	for i, r := range u {
+		binary.LittleEndian.PutUint16(b[2*i:], r)
+	}
+	return b
+}
@@ -0,0 +1,79 @@
+package ntlm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+)
+
+// Test vectors from MS-NLMP 4.2.4.
+func TestNTLMv2(t *testing.T) {
+	key := ntowfv2("User", "Password", "Domain")
+	if got := hex.EncodeToString(key); got != "0c868a403bfd7a93a3001ef22ef02e3f" {
+		t.Errorf("NTOWFv2 = %s", got)
+	}
+
+	info, _ := hex.DecodeString("02000c0044006f006d00610069006e0001000c0053006500720076006500720000000000")
+	server, _ := hex.DecodeString("0123456789abcdef")
+	client := bytes.Repeat([]byte{0xaa}, 8)
+	nt := ntResponse(key, server, client, make([]byte, 8), info)
+	if got := hex.EncodeToString(nt[:16]); got != "68cd0ab851e51c96aabc927bebef6a1c" {
+		t.Errorf("NTProofStr = %s", got)
+	}
+}
+
+func TestHandshake(t *testing.T) {
+	neg := Negotiate()
+	if !bytes.HasPrefix(neg, signature) || binary.LittleEndian.Uint32(neg[8:]) != 1 {
+		t.Fatalf("Bad negotiate message %x", neg)
+	}
+
+	// A challenge with a server timestamp in its target info.
+	info := []byte{avTimestamp, 0, 8, 0, 1, 2, 3, 4, 5, 6, 7, 8, 0, 0, 0, 0}
+	msg := make([]byte, 48)
+	copy(msg, signature)
+	binary.LittleEndian.PutUint32(msg[8:], 2)
+	binary.LittleEndian.PutUint32(msg[20:], negotiateFlags)
+	copy(msg[24:], "nonce123")
+	binary.LittleEndian.PutUint16(msg[40:], uint16(len(info)))
+	binary.LittleEndian.PutUint16(msg[42:], uint16(len(info)))
+	binary.LittleEndian.PutUint32(msg[44:], 48)
+	msg = append(msg, info...)
+
+	c, err := ParseChallenge(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(c.Nonce[:]) != "nonce123" || !bytes.Equal(c.TargetInfo, info) {
+		t.Fatalf("Parsed %+v", c)
+	}
+
+	domain, user := Split(`CORP\alice`)
+	auth, err := Authenticate(c, Credentials{Domain: domain, User: user, Password: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lm, _ := field(auth, 12)
+	nt, _ := field(auth, 20)
+	gotUser, _ := field(auth, 36)
+	if !bytes.Equal(lm, make([]byte, 24)) {
+		t.Errorf("LMv2 sent despite a server timestamp: %x", lm)
+	}
+	if !bytes.Equal(gotUser, unicode("alice")) {
+		t.Errorf("User = %x", gotUser)
+	}
+	// The server recomputes the proof from the blob.
+	blob := nt[16:]
+	if !bytes.Equal(blob[8:16], info[4:12]) {
+		t.Errorf("Server timestamp not used: %x", blob[8:16])
+	}
+	want := ntResponse(ntowfv2("alice", "secret", "CORP"), c.Nonce[:], blob[16:24], blob[8:16], info)
+	if !bytes.Equal(nt, want) {
+		t.Error("NT response does not verify")
+	}
+
+	if _, err := ParseChallenge([]byte("NTLMSSP\x00\x01")); err == nil {
+		t.Error("Short message accepted")
+	}
+}
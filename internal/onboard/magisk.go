@@ -0,0 +1,111 @@
+package onboard
+
+import (
+	"archive/zip"
+	"crypto/md5"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/fs"
+	"time"
+)
+
+// ModuleID identifies the Magisk module; installing it again replaces it.
+const ModuleID = "rogue-ca"
+
+// SubjectHash returns the name Android gives c in its certificate stores,
+// OpenSSL's old subject hash: the first four bytes of the MD5 of the DER
+// subject, little endian.
+func SubjectHash(c *x509.Certificate) string {
+	sum := md5.Sum(c.RawSubject)
+	return fmt.Sprintf("%08x", binary.LittleEndian.Uint32(sum[:4]))
+}
+
+// WriteMagisk writes a Magisk module zip that adds c to Android's system
+// CA store, which apps trust without opting in. On Android 14 and later the
+// store lives in the Conscrypt APEX, which the module mounts over.
+func WriteMagisk(w io.Writer, c *x509.Certificate) error {
+	name := c.Subject.CommonName
+	if name == "" {
+		name = "rogue CA"
+	}
+	certFile := SubjectHash(c) + ".0"
+	files := []struct {
+		name string
+		mode fs.FileMode
+		data []byte
+	}{
+		{"module.prop", 0o644, fmt.Appendf(nil, "id=%s\nname=%s\nversion=v1\nversionCode=1\nauthor=rogue\ndescription=Trusts %s as a system CA\n", ModuleID, name, name)},
+		{"META-INF/com/google/android/update-binary", 0o755, []byte(updateBinary)},
+		{"META-INF/com/google/android/updater-script", 0o644, []byte("#MAGISK\n")},
+		{"system/etc/security/cacerts/" + certFile, 0o644, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Raw})},
+		{"post-fs-data.sh", 0o755, []byte(postFSData)},
+	}
+
+	zw := zip.NewWriter(w)
+	for _, f := range files {
+		h := &zip.FileHeader{Name: f.name, Method: zip.Deflate, Modified: time.Now()}
+		h.SetMode(f.mode)
+		fw, err := zw.CreateHeader(h)
+		if err != nil {
+			return err
+		}
+		if _, err := fw.Write(f.data); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// updateBinary is Magisk's standard module installer.
+const updateBinary = `#!/sbin/sh
+
+umask 022
+
+ui_print() { echo "$1"; }
+
+require_new_magisk() {
+  ui_print "*******************************"
+  ui_print " Please install Magisk v20.4+! "
+  ui_print "*******************************"
+  exit 1
+}
+
+OUTFD=$2
+ZIPFILE=$3
+
+mount /data 2>/dev/null
+
+[ -f /data/adb/magisk/util_functions.sh ] || require_new_magisk
+. /data/adb/magisk/util_functions.sh
+[ $MAGISK_VER_CODE -lt 20400 ] && require_new_magisk
+
+install_module
+exit 0
+`
+
+// postFSData runs at boot. Magisk overlays system/ by itself; from Android
+// 14 the CAs are read from the Conscrypt APEX instead, so a copy of it with
+// the module's certificates added is mounted over it.
+const postFSData = `#!/system/bin/sh
+MODDIR=${0%/*}
+APEX=/apex/com.android.conscrypt/cacerts
+[ -d "$APEX" ] || exit 0
+
+DIR="$MODDIR/cacerts"
+rm -rf "$DIR"
+mkdir -p "$DIR"
+cp "$APEX"/* "$DIR"/
+cp "$MODDIR"/system/etc/security/cacerts/* "$DIR"/
+chown -R root:root "$DIR"
+chmod 755 "$DIR"
+chmod 644 "$DIR"/*
+chcon -R u:object_r:system_security_cacerts_file:s0 "$DIR"
+
+mount --bind "$DIR" "$APEX"
+for pid in $(pidof zygote zygote64); do
+  nsenter --mount=/proc/$pid/ns/mnt -- mount --bind "$DIR" "$APEX"
+done
+`
@@ -0,0 +1,136 @@
+// Package onboard helps install rogue's CA on phones: a page with the
+// certificate and instructions for Android and iOS, an iOS configuration
+// profile, and a Magisk module adding it to Android's system store.
+package onboard
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"html/template"
+	"net/http"
+
+	"github.com/standrze/rogue/internal/qr"
+)
+
+// Paths served by Handler.
+const (
+	PathDER     = "/ca.crt"
+	PathPEM     = "/ca.pem"
+	PathProfile = "/rogue.mobileconfig"
+)
+
+// Content types of the certificate downloads.
+const (
+	ContentTypeDER     = "application/x-x509-ca-cert"
+	ContentTypeProfile = "application/x-apple-aspen-config"
+)
+
+// Handler serves the onboarding page and the CA in the forms devices
+// install.
+type Handler struct {
+	CA *x509.Certificate
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	switch r.URL.Path {
+	case "/":
+		h.page(w, r)
+	case PathDER:
+		w.Header().Set("Content-Type", ContentTypeDER)
+		w.Header().Set("Content-Disposition", `attachment; filename="rogue-ca.crt"`)
+		w.Write(h.CA.Raw)
+	case PathPEM:
+		w.Header().Set("Content-Type", "application/x-pem-file")
+		w.Header().Set("Content-Disposition", `attachment; filename="rogue-ca.pem"`)
+		pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: h.CA.Raw})
+	case PathProfile:
+		w.Header().Set("Content-Type", ContentTypeProfile)
+		w.Header().Set("Content-Disposition", `attachment; filename="rogue.mobileconfig"`)
+		w.Write(Profile(h.CA))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (h *Handler) page(w http.ResponseWriter, r *http.Request) {
+	code, err := qr.Encode("http://" + r.Host + "/")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	pageTemplate.Execute(w, map[string]any{
+		"Subject":     h.CA.Subject.CommonName,
+		"Fingerprint": Fingerprint(h.CA),
+		"NotAfter":    h.CA.NotAfter.Format("2006-01-02"),
+		"QR":          template.HTML(code.SVG()),
+		"DER":         PathDER,
+		"PEM":         PathPEM,
+		"Profile":     PathProfile,
+	})
+}
+
+// Fingerprint returns the SHA-256 fingerprint of c as colon-separated hex,
+// the form device settings show it in.
+func Fingerprint(c *x509.Certificate) string {
+	sum := sha256.Sum256(c.Raw)
+	b := make([]byte, 0, len(sum)*3)
+	for i, v := range sum {
+		if i > 0 {
+			b = append(b, ':')
+		}
+		b = fmt.Appendf(b, "%02X", v)
+	}
+	return string(b)
+}
+
+var pageTemplate = template.Must(template.New("page").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Install the rogue CA</title>
+<style>
+body { font-family: system-ui, sans-serif; max-width: 40em; margin: 1em auto; padding: 0 1em; line-height: 1.4; }
+.qr { width: 12em; height: 12em; }
+.fp { font-family: monospace; word-break: break-all; font-size: 0.85em; }
+a.button { display: inline-block; padding: 0.5em 1em; margin: 0.2em 0; border: 1px solid #444; border-radius: 4px; text-decoration: none; }
+</style>
+</head>
+<body>
+<h1>Install the rogue CA</h1>
+<p>Installing <strong>{{.Subject}}</strong> lets the proxy decrypt this device's HTTPS traffic. Remove it when you are done testing.</p>
+<p>SHA-256: <span class="fp">{{.Fingerprint}}</span><br>Valid until {{.NotAfter}}</p>
+<div class="qr">{{.QR}}</div>
+<p>Scan the code to open this page on another device.</p>
+
+<h2>Android</h2>
+<p><a class="button" href="{{.DER}}">Download the certificate</a></p>
+<ol>
+<li>Download the certificate above.</li>
+<li>Open Settings, Security, Encryption &amp; credentials, Install a certificate, CA certificate, and pick the downloaded file. The path varies by vendor; searching Settings for "CA certificate" finds it.</li>
+<li>Confirm the warning and unlock the device if asked.</li>
+</ol>
+<p>Apps built for Android 7 or later ignore user-installed CAs unless they opt in. On a rooted device, install the module from <code>rogue cert android-magisk</code> with Magisk to add the CA to the system store instead.</p>
+
+<h2>iOS and iPadOS</h2>
+<p><a class="button" href="{{.Profile}}">Download the profile</a></p>
+<ol>
+<li>Open this page in Safari and download the profile above; allow the download.</li>
+<li>Open Settings, General, VPN &amp; Device Management, select the rogue profile and tap Install.</li>
+<li>Open Settings, General, About, Certificate Trust Settings, and turn on full trust for {{.Subject}}.</li>
+</ol>
+
+<h2>Other devices</h2>
+<p>The certificate is also available <a href="{{.PEM}}">as PEM</a>.</p>
+</body>
+</html>
+`))
@@ -0,0 +1,122 @@
+package onboard
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/standrze/rogue/internal/cert"
+)
+
+func testCA(t *testing.T) *x509.Certificate {
+	t.Helper()
+	certPEM, _, err := cert.NewSelfSigned("Rogue", "Rogue Test CA", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(certPEM)
+	c, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestHandler(t *testing.T) {
+	ca := testCA(t)
+	h := &Handler{CA: ca}
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "http://192.168.1.20:8089"+path, nil))
+		return rec
+	}
+
+	rec := get("/")
+	body := rec.Body.String()
+	for _, want := range []string{"<svg", "Rogue Test CA", Fingerprint(ca), `href="/ca.crt"`, `href="/rogue.mobileconfig"`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("page missing %s", want)
+		}
+	}
+
+	rec = get(PathDER)
+	if ct := rec.Header().Get("Content-Type"); ct != ContentTypeDER || !bytes.Equal(rec.Body.Bytes(), ca.Raw) {
+		t.Errorf("DER download: %s, %d bytes", ct, rec.Body.Len())
+	}
+	if block, _ := pem.Decode(get(PathPEM).Body.Bytes()); block == nil || !bytes.Equal(block.Bytes, ca.Raw) {
+		t.Error("PEM download does not hold the CA")
+	}
+	rec = get(PathProfile)
+	if ct := rec.Header().Get("Content-Type"); ct != ContentTypeProfile || !strings.Contains(rec.Body.String(), "com.apple.security.root") {
+		t.Errorf("profile download: %s\n%s", ct, rec.Body.String())
+	}
+	if rec := get("/missing"); rec.Code != 404 {
+		t.Errorf("unknown path: %d", rec.Code)
+	}
+}
+
+func TestProfile(t *testing.T) {
+	ca := testCA(t)
+	a, b := Profile(ca), Profile(ca)
+	if !bytes.Equal(a, b) {
+		t.Error("profile differs between calls")
+	}
+	if bytes.Equal(a, Profile(testCA(t))) {
+		t.Error("profiles of different CAs are equal")
+	}
+}
+
+func TestSubjectHash(t *testing.T) {
+	// openssl x509 -subject_hash_old of O=Rogue, CN=Rogue Test CA.
+	if got := SubjectHash(testCA(t)); got != "1ddf44b6" {
+		t.Errorf("hash = %s", got)
+	}
+}
+
+func TestWriteMagisk(t *testing.T) {
+	ca := testCA(t)
+	var buf bytes.Buffer
+	if err := WriteMagisk(&buf, ca); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]*zip.File{}
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+	read := func(name string) string {
+		f := files[name]
+		if f == nil {
+			t.Fatalf("module missing %s", name)
+		}
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		data, _ := io.ReadAll(rc)
+		return string(data)
+	}
+
+	if prop := read("module.prop"); !strings.Contains(prop, "id="+ModuleID+"\n") {
+		t.Errorf("module.prop:\n%s", prop)
+	}
+	if script := read("META-INF/com/google/android/updater-script"); script != "#MAGISK\n" {
+		t.Errorf("updater-script = %q", script)
+	}
+	block, _ := pem.Decode([]byte(read("system/etc/security/cacerts/1ddf44b6.0")))
+	if block == nil || !bytes.Equal(block.Bytes, ca.Raw) {
+		t.Error("module does not hold the CA")
+	}
+	if mode := files["post-fs-data.sh"].Mode(); mode&0o111 == 0 {
+		t.Errorf("post-fs-data.sh mode = %v", mode)
+	}
+}
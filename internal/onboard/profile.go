@@ -0,0 +1,74 @@
+package onboard
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+)
+
+// Profile returns an iOS configuration profile installing c as a root CA.
+// Its identifiers derive from the certificate, so downloading it again
+// replaces an installed copy instead of adding another.
+func Profile(c *x509.Certificate) []byte {
+	name := c.Subject.CommonName
+	if name == "" {
+		name = "rogue CA"
+	}
+	var b bytes.Buffer
+	b.WriteString(xml.Header)
+	b.WriteString(`<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>PayloadContent</key>
+	<array>
+		<dict>
+			<key>PayloadCertificateFileName</key>
+			<string>rogue-ca.crt</string>
+			<key>PayloadContent</key>
+			<data>`)
+	b.WriteString(base64.StdEncoding.EncodeToString(c.Raw))
+	b.WriteString(`</data>
+			<key>PayloadDisplayName</key>
+			<string>`)
+	xml.EscapeText(&b, []byte(name))
+	fmt.Fprintf(&b, `</string>
+			<key>PayloadIdentifier</key>
+			<string>com.github.standrze.rogue.ca.%[1]s</string>
+			<key>PayloadType</key>
+			<string>com.apple.security.root</string>
+			<key>PayloadUUID</key>
+			<string>%[1]s</string>
+			<key>PayloadVersion</key>
+			<integer>1</integer>
+		</dict>
+	</array>
+	<key>PayloadDescription</key>
+	<string>Trusts the rogue proxy's CA to intercept HTTPS traffic for testing.</string>
+	<key>PayloadDisplayName</key>
+	<string>rogue</string>
+	<key>PayloadIdentifier</key>
+	<string>com.github.standrze.rogue.%[2]s</string>
+	<key>PayloadRemovalDisallowed</key>
+	<false/>
+	<key>PayloadType</key>
+	<string>Configuration</string>
+	<key>PayloadUUID</key>
+	<string>%[2]s</string>
+	<key>PayloadVersion</key>
+	<integer>1</integer>
+</dict>
+</plist>
+`, uuid(c, "payload"), uuid(c, "profile"))
+	return b.Bytes()
+}
+
+// uuid derives a version 8 UUID from c and kind.
+func uuid(c *x509.Certificate, kind string) string {
+	sum := sha256.Sum256(append([]byte(kind+"\x00"), c.Raw...))
+	sum[6] = sum[6]&0x0F | 0x80
+	sum[8] = sum[8]&0x3F | 0x80
+	return fmt.Sprintf("%X-%X-%X-%X-%X", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+}
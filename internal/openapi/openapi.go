@@ -0,0 +1,297 @@
+// Package openapi turns OpenAPI 3 descriptions into rogue features, such as
+// mock rules serving each operation's example response.
+package openapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/standrze/rogue/internal/rules"
+)
+
+// maxSchemaDepth stops example generation for recursive schemas.
+const maxSchemaDepth = 6
+
+// Load reads and validates an OpenAPI 3 document from a file or URL.
+func Load(location string) (*openapi3.T, error) {
+	loader := openapi3.NewLoader()
+	loader.IsExternalRefsAllowed = true
+
+	var doc *openapi3.T
+	var err error
+	if u, perr := url.Parse(location); perr == nil && (u.Scheme == "http" || u.Scheme == "https") {
+		doc, err = loader.LoadFromURI(u)
+	} else {
+		doc, err = loader.LoadFromFile(location)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := doc.Validate(context.Background()); err != nil {
+		return nil, fmt.Errorf("invalid OpenAPI document: %w", err)
+	}
+	return doc, nil
+}
+
+// serverTarget returns the host glob and base path of the first server. An
+// empty host matches any host, which suits relative server URLs.
+func serverTarget(doc *openapi3.T) (host, basePath string) {
+	if len(doc.Servers) == 0 {
+		return "", ""
+	}
+	s := doc.Servers[0]
+	raw := s.URL
+	for name, v := range s.Variables {
+		raw = strings.ReplaceAll(raw, "{"+name+"}", v.Default)
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", ""
+	}
+	return u.Hostname(), strings.TrimSuffix(u.Path, "/")
+}
+
+// MockRules returns a mock rule for every operation in doc, answering with
+// its lowest 2xx response (or the default response). Concrete paths come
+// before templated ones so they take precedence. host overrides the host
+// taken from the document's servers; "*" matches any host.
+func MockRules(doc *openapi3.T, host string) []rules.Rule {
+	docHost, basePath := serverTarget(doc)
+	if host == "" {
+		host = docHost
+	}
+	if host == "*" {
+		host = ""
+	}
+
+	var out []rules.Rule
+	for _, p := range doc.Paths.InMatchingOrder() {
+		item := doc.Paths.Value(p)
+		ops := item.Operations()
+
+		methods := make([]string, 0, len(ops))
+		for m := range ops {
+			methods = append(methods, m)
+		}
+		sort.Strings(methods)
+
+		for _, method := range methods {
+			op := ops[method]
+			name := op.OperationID
+			if name == "" {
+				name = method + " " + p
+			}
+			out = append(out, rules.Rule{
+				Name: "openapi: " + name,
+				Match: rules.Match{
+					Methods: []string{method},
+					Host:    host,
+					Path:    basePath + p,
+				},
+				Mock: mockFor(op),
+			})
+		}
+	}
+	return out
+}
+
+func mockFor(op *openapi3.Operation) *rules.Mock {
+	status, resp := pickResponse(op.Responses)
+	mock := &rules.Mock{Status: status}
+	if resp == nil {
+		return mock
+	}
+
+	ct, media := pickContent(resp.Content)
+	if media == nil {
+		return mock
+	}
+	mock.Headers = map[string]string{"Content-Type": ct}
+
+	example := exampleFor(media)
+	switch v := example.(type) {
+	case nil:
+	case string:
+		if strings.Contains(ct, "json") {
+			b, _ := json.Marshal(v)
+			mock.Body = string(b)
+		} else {
+			mock.Body = v
+		}
+	default:
+		b, err := json.MarshalIndent(v, "", "  ")
+		if err == nil {
+			mock.Body = string(b)
+		}
+	}
+	return mock
+}
+
+// pickResponse prefers the lowest 2xx status, then "default" as 200.
+func pickResponse(responses *openapi3.Responses) (int, *openapi3.Response) {
+	if responses == nil {
+		return 200, nil
+	}
+
+	best := 0
+	var bestResp *openapi3.Response
+	for code, ref := range responses.Map() {
+		n, err := strconv.Atoi(code)
+		if err != nil || n < 200 || n > 299 || ref.Value == nil {
+			continue
+		}
+		if best == 0 || n < best {
+			best, bestResp = n, ref.Value
+		}
+	}
+	if bestResp != nil {
+		return best, bestResp
+	}
+	if def := responses.Default(); def != nil && def.Value != nil {
+		return 200, def.Value
+	}
+	return 200, nil
+}
+
+// pickContent prefers JSON media types.
+func pickContent(content openapi3.Content) (string, *openapi3.MediaType) {
+	if len(content) == 0 {
+		return "", nil
+	}
+	types := make([]string, 0, len(content))
+	for ct := range content {
+		types = append(types, ct)
+	}
+	sort.Slice(types, func(i, j int) bool {
+		ji, jj := strings.Contains(types[i], "json"), strings.Contains(types[j], "json")
+		if ji != jj {
+			return ji
+		}
+		return types[i] < types[j]
+	})
+	return types[0], content[types[0]]
+}
+
+func exampleFor(media *openapi3.MediaType) any {
+	if media.Example != nil {
+		return media.Example
+	}
+	names := make([]string, 0, len(media.Examples))
+	for name, ex := range media.Examples {
+		if ex != nil && ex.Value != nil {
+			names = append(names, name)
+		}
+	}
+	if len(names) > 0 {
+		sort.Strings(names)
+		return media.Examples[names[0]].Value.Value
+	}
+	if media.Schema != nil {
+		return Example(media.Schema.Value)
+	}
+	return nil
+}
+
+// Example synthesizes a value conforming to schema, using its examples,
+// defaults and enums where present.
+func Example(schema *openapi3.Schema) any {
+	return example(schema, 0)
+}
+
+func example(s *openapi3.Schema, depth int) any {
+	if s == nil || depth > maxSchemaDepth {
+		return nil
+	}
+	switch {
+	case s.Example != nil:
+		return s.Example
+	case s.Default != nil:
+		return s.Default
+	case len(s.Enum) > 0:
+		return s.Enum[0]
+	}
+
+	if len(s.AllOf) > 0 {
+		merged := map[string]any{}
+		for _, ref := range s.AllOf {
+			if m, ok := example(ref.Value, depth+1).(map[string]any); ok {
+				for k, v := range m {
+					merged[k] = v
+				}
+			}
+		}
+		return merged
+	}
+	for _, alts := range []openapi3.SchemaRefs{s.OneOf, s.AnyOf} {
+		if len(alts) > 0 {
+			return example(alts[0].Value, depth+1)
+		}
+	}
+
+	typ := ""
+	if s.Type != nil && len(*s.Type) > 0 {
+		typ = (*s.Type)[0]
+	} else if len(s.Properties) > 0 {
+		typ = openapi3.TypeObject
+	}
+
+	switch typ {
+	case openapi3.TypeObject:
+		obj := map[string]any{}
+		for name, prop := range s.Properties {
+			if prop.Value != nil && prop.Value.WriteOnly {
+				continue
+			}
+			obj[name] = example(prop.Value, depth+1)
+		}
+		return obj
+	case openapi3.TypeArray:
+		if s.Items == nil {
+			return []any{}
+		}
+		return []any{example(s.Items.Value, depth+1)}
+	case openapi3.TypeString:
+		return stringExample(s.Format)
+	case openapi3.TypeInteger:
+		if s.Min != nil {
+			return int64(*s.Min)
+		}
+		return 0
+	case openapi3.TypeNumber:
+		if s.Min != nil {
+			return *s.Min
+		}
+		return 0.0
+	case openapi3.TypeBoolean:
+		return true
+	}
+	return nil
+}
+
+func stringExample(format string) string {
+	switch format {
+	case "date-time":
+		return "2024-01-01T00:00:00Z"
+	case "date":
+		return "2024-01-01"
+	case "uuid":
+		return "00000000-0000-4000-8000-000000000000"
+	case "email":
+		return "user@example.com"
+	case "uri", "url":
+		return "https://example.com/"
+	case "ipv4":
+		return "192.0.2.1"
+	case "ipv6":
+		return "2001:db8::1"
+	case "byte":
+		return "cm9ndWU="
+	}
+	return "string"
+}
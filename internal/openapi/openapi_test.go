@@ -0,0 +1,128 @@
+package openapi
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/standrze/rogue/internal/rules"
+)
+
+const petstore = `
+openapi: 3.0.3
+info: {title: Pets, version: "1"}
+servers:
+  - url: https://api.pets.test/v1
+paths:
+  /pets/{id}:
+    get:
+      operationId: getPet
+      parameters:
+        - {name: id, in: path, required: true, schema: {type: integer}}
+      responses:
+        "200":
+          description: ok
+          content:
+            application/json:
+              schema: {$ref: "#/components/schemas/Pet"}
+        "404": {description: missing}
+  /pets/mine:
+    get:
+      operationId: myPets
+      responses:
+        "200":
+          description: ok
+          content:
+            application/json:
+              example: [{id: 7, name: Rex}]
+    post:
+      responses:
+        "201": {description: created}
+components:
+  schemas:
+    Pet:
+      type: object
+      properties:
+        id: {type: integer, minimum: 1}
+        name: {type: string}
+        born: {type: string, format: date}
+        tags: {type: array, items: {type: string, enum: [good]}}
+`
+
+func TestMockRules(t *testing.T) {
+	doc, err := openapi3.NewLoader().LoadFromData([]byte(petstore))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	set := rules.NewSet(MockRules(doc, "")...)
+	tests := []struct {
+		method, url string
+		status      int
+		body        string
+	}{
+		{"GET", "https://api.pets.test/v1/pets/mine", 200, "[\n  {\n    \"id\": 7,\n    \"name\": \"Rex\"\n  }\n]"},
+		{"POST", "https://api.pets.test/v1/pets/mine", 201, ""},
+		{"GET", "https://api.pets.test/v1/pets/3", 200,
+			"{\n  \"born\": \"2024-01-01\",\n  \"id\": 1,\n  \"name\": \"string\",\n  \"tags\": [\n    \"good\"\n  ]\n}"},
+	}
+	for _, tt := range tests {
+		rule, ok := set.Match(httptest.NewRequest(tt.method, tt.url, nil))
+		if !ok {
+			t.Errorf("%s %s: no rule matched", tt.method, tt.url)
+			continue
+		}
+		if rule.Mock.Status != tt.status || rule.Mock.Body != tt.body {
+			t.Errorf("%s %s: got %d %q, want %d %q", tt.method, tt.url, rule.Mock.Status, rule.Mock.Body, tt.status, tt.body)
+		}
+	}
+
+	if _, ok := set.Match(httptest.NewRequest("GET", "https://other.test/v1/pets/3", nil)); ok {
+		t.Error("Rules should be limited to the server host")
+	}
+}
+
+func TestValidator(t *testing.T) {
+	doc, err := openapi3.NewLoader().LoadFromData([]byte(petstore))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := NewValidator(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := v.ValidateRequest(httptest.NewRequest("GET", "https://api.pets.test/v1/pets/3", nil)); err != nil {
+		t.Errorf("Valid request rejected: %v", err)
+	}
+	if err := v.ValidateRequest(httptest.NewRequest("DELETE", "https://api.pets.test/v1/pets/mine", nil)); err == nil {
+		t.Error("Expected undocumented method to be reported")
+	}
+	if err := v.ValidateRequest(httptest.NewRequest("GET", "https://other.test/anything", nil)); err != nil {
+		t.Errorf("Requests outside the spec should not be checked: %v", err)
+	}
+
+	req := httptest.NewRequest("GET", "https://api.pets.test/v1/pets/3", nil)
+	res := &http.Response{
+		StatusCode: 200,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(`{"id": 0, "name": "Rex"}`)),
+		Request:    req,
+	}
+	err = v.ValidateResponse(res)
+	if err == nil || !strings.Contains(err.Error(), "/id") {
+		t.Errorf("Expected id minimum violation, got %v", err)
+	}
+	if body, _ := io.ReadAll(res.Body); string(body) != `{"id": 0, "name": "Rex"}` {
+		t.Errorf("Response body not restored: %q", body)
+	}
+
+	res.StatusCode = 500
+	res.Body = http.NoBody
+	if err := v.ValidateResponse(res); err == nil {
+		t.Error("Expected undocumented status to be reported")
+	}
+}
@@ -0,0 +1,102 @@
+package openapi
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/legacy"
+)
+
+// Validator checks live traffic against the operations of a document.
+// Requests to paths the document does not describe are not checked.
+type Validator struct {
+	router  routers.Router
+	options *openapi3filter.Options
+}
+
+// NewValidator prepares doc for validating requests and responses.
+func NewValidator(doc *openapi3.T) (*Validator, error) {
+	router, err := legacy.NewRouter(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := &openapi3filter.Options{
+		MultiError:            true,
+		IncludeResponseStatus: true,
+		// Credentials are required to be present but not checked.
+		AuthenticationFunc:  openapi3filter.NoopAuthenticationFunc,
+		SkipSettingDefaults: true,
+	}
+	opts.WithCustomSchemaErrorFunc(func(err *openapi3.SchemaError) string {
+		return "/" + strings.Join(err.JSONPointer(), "/") + ": " + err.Reason
+	})
+	return &Validator{router: router, options: opts}, nil
+}
+
+// input finds the operation for req. It reports false when req is outside
+// the document.
+func (v *Validator) input(req *http.Request) (*openapi3filter.RequestValidationInput, bool, error) {
+	route, params, err := v.router.FindRoute(req)
+	// The router returns fresh errors rather than the sentinel.
+	var re *routers.RouteError
+	if errors.As(err, &re) && re.Reason == routers.ErrPathNotFound.Error() {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, true, err
+	}
+	return &openapi3filter.RequestValidationInput{
+		Request:    req,
+		PathParams: params,
+		Route:      route,
+		Options:    v.options,
+	}, true, nil
+}
+
+// ValidateRequest checks req's parameters, security and body. The body is
+// restored so the request can still be forwarded.
+func (v *Validator) ValidateRequest(req *http.Request) error {
+	in, ok, err := v.input(req)
+	if !ok || err != nil {
+		return err
+	}
+	return openapi3filter.ValidateRequest(context.Background(), in)
+}
+
+// ValidateResponse checks res's status, headers and body against the
+// operation of its request. The body is restored for delivery.
+func (v *Validator) ValidateResponse(res *http.Response) error {
+	if res.Request == nil {
+		return nil
+	}
+	in, ok, err := v.input(res.Request)
+	if !ok || err != nil {
+		return err
+	}
+
+	var body []byte
+	if res.Body != nil {
+		if body, err = io.ReadAll(res.Body); err != nil {
+			return err
+		}
+		res.Body.Close()
+		res.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	out := &openapi3filter.ResponseValidationInput{
+		RequestValidationInput: in,
+		Status:                 res.StatusCode,
+		Header:                 res.Header,
+		Options:                v.options,
+	}
+	out.SetBodyBytes(body)
+	return openapi3filter.ValidateResponse(context.Background(), out)
+}
@@ -0,0 +1,74 @@
+// Package pac generates proxy auto-config files pointing clients at rogue.
+package pac
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+// ContentType is the MIME type browsers expect for PAC files.
+const ContentType = "application/x-ns-proxy-autoconfig"
+
+// Generate returns a PAC file sending requests for hosts matching any of the
+// direct glob patterns straight to the origin and everything else to proxy,
+// a "host:port" address.
+func Generate(proxy string, direct []string) []byte {
+	return generate("PROXY "+proxy, direct)
+}
+
+// GenerateHTTPS is like Generate for a proxy clients speak TLS to.
+func GenerateHTTPS(proxy string, direct []string) []byte {
+	return generate("HTTPS "+proxy, direct)
+}
+
+func generate(result string, direct []string) []byte {
+	var b bytes.Buffer
+	b.WriteString("function FindProxyForURL(url, host) {\n")
+	b.WriteString("  host = host.toLowerCase();\n")
+	for _, p := range direct {
+		fmt.Fprintf(&b, "  if (shExpMatch(host, %s)) return \"DIRECT\";\n", quote(p))
+	}
+	fmt.Fprintf(&b, "  return %s;\n", quote(result))
+	b.WriteString("}\n")
+	return b.Bytes()
+}
+
+// quote renders s as a JavaScript string literal.
+func quote(s string) string {
+	q, _ := json.Marshal(s)
+	return string(q)
+}
+
+// Handler serves a PAC file.
+type Handler struct {
+	// Proxy is the address clients are told to use. When empty, the host
+	// name the PAC file was fetched from is combined with Port.
+	Proxy string
+	Port  int
+	// Direct lists host glob patterns that bypass the proxy.
+	Direct []string
+	// HTTPS tells clients to speak TLS to the proxy.
+	HTTPS bool
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	proxy := h.Proxy
+	if proxy == "" {
+		host := r.Host
+		if hh, _, err := net.SplitHostPort(host); err == nil {
+			host = hh
+		}
+		proxy = net.JoinHostPort(host, strconv.Itoa(h.Port))
+	}
+	w.Header().Set("Content-Type", ContentType)
+	w.Header().Set("Cache-Control", "no-cache")
+	if h.HTTPS {
+		w.Write(GenerateHTTPS(proxy, h.Direct))
+		return
+	}
+	w.Write(Generate(proxy, h.Direct))
+}
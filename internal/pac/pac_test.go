@@ -0,0 +1,43 @@
+package pac
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	h := &Handler{Port: 8080, Direct: []string{"*.internal", `we"ird`}}
+
+	req := httptest.NewRequest("GET", "http://10.0.0.5:8081/proxy.pac", nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if ct := rec.Header().Get("Content-Type"); ct != ContentType {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{
+		`if (shExpMatch(host, "*.internal")) return "DIRECT";`,
+		`if (shExpMatch(host, "we\"ird")) return "DIRECT";`,
+		`return "PROXY 10.0.0.5:8080";`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("PAC file missing %s:\n%s", want, body)
+		}
+	}
+
+	h.Proxy = "proxy.corp:3128"
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if !strings.Contains(rec.Body.String(), `"PROXY proxy.corp:3128"`) {
+		t.Errorf("configured proxy not used:\n%s", rec.Body.String())
+	}
+
+	h.HTTPS = true
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if !strings.Contains(rec.Body.String(), `"HTTPS proxy.corp:3128"`) {
+		t.Errorf("TLS proxy not announced:\n%s", rec.Body.String())
+	}
+}
@@ -0,0 +1,73 @@
+// Package pages renders the HTML pages rogue answers with itself, such as
+// upstream failures and CA trust instructions. Built-in templates can be
+// replaced by files of the same name for branding.
+package pages
+
+import (
+	"bytes"
+	"embed"
+	"html/template"
+	"path/filepath"
+)
+
+//go:embed templates/*.html
+var templateFS embed.FS
+
+// Page names, which are also the file names used to override them.
+const (
+	Blocked       = "blocked.html"
+	AuthRequired  = "auth_required.html"
+	UpstreamError = "upstream_error.html"
+	Trust         = "trust.html"
+)
+
+// Data is passed to every page.
+type Data struct {
+	Title     string
+	Status    int
+	Reason    string
+	Host      string
+	URL       string
+	RequestID string
+	// Cause and Fix explain a diagnosed failure.
+	Cause string
+	Fix   string
+	// CAURL is where the CA certificate can be downloaded.
+	CAURL string
+}
+
+// Pages is a set of parsed page templates.
+type Pages struct {
+	t *template.Template
+}
+
+// New parses the built-in pages. Any *.html files in dir are parsed on top,
+// replacing built-in pages and the "head" and "foot" blocks they share.
+func New(dir string) (*Pages, error) {
+	t, err := template.ParseFS(templateFS, "templates/*.html")
+	if err != nil {
+		return nil, err
+	}
+
+	if dir != "" {
+		files, err := filepath.Glob(filepath.Join(dir, "*.html"))
+		if err != nil {
+			return nil, err
+		}
+		if len(files) > 0 {
+			if t, err = t.ParseFiles(files...); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &Pages{t: t}, nil
+}
+
+// Render executes the named page.
+func (p *Pages) Render(name string, data Data) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := p.t.ExecuteTemplate(&buf, name, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,36 @@
+package pages
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOverride(t *testing.T) {
+	dir := t.TempDir()
+	custom := `{{define "upstream_error.html"}}{{template "head" .}}<p class="brand">Acme: {{.Reason}}</p>{{template "foot" .}}{{end}}`
+	if err := os.WriteFile(filepath.Join(dir, UpstreamError), []byte(custom), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := Data{Title: "Unavailable", Status: 502, Reason: "<dial failed>", RequestID: "abc123"}
+	body, err := p.Render(UpstreamError, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page := string(body)
+	if !strings.Contains(page, "Acme: &lt;dial failed&gt;") || !strings.Contains(page, "abc123") {
+		t.Errorf("Override not rendered with escaped data: %s", page)
+	}
+
+	// Pages without an override keep the built-in template.
+	if body, err := p.Render(Blocked, Data{Host: "ads.example"}); err != nil || !strings.Contains(string(body), "ads.example") {
+		t.Errorf("Built-in page not rendered: %v %s", err, body)
+	}
+}
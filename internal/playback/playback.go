@@ -0,0 +1,202 @@
+// Package playback answers HTTP requests with the responses of a recorded
+// session, turning real captures into a mock server. Requests are matched
+// against the recorded ones on configurable parts; a request recorded
+// several times is answered with each recording in turn, so a sequence of
+// polls plays back as it happened.
+package playback
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/standrze/rogue/internal/logger"
+)
+
+// Header names the recording that answered a request, or "miss".
+const Header = "X-Rogue-Playback"
+
+// hopHeaders are recorded but describe the original connection, not the
+// replayed response.
+var hopHeaders = []string{"Connection", "Keep-Alive", "Content-Length", "Transfer-Encoding", "Trailer", "Upgrade"}
+
+// Criteria are the parts of a request that must equal those of a recording.
+type Criteria struct {
+	Method, Host, Path, Query, Body bool
+	// IgnoreParams are query parameters left out of the comparison, such
+	// as cache busters and timestamps.
+	IgnoreParams []string
+}
+
+// DefaultCriteria match the method, path and query.
+var DefaultCriteria = Criteria{Method: true, Path: true, Query: true}
+
+// ParseCriteria reads criteria from names: method, host, path, query and
+// body.
+func ParseCriteria(names []string) (Criteria, error) {
+	var c Criteria
+	for _, n := range names {
+		switch strings.ToLower(strings.TrimSpace(n)) {
+		case "method":
+			c.Method = true
+		case "host":
+			c.Host = true
+		case "path":
+			c.Path = true
+		case "query":
+			c.Query = true
+		case "body":
+			c.Body = true
+		default:
+			return c, fmt.Errorf("unknown match criterion %q (want method, host, path, query or body)", n)
+		}
+	}
+	return c, nil
+}
+
+// Server replays the flows of a session.
+type Server struct {
+	criteria Criteria
+	flows    []*logger.Flow
+	// OnMiss, if set, is called for requests no recording matches.
+	OnMiss func(r *http.Request)
+
+	mu sync.Mutex
+	// served counts how often each key has been answered.
+	served map[string]int
+	byKey  map[string][]*logger.Flow
+}
+
+// New returns a server replaying the complete flows among flows.
+func New(flows []*logger.Flow, c Criteria) *Server {
+	s := &Server{criteria: c, served: map[string]int{}, byKey: map[string][]*logger.Flow{}}
+	for _, f := range flows {
+		if f.Request == nil || f.Response == nil {
+			continue
+		}
+		body, err := f.Request.RawBody()
+		if err != nil {
+			continue
+		}
+		u, err := url.Parse(f.Request.URL)
+		if err != nil {
+			continue
+		}
+		key := s.key(f.Request.Method, u, body)
+		s.byKey[key] = append(s.byKey[key], f)
+		s.flows = append(s.flows, f)
+	}
+	return s
+}
+
+// Len is the number of flows that can be replayed.
+func (s *Server) Len() int { return len(s.flows) }
+
+// key is what requests that match under the criteria have in common.
+func (s *Server) key(method string, u *url.URL, body []byte) string {
+	var parts []string
+	if s.criteria.Method {
+		parts = append(parts, strings.ToUpper(method))
+	}
+	if s.criteria.Host {
+		parts = append(parts, strings.ToLower(u.Host))
+	}
+	if s.criteria.Path {
+		parts = append(parts, u.EscapedPath())
+	}
+	if s.criteria.Query {
+		q := u.Query()
+		for _, p := range s.criteria.IgnoreParams {
+			q.Del(p)
+		}
+		// Encode sorts by key; values keep their order.
+		parts = append(parts, q.Encode())
+	}
+	if s.criteria.Body {
+		parts = append(parts, string(canonicalBody(body)))
+	}
+	return strings.Join(parts, "\x00")
+}
+
+// canonicalBody removes differences in whitespace and member order from
+// JSON bodies; other bodies are compared as they are.
+func canonicalBody(body []byte) []byte {
+	var v any
+	if err := json.Unmarshal(body, &v); err != nil {
+		return body
+	}
+	out, err := json.Marshal(v)
+	if err != nil {
+		return body
+	}
+	return out
+}
+
+// next returns the recording to answer a request with key, advancing
+// through repeated recordings and then staying on the last.
+func (s *Server) next(key string) (*logger.Flow, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	flows := s.byKey[key]
+	if len(flows) == 0 {
+		return nil, false
+	}
+	i := min(s.served[key], len(flows)-1)
+	s.served[key]++
+	return flows[i], true
+}
+
+// Reset starts every sequence of repeated recordings from the beginning.
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	clear(s.served)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	u := *r.URL
+	if u.Host == "" {
+		u.Host = r.Host
+	}
+
+	f, ok := s.next(s.key(r.Method, &u, body))
+	if !ok {
+		if s.OnMiss != nil {
+			s.OnMiss(r)
+		}
+		w.Header().Set(Header, "miss")
+		http.Error(w, fmt.Sprintf("no recorded response matches %s %s", r.Method, r.URL.RequestURI()), http.StatusNotFound)
+		return
+	}
+
+	res := f.Response
+	data, err := res.RawBody()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for k, v := range res.Headers {
+		if !slices.ContainsFunc(hopHeaders, func(h string) bool { return strings.EqualFold(h, k) }) {
+			w.Header().Set(k, v)
+		}
+	}
+	w.Header().Set(Header, f.ID)
+	if res.Truncated {
+		w.Header().Set("X-Rogue-Body-Truncated", "true")
+	}
+	w.WriteHeader(res.StatusCode)
+	if r.Method != http.MethodHead {
+		io.Copy(w, bytes.NewReader(data))
+	}
+}
@@ -0,0 +1,92 @@
+package playback
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/standrze/rogue/internal/logger"
+)
+
+func flow(id, method, url, reqBody string, status int, body string) *logger.Flow {
+	return &logger.Flow{
+		ID:       id,
+		Request:  &logger.RequestLog{Method: method, URL: url, Body: reqBody, BodySize: int64(len(reqBody))},
+		Response: &logger.ResponseLog{StatusCode: status, Headers: map[string]string{"Content-Type": "text/plain", "Content-Length": "99"}, Body: body, BodySize: int64(len(body))},
+	}
+}
+
+func get(t *testing.T, s *Server, method, target, body string) (int, string, string) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(method, target, strings.NewReader(body)))
+	data, _ := io.ReadAll(w.Result().Body)
+	return w.Code, w.Header().Get(Header), string(data)
+}
+
+func TestPlayback(t *testing.T) {
+	flows := []*logger.Flow{
+		flow("1", "GET", "http://api.test/items?a=1&b=2", "", 200, "first"),
+		flow("2", "GET", "http://api.test/items?b=2&a=1", "", 200, "second"),
+		flow("3", "POST", "http://api.test/items", `{"x":1,"y":2}`, 201, "created"),
+		{ID: "4", Request: &logger.RequestLog{Method: "GET", URL: "http://api.test/pending"}},
+	}
+	s := New(flows, DefaultCriteria)
+	if s.Len() != 3 {
+		t.Fatalf("Len = %d, want 3", s.Len())
+	}
+
+	for _, want := range []string{"first", "second", "second"} {
+		if code, id, body := get(t, s, "GET", "/items?b=2&a=1", ""); code != 200 || body != want || id == "" {
+			t.Errorf("Got %d %q %q, want %q", code, id, body, want)
+		}
+	}
+	s.Reset()
+	if _, _, body := get(t, s, "GET", "/items?a=1&b=2", ""); body != "first" {
+		t.Errorf("After Reset got %q, want first", body)
+	}
+
+	if code, id, _ := get(t, s, "GET", "/pending", ""); code != 404 || id != "miss" {
+		t.Errorf("Incomplete flow: got %d %q, want a miss", code, id)
+	}
+	if code, _, _ := get(t, s, "GET", "/items", ""); code != 404 {
+		t.Errorf("Different query: got %d, want 404", code)
+	}
+	if code, _, body := get(t, s, "POST", "/items", "anything"); code != 201 || body != "created" {
+		t.Errorf("Body not matched: got %d %q", code, body)
+	}
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/items?a=1&b=2", nil))
+	if cl := w.Header().Get("Content-Length"); cl == "99" {
+		t.Error("Recorded Content-Length was replayed")
+	}
+}
+
+func TestCriteria(t *testing.T) {
+	flows := []*logger.Flow{
+		flow("1", "POST", "http://a.test/q?t=1", `{"x": 1, "y": 2}`, 200, "a"),
+		flow("2", "POST", "http://b.test/q?t=1", `{"x": 3}`, 200, "b"),
+	}
+	c, err := ParseCriteria([]string{"method", "host", "path", "query", "body"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.IgnoreParams = []string{"t"}
+	s := New(flows, c)
+
+	if _, _, body := get(t, s, "POST", "http://a.test/q?t=99", `{"y":2,"x":1}`); body != "a" {
+		t.Errorf("Got %q, want a", body)
+	}
+	if code, _, _ := get(t, s, "POST", "http://a.test/q", `{"x":3}`); code != 404 {
+		t.Errorf("Body mismatch: got %d, want 404", code)
+	}
+	if _, _, body := get(t, s, "POST", "http://b.test/q", `{"x":3}`); body != "b" {
+		t.Errorf("Got %q, want b", body)
+	}
+
+	if _, err := ParseCriteria([]string{"cookie"}); err == nil {
+		t.Error("Unknown criterion accepted")
+	}
+}
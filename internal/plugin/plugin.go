@@ -0,0 +1,297 @@
+// Package plugin runs request and response modifiers provided by separate
+// programs, so custom logic can be added to rogue without forking it.
+//
+// A plugin is an executable speaking newline-delimited JSON over its
+// standard input and output. It first writes a Hello naming itself and the
+// hooks it implements. Rogue then sends one Message per exchange and hook,
+// and the plugin answers each with a Message carrying the same ID: a
+// changed request or response, a response answering a request in place of
+// the origin, or neither to leave the exchange alone. Messages may be
+// answered in any order. Anything the plugin writes to standard error is
+// passed through to rogue's.
+package plugin
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Version is the protocol version plugins announce in their Hello.
+const Version = 1
+
+// Hooks a plugin may implement.
+const (
+	HookRequest  = "request"
+	HookResponse = "response"
+)
+
+// Hello is the first line a plugin writes.
+type Hello struct {
+	Name    string   `json:"name"`
+	Version int      `json:"version"`
+	Hooks   []string `json:"hooks"`
+	// Hosts are glob patterns of the hosts the plugin is sent exchanges
+	// for; empty means every host.
+	Hosts []string `json:"hosts,omitempty"`
+}
+
+// Request is an HTTP request as exchanged with plugins. Bodies are base64
+// encoded. In replies, empty fields are left as they were; an empty body is
+// sent as "".
+type Request struct {
+	Method  string      `json:"method"`
+	URL     string      `json:"url"`
+	Headers http.Header `json:"headers"`
+	Body    []byte      `json:"body,omitempty"`
+	// BodyOmitted is set when the body was too large to send, and for the
+	// request of a response call. Bodies in replies to such calls are
+	// ignored.
+	BodyOmitted bool `json:"body_omitted,omitempty"`
+}
+
+// Response is an HTTP response as exchanged with plugins, like Request.
+type Response struct {
+	Status      int         `json:"status"`
+	Headers     http.Header `json:"headers"`
+	Body        []byte      `json:"body,omitempty"`
+	BodyOmitted bool        `json:"body_omitted,omitempty"`
+}
+
+// Message is a call from rogue or a plugin's reply to it. Calls carry the
+// hook and the exchange so far; replies carry what the plugin changed, or
+// an error. Tags and Note in a reply are recorded in the session as an
+// annotation on the exchange.
+type Message struct {
+	ID       int64     `json:"id"`
+	Hook     string    `json:"hook,omitempty"`
+	Request  *Request  `json:"request,omitempty"`
+	Response *Response `json:"response,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	Tags     []string  `json:"tags,omitempty"`
+	Note     string    `json:"note,omitempty"`
+}
+
+// Plugin is a running plugin process.
+type Plugin struct {
+	Hello
+	Path string
+
+	cmd     *exec.Cmd
+	timeout time.Duration
+
+	wmu sync.Mutex
+	w   io.WriteCloser
+
+	mu      sync.Mutex
+	pending map[int64]chan Message
+	err     error
+	next    atomic.Int64
+	done    chan struct{}
+}
+
+// Load starts every executable in dir, in name order. Plugins must answer
+// each call within timeout.
+func Load(dir string, timeout time.Duration) ([]*Plugin, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var out []*Plugin
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil || !info.Mode().IsRegular() || info.Mode()&0o111 == 0 {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		p, err := Start(exec.Command(path), timeout)
+		if err != nil {
+			for _, p := range out {
+				p.Close()
+			}
+			return nil, fmt.Errorf("plugin %s: %w", e.Name(), err)
+		}
+		out = append(out, p)
+	}
+	return out, nil
+}
+
+// Start runs cmd as a plugin and waits for its Hello.
+func Start(cmd *exec.Cmd, timeout time.Duration) (*Plugin, error) {
+	w, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	r, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if cmd.Stderr == nil {
+		cmd.Stderr = os.Stderr
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	p := &Plugin{
+		Path:    cmd.Path,
+		cmd:     cmd,
+		timeout: timeout,
+		w:       w,
+		pending: map[int64]chan Message{},
+		done:    make(chan struct{}),
+	}
+
+	br := bufio.NewReaderSize(r, 64<<10)
+	hello := make(chan error, 1)
+	go func() {
+		line, err := br.ReadBytes('\n')
+		if err == nil {
+			err = json.Unmarshal(line, &p.Hello)
+		}
+		hello <- err
+	}()
+	select {
+	case err = <-hello:
+	case <-time.After(max(timeout, 5*time.Second)):
+		err = errors.New("no hello")
+	}
+	if err == nil {
+		err = p.Hello.check()
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil, err
+	}
+	go p.read(br)
+	return p, nil
+}
+
+func (h Hello) check() error {
+	if h.Name == "" {
+		return errors.New("hello has no name")
+	}
+	if h.Version != Version {
+		return fmt.Errorf("protocol version %d, want %d", h.Version, Version)
+	}
+	for _, hook := range h.Hooks {
+		if hook != HookRequest && hook != HookResponse {
+			return fmt.Errorf("unknown hook %q", hook)
+		}
+	}
+	return nil
+}
+
+// Implements reports whether the plugin has the hook.
+func (p *Plugin) Implements(hook string) bool {
+	return slices.Contains(p.Hooks, hook)
+}
+
+// read delivers replies to their callers until the plugin exits.
+func (p *Plugin) read(br *bufio.Reader) {
+	var err error
+	for {
+		var line []byte
+		if line, err = br.ReadBytes('\n'); err != nil {
+			break
+		}
+		var m Message
+		if err = json.Unmarshal(line, &m); err != nil {
+			err = fmt.Errorf("bad reply: %w", err)
+			break
+		}
+		p.mu.Lock()
+		ch, ok := p.pending[m.ID]
+		delete(p.pending, m.ID)
+		p.mu.Unlock()
+		if ok {
+			ch <- m
+		}
+	}
+	if errors.Is(err, io.EOF) {
+		err = errors.New("plugin exited")
+	}
+	p.mu.Lock()
+	p.err = err
+	clear(p.pending)
+	p.mu.Unlock()
+	close(p.done)
+	p.cmd.Process.Kill()
+	p.cmd.Wait()
+}
+
+// Call sends an exchange to the plugin's hook and returns its reply.
+func (p *Plugin) Call(hook string, req *Request, res *Response) (Message, error) {
+	m := Message{ID: p.next.Add(1), Hook: hook, Request: req, Response: res}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return Message{}, err
+	}
+	ch := make(chan Message, 1)
+	p.mu.Lock()
+	if p.err != nil {
+		err := p.err
+		p.mu.Unlock()
+		return Message{}, err
+	}
+	p.pending[m.ID] = ch
+	p.mu.Unlock()
+	forget := func() {
+		p.mu.Lock()
+		delete(p.pending, m.ID)
+		p.mu.Unlock()
+	}
+
+	p.wmu.Lock()
+	_, err = p.w.Write(append(b, '\n'))
+	p.wmu.Unlock()
+	if err != nil {
+		forget()
+		return Message{}, err
+	}
+
+	var timeout <-chan time.Time
+	if p.timeout > 0 {
+		t := time.NewTimer(p.timeout)
+		defer t.Stop()
+		timeout = t.C
+	}
+	select {
+	case reply := <-ch:
+		if reply.Error != "" {
+			return reply, errors.New(reply.Error)
+		}
+		return reply, nil
+	case <-p.done:
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		return Message{}, p.err
+	case <-timeout:
+		forget()
+		return Message{}, fmt.Errorf("no reply within %s", p.timeout)
+	}
+}
+
+// Close ends the plugin, giving it a moment to exit once its input closes.
+func (p *Plugin) Close() error {
+	p.wmu.Lock()
+	p.w.Close()
+	p.wmu.Unlock()
+	select {
+	case <-p.done:
+	case <-time.After(2 * time.Second):
+		p.cmd.Process.Kill()
+		<-p.done
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package plugin
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestHelperPlugin is the plugin the other tests run, by running the test
+// binary again.
+func TestHelperPlugin(t *testing.T) {
+	if os.Getenv("ROGUE_TEST_PLUGIN") == "" {
+		t.Skip("only run as a plugin")
+	}
+	Serve(Handler{
+		Name: "upper",
+		Request: func(req *Request) (*Request, *Response, error) {
+			switch req.URL {
+			case "http://blocked/":
+				return nil, &Response{Status: 403, Body: []byte("blocked by plugin")}, nil
+			case "http://fail/":
+				return nil, nil, errors.New("cannot handle this")
+			case "http://slow/":
+				time.Sleep(time.Second)
+			}
+			req.Headers.Set("X-Plugin", "upper")
+			req.Body = []byte(strings.ToUpper(string(req.Body)))
+			return req, nil, nil
+		},
+	})
+	os.Exit(0)
+}
+
+func startHelper(t *testing.T, timeout time.Duration) *Plugin {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], "-test.run=^TestHelperPlugin$")
+	cmd.Env = append(os.Environ(), "ROGUE_TEST_PLUGIN=1")
+	p, err := Start(cmd, timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { p.Close() })
+	return p
+}
+
+func TestPlugin(t *testing.T) {
+	p := startHelper(t, 200*time.Millisecond)
+	if p.Name != "upper" || !p.Implements(HookRequest) || p.Implements(HookResponse) {
+		t.Fatalf("Hello %+v", p.Hello)
+	}
+
+	reply, err := p.Call(HookRequest, &Request{Method: "POST", URL: "http://example/", Headers: map[string][]string{}, Body: []byte("hi")}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Request == nil || string(reply.Request.Body) != "HI" || reply.Request.Headers.Get("X-Plugin") != "upper" {
+		t.Errorf("Reply %+v", reply.Request)
+	}
+
+	reply, err = p.Call(HookRequest, &Request{URL: "http://blocked/"}, nil)
+	if err != nil || reply.Response == nil || reply.Response.Status != 403 {
+		t.Errorf("Blocked: %+v, %v", reply.Response, err)
+	}
+
+	if _, err := p.Call(HookRequest, &Request{URL: "http://fail/"}, nil); err == nil || err.Error() != "cannot handle this" {
+		t.Errorf("Failing call: %v", err)
+	}
+	if _, err := p.Call(HookRequest, &Request{URL: "http://slow/"}, nil); err == nil || !strings.Contains(err.Error(), "no reply") {
+		t.Errorf("Slow call: %v", err)
+	}
+
+	// Calls after the plugin exits fail at once.
+	p.Close()
+	if _, err := p.Call(HookRequest, &Request{URL: "http://example/"}, nil); err == nil {
+		t.Error("Call after Close succeeded")
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(dir+"/README", []byte("not a plugin"), 0o644)
+	os.WriteFile(dir+"/bad", []byte("#!/bin/sh\necho '{\"name\":\"bad\",\"version\":99}'\n"), 0o755)
+	if _, err := Load(dir, time.Second); err == nil || !strings.Contains(err.Error(), "protocol version") {
+		t.Errorf("Load with a bad plugin: %v", err)
+	}
+	os.Remove(dir + "/bad")
+	ps, err := Load(dir, time.Second)
+	if err != nil || len(ps) != 0 {
+		t.Errorf("Load without plugins: %v, %v", ps, err)
+	}
+}
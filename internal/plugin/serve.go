@@ -0,0 +1,71 @@
+package plugin
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+)
+
+// Handler implements a plugin in Go. Either hook may be nil.
+type Handler struct {
+	Name  string
+	Hosts []string
+	// Request returns a changed request, a response to answer with instead
+	// of the origin, or neither.
+	Request func(req *Request) (*Request, *Response, error)
+	// Response returns a changed response or nil.
+	Response func(req *Request, res *Response) (*Response, error)
+}
+
+// Serve runs h as a plugin on standard input and output, answering calls
+// concurrently until rogue closes its input.
+func Serve(h Handler) error {
+	return serve(h, os.Stdin, os.Stdout)
+}
+
+func serve(h Handler, r io.Reader, w io.Writer) error {
+	hello := Hello{Name: h.Name, Version: Version, Hosts: h.Hosts}
+	if h.Request != nil {
+		hello.Hooks = append(hello.Hooks, HookRequest)
+	}
+	if h.Response != nil {
+		hello.Hooks = append(hello.Hooks, HookResponse)
+	}
+	var mu sync.Mutex
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(hello); err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 64<<20)
+	for sc.Scan() {
+		var m Message
+		if err := json.Unmarshal(sc.Bytes(), &m); err != nil {
+			return err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			reply := Message{ID: m.ID}
+			var err error
+			switch {
+			case m.Hook == HookRequest && h.Request != nil:
+				reply.Request, reply.Response, err = h.Request(m.Request)
+			case m.Hook == HookResponse && h.Response != nil:
+				reply.Response, err = h.Response(m.Request, m.Response)
+			}
+			if err != nil {
+				reply = Message{ID: m.ID, Error: err.Error()}
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			enc.Encode(reply)
+		}()
+	}
+	return sc.Err()
+}
@@ -0,0 +1,85 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/standrze/rogue/internal/bench"
+	"github.com/standrze/rogue/internal/rules"
+)
+
+// BenchmarkProxy measures exchanges through the whole modifier pipeline.
+func BenchmarkProxy(b *testing.B) {
+	matchAll := rules.NewSet(rules.Rule{Name: "tag", Match: rules.Match{Path: "/"}, Tags: []string{"bench"}})
+	for _, bc := range []struct {
+		name   string
+		secure bool
+		size   int
+		opts   []ProxyOption
+	}{
+		{"plain/1KiB", false, 1 << 10, nil},
+		{"plain/1MiB", false, 1 << 20, nil},
+		{"unlogged/1KiB", false, 1 << 10, []ProxyOption{WithLogging(false, false, false, false, 0)}},
+		{"rules/1KiB", false, 1 << 10, []ProxyOption{WithRules(matchAll)}},
+		{"tls/1KiB", true, 1 << 10, nil},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			client, target := benchProxy(b, bc.secure, bc.opts...)
+			u := fmt.Sprintf("%s/?size=%d", target, bc.size)
+			b.SetBytes(int64(bc.size))
+			b.ReportAllocs()
+			for b.Loop() {
+				resp, err := client.Get(u)
+				if err != nil {
+					b.Fatal(err)
+				}
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+			}
+		})
+	}
+}
+
+// benchProxy starts an origin and a proxy with opts in front of it,
+// returning a client of the proxy and the origin's URL.
+func benchProxy(b *testing.B, secure bool, opts ...ProxyOption) (*http.Client, string) {
+	origin := bench.NewOrigin(secure)
+	b.Cleanup(origin.Close)
+
+	tmpDir := b.TempDir()
+	certPath := filepath.Join(tmpDir, "ca.crt")
+	p, sl := NewProxyServer(append([]ProxyOption{
+		WithCert(certPath, filepath.Join(tmpDir, "ca.key")),
+		WithSessionDir(filepath.Join(tmpDir, "logs")),
+		WithTLSPolicy(TLSPolicy{SkipVerifyHosts: []string{"127.0.0.1"}}),
+	}, opts...)...)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	go p.Serve(l)
+	b.Cleanup(func() {
+		p.Close()
+		sl.Close()
+	})
+
+	data, err := os.ReadFile(certPath)
+	if err != nil {
+		b.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(data)
+	transport := &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}
+	proxyURL, _ := url.Parse("http://" + l.Addr().String())
+	transport.Proxy = http.ProxyURL(proxyURL)
+	b.Cleanup(transport.CloseIdleConnections)
+	return &http.Client{Transport: transport}, origin.URL
+}
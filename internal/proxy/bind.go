@@ -0,0 +1,103 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// Binding selects the local address upstream connections are made from,
+// for machines with several networks such as a VPN next to the LAN.
+type Binding struct {
+	// Hosts lists host glob patterns the binding applies to; empty matches
+	// every host.
+	Hosts []string
+	// Address is a local IP to connect from.
+	Address string
+	// Interface names a network interface whose addresses are connected
+	// from instead.
+	Interface string
+}
+
+// WithBindings binds upstream connections to local addresses. The first
+// binding whose Hosts match the origin applies; origins matching none use
+// the system's choice.
+func WithBindings(bindings ...Binding) ProxyOption {
+	return func(p *Proxy) {
+		p.Bindings = append(p.Bindings, bindings...)
+	}
+}
+
+// binding is a Binding with its addresses resolved: at most one IPv4 and
+// one IPv6 dialer.
+type binding struct {
+	hosts   []string
+	dialers []*net.Dialer
+}
+
+func resolveBindings(bindings []Binding, timeout time.Duration) ([]binding, error) {
+	var out []binding
+	for _, b := range bindings {
+		ips, err := b.ips()
+		if err != nil {
+			return nil, err
+		}
+		rb := binding{hosts: b.Hosts}
+		var have4, have6 bool
+		for _, ip := range ips {
+			v4 := ip.To4() != nil
+			if (v4 && have4) || (!v4 && have6) {
+				continue
+			}
+			have4, have6 = have4 || v4, have6 || !v4
+			rb.dialers = append(rb.dialers, &net.Dialer{
+				Timeout:   timeout,
+				KeepAlive: 30 * time.Second,
+				LocalAddr: &net.TCPAddr{IP: ip},
+			})
+		}
+		out = append(out, rb)
+	}
+	return out, nil
+}
+
+// ips returns the addresses b connects from, IPv4 first.
+func (b Binding) ips() ([]net.IP, error) {
+	switch {
+	case b.Address != "" && b.Interface != "":
+		return nil, fmt.Errorf("binding: set address or interface, not both")
+	case b.Address != "":
+		ip := net.ParseIP(b.Address)
+		if ip == nil {
+			return nil, fmt.Errorf("binding: invalid address %q", b.Address)
+		}
+		return []net.IP{ip}, nil
+	case b.Interface == "":
+		return nil, fmt.Errorf("binding: an address or interface is required")
+	}
+
+	iface, err := net.InterfaceByName(b.Interface)
+	if err != nil {
+		return nil, fmt.Errorf("binding: %w", err)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("binding: %s: %w", b.Interface, err)
+	}
+	var v4, v6 []net.IP
+	for _, a := range addrs {
+		ipnet, ok := a.(*net.IPNet)
+		if !ok || ipnet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		if ipnet.IP.To4() != nil {
+			v4 = append(v4, ipnet.IP)
+		} else {
+			v6 = append(v6, ipnet.IP)
+		}
+	}
+	if len(v4)+len(v6) == 0 {
+		return nil, fmt.Errorf("binding: %s has no usable address", b.Interface)
+	}
+	return append(v4, v6...), nil
+}
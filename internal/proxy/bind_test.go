@@ -0,0 +1,67 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestBindings(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	peers := make(chan string, 2)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			host, _, _ := net.SplitHostPort(c.RemoteAddr().String())
+			peers <- host
+			c.Close()
+		}
+	}()
+
+	bindings, err := resolveBindings([]Binding{
+		{Hosts: []string{"*.example.com"}, Address: "127.0.0.3"},
+		{Hosts: []string{"127.0.0.1"}, Address: "127.0.0.2"},
+	}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := &upstream{dialer: &net.Dialer{}, bindings: bindings}
+
+	c, err := u.dial(context.Background(), "tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	if got := <-peers; got != "127.0.0.2" {
+		t.Errorf("connected from %s, want 127.0.0.2", got)
+	}
+
+	u.bindings = bindings[:1]
+	c, err = u.dial(context.Background(), "tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	if got := <-peers; got != "127.0.0.1" {
+		t.Errorf("unbound host connected from %s, want 127.0.0.1", got)
+	}
+
+	for _, b := range []Binding{
+		{},
+		{Address: "not-an-ip"},
+		{Address: "127.0.0.1", Interface: "lo"},
+		{Interface: "no-such-interface0"},
+	} {
+		if _, err := resolveBindings([]Binding{b}, 0); err == nil {
+			t.Errorf("%+v: expected an error", b)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"strings"
+	"sync"
+)
+
+// headBody keeps the first limit bytes read through it and hands them to
+// done at EOF or Close, whichever comes first, so a body can be examined
+// without holding it back.
+type headBody struct {
+	io.ReadCloser
+	limit int
+	head  []byte
+	once  sync.Once
+	done  func([]byte)
+}
+
+func (b *headBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if room := b.limit - len(b.head); room > 0 {
+		b.head = append(b.head, p[:min(n, room)]...)
+	}
+	if err == io.EOF {
+		b.finish()
+	}
+	return n, err
+}
+
+func (b *headBody) Close() error {
+	b.finish()
+	return b.ReadCloser.Close()
+}
+
+func (b *headBody) finish() {
+	b.once.Do(func() { b.done(b.head) })
+}
+
+// decodeHead undoes gzip or deflate on the start of a body, keeping what
+// decodes before the cut. Other encodings yield nothing to check.
+func decodeHead(encoding string, head []byte, limit int) []byte {
+	var r io.Reader
+	var err error
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "", "identity":
+		return head
+	case "gzip", "x-gzip":
+		r, err = gzip.NewReader(bytes.NewReader(head))
+	case "deflate":
+		r, err = zlib.NewReader(bytes.NewReader(head))
+	default:
+		return nil
+	}
+	if err != nil {
+		return nil
+	}
+	out, _ := io.ReadAll(io.LimitReader(r, int64(limit)))
+	return out
+}
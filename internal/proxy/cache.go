@@ -0,0 +1,139 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/martian/v3"
+	"github.com/standrze/rogue/internal/budget"
+	"github.com/standrze/rogue/internal/cache"
+	"github.com/standrze/rogue/internal/metrics"
+	"github.com/standrze/rogue/internal/rules"
+)
+
+const (
+	cacheHitKey   = "rogue.cache.hit"
+	cacheStoreKey = "rogue.cache.store"
+)
+
+// cacheHeader tells clients whether a response came from the cache.
+const cacheHeader = "X-Rogue-Cache"
+
+// CacheModifier answers GET requests from a response cache and stores what
+// the origin returns. Its request side runs after the rules, so mocks and
+// blocks win, and its response side runs first, so it stores the response
+// as the origin sent it and hits pass through the other modifiers like
+// fresh responses. Offline, requests the cache cannot answer get a 504.
+type CacheModifier struct {
+	Cache *cache.Cache
+	// Budget, if set, bounds the memory of bodies read to be stored.
+	Budget *budget.Budget
+}
+
+func (m *CacheModifier) ModifyRequest(req *http.Request) error {
+	if req.Method == http.MethodConnect {
+		return nil
+	}
+	ctx := martian.NewContext(req)
+	if ctx == nil || ctx.SkippingRoundTrip() {
+		return nil
+	}
+	if rule, ok := matchedRule(req); ok && rule.Fault != nil && rule.Fault.Status != 0 {
+		return nil
+	}
+
+	if e, ok := m.Cache.Get(req); ok {
+		ctx.Set(cacheHitKey, e)
+		ctx.SkipRoundTrip()
+		return nil
+	}
+	if m.Cache.Offline() {
+		ctx.Set(cacheHitKey, (*cache.Entry)(nil))
+		ctx.SkipRoundTrip()
+		return nil
+	}
+	if req.Method == http.MethodGet {
+		// The request is stored under the URL the client asked for, before
+		// map_remote can change it.
+		ctx.Set(cacheStoreKey, req.Clone(req.Context()))
+	}
+	return nil
+}
+
+func (m *CacheModifier) ModifyResponse(res *http.Response) error {
+	if res.Request == nil {
+		return nil
+	}
+	ctx := martian.NewContext(res.Request)
+	if ctx == nil {
+		return nil
+	}
+
+	if v, ok := ctx.Get(cacheHitKey); ok {
+		e := v.(*cache.Entry)
+		if e == nil {
+			applyMock(res, &rules.Mock{
+				Status:  http.StatusGatewayTimeout,
+				Headers: map[string]string{"Content-Type": "text/plain; charset=utf-8", cacheHeader: "MISS"},
+				Body:    cache.ErrOffline.Error() + "\n",
+			})
+			return nil
+		}
+		serveEntry(res, e)
+		return nil
+	}
+
+	v, ok := ctx.Get(cacheStoreKey)
+	if !ok || !m.Cache.Storable(v.(*http.Request), res) || res.Body == nil {
+		return nil
+	}
+	body, rest, ok, err := m.Budget.Buffer(res.Body, m.Cache.MaxBodySize())
+	if err != nil {
+		return err
+	}
+	res.Body = rest
+	if !ok {
+		return nil
+	}
+	err = m.Cache.Put(v.(*http.Request), res.StatusCode, res.Header, body)
+	res.Header.Set(cacheHeader, "MISS")
+	return err
+}
+
+// serveEntry replaces the placeholder response martian made for a skipped
+// round trip with e.
+func serveEntry(res *http.Response, e *cache.Entry) {
+	res.StatusCode = e.Status
+	res.Status = strconv.Itoa(e.Status) + " " + http.StatusText(e.Status)
+	res.Header = e.Header.Clone()
+	res.Header.Set("Age", strconv.Itoa(int(e.Age(time.Now()).Seconds())))
+	res.Header.Set(cacheHeader, "HIT")
+	body := e.Body
+	if res.Request.Method == http.MethodHead {
+		body = nil
+	}
+	if res.Body != nil {
+		res.Body.Close()
+	}
+	res.Body = io.NopCloser(bytes.NewReader(body))
+	res.ContentLength = int64(len(e.Body))
+	res.Header.Set("Content-Length", strconv.Itoa(len(e.Body)))
+	res.TransferEncoding = nil
+}
+
+func registerCacheMetrics(reg *metrics.Registry, c *cache.Cache) {
+	stat := func(f func(cache.Stats) float64) func() float64 {
+		return func() float64 { return f(c.Stats()) }
+	}
+	reg.Register("rogue_cache_hits_total", "Requests answered from the response cache.", metrics.Counter,
+		stat(func(s cache.Stats) float64 { return float64(s.Hits) }))
+	reg.Register("rogue_cache_misses_total", "Requests the response cache could not answer.", metrics.Counter,
+		stat(func(s cache.Stats) float64 { return float64(s.Misses) }))
+	reg.Register("rogue_cache_stored_total", "Responses stored in the response cache.", metrics.Counter,
+		stat(func(s cache.Stats) float64 { return float64(s.Stored) }))
+	reg.Register("rogue_cache_entries", "Responses held in memory by the response cache.", metrics.Gauge,
+		stat(func(s cache.Stats) float64 { return float64(s.Entries) }))
+}
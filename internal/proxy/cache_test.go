@@ -0,0 +1,105 @@
+package proxy
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/standrze/rogue/internal/cache"
+	"github.com/standrze/rogue/internal/rules"
+)
+
+func TestCacheModifier(t *testing.T) {
+	var hits atomic.Int32
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		io.WriteString(w, "origin "+r.URL.Path)
+	}))
+	defer origin.Close()
+
+	c, err := cache.New(cache.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpDir := t.TempDir()
+	set := rules.NewSet(rules.Rule{Name: "mock", Match: rules.Match{Path: "/mocked"}, Mock: &rules.Mock{Body: "mocked"}})
+	p, sl := NewProxyServer(
+		WithCert(filepath.Join(tmpDir, "ca.crt"), filepath.Join(tmpDir, "ca.key")),
+		WithSessionDir(filepath.Join(tmpDir, "logs")),
+		WithRules(set),
+		WithCache(c),
+	)
+	defer sl.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go p.Serve(l)
+	defer p.Close()
+
+	proxyURL, _ := url.Parse("http://" + l.Addr().String())
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+	get := func(path string) (string, string) {
+		t.Helper()
+		res, err := client.Get(origin.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, _ := io.ReadAll(res.Body)
+		return string(body), res.Header.Get(cacheHeader)
+	}
+
+	if body, state := get("/page"); body != "origin /page" || state != "MISS" {
+		t.Errorf("First request: %q %s", body, state)
+	}
+	if body, state := get("/page"); body != "origin /page" || state != "HIT" {
+		t.Errorf("Second request: %q %s", body, state)
+	}
+	if n := hits.Load(); n != 1 {
+		t.Errorf("Origin saw %d requests, want 1", n)
+	}
+	if body, _ := get("/mocked"); body != "mocked" {
+		t.Errorf("Mock rule lost to the cache: %q", body)
+	}
+}
+
+func TestCacheModifierOffline(t *testing.T) {
+	c, _ := cache.New(cache.Options{Offline: true})
+	req := httptest.NewRequest("GET", "http://offline.test/known", nil)
+	c.Put(req, http.StatusOK, http.Header{"Content-Type": {"text/plain"}}, []byte("cached"))
+
+	tmpDir := t.TempDir()
+	p, sl := NewProxyServer(
+		WithCert(filepath.Join(tmpDir, "ca.crt"), filepath.Join(tmpDir, "ca.key")),
+		WithSessionDir(filepath.Join(tmpDir, "logs")),
+		WithCache(c),
+	)
+	defer sl.Close()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go p.Serve(l)
+	defer p.Close()
+
+	proxyURL, _ := url.Parse("http://" + l.Addr().String())
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+	for path, want := range map[string]int{"/known": http.StatusOK, "/unknown": http.StatusGatewayTimeout} {
+		res, err := client.Get("http://offline.test" + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != want {
+			t.Errorf("%s: got %d, want %d", path, res.StatusCode, want)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/standrze/rogue/internal/ntlm"
+)
+
+// Upstream proxy authentication schemes.
+const (
+	ProxyAuthBasic     = "basic"
+	ProxyAuthNTLM      = "ntlm"
+	ProxyAuthNegotiate = "negotiate"
+)
+
+// UpstreamProxy chains origin connections through another proxy, such as a
+// corporate one. Every origin connection is a CONNECT tunnel, so the proxy
+// must allow CONNECT to the ports of plain HTTP origins too, and origin
+// names are resolved by the proxy.
+type UpstreamProxy struct {
+	// Address is the proxy's host:port.
+	Address string
+	// Auth is empty, ProxyAuthBasic, ProxyAuthNTLM or ProxyAuthNegotiate,
+	// which sends a Kerberos ticket for the proxy.
+	Auth string
+	// Username may be given as "DOMAIN\user" for NTLM and "user@REALM"
+	// for Negotiate.
+	Username, Password string
+	// Kerberos locates the credentials for Negotiate.
+	Kerberos Kerberos
+	// NoProxy lists host glob patterns of origins connected to directly.
+	NoProxy []string
+}
+
+// WithUpstreamProxy sends origin connections through another proxy. It
+// replaces the HTTP_PROXY environment variables.
+func WithUpstreamProxy(c UpstreamProxy) ProxyOption {
+	return func(p *Proxy) {
+		p.UpstreamProxy = c
+	}
+}
+
+func (c UpstreamProxy) validate() error {
+	if _, _, err := net.SplitHostPort(c.Address); err != nil {
+		return fmt.Errorf("upstream proxy address %q: %w", c.Address, err)
+	}
+	switch c.Auth {
+	case "":
+	case ProxyAuthBasic, ProxyAuthNTLM:
+		if c.Username == "" {
+			return fmt.Errorf("%s authentication needs a username", c.Auth)
+		}
+	case ProxyAuthNegotiate:
+		if c.Username == "" && (c.Kerberos.Keytab != "" || c.Password != "") {
+			return fmt.Errorf("negotiate authentication with a keytab or password needs a username")
+		}
+	default:
+		return fmt.Errorf("unknown upstream proxy authentication %q (want basic, ntlm or negotiate)", c.Auth)
+	}
+	return nil
+}
+
+// tunnel opens a CONNECT tunnel to addr through the upstream proxy,
+// authenticating on the same connection.
+func (u *upstream) tunnel(ctx context.Context, network, addr string) (net.Conn, error) {
+	c := u.chain
+	conn, err := u.dialDirect(ctx, network, c.Address)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	} else if u.dialer.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(u.dialer.Timeout))
+	}
+	br := bufio.NewReader(conn)
+
+	fail := func(err error) (net.Conn, error) {
+		conn.Close()
+		return nil, fmt.Errorf("upstream proxy %s: %w", c.Address, err)
+	}
+
+	var auth string
+	switch c.Auth {
+	case ProxyAuthBasic:
+		auth = "Basic " + base64.StdEncoding.EncodeToString([]byte(c.Username+":"+c.Password))
+	case ProxyAuthNTLM:
+		auth = "NTLM " + base64.StdEncoding.EncodeToString(ntlm.Negotiate())
+	case ProxyAuthNegotiate:
+		token, err := u.negotiate(c.spn())
+		if err != nil {
+			return fail(fmt.Errorf("kerberos ticket for %s: %w", c.spn(), err))
+		}
+		auth = "Negotiate " + base64.StdEncoding.EncodeToString(token)
+	}
+	res, err := connectVia(conn, br, addr, auth)
+	if err != nil {
+		return fail(err)
+	}
+
+	if c.Auth == ProxyAuthNTLM && res.StatusCode == http.StatusProxyAuthRequired {
+		challenge, ok := authChallenge(res.Header, "NTLM")
+		io.Copy(io.Discard, res.Body)
+		res.Body.Close()
+		if !ok {
+			return fail(fmt.Errorf("no NTLM challenge in %q", res.Header.Values("Proxy-Authenticate")))
+		}
+		if res.Close {
+			return fail(fmt.Errorf("connection closed during NTLM authentication"))
+		}
+		ch, err := ntlm.ParseChallenge(challenge)
+		if err != nil {
+			return fail(err)
+		}
+		domain, user := ntlm.Split(c.Username)
+		msg, err := ntlm.Authenticate(ch, ntlm.Credentials{Domain: domain, User: user, Password: c.Password})
+		if err != nil {
+			return fail(err)
+		}
+		if res, err = connectVia(conn, br, addr, "NTLM "+base64.StdEncoding.EncodeToString(msg)); err != nil {
+			return fail(err)
+		}
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fail(fmt.Errorf("CONNECT %s refused: %s", addr, res.Status))
+	}
+	conn.SetDeadline(time.Time{})
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+// connectVia asks the proxy on conn for a tunnel to addr.
+func connectVia(conn net.Conn, br *bufio.Reader, addr, auth string) (*http.Response, error) {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: http.Header{},
+	}
+	if auth != "" {
+		req.Header.Set("Proxy-Authorization", auth)
+	}
+	req.Header.Set("Proxy-Connection", "Keep-Alive")
+	if err := req.Write(conn); err != nil {
+		return nil, err
+	}
+	return http.ReadResponse(br, req)
+}
+
+// authChallenge returns the token of the scheme's Proxy-Authenticate
+// challenge.
+func authChallenge(h http.Header, scheme string) ([]byte, bool) {
+	for _, v := range h.Values("Proxy-Authenticate") {
+		name, token, _ := strings.Cut(strings.TrimSpace(v), " ")
+		if strings.EqualFold(name, scheme) && token != "" {
+			b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(token))
+			return b, err == nil
+		}
+	}
+	return nil, false
+}
+
+// bufferedConn reads what the proxy sent after its response before the
+// rest of the connection.
+type bufferedConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) { return c.r.Read(p) }
@@ -0,0 +1,289 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf16"
+
+	"github.com/jcmturner/gokrb5/v8/client"
+	krbconfig "github.com/jcmturner/gokrb5/v8/config"
+	"github.com/jcmturner/gokrb5/v8/credentials"
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/iana/nametype"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+	"github.com/jcmturner/gokrb5/v8/types"
+)
+
+// ntlmProxy is a CONNECT proxy demanding NTLM, as corporate proxies do. It
+// records the user of each authenticate message it accepts.
+func ntlmProxy(t *testing.T, users chan<- string) net.Listener {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	challenge := make([]byte, 48)
+	copy(challenge, "NTLMSSP\x00")
+	binary.LittleEndian.PutUint32(challenge[8:], 2)
+	copy(challenge[24:], "nonce123")
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				br := bufio.NewReader(conn)
+				for {
+					req, err := http.ReadRequest(br)
+					if err != nil {
+						return
+					}
+					scheme, token, _ := strings.Cut(req.Header.Get("Proxy-Authorization"), " ")
+					msg, _ := base64.StdEncoding.DecodeString(token)
+					if scheme != "NTLM" || len(msg) < 12 {
+						io.WriteString(conn, "HTTP/1.1 407 Proxy Authentication Required\r\nProxy-Authenticate: NTLM\r\nContent-Length: 0\r\nConnection: close\r\n\r\n")
+						return
+					}
+					switch binary.LittleEndian.Uint32(msg[8:]) {
+					case 1:
+						io.WriteString(conn, "HTTP/1.1 407 Proxy Authentication Required\r\nProxy-Authenticate: NTLM "+
+							base64.StdEncoding.EncodeToString(challenge)+"\r\nContent-Length: 6\r\n\r\ndenied")
+					case 3:
+						users <- ntlmField(msg, 28) + `\` + ntlmField(msg, 36)
+						origin, err := net.Dial("tcp", req.Host)
+						if err != nil {
+							return
+						}
+						defer origin.Close()
+						io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+						go io.Copy(origin, br)
+						io.Copy(conn, origin)
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return ln
+}
+
+func ntlmField(msg []byte, off int) string {
+	n := int(binary.LittleEndian.Uint16(msg[off:]))
+	start := int(binary.LittleEndian.Uint32(msg[off+4:]))
+	u := make([]uint16, n/2)
+	for i := range u {
+		u[i] = binary.LittleEndian.Uint16(msg[start+2*i:])
+	}
+	return string(utf16.Decode(u))
+}
+
+func TestUpstreamProxyNTLM(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "through the tunnel")
+	}))
+	defer origin.Close()
+	users := make(chan string, 1)
+	ln := ntlmProxy(t, users)
+
+	u := &upstream{dialer: &net.Dialer{}, chain: &UpstreamProxy{
+		Address:  ln.Addr().String(),
+		Auth:     ProxyAuthNTLM,
+		Username: `CORP\alice`,
+		Password: "secret",
+	}}
+	tr := &http.Transport{DialContext: u.dial}
+	defer tr.CloseIdleConnections()
+	res, err := (&http.Client{Transport: tr}).Get(origin.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if string(body) != "through the tunnel" {
+		t.Errorf("Got %q", body)
+	}
+	if got := <-users; got != `CORP\alice` {
+		t.Errorf("Proxy authenticated %q, want CORP\\alice", got)
+	}
+
+	// Without credentials the proxy's refusal is reported.
+	u.chain = &UpstreamProxy{Address: ln.Addr().String()}
+	if _, err := u.dial(context.Background(), "tcp", origin.Listener.Addr().String()); err == nil || !strings.Contains(err.Error(), "407") {
+		t.Errorf("Unauthenticated dial: %v, want a 407 error", err)
+	}
+
+	// Hosts in NoProxy are dialed directly.
+	u.chain.NoProxy = []string{"127.0.0.1"}
+	conn, err := u.dial(context.Background(), "tcp", origin.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Direct dial: %v", err)
+	}
+	conn.Close()
+}
+
+// kerberosProxy is a CONNECT proxy demanding Negotiate with a Kerberos
+// ticket for a service whose keys are in kt. It records the client
+// principal of each ticket it accepts.
+func kerberosProxy(t *testing.T, kt *keytab.Keytab, users chan<- string) net.Listener {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	svc := spnego.SPNEGOService(kt)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				br := bufio.NewReader(conn)
+				req, err := http.ReadRequest(br)
+				if err != nil {
+					return
+				}
+				scheme, token, _ := strings.Cut(req.Header.Get("Proxy-Authorization"), " ")
+				b, _ := base64.StdEncoding.DecodeString(token)
+				var st spnego.SPNEGOToken
+				if scheme != "Negotiate" || st.Unmarshal(b) != nil {
+					io.WriteString(conn, "HTTP/1.1 407 Proxy Authentication Required\r\nProxy-Authenticate: Negotiate\r\nContent-Length: 0\r\nConnection: close\r\n\r\n")
+					return
+				}
+				ok, ctx, status := svc.AcceptSecContext(&st)
+				if !ok {
+					t.Logf("ticket refused: %v", status)
+					io.WriteString(conn, "HTTP/1.1 407 Proxy Authentication Required\r\nProxy-Authenticate: Negotiate\r\nContent-Length: 0\r\nConnection: close\r\n\r\n")
+					return
+				}
+				id := ctx.Value("github.com/jcmturner/gokrb5/v8/ctxCredentials").(*credentials.Credentials)
+				users <- id.UserName() + "@" + id.Realm()
+				origin, err := net.Dial("tcp", req.Host)
+				if err != nil {
+					return
+				}
+				defer origin.Close()
+				io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+				go io.Copy(origin, br)
+				io.Copy(conn, origin)
+			}()
+		}
+	}()
+	return ln
+}
+
+func TestUpstreamProxyNegotiate(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "through the tunnel")
+	}))
+	defer origin.Close()
+
+	const realm = "CORP.TEST"
+	kt := keytab.New()
+	if err := kt.AddEntry("HTTP/proxy.corp.test", realm, "service secret", time.Now(), 1, etypeID.AES256_CTS_HMAC_SHA1_96); err != nil {
+		t.Fatal(err)
+	}
+	users := make(chan string, 1)
+	ln := kerberosProxy(t, kt, users)
+
+	// The KDC is stood in for by issuing service tickets directly, from
+	// the keys of every service in the realm.
+	kdc := keytab.New()
+	kdc.AddEntry("HTTP/proxy.corp.test", realm, "service secret", time.Now(), 1, etypeID.AES256_CTS_HMAC_SHA1_96)
+	kdc.AddEntry("HTTP/other.corp.test", realm, "other secret", time.Now(), 1, etypeID.AES256_CTS_HMAC_SHA1_96)
+	cl := client.NewWithPassword("alice", realm, "secret", krbconfig.New())
+	var spns []string
+	u := &upstream{dialer: &net.Dialer{}, chain: &UpstreamProxy{
+		Address:  ln.Addr().String(),
+		Auth:     ProxyAuthNegotiate,
+		Kerberos: Kerberos{SPN: "HTTP/proxy.corp.test"},
+	}}
+	u.negotiate = func(spn string) ([]byte, error) {
+		spns = append(spns, spn)
+		now := time.Now()
+		tkt, key, err := messages.NewTicket(cl.Credentials.CName(), realm,
+			types.NewPrincipalName(nametype.KRB_NT_PRINCIPAL, spn), realm, types.NewKrbFlags(), kdc,
+			etypeID.AES256_CTS_HMAC_SHA1_96, 1, now, now, now.Add(time.Hour), now.Add(time.Hour))
+		if err != nil {
+			return nil, err
+		}
+		return negotiateToken(cl, tkt, key)
+	}
+
+	conn, err := u.dial(context.Background(), "tcp", origin.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if got := <-users; got != "alice@"+realm {
+		t.Errorf("Proxy authenticated %q, want alice@%s", got, realm)
+	}
+	if len(spns) != 1 || spns[0] != "HTTP/proxy.corp.test" {
+		t.Errorf("Tickets requested for %v", spns)
+	}
+
+	// A ticket for another service is refused.
+	u.chain.Kerberos.SPN = "HTTP/other.corp.test"
+	if _, err := u.dial(context.Background(), "tcp", origin.Listener.Addr().String()); err == nil || !strings.Contains(err.Error(), "407") {
+		t.Errorf("Dial with the wrong ticket: %v, want a 407 error", err)
+	}
+}
+
+func TestKerberosClient(t *testing.T) {
+	dir := t.TempDir()
+	conf := filepath.Join(dir, "krb5.conf")
+	os.WriteFile(conf, []byte("[libdefaults]\n  default_realm = CORP.TEST\n[realms]\n  CORP.TEST = {\n    kdc = 127.0.0.1:88\n  }\n"), 0644)
+	kt := keytab.New()
+	kt.AddEntry("alice", "CORP.TEST", "secret", time.Now(), 1, etypeID.AES256_CTS_HMAC_SHA1_96)
+	ktPath := filepath.Join(dir, "alice.keytab")
+	b, _ := kt.Marshal()
+	os.WriteFile(ktPath, b, 0600)
+
+	for _, tc := range []struct {
+		name, want string
+		c          UpstreamProxy
+	}{
+		{"keytab in the default realm", "alice@CORP.TEST", UpstreamProxy{Username: "alice", Kerberos: Kerberos{Config: conf, Keytab: ktPath}}},
+		{"password", "bob@OTHER.TEST", UpstreamProxy{Username: "bob@OTHER.TEST", Password: "pw", Kerberos: Kerberos{Config: conf}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cl, err := tc.c.kerberosClient()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := cl.Credentials.UserName() + "@" + cl.Credentials.Realm(); got != tc.want {
+				t.Errorf("logs in as %s, want %s", got, tc.want)
+			}
+		})
+	}
+
+	// Without a keytab or password kinit's tickets are needed.
+	c := UpstreamProxy{Kerberos: Kerberos{Config: conf, CCache: filepath.Join(dir, "missing")}}
+	if _, err := c.kerberosClient(); err == nil || !strings.Contains(err.Error(), "credential cache") {
+		t.Errorf("missing ccache: %v", err)
+	}
+	if got := (UpstreamProxy{Address: "proxy.corp.test:8080"}).spn(); got != "HTTP/proxy.corp.test" {
+		t.Errorf("default SPN = %s", got)
+	}
+}
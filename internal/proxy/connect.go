@@ -0,0 +1,213 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/martian/v3"
+	"github.com/standrze/rogue/internal/logger"
+	"github.com/standrze/rogue/internal/mitm"
+	"github.com/standrze/rogue/internal/scope"
+)
+
+// connListener hands connections opened inside CONNECT tunnels back to the
+// proxy so they run through the same modifier pipeline as direct requests.
+type connListener struct {
+	addr   net.Addr
+	conns  chan net.Conn
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newConnListener(addr net.Addr) *connListener {
+	return &connListener{
+		addr:   addr,
+		conns:  make(chan net.Conn),
+		closed: make(chan struct{}),
+	}
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *connListener) Close() error {
+	l.once.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *connListener) Addr() net.Addr { return l.addr }
+
+// serve passes conn to the proxy and blocks until the proxy closes it.
+func (l *connListener) serve(conn net.Conn, done <-chan struct{}) error {
+	select {
+	case l.conns <- conn:
+	case <-l.closed:
+		return net.ErrClosed
+	}
+	<-done
+	return nil
+}
+
+// tunnelConn reads through the session's buffered reader, so bytes the
+// client sent right after CONNECT are not lost, and reports when it is
+// closed.
+type tunnelConn struct {
+	net.Conn
+	r    io.Reader
+	once sync.Once
+	done chan struct{}
+}
+
+func (c *tunnelConn) Read(p []byte) (int, error) { return c.r.Read(p) }
+func (c *tunnelConn) NetConn() net.Conn          { return c.Conn }
+
+func (c *tunnelConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(func() { close(c.done) })
+	return err
+}
+
+// MITMModifier terminates TLS inside CONNECT tunnels with certificates from
+// Config and replays the decrypted connection through the proxy. It blocks
+// for the life of the tunnel, so it must be the last request modifier.
+type MITMModifier struct {
+	Config *mitm.Config
+	// Passthrough lists host glob patterns whose tunnels are relayed
+	// without interception.
+	Passthrough []string
+	// Interception, if set, can relay further tunnels at runtime.
+	Interception *Interception
+	// Scope, if set, relays tunnels to hosts wholly out of scope.
+	Scope *scope.Scope
+	// DialTimeout bounds connecting to passthrough origins.
+	DialTimeout time.Duration
+	// Dial, if set, connects to passthrough origins instead of net.Dialer.
+	Dial func(ctx context.Context, network, addr string) (net.Conn, error)
+	// Hints, if set, diagnoses failed client handshakes.
+	Hints *troubleshooter
+	// Logger, if set, receives a tunnel entry for every tunnel relayed
+	// without interception, with hex dumps of its first TunnelSample bytes
+	// each way.
+	Logger       *logger.SessionLogger
+	TunnelSample int
+	listener     *connListener
+	tunnels      tunnelStats
+}
+
+func (m *MITMModifier) ModifyRequest(req *http.Request) error {
+	if req.Method != http.MethodConnect {
+		return nil
+	}
+
+	ctx := martian.NewContext(req)
+	if ctx == nil || ctx.Session().Hijacked() {
+		return nil
+	}
+
+	conn, brw, err := ctx.Session().Hijack()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if !m.Scope.ContainsHost(req.Host) {
+		// Out-of-scope traffic is not recorded, tunnels included.
+		return m.passthrough(req, conn, brw, "")
+	}
+	if matchHost(m.Passthrough, req.Host) || !m.Interception.intercepts(req.Host) {
+		return m.passthrough(req, conn, brw, "passthrough")
+	}
+
+	if _, err := brw.WriteString("HTTP/1.1 200 Connection established\r\n\r\n"); err != nil {
+		return err
+	}
+	if err := brw.Flush(); err != nil {
+		return err
+	}
+
+	tc := &tunnelConn{Conn: conn, r: brw.Reader, done: make(chan struct{})}
+	return m.intercept(req, tc, brw.Reader)
+}
+
+// passthrough relays the tunnel to the origin byte for byte. The client is
+// answered with 502 if the origin cannot be reached. Tunnels are logged
+// with reason unless it is empty.
+func (m *MITMModifier) passthrough(req *http.Request, conn net.Conn, brw *bufio.ReadWriter, reason string) error {
+	up, err := m.dialOrigin(req, reason)
+	if err != nil {
+		brw.WriteString("HTTP/1.1 502 Bad Gateway\r\n\r\n")
+		brw.Flush()
+		return err
+	}
+	defer up.Close()
+
+	if _, err := brw.WriteString("HTTP/1.1 200 Connection established\r\n\r\n"); err != nil {
+		return err
+	}
+	if err := brw.Flush(); err != nil {
+		return err
+	}
+	return m.relay(req, conn, brw.Reader, up, reason)
+}
+
+// dialOrigin connects to the target of a tunnel, logging the tunnel if
+// that fails.
+func (m *MITMModifier) dialOrigin(req *http.Request, reason string) (net.Conn, error) {
+	dial := m.Dial
+	if dial == nil {
+		dial = (&net.Dialer{Timeout: m.DialTimeout}).DialContext
+	}
+	start := time.Now()
+	up, err := dial(req.Context(), "tcp", req.Host)
+	if err != nil {
+		m.logTunnel(req, start, reason, func(t *logger.TunnelLog) { t.Error = err.Error() })
+	}
+	return up, err
+}
+
+func (m *MITMModifier) intercept(req *http.Request, tc *tunnelConn, r *bufio.Reader) error {
+	first, err := r.Peek(1)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		return err
+	}
+
+	// 22 is the TLS handshake record type; anything else is treated as
+	// plaintext HTTP inside the tunnel, or relayed as it is when it is
+	// not HTTP.
+	if first[0] != 22 {
+		if looksLikeHTTP(r) {
+			return m.listener.serve(tc, tc.done)
+		}
+		up, err := m.dialOrigin(req, "not_http")
+		if err != nil {
+			return err
+		}
+		defer up.Close()
+		return m.relay(req, tc, r, up, "not_http")
+	}
+
+	tlsConn := tls.Server(tc, m.Config.TLSForHost(req.Host))
+	if err := tlsConn.Handshake(); err != nil {
+		if m.Hints != nil {
+			m.Hints.report(req, err, true)
+		}
+		return err
+	}
+	return m.listener.serve(tlsConn, tc.done)
+}
@@ -0,0 +1,300 @@
+package proxy
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/standrze/rogue/internal/logger"
+	"github.com/standrze/rogue/internal/metrics"
+)
+
+func TestMITMWithTLSPolicy(t *testing.T) {
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "secret")
+	}))
+	defer upstream.Close()
+
+	tmpDir := t.TempDir()
+	certPath := filepath.Join(tmpDir, "ca.crt")
+	sessionDir := filepath.Join(tmpDir, "logs")
+	p, sl := NewProxyServer(
+		WithCert(certPath, filepath.Join(tmpDir, "ca.key")),
+		WithSessionDir(sessionDir),
+		WithTLSPolicy(TLSPolicy{
+			ClientMinVersion: tls.VersionTLS13,
+			SkipVerifyHosts:  []string{"127.0.0.1"},
+		}),
+	)
+	defer sl.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go p.Serve(l)
+
+	caPEM, err := os.ReadFile(certPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(caPEM)
+
+	proxyURL, _ := url.Parse("http://" + l.Addr().String())
+	client := func(maxVersion uint16) *http.Client {
+		return &http.Client{Transport: &http.Transport{
+			Proxy:           http.ProxyURL(proxyURL),
+			TLSClientConfig: &tls.Config{RootCAs: roots, MaxVersion: maxVersion},
+		}}
+	}
+
+	resp, err := client(0).Get(upstream.URL)
+	if err != nil {
+		t.Fatalf("Failed to make request: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != 200 || string(body) != "secret" {
+		t.Errorf("Expected 200 secret, got %d %q", resp.StatusCode, body)
+	}
+	if resp.TLS == nil || resp.TLS.PeerCertificates[0].Issuer.CommonName != "Rogue CA" {
+		t.Error("Expected connection to be intercepted with the rogue CA")
+	}
+
+	if _, err := client(tls.VersionTLS12).Get(upstream.URL); err == nil {
+		t.Error("Expected TLS 1.2 client to be rejected by the policy")
+	}
+
+	// The upstream handshake is reported even though verification was
+	// skipped for this host.
+	entries, err := logger.ReadSession(filepath.Join(sessionDir, sl.GetSessionName()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var conn *logger.TLSConnectionLog
+	for _, e := range entries {
+		if e.Type == "tls_connection" {
+			conn = new(logger.TLSConnectionLog)
+			if err := json.Unmarshal(e.Data, conn); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if conn == nil {
+		t.Fatal("Expected a tls_connection entry")
+	}
+	if conn.Verified || conn.Enforced || conn.VerifyError == "" {
+		t.Errorf("Expected an unenforced verification failure, got %+v", conn)
+	}
+	if len(conn.Chain) == 0 || conn.Chain[0].NotAfter.IsZero() {
+		t.Errorf("Expected the server certificate to be described, got %+v", conn.Chain)
+	}
+}
+
+func TestPassthrough(t *testing.T) {
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "direct")
+	}))
+	defer upstream.Close()
+
+	tmpDir := t.TempDir()
+	p, sl := NewProxyServer(
+		WithCert(filepath.Join(tmpDir, "ca.crt"), filepath.Join(tmpDir, "ca.key")),
+		WithSessionDir(filepath.Join(tmpDir, "logs")),
+		WithTLSPolicy(TLSPolicy{PassthroughHosts: []string{"127.0.0.*"}}),
+	)
+	defer sl.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go p.Serve(l)
+
+	// The client only trusts the origin, so the request succeeds only if the
+	// tunnel is not intercepted.
+	proxyURL, _ := url.Parse("http://" + l.Addr().String())
+	transport := upstream.Client().Transport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyURL(proxyURL)
+
+	resp, err := (&http.Client{Transport: transport}).Get(upstream.URL)
+	if err != nil {
+		t.Fatalf("Failed to make request: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != 200 || string(body) != "direct" {
+		t.Errorf("Expected 200 direct, got %d %q", resp.StatusCode, body)
+	}
+}
+
+func TestTunnelLogging(t *testing.T) {
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "direct")
+	}))
+	defer upstream.Close()
+	echo, err := net.Listen("tcp", "127.0.0.2:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		for {
+			c, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(c, c)
+				c.Close()
+			}()
+		}
+	}()
+
+	tmpDir := t.TempDir()
+	sessionDir := filepath.Join(tmpDir, "logs")
+	p, sl := NewProxyServer(
+		WithCert(filepath.Join(tmpDir, "ca.crt"), filepath.Join(tmpDir, "ca.key")),
+		WithSessionDir(sessionDir),
+		WithTLSPolicy(TLSPolicy{PassthroughHosts: []string{"127.0.0.1"}}),
+		WithTunnelLogging(true, 16),
+	)
+	defer sl.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go p.Serve(l)
+
+	// A passed-through TLS tunnel.
+	proxyURL, _ := url.Parse("http://" + l.Addr().String())
+	transport := upstream.Client().Transport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyURL(proxyURL)
+	transport.TLSClientConfig.ServerName = "example.com"
+	resp, err := (&http.Client{Transport: transport}).Get(upstream.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	transport.CloseIdleConnections()
+
+	// A tunnel carrying neither TLS nor HTTP is relayed as it is.
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(conn, "CONNECT "+echo.Addr().String()+" HTTP/1.1\r\nHost: "+echo.Addr().String()+"\r\n\r\n")
+	br := bufio.NewReader(conn)
+	if res, err := http.ReadResponse(br, nil); err != nil || res.StatusCode != http.StatusOK {
+		t.Fatalf("CONNECT: %v %v", res, err)
+	}
+	io.WriteString(conn, "SSH-2.0-rogue\r\n")
+	line, err := br.ReadString('\n')
+	if err != nil || line != "SSH-2.0-rogue\r\n" {
+		t.Fatalf("echoed %q, %v", line, err)
+	}
+	conn.Close()
+
+	var tunnels []logger.TunnelLog
+	for deadline := time.Now().Add(5 * time.Second); len(tunnels) < 2 && time.Now().Before(deadline); {
+		time.Sleep(20 * time.Millisecond)
+		entries, err := logger.ReadSession(filepath.Join(sessionDir, sl.GetSessionName()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tunnels, err = logger.Tunnels(entries); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(tunnels) != 2 {
+		t.Fatalf("tunnels = %+v", tunnels)
+	}
+	byReason := map[string]logger.TunnelLog{}
+	for _, tn := range tunnels {
+		byReason[tn.Reason] = tn
+	}
+	pass := byReason["passthrough"]
+	if pass.SNI != "example.com" || pass.BytesUp == 0 || pass.BytesDown == 0 || pass.Host != upstream.Listener.Addr().String() {
+		t.Errorf("passthrough tunnel = %+v", pass)
+	}
+	raw := byReason["not_http"]
+	if raw.BytesUp != 15 || raw.BytesDown != 15 || raw.SNI != "" || !strings.Contains(raw.UpSample, "|SSH-2.0-rogue..|") {
+		t.Errorf("raw tunnel = %+v", raw)
+	}
+}
+
+func TestTunnelFastPath(t *testing.T) {
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		c, err := echo.Accept()
+		if err != nil {
+			return
+		}
+		io.Copy(c, c)
+		c.Close()
+	}()
+
+	tmpDir := t.TempDir()
+	reg := metrics.NewRegistry()
+	p, sl := NewProxyServer(
+		WithCert(filepath.Join(tmpDir, "ca.crt"), filepath.Join(tmpDir, "ca.key")),
+		WithSessionDir(filepath.Join(tmpDir, "logs")),
+		WithTLSPolicy(TLSPolicy{PassthroughHosts: []string{"127.0.0.1"}}),
+		WithMetrics(reg),
+	)
+	defer sl.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go p.Serve(l)
+	defer p.Close()
+
+	// The first bytes follow CONNECT in the same write, so they are
+	// buffered before the tunnel is spliced.
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(conn, "CONNECT "+echo.Addr().String()+" HTTP/1.1\r\nHost: "+echo.Addr().String()+"\r\n\r\nhello ")
+	br := bufio.NewReader(conn)
+	if res, err := http.ReadResponse(br, nil); err != nil || res.StatusCode != http.StatusOK {
+		t.Fatalf("CONNECT: %v %v", res, err)
+	}
+	io.WriteString(conn, "world\n")
+	line, err := br.ReadString('\n')
+	if err != nil || line != "hello world\n" {
+		t.Fatalf("echoed %q, %v", line, err)
+	}
+	conn.Close()
+
+	var stats map[string]float64
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		if stats = reg.Snapshot(); stats["rogue_tunnels_total"] == 1 && stats["rogue_tunnels_open"] == 0 {
+			break
+		}
+	}
+	if stats["rogue_tunnels_spliced_total"] != 1 ||
+		stats[`rogue_tunnel_bytes_total{direction="up"}`] != 12 || stats[`rogue_tunnel_bytes_total{direction="down"}`] != 12 {
+		t.Errorf("metrics = %v", stats)
+	}
+}
@@ -0,0 +1,118 @@
+package proxy
+
+import (
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/standrze/rogue/internal/cookies"
+	"github.com/standrze/rogue/internal/rules"
+)
+
+// WithCookieJar records the cookies seen through the proxy in j.
+func WithCookieJar(j *cookies.Jar) ProxyOption {
+	return func(p *Proxy) {
+		p.CookieJar = j
+	}
+}
+
+// CookieModifier records cookies in Jar and applies the cookie edits of the
+// matched rule. Like HeaderModifier it runs after RulesModifier, so the jar
+// and the log hold the cookies the client sent rather than the edited ones.
+type CookieModifier struct {
+	Jar *cookies.Jar
+
+	// frozen holds the pinned value of each frozen cookie, by rule, host
+	// and cookie name.
+	frozen sync.Map
+}
+
+func (m *CookieModifier) ModifyRequest(req *http.Request) error {
+	if req.Method == http.MethodConnect {
+		return nil
+	}
+	if m.Jar != nil {
+		m.Jar.ObserveRequest(req.URL.Hostname(), req.Header, time.Now())
+	}
+	if rule, ok := matchedRule(req); ok && rule.Cookies != nil {
+		m.apply(req, rule)
+	}
+	return nil
+}
+
+func (m *CookieModifier) ModifyResponse(res *http.Response) error {
+	if m.Jar != nil && res.Request != nil && res.Request.Method != http.MethodConnect {
+		m.Jar.ObserveResponse(res.Request.URL, res.Header, time.Now())
+	}
+	return nil
+}
+
+// apply rewrites the Cookie header of req with the edits of rule.
+func (m *CookieModifier) apply(req *http.Request, rule rules.Rule) {
+	ops := rule.Cookies
+	var sent []*http.Cookie
+	for _, line := range req.Header.Values("Cookie") {
+		if cs, err := http.ParseCookie(line); err == nil {
+			sent = append(sent, cs...)
+		}
+	}
+
+	var out []string
+	seen := map[string]bool{}
+	for _, c := range sent {
+		if dropped(ops.Drop, c.Name) {
+			continue
+		}
+		value := c.Value
+		if v, ok := ops.Set[c.Name]; ok {
+			value = v
+		}
+		if slices.Contains(ops.Freeze, c.Name) {
+			v, _ := m.frozen.LoadOrStore(m.frozenKey(rule, req, c.Name), value)
+			value = v.(string)
+		}
+		seen[c.Name] = true
+		out = append(out, c.Name+"="+value)
+	}
+	for _, name := range sortedKeys(ops.Set) {
+		if !seen[name] {
+			seen[name] = true
+			out = append(out, name+"="+ops.Set[name])
+		}
+	}
+	for _, name := range ops.Freeze {
+		if v, ok := m.frozen.Load(m.frozenKey(rule, req, name)); ok && !seen[name] {
+			out = append(out, name+"="+v.(string))
+		}
+	}
+
+	req.Header.Del("Cookie")
+	if len(out) > 0 {
+		req.Header.Set("Cookie", strings.Join(out, "; "))
+	}
+}
+
+func (m *CookieModifier) frozenKey(rule rules.Rule, req *http.Request, name string) string {
+	return rule.Name + "\x00" + strings.ToLower(req.URL.Hostname()) + "\x00" + name
+}
+
+func dropped(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(strings.ToLower(p), strings.ToLower(name)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
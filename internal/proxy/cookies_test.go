@@ -0,0 +1,39 @@
+package proxy
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/standrze/rogue/internal/rules"
+)
+
+func TestCookieOps(t *testing.T) {
+	m := &CookieModifier{}
+	rule := rules.Rule{Name: "session", Cookies: &rules.CookieOps{
+		Drop:   []string{"_ga*"},
+		Set:    map[string]string{"lang": "fr", "debug": "1"},
+		Freeze: []string{"session"},
+	}}
+
+	req := httptest.NewRequest("GET", "http://example.com/", nil)
+	req.Header.Set("Cookie", "_ga=1; _gat=2; session=first; lang=en")
+	m.apply(req, rule)
+	if got, want := req.Header.Get("Cookie"), "session=first; lang=fr; debug=1"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// The server rotated the session; the frozen value is still sent.
+	req = httptest.NewRequest("GET", "http://example.com/next", nil)
+	req.Header.Set("Cookie", "session=second")
+	m.apply(req, rule)
+	if got, want := req.Header.Get("Cookie"), "session=first; debug=1; lang=fr"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// A request without the cookie gets the frozen one too.
+	req = httptest.NewRequest("GET", "http://example.com/other", nil)
+	m.apply(req, rule)
+	if got, want := req.Header.Get("Cookie"), "debug=1; lang=fr; session=first"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,275 @@
+package proxy
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"time"
+)
+
+// Address families for DialStrategy.Family.
+const (
+	FamilyIPv4       = "ipv4"
+	FamilyIPv6       = "ipv6"
+	FamilyPreferIPv4 = "prefer-ipv4"
+	FamilyPreferIPv6 = "prefer-ipv6"
+)
+
+// DialStrategy controls how origin hosts are resolved and connected to.
+type DialStrategy struct {
+	// Family is "ipv4" or "ipv6" to use only that family, "prefer-ipv4" or
+	// "prefer-ipv6" to try it first, or empty for the resolver's order.
+	Family string
+	// FallbackDelay is how long Happy Eyeballs waits on the first family
+	// before racing the other. Zero means 300ms; a negative value disables
+	// racing, trying addresses one at a time.
+	FallbackDelay time.Duration
+	// Resolvers sends the DNS queries of some hosts to other servers.
+	Resolvers []Resolver
+}
+
+// Resolver is a DNS server used for the hosts matching its globs.
+type Resolver struct {
+	Hosts []string
+	// Server is an IP address with an optional port (53 by default) for
+	// plain DNS, "udp://" or "tcp://" followed by one to choose the
+	// transport, "tls://host:port" for DNS over TLS (port 853 by default)
+	// or an https:// URL for DNS over HTTPS.
+	Server string
+}
+
+// WithDialStrategy sets how origin addresses are resolved and connected to.
+func WithDialStrategy(s DialStrategy) ProxyOption {
+	return func(p *Proxy) {
+		p.DialStrategy = s
+	}
+}
+
+// defaultFallbackDelay matches net.Dialer's.
+const defaultFallbackDelay = 300 * time.Millisecond
+
+// resolver is a Resolver ready for lookups.
+type resolver struct {
+	hosts    []string
+	resolver *net.Resolver
+}
+
+// resolvers checks s and builds its resolvers.
+func (s DialStrategy) resolvers() ([]resolver, error) {
+	switch s.Family {
+	case "", FamilyIPv4, FamilyIPv6, FamilyPreferIPv4, FamilyPreferIPv6:
+	default:
+		return nil, fmt.Errorf("unknown address family %q", s.Family)
+	}
+
+	var out []resolver
+	for _, r := range s.Resolvers {
+		res, err := newResolver(r.Server, 0)
+		if err != nil {
+			return nil, fmt.Errorf("resolver for %v: %w", r.Hosts, err)
+		}
+		out = append(out, resolver{hosts: r.Hosts, resolver: res})
+	}
+	return out, nil
+}
+
+// dial connects to addr, through the upstream proxy unless addr is exempt
+// from it.
+func (u *upstream) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	if u.chain != nil && !matchHost(u.chain.NoProxy, addr) {
+		return u.tunnel(ctx, network, addr)
+	}
+	return u.dialDirect(ctx, network, addr)
+}
+
+// dialDirect connects to addr. Origins covered by neither a binding nor
+// the dial strategy are left to the default dialer; the others are
+// resolved here, ordered by family and raced Happy Eyeballs style, each
+// address dialed from the binding's address of its family.
+func (u *upstream) dialDirect(ctx context.Context, network, addr string) (net.Conn, error) {
+	var dialers []*net.Dialer
+	for _, b := range u.bindings {
+		if len(b.hosts) == 0 || matchHost(b.hosts, addr) {
+			dialers = b.dialers
+			break
+		}
+	}
+	res := u.resolverFor(addr)
+	overridden := u.overrideFor(addr)
+	if dialers == nil && res == nil && overridden == nil && u.strategy.Family == "" && u.strategy.FallbackDelay == 0 {
+		return u.dialer.DialContext(ctx, network, addr)
+	}
+	if dialers == nil {
+		dialers = []*net.Dialer{u.dialer}
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	var ips []netip.Addr
+	if ip, err := netip.ParseAddr(host); err == nil {
+		ips = []netip.Addr{ip}
+	} else if overridden != nil {
+		ips = overridden
+	} else {
+		if res == nil {
+			res = net.DefaultResolver
+		}
+		if ips, err = res.LookupNetIP(ctx, "ip", host); err != nil {
+			return nil, err
+		}
+	}
+
+	primary, fallback := u.order(ips, network, dialers)
+	if len(primary) == 0 {
+		return nil, &net.OpError{Op: "dial", Net: network, Err: fmt.Errorf("no usable address for %s", host)}
+	}
+	dial := func(ctx context.Context, ips []netip.Addr) (net.Conn, error) {
+		return dialSerial(ctx, ips, port, dialers)
+	}
+	if len(fallback) == 0 || u.strategy.FallbackDelay < 0 {
+		return dial(ctx, append(primary, fallback...))
+	}
+	return dialRace(ctx, primary, fallback, cmp.Or(u.strategy.FallbackDelay, defaultFallbackDelay), dial)
+}
+
+// resolverFor returns the resolver configured for addr's host, the DNS
+// server, or nil to use the system's.
+func (u *upstream) resolverFor(addr string) *net.Resolver {
+	for _, r := range u.resolvers {
+		if matchHost(r.hosts, addr) {
+			return r.resolver
+		}
+	}
+	return u.dnsServer
+}
+
+// overrideFor returns the addresses a DNS override gives addr's host, or
+// nil.
+func (u *upstream) overrideFor(addr string) []netip.Addr {
+	for _, o := range u.overrides {
+		if matchHost([]string{o.host}, addr) {
+			return o.ips
+		}
+	}
+	return nil
+}
+
+// order splits ips into the family to try first and the other, leaving out
+// families the network, strategy or dialers rule out.
+func (u *upstream) order(ips []netip.Addr, network string, dialers []*net.Dialer) (primary, fallback []netip.Addr) {
+	allow4 := network != "tcp6" && u.strategy.Family != FamilyIPv6 && dialerFor(dialers, true) != nil
+	allow6 := network != "tcp4" && u.strategy.Family != FamilyIPv4 && dialerFor(dialers, false) != nil
+
+	var v4, v6 []netip.Addr
+	for _, ip := range ips {
+		ip = ip.Unmap()
+		switch {
+		case ip.Is4() && allow4:
+			v4 = append(v4, ip)
+		case ip.Is6() && allow6:
+			v6 = append(v6, ip)
+		}
+	}
+
+	first6 := u.strategy.Family == FamilyPreferIPv6 ||
+		(u.strategy.Family != FamilyPreferIPv4 && len(ips) > 0 && ips[0].Unmap().Is6())
+	if (first6 && len(v6) > 0) || len(v4) == 0 {
+		return v6, v4
+	}
+	return v4, v6
+}
+
+// dialerFor returns the dialer for an address family: one bound to an
+// address of that family, or an unbound one.
+func dialerFor(dialers []*net.Dialer, v4 bool) *net.Dialer {
+	for _, d := range dialers {
+		local, ok := d.LocalAddr.(*net.TCPAddr)
+		if !ok || local == nil || (local.IP.To4() != nil) == v4 {
+			return d
+		}
+	}
+	return nil
+}
+
+// dialSerial tries ips in turn, returning the first error if all fail.
+func dialSerial(ctx context.Context, ips []netip.Addr, port string, dialers []*net.Dialer) (net.Conn, error) {
+	var firstErr error
+	for _, ip := range ips {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		conn, err := dialerFor(dialers, ip.Is4()).DialContext(ctx, "tcp", net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr == nil {
+		firstErr = errors.New("no addresses to dial")
+	}
+	return nil, firstErr
+}
+
+// dialRace dials primary, and fallback too once primary has failed or
+// delay has passed, returning the first connection made.
+func dialRace(ctx context.Context, primary, fallback []netip.Addr, delay time.Duration,
+	dial func(context.Context, []netip.Addr) (net.Conn, error)) (net.Conn, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	results := make(chan result)
+	returned := make(chan struct{})
+	defer close(returned)
+	start := func(ips []netip.Addr) {
+		conn, err := dial(ctx, ips)
+		select {
+		case results <- result{conn, err}:
+		case <-returned:
+			if conn != nil {
+				conn.Close()
+			}
+		}
+	}
+
+	go start(primary)
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	pending, raced := 1, false
+	var firstErr error
+	for {
+		select {
+		case <-timer.C:
+			if !raced {
+				raced, pending = true, pending+1
+				go start(fallback)
+			}
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				return r.conn, nil
+			}
+			if firstErr == nil {
+				firstErr = r.err
+			}
+			if !raced {
+				raced, pending = true, pending+1
+				go start(fallback)
+			}
+			if pending == 0 {
+				return nil, firstErr
+			}
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestDialOrder(t *testing.T) {
+	ips := []netip.Addr{
+		netip.MustParseAddr("2001:db8::1"),
+		netip.MustParseAddr("192.0.2.1"),
+		netip.MustParseAddr("2001:db8::2"),
+	}
+	v4 := ips[1:2]
+	v6 := []netip.Addr{ips[0], ips[2]}
+	unbound := []*net.Dialer{{}}
+
+	tests := []struct {
+		family, network   string
+		primary, fallback []netip.Addr
+	}{
+		{"", "tcp", v6, v4},
+		{FamilyPreferIPv4, "tcp", v4, v6},
+		{FamilyPreferIPv6, "tcp", v6, v4},
+		{FamilyIPv4, "tcp", v4, nil},
+		{FamilyIPv6, "tcp", v6, nil},
+		{"", "tcp4", v4, nil},
+	}
+	for _, tt := range tests {
+		u := &upstream{strategy: DialStrategy{Family: tt.family}}
+		primary, fallback := u.order(ips, tt.network, unbound)
+		if !slices.Equal(primary, tt.primary) || !slices.Equal(fallback, tt.fallback) {
+			t.Errorf("%q over %s: got %v then %v, want %v then %v", tt.family, tt.network, primary, fallback, tt.primary, tt.fallback)
+		}
+	}
+
+	// A binding with only an IPv4 address cannot reach IPv6 origins.
+	bound := []*net.Dialer{{LocalAddr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}}}
+	u := &upstream{strategy: DialStrategy{Family: FamilyPreferIPv6}}
+	if primary, fallback := u.order(ips, "tcp", bound); !slices.Equal(primary, v4) || fallback != nil {
+		t.Errorf("IPv4 binding: got %v then %v", primary, fallback)
+	}
+}
+
+func TestDialRace(t *testing.T) {
+	primary := []netip.Addr{netip.MustParseAddr("2001:db8::1")}
+	fallback := []netip.Addr{netip.MustParseAddr("192.0.2.1")}
+	server, client := net.Pipe()
+	defer server.Close()
+
+	// A hanging primary family loses to the fallback once the delay passes.
+	dial := func(ctx context.Context, ips []netip.Addr) (net.Conn, error) {
+		if ips[0].Is6() {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return client, nil
+	}
+	start := time.Now()
+	conn, err := dialRace(context.Background(), primary, fallback, 20*time.Millisecond, dial)
+	if err != nil || conn != client {
+		t.Fatalf("got %v, %v", conn, err)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("fallback started after %v, before the delay", elapsed)
+	}
+
+	// Both failing reports the first error.
+	errV6 := errors.New("v6 unreachable")
+	fail := func(ctx context.Context, ips []netip.Addr) (net.Conn, error) {
+		if ips[0].Is6() {
+			return nil, errV6
+		}
+		return nil, errors.New("v4 unreachable")
+	}
+	if _, err := dialRace(context.Background(), primary, fallback, time.Hour, fail); err != errV6 {
+		t.Errorf("got %v, want %v", err, errV6)
+	}
+}
+
+func TestDialStrategyValidation(t *testing.T) {
+	if _, err := (DialStrategy{Family: "ipv5"}).resolvers(); err == nil {
+		t.Error("expected an error for an unknown family")
+	}
+	if _, err := (DialStrategy{Resolvers: []Resolver{{Server: "dns.example"}}}).resolvers(); err == nil {
+		t.Error("expected an error for a resolver named by host")
+	}
+	rs, err := (DialStrategy{Resolvers: []Resolver{{Hosts: []string{"*.corp"}, Server: "10.0.0.53"}}}).resolvers()
+	if err != nil || len(rs) != 1 {
+		t.Fatalf("got %v, %v", rs, err)
+	}
+	u := &upstream{resolvers: rs}
+	if u.resolverFor("intranet.corp:443") == nil || u.resolverFor("example.com:443") != nil {
+		t.Error("resolver applied to the wrong hosts")
+	}
+}
@@ -0,0 +1,217 @@
+package proxy
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// dohContentType is the media type of DNS over HTTPS messages.
+const dohContentType = "application/dns-message"
+
+// DNS changes how origin host names are resolved.
+type DNS struct {
+	// Overrides answer for hosts without asking DNS, like /etc/hosts.
+	Overrides []Override
+	// Server resolves hosts that have no override and no dial strategy
+	// resolver. See Resolver.Server for the forms it takes.
+	Server string
+}
+
+// Override gives the addresses of the hosts matching Host, a glob.
+type Override struct {
+	Host      string
+	Addresses []string
+}
+
+// WithDNS sets host overrides and the DNS server for upstream dials.
+func WithDNS(d DNS) ProxyOption {
+	return func(p *Proxy) {
+		p.DNS = d
+	}
+}
+
+// override is an Override with parsed addresses.
+type override struct {
+	host string
+	ips  []netip.Addr
+}
+
+// overrides checks and parses the overrides of d.
+func (d DNS) overrides() ([]override, error) {
+	var out []override
+	for _, o := range d.Overrides {
+		if _, err := path.Match(strings.ToLower(o.Host), ""); err != nil || o.Host == "" {
+			return nil, fmt.Errorf("dns override: invalid host %q", o.Host)
+		}
+		if len(o.Addresses) == 0 {
+			return nil, fmt.Errorf("dns override for %s: no addresses", o.Host)
+		}
+		ov := override{host: o.Host}
+		for _, a := range o.Addresses {
+			ip, err := netip.ParseAddr(a)
+			if err != nil {
+				return nil, fmt.Errorf("dns override for %s: invalid address %q", o.Host, a)
+			}
+			ov.ips = append(ov.ips, ip)
+		}
+		out = append(out, ov)
+	}
+	return out, nil
+}
+
+// newResolver builds a resolver for server, which is an IP address with
+// an optional port for plain DNS, "udp://" or "tcp://" followed by one to
+// choose the transport, "tls://host:port" for DNS over TLS (port 853 by
+// default) or an https:// URL for DNS over HTTPS. The names of DoT and
+// DoH servers are resolved by the system.
+func newResolver(server string, timeout time.Duration) (*net.Resolver, error) {
+	timeout = cmp.Or(timeout, 5*time.Second)
+	if strings.HasPrefix(server, "https://") {
+		u, err := url.Parse(server)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid DNS over HTTPS server %q", server)
+		}
+		client := &http.Client{
+			Timeout:   timeout,
+			Transport: &http.Transport{ForceAttemptHTTP2: true, TLSHandshakeTimeout: timeout},
+		}
+		return &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return &dohConn{ctx: ctx, client: client, url: server}, nil
+			},
+		}, nil
+	}
+
+	scheme, addr, found := strings.Cut(server, "://")
+	if !found {
+		scheme, addr = "", server
+	}
+	port := "53"
+	if scheme == "tls" {
+		port = "853"
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(strings.Trim(addr, "[]"), port)
+	}
+
+	d := &net.Dialer{Timeout: timeout}
+	var dial func(ctx context.Context, network, _ string) (net.Conn, error)
+	switch scheme {
+	case "", "udp":
+		dial = func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return d.DialContext(ctx, network, addr)
+		}
+	case "tcp":
+		dial = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return d.DialContext(ctx, "tcp", addr)
+		}
+	case "tls":
+		host, _, _ := net.SplitHostPort(addr)
+		td := &tls.Dialer{NetDialer: d, Config: &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}}
+		return &net.Resolver{PreferGo: true, Dial: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return td.DialContext(ctx, "tcp", addr)
+		}}, nil
+	default:
+		return nil, fmt.Errorf("DNS server %q: unknown scheme %q", server, scheme)
+	}
+	if _, err := netip.ParseAddrPort(addr); err != nil {
+		return nil, fmt.Errorf("DNS server %q: plain DNS servers must be IP addresses", server)
+	}
+	return &net.Resolver{PreferGo: true, Dial: dial}, nil
+}
+
+// dohConn carries the queries of Go's resolver over DNS over HTTPS. The
+// resolver frames messages as on TCP, with a two-byte length, since the
+// connection is not a PacketConn; each complete query is POSTed and its
+// answer framed the same way for reading.
+type dohConn struct {
+	ctx      context.Context
+	client   *http.Client
+	url      string
+	deadline time.Time
+
+	out, in bytes.Buffer
+}
+
+func (c *dohConn) Write(p []byte) (int, error) {
+	return c.out.Write(p)
+}
+
+func (c *dohConn) Read(p []byte) (int, error) {
+	if c.in.Len() == 0 {
+		if err := c.exchange(); err != nil {
+			return 0, err
+		}
+	}
+	return c.in.Read(p)
+}
+
+// exchange sends the next framed query and buffers its answer.
+func (c *dohConn) exchange() error {
+	if c.out.Len() < 2 {
+		return io.EOF
+	}
+	n := int(binary.BigEndian.Uint16(c.out.Bytes()))
+	if c.out.Len() < 2+n {
+		return io.ErrUnexpectedEOF
+	}
+	c.out.Next(2)
+	query := c.out.Next(n)
+
+	ctx := c.ctx
+	if !c.deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, c.deadline)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(query))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", dohContentType)
+	req.Header.Set("Accept", dohContentType)
+	res, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("DNS over HTTPS: %s", res.Status)
+	}
+	answer, err := io.ReadAll(io.LimitReader(res.Body, 65535+1))
+	if err != nil {
+		return err
+	}
+	if len(answer) > 65535 {
+		return errors.New("DNS over HTTPS: answer too large")
+	}
+	c.in.Write(binary.BigEndian.AppendUint16(nil, uint16(len(answer))))
+	c.in.Write(answer)
+	return nil
+}
+
+func (c *dohConn) Close() error                       { return nil }
+func (c *dohConn) LocalAddr() net.Addr                { return dohAddr(c.url) }
+func (c *dohConn) RemoteAddr() net.Addr               { return dohAddr(c.url) }
+func (c *dohConn) SetDeadline(t time.Time) error      { c.deadline = t; return nil }
+func (c *dohConn) SetReadDeadline(t time.Time) error  { c.deadline = t; return nil }
+func (c *dohConn) SetWriteDeadline(t time.Time) error { return nil }
+
+type dohAddr string
+
+func (a dohAddr) Network() string { return "https" }
+func (a dohAddr) String() string  { return string(a) }
@@ -0,0 +1,105 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestDNSOverrides(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+
+	overrides, err := DNS{Overrides: []Override{{Host: "*.staging.invalid", Addresses: []string{"127.0.0.1"}}}}.overrides()
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := &upstream{dialer: &net.Dialer{}, overrides: overrides}
+	conn, err := u.dial(context.Background(), "tcp", "api.staging.invalid:"+port)
+	if err != nil {
+		t.Fatalf("Dialing an overridden host: %v", err)
+	}
+	conn.Close()
+	if u.overrideFor("api.prod.invalid:443") != nil {
+		t.Error("Override applied to another host")
+	}
+
+	if _, err := (DNS{Overrides: []Override{{Host: "a.test", Addresses: []string{"not-an-ip"}}}}).overrides(); err == nil {
+		t.Error("Expected an error for an invalid override address")
+	}
+}
+
+func TestNewResolver(t *testing.T) {
+	for _, server := range []string{"8.8.8.8", "udp://8.8.8.8:5353", "tcp://[2001:db8::53]", "tls://dns.example", "https://dns.example/dns-query"} {
+		if _, err := newResolver(server, 0); err != nil {
+			t.Errorf("%s: %v", server, err)
+		}
+	}
+	for _, server := range []string{"dns.example", "quic://8.8.8.8", "https://"} {
+		if _, err := newResolver(server, 0); err == nil {
+			t.Errorf("%s: expected an error", server)
+		}
+	}
+}
+
+func TestDNSOverHTTPS(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != dohContentType {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		query, _ := io.ReadAll(r.Body)
+		var p dnsmessage.Parser
+		h, err := p.Start(query)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		q, _ := p.Question()
+
+		b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: h.ID, Response: true, RecursionAvailable: true})
+		b.StartQuestions()
+		b.Question(q)
+		b.StartAnswers()
+		if q.Type == dnsmessage.TypeA {
+			b.AResource(dnsmessage.ResourceHeader{Name: q.Name, Class: dnsmessage.ClassINET, TTL: 60}, dnsmessage.AResource{A: [4]byte{192, 0, 2, 7}})
+		}
+		answer, _ := b.Finish()
+		w.Header().Set("Content-Type", dohContentType)
+		w.Write(answer)
+	}))
+	defer srv.Close()
+
+	res := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return &dohConn{ctx: ctx, client: srv.Client(), url: srv.URL}, nil
+		},
+	}
+	ips, err := res.LookupNetIP(context.Background(), "ip4", "staging.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ips) != 1 || ips[0] != netip.MustParseAddr("192.0.2.7") {
+		t.Errorf("Got %v, want 192.0.2.7", ips)
+	}
+}
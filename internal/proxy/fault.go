@@ -0,0 +1,183 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/martian/v3"
+	"github.com/standrze/rogue/internal/logger"
+	"github.com/standrze/rogue/internal/rules"
+)
+
+// errDropped is returned by the body of a response whose connection a fault
+// dropped.
+var errDropped = errors.New("connection dropped by fault rule")
+
+// framingHeaders are left alone by "*" in CorruptHeaders, since scrambling
+// them breaks the connection rather than the response.
+var framingHeaders = map[string]bool{"Content-Length": true, "Transfer-Encoding": true, "Connection": true}
+
+// rollFault reports whether a request matching f is faulted.
+func rollFault(f *rules.Fault) bool {
+	return f.Probability == 0 || rand.Float64() < f.Probability
+}
+
+// injectFault applies the request side of a fault: the delay, and skipping
+// the origin when a status is given.
+func (m *RulesModifier) injectFault(req *http.Request, ctx *martian.Context, rule rules.Rule) {
+	f := rule.Fault
+	if m.Logger != nil {
+		m.Logger.WriteEntry("annotation", logger.Annotation{
+			Timestamp: time.Now(),
+			RequestID: requestID(req),
+			Comment:   fmt.Sprintf("fault: rule %q injected %s", rule.Name, describeFault(f)),
+		})
+	}
+
+	if f.DelayMS > 0 {
+		t := time.NewTimer(time.Duration(f.DelayMS) * time.Millisecond)
+		select {
+		case <-t.C:
+		case <-req.Context().Done():
+			t.Stop()
+		}
+	}
+	if f.Status != 0 {
+		ctx.SkipRoundTrip()
+	}
+}
+
+// applyFault applies the response side of f to res.
+func applyFault(res *http.Response, f *rules.Fault) error {
+	if f.Status != 0 {
+		applyMock(res, &rules.Mock{
+			Status:  f.Status,
+			Headers: map[string]string{"Content-Type": "text/plain; charset=utf-8"},
+			Body:    http.StatusText(f.Status) + "\n",
+		})
+	}
+	corruptHeaders(res.Header, f.CorruptHeaders)
+
+	if f.Truncate > 0 && res.Body != nil && res.Body != http.NoBody {
+		body, err := io.ReadAll(io.LimitReader(res.Body, int64(f.Truncate)))
+		res.Body.Close()
+		if err != nil {
+			return err
+		}
+		res.Body = io.NopCloser(bytes.NewReader(body))
+		res.ContentLength = int64(len(body))
+		res.Header.Set("Content-Length", strconv.Itoa(len(body)))
+		res.TransferEncoding = nil
+	}
+
+	if f.Drop {
+		ctx := martian.NewContext(res.Request)
+		if ctx == nil {
+			return nil
+		}
+		body := res.Body
+		if body == nil {
+			body = http.NoBody
+		}
+		res.Body = &dropReader{ReadCloser: body, left: f.DropAfter, session: ctx.Session()}
+	}
+	return nil
+}
+
+// corruptHeaders scrambles the values of the named headers in h.
+func corruptHeaders(h http.Header, names []string) {
+	for _, name := range names {
+		if name != "*" {
+			if vs := h.Values(name); len(vs) > 0 {
+				h[http.CanonicalHeaderKey(name)] = scramble(vs)
+			}
+			continue
+		}
+		for k, vs := range h {
+			if !framingHeaders[k] {
+				h[k] = scramble(vs)
+			}
+		}
+	}
+}
+
+// scramble shuffles the characters of each value, keeping them printable
+// so the response can still be parsed.
+func scramble(vs []string) []string {
+	out := make([]string, len(vs))
+	for i, v := range vs {
+		b := []byte(v)
+		rand.Shuffle(len(b), func(i, j int) { b[i], b[j] = b[j], b[i] })
+		if bytes.Equal(b, []byte(v)) {
+			b = append(b, '~')
+		}
+		out[i] = strings.ToValidUTF8(string(b), "?")
+	}
+	return out
+}
+
+// describeFault summarizes f for annotations.
+func describeFault(f *rules.Fault) string {
+	var parts []string
+	if f.DelayMS > 0 {
+		parts = append(parts, fmt.Sprintf("delay %dms", f.DelayMS))
+	}
+	if f.Status != 0 {
+		parts = append(parts, fmt.Sprintf("status %d", f.Status))
+	}
+	if len(f.CorruptHeaders) > 0 {
+		parts = append(parts, "corrupt headers "+strings.Join(f.CorruptHeaders, ","))
+	}
+	if f.Truncate > 0 {
+		parts = append(parts, fmt.Sprintf("truncate to %d bytes", f.Truncate))
+	}
+	if f.Drop {
+		parts = append(parts, fmt.Sprintf("drop after %d bytes", f.DropAfter))
+	}
+	if len(parts) == 0 {
+		return "nothing"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// dropReader passes on the first left bytes of a body, then sends what has
+// been written so far and resets the client connection.
+type dropReader struct {
+	io.ReadCloser
+	left    int
+	session *martian.Session
+}
+
+func (d *dropReader) Read(p []byte) (int, error) {
+	if d.left <= 0 {
+		d.drop()
+		return 0, errDropped
+	}
+	if len(p) > d.left {
+		p = p[:d.left]
+	}
+	n, err := d.ReadCloser.Read(p)
+	d.left -= n
+	if err == io.EOF {
+		// The body ended first; drop before the response can complete.
+		d.left = 0
+		err = nil
+	}
+	return n, err
+}
+
+func (d *dropReader) drop() {
+	conn, brw, err := d.session.Hijack()
+	if err != nil {
+		return
+	}
+	brw.Flush()
+	resetConn(conn)
+}
@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/standrze/rogue/internal/rules"
+)
+
+func TestFaultRules(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"items": [1, 2, 3, 4, 5, 6, 7, 8, 9]}`))
+	}))
+	defer origin.Close()
+
+	tmpDir := t.TempDir()
+	set := rules.NewSet(
+		rules.Rule{Name: "outage", Match: rules.Match{Path: "/status"}, Fault: &rules.Fault{Status: 503, DelayMS: 50}},
+		rules.Rule{Name: "short", Match: rules.Match{Path: "/short"}, Fault: &rules.Fault{Truncate: 10, CorruptHeaders: []string{"Content-Type"}}},
+		rules.Rule{Name: "drop", Match: rules.Match{Path: "/drop"}, Fault: &rules.Fault{Drop: true, DropAfter: 5}},
+	)
+	p, sl := NewProxyServer(
+		WithCert(filepath.Join(tmpDir, "ca.crt"), filepath.Join(tmpDir, "ca.key")),
+		WithSessionDir(filepath.Join(tmpDir, "logs")),
+		WithRules(set),
+	)
+	defer sl.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go p.Serve(l)
+	defer p.Close()
+
+	proxyURL, _ := url.Parse("http://" + l.Addr().String())
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL), DisableKeepAlives: true}}
+
+	start := time.Now()
+	resp, err := client.Get(origin.URL + "/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 503 {
+		t.Errorf("Status fault got %d, want 503", resp.StatusCode)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("Delayed request took %v, want at least 50ms", elapsed)
+	}
+
+	resp, err = client.Get(origin.URL + "/short")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || string(body) != `{"items": ` {
+		t.Errorf("Truncated body %q, %v", body, err)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct == "application/json" || !strings.Contains(ct, "j") {
+		t.Errorf("Content-Type %q was not scrambled", ct)
+	}
+
+	resp, err = client.Get(origin.URL + "/drop")
+	if err == nil {
+		body, err = io.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+	if err == nil {
+		t.Errorf("Dropped response read in full: %q", body)
+	}
+}
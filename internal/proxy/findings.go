@@ -0,0 +1,57 @@
+package proxy
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/standrze/rogue/internal/findings"
+	"github.com/standrze/rogue/internal/logger"
+)
+
+// WithFindings passively checks every exchange with scanner and records
+// what it finds in the session.
+func WithFindings(scanner *findings.Scanner) ProxyOption {
+	return func(p *Proxy) {
+		p.Findings = scanner
+	}
+}
+
+// FindingsModifier passively checks exchanges for security issues and
+// records what it finds as "finding" entries. Header checks run when the
+// response arrives; body checks run on the start of the body once the
+// client has read it, so the response is never held back.
+type FindingsModifier struct {
+	Scanner *findings.Scanner
+	Logger  *logger.SessionLogger
+}
+
+func (m *FindingsModifier) ModifyResponse(res *http.Response) error {
+	req := res.Request
+	if req == nil || req.Method == http.MethodConnect || !inScope(req) {
+		return nil
+	}
+	m.record(req, m.Scanner.Headers(req, res))
+
+	if !bodyAllowed(res) || res.Body == nil || res.Body == http.NoBody || !textual(res) {
+		return nil
+	}
+	res.Body = &headBody{ReadCloser: res.Body, limit: findings.MaxBody, done: func(head []byte) {
+		m.record(req, m.Scanner.Body(req, res, decodeHead(res.Header.Get("Content-Encoding"), head, findings.MaxBody)))
+	}}
+	return nil
+}
+
+func (m *FindingsModifier) record(req *http.Request, found []findings.Finding) {
+	for _, f := range found {
+		f.RequestID, f.URL = requestID(req), m.Logger.RedactURL(req.URL)
+		m.Logger.WriteEntry("finding", f)
+	}
+}
+
+// textual reports whether the body checks can make sense of res.
+func textual(res *http.Response) bool {
+	mt, _, _ := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	return strings.HasPrefix(mt, "text/") && mt != "text/event-stream" ||
+		strings.HasSuffix(mt, "json") || strings.HasSuffix(mt, "xml")
+}
@@ -0,0 +1,82 @@
+package proxy
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/standrze/rogue/internal/findings"
+	"github.com/standrze/rogue/internal/logger"
+	"github.com/standrze/rogue/internal/rules"
+)
+
+func TestFindings(t *testing.T) {
+	tmpDir := t.TempDir()
+	sessionDir := filepath.Join(tmpDir, "logs")
+	set := rules.NewSet(rules.Rule{
+		Name:  "broken page",
+		Match: rules.Match{Host: "unbuilt.invalid"},
+		Mock: &rules.Mock{
+			Status:  500,
+			Headers: map[string]string{"Content-Type": "text/html", "Set-Cookie": "sid=1; Path=/"},
+			Body:    "<pre>goroutine 1 [running]:\nmain.main()</pre>",
+		},
+	})
+	scanner, err := findings.NewScanner(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, sl := NewProxyServer(
+		WithCert(filepath.Join(tmpDir, "ca.crt"), filepath.Join(tmpDir, "ca.key")),
+		WithSessionDir(sessionDir),
+		WithRules(set),
+		WithFindings(scanner),
+	)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go p.Serve(l)
+	defer p.Close()
+
+	proxyURL, _ := url.Parse("http://" + l.Addr().String())
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+	resp, err := client.Get("http://unbuilt.invalid/crash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	defer sl.Close()
+
+	// Body checks run once the proxy is done with the body, which may be
+	// after the client has it.
+	var found []findings.Finding
+	for i := 0; i < 100 && len(found) < 3; i++ {
+		time.Sleep(10 * time.Millisecond)
+		entries, err := logger.ReadSession(filepath.Join(sessionDir, sl.GetSessionName()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found, err = findings.FromEntries(entries); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got := map[string]bool{}
+	for _, f := range found {
+		got[f.Check] = true
+		if f.RequestID == "" || f.URL != "http://unbuilt.invalid/crash" {
+			t.Errorf("finding %+v lacks its flow", f)
+		}
+	}
+	for _, check := range []string{findings.CheckSecurityHeaders, findings.CheckInsecureCookie, findings.CheckVerboseError} {
+		if !got[check] {
+			t.Errorf("Expected a %s finding, got %+v", check, found)
+		}
+	}
+}
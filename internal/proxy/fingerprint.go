@@ -0,0 +1,101 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	utls "github.com/refraction-networking/utls"
+)
+
+var fingerprints = map[string]utls.ClientHelloID{
+	"chrome":     utls.HelloChrome_Auto,
+	"firefox":    utls.HelloFirefox_Auto,
+	"safari":     utls.HelloSafari_Auto,
+	"edge":       utls.HelloEdge_Auto,
+	"ios":        utls.HelloIOS_Auto,
+	"android":    utls.HelloAndroid_11_OkHttp,
+	"randomized": utls.HelloRandomizedNoALPN,
+}
+
+// Fingerprints lists the browser fingerprints accepted by ParseFingerprint.
+func Fingerprints() []string {
+	names := make([]string, 0, len(fingerprints))
+	for name := range fingerprints {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ParseFingerprint validates a fingerprint name. An empty name selects Go's
+// own ClientHello.
+func ParseFingerprint(name string) (string, error) {
+	name = strings.ToLower(name)
+	if _, ok := fingerprints[name]; name != "" && !ok {
+		return "", fmt.Errorf("unknown TLS fingerprint %q (want one of %s)", name, strings.Join(Fingerprints(), ", "))
+	}
+	return name, nil
+}
+
+// helloSpec returns the ClientHello of the named browser, restricted to
+// HTTP/1.1 because the transport cannot speak HTTP/2 over a uTLS connection.
+func helloSpec(name string) (utls.ClientHelloID, *utls.ClientHelloSpec, error) {
+	id := fingerprints[name]
+	if id == utls.HelloRandomizedNoALPN {
+		return id, nil, nil
+	}
+
+	spec, err := utls.UTLSIdToSpec(id)
+	if err != nil {
+		return id, nil, err
+	}
+	for _, ext := range spec.Extensions {
+		if alpn, ok := ext.(*utls.ALPNExtension); ok {
+			alpn.AlpnProtocols = []string{"http/1.1"}
+		}
+	}
+	return utls.HelloCustom, &spec, nil
+}
+
+// mimicHandshake performs the upstream handshake with a browser ClientHello.
+// Version and cipher suite settings of the policy do not apply; the
+// fingerprint defines them.
+func (u *upstream) mimicHandshake(ctx context.Context, raw net.Conn, host string) (net.Conn, error) {
+	id, spec, err := helloSpec(u.policy.Fingerprint)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &utls.Config{
+		ServerName:         host,
+		InsecureSkipVerify: true,
+		KeyLogWriter:       u.policy.KeyLog,
+		VerifyConnection: func(cs utls.ConnectionState) error {
+			return u.verify(host, tls.ConnectionState{
+				Version:            cs.Version,
+				CipherSuite:        cs.CipherSuite,
+				NegotiatedProtocol: cs.NegotiatedProtocol,
+				ServerName:         cs.ServerName,
+				PeerCertificates:   cs.PeerCertificates,
+			})
+		},
+	}
+	if c, ok := u.policy.clientCert(host); ok {
+		cfg.Certificates = []utls.Certificate{{Certificate: c.Certificate, PrivateKey: c.PrivateKey, Leaf: c.Leaf}}
+	}
+	conn := utls.UClient(raw, cfg, id)
+	if spec != nil {
+		if err := conn.ApplyPreset(spec); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := conn.HandshakeContext(ctx); err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
@@ -0,0 +1,52 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestUpstreamFingerprint(t *testing.T) {
+	hellos := make(chan *tls.ClientHelloInfo, 1)
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.TLS = &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			hellos <- hello
+			return nil, nil
+		},
+	}
+	srv.StartTLS()
+	defer srv.Close()
+
+	u := &upstream{
+		dialer: &net.Dialer{},
+		policy: TLSPolicy{Fingerprint: "chrome", SkipVerifyHosts: []string{"127.0.0.1"}},
+	}
+	conn, err := u.dialTLS(context.Background(), "tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Handshake failed: %v", err)
+	}
+	conn.Close()
+
+	hello := <-hellos
+	// Browsers send GREASE values, Go never does.
+	var grease bool
+	for _, cs := range hello.CipherSuites {
+		if cs&0x0f0f == 0x0a0a {
+			grease = true
+		}
+	}
+	if !grease {
+		t.Errorf("Expected a browser ClientHello, got cipher suites %x", hello.CipherSuites)
+	}
+	if len(hello.SupportedProtos) != 1 || hello.SupportedProtos[0] != "http/1.1" {
+		t.Errorf("Expected ALPN restricted to http/1.1, got %v", hello.SupportedProtos)
+	}
+
+	if _, err := ParseFingerprint("netscape"); err == nil {
+		t.Error("Expected unknown fingerprint to be rejected")
+	}
+}
@@ -0,0 +1,46 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/martian/v3"
+)
+
+// RequestIDHeader is set on outgoing requests when the request ID is exposed
+// for downstream debugging.
+const RequestIDHeader = "X-Rogue-Request-ID"
+
+const requestIDKey = "rogue.request_id"
+
+// RequestIDModifier assigns every exchange an ID and stores it on the martian
+// context so request and response entries can be correlated without touching
+// the traffic itself.
+type RequestIDModifier struct {
+	ExposeHeader bool
+}
+
+func (r *RequestIDModifier) ModifyRequest(req *http.Request) error {
+	reqID := fmt.Sprintf("%d", time.Now().UnixNano())
+
+	if ctx := martian.NewContext(req); ctx != nil {
+		reqID = ctx.ID()
+		ctx.Set(requestIDKey, reqID)
+	}
+
+	if r.ExposeHeader {
+		req.Header.Set(RequestIDHeader, reqID)
+	}
+	return nil
+}
+
+// requestID returns the ID assigned to req by RequestIDModifier.
+func requestID(req *http.Request) string {
+	if ctx := martian.NewContext(req); ctx != nil {
+		if v, ok := ctx.Get(requestIDKey); ok {
+			return v.(string)
+		}
+	}
+	return req.Header.Get(RequestIDHeader)
+}
@@ -0,0 +1,59 @@
+package proxy
+
+import (
+	"net/http"
+
+	"github.com/standrze/rogue/internal/rules"
+)
+
+// WithHeaderProfiles defines header profiles that rules can name, and
+// applies those listed in global to every exchange.
+func WithHeaderProfiles(profiles rules.Profiles, global ...string) ProxyOption {
+	return func(p *Proxy) {
+		p.HeaderProfiles = profiles
+		p.GlobalHeaders = global
+	}
+}
+
+// HeaderModifier applies the global header profiles, then those named by
+// the matched rule. It runs after RulesModifier, so request edits are not
+// in the logged request but response edits are in the logged response.
+type HeaderModifier struct {
+	Profiles rules.Profiles
+	Global   []string
+}
+
+func (m *HeaderModifier) ModifyRequest(req *http.Request) error {
+	if req.Method == http.MethodConnect {
+		return nil
+	}
+	for _, hp := range m.profiles(req) {
+		hp.Request.Apply(req.Header)
+	}
+	return nil
+}
+
+func (m *HeaderModifier) ModifyResponse(res *http.Response) error {
+	if res.Request == nil || res.Request.Method == http.MethodConnect {
+		return nil
+	}
+	for _, hp := range m.profiles(res.Request) {
+		hp.Response.Apply(res.Header)
+	}
+	return nil
+}
+
+// profiles returns the profiles that apply to req, in order.
+func (m *HeaderModifier) profiles(req *http.Request) []rules.HeaderProfile {
+	names := m.Global
+	if rule, ok := matchedRule(req); ok && len(rule.Headers) > 0 {
+		names = append(names[:len(names):len(names)], rule.Headers...)
+	}
+	var out []rules.HeaderProfile
+	for _, name := range names {
+		if hp, ok := m.Profiles.Lookup(name); ok {
+			out = append(out, hp)
+		}
+	}
+	return out
+}
@@ -0,0 +1,67 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	"github.com/standrze/rogue/internal/rules"
+)
+
+func TestHeaderProfiles(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Seen-Token", r.Header.Get("Authorization"))
+		w.Header().Set("X-Seen-Tracking", r.Header.Get("X-Tracking-Id"))
+		w.Header().Set("Server", "origin/1.0")
+	}))
+	defer origin.Close()
+
+	tmpDir := t.TempDir()
+	set := rules.NewSet(rules.Rule{Name: "api", Match: rules.Match{Path: "/api/*"}, Headers: []string{"add-auth-token"}})
+	p, sl := NewProxyServer(
+		WithCert(filepath.Join(tmpDir, "ca.crt"), filepath.Join(tmpDir, "ca.key")),
+		WithSessionDir(filepath.Join(tmpDir, "logs")),
+		WithRules(set),
+		WithHeaderProfiles(rules.Profiles{
+			"add-auth-token": {Request: rules.HeaderOps{Set: map[string]string{"Authorization": "Bearer test"}}},
+			"strip-tracking": {
+				Request:  rules.HeaderOps{Remove: []string{"X-Tracking-*"}},
+				Response: rules.HeaderOps{Remove: []string{"Server"}},
+			},
+		}, "strip-tracking"),
+	)
+	defer sl.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go p.Serve(l)
+	defer p.Close()
+
+	proxyURL, _ := url.Parse("http://" + l.Addr().String())
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+
+	get := func(path string) *http.Response {
+		req, _ := http.NewRequest("GET", origin.URL+path, nil)
+		req.Header.Set("X-Tracking-Id", "abc")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	resp := get("/api/users")
+	if resp.Header.Get("X-Seen-Token") != "Bearer test" || resp.Header.Get("X-Seen-Tracking") != "" || resp.Header.Get("Server") != "" {
+		t.Errorf("rule and global profiles not applied: %v", resp.Header)
+	}
+	resp = get("/other")
+	if resp.Header.Get("X-Seen-Token") != "" || resp.Header.Get("X-Seen-Tracking") != "" {
+		t.Errorf("only the global profile should apply: %v", resp.Header)
+	}
+}
@@ -0,0 +1,189 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/google/martian/v3"
+	"github.com/standrze/rogue/internal/logger"
+	"github.com/standrze/rogue/internal/metrics"
+)
+
+// Failure classes reported in hints.
+const (
+	FailureUntrustedCA    = "untrusted_ca"
+	FailureUpstreamVerify = "upstream_verify"
+	FailureDNS            = "dns"
+	FailureRefused        = "connection_refused"
+	FailureReset          = "connection_reset"
+	FailureTimeout        = "timeout"
+)
+
+const hintKey = "rogue.hint"
+
+// Hint is the likely cause of a failure and what to do about it.
+type Hint struct {
+	Class string
+	Cause string
+	Fix   string
+}
+
+var hints = map[string]Hint{
+	FailureUntrustedCA: {
+		Cause: "The client rejected the certificate minted by the proxy because it does not trust the Rogue CA.",
+		Fix:   "Install and trust the CA on the client (browse to http://rogue.proxy/ through the proxy). Apps that pin certificates cannot be intercepted.",
+	},
+	FailureUpstreamVerify: {
+		Cause: "The origin presented a certificate the proxy does not trust, such as a self-signed or private CA certificate or one issued for another name.",
+		Fix:   "Add the host to tls.skip_verify_hosts if the origin is trusted, or install its CA on the proxy host.",
+	},
+	FailureDNS: {
+		Cause: "The proxy could not resolve the origin's host name.",
+		Fix:   "Check the host name and the DNS configuration of the machine running the proxy.",
+	},
+	FailureRefused: {
+		Cause: "Nothing accepted the connection on the origin's port, or a firewall rejected it.",
+		Fix:   "Check that the origin service is running and reachable from the proxy host.",
+	},
+	FailureReset: {
+		Cause: "The origin or a middlebox closed the connection abruptly.",
+		Fix:   "Firewalls and origins that reject the TLS ClientHello often do this; try tls.upstream_fingerprint.",
+	},
+	FailureTimeout: {
+		Cause: "The origin did not respond in time.",
+		Fix:   "Check connectivity to the origin, or raise proxy.dial_timeout and proxy.response_header_timeout.",
+	},
+}
+
+// Diagnose classifies a failed upstream round trip, or with client set, a
+// failed handshake with an intercepted client.
+func Diagnose(err error, client bool) (Hint, bool) {
+	class := classify(err, client)
+	if class == "" {
+		return Hint{}, false
+	}
+	h := hints[class]
+	h.Class = class
+	return h, true
+}
+
+func classify(err error, client bool) string {
+	var dnsErr *net.DNSError
+	var verifyErr *tls.CertificateVerificationError
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	var invalid x509.CertificateInvalidError
+	var netErr net.Error
+	var opErr *net.OpError
+
+	switch {
+	case client:
+		if errors.As(err, &opErr) && opErr.Op == "remote error" && isCertificateAlert(opErr.Err) {
+			return FailureUntrustedCA
+		}
+		return ""
+	case errors.As(err, &verifyErr), errors.As(err, &unknownAuthority),
+		errors.As(err, &hostname), errors.As(err, &invalid):
+		return FailureUpstreamVerify
+	case errors.As(err, &dnsErr):
+		return FailureDNS
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return FailureRefused
+	case errors.Is(err, syscall.ECONNRESET):
+		return FailureReset
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return FailureTimeout
+	}
+	return ""
+}
+
+// isCertificateAlert reports whether a TLS alert from the client rejects the
+// certificate it was presented.
+func isCertificateAlert(alert error) bool {
+	msg := alert.Error()
+	for _, s := range []string{"unknown certificate authority", "bad certificate", "certificate unknown", "unknown certificate"} {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// troubleshooter records a hint entry for every diagnosed failure and
+// counts them by class.
+type troubleshooter struct {
+	logger *logger.SessionLogger
+	counts map[string]*atomic.Int64
+}
+
+func newTroubleshooter(sl *logger.SessionLogger) *troubleshooter {
+	t := &troubleshooter{logger: sl, counts: make(map[string]*atomic.Int64, len(hints))}
+	for class := range hints {
+		t.counts[class] = new(atomic.Int64)
+	}
+	return t
+}
+
+// report diagnoses err for the exchange of req and, if it is a known class,
+// logs it and keeps the hint on the context for error pages.
+func (t *troubleshooter) report(req *http.Request, err error, client bool) {
+	h, ok := Diagnose(err, client)
+	if !ok {
+		return
+	}
+	t.counts[h.Class].Add(1)
+
+	ctx := martian.NewContext(req)
+	if ctx != nil {
+		ctx.Set(hintKey, h)
+	}
+	if t.logger != nil {
+		t.logger.WriteEntry("hint", logger.HintLog{
+			Timestamp: time.Now(),
+			RequestID: requestID(req),
+			Host:      req.URL.Hostname(),
+			Class:     h.Class,
+			Error:     err.Error(),
+			Cause:     h.Cause,
+			Fix:       h.Fix,
+		})
+	}
+}
+
+func (t *troubleshooter) register(reg *metrics.Registry) {
+	for class, n := range t.counts {
+		reg.Register(`rogue_failures_total{class="`+class+`"}`, "Failed exchanges by diagnosed cause.", metrics.Counter,
+			func() float64 { return float64(n.Load()) })
+	}
+}
+
+// hintFor returns the hint reported for req, if any.
+func hintFor(req *http.Request) (Hint, bool) {
+	if ctx := martian.NewContext(req); ctx != nil {
+		if v, ok := ctx.Get(hintKey); ok {
+			return v.(Hint), true
+		}
+	}
+	return Hint{}, false
+}
+
+// hintingTransport reports failed round trips to a troubleshooter.
+type hintingTransport struct {
+	http.RoundTripper
+	t *troubleshooter
+}
+
+func (h *hintingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := h.RoundTripper.RoundTrip(req)
+	if err != nil {
+		h.t.report(req, err, false)
+	}
+	return res, err
+}
@@ -0,0 +1,101 @@
+package proxy
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/standrze/rogue/internal/logger"
+	"github.com/standrze/rogue/internal/metrics"
+	"github.com/standrze/rogue/internal/pages"
+)
+
+func TestDiagnose(t *testing.T) {
+	tests := []struct {
+		err    error
+		client bool
+		want   string
+	}{
+		{&net.OpError{Op: "remote error", Err: errors.New("tls: unknown certificate authority")}, true, FailureUntrustedCA},
+		{&net.OpError{Op: "remote error", Err: errors.New("tls: handshake failure")}, true, ""},
+		{fmt.Errorf("dial: %w", x509.UnknownAuthorityError{}), false, FailureUpstreamVerify},
+		{&net.DNSError{Err: "no such host", Name: "unbuilt.invalid", IsNotFound: true}, false, FailureDNS},
+		{io.ErrUnexpectedEOF, false, ""},
+	}
+	for _, tt := range tests {
+		h, ok := Diagnose(tt.err, tt.client)
+		if h.Class != tt.want || ok != (tt.want != "") {
+			t.Errorf("Diagnose(%v) = %q, want %q", tt.err, h.Class, tt.want)
+		}
+	}
+}
+
+func TestRefusedConnectionHint(t *testing.T) {
+	// Reserve a port and close it so connecting is refused.
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	origin := closed.Addr().String()
+	closed.Close()
+
+	set, err := pages.New("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg := metrics.NewRegistry()
+	tmpDir := t.TempDir()
+	sessionDir := filepath.Join(tmpDir, "logs")
+	p, sl := NewProxyServer(
+		WithCert(filepath.Join(tmpDir, "ca.crt"), filepath.Join(tmpDir, "ca.key")),
+		WithSessionDir(sessionDir),
+		WithPages(set, ""),
+		WithMetrics(reg),
+	)
+	defer sl.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go p.Serve(l)
+	defer p.Close()
+
+	proxyURL, _ := url.Parse("http://" + l.Addr().String())
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+	resp, err := client.Get("http://" + origin + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(body), "Likely cause") {
+		t.Errorf("Expected the error page to explain the failure, got %q", body)
+	}
+
+	entries, err := logger.ReadSession(filepath.Join(sessionDir, sl.GetSessionName()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var hint logger.HintLog
+	for _, e := range entries {
+		if e.Type == "hint" {
+			json.Unmarshal(e.Data, &hint)
+		}
+	}
+	if hint.Class != FailureRefused || hint.Fix == "" || hint.RequestID == "" {
+		t.Errorf("Expected a connection_refused hint, got %+v", hint)
+	}
+
+	if got := reg.Snapshot()[`rogue_failures_total{class="connection_refused"}`]; got != 1 {
+		t.Errorf("Expected one refused failure counted, got %v", got)
+	}
+}
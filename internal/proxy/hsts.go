@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/standrze/rogue/internal/logger"
+)
+
+// HSTS modes.
+const (
+	// HSTSStrip removes Strict-Transport-Security headers, so clients do
+	// not learn to insist on HTTPS.
+	HSTSStrip = "strip"
+	// HSTSExpire replaces them with max-age=0, so clients also forget
+	// what they learned before.
+	HSTSExpire = "expire"
+)
+
+// ParseHSTSMode checks an HSTS mode; "" leaves the header alone.
+func ParseHSTSMode(s string) (string, error) {
+	switch s {
+	case "", HSTSStrip, HSTSExpire:
+		return s, nil
+	}
+	return "", fmt.Errorf("unknown HSTS mode %q (want %s or %s)", s, HSTSStrip, HSTSExpire)
+}
+
+// WithHSTS strips or expires the Strict-Transport-Security headers of
+// responses. It weakens the clients behind the proxy and is for testing
+// only.
+func WithHSTS(mode string) ProxyOption {
+	return func(p *Proxy) {
+		p.HSTS = mode
+	}
+}
+
+// HSTSModifier rewrites Strict-Transport-Security headers according to
+// Mode, annotating the first rewritten response from each host.
+type HSTSModifier struct {
+	Mode   string
+	Logger *logger.SessionLogger
+
+	// seen holds the hosts already annotated.
+	seen sync.Map
+}
+
+func (m *HSTSModifier) ModifyResponse(res *http.Response) error {
+	policy := res.Header.Get("Strict-Transport-Security")
+	if policy == "" {
+		return nil
+	}
+	var done string
+	switch m.Mode {
+	case HSTSStrip:
+		res.Header.Del("Strict-Transport-Security")
+		done = "stripped"
+	case HSTSExpire:
+		res.Header.Set("Strict-Transport-Security", "max-age=0")
+		done = "expired"
+	default:
+		return nil
+	}
+
+	req := res.Request
+	if m.Logger == nil || req == nil {
+		return nil
+	}
+	if _, dup := m.seen.LoadOrStore(req.Host, true); dup {
+		return nil
+	}
+	m.Logger.WriteEntry("annotation", logger.Annotation{
+		Timestamp: time.Now(),
+		RequestID: requestID(req),
+		Comment:   fmt.Sprintf("hsts: %s the policy %q of %s", done, policy, req.Host),
+		Tags:      []string{"hsts"},
+	})
+	return nil
+}
@@ -0,0 +1,32 @@
+package proxy
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+)
+
+func TestHSTSModifier(t *testing.T) {
+	for _, tc := range []struct {
+		mode string
+		want []string
+	}{
+		{"", []string{"max-age=31536000; includeSubDomains"}},
+		{HSTSStrip, nil},
+		{HSTSExpire, []string{"max-age=0"}},
+	} {
+		res := &http.Response{Header: http.Header{}, Request: &http.Request{Host: "bank.test"}}
+		res.Header.Set("Strict-Transport-Security", "max-age=31536000; includeSubDomains")
+		m := &HSTSModifier{Mode: tc.mode}
+		if err := m.ModifyResponse(res); err != nil {
+			t.Fatal(err)
+		}
+		if got := res.Header.Values("Strict-Transport-Security"); !slices.Equal(got, tc.want) {
+			t.Errorf("mode %q: Strict-Transport-Security = %q, want %q", tc.mode, got, tc.want)
+		}
+	}
+
+	if _, err := ParseHSTSMode("downgrade"); err == nil {
+		t.Error("ParseHSTSMode accepted an unknown mode")
+	}
+}
@@ -0,0 +1,58 @@
+package proxy
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// defaultRequestTimeout is the idle limit on client connections when
+// Timeouts.Request is zero, martian's own default.
+const defaultRequestTimeout = 5 * time.Minute
+
+// idleListener wraps accepted connections in idleConn.
+type idleListener struct {
+	net.Listener
+	timeout time.Duration
+}
+
+func (l *idleListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &idleConn{Conn: c, timeout: l.timeout}, nil
+}
+
+// idleConn turns martian's per-request deadline into an idle limit. Martian
+// sets a deadline a fixed time ahead before each request, which also bounds
+// writing the response, so a download or stream outlasting it was cut off.
+// Instead, once a deadline is set each read and write must make progress
+// within timeout; a zero deadline, as set by hijackers relaying long-lived
+// streams, lifts the limit.
+type idleConn struct {
+	net.Conn
+	timeout time.Duration
+	armed   atomic.Bool
+}
+
+func (c *idleConn) SetDeadline(t time.Time) error {
+	c.armed.Store(!t.IsZero())
+	return c.Conn.SetDeadline(time.Time{})
+}
+
+func (c *idleConn) Read(p []byte) (int, error) {
+	if c.armed.Load() {
+		c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+	}
+	return c.Conn.Read(p)
+}
+
+func (c *idleConn) Write(p []byte) (int, error) {
+	if c.armed.Load() {
+		c.Conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	}
+	return c.Conn.Write(p)
+}
+
+func (c *idleConn) NetConn() net.Conn { return c.Conn }
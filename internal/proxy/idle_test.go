@@ -0,0 +1,139 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// idleProxy starts a proxy on a main listener with the given request
+// timeout and returns its address.
+func idleProxy(t *testing.T, timeout time.Duration, opts ...ProxyOption) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	main := &Listener{Mode: ModeHTTP, Listener: l}
+	tmpDir := t.TempDir()
+	p, sl := NewProxyServer(append([]ProxyOption{
+		WithCert(filepath.Join(tmpDir, "ca.crt"), filepath.Join(tmpDir, "ca.key")),
+		WithSessionDir(filepath.Join(tmpDir, "logs")),
+		WithMainListener(main),
+		WithTimeouts(Timeouts{Request: timeout}),
+	}, opts...)...)
+	go p.Serve(main.Listener)
+	t.Cleanup(func() {
+		p.Close()
+		sl.Close()
+	})
+	return l.Addr().String()
+}
+
+func TestRequestTimeoutSlowBody(t *testing.T) {
+	// The body takes well over the timeout but never stalls for long.
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := range 12 {
+			fmt.Fprintf(w, "chunk %d\n", i)
+			w.(http.Flusher).Flush()
+			time.Sleep(250 * time.Millisecond)
+		}
+	}))
+	defer origin.Close()
+
+	addr := idleProxy(t, time.Second)
+	proxyURL, _ := url.Parse("http://" + addr)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+	resp, err := client.Get(origin.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || len(body) != 12*len("chunk 0\n")+2 {
+		t.Fatalf("body = %d bytes, %v", len(body), err)
+	}
+}
+
+func TestRequestTimeoutIdle(t *testing.T) {
+	addr := idleProxy(t, 500*time.Millisecond)
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// A client that never sends a request is dropped.
+	start := time.Now()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("read = %v, want EOF", err)
+	}
+	if d := time.Since(start); d < 400*time.Millisecond || d > 3*time.Second {
+		t.Errorf("closed after %v", d)
+	}
+}
+
+func TestTunnelOutlivesRequestTimeout(t *testing.T) {
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		for {
+			c, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				io.Copy(c, c)
+			}()
+		}
+	}()
+
+	for _, tc := range []struct {
+		name  string
+		opts  []ProxyOption
+		first string
+	}{
+		{"passthrough", []ProxyOption{WithTLSPolicy(TLSPolicy{PassthroughHosts: []string{"127.0.0.*"}})}, "hello"},
+		// Neither TLS nor HTTP, relayed by the intercepting path.
+		{"not_http", nil, "\x00hello"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", idleProxy(t, 500*time.Millisecond, tc.opts...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(10 * time.Second))
+			fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %[1]s\r\n\r\n", echo.Addr())
+			r := bufio.NewReader(conn)
+			resp, err := http.ReadResponse(r, nil)
+			if err != nil || resp.StatusCode != http.StatusOK {
+				t.Fatalf("CONNECT: %v %v", resp, err)
+			}
+
+			// Idle for longer than the timeout between exchanges.
+			for _, msg := range []string{tc.first, "again"} {
+				if _, err := io.WriteString(conn, msg); err != nil {
+					t.Fatal(err)
+				}
+				got := make([]byte, len(msg))
+				if _, err := io.ReadFull(r, got); err != nil || string(got) != msg {
+					t.Fatalf("echo = %q, %v", got, err)
+				}
+				time.Sleep(time.Second)
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package proxy
+
+import (
+	"slices"
+	"sync"
+)
+
+// Interception switches TLS interception at runtime, on top of the static
+// TLSPolicy.PassthroughHosts. It is safe for concurrent use.
+type Interception struct {
+	mu          sync.RWMutex
+	disabled    bool
+	passthrough []string
+}
+
+// WithInterception lets i decide which tunnels are intercepted.
+func WithInterception(i *Interception) ProxyOption {
+	return func(p *Proxy) {
+		p.Interception = i
+	}
+}
+
+// Get returns whether tunnels are intercepted and the host glob patterns
+// relayed without interception in addition to the configured ones.
+func (i *Interception) Get() (enabled bool, passthrough []string) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return !i.disabled, slices.Clone(i.passthrough)
+}
+
+// Set turns interception on or off and replaces the runtime passthrough
+// hosts.
+func (i *Interception) Set(enabled bool, passthrough []string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.disabled = !enabled
+	i.passthrough = slices.Clone(passthrough)
+}
+
+// intercepts reports whether tunnels to host are intercepted.
+func (i *Interception) intercepts(host string) bool {
+	if i == nil {
+		return true
+	}
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return !i.disabled && !matchHost(i.passthrough, host)
+}
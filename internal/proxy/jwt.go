@@ -0,0 +1,57 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/standrze/rogue/internal/jwt"
+	"github.com/standrze/rogue/internal/logger"
+)
+
+// JWTModifier re-signs the JWT of requests whose rule has a jwt action.
+// Like CookieModifier it runs after the request is logged, so the log holds
+// the token the client sent; an annotation records the one forwarded.
+type JWTModifier struct {
+	Logger *logger.SessionLogger
+}
+
+func (m *JWTModifier) ModifyRequest(req *http.Request) error {
+	rule, ok := matchedRule(req)
+	if !ok || rule.JWT == nil || req.Method == http.MethodConnect {
+		return nil
+	}
+	edit := rule.JWT
+	where := "Authorization header"
+	if edit.Cookie != "" {
+		where = "cookie " + edit.Cookie
+	}
+	t, ok := jwt.Find(req.Header, edit.Cookie)
+	if !ok {
+		m.note(req, fmt.Sprintf("jwt: rule %q found no token in the %s", rule.Name, where))
+		return nil
+	}
+	key, err := edit.SigningKey()
+	if err == nil {
+		var signed string
+		signed, err = jwt.Resign(t, jwt.Edit{Alg: edit.Alg, Header: edit.Header, Claims: edit.Claims}, key)
+		if err == nil {
+			jwt.Replace(req.Header, edit.Cookie, t.Raw, signed)
+			m.note(req, fmt.Sprintf("jwt: rule %q re-signed the token in the %s: %s", rule.Name, where, signed))
+			return nil
+		}
+	}
+	m.note(req, fmt.Sprintf("jwt: rule %q could not re-sign the token in the %s: %v", rule.Name, where, err))
+	return nil
+}
+
+func (m *JWTModifier) note(req *http.Request, comment string) {
+	if m.Logger == nil {
+		return
+	}
+	m.Logger.WriteEntry("annotation", logger.Annotation{
+		Timestamp: time.Now(),
+		RequestID: requestID(req),
+		Comment:   comment,
+	})
+}
@@ -0,0 +1,94 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/standrze/rogue/internal/jwt"
+	"github.com/standrze/rogue/internal/logger"
+	"github.com/standrze/rogue/internal/rules"
+)
+
+func TestJWTRule(t *testing.T) {
+	got := make(chan string, 1)
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got <- r.Header.Get("Authorization")
+	}))
+	defer origin.Close()
+	originURL, _ := url.Parse(origin.URL)
+
+	tmpDir := t.TempDir()
+	sessionDir := filepath.Join(tmpDir, "logs")
+	set := rules.NewSet(rules.Rule{
+		Name:  "admin",
+		Match: rules.Match{Host: originURL.Hostname()},
+		JWT:   &rules.JWT{Claims: map[string]any{"role": "admin"}, Key: "secret"},
+	})
+	p, sl := NewProxyServer(
+		WithCert(filepath.Join(tmpDir, "ca.crt"), filepath.Join(tmpDir, "ca.key")),
+		WithSessionDir(sessionDir),
+		WithRules(set),
+	)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go p.Serve(l)
+	defer p.Close()
+
+	const sent = "eyJhbGciOiJIUzI1NiJ9.eyJzdWIiOiIxIiwicm9sZSI6InVzZXIifQ.sig"
+	proxyURL, _ := url.Parse("http://" + l.Addr().String())
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+	req, _ := http.NewRequest("GET", origin.URL+"/me", nil)
+	req.Header.Set("Authorization", "Bearer "+sent)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	sl.Close()
+
+	auth := <-got
+	tok, err := jwt.Parse(strings.TrimPrefix(auth, "Bearer "))
+	if err != nil {
+		t.Fatalf("the origin got %q: %v", auth, err)
+	}
+	if string(tok.Claims) != `{"role":"admin","sub":"1"}` || tok.Signature == "sig" {
+		t.Errorf("the origin got claims %s signed %q", tok.Claims, tok.Signature)
+	}
+
+	entries, err := logger.ReadSession(filepath.Join(sessionDir, sl.GetSessionName()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	flows, err := logger.BuildFlows(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(flows) != 1 || flows[0].Request == nil {
+		t.Fatalf("flows = %+v", flows)
+	}
+	f := flows[0]
+	jwts := f.Request.JWTs
+	if len(jwts) != 1 || jwts[0].Source != "Authorization" || jwts[0].Token != sent {
+		t.Fatalf("logged JWTs = %+v", jwts)
+	}
+	var claims bytes.Buffer
+	json.Compact(&claims, jwts[0].Claims)
+	if claims.String() != `{"sub":"1","role":"user"}` {
+		t.Errorf("logged claims = %s", claims.String())
+	}
+	if len(f.Annotations) != 1 || !strings.Contains(f.Annotations[0].Comment, `rule "admin" re-signed the token in the Authorization header: `+tok.Raw) {
+		t.Errorf("annotations = %+v", f.Annotations)
+	}
+}
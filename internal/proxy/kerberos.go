@@ -0,0 +1,98 @@
+package proxy
+
+import (
+	"cmp"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/jcmturner/gokrb5/v8/client"
+	krbconfig "github.com/jcmturner/gokrb5/v8/config"
+	"github.com/jcmturner/gokrb5/v8/credentials"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+	"github.com/jcmturner/gokrb5/v8/types"
+)
+
+// Kerberos locates the credentials Negotiate authenticates with.
+type Kerberos struct {
+	// Config is the krb5.conf naming the realms and their KDCs;
+	// KRB5_CONFIG or /etc/krb5.conf by default.
+	Config string
+	// Keytab, if set, holds the keys of Username. Otherwise Password is
+	// used if set, or else the tickets kinit left in CCache, by default
+	// KRB5CCNAME or /tmp/krb5cc_<uid>.
+	Keytab string
+	CCache string
+	// SPN is the proxy's service principal, HTTP/<proxy host> by default.
+	SPN string
+}
+
+// spn returns the service principal tickets for the proxy are issued to.
+func (c UpstreamProxy) spn() string {
+	host, _, _ := net.SplitHostPort(c.Address)
+	return cmp.Or(c.Kerberos.SPN, "HTTP/"+host)
+}
+
+// kerberosClient loads the configured credentials. The KDC is only
+// contacted once the first tunnel needs a ticket.
+func (c UpstreamProxy) kerberosClient() (*client.Client, error) {
+	k := c.Kerberos
+	path := cmp.Or(k.Config, os.Getenv("KRB5_CONFIG"), "/etc/krb5.conf")
+	cfg, err := krbconfig.Load(path)
+	if err != nil {
+		return nil, fmt.Errorf("kerberos config %s: %w", path, err)
+	}
+	// Active Directory KDCs reject the FAST pre-authentication gokrb5
+	// offers by default.
+	noFAST := client.DisablePAFXFAST(true)
+
+	if k.Keytab == "" && c.Password == "" {
+		path := cmp.Or(k.CCache, strings.TrimPrefix(os.Getenv("KRB5CCNAME"), "FILE:"), fmt.Sprintf("/tmp/krb5cc_%d", os.Getuid()))
+		cc, err := credentials.LoadCCache(path)
+		if err != nil {
+			return nil, fmt.Errorf("kerberos credential cache %s: %w", path, err)
+		}
+		return client.NewFromCCache(cc, cfg, noFAST)
+	}
+
+	user, realm, _ := strings.Cut(c.Username, "@")
+	realm = cmp.Or(realm, cfg.LibDefaults.DefaultRealm)
+	if realm == "" {
+		return nil, fmt.Errorf("kerberos: no realm; give the username as %s@REALM or set default_realm in %s", user, path)
+	}
+	if k.Keytab != "" {
+		kt, err := keytab.Load(k.Keytab)
+		if err != nil {
+			return nil, fmt.Errorf("kerberos keytab %s: %w", k.Keytab, err)
+		}
+		return client.NewWithKeytab(user, realm, kt, cfg, noFAST), nil
+	}
+	return client.NewWithPassword(user, realm, c.Password, cfg, noFAST), nil
+}
+
+// kerberosNegotiator returns the Negotiate tokens of cl, logging in and
+// fetching service tickets as needed.
+func kerberosNegotiator(cl *client.Client) func(spn string) ([]byte, error) {
+	return func(spn string) ([]byte, error) {
+		if err := cl.AffirmLogin(); err != nil {
+			return nil, err
+		}
+		tkt, key, err := cl.GetServiceTicket(spn)
+		if err != nil {
+			return nil, err
+		}
+		return negotiateToken(cl, tkt, key)
+	}
+}
+
+// negotiateToken wraps a Kerberos AP-REQ for tkt in an SPNEGO token.
+func negotiateToken(cl *client.Client, tkt messages.Ticket, key types.EncryptionKey) ([]byte, error) {
+	neg, err := spnego.NewNegTokenInitKRB5(cl, tkt, key)
+	if err != nil {
+		return nil, err
+	}
+	return (&spnego.SPNEGOToken{Init: true, NegTokenInit: neg}).Marshal()
+}
@@ -0,0 +1,374 @@
+package proxy
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/martian/v3"
+	"github.com/standrze/rogue/internal/metrics"
+	"github.com/standrze/rogue/internal/rules"
+)
+
+const rateLimitedKey = "rogue.limits.retry"
+
+// Rate limit policies.
+const (
+	RateLimitReject = "reject"
+	RateLimitDelay  = "delay"
+)
+
+// bandwidthChunk bounds the bytes a throttled connection moves at once, so
+// connections sharing the bandwidth cap take turns.
+const bandwidthChunk = 16 << 10
+
+// clientIdle is how long a client's request budget is kept after its last
+// request.
+const clientIdle = 5 * time.Minute
+
+// Limits keeps one runaway client from overwhelming a shared proxy. Zero
+// values disable a limit.
+type Limits struct {
+	// MaxConnections bounds the client connections open at once across
+	// all listeners. Further connections wait in the listen backlog.
+	MaxConnections int
+	// MaxConnectionsPerClient bounds the connections open at once from one
+	// client address. Further connections are closed.
+	MaxConnectionsPerClient int
+	// RequestRate is the requests per second each client address may send,
+	// with bursts of up to RequestBurst (default: one second's worth).
+	RequestRate  float64
+	RequestBurst int
+	// RatePolicy is RateLimitReject, answering requests over the rate with
+	// 429 Too Many Requests, or RateLimitDelay, holding them until the
+	// client is within its rate again.
+	RatePolicy string
+	// Bandwidth caps the bytes per second read from and written to all
+	// clients together.
+	Bandwidth int64
+}
+
+// Limiter enforces Limits on the listeners it wraps and the requests it
+// sees. Connection and bandwidth limits need the proxy's listeners to be
+// wrapped with Listener; request limits need WithLimiter.
+type Limiter struct {
+	limits Limits
+
+	slots     chan struct{}
+	bandwidth *bucket
+
+	mu      sync.Mutex
+	conns   map[string]int
+	clients map[string]*bucket
+	pruned  time.Time
+
+	open, refused, limited, delayed atomic.Int64
+}
+
+// NewLimiter returns a limiter for l.
+func NewLimiter(l Limits) (*Limiter, error) {
+	switch l.RatePolicy {
+	case "":
+		l.RatePolicy = RateLimitReject
+	case RateLimitReject, RateLimitDelay:
+	default:
+		return nil, fmt.Errorf("unknown rate limit policy %q (want %q or %q)", l.RatePolicy, RateLimitReject, RateLimitDelay)
+	}
+	if l.MaxConnections < 0 || l.MaxConnectionsPerClient < 0 || l.RequestRate < 0 || l.RequestBurst < 0 || l.Bandwidth < 0 {
+		return nil, fmt.Errorf("limits must not be negative")
+	}
+	if l.RequestRate > 0 && l.RequestBurst == 0 {
+		l.RequestBurst = max(int(math.Ceil(l.RequestRate)), 1)
+	}
+
+	lim := &Limiter{limits: l, conns: map[string]int{}, clients: map[string]*bucket{}}
+	if l.MaxConnections > 0 {
+		lim.slots = make(chan struct{}, l.MaxConnections)
+	}
+	if l.Bandwidth > 0 {
+		lim.bandwidth = newBucket(float64(l.Bandwidth), float64(min(l.Bandwidth, bandwidthChunk)))
+	}
+	return lim, nil
+}
+
+// WithLimiter applies the request rate limits of lim. Its connection and
+// bandwidth limits also apply to additional listeners.
+func WithLimiter(lim *Limiter) ProxyOption {
+	return func(p *Proxy) {
+		p.Limiter = lim
+	}
+}
+
+// Listener applies the connection and bandwidth limits to connections
+// accepted from l.
+func (lim *Limiter) Listener(l net.Listener) net.Listener {
+	if lim == nil || lim.slots == nil && lim.limits.MaxConnectionsPerClient == 0 && lim.bandwidth == nil {
+		return l
+	}
+	return &limitListener{Listener: l, lim: lim}
+}
+
+type limitListener struct {
+	net.Listener
+	lim *Limiter
+}
+
+func (l *limitListener) Accept() (net.Conn, error) {
+	lim := l.lim
+	for {
+		if lim.slots != nil {
+			lim.slots <- struct{}{}
+		}
+		c, err := l.Listener.Accept()
+		if err != nil {
+			lim.release()
+			return nil, err
+		}
+		client := clientHost(c.RemoteAddr().String())
+		if !lim.admit(client) {
+			lim.release()
+			lim.refused.Add(1)
+			c.Close()
+			continue
+		}
+		lim.open.Add(1)
+		return &limitConn{Conn: c, lim: lim, client: client}, nil
+	}
+}
+
+// admit counts a connection from client, reporting false when the client
+// already has as many as it may.
+func (lim *Limiter) admit(client string) bool {
+	if lim.limits.MaxConnectionsPerClient == 0 {
+		return true
+	}
+	lim.mu.Lock()
+	defer lim.mu.Unlock()
+	if lim.conns[client] >= lim.limits.MaxConnectionsPerClient {
+		return false
+	}
+	lim.conns[client]++
+	return true
+}
+
+// release frees a connection slot taken in Accept.
+func (lim *Limiter) release() {
+	if lim.slots != nil {
+		<-lim.slots
+	}
+}
+
+// limitConn gives back its slots when closed and shares the bandwidth cap.
+type limitConn struct {
+	net.Conn
+	lim    *Limiter
+	client string
+	once   sync.Once
+}
+
+func (c *limitConn) NetConn() net.Conn { return c.Conn }
+
+func (c *limitConn) Read(p []byte) (int, error) {
+	if c.lim.bandwidth == nil {
+		return c.Conn.Read(p)
+	}
+	n, err := c.Conn.Read(p[:min(len(p), bandwidthChunk)])
+	if n > 0 {
+		time.Sleep(c.lim.bandwidth.take(float64(n), time.Now()))
+	}
+	return n, err
+}
+
+func (c *limitConn) Write(p []byte) (int, error) {
+	if c.lim.bandwidth == nil {
+		return c.Conn.Write(p)
+	}
+	written := 0
+	for len(p) > 0 {
+		chunk := p[:min(len(p), bandwidthChunk)]
+		time.Sleep(c.lim.bandwidth.take(float64(len(chunk)), time.Now()))
+		n, err := c.Conn.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+func (c *limitConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(func() {
+		lim := c.lim
+		lim.open.Add(-1)
+		lim.release()
+		if lim.limits.MaxConnectionsPerClient > 0 {
+			lim.mu.Lock()
+			if lim.conns[c.client]--; lim.conns[c.client] <= 0 {
+				delete(lim.conns, c.client)
+			}
+			lim.mu.Unlock()
+		}
+	})
+	return err
+}
+
+// wait charges a request from client against its rate and returns how long
+// the client must wait before sending it.
+func (lim *Limiter) wait(client string, now time.Time) time.Duration {
+	lim.mu.Lock()
+	defer lim.mu.Unlock()
+	if now.Sub(lim.pruned) > clientIdle {
+		for k, b := range lim.clients {
+			if now.Sub(b.last) > clientIdle {
+				delete(lim.clients, k)
+			}
+		}
+		lim.pruned = now
+	}
+	b, ok := lim.clients[client]
+	if !ok {
+		b = newBucket(lim.limits.RequestRate, float64(lim.limits.RequestBurst))
+		lim.clients[client] = b
+	}
+	if lim.limits.RatePolicy == RateLimitDelay {
+		return b.take(1, now)
+	}
+	return b.try(1, now)
+}
+
+// ModifyRequest holds or refuses requests from clients over their rate.
+// Refused CONNECT requests are answered at once, since no tunnel can be
+// opened for them; other refused requests are answered by ModifyResponse,
+// so they are logged like any other exchange.
+func (lim *Limiter) ModifyRequest(req *http.Request) error {
+	if lim.limits.RequestRate == 0 {
+		return nil
+	}
+	ctx := martian.NewContext(req)
+	if ctx == nil {
+		return nil
+	}
+	d := lim.wait(clientHost(req.RemoteAddr), time.Now())
+	if d <= 0 {
+		return nil
+	}
+
+	if lim.limits.RatePolicy == RateLimitDelay {
+		lim.delayed.Add(1)
+		t := time.NewTimer(d)
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-req.Context().Done():
+		}
+		return nil
+	}
+
+	lim.limited.Add(1)
+	retry := strconv.Itoa(int(math.Ceil(d.Seconds())))
+	if req.Method == http.MethodConnect {
+		conn, brw, err := ctx.Session().Hijack()
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		fmt.Fprintf(brw, "HTTP/1.1 429 Too Many Requests\r\nRetry-After: %s\r\nContent-Length: 0\r\nConnection: close\r\n\r\n", retry)
+		return brw.Flush()
+	}
+	ctx.Set(rateLimitedKey, retry)
+	ctx.SkipRoundTrip()
+	return nil
+}
+
+// ModifyResponse answers refused requests with 429 Too Many Requests. It
+// runs after the modifiers that could change the response, so the client
+// and the log see the refusal.
+func (lim *Limiter) ModifyResponse(res *http.Response) error {
+	if res.Request == nil {
+		return nil
+	}
+	ctx := martian.NewContext(res.Request)
+	if ctx == nil {
+		return nil
+	}
+	if v, ok := ctx.Get(rateLimitedKey); ok {
+		applyMock(res, &rules.Mock{
+			Status:  http.StatusTooManyRequests,
+			Headers: map[string]string{"Content-Type": "text/plain; charset=utf-8", "Retry-After": v.(string)},
+			Body:    "Too many requests from this client; retry after " + v.(string) + "s\n",
+		})
+	}
+	return nil
+}
+
+// clientHost is the address connections and requests are counted by.
+func clientHost(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// bucket is a token bucket refilled at rate tokens per second up to burst.
+type bucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newBucket(rate, burst float64) *bucket {
+	return &bucket{rate: rate, burst: burst, tokens: burst}
+}
+
+func (b *bucket) refill(now time.Time) {
+	if !b.last.IsZero() {
+		b.tokens = min(b.tokens+now.Sub(b.last).Seconds()*b.rate, b.burst)
+	}
+	b.last = now
+}
+
+// take removes n tokens, going into debt if needed, and returns how long
+// to wait until the debt is paid.
+func (b *bucket) take(n float64, now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(now)
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// try removes n tokens if there are enough. Otherwise it returns how long
+// until there will be.
+func (b *bucket) try(n float64, now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(now)
+	if b.tokens >= n {
+		b.tokens -= n
+		return 0
+	}
+	return max(time.Duration((n-b.tokens)/b.rate*float64(time.Second)), time.Nanosecond)
+}
+
+func registerLimitMetrics(reg *metrics.Registry, lim *Limiter) {
+	reg.Register("rogue_limit_connections", "Client connections open under the connection limits.", metrics.Gauge,
+		func() float64 { return float64(lim.open.Load()) })
+	reg.Register("rogue_limit_refused_connections_total", "Client connections closed for exceeding the per-client limit.", metrics.Counter,
+		func() float64 { return float64(lim.refused.Load()) })
+	reg.Register("rogue_limit_rejected_requests_total", "Requests answered with 429 for exceeding the request rate.", metrics.Counter,
+		func() float64 { return float64(lim.limited.Load()) })
+	reg.Register("rogue_limit_delayed_requests_total", "Requests held back for exceeding the request rate.", metrics.Counter,
+		func() float64 { return float64(lim.delayed.Load()) })
+}
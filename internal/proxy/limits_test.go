@@ -0,0 +1,141 @@
+package proxy
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBucket(t *testing.T) {
+	now := time.Now()
+	b := newBucket(2, 2)
+	for i := range 2 {
+		if d := b.try(1, now); d != 0 {
+			t.Fatalf("Request %d waited %v within the burst", i, d)
+		}
+	}
+	if d := b.try(1, now); d != 500*time.Millisecond {
+		t.Errorf("Over the burst: wait %v, want 500ms", d)
+	}
+	if d := b.try(1, now.Add(500*time.Millisecond)); d != 0 {
+		t.Errorf("After refilling: wait %v", d)
+	}
+	if d := b.take(3, now.Add(500*time.Millisecond)); d != 1500*time.Millisecond {
+		t.Errorf("Debt: wait %v, want 1.5s", d)
+	}
+}
+
+func TestLimiterConnections(t *testing.T) {
+	lim, err := NewLimiter(Limits{MaxConnections: 2, MaxConnectionsPerClient: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := lim.Listener(inner)
+	defer l.Close()
+
+	dial := func() net.Conn {
+		c, err := net.Dial("tcp", inner.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	c1, c2 := dial(), dial()
+	defer c1.Close()
+	defer c2.Close()
+
+	accepted := make(chan net.Conn)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				close(accepted)
+				return
+			}
+			accepted <- c
+		}
+	}()
+	first := <-accepted
+
+	// The second connection comes from the same address and is closed.
+	c2.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := c2.Read(make([]byte, 1)); err == nil {
+		t.Error("Second connection from the client was not closed")
+	}
+	select {
+	case <-accepted:
+		t.Fatal("Connection over the per-client limit accepted")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	first.Close()
+	c3 := dial()
+	defer c3.Close()
+	select {
+	case c := <-accepted:
+		c.Close()
+	case <-time.After(2 * time.Second):
+		t.Fatal("Connection not accepted after the first closed")
+	}
+}
+
+func TestLimiterRate(t *testing.T) {
+	if _, err := NewLimiter(Limits{RatePolicy: "queue"}); err == nil {
+		t.Error("Unknown policy accepted")
+	}
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	defer origin.Close()
+
+	lim, err := NewLimiter(Limits{RequestRate: 0.1, RequestBurst: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpDir := t.TempDir()
+	p, sl := NewProxyServer(
+		WithCert(filepath.Join(tmpDir, "ca.crt"), filepath.Join(tmpDir, "ca.key")),
+		WithSessionDir(filepath.Join(tmpDir, "logs")),
+		WithLimiter(lim),
+	)
+	defer sl.Close()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go p.Serve(lim.Listener(l))
+	defer p.Close()
+
+	proxyURL, _ := url.Parse("http://" + l.Addr().String())
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+	for i, want := range []int{200, 200, 429} {
+		res, err := client.Get(origin.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != want {
+			t.Errorf("Request %d: status %d, want %d", i, res.StatusCode, want)
+		}
+		if want == 429 && res.Header.Get("Retry-After") != "10" {
+			t.Errorf("Retry-After = %q, want 10", res.Header.Get("Retry-After"))
+		}
+	}
+
+	// CONNECT requests over the rate are refused before a tunnel opens.
+	_, err = client.Get("https://" + origin.Listener.Addr().String())
+	if err == nil || !strings.Contains(err.Error(), "Too Many Requests") {
+		t.Errorf("CONNECT over the rate: %v", err)
+	}
+}
@@ -0,0 +1,200 @@
+package proxy
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/standrze/rogue/internal/mitm"
+)
+
+// Listener modes.
+const (
+	// ModeHTTP accepts explicit proxy requests in plain HTTP.
+	ModeHTTP = "http"
+	// ModeHTTPS accepts explicit proxy requests over TLS to the proxy.
+	ModeHTTPS = "https"
+	// ModeTransparent accepts traffic redirected to the proxy, such as by
+	// iptables, and intercepts TLS using the SNI name.
+	ModeTransparent = "transparent"
+)
+
+// sniffTimeout bounds waiting for a transparent client's first byte.
+const sniffTimeout = 10 * time.Second
+
+// Listener is an additional socket the proxy accepts clients on. All
+// listeners share the modifier pipeline and session log.
+type Listener struct {
+	Mode     string
+	Listener net.Listener
+	// Certificate is presented by HTTPS listeners. When nil, one is minted
+	// from the CA for the name clients connect with.
+	Certificate *tls.Certificate
+	// ClientCAs, if set, makes an HTTPS listener require a client
+	// certificate signed by one of them.
+	ClientCAs *x509.CertPool
+}
+
+// WithMainListener has the proxy prepare l, the listener the caller serves
+// itself, for l.Mode: once NewProxyServer returns, l.Listener terminates TLS
+// for an HTTPS listener or classifies redirected traffic for a transparent
+// one.
+func WithMainListener(l *Listener) ProxyOption {
+	return func(p *Proxy) {
+		p.MainListener = l
+	}
+}
+
+// ParseListenMode validates a listener mode. Empty means ModeHTTP.
+func ParseListenMode(s string) (string, error) {
+	switch m := strings.ToLower(s); m {
+	case "":
+		return ModeHTTP, nil
+	case ModeHTTP, ModeHTTPS, ModeTransparent:
+		return m, nil
+	}
+	return "", fmt.Errorf("unknown listener mode %q (want http, https or transparent)", s)
+}
+
+// wrap returns the listener the proxy serves for l, whose connections may
+// idle for timeout.
+func (l Listener) wrap(mc *mitm.Config, timeout time.Duration) (net.Listener, error) {
+	mode, err := ParseListenMode(l.Mode)
+	if err != nil {
+		return nil, err
+	}
+
+	if l.ClientCAs != nil && mode != ModeHTTPS {
+		return nil, fmt.Errorf("client certificates need an https listener, not %s", mode)
+	}
+
+	// Below TLS, so martian still sees the *tls.Conn of transparent
+	// clients and the deadlines apply to the handshake too.
+	l.Listener = &idleListener{Listener: l.Listener, timeout: timeout}
+
+	host, _, _ := net.SplitHostPort(l.Listener.Addr().String())
+	switch mode {
+	case ModeHTTPS:
+		cfg := mc.TLSForHost(host)
+		if l.Certificate != nil {
+			cfg.GetCertificate = nil
+			cfg.Certificates = []tls.Certificate{*l.Certificate}
+		}
+		if l.ClientCAs != nil {
+			cfg.ClientCAs = l.ClientCAs
+			cfg.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+		return &proxyTLSListener{Listener: l.Listener, config: cfg}, nil
+	case ModeTransparent:
+		return newTransparentListener(l.Listener, mc), nil
+	}
+	return l.Listener, nil
+}
+
+// proxyTLSListener terminates TLS from clients that speak to the proxy
+// itself over TLS. Connections are wrapped so martian does not treat the
+// session as intercepted HTTPS and rewrite http:// requests to https://.
+type proxyTLSListener struct {
+	net.Listener
+	config *tls.Config
+}
+
+type proxyTLSConn struct {
+	net.Conn
+}
+
+func (l *proxyTLSListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return proxyTLSConn{tls.Server(c, l.config)}, nil
+}
+
+// transparentListener classifies redirected connections by their first
+// byte, terminating TLS with a certificate for the SNI name and passing
+// plaintext through. Requests then carry the origin in the Host header.
+type transparentListener struct {
+	net.Listener
+	mc    *mitm.Config
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+	err   error
+}
+
+func newTransparentListener(l net.Listener, mc *mitm.Config) *transparentListener {
+	t := &transparentListener{
+		Listener: l,
+		mc:       mc,
+		conns:    make(chan net.Conn),
+		done:     make(chan struct{}),
+	}
+	go t.acceptLoop()
+	return t
+}
+
+func (t *transparentListener) acceptLoop() {
+	for {
+		c, err := t.Listener.Accept()
+		if err != nil {
+			t.stop(err)
+			return
+		}
+		// Classification waits for the client, so it must not hold up
+		// accepting others.
+		go t.classify(c)
+	}
+}
+
+func (t *transparentListener) classify(c net.Conn) {
+	br := bufio.NewReader(c)
+	c.SetReadDeadline(time.Now().Add(sniffTimeout))
+	first, err := br.Peek(1)
+	c.SetReadDeadline(time.Time{})
+	if err != nil {
+		c.Close()
+		return
+	}
+
+	var conn net.Conn = &tunnelConn{Conn: c, r: br, done: make(chan struct{})}
+	// 22 is the TLS handshake record type. Clients that send no SNI get a
+	// certificate for the address they connected to.
+	if first[0] == 22 {
+		host, _, _ := net.SplitHostPort(c.LocalAddr().String())
+		conn = tls.Server(conn, t.mc.TLSForHost(host))
+	}
+
+	select {
+	case t.conns <- conn:
+	case <-t.done:
+		c.Close()
+	}
+}
+
+func (t *transparentListener) stop(err error) {
+	t.once.Do(func() {
+		t.err = err
+		close(t.done)
+	})
+}
+
+func (t *transparentListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-t.conns:
+		return c, nil
+	case <-t.done:
+		return nil, t.err
+	}
+}
+
+func (t *transparentListener) Close() error {
+	err := t.Listener.Close()
+	t.stop(net.ErrClosed)
+	return err
+}
@@ -0,0 +1,193 @@
+package proxy
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/standrze/rogue/internal/rules"
+)
+
+func TestListenerModes(t *testing.T) {
+	tmpDir := t.TempDir()
+	certPath := filepath.Join(tmpDir, "ca.crt")
+	set := rules.NewSet(rules.Rule{
+		Match: rules.Match{Host: "unbuilt.invalid", Path: "/status"},
+		Mock:  &rules.Mock{Status: 202, Body: "mocked"},
+	})
+
+	listen := func() net.Listener {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return l
+	}
+	httpsL, transparentL := listen(), listen()
+
+	p, sl := NewProxyServer(
+		WithCert(certPath, filepath.Join(tmpDir, "ca.key")),
+		WithSessionDir(filepath.Join(tmpDir, "logs")),
+		WithRules(set),
+		WithListeners(
+			Listener{Mode: ModeHTTPS, Listener: httpsL},
+			Listener{Mode: ModeTransparent, Listener: transparentL},
+		),
+	)
+	defer sl.Close()
+	defer p.Close()
+	defer httpsL.Close()
+	defer transparentL.Close()
+
+	caPEM, err := os.ReadFile(certPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(caPEM)
+
+	check := func(name string, resp *http.Response, err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != 202 || string(body) != "mocked" {
+			t.Errorf("%s: got %d %q", name, resp.StatusCode, body)
+		}
+	}
+
+	// Explicit proxy over TLS, with a certificate minted for its address.
+	proxyURL, _ := url.Parse("https://" + httpsL.Addr().String())
+	client := &http.Client{Transport: &http.Transport{
+		Proxy:           http.ProxyURL(proxyURL),
+		TLSClientConfig: &tls.Config{RootCAs: roots},
+	}}
+	resp, err := client.Get("http://unbuilt.invalid/status")
+	check("https", resp, err)
+
+	// Transparent plaintext, routed by the Host header.
+	conn, err := net.Dial("tcp", transparentL.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprint(conn, "GET /status HTTP/1.1\r\nHost: unbuilt.invalid\r\n\r\n")
+	resp, err = http.ReadResponse(bufio.NewReader(conn), nil)
+	check("transparent http", resp, err)
+	conn.Close()
+
+	// Transparent TLS, intercepted with a certificate for the SNI name.
+	tconn, err := tls.Dial("tcp", transparentL.Addr().String(), &tls.Config{RootCAs: roots, ServerName: "unbuilt.invalid"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprint(tconn, "GET /status HTTP/1.1\r\nHost: unbuilt.invalid\r\n\r\n")
+	resp, err = http.ReadResponse(bufio.NewReader(tconn), nil)
+	check("transparent https", resp, err)
+	tconn.Close()
+}
+
+func TestMainListenerClientCert(t *testing.T) {
+	tmpDir := t.TempDir()
+	certPath := filepath.Join(tmpDir, "ca.crt")
+	set := rules.NewSet(rules.Rule{
+		Match: rules.Match{Host: "unbuilt.invalid"},
+		Mock:  &rules.Mock{Body: "mocked"},
+	})
+
+	clientCert := selfSigned(t, "client")
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert.Leaf)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	main := &Listener{Mode: ModeHTTPS, Listener: l, ClientCAs: clientCAs}
+	p, sl := NewProxyServer(
+		WithCert(certPath, filepath.Join(tmpDir, "ca.key")),
+		WithSessionDir(filepath.Join(tmpDir, "logs")),
+		WithRules(set),
+		WithMainListener(main),
+	)
+	defer sl.Close()
+	go p.Serve(main.Listener)
+	defer p.Close()
+
+	caPEM, err := os.ReadFile(certPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(caPEM)
+
+	get := func(certs ...tls.Certificate) (string, error) {
+		proxyURL, _ := url.Parse("https://" + l.Addr().String())
+		client := &http.Client{Transport: &http.Transport{
+			Proxy:           http.ProxyURL(proxyURL),
+			TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certs},
+		}}
+		resp, err := client.Get("http://unbuilt.invalid/")
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body), nil
+	}
+
+	if body, err := get(clientCert); err != nil || body != "mocked" {
+		t.Errorf("With a client certificate: %q, %v", body, err)
+	}
+	if _, err := get(); err == nil {
+		t.Error("Client without a certificate was served")
+	}
+	if _, err := get(selfSigned(t, "stranger")); err == nil {
+		t.Error("Client with an unknown certificate was served")
+	}
+
+	if _, err := (Listener{Mode: ModeHTTP, Listener: l, ClientCAs: clientCAs}).wrap(nil, time.Minute); err == nil {
+		t.Error("Client certificates accepted on a plain HTTP listener")
+	}
+}
+
+// selfSigned returns a certificate for client authentication that is its
+// own CA.
+func selfSigned(t *testing.T, name string) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(der)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
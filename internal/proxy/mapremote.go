@@ -0,0 +1,68 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/google/martian/v3"
+	"github.com/standrze/rogue/internal/logger"
+)
+
+const mappedKey = "rogue.mapped"
+
+// mapped is the destination of a request before map_remote changed it.
+type mapped struct {
+	url  *url.URL
+	host string
+}
+
+// MapRemoteModifier sends requests whose rule has a map_remote action to
+// the new origin. It runs after the other request modifiers and restores
+// the original URL before the other response modifiers, so the log, the
+// cookie jar and validation see the host the client asked for.
+type MapRemoteModifier struct {
+	// Logger, if set, receives an annotation for each mapped flow.
+	Logger *logger.SessionLogger
+}
+
+func (m *MapRemoteModifier) ModifyRequest(req *http.Request) error {
+	rule, ok := matchedRule(req)
+	if !ok || rule.MapRemote == nil {
+		return nil
+	}
+	ctx := martian.NewContext(req)
+	if ctx == nil {
+		return nil
+	}
+
+	orig := *req.URL
+	ctx.Set(mappedKey, mapped{url: &orig, host: req.Host})
+	rule.MapRemote.Apply(req.URL)
+	if !rule.MapRemote.PreserveHost {
+		req.Host = req.URL.Host
+	}
+
+	if m.Logger != nil {
+		m.Logger.WriteEntry("annotation", logger.Annotation{
+			Timestamp: time.Now(),
+			RequestID: requestID(req),
+			Comment:   fmt.Sprintf("map_remote: rule %q sent the request to %s://%s", rule.Name, req.URL.Scheme, req.URL.Host),
+		})
+	}
+	return nil
+}
+
+func (m *MapRemoteModifier) ModifyResponse(res *http.Response) error {
+	if res.Request == nil {
+		return nil
+	}
+	if ctx := martian.NewContext(res.Request); ctx != nil {
+		if v, ok := ctx.Get(mappedKey); ok {
+			orig := v.(mapped)
+			res.Request.URL, res.Request.Host = orig.url, orig.host
+		}
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package proxy
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/standrze/rogue/internal/logger"
+	"github.com/standrze/rogue/internal/rules"
+)
+
+func TestMapRemote(t *testing.T) {
+	prod := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("prod"))
+	}))
+	defer prod.Close()
+	local := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("local " + r.Host + " " + r.URL.RequestURI()))
+	}))
+	defer local.Close()
+	localURL, _ := url.Parse(local.URL)
+	localHost, localPort, _ := net.SplitHostPort(localURL.Host)
+	port, _ := strconv.Atoi(localPort)
+
+	tmpDir := t.TempDir()
+	sessionDir := filepath.Join(tmpDir, "logs")
+	set := rules.NewSet(
+		rules.Rule{Name: "api to local", Match: rules.Match{Path: "/api/*"}, MapRemote: &rules.MapRemote{Host: localHost, Port: port}},
+		rules.Rule{Name: "keep host", Match: rules.Match{Path: "/keep"}, MapRemote: &rules.MapRemote{Host: localHost, Port: port, PreserveHost: true}},
+	)
+	p, sl := NewProxyServer(
+		WithCert(filepath.Join(tmpDir, "ca.crt"), filepath.Join(tmpDir, "ca.key")),
+		WithSessionDir(sessionDir),
+		WithRules(set),
+	)
+	defer sl.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go p.Serve(l)
+	defer p.Close()
+
+	proxyURL, _ := url.Parse("http://" + l.Addr().String())
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+	get := func(path string) string {
+		resp, err := client.Get(prod.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	prodHost := strings.TrimPrefix(prod.URL, "http://")
+	if got := get("/api/users?id=1"); got != "local "+localURL.Host+" /api/users?id=1" {
+		t.Errorf("Mapped request got %q", got)
+	}
+	if got := get("/keep"); got != "local "+prodHost+" /keep" {
+		t.Errorf("Request preserving Host got %q", got)
+	}
+	if got := get("/other"); got != "prod" {
+		t.Errorf("Unmatched request got %q", got)
+	}
+
+	flows, err := logger.LoadFlows(filepath.Join(sessionDir, sl.GetSessionName()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := flows[0]
+	if f.Request == nil || f.Request.URL != prod.URL+"/api/users?id=1" {
+		t.Fatalf("Expected the original URL to be logged, got %+v", f.Request)
+	}
+	if len(f.Annotations) == 0 || !strings.Contains(f.Annotations[0].Comment, localURL.Host) {
+		t.Errorf("Expected an annotation naming the new origin, got %+v", f.Annotations)
+	}
+	if f.Response == nil || f.Response.Upstream == nil || f.Response.Upstream.Address != localURL.Host {
+		t.Errorf("Expected the local backend as upstream, got %+v", f.Response)
+	}
+}
@@ -0,0 +1,121 @@
+package proxy
+
+import (
+	"cmp"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/google/martian/v3"
+	"github.com/standrze/rogue/internal/pages"
+	"github.com/standrze/rogue/internal/rules"
+)
+
+const trustKey = "rogue.trust"
+
+// caPath is where the trust host serves the CA certificate.
+const caPath = "/ca.crt"
+
+// PageModifier answers requests to TrustHost with the CA and instructions for
+// trusting it, and replaces the bare 502 martian returns for failed round
+// trips with a rendered page. Like RulesModifier it must run before the
+// logging response modifier.
+type PageModifier struct {
+	Pages     *pages.Pages
+	TrustHost string
+	// CA is the PEM certificate clients need to trust.
+	CA []byte
+}
+
+func (m *PageModifier) ModifyRequest(req *http.Request) error {
+	if req.Method == http.MethodConnect || m.TrustHost == "" || !strings.EqualFold(req.URL.Hostname(), m.TrustHost) {
+		return nil
+	}
+	if ctx := martian.NewContext(req); ctx != nil {
+		ctx.Set(trustKey, true)
+		ctx.SkipRoundTrip()
+	}
+	return nil
+}
+
+func (m *PageModifier) ModifyResponse(res *http.Response) error {
+	req := res.Request
+	if req == nil || req.Method == http.MethodConnect {
+		return nil
+	}
+
+	if ctx := martian.NewContext(req); ctx != nil {
+		if _, ok := ctx.Get(trustKey); ok {
+			if req.URL.Path == caPath {
+				applyMock(res, &rules.Mock{
+					Headers: map[string]string{
+						"Content-Type":        "application/x-x509-ca-cert",
+						"Content-Disposition": `attachment; filename="rogue-ca.crt"`,
+					},
+					Body: string(m.CA),
+				})
+				return nil
+			}
+			return m.render(res, pages.Trust, http.StatusOK, pages.Data{Title: "Trust the Rogue CA", CAURL: caPath})
+		}
+	}
+
+	if rule, ok := matchedRule(req); ok && rule.Block != nil && rule.Block.Body == "" {
+		data := pages.Data{Title: "Blocked", Reason: fmt.Sprintf("Blocked by rule %q", rule.Name)}
+		return m.render(res, pages.Blocked, cmp.Or(rule.Block.Status, http.StatusForbidden), data)
+	}
+
+	if reason, ok := roundTripError(res); ok {
+		data := pages.Data{Title: "Upstream server unavailable", Reason: reason}
+		if h, ok := hintFor(req); ok {
+			data.Cause, data.Fix = h.Cause, h.Fix
+		}
+		return m.render(res, pages.UpstreamError, http.StatusBadGateway, data)
+	}
+	return nil
+}
+
+// render replaces res with the named page. Fields describing the exchange are
+// filled in from the request.
+func (m *PageModifier) render(res *http.Response, name string, status int, data pages.Data) error {
+	data.Status = status
+	data.Host = res.Request.URL.Hostname()
+	data.URL = res.Request.URL.String()
+	data.RequestID = requestID(res.Request)
+
+	body, err := m.Pages.Render(name, data)
+	if err != nil {
+		return err
+	}
+	applyMock(res, &rules.Mock{
+		Status:  status,
+		Headers: map[string]string{"Content-Type": "text/html; charset=utf-8"},
+		Body:    string(body),
+	})
+	return nil
+}
+
+// roundTripError extracts the error from the Warning header martian adds to
+// the 502 it substitutes for a failed round trip.
+func roundTripError(res *http.Response) (string, bool) {
+	if res.StatusCode != http.StatusBadGateway {
+		return "", false
+	}
+	for _, w := range res.Header.Values("Warning") {
+		rest, ok := strings.CutPrefix(w, `199 "martian" `)
+		if !ok {
+			continue
+		}
+		quoted, err := strconv.QuotedPrefix(rest)
+		if err != nil {
+			continue
+		}
+		reason, err := strconv.Unquote(quoted)
+		if err != nil {
+			continue
+		}
+		return reason, true
+	}
+	return "", false
+}
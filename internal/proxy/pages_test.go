@@ -0,0 +1,63 @@
+package proxy
+
+import (
+	"encoding/pem"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/standrze/rogue/internal/pages"
+)
+
+func TestPages(t *testing.T) {
+	set, err := pages.New("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpDir := t.TempDir()
+	p, sl := NewProxyServer(
+		WithCert(filepath.Join(tmpDir, "ca.crt"), filepath.Join(tmpDir, "ca.key")),
+		WithSessionDir(filepath.Join(tmpDir, "logs")),
+		WithPages(set, "rogue.proxy"),
+	)
+	defer sl.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go p.Serve(l)
+	defer p.Close()
+
+	proxyURL, _ := url.Parse("http://" + l.Addr().String())
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+	get := func(u string) (*http.Response, string) {
+		resp, err := client.Get(u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return resp, string(body)
+	}
+
+	resp, body := get("http://unbuilt.invalid/")
+	if resp.StatusCode != http.StatusBadGateway || !strings.Contains(body, "unbuilt.invalid") || !strings.Contains(body, "Flow <code>") {
+		t.Errorf("Expected an upstream error page, got %d %q", resp.StatusCode, body)
+	}
+
+	resp, body = get("http://rogue.proxy/")
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, `href="/ca.crt"`) {
+		t.Errorf("Expected the trust page, got %d %q", resp.StatusCode, body)
+	}
+
+	resp, body = get("http://rogue.proxy/ca.crt")
+	if block, _ := pem.Decode([]byte(body)); block == nil || block.Type != "CERTIFICATE" {
+		t.Errorf("Expected the CA certificate, got %d %q", resp.StatusCode, body)
+	}
+}
@@ -0,0 +1,229 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/martian/v3"
+	"github.com/standrze/rogue/internal/budget"
+	"github.com/standrze/rogue/internal/logger"
+	"github.com/standrze/rogue/internal/plugin"
+)
+
+const pluginResponseKey = "rogue.plugin.response"
+
+// WithPlugins runs plugins on every exchange, in order. Bodies larger than
+// maxBody bytes are not sent to them.
+func WithPlugins(maxBody int64, plugins ...*plugin.Plugin) ProxyOption {
+	return func(p *Proxy) {
+		p.Plugins = plugins
+		p.PluginMaxBody = maxBody
+	}
+}
+
+// PluginModifier passes exchanges through plugins. Like HeaderModifier it
+// runs after RulesModifier, so request changes are not in the logged
+// request but response changes are in the logged response. A plugin that
+// fails or does not answer in time leaves the exchange as it was.
+type PluginModifier struct {
+	Plugins []*plugin.Plugin
+	MaxBody int64
+	// Logger, if set, receives the tags and notes plugins attach.
+	Logger *logger.SessionLogger
+	// Budget, if set, bounds the memory of bodies read for plugins.
+	Budget *budget.Budget
+}
+
+func (m *PluginModifier) ModifyRequest(req *http.Request) error {
+	ctx := martian.NewContext(req)
+	if req.Method == http.MethodConnect || ctx == nil || ctx.SkippingRoundTrip() {
+		return nil
+	}
+	var errs []error
+	for _, p := range m.plugins(plugin.HookRequest, req) {
+		preq, err := m.request(req)
+		if err != nil {
+			return err
+		}
+		reply, err := p.Call(plugin.HookRequest, preq, nil)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("plugin %s: %w", p.Name, err))
+			continue
+		}
+		m.annotate(req, reply)
+		if reply.Request != nil {
+			if preq.BodyOmitted {
+				reply.Request.Body = nil
+			}
+			if err := applyPluginRequest(req, reply.Request); err != nil {
+				errs = append(errs, fmt.Errorf("plugin %s: %w", p.Name, err))
+			}
+		}
+		if reply.Response != nil {
+			ctx.Set(pluginResponseKey, reply.Response)
+			ctx.SkipRoundTrip()
+			break
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (m *PluginModifier) ModifyResponse(res *http.Response) error {
+	req := res.Request
+	if req == nil || req.Method == http.MethodConnect {
+		return nil
+	}
+	if ctx := martian.NewContext(req); ctx != nil {
+		if v, ok := ctx.Get(pluginResponseKey); ok {
+			applyPluginResponse(res, v.(*plugin.Response))
+		}
+	}
+	var errs []error
+	for _, p := range m.plugins(plugin.HookResponse, req) {
+		preq := &plugin.Request{Method: req.Method, URL: req.URL.String(), Headers: req.Header, BodyOmitted: true}
+		pres, err := m.response(res)
+		if err != nil {
+			return err
+		}
+		reply, err := p.Call(plugin.HookResponse, preq, pres)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("plugin %s: %w", p.Name, err))
+			continue
+		}
+		m.annotate(req, reply)
+		if reply.Response != nil {
+			if pres.BodyOmitted {
+				reply.Response.Body = nil
+			}
+			applyPluginResponse(res, reply.Response)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// annotate records the tags and note of a plugin's reply.
+func (m *PluginModifier) annotate(req *http.Request, reply plugin.Message) {
+	if m.Logger == nil || (len(reply.Tags) == 0 && reply.Note == "") {
+		return
+	}
+	m.Logger.WriteEntry("annotation", logger.Annotation{
+		Timestamp: time.Now(),
+		RequestID: requestID(req),
+		Comment:   reply.Note,
+		Tags:      reply.Tags,
+	})
+}
+
+// plugins returns the plugins with hook that apply to req's host.
+func (m *PluginModifier) plugins(hook string, req *http.Request) []*plugin.Plugin {
+	var out []*plugin.Plugin
+	for _, p := range m.Plugins {
+		if p.Implements(hook) && (len(p.Hosts) == 0 || matchHost(p.Hosts, req.URL.Host)) {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+func (m *PluginModifier) request(req *http.Request) (*plugin.Request, error) {
+	body, rest, omitted, err := m.readBody(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	req.Body = rest
+	return &plugin.Request{
+		Method:      req.Method,
+		URL:         req.URL.String(),
+		Headers:     req.Header,
+		Body:        body,
+		BodyOmitted: omitted,
+	}, nil
+}
+
+func (m *PluginModifier) response(res *http.Response) (*plugin.Response, error) {
+	pres := &plugin.Response{Status: res.StatusCode, Headers: res.Header, BodyOmitted: true}
+	// Streams are relayed as they arrive, so their bodies are never sent.
+	if strings.HasPrefix(res.Header.Get("Content-Type"), "text/event-stream") {
+		return pres, nil
+	}
+	body, rest, omitted, err := m.readBody(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	res.Body = rest
+	pres.Body, pres.BodyOmitted = body, omitted
+	return pres, nil
+}
+
+// readBody reads up to MaxBody bytes of body. When the body is longer, or
+// the memory budget has no room for it, it is left whole in rest and
+// omitted is set.
+func (m *PluginModifier) readBody(body io.ReadCloser) (data []byte, rest io.ReadCloser, omitted bool, err error) {
+	if body == nil || body == http.NoBody {
+		return nil, body, false, nil
+	}
+	data, rest, ok, err := m.Budget.Buffer(body, m.MaxBody)
+	return data, rest, !ok, err
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+func applyPluginRequest(req *http.Request, r *plugin.Request) error {
+	if r.Method != "" {
+		req.Method = r.Method
+	}
+	if r.URL != "" && r.URL != req.URL.String() {
+		u, err := url.Parse(r.URL)
+		if err != nil {
+			return err
+		}
+		req.URL = u
+		req.Host = u.Host
+	}
+	if r.Headers != nil {
+		req.Header = r.Headers
+	}
+	if r.Body != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		req.Body = io.NopCloser(bytes.NewReader(r.Body))
+		req.ContentLength = int64(len(r.Body))
+		req.TransferEncoding = nil
+		if len(r.Body) > 0 {
+			req.Header.Set("Content-Length", strconv.Itoa(len(r.Body)))
+		} else {
+			req.Header.Del("Content-Length")
+		}
+	}
+	return nil
+}
+
+func applyPluginResponse(res *http.Response, r *plugin.Response) {
+	if r.Status != 0 {
+		res.StatusCode = r.Status
+		res.Status = fmt.Sprintf("%d %s", r.Status, http.StatusText(r.Status))
+	}
+	if r.Headers != nil {
+		res.Header = r.Headers
+	}
+	if r.Body != nil {
+		if res.Body != nil {
+			res.Body.Close()
+		}
+		res.Body = io.NopCloser(bytes.NewReader(r.Body))
+		res.ContentLength = int64(len(r.Body))
+		res.Header.Set("Content-Length", strconv.Itoa(len(r.Body)))
+		res.TransferEncoding = nil
+	}
+}
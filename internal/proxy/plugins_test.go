@@ -0,0 +1,89 @@
+package proxy
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/standrze/rogue/internal/plugin"
+)
+
+// TestPluginHelper is the plugin TestPlugins runs, by running the test
+// binary again.
+func TestPluginHelper(t *testing.T) {
+	if os.Getenv("ROGUE_TEST_PLUGIN") == "" {
+		t.Skip("only run as a plugin")
+	}
+	plugin.Serve(plugin.Handler{
+		Name: "test",
+		Request: func(req *plugin.Request) (*plugin.Request, *plugin.Response, error) {
+			if strings.HasSuffix(req.URL, "/answer") {
+				return nil, &plugin.Response{Status: http.StatusTeapot, Body: []byte("from plugin")}, nil
+			}
+			req.Headers.Set("X-Plugin", "seen")
+			return req, nil, nil
+		},
+		Response: func(req *plugin.Request, res *plugin.Response) (*plugin.Response, error) {
+			res.Body = []byte(strings.ToUpper(string(res.Body)))
+			return res, nil
+		},
+	})
+	os.Exit(0)
+}
+
+func TestPlugins(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "plugin header "+r.Header.Get("X-Plugin"))
+	}))
+	defer origin.Close()
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestPluginHelper$")
+	cmd.Env = append(os.Environ(), "ROGUE_TEST_PLUGIN=1")
+	pl, err := plugin.Start(cmd, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pl.Close()
+
+	tmpDir := t.TempDir()
+	p, sl := NewProxyServer(
+		WithCert(filepath.Join(tmpDir, "ca.crt"), filepath.Join(tmpDir, "ca.key")),
+		WithSessionDir(filepath.Join(tmpDir, "logs")),
+		WithPlugins(1<<20, pl),
+	)
+	defer sl.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go p.Serve(l)
+	defer p.Close()
+
+	proxyURL, _ := url.Parse("http://" + l.Addr().String())
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+	get := func(path string) (int, string) {
+		resp, err := client.Get(origin.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	if status, body := get("/"); status != http.StatusOK || body != "PLUGIN HEADER SEEN" {
+		t.Errorf("Got %d %q, want the request and response changed", status, body)
+	}
+	if status, body := get("/answer"); status != http.StatusTeapot || body != "FROM PLUGIN" {
+		t.Errorf("Got %d %q, want the plugin's answer", status, body)
+	}
+}
@@ -1,28 +1,145 @@
 package proxy
 
 import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"os"
+	"runtime"
 	"time"
 
 	"github.com/google/martian/v3"
 	"github.com/google/martian/v3/fifo"
-	"github.com/google/martian/v3/mitm"
+	"github.com/google/martian/v3/log"
+	"github.com/standrze/rogue/internal/artifact"
+	"github.com/standrze/rogue/internal/budget"
+	"github.com/standrze/rogue/internal/cache"
 	"github.com/standrze/rogue/internal/cert"
+	"github.com/standrze/rogue/internal/codec"
+	"github.com/standrze/rogue/internal/cookies"
+	"github.com/standrze/rogue/internal/doh"
+	"github.com/standrze/rogue/internal/findings"
+	"github.com/standrze/rogue/internal/gitproto"
+	"github.com/standrze/rogue/internal/graphql"
+	"github.com/standrze/rogue/internal/grpcdecode"
+	"github.com/standrze/rogue/internal/jwt"
 	"github.com/standrze/rogue/internal/logger"
+	"github.com/standrze/rogue/internal/metrics"
+	"github.com/standrze/rogue/internal/mitm"
+	"github.com/standrze/rogue/internal/openapi"
+	"github.com/standrze/rogue/internal/pages"
+	"github.com/standrze/rogue/internal/plugin"
+	"github.com/standrze/rogue/internal/registry"
+	"github.com/standrze/rogue/internal/rules"
+	"github.com/standrze/rogue/internal/s3"
+	"github.com/standrze/rogue/internal/scope"
+	"github.com/standrze/rogue/internal/sensitive"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Proxy struct {
-	Port         int
-	Host         string
-	CertPath     string
-	KeyPath      string
-	SessionDir   string
-	LogRequests  bool
-	LogResponses bool
-	LogHeaders   bool
-	LogBody      bool
-	MaxBodySize  int
+	Port              int
+	Host              string
+	CertPath          string
+	KeyPath           string
+	CACertPEM         []byte
+	CAKeyPEM          []byte
+	KeyPassphrase     cert.PassphraseFunc
+	EncryptKey        bool
+	KeyPerm           os.FileMode
+	SessionDir        string
+	ResumeSession     bool
+	RotateSize        int64
+	RotateInterval    time.Duration
+	RotateCompress    bool
+	LogQueueSize      int
+	LogQueuePolicy    string
+	LogSyncInterval   time.Duration
+	LogRequests       bool
+	LogResponses      bool
+	LogHeaders        bool
+	LogBody           bool
+	MaxBodySize       int
+	ExposeID          bool
+	LogSSEEvents      bool
+	LogRegistryBlobs  bool
+	LogServerCerts    bool
+	LogTunnels        bool
+	TunnelSample      int
+	StripAltSvc       bool
+	HSTS              string
+	BodyBudget        *budget.Budget
+	RedactS3          bool
+	Labels            map[string]string
+	GenerateCA        bool
+	CertCacheSize     int
+	KeyPoolSize       int
+	WarmHosts         []string
+	Metrics           *metrics.Registry
+	Rules             *rules.Set
+	Validator         *openapi.Validator
+	ValidateResponses bool
+	RejectInvalid     bool
+	Pages             *pages.Pages
+	Listeners         []Listener
+	MainListener      *Listener
+	TrustHost         string
+	Timeouts          Timeouts
+	TLSPolicy         TLSPolicy
+	SessionFile       bool
+	LogSinks          []LogSink
+	Tracer            trace.Tracer
+	PropagateTrace    bool
+	LogDegrade        logger.DegradePolicy
+	Bindings          []Binding
+	DialStrategy      DialStrategy
+	DNS               DNS
+	HeaderProfiles    rules.Profiles
+	GlobalHeaders     []string
+	CookieJar         *cookies.Jar
+	Cache             *cache.Cache
+	Limiter           *Limiter
+	UpstreamProxy     UpstreamProxy
+	Plugins           []*plugin.Plugin
+	Interception      *Interception
+	PluginMaxBody     int64
+	Findings          *findings.Scanner
+	Sensitive         *sensitive.Scanner
+	SensitiveWarn     io.Writer
+	Scope             *scope.Scope
+	ProtoSchema       *grpcdecode.Schema
+	ProtoTypes        []grpcdecode.MessageType
+}
+
+// LogSink is a remote collector session entries are shipped to.
+type LogSink struct {
+	Sink    logger.Sink
+	Options logger.SinkOptions
+}
+
+// Timeouts bounds the phases of a proxied exchange. A zero value disables the
+// corresponding limit, except for Request where it means five minutes.
+type Timeouts struct {
+	// Request is how long a client connection may make no progress: wait
+	// for the next request, or stall while a request is read or its
+	// response written. It does not bound a transfer that keeps moving,
+	// and tunnels and event streams relayed as they are lift it. It applies
+	// on the main and additional listeners.
+	Request time.Duration
+	// Dial bounds establishing the upstream TCP connection.
+	Dial time.Duration
+	// TLSHandshake bounds the upstream TLS handshake.
+	TLSHandshake time.Duration
+	// ResponseHeader bounds waiting for the upstream response headers once
+	// the request has been written.
+	ResponseHeader time.Duration
+	// Idle is how long an unused upstream connection is kept alive.
+	Idle time.Duration
 }
 
 type ProxyOption func(p *Proxy)
@@ -52,6 +169,67 @@ func WithSessionDir(dir string) ProxyOption {
 	}
 }
 
+// WithSessionResume appends to the newest session in the session directory
+// instead of starting a new one.
+func WithSessionResume(resume bool) ProxyOption {
+	return func(p *Proxy) {
+		p.ResumeSession = resume
+	}
+}
+
+// WithSessionRotation starts a new session file when the current one
+// reaches size bytes or age interval, gzipping rotated files with compress.
+func WithSessionRotation(size int64, interval time.Duration, compress bool) ProxyOption {
+	return func(p *Proxy) {
+		p.RotateSize = size
+		p.RotateInterval = interval
+		p.RotateCompress = compress
+	}
+}
+
+// WithAsyncLogging writes session entries from a background goroutine fed by
+// a queue of queueSize entries. policy (logger.QueueBlock, QueueDrop or
+// QueueDegrade) applies when the queue fills up; the file is synced every syncInterval.
+func WithAsyncLogging(queueSize int, policy string, syncInterval time.Duration) ProxyOption {
+	return func(p *Proxy) {
+		p.LogQueueSize = queueSize
+		p.LogQueuePolicy = policy
+		p.LogSyncInterval = syncInterval
+	}
+}
+
+// WithLogDegradation sets the thresholds used by the logger.QueueDegrade
+// queue policy.
+func WithLogDegradation(policy logger.DegradePolicy) ProxyOption {
+	return func(p *Proxy) {
+		p.LogDegrade = policy
+	}
+}
+
+// WithSessionFile controls whether entries are written to a session file.
+// Without one, entries only reach the sinks given to WithLogSinks.
+func WithSessionFile(enabled bool) ProxyOption {
+	return func(p *Proxy) {
+		p.SessionFile = enabled
+	}
+}
+
+// WithLogSinks ships every session entry to sinks as well.
+func WithLogSinks(sinks ...LogSink) ProxyOption {
+	return func(p *Proxy) {
+		p.LogSinks = append(p.LogSinks, sinks...)
+	}
+}
+
+// WithTracing records a span per exchange with tracer. With propagate, the
+// traceparent header sent upstream is set to the proxy's span.
+func WithTracing(tracer trace.Tracer, propagate bool) ProxyOption {
+	return func(p *Proxy) {
+		p.Tracer = tracer
+		p.PropagateTrace = propagate
+	}
+}
+
 func WithLogging(logRequests, logResponses, logHeaders, logBody bool, maxBodySize int) ProxyOption {
 	return func(p *Proxy) {
 		p.LogRequests = logRequests
@@ -62,88 +240,723 @@ func WithLogging(logRequests, logResponses, logHeaders, logBody bool, maxBodySiz
 	}
 }
 
+// WithRequestIDHeader forwards the request ID upstream in the
+// X-Rogue-Request-ID header. It is off by default because the header leaks to
+// origin servers and breaks request signatures.
+func WithRequestIDHeader(expose bool) ProxyOption {
+	return func(p *Proxy) {
+		p.ExposeID = expose
+	}
+}
+
+// WithSSEEventLogging records each Server-Sent Event as its own session
+// entry.
+func WithSSEEventLogging(enabled bool) ProxyOption {
+	return func(p *Proxy) {
+		p.LogSSEEvents = enabled
+	}
+}
+
+// WithRegistryBlobs keeps container image layer bodies in the log. They are
+// omitted by default; only their size is recorded.
+func WithRegistryBlobs(enabled bool) ProxyOption {
+	return func(p *Proxy) {
+		p.LogRegistryBlobs = enabled
+	}
+}
+
+// WithServerCertLogging records the certificate chain and verification
+// result of every upstream TLS connection.
+func WithServerCertLogging(enabled bool) ProxyOption {
+	return func(p *Proxy) {
+		p.LogServerCerts = enabled
+	}
+}
+
+// WithTunnelLogging records CONNECT tunnels relayed without interception,
+// with hex dumps of their first sampleSize bytes each way.
+func WithTunnelLogging(enabled bool, sampleSize int) ProxyOption {
+	return func(p *Proxy) {
+		p.LogTunnels = enabled
+		p.TunnelSample = sampleSize
+	}
+}
+
+// WithBodyBudget bounds the memory held across exchanges by bodies
+// buffered to be logged, cached, rewritten or shown to plugins.
+func WithBodyBudget(b *budget.Budget) ProxyOption {
+	return func(p *Proxy) {
+		p.BodyBudget = b
+	}
+}
+
+// WithAltSvcStripping removes HTTP/3 alternatives from Alt-Svc headers
+// so that clients keep using TCP, where they are intercepted.
+func WithAltSvcStripping(enabled bool) ProxyOption {
+	return func(p *Proxy) {
+		p.StripAltSvc = enabled
+	}
+}
+
+// WithS3SignatureRedaction masks SigV4 signatures and session tokens in
+// logged URLs and headers.
+func WithS3SignatureRedaction(enabled bool) ProxyOption {
+	return func(p *Proxy) {
+		p.RedactS3 = enabled
+	}
+}
+
+// WithLabels attaches labels, such as those of the pod the proxy runs
+// beside, to every logged request and tunnel.
+func WithLabels(labels map[string]string) ProxyOption {
+	return func(p *Proxy) {
+		p.Labels = labels
+	}
+}
+
+// WithProtobufSchema decodes protobuf and gRPC bodies in the log with the
+// message types in schema: gRPC methods by name when reflection is not
+// available, and plain protobuf bodies by their Content-Type or types.
+func WithProtobufSchema(schema *grpcdecode.Schema, types ...grpcdecode.MessageType) ProxyOption {
+	return func(p *Proxy) {
+		p.ProtoSchema = schema
+		p.ProtoTypes = types
+	}
+}
+
+// WithCAPEM signs with the CA and key given as PEM instead of the files of
+// WithCert, which are then neither read nor generated.
+func WithCAPEM(certPEM, keyPEM []byte) ProxyOption {
+	return func(p *Proxy) {
+		p.CACertPEM = certPEM
+		p.CAKeyPEM = keyPEM
+	}
+}
+
+// WithCAGeneration controls whether a missing CA is generated. Disable it
+// when signing with an organizational CA so a wrong path fails loudly
+// instead of silently minting an untrusted root.
+func WithCAGeneration(enabled bool) ProxyOption {
+	return func(p *Proxy) {
+		p.GenerateCA = enabled
+	}
+}
+
+// WithKeyPassphrase supplies the passphrase for an encrypted CA key. When
+// encrypt is set, a generated CA key is encrypted with it too.
+func WithKeyPassphrase(passphrase cert.PassphraseFunc, encrypt bool) ProxyOption {
+	return func(p *Proxy) {
+		p.KeyPassphrase = passphrase
+		p.EncryptKey = encrypt
+	}
+}
+
+// WithKeyPerm sets the file mode of a generated CA key.
+func WithKeyPerm(perm os.FileMode) ProxyOption {
+	return func(p *Proxy) {
+		p.KeyPerm = perm
+	}
+}
+
+// WithCertCache bounds the leaf certificate cache and lists hosts whose
+// certificates are minted at startup.
+func WithCertCache(size int, warmHosts []string) ProxyOption {
+	return func(p *Proxy) {
+		p.CertCacheSize = size
+		p.WarmHosts = warmHosts
+	}
+}
+
+// WithKeyPool gives each leaf certificate its own key, keeping size keys
+// generated ahead of need. Zero shares one key across all leaves.
+func WithKeyPool(size int) ProxyOption {
+	return func(p *Proxy) {
+		p.KeyPoolSize = size
+	}
+}
+
+// WithMetrics registers the proxy's runtime metrics with reg.
+func WithMetrics(reg *metrics.Registry) ProxyOption {
+	return func(p *Proxy) {
+		p.Metrics = reg
+	}
+}
+
+// WithRules applies the rules in set to every exchange. The set may be
+// changed while the proxy is running.
+func WithRules(set *rules.Set) ProxyOption {
+	return func(p *Proxy) {
+		p.Rules = set
+	}
+}
+
+// WithValidation checks requests, and optionally responses, against an
+// OpenAPI document. Violations are annotated on the flow and, with reject
+// set, answered with an error.
+func WithValidation(v *openapi.Validator, responses, reject bool) ProxyOption {
+	return func(p *Proxy) {
+		p.Validator = v
+		p.ValidateResponses = responses
+		p.RejectInvalid = reject
+	}
+}
+
+// WithCache answers GET requests from c and stores the responses of the
+// origin in it.
+func WithCache(c *cache.Cache) ProxyOption {
+	return func(p *Proxy) {
+		p.Cache = c
+	}
+}
+
+// WithPages renders proxy errors with pages instead of bare status lines and
+// serves the CA with trust instructions on trustHost (e.g. http://rogue.proxy/).
+func WithPages(set *pages.Pages, trustHost string) ProxyOption {
+	return func(p *Proxy) {
+		p.Pages = set
+		p.TrustHost = trustHost
+	}
+}
+
+// WithListeners serves the proxy on additional sockets alongside the one
+// passed to Serve.
+func WithListeners(ls ...Listener) ProxyOption {
+	return func(p *Proxy) {
+		p.Listeners = append(p.Listeners, ls...)
+	}
+}
+
+func WithTimeouts(t Timeouts) ProxyOption {
+	return func(p *Proxy) {
+		p.Timeouts = t
+	}
+}
+
 type RequestModifier struct {
-	Logger *logger.SessionLogger
+	Logger    *logger.SessionLogger
+	Redirects *redirectTracker
 }
 
 func (r *RequestModifier) ModifyRequest(req *http.Request) error {
-	reqID := fmt.Sprintf("%d", time.Now().UnixNano())
-	req.Header.Set("X-Rogue-Request-ID", reqID)
-	return r.Logger.LogRequest(req, reqID)
+	if !inScope(req) {
+		return nil
+	}
+	reqID := requestID(req)
+	*req = *req.WithContext(logger.TraceUpstream(req.Context()))
+
+	reqLog := r.Logger.CaptureRequest(req, reqID)
+	if r.Redirects != nil {
+		reqLog.RedirectOf = r.Redirects.Follow(req)
+	}
+
+	body, err := r.Logger.StreamEntry("request", reqLog, req.Body)
+	req.Body = body
+	return err
 }
 
 type ResponseModifier struct {
-	Logger *logger.SessionLogger
+	Logger    *logger.SessionLogger
+	Redirects *redirectTracker
 }
 
 func (r *ResponseModifier) ModifyResponse(res *http.Response) error {
-	reqID := res.Request.Header.Get("X-Rogue-Request-ID")
+	if !inScope(res.Request) {
+		return nil
+	}
+	reqID := requestID(res.Request)
+	if r.Redirects != nil {
+		r.Redirects.Observe(res, reqID)
+	}
+
+	// Event streams never end on their own terms; log the headers now and
+	// leave the events to SSEModifier.
+	if isEventStream(res) {
+		return r.Logger.WriteEntry("response", r.Logger.CaptureResponse(res, reqID))
+	}
 	return r.Logger.LogResponse(res, reqID)
 }
 
+func registerCertMetrics(reg *metrics.Registry, mc *mitm.Config) {
+	stat := func(f func(mitm.CacheStats) float64) func() float64 {
+		return func() float64 { return f(mc.Stats()) }
+	}
+	reg.Register("rogue_cert_cache_hits_total", "Leaf certificates served from the cache.", metrics.Counter,
+		stat(func(s mitm.CacheStats) float64 { return float64(s.Hits) }))
+	reg.Register("rogue_cert_cache_misses_total", "Leaf certificates minted on demand.", metrics.Counter,
+		stat(func(s mitm.CacheStats) float64 { return float64(s.Misses) }))
+	reg.Register("rogue_cert_cache_evictions_total", "Leaf certificates evicted from the cache.", metrics.Counter,
+		stat(func(s mitm.CacheStats) float64 { return float64(s.Evictions) }))
+	reg.Register("rogue_cert_cache_size", "Leaf certificates currently cached.", metrics.Gauge,
+		stat(func(s mitm.CacheStats) float64 { return float64(s.Size) }))
+	reg.Register("rogue_cert_cache_capacity", "Maximum number of cached leaf certificates.", metrics.Gauge,
+		stat(func(s mitm.CacheStats) float64 { return float64(s.Capacity) }))
+}
+
+func registerKeyPoolMetrics(reg *metrics.Registry, pool *mitm.KeyPool) {
+	stat := func(f func(mitm.KeyPoolStats) float64) func() float64 {
+		return func() float64 { return f(pool.Stats()) }
+	}
+	reg.Register("rogue_cert_key_pool_hits_total", "Leaf keys taken from the pre-generated pool.", metrics.Counter,
+		stat(func(s mitm.KeyPoolStats) float64 { return float64(s.Hits) }))
+	reg.Register("rogue_cert_key_pool_misses_total", "Leaf keys generated on the handshake path because the pool was empty.", metrics.Counter,
+		stat(func(s mitm.KeyPoolStats) float64 { return float64(s.Misses) }))
+	reg.Register("rogue_cert_key_pool_available", "Pre-generated leaf keys ready for use.", metrics.Gauge,
+		stat(func(s mitm.KeyPoolStats) float64 { return float64(s.Available) }))
+	reg.Register("rogue_cert_key_pool_capacity", "Maximum number of pre-generated leaf keys.", metrics.Gauge,
+		stat(func(s mitm.KeyPoolStats) float64 { return float64(s.Capacity) }))
+}
+
+func registerLogQueueMetrics(reg *metrics.Registry, sl *logger.SessionLogger) {
+	stat := func(f func(logger.QueueStats) float64) func() float64 {
+		return func() float64 { return f(sl.QueueStats()) }
+	}
+	reg.Register("rogue_log_queue_length", "Session entries waiting to be written.", metrics.Gauge,
+		stat(func(s logger.QueueStats) float64 { return float64(s.Length) }))
+	reg.Register("rogue_log_queue_capacity", "Maximum number of queued session entries.", metrics.Gauge,
+		stat(func(s logger.QueueStats) float64 { return float64(s.Capacity) }))
+	reg.Register("rogue_log_entries_dropped_total", "Session entries discarded because the queue was full.", metrics.Counter,
+		stat(func(s logger.QueueStats) float64 { return float64(s.Dropped) }))
+	reg.Register("rogue_log_degradation_level", "Detail left out of session entries: 0 none, 1 bodies, 2 headers, 3 sampled flows.", metrics.Gauge,
+		stat(func(s logger.QueueStats) float64 { return float64(s.Level) }))
+	reg.Register("rogue_log_entries_sampled_out_total", "Session entries skipped by flow sampling under degradation.", metrics.Counter,
+		stat(func(s logger.QueueStats) float64 { return float64(s.SampledOut) }))
+}
+
+func registerBudgetMetrics(reg *metrics.Registry, b *budget.Budget) {
+	stat := func(f func(budget.Stats) float64) func() float64 {
+		return func() float64 { return f(b.Stats()) }
+	}
+	reg.Register("rogue_body_memory_bytes", "Memory held by bodies buffered in flight.", metrics.Gauge,
+		stat(func(s budget.Stats) float64 { return float64(s.Used) }))
+	reg.Register("rogue_body_memory_limit_bytes", "Memory bodies buffered in flight may hold.", metrics.Gauge,
+		stat(func(s budget.Stats) float64 { return float64(s.Limit) }))
+	reg.Register("rogue_body_spilled_total", "Captured bodies moved to temporary files for want of memory.", metrics.Counter,
+		stat(func(s budget.Stats) float64 { return float64(s.Spilled) }))
+	reg.Register("rogue_body_unbuffered_total", "Bodies left unbuffered or captured in part for want of memory.", metrics.Counter,
+		stat(func(s budget.Stats) float64 { return float64(s.Refused) }))
+}
+
+func registerLogSinkMetrics(reg *metrics.Registry, sl *logger.SessionLogger) {
+	results := map[string]func(logger.SinkStats) uint64{
+		"sent":    func(s logger.SinkStats) uint64 { return s.Sent },
+		"failed":  func(s logger.SinkStats) uint64 { return s.Failed },
+		"dropped": func(s logger.SinkStats) uint64 { return s.Dropped },
+	}
+	for i, sink := range sl.SinkStats() {
+		for result, f := range results {
+			reg.Register(`rogue_log_sink_entries_total{sink="`+sink.Name+`",result="`+result+`"}`,
+				"Session entries handed to remote sinks by outcome.", metrics.Counter,
+				func() float64 { return float64(f(sl.SinkStats()[i])) })
+		}
+	}
+}
+
 func NewProxyServer(option ...ProxyOption) (*martian.Proxy, *logger.SessionLogger) {
 	proxyOpts := &Proxy{
-		Port:         8080,
-		Host:         "0.0.0.0",
-		CertPath:     "certs/ca.crt",
-		KeyPath:      "certs/ca.key",
-		SessionDir:   "logs",
-		LogRequests:  true,
-		LogResponses: true,
-		LogHeaders:   true,
-		LogBody:      true,
-		MaxBodySize:  1024 * 1024,
+		Port:           8080,
+		Host:           "0.0.0.0",
+		CertPath:       "certs/ca.crt",
+		KeyPath:        "certs/ca.key",
+		SessionDir:     "logs",
+		LogRequests:    true,
+		LogResponses:   true,
+		LogHeaders:     true,
+		LogBody:        true,
+		MaxBodySize:    1024 * 1024,
+		LogSSEEvents:   true,
+		LogServerCerts: true,
+		TunnelSample:   DefaultTunnelSampleSize,
+		GenerateCA:     true,
+		SessionFile:    true,
+		CertCacheSize:  mitm.DefaultCacheSize,
+		Timeouts: Timeouts{
+			Request:        30 * time.Second,
+			Dial:           10 * time.Second,
+			TLSHandshake:   10 * time.Second,
+			ResponseHeader: 60 * time.Second,
+			Idle:           90 * time.Second,
+		},
 	}
 
 	for _, opt := range option {
 		opt(proxyOpts)
 	}
 
-	if !cert.Exists(proxyOpts.CertPath, proxyOpts.KeyPath) {
-		if err := cert.GenerateSelfSigned("Rogue Proxy", "Rogue CA", 365, proxyOpts.CertPath, proxyOpts.KeyPath); err != nil {
+	if proxyOpts.CACertPEM == nil && !cert.Exists(proxyOpts.CertPath, proxyOpts.KeyPath) {
+		if !proxyOpts.GenerateCA {
+			panic(fmt.Sprintf("CA certificate %s or key %s not found and generation is disabled", proxyOpts.CertPath, proxyOpts.KeyPath))
+		}
+		var passphrase []byte
+		if proxyOpts.EncryptKey {
+			if proxyOpts.KeyPassphrase == nil {
+				panic("key encryption is enabled but no passphrase is configured")
+			}
+			var err error
+			if passphrase, err = proxyOpts.KeyPassphrase(); err != nil || len(passphrase) == 0 {
+				panic(fmt.Sprintf("failed to read a passphrase for the CA key: %v", err))
+			}
+		}
+		keyOpts := cert.KeyOptions{Passphrase: passphrase, Perm: proxyOpts.KeyPerm}
+		if err := cert.GenerateSelfSigned("Rogue Proxy", "Rogue CA", 365, proxyOpts.CertPath, proxyOpts.KeyPath, keyOpts); err != nil {
 			panic(fmt.Sprintf("failed to generate certs: %v", err))
 		}
 	}
 
-	ca, priv, err := cert.Load(proxyOpts.CertPath, proxyOpts.KeyPath)
+	var chain []*x509.Certificate
+	var priv crypto.Signer
+	var err error
+	if proxyOpts.CACertPEM != nil {
+		chain, priv, err = cert.ParseChain(proxyOpts.CACertPEM, proxyOpts.CAKeyPEM, proxyOpts.KeyPassphrase)
+	} else {
+		chain, priv, err = cert.LoadChain(proxyOpts.CertPath, proxyOpts.KeyPath, proxyOpts.KeyPassphrase)
+	}
 	if err != nil {
 		panic(fmt.Sprintf("failed to load certs: %v", err))
 	}
 
-	mc, err := mitm.NewConfig(ca, priv)
+	mc, err := mitm.NewConfig(chain[0], priv)
 	if err != nil {
 		panic(fmt.Sprintf("failed to create MITM config: %v", err))
 	}
+	mc.SetChain(chain[1:])
+	mc.MinVersion = proxyOpts.TLSPolicy.ClientMinVersion
+	mc.MaxVersion = proxyOpts.TLSPolicy.ClientMaxVersion
+	mc.CipherSuites = proxyOpts.TLSPolicy.ClientCipherSuites
+	mc.KeyLogWriter = proxyOpts.TLSPolicy.KeyLog
+	mc.SetCacheSize(proxyOpts.CertCacheSize)
+	if proxyOpts.KeyPoolSize > 0 {
+		workers := min(proxyOpts.KeyPoolSize, max(runtime.NumCPU()/2, 1))
+		pool := mitm.NewKeyPool(proxyOpts.KeyPoolSize, workers, mitm.GenerateRSAKey)
+		mc.SetKeyPool(pool)
+		if proxyOpts.Metrics != nil {
+			registerKeyPoolMetrics(proxyOpts.Metrics, pool)
+		}
+	}
+	if len(proxyOpts.WarmHosts) > 0 {
+		go mc.Warm(proxyOpts.WarmHosts)
+	}
+	if proxyOpts.Metrics != nil {
+		registerCertMetrics(proxyOpts.Metrics, mc)
+	}
 
-	// Trust the CA in the proxy's TLS config so it can verify itself if needed,
-	// though mostly this is for the client to trust.
-	// Martian handles the MITM logic.
-
-	// Create proxy
+	// Create proxy. TLS interception is done by MITMModifier rather than
+	// martian so the client-facing handshake can be configured; decrypted
+	// tunnels are served from an in-process listener.
 	p := martian.NewProxy()
-	p.SetMITM(mc)
+	bindings, err := resolveBindings(proxyOpts.Bindings, proxyOpts.Timeouts.Dial)
+	if err != nil {
+		panic(fmt.Sprintf("failed to bind upstream connections: %v", err))
+	}
+	resolvers, err := proxyOpts.DialStrategy.resolvers()
+	if err != nil {
+		panic(fmt.Sprintf("invalid dial strategy: %v", err))
+	}
+	overrides, err := proxyOpts.DNS.overrides()
+	if err != nil {
+		panic(fmt.Sprintf("invalid DNS configuration: %v", err))
+	}
+	var dnsServer *net.Resolver
+	if proxyOpts.DNS.Server != "" {
+		if dnsServer, err = newResolver(proxyOpts.DNS.Server, proxyOpts.Timeouts.Dial); err != nil {
+			panic(fmt.Sprintf("invalid DNS configuration: %v", err))
+		}
+	}
+	up := configureUpstream(p, proxyOpts.Timeouts, proxyOpts.TLSPolicy, bindings)
+	up.strategy, up.resolvers = proxyOpts.DialStrategy, resolvers
+	up.overrides, up.dnsServer = overrides, dnsServer
+	if proxyOpts.UpstreamProxy.Address != "" {
+		if err := proxyOpts.UpstreamProxy.validate(); err != nil {
+			panic(fmt.Sprintf("invalid upstream proxy: %v", err))
+		}
+		up.chain = &proxyOpts.UpstreamProxy
+		if up.chain.Auth == ProxyAuthNegotiate {
+			cl, err := up.chain.kerberosClient()
+			if err != nil {
+				panic(fmt.Sprintf("invalid upstream proxy: %v", err))
+			}
+			up.negotiate = kerberosNegotiator(cl)
+		}
+		// Origins are reached through tunnels from the dialer instead.
+		p.GetRoundTripper().(*http.Transport).Proxy = nil
+	}
+
+	tunnels := newConnListener(&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	go p.Serve(tunnels)
 
 	// Logger
-	sl, err := logger.NewSessionLogger(proxyOpts.SessionDir, proxyOpts.LogHeaders, proxyOpts.LogBody, proxyOpts.MaxBodySize)
-	if err != nil {
-		panic(fmt.Sprintf("failed to create session logger: %v", err))
+	newLogger := logger.NewSessionLogger
+	if proxyOpts.ResumeSession {
+		newLogger = logger.ResumeSessionLogger
+	}
+	var sl *logger.SessionLogger
+	if proxyOpts.SessionFile {
+		sl, err = newLogger(proxyOpts.SessionDir, proxyOpts.LogHeaders, proxyOpts.LogBody, proxyOpts.MaxBodySize)
+		if err != nil {
+			panic(fmt.Sprintf("failed to create session logger: %v", err))
+		}
+	} else {
+		sl = logger.NewRemoteLogger(proxyOpts.LogHeaders, proxyOpts.LogBody, proxyOpts.MaxBodySize)
+	}
+	for _, s := range proxyOpts.LogSinks {
+		sl.AddSink(s.Sink, s.Options)
+	}
+	if proxyOpts.Metrics != nil && len(proxyOpts.LogSinks) > 0 {
+		registerLogSinkMetrics(proxyOpts.Metrics, sl)
+	}
+
+	sl.SetRotation(proxyOpts.RotateSize, proxyOpts.RotateInterval, proxyOpts.RotateCompress)
+	if proxyOpts.LogQueueSize > 0 {
+		sl.SetAsync(proxyOpts.LogQueueSize, proxyOpts.LogQueuePolicy, proxyOpts.LogSyncInterval)
+		if proxyOpts.LogDegrade != (logger.DegradePolicy{}) {
+			sl.SetDegradePolicy(proxyOpts.LogDegrade)
+		}
+		if proxyOpts.Metrics != nil {
+			registerLogQueueMetrics(proxyOpts.Metrics, sl)
+		}
 	}
 
+	if proxyOpts.LogServerCerts {
+		up.Logger = sl
+	}
+
+	hints := newTroubleshooter(sl)
+	p.SetRoundTripper(&hintingTransport{RoundTripper: p.GetRoundTripper(), t: hints})
+	if proxyOpts.Rules != nil {
+		p.SetRoundTripper(&sessionTransport{RoundTripper: p.GetRoundTripper(), Logger: sl})
+	}
+	if proxyOpts.Metrics != nil {
+		hints.register(proxyOpts.Metrics)
+	}
+
+	if proxyOpts.BodyBudget != nil {
+		sl.SetBodyBudget(proxyOpts.BodyBudget)
+		if proxyOpts.Metrics != nil {
+			registerBudgetMetrics(proxyOpts.Metrics, proxyOpts.BodyBudget)
+		}
+	}
+	// Reflection lookups reach origins the way proxied requests do.
+	reflection := grpcdecode.NewResolver()
+	reflection.Dial = up.dial
+	reflection.TLSConfig = up.tlsConfig
+	sl.SetBodyDecoder(logger.DecoderChain{
+		&grpcdecode.Decoder{Resolver: reflection, Schema: proxyOpts.ProtoSchema},
+		&grpcdecode.ProtobufDecoder{Schema: proxyOpts.ProtoSchema, Types: proxyOpts.ProtoTypes},
+		gitproto.Decoder{},
+		registry.ManifestDecoder{},
+		doh.Decoder{},
+		codec.BodyDecoder{},
+	})
+	sl.SetProtocolDetector(logger.DetectorChain{
+		registry.Detector{LogBlobs: proxyOpts.LogRegistryBlobs},
+		s3.Detector{},
+		doh.Detector{},
+	})
+	sl.SetJWTDecoder(jwt.Decoder{})
+	sl.SetGraphQLParser(graphql.Parser{})
+	if proxyOpts.RedactS3 {
+		sl.SetRedactor(s3.Redactor{})
+	}
+	sl.SetLabels(proxyOpts.Labels)
+
 	// Modifiers
 	fg := fifo.NewGroup()
+	fg.AddRequestModifier(&RequestIDModifier{ExposeHeader: proxyOpts.ExposeID})
+	if !proxyOpts.Scope.Empty() {
+		fg.AddRequestModifier(&ScopeModifier{Scope: proxyOpts.Scope})
+	}
+	fg.AddRequestModifier(&TagModifier{Logger: sl})
+
+	var tracingMod *TracingModifier
+	if proxyOpts.Tracer != nil {
+		tracingMod = &TracingModifier{Tracer: proxyOpts.Tracer, Propagate: proxyOpts.PropagateTrace}
+		fg.AddRequestModifier(tracingMod)
+	}
+
+	if proxyOpts.Limiter != nil {
+		fg.AddRequestModifier(proxyOpts.Limiter)
+		if proxyOpts.Metrics != nil {
+			registerLimitMetrics(proxyOpts.Metrics, proxyOpts.Limiter)
+		}
+	}
+
+	// Redirect chains can only be linked when both halves of the exchange are
+	// observed.
+	var redirects *redirectTracker
+	if proxyOpts.LogRequests && proxyOpts.LogResponses {
+		redirects = newRedirectTracker()
+	}
 
 	if proxyOpts.LogRequests {
-		reqMod := &RequestModifier{Logger: sl}
+		reqMod := &RequestModifier{Logger: sl, Redirects: redirects}
 		fg.AddRequestModifier(reqMod)
 	}
 
+	// The cache stores responses before any other modifier changes them.
+	var cacheMod *CacheModifier
+	if proxyOpts.Cache != nil {
+		cacheMod = &CacheModifier{Cache: proxyOpts.Cache, Budget: proxyOpts.BodyBudget}
+		fg.AddResponseModifier(cacheMod)
+		if proxyOpts.Metrics != nil {
+			registerCacheMetrics(proxyOpts.Metrics, proxyOpts.Cache)
+		}
+	}
+
+	var mapMod *MapRemoteModifier
+	if proxyOpts.Rules != nil {
+		rulesMod := &RulesModifier{Rules: proxyOpts.Rules, Logger: sl, Budget: proxyOpts.BodyBudget}
+		mapMod = &MapRemoteModifier{Logger: sl}
+		fg.AddRequestModifier(rulesMod)
+		fg.AddResponseModifier(mapMod)
+		fg.AddResponseModifier(rulesMod)
+	}
+
+	if len(proxyOpts.HeaderProfiles) > 0 || len(proxyOpts.GlobalHeaders) > 0 {
+		if err := proxyOpts.HeaderProfiles.Validate(); err != nil {
+			panic(fmt.Sprintf("invalid header profiles: %v", err))
+		}
+		if err := proxyOpts.HeaderProfiles.Check(proxyOpts.GlobalHeaders...); err != nil {
+			panic(fmt.Sprintf("invalid global header profiles: %v", err))
+		}
+		headerMod := &HeaderModifier{Profiles: proxyOpts.HeaderProfiles, Global: proxyOpts.GlobalHeaders}
+		fg.AddRequestModifier(headerMod)
+		fg.AddResponseModifier(headerMod)
+	}
+
+	fg.AddResponseModifier(&AltSvcModifier{Strip: proxyOpts.StripAltSvc, Logger: sl})
+	if proxyOpts.HSTS != "" {
+		fg.AddResponseModifier(&HSTSModifier{Mode: proxyOpts.HSTS, Logger: sl})
+	}
+
+	if proxyOpts.CookieJar != nil || proxyOpts.Rules != nil {
+		cookieMod := &CookieModifier{Jar: proxyOpts.CookieJar}
+		fg.AddRequestModifier(cookieMod)
+		fg.AddResponseModifier(cookieMod)
+	}
+
+	if proxyOpts.Rules != nil {
+		fg.AddRequestModifier(&JWTModifier{Logger: sl})
+	}
+
+	if proxyOpts.Validator != nil {
+		valMod := &ValidationModifier{
+			Validator: proxyOpts.Validator,
+			Logger:    sl,
+			Responses: proxyOpts.ValidateResponses,
+			Reject:    proxyOpts.RejectInvalid,
+		}
+		fg.AddRequestModifier(valMod)
+		fg.AddResponseModifier(valMod)
+	}
+
+	if proxyOpts.Pages != nil {
+		root := chain[len(chain)-1]
+		pageMod := &PageModifier{
+			Pages:     proxyOpts.Pages,
+			TrustHost: proxyOpts.TrustHost,
+			CA:        pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: root.Raw}),
+		}
+		fg.AddRequestModifier(pageMod)
+		fg.AddResponseModifier(pageMod)
+	}
+
+	var pluginMod *PluginModifier
+	if len(proxyOpts.Plugins) > 0 {
+		pluginMod = &PluginModifier{Plugins: proxyOpts.Plugins, MaxBody: proxyOpts.PluginMaxBody, Logger: sl, Budget: proxyOpts.BodyBudget}
+		fg.AddRequestModifier(pluginMod)
+		fg.AddResponseModifier(pluginMod)
+	}
+
+	var sensitiveMod *SensitiveModifier
+	if proxyOpts.Sensitive != nil && !proxyOpts.Sensitive.Empty() {
+		sensitiveMod = &SensitiveModifier{Scanner: proxyOpts.Sensitive, Logger: sl, Warn: proxyOpts.SensitiveWarn}
+		fg.AddRequestModifier(sensitiveMod)
+	}
+
+	// Refusals replace whatever the modifiers above made of the response.
+	if proxyOpts.Limiter != nil {
+		fg.AddResponseModifier(proxyOpts.Limiter)
+	}
+
 	if proxyOpts.LogResponses {
-		respMod := &ResponseModifier{Logger: sl}
+		respMod := &ResponseModifier{Logger: sl, Redirects: redirects}
 		fg.AddResponseModifier(respMod)
 	}
 
+	if proxyOpts.LogResponses {
+		fg.AddResponseModifier(&RangeModifier{Logger: sl, Tracker: artifact.NewTracker()})
+	}
+
+	if proxyOpts.Findings != nil {
+		fg.AddResponseModifier(&FindingsModifier{Scanner: proxyOpts.Findings, Logger: sl})
+	}
+
+	if sensitiveMod != nil {
+		fg.AddResponseModifier(sensitiveMod)
+	}
+
+	sseMod := &SSEModifier{}
+	if proxyOpts.LogResponses && proxyOpts.LogSSEEvents {
+		sseMod.Logger = sl
+	}
+	fg.AddResponseModifier(sseMod)
+
+	if tracingMod != nil {
+		fg.AddResponseModifier(tracingMod)
+	}
+
+	if cacheMod != nil {
+		fg.AddRequestModifier(cacheMod)
+	}
+
+	// Requests are sent elsewhere only after every other modifier has seen
+	// the original destination.
+	if mapMod != nil {
+		fg.AddRequestModifier(mapMod)
+	}
+
+	mitmMod := &MITMModifier{
+		Config:       mc,
+		Passthrough:  proxyOpts.TLSPolicy.PassthroughHosts,
+		Scope:        proxyOpts.Scope,
+		Interception: proxyOpts.Interception,
+		DialTimeout:  proxyOpts.Timeouts.Dial,
+		Dial:         up.dial,
+		Hints:        hints,
+		listener:     tunnels,
+	}
+	if proxyOpts.LogTunnels {
+		mitmMod.Logger = sl
+		mitmMod.TunnelSample = proxyOpts.TunnelSample
+	}
+	if proxyOpts.Metrics != nil {
+		registerTunnelMetrics(proxyOpts.Metrics, mitmMod)
+	}
+	fg.AddRequestModifier(mitmMod)
+
 	p.SetRequestModifier(fg)
 	p.SetResponseModifier(fg)
 
+	timeout := proxyOpts.Timeouts.Request
+	if timeout <= 0 {
+		timeout = defaultRequestTimeout
+	}
+	if l := proxyOpts.MainListener; l != nil {
+		if l.Listener, err = l.wrap(mc, timeout); err != nil {
+			panic(fmt.Sprintf("failed to configure listener: %v", err))
+		}
+	}
+	for _, l := range proxyOpts.Listeners {
+		l.Listener = proxyOpts.Limiter.Listener(l.Listener)
+		wrapped, err := l.wrap(mc, timeout)
+		if err != nil {
+			panic(fmt.Sprintf("failed to configure listener: %v", err))
+		}
+		go func() {
+			if err := p.Serve(wrapped); err != nil && !errors.Is(err, net.ErrClosed) {
+				log.Errorf("rogue: %s listener on %s stopped: %v", l.Mode, wrapped.Addr(), err)
+			}
+		}()
+	}
+
 	return p, sl
 }
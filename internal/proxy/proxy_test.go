@@ -2,9 +2,11 @@ package proxy
 
 import (
 	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
 	"path/filepath"
@@ -83,3 +85,49 @@ func TestNewProxyServer(t *testing.T) {
 	// Close logger to ensure all data is flushed
 	sl.Close()
 }
+
+func TestCAPEM(t *testing.T) {
+	origin := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer origin.Close()
+
+	certPEM, keyPEM, err := cert.NewSelfSigned("Rogue Proxy", "Rogue Env CA", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpDir := t.TempDir()
+	certPath := filepath.Join(tmpDir, "certs", "ca.crt")
+	p, sl := NewProxyServer(
+		WithCert(certPath, filepath.Join(tmpDir, "certs", "ca.key")),
+		WithCAPEM(certPEM, keyPEM),
+		WithSessionDir(filepath.Join(tmpDir, "logs")),
+		WithTLSPolicy(TLSPolicy{SkipVerifyHosts: []string{"127.0.0.1"}}),
+	)
+	defer sl.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go p.Serve(l)
+	defer p.Close()
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(certPEM)
+	proxyURL, _ := url.Parse("http://" + l.Addr().String())
+	client := &http.Client{Transport: &http.Transport{
+		Proxy:           http.ProxyURL(proxyURL),
+		TLSClientConfig: &tls.Config{RootCAs: roots},
+	}}
+	resp, err := client.Get(origin.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if issuer := resp.TLS.PeerCertificates[0].Issuer.CommonName; issuer != "Rogue Env CA" {
+		t.Errorf("leaf issued by %q", issuer)
+	}
+	// The CA is neither read from nor written to the configured paths.
+	if _, err := os.Stat(certPath); !os.IsNotExist(err) {
+		t.Errorf("stat %s: %v", certPath, err)
+	}
+}
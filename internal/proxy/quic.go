@@ -0,0 +1,102 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/standrze/rogue/internal/logger"
+)
+
+// AltSvcModifier notices origins advertising HTTP/3 in their Alt-Svc
+// header. A client that follows the advertisement switches to QUIC over
+// UDP, which the proxy does not see; with Strip set the HTTP/3
+// alternatives are removed so that it stays on TCP and is intercepted.
+type AltSvcModifier struct {
+	Strip  bool
+	Logger *logger.SessionLogger
+
+	// seen holds the hosts already annotated.
+	seen sync.Map
+}
+
+func (m *AltSvcModifier) ModifyResponse(res *http.Response) error {
+	values := res.Header.Values("Alt-Svc")
+	if len(values) == 0 {
+		return nil
+	}
+	var kept, quic []string
+	for _, v := range values {
+		for _, alt := range splitAltSvc(v) {
+			if isQUICAlt(alt) {
+				quic = append(quic, alt)
+			} else {
+				kept = append(kept, alt)
+			}
+		}
+	}
+	if len(quic) == 0 {
+		return nil
+	}
+	if m.Strip {
+		res.Header.Del("Alt-Svc")
+		if len(kept) > 0 {
+			res.Header.Set("Alt-Svc", strings.Join(kept, ", "))
+		}
+	}
+	m.note(res.Request, quic)
+	return nil
+}
+
+// note annotates the first response from each host that advertised
+// HTTP/3.
+func (m *AltSvcModifier) note(req *http.Request, alts []string) {
+	if m.Logger == nil || req == nil {
+		return
+	}
+	if _, dup := m.seen.LoadOrStore(req.Host, true); dup {
+		return
+	}
+	comment := fmt.Sprintf("quic: %s advertises HTTP/3 (%s); clients may bypass the proxy", req.Host, strings.Join(alts, ", "))
+	if m.Strip {
+		comment = fmt.Sprintf("quic: stripped HTTP/3 alternatives (%s) from %s", strings.Join(alts, ", "), req.Host)
+	}
+	m.Logger.WriteEntry("annotation", logger.Annotation{
+		Timestamp: time.Now(),
+		RequestID: requestID(req),
+		Comment:   comment,
+		Tags:      []string{"quic"},
+	})
+}
+
+// splitAltSvc splits an Alt-Svc value into its alternatives, leaving
+// commas inside quoted authorities alone.
+func splitAltSvc(v string) []string {
+	var alts []string
+	quoted, start := false, 0
+	for i := 0; i <= len(v); i++ {
+		if i < len(v) && v[i] == '"' {
+			quoted = !quoted
+		}
+		if i == len(v) || (v[i] == ',' && !quoted) {
+			if alt := strings.TrimSpace(v[start:i]); alt != "" {
+				alts = append(alts, alt)
+			}
+			start = i + 1
+		}
+	}
+	return alts
+}
+
+// isQUICAlt reports whether alt names an HTTP/3 or QUIC protocol, such as
+// h3="":443, h3-29=":443" or quic=":443".
+func isQUICAlt(alt string) bool {
+	proto, _, ok := strings.Cut(alt, "=")
+	if !ok {
+		return false
+	}
+	proto = strings.ToLower(strings.TrimSpace(proto))
+	return proto == "h3" || strings.HasPrefix(proto, "h3-") || proto == "quic" || strings.HasPrefix(proto, "hq")
+}
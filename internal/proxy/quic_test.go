@@ -0,0 +1,80 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/standrze/rogue/internal/logger"
+)
+
+func TestAltSvcStripping(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Alt-Svc", `h3=":443"; ma=86400, h3-29=":443"; ma=86400`)
+		w.Header().Add("Alt-Svc", `h2="alt.test:443", quic=":443"`)
+	}))
+	defer origin.Close()
+
+	tmpDir := t.TempDir()
+	sessionDir := filepath.Join(tmpDir, "logs")
+	p, sl := NewProxyServer(
+		WithCert(filepath.Join(tmpDir, "ca.crt"), filepath.Join(tmpDir, "ca.key")),
+		WithSessionDir(sessionDir),
+		WithAltSvcStripping(true),
+	)
+	defer sl.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go p.Serve(l)
+	defer p.Close()
+
+	proxyURL, _ := url.Parse("http://" + l.Addr().String())
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+	for range 2 {
+		resp, err := client.Get(origin.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if got := resp.Header.Values("Alt-Svc"); !slices.Equal(got, []string{`h2="alt.test:443"`}) {
+			t.Errorf("Alt-Svc = %q", got)
+		}
+	}
+	sl.Close()
+
+	entries, err := logger.ReadSession(filepath.Join(sessionDir, sl.GetSessionName()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	flows, err := logger.BuildFlows(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Only the first response from the host is annotated.
+	if len(flows) != 2 || len(flows[0].Annotations) != 1 || len(flows[1].Annotations) != 0 {
+		t.Fatalf("flows = %+v", flows)
+	}
+	if a := flows[0].Annotations[0]; !slices.Equal(a.Tags, []string{"quic"}) {
+		t.Errorf("annotation = %+v", a)
+	}
+}
+
+func TestSplitAltSvc(t *testing.T) {
+	got := splitAltSvc(`h3=":443"; ma=3600, h2="a,b:443" , clear`)
+	want := []string{`h3=":443"; ma=3600`, `h2="a,b:443"`, "clear"}
+	if !slices.Equal(got, want) {
+		t.Errorf("splitAltSvc = %q, want %q", got, want)
+	}
+	for alt, quic := range map[string]bool{`h3=":443"`: true, `H3-29=":443"`: true, `quic=":443"`: true, `h2=":443"`: false, "clear": false} {
+		if isQUICAlt(alt) != quic {
+			t.Errorf("isQUICAlt(%q) = %v", alt, !quic)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package proxy
+
+import (
+	"cmp"
+	"net/http"
+	"time"
+
+	"github.com/standrze/rogue/internal/artifact"
+	"github.com/standrze/rogue/internal/logger"
+)
+
+// RangeModifier stitches ranged downloads together as their 206 responses
+// pass through, writing an "artifact" entry once every byte of a resource
+// has been fetched. Downloads left incomplete are reported by rogue
+// sessions downloads, which stitches the recorded flows the same way.
+type RangeModifier struct {
+	Logger  *logger.SessionLogger
+	Tracker *artifact.Tracker
+}
+
+func (m *RangeModifier) ModifyResponse(res *http.Response) error {
+	if res.StatusCode != http.StatusPartialContent || res.Request == nil || !inScope(res.Request) {
+		return nil
+	}
+	a, ok := m.Tracker.Observe(artifact.Part{
+		FlowID:       requestID(res.Request),
+		URL:          m.Logger.RedactURL(res.Request.URL),
+		ContentRange: res.Header.Get("Content-Range"),
+		Validator:    cmp.Or(res.Header.Get("ETag"), res.Header.Get("Last-Modified")),
+		ContentType:  res.Header.Get("Content-Type"),
+		Time:         time.Now(),
+	})
+	if !ok || !a.Complete {
+		return nil
+	}
+	m.Tracker.Forget(a)
+	return m.Logger.WriteEntry("artifact", a)
+}
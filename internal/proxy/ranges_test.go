@@ -0,0 +1,85 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/standrze/rogue/internal/artifact"
+	"github.com/standrze/rogue/internal/logger"
+)
+
+func TestRangeStitching(t *testing.T) {
+	content := strings.Repeat("0123456789", 100)
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"abc"`)
+		http.ServeContent(w, r, "file.bin", time.Time{}, strings.NewReader(content))
+	}))
+	defer origin.Close()
+
+	tmpDir := t.TempDir()
+	sessionDir := filepath.Join(tmpDir, "logs")
+	p, sl := NewProxyServer(
+		WithCert(filepath.Join(tmpDir, "ca.crt"), filepath.Join(tmpDir, "ca.key")),
+		WithSessionDir(sessionDir),
+	)
+	defer sl.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go p.Serve(l)
+	defer p.Close()
+
+	proxyURL, _ := url.Parse("http://" + l.Addr().String())
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+	for _, r := range []string{"bytes=0-499", "bytes=400-899", "bytes=900-"} {
+		req, _ := http.NewRequest("GET", origin.URL+"/file.bin", nil)
+		req.Header.Set("Range", r)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusPartialContent {
+			t.Fatalf("%s: got %d", r, resp.StatusCode)
+		}
+	}
+
+	entries, err := logger.ReadSession(filepath.Join(sessionDir, sl.GetSessionName()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found []artifact.Artifact
+	for _, e := range entries {
+		if e.Type == "artifact" {
+			var a artifact.Artifact
+			json.Unmarshal(e.Data, &a)
+			found = append(found, a)
+		}
+	}
+	if len(found) != 1 {
+		t.Fatalf("Expected one artifact entry, got %d", len(found))
+	}
+	a := found[0]
+	if !a.Complete || a.Size != 1000 || a.Received != 1000 || a.Fetched != 1100 || len(a.Flows) != 3 || a.Validator != `"abc"` {
+		t.Errorf("Unexpected artifact %+v", a)
+	}
+
+	flows, err := logger.LoadFlows(filepath.Join(sessionDir, sl.GetSessionName()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stitched := artifact.Stitch(flows); len(stitched) != 1 || stitched[0].Received != 1000 {
+		t.Errorf("Stitching the recorded flows gave %+v", stitched)
+	}
+}
@@ -0,0 +1,82 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// redirectTTL bounds how long a redirect target waits for the client to
+// follow it before it is forgotten.
+const redirectTTL = time.Minute
+
+type redirectHop struct {
+	requestID string
+	seen      time.Time
+}
+
+// redirectTracker links requests that follow a 3xx Location back to the
+// request that produced the redirect, so a chain can be reassembled from
+// the session log.
+type redirectTracker struct {
+	mu      sync.Mutex
+	pending map[string]redirectHop
+}
+
+func newRedirectTracker() *redirectTracker {
+	return &redirectTracker{pending: make(map[string]redirectHop)}
+}
+
+func redirectKey(req *http.Request, target string) string {
+	client, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		client = req.RemoteAddr
+	}
+	return client + " " + target
+}
+
+// Observe records the Location of a redirect response so the follow-up
+// request can be linked to requestID.
+func (t *redirectTracker) Observe(res *http.Response, requestID string) {
+	if res.StatusCode < 300 || res.StatusCode >= 400 || res.Request == nil {
+		return
+	}
+
+	loc, err := res.Location()
+	if err != nil {
+		return
+	}
+
+	now := time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for k, hop := range t.pending {
+		if now.Sub(hop.seen) > redirectTTL {
+			delete(t.pending, k)
+		}
+	}
+	t.pending[redirectKey(res.Request, loc.String())] = redirectHop{requestID: requestID, seen: now}
+}
+
+// Follow returns the ID of the request whose redirect req is following, or
+// an empty string if req does not continue a known chain.
+func (t *redirectTracker) Follow(req *http.Request) string {
+	key := redirectKey(req, req.URL.String())
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	hop, ok := t.pending[key]
+	if !ok {
+		return ""
+	}
+	delete(t.pending, key)
+
+	if time.Since(hop.seen) > redirectTTL {
+		return ""
+	}
+	return hop.requestID
+}
@@ -0,0 +1,37 @@
+package proxy
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestRedirectTracker(t *testing.T) {
+	tracker := newRedirectTracker()
+
+	origin, _ := url.Parse("http://example.com/login")
+	req := &http.Request{URL: origin, RemoteAddr: "10.0.0.1:50000"}
+	res := &http.Response{
+		StatusCode: http.StatusFound,
+		Header:     http.Header{"Location": []string{"/home"}},
+		Request:    req,
+	}
+	tracker.Observe(res, "first")
+
+	// A different client following the same URL is not part of the chain.
+	target, _ := url.Parse("http://example.com/home")
+	other := &http.Request{URL: target, RemoteAddr: "10.0.0.2:50000"}
+	if got := tracker.Follow(other); got != "" {
+		t.Errorf("Expected no redirect for other client, got %q", got)
+	}
+
+	follow := &http.Request{URL: target, RemoteAddr: "10.0.0.1:50001"}
+	if got := tracker.Follow(follow); got != "first" {
+		t.Errorf("Expected redirect_of %q, got %q", "first", got)
+	}
+
+	// Each hop is only linked once.
+	if got := tracker.Follow(follow); got != "" {
+		t.Errorf("Expected hop to be consumed, got %q", got)
+	}
+}
@@ -0,0 +1,99 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/standrze/rogue/internal/budget"
+	"github.com/standrze/rogue/internal/logger"
+	"github.com/standrze/rogue/internal/rules"
+)
+
+// maxRewriteBody bounds the bodies read into memory to be rewritten;
+// larger ones pass through unchanged.
+const maxRewriteBody = 16 << 20
+
+// rewriteRequest applies the request edits of rule to req. Response edits
+// need a body the proxy can read, so the client's Accept-Encoding is
+// dropped and the transport negotiates, and decodes, compression itself.
+func (m *RulesModifier) rewriteRequest(req *http.Request, rule rules.Rule) error {
+	if len(rule.Rewrite.Response) > 0 {
+		req.Header.Del("Accept-Encoding")
+	}
+	if len(rule.Rewrite.Request) == 0 {
+		return nil
+	}
+	changed, err := rewriteBody(m.Budget, &req.Body, &req.ContentLength, &req.TransferEncoding, req.Header, rule.Rewrite.Request)
+	if changed {
+		m.noteRewrite(req, rule, "request")
+	}
+	return err
+}
+
+// rewriteResponse applies the response edits of rule to res.
+func (m *RulesModifier) rewriteResponse(res *http.Response, rule rules.Rule) error {
+	if len(rule.Rewrite.Response) == 0 || !bodyAllowed(res) {
+		return nil
+	}
+	changed, err := rewriteBody(m.Budget, &res.Body, &res.ContentLength, &res.TransferEncoding, res.Header, rule.Rewrite.Response)
+	if changed {
+		m.noteRewrite(res.Request, rule, "response")
+	}
+	return err
+}
+
+// bodyAllowed reports whether res may carry a body.
+func bodyAllowed(res *http.Response) bool {
+	if res.Request != nil && res.Request.Method == http.MethodHead {
+		return false
+	}
+	return res.StatusCode != http.StatusNoContent && res.StatusCode != http.StatusNotModified
+}
+
+func (m *RulesModifier) noteRewrite(req *http.Request, rule rules.Rule, side string) {
+	if m.Logger == nil || req == nil {
+		return
+	}
+	m.Logger.WriteEntry("annotation", logger.Annotation{
+		Timestamp: time.Now(),
+		RequestID: requestID(req),
+		Comment:   fmt.Sprintf("rewrite: rule %q changed the %s body", rule.Name, side),
+	})
+}
+
+// rewriteBody applies edits to *body, reporting whether it changed. A body
+// that was read is sent with a Content-Length rather than chunked.
+// Compressed, streamed and oversized bodies, and those the memory budget
+// has no room for, are left alone.
+func rewriteBody(b *budget.Budget, body *io.ReadCloser, length *int64, te *[]string, h http.Header, edits []rules.BodyEdit) (bool, error) {
+	if *body == nil || *body == http.NoBody {
+		return false, nil
+	}
+	if ce := h.Get("Content-Encoding"); ce != "" && ce != "identity" {
+		return false, nil
+	}
+	if mt, _, _ := mime.ParseMediaType(h.Get("Content-Type")); mt == "text/event-stream" {
+		return false, nil
+	}
+
+	data, rest, ok, err := b.Buffer(*body, maxRewriteBody)
+	if err != nil {
+		return false, err
+	}
+	*body = rest
+	if !ok {
+		return false, nil
+	}
+
+	out, changed := rules.ApplyEdits(edits, data)
+	*body = readCloser{bytes.NewReader(out), rest}
+	*length = int64(len(out))
+	*te = nil
+	h.Set("Content-Length", strconv.Itoa(len(out)))
+	return changed, nil
+}
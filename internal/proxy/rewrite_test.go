@@ -0,0 +1,82 @@
+package proxy
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/standrze/rogue/internal/rules"
+)
+
+func TestRewriteRules(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		resp, _ := json.Marshal(map[string]any{"echo": string(body), "secret": "s3cr3t", "encoding": r.Header.Get("Accept-Encoding")})
+		w.Header().Set("Content-Type", "application/json")
+		if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			w.Header().Set("Content-Encoding", "gzip")
+			zw := gzip.NewWriter(w)
+			zw.Write(resp)
+			zw.Close()
+			return
+		}
+		w.Write(resp)
+	}))
+	defer origin.Close()
+
+	tmpDir := t.TempDir()
+	set := rules.NewSet(rules.Rule{
+		Name:  "rewrite",
+		Match: rules.Match{Path: "/api"},
+		Rewrite: &rules.Rewrite{
+			Request:  []rules.BodyEdit{{Regex: "alice", Replace: "mallory"}},
+			Response: []rules.BodyEdit{{JSONPath: "$.secret", Delete: true}, {JSONPath: "$.injected", Set: true}},
+		},
+	})
+	p, sl := NewProxyServer(
+		WithCert(filepath.Join(tmpDir, "ca.crt"), filepath.Join(tmpDir, "ca.key")),
+		WithSessionDir(filepath.Join(tmpDir, "logs")),
+		WithRules(set),
+	)
+	defer sl.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go p.Serve(l)
+	defer p.Close()
+
+	proxyURL, _ := url.Parse("http://" + l.Addr().String())
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL), DisableCompression: true}}
+
+	req, _ := http.NewRequest("POST", origin.URL+"/api", strings.NewReader("user=alice"))
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.Header.Get("Content-Encoding") != "" {
+		t.Fatalf("Expected an uncompressed response to rewrite, got %q", resp.Header.Get("Content-Encoding"))
+	}
+	if resp.ContentLength != int64(len(body)) {
+		t.Errorf("Content-Length %d does not match the %d byte body", resp.ContentLength, len(body))
+	}
+	var got map[string]any
+	if err := json.Unmarshal(body, &got); err != nil {
+		t.Fatalf("Rewritten body is not JSON: %s", body)
+	}
+	if got["echo"] != "user=mallory" || got["secret"] != nil || got["injected"] != true {
+		t.Errorf("Unexpected rewritten body %s", body)
+	}
+}
@@ -0,0 +1,163 @@
+package proxy
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/martian/v3"
+	"github.com/standrze/rogue/internal/budget"
+	"github.com/standrze/rogue/internal/logger"
+	"github.com/standrze/rogue/internal/rules"
+)
+
+const ruleKey = "rogue.rule"
+
+// RulesModifier applies the first matching rule to each exchange. It must
+// run before the logging response modifier so the log shows what the client
+// actually received.
+type RulesModifier struct {
+	Rules *rules.Set
+	// Logger, if set, receives an annotation for each faulted flow and for
+	// each flow matched by a rule with tags or a note.
+	Logger *logger.SessionLogger
+	// Budget, if set, bounds the memory of bodies read to be rewritten.
+	Budget *budget.Budget
+}
+
+func (m *RulesModifier) ModifyRequest(req *http.Request) error {
+	if req.Method == http.MethodConnect {
+		return nil
+	}
+
+	rule, ok := m.Rules.Match(req)
+	if !ok {
+		return nil
+	}
+	ctx := martian.NewContext(req)
+	if ctx == nil {
+		return nil
+	}
+	if rule.Fault != nil && !rollFault(rule.Fault) {
+		rule.Fault = nil
+	}
+	ctx.Set(ruleKey, rule)
+	if m.Logger != nil && (len(rule.Tags) > 0 || rule.Note != "") {
+		m.Logger.WriteEntry("annotation", logger.Annotation{
+			Timestamp: time.Now(),
+			RequestID: requestID(req),
+			Comment:   rule.Note,
+			Tags:      rule.Tags,
+		})
+	}
+
+	if rule.Block != nil && rule.Block.Reset {
+		conn, _, err := ctx.Session().Hijack()
+		if err != nil {
+			return err
+		}
+		resetConn(conn)
+		return nil
+	}
+	if rule.Mock != nil || rule.Block != nil {
+		ctx.SkipRoundTrip()
+	}
+	if rule.Fault != nil {
+		m.injectFault(req, ctx, rule)
+	}
+	if rule.Rewrite != nil {
+		return m.rewriteRequest(req, rule)
+	}
+	return nil
+}
+
+func (m *RulesModifier) ModifyResponse(res *http.Response) error {
+	rule, ok := matchedRule(res.Request)
+	if !ok {
+		return nil
+	}
+
+	if rule.Mock != nil {
+		applyMock(res, rule.Mock)
+	}
+	if rule.Block != nil {
+		applyMock(res, blockMock(rule))
+	}
+	if rule.Rewrite != nil {
+		if err := m.rewriteResponse(res, rule); err != nil {
+			return err
+		}
+	}
+	if rule.Fault != nil {
+		return applyFault(res, rule.Fault)
+	}
+	return nil
+}
+
+// blockMock is the response to a request refused by rule.
+func blockMock(rule rules.Rule) *rules.Mock {
+	m := &rules.Mock{
+		Status:  cmp.Or(rule.Block.Status, http.StatusForbidden),
+		Headers: map[string]string{"Content-Type": "text/plain; charset=utf-8"},
+		Body:    rule.Block.Body,
+	}
+	if m.Body == "" {
+		m.Body = fmt.Sprintf("Blocked by rule %q\n", rule.Name)
+	}
+	return m
+}
+
+// resetConn closes c with a TCP reset instead of an orderly shutdown, so
+// the client sees the connection fail.
+func resetConn(c net.Conn) {
+	inner := c
+	for {
+		if u, ok := inner.(interface{ NetConn() net.Conn }); ok {
+			inner = u.NetConn()
+			continue
+		}
+		break
+	}
+	if tcp, ok := inner.(*net.TCPConn); ok {
+		tcp.SetLinger(0)
+	}
+	inner.Close()
+	c.Close()
+}
+
+// matchedRule returns the rule RulesModifier selected for req.
+func matchedRule(req *http.Request) (rules.Rule, bool) {
+	if ctx := martian.NewContext(req); ctx != nil {
+		if v, ok := ctx.Get(ruleKey); ok {
+			return v.(rules.Rule), true
+		}
+	}
+	return rules.Rule{}, false
+}
+
+func applyMock(res *http.Response, mock *rules.Mock) {
+	status := mock.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	res.StatusCode = status
+	res.Status = fmt.Sprintf("%d %s", status, http.StatusText(status))
+
+	res.Header = make(http.Header)
+	for k, v := range mock.Headers {
+		res.Header.Set(k, v)
+	}
+
+	if res.Body != nil {
+		res.Body.Close()
+	}
+	res.Body = io.NopCloser(strings.NewReader(mock.Body))
+	res.ContentLength = int64(len(mock.Body))
+	res.Header.Set("Content-Length", strconv.Itoa(len(mock.Body)))
+	res.TransferEncoding = nil
+}
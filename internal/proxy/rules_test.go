@@ -0,0 +1,135 @@
+package proxy
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	"github.com/standrze/rogue/internal/logger"
+	"github.com/standrze/rogue/internal/rules"
+)
+
+func TestMockRuleSkipsOrigin(t *testing.T) {
+	tmpDir := t.TempDir()
+	set := rules.NewSet(rules.Rule{
+		Name:  "stub",
+		Match: rules.Match{Host: "unbuilt.invalid", Path: "/status"},
+		Mock:  &rules.Mock{Status: 202, Headers: map[string]string{"Content-Type": "text/plain"}, Body: "mocked"},
+	})
+	p, sl := NewProxyServer(
+		WithCert(filepath.Join(tmpDir, "ca.crt"), filepath.Join(tmpDir, "ca.key")),
+		WithSessionDir(filepath.Join(tmpDir, "logs")),
+		WithRules(set),
+	)
+	defer sl.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go p.Serve(l)
+	defer p.Close()
+
+	proxyURL, _ := url.Parse("http://" + l.Addr().String())
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+
+	resp, err := client.Get("http://unbuilt.invalid/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != 202 || string(body) != "mocked" || resp.Header.Get("Content-Type") != "text/plain" {
+		t.Errorf("Unexpected mock response %d %q %v", resp.StatusCode, body, resp.Header)
+	}
+}
+
+func TestBlockRules(t *testing.T) {
+	tmpDir := t.TempDir()
+	set := rules.NewSet(
+		rules.Rule{Name: "ads", Match: rules.Match{Hosts: []string{"ads.invalid"}}, Block: &rules.Block{Status: 404}},
+		rules.Rule{Name: "telemetry", Match: rules.Match{URL: "http://app.invalid/v1/telemetry*"}, Block: &rules.Block{Reset: true}},
+	)
+	p, sl := NewProxyServer(
+		WithCert(filepath.Join(tmpDir, "ca.crt"), filepath.Join(tmpDir, "ca.key")),
+		WithSessionDir(filepath.Join(tmpDir, "logs")),
+		WithRules(set),
+	)
+	defer sl.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go p.Serve(l)
+	defer p.Close()
+
+	proxyURL, _ := url.Parse("http://" + l.Addr().String())
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL), DisableKeepAlives: true}}
+
+	resp, err := client.Get("http://ads.invalid/banner.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 404 {
+		t.Errorf("Blocked request got status %d, want 404", resp.StatusCode)
+	}
+
+	if resp, err := client.Get("http://app.invalid/v1/telemetry/batch"); err == nil {
+		resp.Body.Close()
+		t.Errorf("Reset request got status %d, want a connection error", resp.StatusCode)
+	}
+}
+
+func TestRuleTags(t *testing.T) {
+	tmpDir := t.TempDir()
+	sessionDir := filepath.Join(tmpDir, "logs")
+	set := rules.NewSet(rules.Rule{
+		Name:  "flag admin",
+		Match: rules.Match{Host: "unbuilt.invalid", Path: "/admin/*"},
+		Mock:  &rules.Mock{Status: 200, Body: "ok"},
+		Tags:  []string{"admin", "authz"},
+		Note:  "admin surface",
+	})
+	p, sl := NewProxyServer(
+		WithCert(filepath.Join(tmpDir, "ca.crt"), filepath.Join(tmpDir, "ca.key")),
+		WithSessionDir(sessionDir),
+		WithRules(set),
+	)
+	defer sl.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go p.Serve(l)
+	defer p.Close()
+
+	proxyURL, _ := url.Parse("http://" + l.Addr().String())
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+	resp, err := client.Get("http://unbuilt.invalid/admin/users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	flows, err := logger.LoadFlows(filepath.Join(sessionDir, sl.GetSessionName()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(flows) != 1 {
+		t.Fatalf("Expected one flow, got %d", len(flows))
+	}
+	f := flows[0]
+	if tags := f.Tags(); len(tags) != 2 || tags[0] != "admin" || tags[1] != "authz" {
+		t.Errorf("Expected the rule's tags, got %v", tags)
+	}
+	if len(f.Annotations) != 1 || f.Annotations[0].Comment != "admin surface" {
+		t.Errorf("Expected the rule's note, got %+v", f.Annotations)
+	}
+}
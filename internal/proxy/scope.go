@@ -0,0 +1,48 @@
+package proxy
+
+import (
+	"net/http"
+
+	"github.com/google/martian/v3"
+	"github.com/standrze/rogue/internal/scope"
+)
+
+const outOfScopeKey = "rogue.out_of_scope"
+
+// WithScope limits recording, interception and passive scanning to the
+// traffic s contains. Out-of-scope traffic is still forwarded.
+func WithScope(s *scope.Scope) ProxyOption {
+	return func(p *Proxy) {
+		p.Scope = s
+	}
+}
+
+// ScopeModifier marks exchanges outside Scope so the modifiers that record
+// and scan traffic leave them alone.
+type ScopeModifier struct {
+	Scope *scope.Scope
+}
+
+func (m *ScopeModifier) ModifyRequest(req *http.Request) error {
+	in := m.Scope.Contains(req.URL)
+	if req.Method == http.MethodConnect {
+		in = m.Scope.ContainsHost(req.Host)
+	}
+	if ctx := martian.NewContext(req); ctx != nil && !in {
+		ctx.Set(outOfScopeKey, true)
+	}
+	return nil
+}
+
+// inScope reports whether ScopeModifier left req in scope.
+func inScope(req *http.Request) bool {
+	if req == nil {
+		return true
+	}
+	if ctx := martian.NewContext(req); ctx != nil {
+		if _, ok := ctx.Get(outOfScopeKey); ok {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	"github.com/standrze/rogue/internal/findings"
+	"github.com/standrze/rogue/internal/logger"
+	"github.com/standrze/rogue/internal/rules"
+	"github.com/standrze/rogue/internal/scope"
+)
+
+func TestScope(t *testing.T) {
+	tmpDir := t.TempDir()
+	sessionDir := filepath.Join(tmpDir, "logs")
+	set := rules.NewSet(rules.Rule{
+		Name:  "pages",
+		Match: rules.Match{Host: "*.invalid"},
+		Mock:  &rules.Mock{Headers: map[string]string{"Content-Type": "text/html"}, Body: "<p>hi</p>"},
+	})
+	s, err := scope.New([]string{"app.invalid"}, []string{"app.invalid/static/*"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	scanner, _ := findings.NewScanner(nil)
+	p, sl := NewProxyServer(
+		WithCert(filepath.Join(tmpDir, "ca.crt"), filepath.Join(tmpDir, "ca.key")),
+		WithSessionDir(sessionDir),
+		WithRules(set),
+		WithFindings(scanner),
+		WithScope(s),
+	)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go p.Serve(l)
+	defer p.Close()
+
+	proxyURL, _ := url.Parse("http://" + l.Addr().String())
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+	for _, u := range []string{"http://app.invalid/", "http://app.invalid/static/a.css", "http://ads.invalid/"} {
+		req, _ := http.NewRequest("GET", u, nil)
+		req.Header.Set(TagHeader, "seen")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		// Out-of-scope traffic is still forwarded.
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("%s: status %d", u, resp.StatusCode)
+		}
+	}
+	sl.Close()
+
+	entries, err := logger.ReadSession(filepath.Join(sessionDir, sl.GetSessionName()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	flows, err := logger.BuildFlows(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(flows) != 1 || flows[0].Request == nil || flows[0].Request.URL != "http://app.invalid/" {
+		t.Errorf("recorded flows = %+v", flows)
+	}
+	annotations := 0
+	for _, e := range entries {
+		if e.Type == "annotation" {
+			annotations++
+		}
+	}
+	if annotations != 1 || len(flows) == 0 || len(flows[0].Tags()) != 1 {
+		t.Errorf("%d annotations recorded, want only the in-scope request's", annotations)
+	}
+	found, _ := findings.FromEntries(entries)
+	for _, f := range found {
+		if f.URL != "http://app.invalid/" {
+			t.Errorf("out-of-scope finding %+v", f)
+		}
+	}
+	if len(found) == 0 {
+		t.Error("the in-scope page was not scanned")
+	}
+}
@@ -0,0 +1,124 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/standrze/rogue/internal/logger"
+	"github.com/standrze/rogue/internal/sensitive"
+)
+
+// WithSensitiveData scans requests and responses with scanner and annotates
+// the flows carrying sensitive data. Unless warn is nil, a line is also
+// written to it for each.
+func WithSensitiveData(scanner *sensitive.Scanner, warn io.Writer) ProxyOption {
+	return func(p *Proxy) {
+		p.Sensitive = scanner
+		p.SensitiveWarn = warn
+	}
+}
+
+// SensitiveModifier looks for sensitive data in the URL, headers and body of
+// requests and responses. What it finds is recorded as an annotation tagged
+// "sensitive" and "sensitive:<detector>". Bodies are scanned as they are
+// read, so nothing is held back.
+type SensitiveModifier struct {
+	Scanner *sensitive.Scanner
+	Logger  *logger.SessionLogger
+	Warn    io.Writer
+}
+
+func (m *SensitiveModifier) ModifyRequest(req *http.Request) error {
+	if req.Method == http.MethodConnect || !inScope(req) {
+		return nil
+	}
+	target := m.Logger.RedactURL(req.URL)
+	if q, err := url.QueryUnescape(req.URL.RawQuery); err == nil {
+		m.report(req, target, "request URL", m.Scanner.Scan([]byte(req.URL.Path+" "+q)))
+	}
+	m.report(req, target, "request headers", m.Scanner.Scan(headerText(req.Header)))
+	if req.Body != nil && req.Body != http.NoBody && scannable(req.Header) {
+		encoding := req.Header.Get("Content-Encoding")
+		req.Body = &headBody{ReadCloser: req.Body, limit: sensitive.MaxBody, done: func(head []byte) {
+			m.report(req, target, "request body", m.Scanner.Scan(decodeHead(encoding, head, sensitive.MaxBody)))
+		}}
+	}
+	return nil
+}
+
+func (m *SensitiveModifier) ModifyResponse(res *http.Response) error {
+	req := res.Request
+	if req == nil || req.Method == http.MethodConnect || !inScope(req) {
+		return nil
+	}
+	target := m.Logger.RedactURL(req.URL)
+	m.report(req, target, "response headers", m.Scanner.Scan(headerText(res.Header)))
+	if bodyAllowed(res) && res.Body != nil && res.Body != http.NoBody && scannable(res.Header) {
+		encoding := res.Header.Get("Content-Encoding")
+		res.Body = &headBody{ReadCloser: res.Body, limit: sensitive.MaxBody, done: func(head []byte) {
+			m.report(req, target, "response body", m.Scanner.Scan(decodeHead(encoding, head, sensitive.MaxBody)))
+		}}
+	}
+	return nil
+}
+
+func (m *SensitiveModifier) report(req *http.Request, target, where string, found []sensitive.Match) {
+	if len(found) == 0 {
+		return
+	}
+	tags := []string{"sensitive"}
+	var parts []string
+	for _, f := range found {
+		tags = append(tags, "sensitive:"+f.Detector)
+		part := f.Detector + " (" + f.Sample
+		if f.Count > 1 {
+			part += fmt.Sprintf(", %d found", f.Count)
+		}
+		parts = append(parts, part+")")
+	}
+	comment := "sensitive data in the " + where + ": " + strings.Join(parts, ", ")
+	m.Logger.WriteEntry("annotation", logger.Annotation{
+		Timestamp: time.Now(),
+		RequestID: requestID(req),
+		Comment:   comment,
+		Tags:      tags,
+	})
+	if m.Warn != nil {
+		fmt.Fprintf(m.Warn, "rogue: %s %s: %s\n", req.Method, target, comment)
+	}
+}
+
+// headerText renders h one "Name: value" line per value, in name order.
+func headerText(h http.Header) []byte {
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		for _, v := range h[name] {
+			b.WriteString(name + ": " + v + "\n")
+		}
+	}
+	return []byte(b.String())
+}
+
+// scannable reports whether a body with header h is text the detectors can
+// make sense of. Bodies without a Content-Type are scanned too.
+func scannable(h http.Header) bool {
+	ct := h.Get("Content-Type")
+	if ct == "" {
+		return true
+	}
+	mt, _, _ := mime.ParseMediaType(ct)
+	return strings.HasPrefix(mt, "text/") && mt != "text/event-stream" ||
+		strings.HasSuffix(mt, "json") || strings.HasSuffix(mt, "xml") ||
+		mt == "application/x-www-form-urlencoded" || mt == "multipart/form-data"
+}
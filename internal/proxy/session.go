@@ -0,0 +1,161 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/standrze/rogue/internal/logger"
+	"github.com/standrze/rogue/internal/rules"
+)
+
+// maxReplayBody bounds the request bodies kept so a request can be retried
+// after its session is refreshed. Larger requests are sent once.
+const maxReplayBody = 10 << 20
+
+// maxExpiredBody bounds how much of a response or refresh body is read to
+// recognise an expired session or find a new token.
+const maxExpiredBody = 1 << 20
+
+// sessionTransport keeps the requests of rules with a session action
+// authenticated: it puts the rule's current token into each request and,
+// when the response shows the session expired, refreshes the token and
+// retries the request once. Refreshes of a rule are serialized, so a burst
+// of expired requests triggers one.
+type sessionTransport struct {
+	http.RoundTripper
+	Logger *logger.SessionLogger
+
+	// states holds a *sessionState per rule name.
+	states sync.Map
+}
+
+// sessionState is the current token of a rule. gen counts refreshes, so a
+// request sent before the latest one retries with its token instead of
+// refreshing again.
+type sessionState struct {
+	mu    sync.Mutex
+	token string
+	gen   int
+}
+
+func (t *sessionTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rule, ok := matchedRule(req)
+	if !ok || rule.Session == nil {
+		return t.RoundTripper.RoundTrip(req)
+	}
+	s := rule.Session
+	v, _ := t.states.LoadOrStore(rule.Name, &sessionState{})
+	state := v.(*sessionState)
+
+	body, replayable, err := bufferBody(req)
+	if err != nil {
+		return nil, err
+	}
+	state.mu.Lock()
+	token, gen := state.token, state.gen
+	state.mu.Unlock()
+	if token != "" {
+		s.Inject.Apply(req.Header, token)
+	}
+
+	res, err := t.RoundTripper.RoundTrip(req)
+	if err != nil || !replayable || !expired(res, s.Expired) {
+		return res, err
+	}
+
+	token, err = t.refresh(req, state, gen, s)
+	if err != nil {
+		t.note(req, fmt.Sprintf("session: rule %q could not refresh the token after a %d: %v", rule.Name, res.StatusCode, err))
+		return res, nil
+	}
+	res.Body.Close()
+	retry := req.Clone(req.Context())
+	retry.Body = io.NopCloser(bytes.NewReader(body))
+	s.Inject.Apply(retry.Header, token)
+	t.note(req, fmt.Sprintf("session: rule %q refreshed the token after a %d and retried the request", rule.Name, res.StatusCode))
+	return t.RoundTripper.RoundTrip(retry)
+}
+
+// refresh returns a token newer than generation gen, sending the refresh
+// request unless another request already did.
+func (t *sessionTransport) refresh(req *http.Request, state *sessionState, gen int, s *rules.Session) (string, error) {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	if state.gen != gen {
+		return state.token, nil
+	}
+
+	refresh, err := s.Refresh.Request()
+	if err != nil {
+		return "", err
+	}
+	res, err := t.RoundTripper.RoundTrip(refresh.WithContext(req.Context()))
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(res.Body, maxExpiredBody))
+	if err != nil {
+		return "", err
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return "", fmt.Errorf("the refresh request got %d", res.StatusCode)
+	}
+	token, ok := s.Token.Extract(res, body)
+	if !ok {
+		return "", fmt.Errorf("no token in the refresh response")
+	}
+	state.token, state.gen = token, gen+1
+	return token, nil
+}
+
+func (t *sessionTransport) note(req *http.Request, comment string) {
+	if t.Logger == nil {
+		return
+	}
+	t.Logger.WriteEntry("annotation", logger.Annotation{
+		Timestamp: time.Now(),
+		RequestID: requestID(req),
+		Comment:   comment,
+	})
+}
+
+// bufferBody reads the body of req so it can be sent again, and reports
+// whether it could: bodies over maxReplayBody are left streaming.
+func bufferBody(req *http.Request) ([]byte, bool, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, true, nil
+	}
+	body, err := io.ReadAll(io.LimitReader(req.Body, maxReplayBody+1))
+	if err != nil {
+		return nil, false, err
+	}
+	if len(body) > maxReplayBody {
+		req.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), req.Body), req.Body}
+		return nil, false, nil
+	}
+	req.Body.Close()
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	return body, true, nil
+}
+
+// expired reports whether res shows an expired session. When the body is
+// looked at, its start is put back for the client.
+func expired(res *http.Response, e rules.Expired) bool {
+	if !e.MatchesStatus(res.StatusCode) || !e.NeedsBody() {
+		return e.MatchesStatus(res.StatusCode)
+	}
+	head, _ := io.ReadAll(io.LimitReader(res.Body, maxExpiredBody))
+	res.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(head), res.Body), res.Body}
+	return e.Matches(res.StatusCode, decodeHead(res.Header.Get("Content-Encoding"), head, maxExpiredBody))
+}
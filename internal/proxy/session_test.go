@@ -0,0 +1,107 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/standrze/rogue/internal/logger"
+	"github.com/standrze/rogue/internal/rules"
+)
+
+func TestSessionRefresh(t *testing.T) {
+	var logins atomic.Int32
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login":
+			fmt.Fprintf(w, `{"access_token":"tok%d"}`, logins.Add(1))
+		case "/api":
+			if r.Header.Get("Authorization") != "Bearer tok1" {
+				w.WriteHeader(http.StatusUnauthorized)
+				io.WriteString(w, `{"error":"token expired"}`)
+				return
+			}
+			body, _ := io.ReadAll(r.Body)
+			w.Write(body)
+		}
+	}))
+	defer origin.Close()
+	originURL, _ := url.Parse(origin.URL)
+
+	tmpDir := t.TempDir()
+	sessionDir := filepath.Join(tmpDir, "logs")
+	set := rules.NewSet(rules.Rule{
+		Name:  "api session",
+		Match: rules.Match{Host: originURL.Hostname(), Path: "/api"},
+		Session: &rules.Session{
+			Expired: rules.Expired{BodyRegex: "expired"},
+			Refresh: rules.Refresh{URL: origin.URL + "/login", Body: "user=a&password=b"},
+			Token:   rules.TokenSource{JSONPath: "$.access_token"},
+			Inject:  rules.TokenInject{Header: "Authorization"},
+		},
+	})
+	p, sl := NewProxyServer(
+		WithCert(filepath.Join(tmpDir, "ca.crt"), filepath.Join(tmpDir, "ca.key")),
+		WithSessionDir(sessionDir),
+		WithRules(set),
+	)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go p.Serve(l)
+	defer p.Close()
+
+	proxyURL, _ := url.Parse("http://" + l.Addr().String())
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+	send := func(body string) (int, string) {
+		t.Helper()
+		req, _ := http.NewRequest("POST", origin.URL+"/api", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer stale")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		got, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(got)
+	}
+
+	// The first request expires, is retried with a fresh token and keeps
+	// its body.
+	if status, body := send("first"); status != http.StatusOK || body != "first" {
+		t.Errorf("first request: %d %q", status, body)
+	}
+	// The next one carries the token from the start.
+	if status, body := send("second"); status != http.StatusOK || body != "second" {
+		t.Errorf("second request: %d %q", status, body)
+	}
+	if n := logins.Load(); n != 1 {
+		t.Errorf("logged in %d times, want 1", n)
+	}
+	sl.Close()
+
+	entries, err := logger.ReadSession(filepath.Join(sessionDir, sl.GetSessionName()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	flows, err := logger.BuildFlows(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(flows) != 2 || len(flows[0].Annotations) != 1 || len(flows[1].Annotations) != 0 ||
+		flows[0].Annotations[0].Comment != `session: rule "api session" refreshed the token after a 401 and retried the request` {
+		t.Errorf("flows = %+v", flows)
+	}
+	if flows[0].Request.Body != "first" || flows[0].Response == nil || flows[0].Response.StatusCode != http.StatusOK {
+		t.Errorf("first flow = %+v %+v", flows[0].Request, flows[0].Response)
+	}
+}
@@ -0,0 +1,149 @@
+package proxy
+
+import (
+	"bufio"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/martian/v3"
+	"github.com/standrze/rogue/internal/logger"
+)
+
+// isEventStream reports whether res is a Server-Sent Events stream.
+func isEventStream(res *http.Response) bool {
+	mt, _, err := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	return err == nil && mt == "text/event-stream"
+}
+
+// SSEModifier takes over delivery of text/event-stream responses. Martian
+// writes responses through a buffered writer that is only flushed once the
+// body ends, which holds back events indefinitely; instead the connection is
+// hijacked and flushed after every read. It must be the last response
+// modifier in the chain.
+type SSEModifier struct {
+	// Logger, when set, records each event as a separate sse_event entry.
+	Logger *logger.SessionLogger
+}
+
+func (s *SSEModifier) ModifyResponse(res *http.Response) error {
+	if !isEventStream(res) || res.Request == nil {
+		return nil
+	}
+
+	ctx := martian.NewContext(res.Request)
+	if ctx == nil {
+		return nil
+	}
+
+	conn, brw, err := ctx.Session().Hijack()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// Streams are long-lived; lift the per-request deadline.
+	conn.SetDeadline(time.Time{})
+
+	var body io.Reader = res.Body
+	if s.Logger != nil && inScope(res.Request) {
+		body = newSSETap(body, s.Logger, requestID(res.Request))
+	}
+
+	// The connection is closed when the stream ends, so the body is written
+	// unframed rather than chunked.
+	res.Close = true
+	res.ContentLength = -1
+	res.TransferEncoding = nil
+	res.Body = io.NopCloser(&flushReader{r: body, w: brw.Writer})
+
+	// Hide bufio.Writer's ReadFrom: it reads straight into the write buffer,
+	// which must not be flushed mid-read.
+	if err := res.Write(struct{ io.Writer }{brw.Writer}); err != nil {
+		return err
+	}
+	return brw.Flush()
+}
+
+// flushReader flushes everything written so far before blocking on the next
+// read, so each chunk reaches the client as soon as it arrives.
+type flushReader struct {
+	r io.Reader
+	w *bufio.Writer
+}
+
+func (f *flushReader) Read(p []byte) (int, error) {
+	if err := f.w.Flush(); err != nil {
+		return 0, err
+	}
+	return f.r.Read(p)
+}
+
+// sseTap parses the event stream flowing through it and logs every
+// dispatched event.
+type sseTap struct {
+	r         io.Reader
+	logger    *logger.SessionLogger
+	requestID string
+	pending   string
+	event     logger.SSEEventLog
+	data      []string
+}
+
+func newSSETap(r io.Reader, sl *logger.SessionLogger, requestID string) *sseTap {
+	return &sseTap{r: r, logger: sl, requestID: requestID}
+}
+
+func (t *sseTap) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	if n > 0 {
+		t.pending += string(p[:n])
+		for {
+			i := strings.IndexByte(t.pending, '\n')
+			if i < 0 {
+				break
+			}
+			t.line(strings.TrimSuffix(t.pending[:i], "\r"))
+			t.pending = t.pending[i+1:]
+		}
+	}
+	return n, err
+}
+
+func (t *sseTap) line(line string) {
+	if line == "" {
+		t.dispatch()
+		return
+	}
+	if strings.HasPrefix(line, ":") {
+		return
+	}
+
+	field, value, _ := strings.Cut(line, ":")
+	value = strings.TrimPrefix(value, " ")
+
+	switch field {
+	case "event":
+		t.event.Event = value
+	case "id":
+		t.event.ID = value
+	case "data":
+		t.data = append(t.data, value)
+	}
+}
+
+func (t *sseTap) dispatch() {
+	if len(t.data) == 0 && t.event.Event == "" {
+		return
+	}
+
+	t.event.Timestamp = time.Now()
+	t.event.RequestID = t.requestID
+	t.event.Data = strings.Join(t.data, "\n")
+	t.logger.WriteEntry("sse_event", t.event)
+
+	t.event = logger.SSEEventLog{}
+	t.data = nil
+}
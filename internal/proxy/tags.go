@@ -0,0 +1,52 @@
+package proxy
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/standrze/rogue/internal/logger"
+)
+
+// TagHeader and NoteHeader let a client tag and annotate its own requests,
+// as the repeater does. TagHeader holds comma-separated tags.
+const (
+	TagHeader  = "X-Rogue-Tag"
+	NoteHeader = "X-Rogue-Note"
+)
+
+// TagModifier records the tags and note a request carries in TagHeader and
+// NoteHeader as an annotation of its flow, and removes the headers so they
+// are neither logged nor forwarded. Out-of-scope requests are not recorded,
+// so their tags are dropped.
+type TagModifier struct {
+	Logger *logger.SessionLogger
+}
+
+func (m *TagModifier) ModifyRequest(req *http.Request) error {
+	tags := ParseTags(strings.Join(req.Header.Values(TagHeader), ","))
+	note := strings.TrimSpace(req.Header.Get(NoteHeader))
+	req.Header.Del(TagHeader)
+	req.Header.Del(NoteHeader)
+	if m.Logger == nil || (len(tags) == 0 && note == "") || !inScope(req) {
+		return nil
+	}
+	m.Logger.WriteEntry("annotation", logger.Annotation{
+		Timestamp: time.Now(),
+		RequestID: requestID(req),
+		Comment:   note,
+		Tags:      tags,
+	})
+	return nil
+}
+
+// ParseTags splits a TagHeader value, dropping empty tags.
+func ParseTags(v string) []string {
+	var tags []string
+	for _, t := range strings.Split(v, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			tags = append(tags, t)
+		}
+	}
+	return tags
+}
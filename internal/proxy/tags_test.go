@@ -0,0 +1,66 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	"github.com/standrze/rogue/internal/logger"
+	"github.com/standrze/rogue/internal/rules"
+)
+
+func TestTagHeaders(t *testing.T) {
+	tmpDir := t.TempDir()
+	sessionDir := filepath.Join(tmpDir, "logs")
+	set := rules.NewSet(rules.Rule{
+		Name:  "stub",
+		Match: rules.Match{Host: "unbuilt.invalid"},
+		Mock:  &rules.Mock{Status: 200, Body: "ok"},
+	})
+	p, sl := NewProxyServer(
+		WithCert(filepath.Join(tmpDir, "ca.crt"), filepath.Join(tmpDir, "ca.key")),
+		WithSessionDir(sessionDir),
+		WithRules(set),
+	)
+	defer sl.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go p.Serve(l)
+	defer p.Close()
+
+	proxyURL, _ := url.Parse("http://" + l.Addr().String())
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+	req, _ := http.NewRequest("GET", "http://unbuilt.invalid/", nil)
+	req.Header.Set(TagHeader, "repeater, ,idor")
+	req.Header.Set(NoteHeader, "second try")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	flows, err := logger.LoadFlows(filepath.Join(sessionDir, sl.GetSessionName()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(flows) != 1 {
+		t.Fatalf("Expected one flow, got %d", len(flows))
+	}
+	f := flows[0]
+	if tags := f.Tags(); len(tags) != 2 || tags[0] != "repeater" || tags[1] != "idor" {
+		t.Errorf("Expected the header's tags, got %v", tags)
+	}
+	if len(f.Annotations) != 1 || f.Annotations[0].Comment != "second try" {
+		t.Errorf("Expected the header's note, got %+v", f.Annotations)
+	}
+	for name := range f.Request.Headers {
+		if http.CanonicalHeaderKey(name) == TagHeader || http.CanonicalHeaderKey(name) == NoteHeader {
+			t.Errorf("Expected %s to be removed before logging", name)
+		}
+	}
+}
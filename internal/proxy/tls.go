@@ -0,0 +1,130 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"strings"
+)
+
+// TLSPolicy restricts protocol versions and cipher suites on both sides of
+// an intercepted connection. Zero values keep crypto/tls defaults.
+type TLSPolicy struct {
+	ClientMinVersion   uint16
+	ClientMaxVersion   uint16
+	ClientCipherSuites []uint16
+
+	UpstreamMinVersion   uint16
+	UpstreamMaxVersion   uint16
+	UpstreamCipherSuites []uint16
+	// SkipVerifyHosts lists host glob patterns whose upstream certificates
+	// are not verified.
+	SkipVerifyHosts []string
+	// PassthroughHosts lists host glob patterns whose CONNECT tunnels are
+	// relayed to the origin without interception.
+	PassthroughHosts []string
+	// Fingerprint makes upstream handshakes mimic a browser ClientHello (see
+	// Fingerprints). It overrides the upstream versions and cipher suites.
+	Fingerprint string
+	// ClientCerts are presented to origins that ask for a client
+	// certificate; the first entry matching the origin applies.
+	ClientCerts []ClientCert
+	// KeyLog receives the secrets of client-facing and upstream handshakes
+	// in NSS key log format, so captures of either side can be decrypted.
+	KeyLog io.Writer
+}
+
+// ClientCert is a certificate presented to origins requiring mutual TLS.
+type ClientCert struct {
+	// Hosts are glob patterns of the origins it is for; empty matches
+	// every origin.
+	Hosts       []string
+	Certificate tls.Certificate
+}
+
+// clientCert returns the certificate to present to host.
+func (p TLSPolicy) clientCert(host string) (*tls.Certificate, bool) {
+	for i, c := range p.ClientCerts {
+		if len(c.Hosts) == 0 || matchHost(c.Hosts, host) {
+			return &p.ClientCerts[i].Certificate, true
+		}
+	}
+	return nil, false
+}
+
+func WithTLSPolicy(policy TLSPolicy) ProxyOption {
+	return func(p *Proxy) {
+		p.TLSPolicy = policy
+	}
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ParseTLSVersion converts "1.0" through "1.3" to a crypto/tls version. An
+// empty string yields zero, meaning the library default.
+func ParseTLSVersion(v string) (uint16, error) {
+	if v == "" {
+		return 0, nil
+	}
+	version, ok := tlsVersions[strings.TrimPrefix(strings.ToLower(v), "tls")]
+	if !ok {
+		return 0, fmt.Errorf("unknown TLS version %q", v)
+	}
+	return version, nil
+}
+
+// ParseCipherSuites converts IANA cipher suite names, including the insecure
+// ones needed by legacy devices, to their IDs.
+func ParseCipherSuites(names []string) ([]uint16, error) {
+	known := make(map[string]uint16)
+	for _, s := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		known[s.Name] = s.ID
+	}
+
+	var ids []uint16
+	for _, name := range names {
+		id, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func matchHost(patterns []string, host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+	for _, p := range patterns {
+		if ok, _ := path.Match(strings.ToLower(p), host); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func verifyPeer(cs tls.ConnectionState, host string) error {
+	if len(cs.PeerCertificates) == 0 {
+		return fmt.Errorf("tls: no certificates from %s", host)
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, c := range cs.PeerCertificates[1:] {
+		intermediates.AddCert(c)
+	}
+	_, err := cs.PeerCertificates[0].Verify(x509.VerifyOptions{
+		DNSName:       host,
+		Intermediates: intermediates,
+	})
+	return err
+}
@@ -0,0 +1,73 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestUpstreamClientCert(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, strconv.Itoa(len(r.TLS.PeerCertificates)))
+	}))
+	srv.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+	srv.StartTLS()
+	defer srv.Close()
+	// The server's own certificate serves as the client's.
+	cert := srv.TLS.Certificates[0]
+
+	certs := func(policy TLSPolicy) string {
+		t.Helper()
+		policy.SkipVerifyHosts = []string{"127.0.0.1"}
+		u := &upstream{dialer: &net.Dialer{}, policy: policy}
+		client := &http.Client{Transport: &http.Transport{DialTLSContext: u.dialTLS}}
+		res, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, _ := io.ReadAll(res.Body)
+		return string(body)
+	}
+
+	if n := certs(TLSPolicy{}); n != "0" {
+		t.Errorf("Without client certificates the origin got %s", n)
+	}
+	if n := certs(TLSPolicy{ClientCerts: []ClientCert{{Hosts: []string{"mtls.example"}, Certificate: cert}}}); n != "0" {
+		t.Errorf("Certificate for another host was presented")
+	}
+	if n := certs(TLSPolicy{ClientCerts: []ClientCert{{Hosts: []string{"127.0.0.*"}, Certificate: cert}}}); n != "1" {
+		t.Errorf("Origin got %s certificates, want 1", n)
+	}
+	if n := certs(TLSPolicy{Fingerprint: "chrome", ClientCerts: []ClientCert{{Certificate: cert}}}); n != "1" {
+		t.Errorf("With a fingerprint the origin got %s certificates, want 1", n)
+	}
+}
+
+func TestUpstreamKeyLog(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	for _, fingerprint := range []string{"", "chrome"} {
+		var keys strings.Builder
+		u := &upstream{dialer: &net.Dialer{}, policy: TLSPolicy{
+			SkipVerifyHosts: []string{"127.0.0.1"},
+			Fingerprint:     fingerprint,
+			KeyLog:          &keys,
+		}}
+		client := &http.Client{Transport: &http.Transport{DialTLSContext: u.dialTLS}}
+		res, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if !strings.Contains(keys.String(), "CLIENT_TRAFFIC_SECRET_0 ") && !strings.Contains(keys.String(), "CLIENT_RANDOM ") {
+			t.Errorf("fingerprint %q: key log %q", fingerprint, keys.String())
+		}
+	}
+}
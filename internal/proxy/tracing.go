@@ -0,0 +1,133 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/google/martian/v3"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.40.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const spanKey = "rogue.span"
+
+// TracingModifier records an OpenTelemetry span for each exchange, from the
+// request arriving until its response body has been relayed. Spans continue
+// a trace started by the client's traceparent header. With Propagate, the
+// header forwarded upstream is replaced so the origin's spans nest under the
+// proxy's. It must be added after RequestIDModifier and its response half
+// after every modifier that can rewrite the response.
+type TracingModifier struct {
+	Tracer    trace.Tracer
+	Propagate bool
+}
+
+var traceContext = propagation.TraceContext{}
+
+func (m *TracingModifier) ModifyRequest(req *http.Request) error {
+	if req.Method == http.MethodConnect {
+		return nil
+	}
+	ctx := martian.NewContext(req)
+	if ctx == nil {
+		return nil
+	}
+
+	parent := traceContext.Extract(context.Background(), propagation.HeaderCarrier(req.Header))
+	attrs := []attribute.KeyValue{
+		semconv.HTTPRequestMethodKey.String(req.Method),
+		semconv.URLFull(req.URL.String()),
+		semconv.URLScheme(req.URL.Scheme),
+		semconv.ServerAddress(req.URL.Hostname()),
+		attribute.String("rogue.request_id", requestID(req)),
+	}
+	if port, err := strconv.Atoi(req.URL.Port()); err == nil {
+		attrs = append(attrs, semconv.ServerPort(port))
+	}
+	if ua := req.UserAgent(); ua != "" {
+		attrs = append(attrs, semconv.UserAgentOriginal(ua))
+	}
+	if req.ContentLength >= 0 {
+		attrs = append(attrs, semconv.HTTPRequestBodySize(int(req.ContentLength)))
+	}
+
+	spanCtx, span := m.Tracer.Start(parent, req.Method, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(attrs...))
+	ctx.Set(spanKey, span)
+	if m.Propagate {
+		traceContext.Inject(spanCtx, propagation.HeaderCarrier(req.Header))
+	}
+	return nil
+}
+
+func (m *TracingModifier) ModifyResponse(res *http.Response) error {
+	if res.Request == nil || res.Request.Method == http.MethodConnect {
+		return nil
+	}
+	ctx := martian.NewContext(res.Request)
+	if ctx == nil {
+		return nil
+	}
+	v, ok := ctx.Get(spanKey)
+	if !ok {
+		return nil
+	}
+	span := v.(trace.Span)
+
+	span.SetAttributes(semconv.HTTPResponseStatusCode(res.StatusCode))
+	if reason, ok := roundTripError(res); ok {
+		span.SetStatus(codes.Error, reason)
+		if h, ok := hintFor(res.Request); ok {
+			span.SetAttributes(semconv.ErrorTypeKey.String(h.Class))
+		}
+	} else if res.StatusCode >= 500 {
+		span.SetStatus(codes.Error, fmt.Sprintf("%d %s", res.StatusCode, http.StatusText(res.StatusCode)))
+	}
+	if rule, ok := matchedRule(res.Request); ok && rule.Mock != nil {
+		span.SetAttributes(attribute.Bool("rogue.mocked", true))
+	}
+
+	if res.Body == nil || res.Body == http.NoBody {
+		span.SetAttributes(semconv.HTTPResponseBodySize(0))
+		span.End()
+		return nil
+	}
+	res.Body = &spanBody{ReadCloser: res.Body, span: span}
+	return nil
+}
+
+// spanBody ends its span once the response body has been relayed.
+type spanBody struct {
+	io.ReadCloser
+	span trace.Span
+	n    int
+	once sync.Once
+}
+
+func (b *spanBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += n
+	if err == io.EOF {
+		b.end()
+	}
+	return n, err
+}
+
+func (b *spanBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.end()
+	return err
+}
+
+func (b *spanBody) end() {
+	b.once.Do(func() {
+		b.span.SetAttributes(semconv.HTTPResponseBodySize(b.n))
+		b.span.End()
+	})
+}
@@ -0,0 +1,233 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/standrze/rogue/internal/logger"
+	"github.com/standrze/rogue/internal/metrics"
+)
+
+// DefaultTunnelSampleSize is how many bytes of each direction of a tunnel
+// are kept for its log entry by default.
+const DefaultTunnelSampleSize = 256
+
+// maxClientHello bounds how much of a tunnel is kept to find the server
+// name of a TLS ClientHello in it: one full record.
+const maxClientHello = 5 + 16384
+
+// tunnelRecorder counts the bytes relayed one way through a tunnel and
+// keeps the first keep of them.
+type tunnelRecorder struct {
+	n    int64
+	head []byte
+	keep int
+}
+
+func (r *tunnelRecorder) Write(p []byte) (int, error) {
+	r.n += int64(len(p))
+	if room := r.keep - len(r.head); room > 0 {
+		r.head = append(r.head, p[:min(len(p), room)]...)
+	}
+	return len(p), nil
+}
+
+// sample returns a hex dump of the first n bytes recorded.
+func (r *tunnelRecorder) sample(n int) string {
+	if len(r.head) == 0 || n <= 0 {
+		return ""
+	}
+	return hex.Dump(r.head[:min(len(r.head), n)])
+}
+
+// tunnelStats counts the tunnels relayed without interception.
+type tunnelStats struct {
+	open, total, spliced atomic.Int64
+	// up and down are the bytes sent each way, counted as each direction
+	// of a tunnel finishes.
+	up, down atomic.Int64
+}
+
+// relay copies the tunnel between the client, read from r and written to
+// conn, and the origin until both sides are done. Logged tunnels are
+// counted and sampled on the way and written at the end; the others take
+// the fast path when they can.
+func (m *MITMModifier) relay(req *http.Request, conn net.Conn, r *bufio.Reader, up net.Conn, reason string) error {
+	// Tunnels are long-lived; lift the per-request deadline.
+	conn.SetDeadline(time.Time{})
+
+	start := time.Now()
+	m.tunnels.open.Add(1)
+	m.tunnels.total.Add(1)
+	defer m.tunnels.open.Add(-1)
+
+	sent := &tunnelRecorder{keep: max(m.TunnelSample, maxClientHello)}
+	received := &tunnelRecorder{keep: m.TunnelSample}
+	var (
+		src      io.Reader = r
+		dst      io.Writer = conn
+		down     io.Reader = up
+		upstream io.Writer = up
+		early    int64
+	)
+	if m.logsTunnels(reason) {
+		src, down = io.TeeReader(r, sent), io.TeeReader(up, received)
+	} else if client, origin := tcpConn(conn), tcpConn(up); client != nil && origin != nil {
+		// Nothing is inspected: the bytes already buffered are sent on and
+		// the kernel splices the rest between the two sockets.
+		buffered, _ := r.Peek(r.Buffered())
+		if _, err := up.Write(buffered); err != nil {
+			return err
+		}
+		early = int64(len(buffered))
+		r.Discard(len(buffered))
+		src, dst, down, upstream = client, client, origin, origin
+		m.tunnels.spliced.Add(1)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		n, _ := io.Copy(upstream, src)
+		m.tunnels.up.Add(early + n)
+		if c, ok := up.(interface{ CloseWrite() error }); ok {
+			c.CloseWrite()
+		}
+		close(done)
+	}()
+	n, _ := io.Copy(dst, down)
+	m.tunnels.down.Add(n)
+	conn.Close()
+	<-done
+
+	m.logTunnel(req, start, reason, func(t *logger.TunnelLog) {
+		t.SNI = clientHelloSNI(sent.head)
+		t.BytesUp, t.BytesDown = sent.n, received.n
+		t.DurationMS = time.Since(start).Milliseconds()
+		t.UpSample = sent.sample(m.TunnelSample)
+		t.DownSample = received.sample(m.TunnelSample)
+	})
+	return nil
+}
+
+// tcpConn returns the TCP connection under c if nothing between them
+// changes the bytes or their pace, or nil. A tunnelConn is only unwrapped
+// once the reader it shares has been drained.
+func tcpConn(c net.Conn) *net.TCPConn {
+	for {
+		switch t := c.(type) {
+		case *net.TCPConn:
+			return t
+		case *tunnelConn:
+			c = t.Conn
+		case *idleConn:
+			if t.armed.Load() {
+				return nil
+			}
+			c = t.Conn
+		case *limitConn:
+			if t.lim.bandwidth != nil {
+				return nil
+			}
+			c = t.Conn
+		default:
+			return nil
+		}
+	}
+}
+
+func registerTunnelMetrics(reg *metrics.Registry, m *MITMModifier) {
+	stat := func(v *atomic.Int64) func() float64 {
+		return func() float64 { return float64(v.Load()) }
+	}
+	reg.Register("rogue_tunnels_open", "CONNECT tunnels being relayed without interception.", metrics.Gauge, stat(&m.tunnels.open))
+	reg.Register("rogue_tunnels_total", "CONNECT tunnels relayed without interception.", metrics.Counter, stat(&m.tunnels.total))
+	reg.Register("rogue_tunnels_spliced_total", "Relayed tunnels copied by the kernel between the sockets.", metrics.Counter, stat(&m.tunnels.spliced))
+	reg.Register(`rogue_tunnel_bytes_total{direction="up"}`, "Bytes relayed through tunnels, counted as each direction finishes.", metrics.Counter, stat(&m.tunnels.up))
+	reg.Register(`rogue_tunnel_bytes_total{direction="down"}`, "Bytes relayed through tunnels, counted as each direction finishes.", metrics.Counter, stat(&m.tunnels.down))
+}
+
+// logsTunnels reports whether tunnels relayed for reason are logged; an
+// empty reason is never logged.
+func (m *MITMModifier) logsTunnels(reason string) bool {
+	return m.Logger != nil && reason != ""
+}
+
+// logTunnel writes a tunnel entry for req, filled in by fill, if tunnels
+// relayed for reason are logged.
+func (m *MITMModifier) logTunnel(req *http.Request, start time.Time, reason string, fill func(*logger.TunnelLog)) {
+	if !m.logsTunnels(reason) {
+		return
+	}
+	t := &logger.TunnelLog{
+		Timestamp: start,
+		RequestID: requestID(req),
+		Host:      req.Host,
+		ClientIP:  clientAddr(req),
+		Reason:    reason,
+		Labels:    m.Logger.Labels(),
+	}
+	fill(t)
+	m.Logger.WriteEntry("tunnel", t)
+}
+
+// clientAddr returns the IP address of the client that sent req.
+func clientAddr(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// looksLikeHTTP reports whether the bytes buffered in r so far could start
+// an HTTP/1.x request or the HTTP/2 preface.
+func looksLikeHTTP(r *bufio.Reader) bool {
+	head, _ := r.Peek(r.Buffered())
+	for _, m := range []string{"GET ", "POST ", "PUT ", "DELETE ", "HEAD ", "OPTIONS ", "PATCH ", "CONNECT ", "TRACE ", "PRI "} {
+		n := min(len(head), len(m))
+		if n > 0 && string(head[:n]) == m[:n] {
+			return true
+		}
+	}
+	return false
+}
+
+var errHelloRead = errors.New("client hello read")
+
+// clientHelloSNI returns the server name of the TLS ClientHello that b
+// starts with, or "" if it does not start with one.
+func clientHelloSNI(b []byte) string {
+	if len(b) < 6 || b[0] != 22 {
+		return ""
+	}
+	var sni string
+	conn := tls.Server(&helloConn{r: bytes.NewReader(b)}, &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			sni = hello.ServerName
+			return nil, errHelloRead
+		},
+	})
+	conn.Handshake()
+	return sni
+}
+
+// helloConn lets crypto/tls parse recorded bytes; writes are discarded.
+type helloConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *helloConn) Read(p []byte) (int, error)       { return c.r.Read(p) }
+func (c *helloConn) Write(p []byte) (int, error)      { return len(p), nil }
+func (c *helloConn) Close() error                     { return nil }
+func (c *helloConn) SetDeadline(time.Time) error      { return nil }
+func (c *helloConn) SetReadDeadline(time.Time) error  { return nil }
+func (c *helloConn) SetWriteDeadline(time.Time) error { return nil }
@@ -0,0 +1,137 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/google/martian/v3"
+	"github.com/standrze/rogue/internal/logger"
+)
+
+// upstream dials origin servers. TLS is established here rather than by
+// http.Transport so the handshake can be tailored per host.
+type upstream struct {
+	dialer           *net.Dialer
+	bindings         []binding
+	strategy         DialStrategy
+	resolvers        []resolver
+	overrides        []override
+	dnsServer        *net.Resolver
+	policy           TLSPolicy
+	handshakeTimeout time.Duration
+	// chain, if set, is the proxy origins are reached through, and
+	// negotiate returns the Negotiate tokens for its service principal.
+	chain     *UpstreamProxy
+	negotiate func(spn string) ([]byte, error)
+	// Logger, if set, receives a tls_connection entry for every upstream
+	// handshake.
+	Logger *logger.SessionLogger
+}
+
+func configureUpstream(p *martian.Proxy, t Timeouts, policy TLSPolicy, bindings []binding) *upstream {
+	u := &upstream{
+		dialer: &net.Dialer{
+			Timeout:   t.Dial,
+			KeepAlive: 30 * time.Second,
+		},
+		bindings:         bindings,
+		policy:           policy,
+		handshakeTimeout: t.TLSHandshake,
+	}
+
+	p.SetRoundTripper(&http.Transport{
+		DialContext:           u.dial,
+		DialTLSContext:        u.dialTLS,
+		TLSHandshakeTimeout:   t.TLSHandshake,
+		ResponseHeaderTimeout: t.ResponseHeader,
+		IdleConnTimeout:       t.Idle,
+		ExpectContinueTimeout: time.Second,
+	})
+	tr := p.GetRoundTripper().(*http.Transport)
+	// SetRoundTripper replaces the environment proxy with the (unset)
+	// downstream proxy; keep honoring HTTP_PROXY and friends.
+	tr.Proxy = http.ProxyFromEnvironment
+	// Used when TLS is tunneled through an environment proxy.
+	tr.TLSClientConfig = u.policyConfig()
+
+	p.SetDial(func(network, addr string) (net.Conn, error) {
+		return u.dial(context.Background(), network, addr)
+	})
+
+	return u
+}
+
+func (u *upstream) policyConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:   u.policy.UpstreamMinVersion,
+		MaxVersion:   u.policy.UpstreamMaxVersion,
+		CipherSuites: u.policy.UpstreamCipherSuites,
+		KeyLogWriter: u.policy.KeyLog,
+	}
+}
+
+// tlsConfig returns the client config for host. Verification is done in
+// VerifyConnection rather than by crypto/tls so it can be skipped for
+// selected hosts and still check IP address targets, which send no SNI.
+func (u *upstream) tlsConfig(host string) *tls.Config {
+	cfg := u.policyConfig()
+	cfg.ServerName = host
+	cfg.InsecureSkipVerify = true
+	cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+		return u.verify(host, cs)
+	}
+	if c, ok := u.policy.clientCert(host); ok {
+		cfg.Certificates = []tls.Certificate{*c}
+	}
+	return cfg
+}
+
+// verify checks the server chain of a handshake with host and records it.
+func (u *upstream) verify(host string, cs tls.ConnectionState) error {
+	enforced := !matchHost(u.policy.SkipVerifyHosts, host)
+	err := verifyPeer(cs, host)
+	if u.Logger != nil {
+		u.Logger.WriteEntry("tls_connection", logger.CaptureTLSConnection(host, cs, err, enforced))
+	}
+	if !enforced {
+		return nil
+	}
+	return err
+}
+
+func (u *upstream) dialTLS(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+
+	raw, err := u.dial(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.handshakeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, u.handshakeTimeout)
+		defer cancel()
+	}
+
+	if u.policy.Fingerprint != "" {
+		conn, err := u.mimicHandshake(ctx, raw, host)
+		if err != nil {
+			raw.Close()
+			return nil, err
+		}
+		return conn, nil
+	}
+
+	conn := tls.Client(raw, u.tlsConfig(host))
+	if err := conn.HandshakeContext(ctx); err != nil {
+		raw.Close()
+		return nil, err
+	}
+	return conn, nil
+}
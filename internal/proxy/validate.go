@@ -0,0 +1,89 @@
+package proxy
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/google/martian/v3"
+	"github.com/standrze/rogue/internal/logger"
+	"github.com/standrze/rogue/internal/openapi"
+	"github.com/standrze/rogue/internal/rules"
+)
+
+const invalidRequestKey = "rogue.invalid_request"
+
+// ValidationModifier checks exchanges against an OpenAPI document. Violations
+// are recorded as annotations on the flow; with Reject set, invalid requests
+// are answered with 400 instead of being forwarded and invalid responses are
+// replaced with 502. Like RulesModifier it must run before the logging
+// response modifier.
+type ValidationModifier struct {
+	Validator *openapi.Validator
+	Logger    *logger.SessionLogger
+	Responses bool
+	Reject    bool
+}
+
+func (m *ValidationModifier) ModifyRequest(req *http.Request) error {
+	if req.Method == http.MethodConnect {
+		return nil
+	}
+
+	err := m.Validator.ValidateRequest(req)
+	if err == nil {
+		return nil
+	}
+	m.annotate(req, "request", err)
+
+	if ctx := martian.NewContext(req); ctx != nil && m.Reject {
+		ctx.Set(invalidRequestKey, err)
+		ctx.SkipRoundTrip()
+	}
+	return nil
+}
+
+func (m *ValidationModifier) ModifyResponse(res *http.Response) error {
+	if res.Request == nil || res.Request.Method == http.MethodConnect {
+		return nil
+	}
+
+	if ctx := martian.NewContext(res.Request); ctx != nil {
+		if v, ok := ctx.Get(invalidRequestKey); ok {
+			applyMock(res, validationError(http.StatusBadRequest, "request", v.(error)))
+			return nil
+		}
+	}
+
+	if !m.Responses || isEventStream(res) {
+		return nil
+	}
+	err := m.Validator.ValidateResponse(res)
+	if err == nil {
+		return nil
+	}
+	m.annotate(res.Request, "response", err)
+
+	if m.Reject {
+		applyMock(res, validationError(http.StatusBadGateway, "response", err))
+	}
+	return nil
+}
+
+func (m *ValidationModifier) annotate(req *http.Request, what string, err error) {
+	if m.Logger == nil {
+		return
+	}
+	m.Logger.WriteEntry("annotation", logger.Annotation{
+		Timestamp: time.Now(),
+		RequestID: requestID(req),
+		Comment:   "openapi: " + what + " does not match the spec: " + err.Error(),
+	})
+}
+
+func validationError(status int, what string, err error) *rules.Mock {
+	return &rules.Mock{
+		Status:  status,
+		Headers: map[string]string{"Content-Type": "text/plain; charset=utf-8"},
+		Body:    what + " does not match the OpenAPI spec:\n" + err.Error() + "\n",
+	}
+}
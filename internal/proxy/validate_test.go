@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/standrze/rogue/internal/logger"
+	"github.com/standrze/rogue/internal/openapi"
+)
+
+const orders = `
+openapi: 3.0.3
+info: {title: Orders, version: "1"}
+servers:
+  - url: http://orders.invalid
+paths:
+  /orders:
+    post:
+      requestBody:
+        required: true
+        content:
+          application/json:
+            schema:
+              type: object
+              required: [sku]
+              properties:
+                sku: {type: string}
+      responses:
+        "201": {description: created}
+`
+
+func TestValidationRejectsInvalidRequest(t *testing.T) {
+	doc, err := openapi3.NewLoader().LoadFromData([]byte(orders))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := openapi.NewValidator(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpDir := t.TempDir()
+	sessionDir := filepath.Join(tmpDir, "logs")
+	p, sl := NewProxyServer(
+		WithCert(filepath.Join(tmpDir, "ca.crt"), filepath.Join(tmpDir, "ca.key")),
+		WithSessionDir(sessionDir),
+		WithValidation(v, true, true),
+	)
+	defer sl.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go p.Serve(l)
+	defer p.Close()
+
+	proxyURL, _ := url.Parse("http://" + l.Addr().String())
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+
+	// The origin does not resolve, so only a rejected request gets a 400.
+	resp, err := client.Post("http://orders.invalid/orders", "application/json", strings.NewReader(`{"qty": 1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest || !strings.Contains(string(body), "sku") {
+		t.Errorf("Expected 400 naming the missing property, got %d %q", resp.StatusCode, body)
+	}
+
+	entries, err := logger.ReadSession(filepath.Join(sessionDir, sl.GetSessionName()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var note logger.Annotation
+	for _, e := range entries {
+		if e.Type == "annotation" {
+			json.Unmarshal(e.Data, &note)
+		}
+	}
+	if !strings.HasPrefix(note.Comment, "openapi: request") || note.RequestID == "" {
+		t.Errorf("Expected the flow to be annotated, got %+v", note)
+	}
+}
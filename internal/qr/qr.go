@@ -0,0 +1,395 @@
+// Package qr encodes short texts, such as URLs, as QR codes (ISO/IEC 18004)
+// in byte mode at error correction level M, and renders them for terminals
+// and web pages.
+package qr
+
+import (
+	"fmt"
+	"strings"
+)
+
+// versions holds the block structure of level M for versions 1 to 10:
+// error correction codewords per block, then the number of blocks and
+// their data codewords for each of the two groups.
+var versions = [...]struct {
+	ec             int
+	blocks1, data1 int
+	blocks2, data2 int
+	align          []int
+}{
+	{10, 1, 16, 0, 0, nil},
+	{16, 1, 28, 0, 0, []int{6, 18}},
+	{26, 1, 44, 0, 0, []int{6, 22}},
+	{18, 2, 32, 0, 0, []int{6, 26}},
+	{24, 2, 43, 0, 0, []int{6, 30}},
+	{16, 4, 27, 0, 0, []int{6, 34}},
+	{18, 4, 31, 0, 0, []int{6, 22, 38}},
+	{22, 2, 38, 2, 39, []int{6, 24, 42}},
+	{22, 3, 36, 2, 37, []int{6, 26, 46}},
+	{26, 4, 43, 1, 44, []int{6, 28, 50}},
+}
+
+// Code is an encoded QR code.
+type Code struct {
+	// Size is the width and height in modules.
+	Size     int
+	dark     [][]bool
+	function [][]bool
+}
+
+// Dark reports whether the module in column x of row y is dark.
+func (c *Code) Dark(x, y int) bool {
+	return c.dark[y][x]
+}
+
+// Encode returns the smallest code holding data.
+func Encode(data string) (*Code, error) {
+	for v := 1; v <= len(versions); v++ {
+		vi := versions[v-1]
+		capacity := vi.blocks1*vi.data1 + vi.blocks2*vi.data2
+		countBits := 8
+		if v >= 10 {
+			countBits = 16
+		}
+		if 4+countBits+8*len(data) <= 8*capacity {
+			return encode(v, countBits, capacity, []byte(data)), nil
+		}
+	}
+	return nil, fmt.Errorf("qr: %d bytes do not fit in a version %d code", len(data), len(versions))
+}
+
+func encode(version, countBits, capacity int, data []byte) *Code {
+	var bits bitBuffer
+	bits.append(0b0100, 4) // byte mode
+	bits.append(len(data), countBits)
+	for _, b := range data {
+		bits.append(int(b), 8)
+	}
+	bits.append(0, min(4, 8*capacity-len(bits)))
+	bits.append(0, (8-len(bits)%8)%8)
+	codewords := bits.bytes()
+	for pad := 0xEC; len(codewords) < capacity; pad ^= 0xEC ^ 0x11 {
+		codewords = append(codewords, byte(pad))
+	}
+
+	size := 17 + 4*version
+	c := &Code{Size: size, dark: grid(size), function: grid(size)}
+	c.drawFunctions(version)
+	c.drawCodewords(interleave(version, codewords))
+
+	best, penalty := 0, -1
+	for mask := range 8 {
+		c.applyMask(mask)
+		c.drawFormat(mask)
+		if p := c.penalty(); penalty < 0 || p < penalty {
+			best, penalty = mask, p
+		}
+		c.applyMask(mask)
+	}
+	c.applyMask(best)
+	c.drawFormat(best)
+	return c
+}
+
+func grid(size int) [][]bool {
+	g := make([][]bool, size)
+	for i := range g {
+		g[i] = make([]bool, size)
+	}
+	return g
+}
+
+// interleave splits the data codewords into blocks, appends each block's
+// error correction and interleaves the blocks.
+func interleave(version int, data []byte) []byte {
+	vi := versions[version-1]
+	var blocks, ecs [][]byte
+	divisor := rsDivisor(vi.ec)
+	for i := range vi.blocks1 + vi.blocks2 {
+		n := vi.data1
+		if i >= vi.blocks1 {
+			n = vi.data2
+		}
+		blocks = append(blocks, data[:n])
+		ecs = append(ecs, rsRemainder(data[:n], divisor))
+		data = data[n:]
+	}
+	var out []byte
+	for i := range max(vi.data1, vi.data2) {
+		for _, b := range blocks {
+			if i < len(b) {
+				out = append(out, b[i])
+			}
+		}
+	}
+	for i := range vi.ec {
+		for _, e := range ecs {
+			out = append(out, e[i])
+		}
+	}
+	return out
+}
+
+func (c *Code) set(x, y int, dark bool) {
+	c.dark[y][x] = dark
+	c.function[y][x] = true
+}
+
+// drawFunctions draws the finder, timing and alignment patterns and
+// reserves the format and version areas.
+func (c *Code) drawFunctions(version int) {
+	for i := range c.Size {
+		c.set(6, i, i%2 == 0)
+		c.set(i, 6, i%2 == 0)
+	}
+	for _, p := range [][2]int{{3, 3}, {c.Size - 4, 3}, {3, c.Size - 4}} {
+		for dy := -4; dy <= 4; dy++ {
+			for dx := -4; dx <= 4; dx++ {
+				x, y := p[0]+dx, p[1]+dy
+				if x >= 0 && x < c.Size && y >= 0 && y < c.Size {
+					d := max(abs(dx), abs(dy))
+					c.set(x, y, d != 2 && d != 4)
+				}
+			}
+		}
+	}
+	align := versions[version-1].align
+	last := len(align) - 1
+	for i, y := range align {
+		for j, x := range align {
+			if i == 0 && j == 0 || i == 0 && j == last || i == last && j == 0 {
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					c.set(x+dx, y+dy, max(abs(dx), abs(dy)) != 1)
+				}
+			}
+		}
+	}
+	c.drawFormat(0)
+	if version >= 7 {
+		rem := version
+		for range 12 {
+			rem = rem<<1 ^ (rem>>11)*0x1F25
+		}
+		bits := version<<12 | rem
+		for i := range 18 {
+			dark := bits>>i&1 != 0
+			a, b := c.Size-11+i%3, i/3
+			c.set(a, b, dark)
+			c.set(b, a, dark)
+		}
+	}
+}
+
+// formatBits returns the format information for level M and mask.
+func formatBits(mask int) int {
+	data := 0b00<<3 | mask
+	rem := data
+	for range 10 {
+		rem = rem<<1 ^ (rem>>9)*0x537
+	}
+	return (data<<10 | rem) ^ 0x5412
+}
+
+// drawFormat draws both copies of the format information.
+func (c *Code) drawFormat(mask int) {
+	bits := formatBits(mask)
+	bit := func(i int) bool { return bits>>i&1 != 0 }
+	for i := range 6 {
+		c.set(8, i, bit(i))
+	}
+	c.set(8, 7, bit(6))
+	c.set(8, 8, bit(7))
+	c.set(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		c.set(14-i, 8, bit(i))
+	}
+	for i := range 8 {
+		c.set(c.Size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		c.set(8, c.Size-15+i, bit(i))
+	}
+	c.set(8, c.Size-8, true)
+}
+
+// drawCodewords places data in the zigzag order of two-module columns,
+// right to left, skipping function modules.
+func (c *Code) drawCodewords(data []byte) {
+	i := 0
+	for right := c.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := range c.Size {
+			for j := range 2 {
+				x := right - j
+				y := vert
+				if (right+1)&2 == 0 {
+					y = c.Size - 1 - vert
+				}
+				if !c.function[y][x] && i < len(data)*8 {
+					c.dark[y][x] = data[i>>3]>>(7-i&7)&1 != 0
+					i++
+				}
+			}
+		}
+	}
+}
+
+// applyMask flips the data modules selected by mask; applying it twice
+// undoes it.
+func (c *Code) applyMask(mask int) {
+	for y := range c.Size {
+		for x := range c.Size {
+			var flip bool
+			switch mask {
+			case 0:
+				flip = (x+y)%2 == 0
+			case 1:
+				flip = y%2 == 0
+			case 2:
+				flip = x%3 == 0
+			case 3:
+				flip = (x+y)%3 == 0
+			case 4:
+				flip = (x/3+y/2)%2 == 0
+			case 5:
+				flip = x*y%2+x*y%3 == 0
+			case 6:
+				flip = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				flip = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if flip && !c.function[y][x] {
+				c.dark[y][x] = !c.dark[y][x]
+			}
+		}
+	}
+}
+
+// penalty scores how hard the code is to scan, by the four rules of the
+// standard: long runs, 2x2 blocks, finder-like patterns and imbalance.
+func (c *Code) penalty() int {
+	p := 0
+	dark := 0
+	for y := range c.Size {
+		for x := range c.Size {
+			if c.dark[y][x] {
+				dark++
+			}
+			if x+1 < c.Size && y+1 < c.Size {
+				v := c.dark[y][x]
+				if c.dark[y][x+1] == v && c.dark[y+1][x] == v && c.dark[y+1][x+1] == v {
+					p += 3
+				}
+			}
+		}
+	}
+	for _, line := range c.lines() {
+		run := 1
+		for i := 1; i <= len(line); i++ {
+			if i < len(line) && line[i] == line[i-1] {
+				run++
+				continue
+			}
+			if run >= 5 {
+				p += 3 + run - 5
+			}
+			run = 1
+		}
+		s := string(line)
+		p += 40 * (strings.Count(s, "10111010000") + strings.Count(s, "00001011101"))
+	}
+	total := c.Size * c.Size
+	return p + 10*(abs(dark*100/total-50)/5)
+}
+
+// lines returns the rows and columns as strings of '1' and '0'.
+func (c *Code) lines() [][]byte {
+	var out [][]byte
+	for i := range c.Size {
+		row, col := make([]byte, c.Size), make([]byte, c.Size)
+		for j := range c.Size {
+			row[j], col[j] = '0', '0'
+			if c.dark[i][j] {
+				row[j] = '1'
+			}
+			if c.dark[j][i] {
+				col[j] = '1'
+			}
+		}
+		out = append(out, row, col)
+	}
+	return out
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// bitBuffer collects bits most significant first.
+type bitBuffer []bool
+
+func (b *bitBuffer) append(v, n int) {
+	for i := n - 1; i >= 0; i-- {
+		*b = append(*b, v>>i&1 != 0)
+	}
+}
+
+func (b bitBuffer) bytes() []byte {
+	out := make([]byte, len(b)/8)
+	for i, bit := range b {
+		if bit {
+			out[i/8] |= 1 << (7 - i%8)
+		}
+	}
+	return out
+}
+
+// rsDivisor returns the Reed-Solomon generator polynomial of degree n over
+// GF(2^8), without its leading coefficient.
+func rsDivisor(n int) []byte {
+	result := make([]byte, n)
+	result[n-1] = 1
+	root := byte(1)
+	for range n {
+		for j := range result {
+			result[j] = gfMul(result[j], root)
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = gfMul(root, 2)
+	}
+	return result
+}
+
+// rsRemainder returns the error correction codewords of data.
+func rsRemainder(data, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i := range result {
+			result[i] ^= gfMul(divisor[i], factor)
+		}
+	}
+	return result
+}
+
+// gfMul multiplies in GF(2^8) modulo x^8 + x^4 + x^3 + x^2 + 1.
+func gfMul(x, y byte) byte {
+	z := 0
+	for i := 7; i >= 0; i-- {
+		z = z<<1 ^ (z>>7)*0x11D
+		z ^= int(y>>i&1) * int(x)
+	}
+	return byte(z)
+}
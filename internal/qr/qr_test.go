@@ -0,0 +1,89 @@
+package qr
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestReedSolomon(t *testing.T) {
+	// "HELLO WORLD" at 1-M, from the worked example of the standard.
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	want := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+	if got := rsRemainder(data, rsDivisor(10)); !bytes.Equal(got, want) {
+		t.Errorf("ec = %v, want %v", got, want)
+	}
+}
+
+func TestFormatBits(t *testing.T) {
+	if got := formatBits(0); got != 0b101010000010010 {
+		t.Errorf("format = %015b", got)
+	}
+}
+
+func TestEncode(t *testing.T) {
+	for _, tc := range []struct {
+		data string
+		size int
+	}{
+		{"", 21},
+		{"http://192.168.1.20:8089/", 25},
+		{strings.Repeat("x", 150), 49},
+		{strings.Repeat("x", 213), 57},
+	} {
+		c, err := Encode(tc.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.Size != tc.size {
+			t.Errorf("%d bytes: size = %d, want %d", len(tc.data), c.Size, tc.size)
+		}
+		// Finder patterns and the dark module.
+		for _, p := range [][2]int{{0, 0}, {c.Size - 7, 0}, {0, c.Size - 7}} {
+			if !c.Dark(p[0], p[1]) || !c.Dark(p[0]+3, p[1]+3) || c.Dark(p[0]+1, p[1]+1) {
+				t.Errorf("%d bytes: no finder at %v", len(tc.data), p)
+			}
+		}
+		if !c.Dark(8, c.Size-8) {
+			t.Errorf("%d bytes: dark module is light", len(tc.data))
+		}
+	}
+	if _, err := Encode(strings.Repeat("x", 214)); err == nil {
+		t.Error("oversized data encoded")
+	}
+}
+
+func TestVersionInfo(t *testing.T) {
+	c, err := Encode(strings.Repeat("x", 120))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Size != 45 {
+		t.Fatalf("size = %d, want 45", c.Size)
+	}
+	// Version 7 is 000111110010010100, least significant bit first.
+	const want = 0b000111110010010100
+	for i := range 18 {
+		if c.Dark(c.Size-11+i%3, i/3) != (want>>i&1 != 0) {
+			t.Errorf("version bit %d wrong", i)
+		}
+	}
+}
+
+func TestRender(t *testing.T) {
+	c, err := Encode("rogue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	if err := c.WriteTerminal(&b); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+	if len(lines) != (c.Size+9)/2 || len([]rune(lines[0])) != c.Size+8 {
+		t.Errorf("terminal output is %d lines of %d", len(lines), len([]rune(lines[0])))
+	}
+	if svg := c.SVG(); !strings.HasPrefix(svg, "<svg") || !strings.Contains(svg, `viewBox="0 0 29 29"`) {
+		t.Errorf("svg = %.80s", svg)
+	}
+}
@@ -0,0 +1,58 @@
+package qr
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// quiet is the width of the light border scanners need around a code.
+const quiet = 4
+
+func (c *Code) darkAt(x, y int) bool {
+	x, y = x-quiet, y-quiet
+	return x >= 0 && y >= 0 && x < c.Size && y < c.Size && c.dark[y][x]
+}
+
+// WriteTerminal draws the code with half block characters, two rows to a
+// line. Light modules are printed as blocks so the code scans on the dark
+// background of most terminals.
+func (c *Code) WriteTerminal(w io.Writer) error {
+	var b strings.Builder
+	n := c.Size + 2*quiet
+	for y := 0; y < n; y += 2 {
+		for x := range n {
+			top, bottom := !c.darkAt(x, y), y+1 < n && !c.darkAt(x, y+1)
+			switch {
+			case top && bottom:
+				b.WriteString("█")
+			case top:
+				b.WriteString("▀")
+			case bottom:
+				b.WriteString("▄")
+			default:
+				b.WriteByte(' ')
+			}
+		}
+		b.WriteByte('\n')
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// SVG returns the code as an SVG image scaled to fit its container.
+func (c *Code) SVG() string {
+	var b strings.Builder
+	n := c.Size + 2*quiet
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" shape-rendering="crispEdges">`, n, n)
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" fill="#fff"/><path fill="#000" d="`, n, n)
+	for y := range c.Size {
+		for x := range c.Size {
+			if c.dark[y][x] {
+				fmt.Fprintf(&b, "M%d %dh1v1h-1z", x+quiet, y+quiet)
+			}
+		}
+	}
+	b.WriteString(`"/></svg>`)
+	return b.String()
+}
@@ -0,0 +1,165 @@
+// Package rawhttp parses raw HTTP/1.x request files, such as those saved by
+// Burp Suite or copied from a terminal, and sends them byte for byte. Header
+// names keep their case, order, duplicates and spacing, so requests that
+// would be normalized away by net/http arrive as written.
+package rawhttp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Header is one header line, split at its first colon. Value keeps the
+// spacing after the colon, and folded continuation lines with their line
+// breaks.
+type Header struct {
+	Name  string
+	Value string
+}
+
+// Request is a parsed request file.
+type Request struct {
+	Method string
+	// Target is the request target as written: a path, an absolute URL, an
+	// authority for CONNECT or "*".
+	Target  string
+	Proto   string
+	Headers []Header
+	Body    []byte
+}
+
+// Parse reads a request file. Lines may end in CRLF or a bare LF; the
+// request line and headers are always sent with CRLF, and the body is kept
+// as it is. A body made only of line breaks, which editors add at the end
+// of files, is dropped when no header announces a body.
+func Parse(data []byte) (*Request, error) {
+	// Tolerate a byte order mark and blank lines before the request line.
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	data = bytes.TrimLeft(data, "\r\n")
+
+	r := &Request{}
+	line, rest, err := nextLine(data)
+	if err != nil {
+		return nil, errors.New("empty request")
+	}
+	parts := strings.Fields(line)
+	if len(parts) != 3 && len(parts) != 2 {
+		return nil, fmt.Errorf("malformed request line %q", line)
+	}
+	r.Method, r.Target, r.Proto = parts[0], parts[1], "HTTP/1.1"
+	if len(parts) == 3 {
+		r.Proto = parts[2]
+	}
+	if !strings.HasPrefix(r.Proto, "HTTP/") {
+		return nil, fmt.Errorf("malformed request line %q", line)
+	}
+
+	for n := 2; ; n++ {
+		line, rest, err = nextLine(rest)
+		if err != nil || line == "" {
+			break
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			if len(r.Headers) == 0 {
+				return nil, fmt.Errorf("line %d: continuation line before any header", n)
+			}
+			r.Headers[len(r.Headers)-1].Value += "\r\n" + line
+			continue
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("line %d: malformed header %q", n, line)
+		}
+		r.Headers = append(r.Headers, Header{Name: name, Value: value})
+	}
+
+	r.Body = rest
+	if len(bytes.Trim(r.Body, "\r\n")) == 0 && r.Get("Content-Length") == "" && r.Get("Transfer-Encoding") == "" {
+		r.Body = nil
+	}
+	return r, nil
+}
+
+// nextLine splits off the first line of data without its line break. It
+// fails when data is empty.
+func nextLine(data []byte) (string, []byte, error) {
+	if len(data) == 0 {
+		return "", nil, errors.New("no more lines")
+	}
+	line, rest, found := bytes.Cut(data, []byte("\n"))
+	if !found {
+		rest = nil
+	}
+	return string(bytes.TrimSuffix(line, []byte("\r"))), rest, nil
+}
+
+// Get returns the trimmed value of the first header called name, compared
+// without regard to case or surrounding space.
+func (r *Request) Get(name string) string {
+	for _, h := range r.Headers {
+		if strings.EqualFold(strings.TrimSpace(h.Name), name) {
+			return strings.TrimSpace(h.Value)
+		}
+	}
+	return ""
+}
+
+// Set replaces the value of every header called name, or adds one.
+func (r *Request) Set(name, value string) {
+	found := false
+	for i, h := range r.Headers {
+		if strings.EqualFold(strings.TrimSpace(h.Name), name) {
+			r.Headers[i].Value = " " + value
+			found = true
+		}
+	}
+	if !found {
+		r.Headers = append(r.Headers, Header{Name: name, Value: " " + value})
+	}
+}
+
+// FixContentLength sets Content-Length to the size of the body, as editing
+// a request file by hand rarely keeps it right. Chunked requests and
+// requests without a body and without the header are left alone.
+func (r *Request) FixContentLength() {
+	if r.Get("Transfer-Encoding") != "" {
+		return
+	}
+	if len(r.Body) == 0 && r.Get("Content-Length") == "" {
+		return
+	}
+	r.Set("Content-Length", strconv.Itoa(len(r.Body)))
+}
+
+// Origin returns the scheme and authority the request is for: those of an
+// absolute-form target, or the Host header with defaultScheme.
+func (r *Request) Origin(defaultScheme string) (string, string, error) {
+	if scheme, rest, ok := strings.Cut(r.Target, "://"); ok {
+		host, _, _ := strings.Cut(rest, "/")
+		host, _, _ = strings.Cut(host, "?")
+		if host == "" {
+			return "", "", fmt.Errorf("no host in target %q", r.Target)
+		}
+		return strings.ToLower(scheme), host, nil
+	}
+	host := r.Get("Host")
+	if host == "" {
+		return "", "", errors.New("the request has no Host header")
+	}
+	return defaultScheme, host, nil
+}
+
+// Bytes returns the request as sent, with target in place of its own.
+func (r *Request) Bytes(target string) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s %s %s\r\n", r.Method, target, r.Proto)
+	for _, h := range r.Headers {
+		fmt.Fprintf(&buf, "%s:%s\r\n", h.Name, h.Value)
+	}
+	buf.WriteString("\r\n")
+	buf.Write(r.Body)
+	return buf.Bytes()
+}
@@ -0,0 +1,165 @@
+package rawhttp
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	file := "POST /api/login?next=%2F HTTP/1.1\n" +
+		"Host: app.example\n" +
+		"x-CUSTOM-case:  spaced\n" +
+		"X-Dup: 1\n" +
+		"X-Dup: 2\n" +
+		"X-Folded: a\n" +
+		"\tb\n" +
+		"Transfer-Encoding : chunked\n" +
+		"Content-Length: 99\n" +
+		"\n" +
+		"user=a&pass=b"
+	r, err := Parse([]byte(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Method != "POST" || r.Target != "/api/login?next=%2F" || r.Proto != "HTTP/1.1" {
+		t.Errorf("Request line: %s %s %s", r.Method, r.Target, r.Proto)
+	}
+	if len(r.Headers) != 7 || r.Headers[1] != (Header{"x-CUSTOM-case", "  spaced"}) {
+		t.Errorf("Headers: %q", r.Headers)
+	}
+	if r.Get("transfer-encoding") != "chunked" {
+		t.Errorf("Header with a space before the colon not found")
+	}
+
+	// Chunked requests keep their Content-Length, however odd.
+	r.FixContentLength()
+	want := "POST /api/login?next=%2F HTTP/1.1\r\n" +
+		"Host: app.example\r\n" +
+		"x-CUSTOM-case:  spaced\r\n" +
+		"X-Dup: 1\r\n" +
+		"X-Dup: 2\r\n" +
+		"X-Folded: a\r\n" +
+		"\tb\r\n" +
+		"Transfer-Encoding : chunked\r\n" +
+		"Content-Length: 99\r\n" +
+		"\r\n" +
+		"user=a&pass=b"
+	if got := string(r.Bytes(r.Target)); got != want {
+		t.Errorf("Got\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestParseContentLength(t *testing.T) {
+	r, err := Parse([]byte("\xef\xbb\xbfPOST / HTTP/1.1\r\nHost: a\r\nContent-Length: 3\r\n\r\n{\"a\":1}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.FixContentLength()
+	if r.Get("Content-Length") != "7" {
+		t.Errorf("Content-Length %q, want 7", r.Get("Content-Length"))
+	}
+
+	// A trailing newline after a GET is not a body.
+	r, err = Parse([]byte("GET / HTTP/1.1\nHost: a\n\n\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.FixContentLength()
+	if r.Body != nil || r.Get("Content-Length") != "" {
+		t.Errorf("Got body %q and Content-Length %q", r.Body, r.Get("Content-Length"))
+	}
+
+	for _, bad := range []string{"", "GET\n", "GET / FTP/1.0\n", "GET / HTTP/1.1\n no header\n", "GET / HTTP/1.1\nnocolon\n"} {
+		if _, err := Parse([]byte(bad)); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+}
+
+func TestOrigin(t *testing.T) {
+	tests := []struct{ target, host, scheme, wantHost string }{
+		{"/x", "a.example:8443", "https", "a.example:8443"},
+		{"http://b.example/x?y", "a.example", "http", "b.example"},
+		{"HTTPS://c.example?q", "", "https", "c.example"},
+	}
+	for _, tt := range tests {
+		r := &Request{Target: tt.target}
+		if tt.host != "" {
+			r.Headers = []Header{{"Host", " " + tt.host}}
+		}
+		scheme, host, err := r.Origin("https")
+		if err != nil || scheme != tt.scheme || host != tt.wantHost {
+			t.Errorf("%s: got %s %s %v", tt.target, scheme, host, err)
+		}
+	}
+}
+
+func TestSendThroughProxy(t *testing.T) {
+	// A proxy that records what it receives and answers itself.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	got := make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		req, err := http.ReadRequest(bufio.NewReader(conn))
+		if err != nil {
+			got <- err.Error()
+			return
+		}
+		body, _ := io.ReadAll(req.Body)
+		got <- req.RequestURI + " " + strings.Join(req.Header["X-Dup"], ",") + " " + string(body)
+		io.WriteString(conn, "HTTP/1.1 201 Created\r\nContent-Length: 2\r\n\r\nok")
+	}()
+
+	r, err := Parse([]byte("PUT /items HTTP/1.1\nHost: origin.example\nX-Dup: 1\nX-Dup: 2\n\nabc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.FixContentLength()
+	res, err := Send(context.Background(), r, Options{Scheme: "http", Proxy: l.Addr().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(res.Body)
+	if res.StatusCode != 201 || string(body) != "ok" {
+		t.Errorf("Got %d %q", res.StatusCode, body)
+	}
+	if want := "http://origin.example/items 1,2 abc"; <-got != want {
+		t.Errorf("Proxy did not receive %q", want)
+	}
+}
+
+func TestSendTLS(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Method+" "+r.URL.Path)
+	}))
+	defer srv.Close()
+
+	r, err := Parse([]byte("DELETE /thing HTTP/1.1\r\nHost: ignored.example\r\n\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := srv.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs
+	res, err := Send(context.Background(), r, Options{Host: srv.Listener.Addr().String(), RootCAs: roots})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if body, _ := io.ReadAll(res.Body); string(body) != "DELETE /thing" {
+		t.Errorf("Got %q", body)
+	}
+}
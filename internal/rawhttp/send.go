@@ -0,0 +1,140 @@
+package rawhttp
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Options controls where Send delivers a request.
+type Options struct {
+	// Scheme and Host name the origin, overriding the request's own.
+	// Scheme defaults to https when the request only has a Host header.
+	Scheme, Host string
+	// Proxy is the address of an HTTP proxy to send through, such as
+	// rogue itself; empty connects to the origin.
+	Proxy string
+	// RootCAs verifies the origin's certificate, or the proxy's when its
+	// CA is added. Nil uses the system roots.
+	RootCAs *x509.CertPool
+	// Insecure skips certificate verification.
+	Insecure bool
+	// Timeout bounds the whole exchange. Zero means 30 seconds.
+	Timeout time.Duration
+}
+
+// Send writes r as it is to its origin and reads the response, which the
+// caller must close. Through a proxy, plain HTTP requests get an
+// absolute-form target and HTTPS ones are tunneled with CONNECT.
+func Send(ctx context.Context, r *Request, opts Options) (*http.Response, error) {
+	scheme, host, err := r.Origin("https")
+	if err != nil {
+		return nil, err
+	}
+	if opts.Scheme != "" {
+		scheme = opts.Scheme
+	}
+	if opts.Host != "" {
+		host = opts.Host
+	}
+	if scheme != "http" && scheme != "https" {
+		return nil, fmt.Errorf("unsupported scheme %q", scheme)
+	}
+	addr := host
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		port := "443"
+		if scheme == "http" {
+			port = "80"
+		}
+		addr = net.JoinHostPort(strings.Trim(host, "[]"), port)
+	}
+
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var d net.Dialer
+	dialAddr := addr
+	if opts.Proxy != "" {
+		dialAddr = opts.Proxy
+	}
+	conn, err := d.DialContext(ctx, "tcp", dialAddr)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	br := bufio.NewReader(conn)
+
+	target := r.Target
+	if scheme == "https" {
+		if opts.Proxy != "" {
+			if err := connect(conn, br, addr); err != nil {
+				conn.Close()
+				return nil, err
+			}
+		}
+		serverName, _, _ := net.SplitHostPort(addr)
+		tc := tls.Client(conn, &tls.Config{
+			ServerName:         serverName,
+			RootCAs:            opts.RootCAs,
+			InsecureSkipVerify: opts.Insecure,
+			NextProtos:         []string{"http/1.1"},
+		})
+		if err := tc.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn, br = tc, bufio.NewReader(tc)
+	} else if opts.Proxy != "" && strings.HasPrefix(target, "/") {
+		target = "http://" + host + target
+	}
+
+	if _, err := conn.Write(r.Bytes(target)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	res, err := http.ReadResponse(br, &http.Request{Method: r.Method})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	res.Body = &connBody{ReadCloser: res.Body, conn: conn}
+	return res, nil
+}
+
+// connect asks the proxy on conn for a tunnel to addr.
+func connect(conn net.Conn, br *bufio.Reader, addr string) error {
+	fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", addr, addr)
+	res, err := http.ReadResponse(br, &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("proxy refused CONNECT %s: %s", addr, res.Status)
+	}
+	return nil
+}
+
+// connBody closes the connection with the response body.
+type connBody struct {
+	io.ReadCloser
+	conn net.Conn
+}
+
+func (b *connBody) Close() error {
+	b.ReadCloser.Close()
+	return b.conn.Close()
+}
@@ -0,0 +1,182 @@
+// Package registry recognises Docker Registry HTTP API v2 and OCI
+// distribution traffic, so image pulls and pushes can be followed in the
+// session log without capturing layer contents.
+package registry
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/standrze/rogue/internal/logger"
+)
+
+// Operations reported in Info.Operation.
+const (
+	OpPing       = "ping"
+	OpCatalog    = "catalog"
+	OpTags       = "tags"
+	OpManifest   = "manifest"
+	OpBlob       = "blob"
+	OpBlobUpload = "blob_upload"
+	OpReferrers  = "referrers"
+)
+
+// Info is the structured form of one registry API call.
+type Info struct {
+	Operation string `json:"operation"`
+	Image     string `json:"image,omitempty"`
+	Tag       string `json:"tag,omitempty"`
+	Digest    string `json:"digest,omitempty"`
+	UploadID  string `json:"upload_id,omitempty"`
+}
+
+// Parse maps a registry API path such as /v2/library/alpine/manifests/3.19
+// to its operation. It reports false for paths outside the API.
+func Parse(path string) (Info, bool) {
+	rest, ok := strings.CutPrefix(path, "/v2/")
+	if !ok {
+		return Info{}, false
+	}
+	switch rest {
+	case "":
+		return Info{Operation: OpPing}, true
+	case "_catalog":
+		return Info{Operation: OpCatalog}, true
+	}
+
+	// Image names may contain slashes, so locate the operation segment from
+	// the right.
+	segs := strings.Split(strings.TrimSuffix(rest, "/"), "/")
+	for i := len(segs) - 1; i > 0; i-- {
+		name := strings.Join(segs[:i], "/")
+		tail := segs[i+1:]
+		switch segs[i] {
+		case "tags":
+			if len(tail) == 1 && tail[0] == "list" {
+				return Info{Operation: OpTags, Image: name}, true
+			}
+		case "manifests":
+			if len(tail) == 1 {
+				info := Info{Operation: OpManifest, Image: name}
+				setReference(&info, tail[0])
+				return info, true
+			}
+		case "referrers":
+			if len(tail) == 1 {
+				return Info{Operation: OpReferrers, Image: name, Digest: tail[0]}, true
+			}
+		case "blobs":
+			switch {
+			case len(tail) == 1 && tail[0] == "uploads":
+				return Info{Operation: OpBlobUpload, Image: name}, true
+			case len(tail) == 2 && tail[0] == "uploads":
+				return Info{Operation: OpBlobUpload, Image: name, UploadID: tail[1]}, true
+			case len(tail) == 1:
+				return Info{Operation: OpBlob, Image: name, Digest: tail[0]}, true
+			}
+		}
+	}
+	return Info{}, false
+}
+
+func setReference(info *Info, ref string) {
+	if strings.Contains(ref, ":") {
+		info.Digest = ref
+	} else {
+		info.Tag = ref
+	}
+}
+
+// Detector implements logger.ProtocolDetector for registry API calls.
+type Detector struct {
+	// LogBlobs keeps layer bodies in the log. They are omitted by default
+	// because a single pull can move gigabytes.
+	LogBlobs bool
+}
+
+func (d Detector) DetectProtocol(req *http.Request, res *http.Response) *logger.Protocol {
+	info, ok := Parse(req.URL.Path)
+	if !ok {
+		return nil
+	}
+
+	// The digest of an upload is only known when it is committed.
+	if dgst := req.URL.Query().Get("digest"); dgst != "" && info.Digest == "" {
+		info.Digest = dgst
+	}
+	if res != nil {
+		if dgst := res.Header.Get("Docker-Content-Digest"); dgst != "" && info.Digest == "" {
+			info.Digest = dgst
+		}
+		if uuid := res.Header.Get("Docker-Upload-UUID"); uuid != "" && info.UploadID == "" {
+			info.UploadID = uuid
+		}
+	}
+
+	return &logger.Protocol{
+		Name:     "registry",
+		Fields:   info,
+		OmitBody: !d.LogBlobs && (info.Operation == OpBlob || info.Operation == OpBlobUpload),
+	}
+}
+
+// Descriptor references content from a manifest.
+type Descriptor struct {
+	MediaType string    `json:"mediaType,omitempty"`
+	Digest    string    `json:"digest"`
+	Size      int64     `json:"size"`
+	Platform  *Platform `json:"platform,omitempty"`
+}
+
+// Platform is the target of one entry in an image index.
+type Platform struct {
+	OS           string `json:"os"`
+	Architecture string `json:"architecture"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// Manifest summarises an image manifest or index.
+type Manifest struct {
+	MediaType string       `json:"media_type"`
+	Config    *Descriptor  `json:"config,omitempty"`
+	Layers    []Descriptor `json:"layers,omitempty"`
+	Manifests []Descriptor `json:"manifests,omitempty"`
+	TotalSize int64        `json:"total_size,omitempty"`
+}
+
+var manifestTypes = map[string]bool{
+	"application/vnd.docker.distribution.manifest.v2+json":      true,
+	"application/vnd.docker.distribution.manifest.list.v2+json": true,
+	"application/vnd.oci.image.manifest.v1+json":                true,
+	"application/vnd.oci.image.index.v1+json":                   true,
+}
+
+// ManifestDecoder implements logger.BodyDecoder for manifests and indexes.
+type ManifestDecoder struct{}
+
+func (ManifestDecoder) DecodeBody(meta logger.BodyMeta, body []byte) *logger.Decoded {
+	mt, _, err := mime.ParseMediaType(meta.ContentType)
+	if err != nil || !manifestTypes[mt] {
+		return nil
+	}
+
+	var raw struct {
+		Config    *Descriptor  `json:"config"`
+		Layers    []Descriptor `json:"layers"`
+		Manifests []Descriptor `json:"manifests"`
+	}
+	d := &logger.Decoded{Format: "oci-manifest"}
+	if err := json.Unmarshal(body, &raw); err != nil {
+		d.Error = err.Error()
+		return d
+	}
+
+	m := Manifest{MediaType: mt, Config: raw.Config, Layers: raw.Layers, Manifests: raw.Manifests}
+	for _, l := range raw.Layers {
+		m.TotalSize += l.Size
+	}
+	d.Value = m
+	return d
+}
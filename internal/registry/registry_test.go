@@ -0,0 +1,38 @@
+package registry
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		path string
+		want Info
+	}{
+		{"/v2/", Info{Operation: OpPing}},
+		{"/v2/_catalog", Info{Operation: OpCatalog}},
+		{"/v2/library/alpine/tags/list", Info{Operation: OpTags, Image: "library/alpine"}},
+		{"/v2/library/alpine/manifests/3.19", Info{Operation: OpManifest, Image: "library/alpine", Tag: "3.19"}},
+		{"/v2/org/team/app/manifests/sha256:abc", Info{Operation: OpManifest, Image: "org/team/app", Digest: "sha256:abc"}},
+		{"/v2/app/blobs/sha256:def", Info{Operation: OpBlob, Image: "app", Digest: "sha256:def"}},
+		{"/v2/app/blobs/uploads/", Info{Operation: OpBlobUpload, Image: "app"}},
+		{"/v2/app/blobs/uploads/1234", Info{Operation: OpBlobUpload, Image: "app", UploadID: "1234"}},
+		// A repository may itself be called "blobs".
+		{"/v2/blobs/manifests/latest", Info{Operation: OpManifest, Image: "blobs", Tag: "latest"}},
+	}
+
+	for _, tt := range tests {
+		got, ok := Parse(tt.path)
+		if !ok {
+			t.Errorf("Parse(%q) not recognised", tt.path)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Parse(%q) = %+v, want %+v", tt.path, got, tt.want)
+		}
+	}
+
+	for _, path := range []string{"/v1/search", "/v2/app", "/api/v2/users/1"} {
+		if _, ok := Parse(path); ok {
+			t.Errorf("Parse(%q) recognised a non-registry path", path)
+		}
+	}
+}
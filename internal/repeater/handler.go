@@ -0,0 +1,107 @@
+package repeater
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// TabRequest is the body of a request creating or editing a tab. Creating
+// takes FlowID to copy a captured request, or Request for raw HTTP text.
+type TabRequest struct {
+	Name    string `json:"name,omitempty"`
+	FlowID  string `json:"flow_id,omitempty"`
+	Request string `json:"request,omitempty"`
+}
+
+// Handler serves the repeater's HTTP API: GET /repeater lists tabs, POST
+// /repeater creates one, GET, PUT and DELETE /repeater/{id} read, edit and
+// remove one, and POST /repeater/{id}/send sends it.
+func (rp *Repeater) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /repeater", rp.handleList)
+	mux.HandleFunc("POST /repeater", rp.handleCreate)
+	mux.HandleFunc("GET /repeater/{id}", rp.handleGet)
+	mux.HandleFunc("PUT /repeater/{id}", rp.handleUpdate)
+	mux.HandleFunc("DELETE /repeater/{id}", rp.handleDelete)
+	mux.HandleFunc("POST /repeater/{id}/send", rp.handleSend)
+	return mux
+}
+
+func (rp *Repeater) handleList(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, rp.Tabs())
+}
+
+func (rp *Repeater) handleCreate(w http.ResponseWriter, r *http.Request) {
+	var req TabRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+		return
+	}
+	t, err := rp.Create(req.Name, req.FlowID, req.Request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, http.StatusCreated, t)
+}
+
+func (rp *Repeater) handleGet(w http.ResponseWriter, r *http.Request) {
+	t := rp.Tab(r.PathValue("id"))
+	if t == nil {
+		http.NotFound(w, r)
+		return
+	}
+	writeJSON(w, http.StatusOK, t)
+}
+
+func (rp *Repeater) handleUpdate(w http.ResponseWriter, r *http.Request) {
+	var req TabRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+		return
+	}
+	t, err := rp.Update(r.PathValue("id"), req.Name, req.Request)
+	switch {
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case t == nil:
+		http.NotFound(w, r)
+	default:
+		writeJSON(w, http.StatusOK, t)
+	}
+}
+
+func (rp *Repeater) handleDelete(w http.ResponseWriter, r *http.Request) {
+	if !rp.Delete(r.PathValue("id")) {
+		http.NotFound(w, r)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (rp *Repeater) handleSend(w http.ResponseWriter, r *http.Request) {
+	var opts SendOptions
+	// An empty body sends once.
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&opts); err != nil {
+			http.Error(w, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+	results, err := rp.Send(r.Context(), r.PathValue("id"), opts)
+	switch {
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case results == nil:
+		http.NotFound(w, r)
+	default:
+		writeJSON(w, http.StatusOK, results)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,370 @@
+// Package repeater keeps captured requests in editable tabs and sends them
+// again, once or many times and optionally concurrently, for the workflow
+// of Burp Suite's Repeater. Requests go through the proxy, so every attempt
+// is recorded in the session as a flow of its own, tagged with the tab it
+// came from.
+package repeater
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/standrze/rogue/internal/env"
+	"github.com/standrze/rogue/internal/logger"
+	"github.com/standrze/rogue/internal/proxy"
+	"github.com/standrze/rogue/internal/rawhttp"
+)
+
+// Limits on a single send.
+const (
+	MaxCount       = 1000
+	MaxConcurrency = 50
+)
+
+// Tab is a request being edited. Request is the raw HTTP text as sent.
+type Tab struct {
+	ID      string    `json:"id"`
+	Name    string    `json:"name,omitempty"`
+	FlowID  string    `json:"flow_id,omitempty"`
+	Request string    `json:"request"`
+	Created time.Time `json:"created"`
+	Updated time.Time `json:"updated"`
+	// Results are those of the last send.
+	Results []Result `json:"results,omitempty"`
+}
+
+// Result is one attempt of a send, numbered from 1.
+type Result struct {
+	N          int    `json:"n"`
+	Status     int    `json:"status,omitempty"`
+	DurationMS int64  `json:"duration_ms"`
+	Size       int64  `json:"size"`
+	Error      string `json:"error,omitempty"`
+}
+
+// SendOptions controls a send.
+type SendOptions struct {
+	// Count is how many times to send; 0 means once.
+	Count int `json:"count,omitempty"`
+	// Concurrency is how many attempts are in flight at once; 0 means one
+	// after the other.
+	Concurrency int `json:"concurrency,omitempty"`
+	// Tags are recorded on the resulting flows along with "repeater" and
+	// "repeater:<tab id>".
+	Tags []string `json:"tags,omitempty"`
+	// Target overrides the request's origin, as scheme://host[:port].
+	Target string `json:"target,omitempty"`
+	// Env names the environment whose variables the request and Target
+	// refer to as {{name}}, instead of the repeater's default one. Vars
+	// add to and override its variables for this send.
+	Env  string   `json:"env,omitempty"`
+	Vars env.Vars `json:"vars,omitempty"`
+}
+
+// Finder looks up captured flows, as viewer.Archive does.
+type Finder interface {
+	Find(id string) (*logger.Flow, string, error)
+}
+
+// Repeater holds the tabs of a running proxy.
+type Repeater struct {
+	// Flows finds the flows tabs are copied from.
+	Flows Finder
+	// Options sends requests, normally through the proxy.
+	Options rawhttp.Options
+	// Env returns the variables of the environment name, or of the
+	// default one for an empty name; nil variables leave requests as they
+	// are. Without it only SendOptions.Vars are expanded.
+	Env func(name string) (env.Vars, error)
+
+	mu   sync.Mutex
+	tabs map[string]*Tab
+	next int
+}
+
+// New returns a repeater with no tabs.
+func New(flows Finder, opts rawhttp.Options) *Repeater {
+	return &Repeater{Flows: flows, Options: opts, tabs: map[string]*Tab{}}
+}
+
+// FromFlow returns the request of a captured flow as raw HTTP text.
+func FromFlow(f *logger.Flow) (string, error) {
+	r, err := Request(f)
+	if err != nil {
+		return "", err
+	}
+	return string(r.Bytes(r.Target)), nil
+}
+
+// Request rebuilds the request of a captured flow. Plain HTTP requests keep
+// their absolute URL so the scheme survives; HTTPS ones are written in
+// origin form with a Host header. It fails for requests whose body was not
+// captured in full.
+func Request(f *logger.Flow) (*rawhttp.Request, error) {
+	req := f.Request
+	if req == nil {
+		return nil, errors.New("the flow has no request")
+	}
+	if req.Truncated {
+		return nil, errors.New("the request body was truncated when captured")
+	}
+	u, err := url.Parse(req.URL)
+	if err != nil {
+		return nil, err
+	}
+	body, err := req.RawBody()
+	if err != nil {
+		return nil, err
+	}
+
+	target := u.RequestURI()
+	if u.Scheme == "http" {
+		target = u.String()
+	}
+	r := &rawhttp.Request{Method: req.Method, Target: target, Proto: "HTTP/1.1", Body: body}
+	r.Set("Host", u.Host)
+	names := make([]string, 0, len(req.Headers))
+	for name := range req.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		// The captured body is already de-chunked; FixContentLength sizes it.
+		switch http.CanonicalHeaderKey(name) {
+		case "Host", "Proxy-Connection", "Transfer-Encoding", "Content-Length":
+			continue
+		}
+		r.Headers = append(r.Headers, rawhttp.Header{Name: name, Value: " " + req.Headers[name]})
+	}
+	r.FixContentLength()
+	return r, nil
+}
+
+// Create adds a tab holding raw, or when raw is empty the request of the
+// flow with flowID.
+func (rp *Repeater) Create(name, flowID, raw string) (*Tab, error) {
+	if raw == "" {
+		if flowID == "" {
+			return nil, errors.New("give a flow_id or a request")
+		}
+		if rp.Flows == nil {
+			return nil, errors.New("captured flows are not available")
+		}
+		f, _, err := rp.Flows.Find(flowID)
+		if err != nil {
+			return nil, err
+		}
+		if f == nil {
+			return nil, fmt.Errorf("no flow %q", flowID)
+		}
+		if raw, err = FromFlow(f); err != nil {
+			return nil, err
+		}
+	} else if err := check(raw); err != nil {
+		return nil, err
+	}
+
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	rp.next++
+	now := time.Now()
+	t := &Tab{ID: strconv.Itoa(rp.next), Name: name, FlowID: flowID, Request: raw, Created: now, Updated: now}
+	rp.tabs[t.ID] = t
+	return t.copy(), nil
+}
+
+// check reports whether raw is a request that can be sent. The origin of
+// a request referring to variables is only known once they are expanded.
+func check(raw string) error {
+	r, err := rawhttp.Parse([]byte(raw))
+	if err != nil {
+		return err
+	}
+	if strings.Contains(raw, "{{") {
+		return nil
+	}
+	_, _, err = r.Origin("https")
+	return err
+}
+
+func (t *Tab) copy() *Tab {
+	c := *t
+	c.Results = slices.Clone(t.Results)
+	return &c
+}
+
+// Tabs returns the tabs in the order they were created.
+func (rp *Repeater) Tabs() []*Tab {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	out := make([]*Tab, 0, len(rp.tabs))
+	for _, t := range rp.tabs {
+		out = append(out, t.copy())
+	}
+	sort.Slice(out, func(i, j int) bool {
+		a, _ := strconv.Atoi(out[i].ID)
+		b, _ := strconv.Atoi(out[j].ID)
+		return a < b
+	})
+	return out
+}
+
+// Tab returns the tab with id, or nil.
+func (rp *Repeater) Tab(id string) *Tab {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	if t, ok := rp.tabs[id]; ok {
+		return t.copy()
+	}
+	return nil
+}
+
+// Update replaces the request and, when set, the name of a tab.
+func (rp *Repeater) Update(id, name, raw string) (*Tab, error) {
+	if err := check(raw); err != nil {
+		return nil, err
+	}
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	t, ok := rp.tabs[id]
+	if !ok {
+		return nil, nil
+	}
+	if name != "" {
+		t.Name = name
+	}
+	t.Request, t.Updated = raw, time.Now()
+	return t.copy(), nil
+}
+
+// Delete removes a tab and reports whether it existed.
+func (rp *Repeater) Delete(id string) bool {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	_, ok := rp.tabs[id]
+	delete(rp.tabs, id)
+	return ok
+}
+
+// Send sends the request of tab id as opts asks and records the results on
+// the tab. Failed attempts are reported in their Result rather than as an
+// error.
+func (rp *Repeater) Send(ctx context.Context, id string, opts SendOptions) ([]Result, error) {
+	t := rp.Tab(id)
+	if t == nil {
+		return nil, nil
+	}
+	count := max(opts.Count, 1)
+	if count > MaxCount {
+		return nil, fmt.Errorf("count %d is over the limit of %d", count, MaxCount)
+	}
+	concurrency := min(max(opts.Concurrency, 1), count)
+	if concurrency > MaxConcurrency {
+		return nil, fmt.Errorf("concurrency %d is over the limit of %d", concurrency, MaxConcurrency)
+	}
+	for _, tag := range opts.Tags {
+		if strings.TrimSpace(tag) == "" || strings.Contains(tag, ",") {
+			return nil, fmt.Errorf("invalid tag %q", tag)
+		}
+	}
+	raw, target, err := rp.expand(t.Request, opts)
+	if err != nil {
+		return nil, err
+	}
+	sendOpts := rp.Options
+	if target != "" {
+		u, err := url.Parse(target)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid target %q (want scheme://host[:port])", target)
+		}
+		sendOpts.Scheme, sendOpts.Host = u.Scheme, u.Host
+	}
+
+	req, err := rawhttp.Parse([]byte(raw))
+	if err != nil {
+		return nil, err
+	}
+	if raw != t.Request {
+		// Values of variables change the length of bodies using them.
+		req.FixContentLength()
+	}
+	tags := append([]string{"repeater", "repeater:" + t.ID}, opts.Tags...)
+	req.Set(proxy.TagHeader, strings.Join(tags, ","))
+	note := "repeater: tab " + t.ID
+	if t.FlowID != "" {
+		note += ", copied from flow " + t.FlowID
+	}
+	req.Set(proxy.NoteHeader, note)
+
+	results := make([]Result, count)
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i := range results {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			results[i] = send(ctx, req, sendOpts)
+			results[i].N = i + 1
+		}()
+	}
+	wg.Wait()
+
+	rp.mu.Lock()
+	if t, ok := rp.tabs[id]; ok {
+		t.Results = results
+	}
+	rp.mu.Unlock()
+	return results, nil
+}
+
+// expand replaces the variables raw and the target of opts refer to.
+func (rp *Repeater) expand(raw string, opts SendOptions) (string, string, error) {
+	var vars env.Vars
+	if rp.Env != nil {
+		var err error
+		if vars, err = rp.Env(opts.Env); err != nil {
+			return "", "", err
+		}
+	} else if opts.Env != "" {
+		return "", "", errors.New("environments are not available")
+	}
+	if vars == nil && opts.Vars == nil {
+		return raw, opts.Target, nil
+	}
+	vars = vars.With(opts.Vars)
+	raw, err := vars.Expand(raw)
+	if err != nil {
+		return "", "", err
+	}
+	target, err := vars.Expand(opts.Target)
+	if err != nil {
+		return "", "", fmt.Errorf("target: %w", err)
+	}
+	return raw, target, nil
+}
+
+func send(ctx context.Context, req *rawhttp.Request, opts rawhttp.Options) Result {
+	start := time.Now()
+	res, err := rawhttp.Send(ctx, req, opts)
+	if err != nil {
+		return Result{DurationMS: time.Since(start).Milliseconds(), Error: err.Error()}
+	}
+	defer res.Body.Close()
+	n, err := io.Copy(io.Discard, res.Body)
+	r := Result{Status: res.StatusCode, Size: n, DurationMS: time.Since(start).Milliseconds()}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	return r
+}
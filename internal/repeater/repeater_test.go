@@ -0,0 +1,184 @@
+package repeater
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/standrze/rogue/internal/env"
+	"github.com/standrze/rogue/internal/logger"
+	"github.com/standrze/rogue/internal/rawhttp"
+)
+
+type flows map[string]*logger.Flow
+
+func (f flows) Find(id string) (*logger.Flow, string, error) {
+	return f[id], "session.jsonl", nil
+}
+
+func TestFromFlow(t *testing.T) {
+	raw, err := FromFlow(&logger.Flow{Request: &logger.RequestLog{
+		Method: "POST",
+		URL:    "https://api.example.com/v1/items?x=1",
+		Headers: map[string]string{
+			"Content-Type":      "application/json",
+			"Host":              "api.example.com",
+			"Transfer-Encoding": "chunked",
+		},
+		Body:         "eyJhIjoxfQ==",
+		BodyEncoding: "base64",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "POST /v1/items?x=1 HTTP/1.1\r\nHost: api.example.com\r\nContent-Type: application/json\r\nContent-Length: 7\r\n\r\n{\"a\":1}"
+	if raw != want {
+		t.Errorf("FromFlow = %q, want %q", raw, want)
+	}
+
+	raw, err = FromFlow(&logger.Flow{Request: &logger.RequestLog{Method: "GET", URL: "http://plain.test/a"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(raw, "GET http://plain.test/a HTTP/1.1\r\n") {
+		t.Errorf("FromFlow = %q, want an absolute-form target", raw)
+	}
+
+	if _, err := FromFlow(&logger.Flow{Request: &logger.RequestLog{Method: "POST", URL: "http://a.test/", Truncated: true}}); err == nil {
+		t.Error("FromFlow accepted a truncated body")
+	}
+}
+
+func TestSend(t *testing.T) {
+	var hits atomic.Int32
+	var tags atomic.Value
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		tags.Store(r.Header.Get("X-Rogue-Tag"))
+		w.Write([]byte("pong"))
+	}))
+	defer origin.Close()
+	host := strings.TrimPrefix(origin.URL, "http://")
+
+	rp := New(flows{"f1": {Request: &logger.RequestLog{Method: "GET", URL: origin.URL + "/ping"}}}, rawhttp.Options{})
+	tab, err := rp.Create("", "f1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := rp.Send(context.Background(), tab.ID, SendOptions{Count: 5, Concurrency: 2, Tags: []string{"idor"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 5 || hits.Load() != 5 {
+		t.Fatalf("sent %d, origin saw %d, want 5", len(results), hits.Load())
+	}
+	for i, r := range results {
+		if r.N != i+1 || r.Status != 200 || r.Size != 4 || r.Error != "" {
+			t.Errorf("result %d = %+v", i, r)
+		}
+	}
+	if got := tags.Load(); got != "repeater,repeater:1,idor" {
+		t.Errorf("tags = %q", got)
+	}
+	if got := rp.Tab(tab.ID).Results; len(got) != 5 {
+		t.Errorf("the tab kept %d results, want 5", len(got))
+	}
+
+	// An edited tab sends the new request.
+	if _, err := rp.Update(tab.ID, "edited", "GET /other HTTP/1.1\r\nHost: "+host+"\r\n\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	results, err = rp.Send(context.Background(), tab.ID, SendOptions{Target: "http://" + host})
+	if err != nil || len(results) != 1 || results[0].Status != 200 {
+		t.Errorf("Send = %+v, %v", results, err)
+	}
+
+	if _, err := rp.Send(context.Background(), tab.ID, SendOptions{Count: MaxCount + 1}); err == nil {
+		t.Error("Send accepted a count over the limit")
+	}
+	if _, err := rp.Send(context.Background(), tab.ID, SendOptions{Tags: []string{"a,b"}}); err == nil {
+		t.Error("Send accepted a tag with a comma")
+	}
+}
+
+func TestHandler(t *testing.T) {
+	rp := New(flows{}, rawhttp.Options{})
+	h := rp.Handler()
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rec
+	}
+
+	if rec := do("POST", "/repeater", `{"flow_id":"missing"}`); rec.Code != 400 {
+		t.Errorf("unknown flow: status = %d, want 400", rec.Code)
+	}
+	if rec := do("POST", "/repeater", `{"request":"not http"}`); rec.Code != 400 {
+		t.Errorf("bad request text: status = %d, want 400", rec.Code)
+	}
+	rec := do("POST", "/repeater", `{"name":"login","request":"GET / HTTP/1.1\r\nHost: a.test\r\n\r\n"}`)
+	if rec.Code != 201 || !strings.Contains(rec.Body.String(), `"name":"login"`) {
+		t.Fatalf("create: %d %s", rec.Code, rec.Body)
+	}
+	if rec := do("GET", "/repeater", ""); !strings.Contains(rec.Body.String(), `"id":"1"`) {
+		t.Errorf("list: %s", rec.Body)
+	}
+	if rec := do("PUT", "/repeater/1", `{"request":"GET /x HTTP/1.1\r\nHost: a.test\r\n\r\n"}`); rec.Code != 200 {
+		t.Errorf("update: status = %d", rec.Code)
+	}
+	if rec := do("POST", "/repeater/2/send", ""); rec.Code != 404 {
+		t.Errorf("send to a missing tab: status = %d, want 404", rec.Code)
+	}
+	if rec := do("DELETE", "/repeater/1", ""); rec.Code != 204 {
+		t.Errorf("delete: status = %d", rec.Code)
+	}
+	if rec := do("GET", "/repeater/1", ""); rec.Code != 404 {
+		t.Errorf("deleted tab: status = %d, want 404", rec.Code)
+	}
+}
+
+func TestSendEnv(t *testing.T) {
+	var auth, body atomic.Value
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth.Store(r.Header.Get("Authorization"))
+		b, _ := io.ReadAll(r.Body)
+		body.Store(string(b))
+	}))
+	defer origin.Close()
+
+	envs := map[string]env.Vars{
+		"":        {"base_url": origin.URL, "token": "staging"},
+		"prod":    {"base_url": origin.URL, "token": "prod"},
+		"missing": {},
+	}
+	host := strings.TrimPrefix(origin.URL, "http://")
+	rp := New(flows{}, rawhttp.Options{})
+	rp.Env = func(name string) (env.Vars, error) { return envs[name], nil }
+	tab, err := rp.Create("", "", "POST {{base_url}}/login HTTP/1.1\r\nHost: "+host+"\r\nAuthorization: Bearer {{token}}\r\nContent-Length: 2\r\n\r\n{{user}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		opts       SendOptions
+		auth, body string
+	}{
+		{SendOptions{Vars: env.Vars{"user": "alice"}}, "Bearer staging", "alice"},
+		{SendOptions{Env: "prod", Vars: env.Vars{"user": "bob", "token": "override"}}, "Bearer override", "bob"},
+	} {
+		results, err := rp.Send(context.Background(), tab.ID, tc.opts)
+		if err != nil || results[0].Error != "" {
+			t.Fatalf("Send = %+v, %v", results, err)
+		}
+		if auth.Load() != tc.auth || body.Load() != tc.body {
+			t.Errorf("%+v: origin saw %q %q", tc.opts, auth.Load(), body.Load())
+		}
+	}
+	if _, err := rp.Send(context.Background(), tab.ID, SendOptions{Env: "missing"}); err == nil {
+		t.Error("Send with undefined variables succeeded")
+	}
+}
@@ -0,0 +1,123 @@
+package rules
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// replayedHeaders are the captured response headers a replaying mock keeps.
+// Framing and encoding headers no longer describe the logged body.
+var replayedHeaders = []string{"Content-Type", "Cache-Control", "Location"}
+
+// Exchange is a captured request and response to build a rule from.
+type Exchange struct {
+	Method string
+	URL    string
+	// Status, Headers and Body describe the response; Status is zero when
+	// none was recorded.
+	Status    int
+	Headers   map[string]string
+	Body      string
+	Truncated bool
+}
+
+// Request rebuilds the captured request for matching.
+func (ex Exchange) Request() (*http.Request, error) {
+	u, err := url.Parse(ex.URL)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Request{Method: ex.Method, URL: u, Host: u.Host, Header: http.Header{}}, nil
+}
+
+// MatchExchange returns a match selecting exactly the captured request.
+func MatchExchange(ex Exchange) (Match, error) {
+	u, err := url.Parse(ex.URL)
+	if err != nil {
+		return Match{}, err
+	}
+	return Match{Methods: []string{ex.Method}, Host: u.Hostname(), Path: u.Path}, nil
+}
+
+// ReplayMock returns a mock answering with the captured response. It
+// reports false when the response is not fit to replay as is: it was not
+// recorded in full, or its body is still content-encoded.
+func ReplayMock(ex Exchange) (*Mock, bool) {
+	m := &Mock{Status: ex.Status, Body: ex.Body, Headers: map[string]string{}}
+	faithful := !ex.Truncated
+	for k, v := range ex.Headers {
+		for _, keep := range replayedHeaders {
+			if strings.EqualFold(k, keep) {
+				m.Headers[keep] = v
+			}
+		}
+		if strings.EqualFold(k, "Content-Encoding") && v != "identity" {
+			faithful = false
+		}
+	}
+	return m, faithful
+}
+
+// DefaultName names a rule after its match.
+func DefaultName(m Match) string {
+	parts := []string{strings.Join(m.Methods, ",")}
+	parts = append(parts, m.Host+m.Path)
+	return strings.TrimSpace(strings.Join(parts, " "))
+}
+
+// Preview is the effect a rule would have on a captured request if it was
+// added to the end of a set.
+type Preview struct {
+	// Matches reports whether the rule selects the request.
+	Matches bool
+	// Shadowed names an earlier rule that would match the request first.
+	Shadowed string
+}
+
+// Preview reports how r would apply to req once appended to s.
+func (s *Set) Preview(r Rule, req *http.Request) Preview {
+	p := Preview{Matches: r.Match.Matches(req)}
+	if earlier, ok := s.Match(req); ok {
+		p.Shadowed = earlier.Name
+	}
+	return p
+}
+
+// Handler serves the rules of a set over HTTP: GET lists them and POST
+// appends the rule in the JSON request body.
+type Handler struct {
+	Set *Set
+	// Profiles, if set, are the header profiles added rules may name.
+	Profiles Profiles
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(file{Rules: h.Set.Rules()})
+	case http.MethodPost:
+		var rule Rule
+		d := json.NewDecoder(r.Body)
+		d.DisallowUnknownFields()
+		err := d.Decode(&rule)
+		if err == nil {
+			err = rule.Validate()
+		}
+		if err == nil && h.Profiles != nil {
+			err = h.Profiles.Check(rule.Headers...)
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid rule: %v", err), http.StatusBadRequest)
+			return
+		}
+		h.Set.Add(rule)
+		w.WriteHeader(http.StatusCreated)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
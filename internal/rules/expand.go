@@ -0,0 +1,64 @@
+package rules
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// Expand returns r with expand applied to every string in it, such as mock
+// bodies, header values and map_remote hosts, for rules that refer to the
+// variables of an environment. The result is validated again.
+func (r Rule) Expand(expand func(string) (string, error)) (Rule, error) {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return r, err
+	}
+	if !bytes.Contains(data, []byte("{{")) {
+		return r, nil
+	}
+	var tree any
+	if err := json.Unmarshal(data, &tree); err != nil {
+		return r, err
+	}
+	if tree, err = expandStrings(tree, expand); err != nil {
+		return r, err
+	}
+	if data, err = json.Marshal(tree); err != nil {
+		return r, err
+	}
+	var out Rule
+	if err := json.Unmarshal(data, &out); err != nil {
+		return r, err
+	}
+	return out, out.Validate()
+}
+
+// expandStrings applies expand to the strings of a decoded JSON value,
+// object keys included.
+func expandStrings(v any, expand func(string) (string, error)) (any, error) {
+	switch v := v.(type) {
+	case string:
+		return expand(v)
+	case []any:
+		for i := range v {
+			var err error
+			if v[i], err = expandStrings(v[i], expand); err != nil {
+				return nil, err
+			}
+		}
+		return v, nil
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for k, e := range v {
+			k, err := expand(k)
+			if err != nil {
+				return nil, err
+			}
+			if out[k], err = expandStrings(e, expand); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	}
+	return v, nil
+}
@@ -0,0 +1,89 @@
+package rules
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// HeaderOps edits a set of headers. Remove runs first and accepts globs
+// such as "X-Tracking-*"; Set then replaces values and Add appends them.
+type HeaderOps struct {
+	Add    map[string]string `json:"add,omitempty" yaml:"add,omitempty"`
+	Set    map[string]string `json:"set,omitempty" yaml:"set,omitempty"`
+	Remove []string          `json:"remove,omitempty" yaml:"remove,omitempty"`
+}
+
+// Apply edits h.
+func (o HeaderOps) Apply(h http.Header) {
+	for _, pattern := range o.Remove {
+		pattern = strings.ToLower(pattern)
+		for k := range h {
+			if ok, _ := path.Match(pattern, strings.ToLower(k)); ok {
+				delete(h, k)
+			}
+		}
+	}
+	for k, v := range o.Set {
+		h.Set(k, v)
+	}
+	for k, v := range o.Add {
+		h.Add(k, v)
+	}
+}
+
+// HeaderProfile is a named, reusable set of header edits, applied to every
+// exchange when enabled globally or to those matching a rule naming it.
+type HeaderProfile struct {
+	Request  HeaderOps `json:"request,omitempty" yaml:"request,omitempty"`
+	Response HeaderOps `json:"response,omitempty" yaml:"response,omitempty"`
+}
+
+// Profiles holds header profiles by name. Names are case-insensitive.
+type Profiles map[string]HeaderProfile
+
+// Lookup returns the profile called name.
+func (p Profiles) Lookup(name string) (HeaderProfile, bool) {
+	if hp, ok := p[name]; ok {
+		return hp, true
+	}
+	for k, hp := range p {
+		if strings.EqualFold(k, name) {
+			return hp, true
+		}
+	}
+	return HeaderProfile{}, false
+}
+
+// Check reports names that are not profiles in p.
+func (p Profiles) Check(names ...string) error {
+	for _, name := range names {
+		if _, ok := p.Lookup(name); !ok {
+			return fmt.Errorf("unknown header profile %q", name)
+		}
+	}
+	return nil
+}
+
+// validate checks patterns in o.
+func (o HeaderOps) validate() error {
+	for _, pattern := range o.Remove {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid header pattern %q", pattern)
+		}
+	}
+	return nil
+}
+
+// Validate checks the remove patterns of every profile.
+func (p Profiles) Validate() error {
+	for name, hp := range p {
+		for _, o := range []HeaderOps{hp.Request, hp.Response} {
+			if err := o.validate(); err != nil {
+				return fmt.Errorf("header profile %q: %w", name, err)
+			}
+		}
+	}
+	return nil
+}
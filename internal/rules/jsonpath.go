@@ -0,0 +1,350 @@
+package rules
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// A JSONPath is a parsed path such as $.items[0].price. It supports the
+// subset needed to point at values: .name and ['name'] for members, [n]
+// for array elements, and .* and [*] for every child.
+type JSONPath []pathStep
+
+type pathStep struct {
+	name  string
+	index int
+	kind  stepKind
+}
+
+type stepKind int
+
+const (
+	stepName stepKind = iota
+	stepIndex
+	stepWildcard
+)
+
+// ParseJSONPath parses a path starting at the root, $.
+func ParseJSONPath(s string) (JSONPath, error) {
+	rest, ok := strings.CutPrefix(s, "$")
+	if !ok {
+		return nil, fmt.Errorf("json path %q must start with $", s)
+	}
+	var p JSONPath
+	for rest != "" {
+		switch {
+		case strings.HasPrefix(rest, ".."):
+			return nil, fmt.Errorf("json path %q: recursive descent is not supported", s)
+		case rest[0] == '.':
+			end := strings.IndexAny(rest[1:], ".[")
+			if end < 0 {
+				end = len(rest) - 1
+			}
+			name := rest[1 : end+1]
+			if name == "" {
+				return nil, fmt.Errorf("json path %q: empty member name", s)
+			}
+			if name == "*" {
+				p = append(p, pathStep{kind: stepWildcard})
+			} else {
+				p = append(p, pathStep{kind: stepName, name: name})
+			}
+			rest = rest[end+1:]
+		case rest[0] == '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("json path %q: unclosed [", s)
+			}
+			inner := rest[1:end]
+			switch {
+			case inner == "*":
+				p = append(p, pathStep{kind: stepWildcard})
+			case len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0]:
+				p = append(p, pathStep{kind: stepName, name: inner[1 : len(inner)-1]})
+			default:
+				n, err := strconv.Atoi(inner)
+				if err != nil || n < 0 {
+					return nil, fmt.Errorf("json path %q: invalid index [%s]", s, inner)
+				}
+				p = append(p, pathStep{kind: stepIndex, index: n})
+			}
+			rest = rest[end+1:]
+		default:
+			return nil, fmt.Errorf("json path %q: unexpected %q", s, rest[0])
+		}
+	}
+	return p, nil
+}
+
+// object is a decoded JSON object that keeps the order of its members, so
+// rewritten bodies differ from the original only where they were edited.
+type object struct {
+	keys []string
+	vals map[string]any
+}
+
+func (o *object) set(k string, v any) {
+	if _, ok := o.vals[k]; !ok {
+		o.keys = append(o.keys, k)
+	}
+	o.vals[k] = v
+}
+
+func (o *object) remove(k string) bool {
+	if _, ok := o.vals[k]; !ok {
+		return false
+	}
+	delete(o.vals, k)
+	o.keys = slices.DeleteFunc(o.keys, func(s string) bool { return s == k })
+	return true
+}
+
+// array is a decoded JSON array that can be edited in place.
+type array struct {
+	items []any
+}
+
+// decodeJSON decodes a single JSON value into objects, arrays and, for
+// scalars, the types of encoding/json with numbers kept as written.
+func decodeJSON(data []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	v, err := decodeValue(dec)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("trailing data after JSON value")
+	}
+	return v, nil
+}
+
+func decodeValue(dec *json.Decoder) (any, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch tok {
+	case json.Delim('{'):
+		o := &object{vals: map[string]any{}}
+		for dec.More() {
+			k, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			v, err := decodeValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			o.set(k.(string), v)
+		}
+		_, err := dec.Token()
+		return o, err
+	case json.Delim('['):
+		a := &array{}
+		for dec.More() {
+			v, err := decodeValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			a.items = append(a.items, v)
+		}
+		_, err := dec.Token()
+		return a, err
+	}
+	return tok, nil
+}
+
+// encodeJSON writes v compactly without escaping HTML characters.
+func encodeJSON(buf *bytes.Buffer, v any) error {
+	switch v := v.(type) {
+	case *object:
+		buf.WriteByte('{')
+		for i, k := range v.keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := encodeJSON(buf, k); err != nil {
+				return err
+			}
+			buf.WriteByte(':')
+			if err := encodeJSON(buf, v.vals[k]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+		return nil
+	case *array:
+		buf.WriteByte('[')
+		for i, item := range v.items {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := encodeJSON(buf, item); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+		return nil
+	}
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return err
+	}
+	buf.Truncate(buf.Len() - 1) // the newline Encode adds
+	return nil
+}
+
+// children returns the values p's step selects in v.
+func (s pathStep) children(v any) []any {
+	switch v := v.(type) {
+	case *object:
+		switch s.kind {
+		case stepName:
+			if c, ok := v.vals[s.name]; ok {
+				return []any{c}
+			}
+		case stepWildcard:
+			out := make([]any, len(v.keys))
+			for i, k := range v.keys {
+				out[i] = v.vals[k]
+			}
+			return out
+		}
+	case *array:
+		switch s.kind {
+		case stepIndex:
+			if s.index < len(v.items) {
+				return []any{v.items[s.index]}
+			}
+		case stepWildcard:
+			return slices.Clone(v.items)
+		}
+	}
+	return nil
+}
+
+// parents returns the values the path up to its last step selects in
+// root. With create set, missing members are added as empty objects.
+func (p JSONPath) parents(root any, create bool) []any {
+	nodes := []any{root}
+	for i, s := range p[:len(p)-1] {
+		var next []any
+		for _, n := range nodes {
+			c := s.children(n)
+			if o, ok := n.(*object); ok && len(c) == 0 && create && s.kind == stepName && p[i+1].kind != stepIndex {
+				child := &object{vals: map[string]any{}}
+				o.set(s.name, child)
+				c = []any{child}
+			}
+			next = append(next, c...)
+		}
+		nodes = next
+	}
+	return nodes
+}
+
+// set replaces the values p selects in root with value, adding members
+// that are missing, and reports how many were set. Setting $ itself is
+// not possible here; callers replace the whole document instead.
+func (p JSONPath) set(root any, value func() any) int {
+	last := p[len(p)-1]
+	n := 0
+	for _, parent := range p.parents(root, true) {
+		switch parent := parent.(type) {
+		case *object:
+			switch last.kind {
+			case stepName:
+				parent.set(last.name, value())
+				n++
+			case stepWildcard:
+				for _, k := range parent.keys {
+					parent.vals[k] = value()
+					n++
+				}
+			}
+		case *array:
+			switch last.kind {
+			case stepIndex:
+				if last.index < len(parent.items) {
+					parent.items[last.index] = value()
+					n++
+				}
+			case stepWildcard:
+				for i := range parent.items {
+					parent.items[i] = value()
+					n++
+				}
+			}
+		}
+	}
+	return n
+}
+
+// remove deletes the values p selects in root and reports how many were
+// deleted.
+func (p JSONPath) remove(root any) int {
+	last := p[len(p)-1]
+	n := 0
+	for _, parent := range p.parents(root, false) {
+		switch parent := parent.(type) {
+		case *object:
+			switch last.kind {
+			case stepName:
+				if parent.remove(last.name) {
+					n++
+				}
+			case stepWildcard:
+				n += len(parent.keys)
+				parent.keys, parent.vals = nil, map[string]any{}
+			}
+		case *array:
+			switch last.kind {
+			case stepIndex:
+				if last.index < len(parent.items) {
+					parent.items = slices.Delete(parent.items, last.index, last.index+1)
+					n++
+				}
+			case stepWildcard:
+				n += len(parent.items)
+				parent.items = nil
+			}
+		}
+	}
+	return n
+}
+
+// Lookup returns the first value p selects in the JSON document data:
+// strings as they are, other values as JSON.
+func (p JSONPath) Lookup(data []byte) (string, bool) {
+	root, err := decodeJSON(data)
+	if err != nil {
+		return "", false
+	}
+	nodes := []any{root}
+	for _, s := range p {
+		var next []any
+		for _, n := range nodes {
+			next = append(next, s.children(n)...)
+		}
+		nodes = next
+	}
+	if len(nodes) == 0 {
+		return "", false
+	}
+	if s, ok := nodes[0].(string); ok {
+		return s, true
+	}
+	var buf bytes.Buffer
+	if err := encodeJSON(&buf, nodes[0]); err != nil {
+		return "", false
+	}
+	return buf.String(), true
+}
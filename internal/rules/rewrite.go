@@ -0,0 +1,141 @@
+package rules
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"unicode/utf8"
+)
+
+// BodyEdit is one change to a body. Regex edits replace every match of
+// Regex in text bodies with Replace, which may refer to groups as $1 or
+// ${name}. JSONPath edits select values of JSON bodies to Set or Delete;
+// setting a member that does not exist adds it.
+type BodyEdit struct {
+	Regex   string `json:"regex,omitempty" yaml:"regex,omitempty"`
+	Replace string `json:"replace,omitempty" yaml:"replace,omitempty"`
+
+	JSONPath string `json:"jsonpath,omitempty" yaml:"jsonpath,omitempty"`
+	Set      any    `json:"set,omitempty" yaml:"set,omitempty"`
+	Delete   bool   `json:"delete,omitempty" yaml:"delete,omitempty"`
+}
+
+// Rewrite edits the bodies of matching exchanges, applying the edits of
+// each side in order.
+type Rewrite struct {
+	Request  []BodyEdit `json:"request,omitempty" yaml:"request,omitempty"`
+	Response []BodyEdit `json:"response,omitempty" yaml:"response,omitempty"`
+}
+
+// validate reports edits that are incomplete or do not parse.
+func (e BodyEdit) validate() error {
+	switch {
+	case e.Regex != "" && e.JSONPath != "":
+		return errors.New("set either regex or jsonpath, not both")
+	case e.Regex != "":
+		if _, err := regexp.Compile(e.Regex); err != nil {
+			return fmt.Errorf("invalid rewrite regex: %w", err)
+		}
+		if e.Set != nil || e.Delete {
+			return errors.New("set and delete apply to jsonpath edits")
+		}
+	case e.JSONPath != "":
+		p, err := ParseJSONPath(e.JSONPath)
+		if err != nil {
+			return err
+		}
+		if (e.Set != nil) == e.Delete {
+			return fmt.Errorf("jsonpath %s needs one of set and delete", e.JSONPath)
+		}
+		if e.Delete && len(p) == 0 {
+			return errors.New("cannot delete $")
+		}
+		if _, err := json.Marshal(e.Set); err != nil {
+			return fmt.Errorf("rewrite value for %s: %w", e.JSONPath, err)
+		}
+	default:
+		return errors.New("rewrite edits need a regex or a jsonpath")
+	}
+	return nil
+}
+
+func (r *Rewrite) validate() error {
+	for _, edits := range [][]BodyEdit{r.Request, r.Response} {
+		for _, e := range edits {
+			if err := e.validate(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ApplyEdits applies edits that passed Validate to body and reports
+// whether it changed. Regex edits skip bodies that are not UTF-8 text and
+// JSONPath edits those that are not JSON. A JSON body that is edited is
+// written back compactly, with its members in their original order.
+func ApplyEdits(edits []BodyEdit, body []byte) ([]byte, bool) {
+	changed := false
+	for _, e := range edits {
+		var out []byte
+		var ok bool
+		if e.Regex != "" {
+			out, ok = e.replace(body)
+		} else {
+			out, ok = e.editJSON(body)
+		}
+		if ok {
+			body, changed = out, true
+		}
+	}
+	return body, changed
+}
+
+func (e BodyEdit) replace(body []byte) ([]byte, bool) {
+	if !utf8.Valid(body) {
+		return nil, false
+	}
+	re := compiled(e.Regex)
+	if !re.Match(body) {
+		return nil, false
+	}
+	out := re.ReplaceAll(body, []byte(e.Replace))
+	return out, !bytes.Equal(out, body)
+}
+
+func (e BodyEdit) editJSON(body []byte) ([]byte, bool) {
+	p, err := ParseJSONPath(e.JSONPath)
+	if err != nil {
+		return nil, false
+	}
+	root, err := decodeJSON(body)
+	if err != nil {
+		return nil, false
+	}
+
+	value := func() any {
+		data, _ := json.Marshal(e.Set)
+		v, _ := decodeJSON(data)
+		return v
+	}
+	switch {
+	case len(p) == 0:
+		root = value()
+	case e.Delete:
+		if p.remove(root) == 0 {
+			return nil, false
+		}
+	default:
+		if p.set(root, value) == 0 {
+			return nil, false
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := encodeJSON(&buf, root); err != nil {
+		return nil, false
+	}
+	return buf.Bytes(), true
+}
@@ -0,0 +1,568 @@
+// Package rules matches proxied requests against user-defined rules and
+// describes the actions to take on them.
+package rules
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/standrze/rogue/internal/graphql"
+	"github.com/standrze/rogue/internal/jwt"
+	"go.yaml.in/yaml/v3"
+)
+
+// Match selects the requests a rule applies to. Empty fields match
+// everything.
+type Match struct {
+	// Methods lists HTTP methods, case-insensitively.
+	Methods []string `json:"methods,omitempty" yaml:"methods,omitempty"`
+	// Host is a glob matched against the request host without port.
+	Host string `json:"host,omitempty" yaml:"host,omitempty"`
+	// Path is a glob matched against the URL path. OpenAPI-style
+	// parameters such as /pets/{id} match a single segment.
+	Path string `json:"path,omitempty" yaml:"path,omitempty"`
+	// Clients lists client IPs and CIDR prefixes. Behind a load balancer
+	// speaking the PROXY protocol, this is the real client.
+	Clients []string `json:"clients,omitempty" yaml:"clients,omitempty"`
+	// Hosts lists further host globs; a request matches if its host
+	// matches Host or any of them.
+	Hosts []string `json:"hosts,omitempty" yaml:"hosts,omitempty"`
+	// URL is a glob matched against the full URL, where * matches any
+	// run of characters, slashes included.
+	URL string `json:"url,omitempty" yaml:"url,omitempty"`
+	// URLRegex is a regular expression searched for in the full URL.
+	URLRegex string `json:"url_regex,omitempty" yaml:"url_regex,omitempty"`
+	// Operation is a glob matched against the operation names of a
+	// GraphQL request; a batch matches if any of its operations does.
+	Operation string `json:"operation,omitempty" yaml:"operation,omitempty"`
+}
+
+// Mock answers a request without contacting the origin.
+type Mock struct {
+	Status  int               `json:"status,omitempty" yaml:"status,omitempty"`
+	Headers map[string]string `json:"headers,omitempty" yaml:"headers,omitempty"`
+	Body    string            `json:"body,omitempty" yaml:"body,omitempty"`
+}
+
+// Block refuses a request: it is answered with Status (403 by default)
+// and Body, or with Reset the client connection is closed without a
+// response.
+type Block struct {
+	Status int    `json:"status,omitempty" yaml:"status,omitempty"`
+	Body   string `json:"body,omitempty" yaml:"body,omitempty"`
+	Reset  bool   `json:"reset,omitempty" yaml:"reset,omitempty"`
+}
+
+// Fault disturbs matching exchanges to test how clients cope. Each request
+// is faulted with chance Probability (every request when it is 0), and the
+// parts that are set apply together.
+type Fault struct {
+	Probability float64 `json:"probability,omitempty" yaml:"probability,omitempty"`
+	// DelayMS holds the request back before it is forwarded.
+	DelayMS int `json:"delay_ms,omitempty" yaml:"delay_ms,omitempty"`
+	// Status answers with this status instead of contacting the origin.
+	Status int `json:"status,omitempty" yaml:"status,omitempty"`
+	// Truncate cuts the response body to this many bytes, adjusting its
+	// length so the response itself stays well formed.
+	Truncate int `json:"truncate,omitempty" yaml:"truncate,omitempty"`
+	// Drop closes the connection after DropAfter bytes of the body have
+	// been sent, leaving the response incomplete.
+	Drop      bool `json:"drop,omitempty" yaml:"drop,omitempty"`
+	DropAfter int  `json:"drop_after,omitempty" yaml:"drop_after,omitempty"`
+	// CorruptHeaders scrambles the values of these response headers; "*"
+	// scrambles all of them except those framing the body.
+	CorruptHeaders []string `json:"corrupt_headers,omitempty" yaml:"corrupt_headers,omitempty"`
+}
+
+// CookieOps edits the cookies of matching requests. Drop removes cookies
+// whose names match its globs, Set rewrites values (adding cookies that
+// were not sent), and Freeze pins each named cookie to the first value
+// sent, so later requests carry it even after the server rotates it.
+type CookieOps struct {
+	Drop   []string          `json:"drop,omitempty" yaml:"drop,omitempty"`
+	Set    map[string]string `json:"set,omitempty" yaml:"set,omitempty"`
+	Freeze []string          `json:"freeze,omitempty" yaml:"freeze,omitempty"`
+}
+
+// MapRemote sends matching requests to another origin, such as a local
+// backend, keeping their path and query. Empty fields keep the request's
+// own scheme, host and port.
+type MapRemote struct {
+	Scheme string `json:"scheme,omitempty" yaml:"scheme,omitempty"`
+	Host   string `json:"host,omitempty" yaml:"host,omitempty"`
+	Port   int    `json:"port,omitempty" yaml:"port,omitempty"`
+	// PreserveHost sends the original Host header rather than the new
+	// origin's.
+	PreserveHost bool `json:"preserve_host,omitempty" yaml:"preserve_host,omitempty"`
+}
+
+// Apply points u at the new origin.
+func (m MapRemote) Apply(u *url.URL) {
+	if m.Scheme != "" {
+		u.Scheme = m.Scheme
+	}
+	host, port := u.Hostname(), u.Port()
+	if m.Host != "" {
+		host = m.Host
+		// A new scheme without a port uses its default one.
+		if m.Scheme != "" {
+			port = ""
+		}
+	}
+	if m.Port != 0 {
+		port = strconv.Itoa(m.Port)
+	}
+	if port != "" {
+		u.Host = net.JoinHostPort(host, port)
+	} else if strings.Contains(host, ":") {
+		u.Host = "[" + host + "]"
+	} else {
+		u.Host = host
+	}
+}
+
+func (m MapRemote) validate() error {
+	switch {
+	case m.Scheme != "" && m.Scheme != "http" && m.Scheme != "https":
+		return fmt.Errorf("map_remote scheme %q is not http or https", m.Scheme)
+	case m.Port < 0 || m.Port > 65535:
+		return fmt.Errorf("invalid map_remote port %d", m.Port)
+	case m.Scheme == "" && m.Host == "" && m.Port == 0:
+		return errors.New("map_remote needs a scheme, host or port")
+	case strings.ContainsAny(m.Host, "/?#@"):
+		return fmt.Errorf("map_remote host %q must be a bare host name or IP", m.Host)
+	}
+	return nil
+}
+
+// JWT re-signs the JWT of matching requests after editing it, to test how
+// servers check tokens. The token is taken from Cookie or, when Cookie is
+// empty, from the Authorization header. Header and Claims members replace
+// those of the token, and null values remove them. Alg replaces the
+// algorithm; the token is signed with Key, an HMAC secret, or with the PEM
+// private key in KeyFile for RSA and ECDSA algorithms.
+type JWT struct {
+	Cookie  string         `json:"cookie,omitempty" yaml:"cookie,omitempty"`
+	Alg     string         `json:"alg,omitempty" yaml:"alg,omitempty"`
+	Header  map[string]any `json:"header,omitempty" yaml:"header,omitempty"`
+	Claims  map[string]any `json:"claims,omitempty" yaml:"claims,omitempty"`
+	Key     string         `json:"key,omitempty" yaml:"key,omitempty"`
+	KeyFile string         `json:"key_file,omitempty" yaml:"key_file,omitempty"`
+}
+
+// SigningKey returns the key the token is signed with.
+func (j JWT) SigningKey() ([]byte, error) {
+	if j.KeyFile != "" {
+		return os.ReadFile(j.KeyFile)
+	}
+	return []byte(j.Key), nil
+}
+
+func (j JWT) validate() error {
+	if j.Key != "" && j.KeyFile != "" {
+		return errors.New("jwt needs either key or key_file, not both")
+	}
+	if j.Alg != "" && !slices.Contains(jwt.Algorithms, j.Alg) {
+		return fmt.Errorf("unsupported jwt alg %q (have %s)", j.Alg, strings.Join(jwt.Algorithms, ", "))
+	}
+	if j.Alg != "" && j.Alg != "none" && j.Key == "" && j.KeyFile == "" {
+		return fmt.Errorf("jwt alg %s needs a key or key_file", j.Alg)
+	}
+	if j.KeyFile != "" {
+		if _, err := os.Stat(j.KeyFile); err != nil {
+			return fmt.Errorf("jwt key_file: %w", err)
+		}
+	}
+	return nil
+}
+
+// Rule pairs a match with the action to apply. A rule without an action
+// lets matching requests through untouched.
+type Rule struct {
+	Name     string `json:"name" yaml:"name"`
+	Disabled bool   `json:"disabled,omitempty" yaml:"disabled,omitempty"`
+	Match    Match  `json:"match" yaml:"match"`
+	Mock     *Mock  `json:"mock,omitempty" yaml:"mock,omitempty"`
+	Block    *Block `json:"block,omitempty" yaml:"block,omitempty"`
+	Fault    *Fault `json:"fault,omitempty" yaml:"fault,omitempty"`
+	// Headers names header profiles applied to matching exchanges.
+	Headers []string `json:"headers,omitempty" yaml:"headers,omitempty"`
+	// Cookies edits the cookies of matching requests.
+	Cookies *CookieOps `json:"cookies,omitempty" yaml:"cookies,omitempty"`
+	// Rewrite edits the bodies of matching exchanges.
+	Rewrite *Rewrite `json:"rewrite,omitempty" yaml:"rewrite,omitempty"`
+	// MapRemote sends matching requests to another origin.
+	MapRemote *MapRemote `json:"map_remote,omitempty" yaml:"map_remote,omitempty"`
+	// JWT re-signs the token of matching requests.
+	JWT *JWT `json:"jwt,omitempty" yaml:"jwt,omitempty"`
+	// Session refreshes the token of matching requests when it expires.
+	Session *Session `json:"session,omitempty" yaml:"session,omitempty"`
+	// Tags and Note are recorded in the session as an annotation on each
+	// matching exchange.
+	Tags []string `json:"tags,omitempty" yaml:"tags,omitempty"`
+	Note string   `json:"note,omitempty" yaml:"note,omitempty"`
+}
+
+// Validate reports rules that can never match or have conflicting actions.
+func (r Rule) Validate() error {
+	actions := 0
+	for _, set := range []bool{r.Mock != nil, r.Block != nil, r.Fault != nil} {
+		if set {
+			actions++
+		}
+	}
+	if actions > 1 {
+		return errors.New("only one of mock, block and fault can be set")
+	}
+	if r.MapRemote != nil {
+		if r.Mock != nil || r.Block != nil {
+			return errors.New("map_remote cannot be combined with mock or block")
+		}
+		if err := r.MapRemote.validate(); err != nil {
+			return err
+		}
+	}
+	if r.Rewrite != nil {
+		if r.Mock != nil || r.Block != nil {
+			return errors.New("rewrite cannot be combined with mock or block")
+		}
+		if err := r.Rewrite.validate(); err != nil {
+			return err
+		}
+	}
+	if r.Block != nil && r.Block.Status != 0 && http.StatusText(r.Block.Status) == "" {
+		return fmt.Errorf("invalid block status %d", r.Block.Status)
+	}
+	if f := r.Fault; f != nil {
+		switch {
+		case f.Probability < 0 || f.Probability > 1:
+			return fmt.Errorf("fault probability %g is not between 0 and 1", f.Probability)
+		case f.Status != 0 && http.StatusText(f.Status) == "":
+			return fmt.Errorf("invalid fault status %d", f.Status)
+		case f.DelayMS < 0 || f.Truncate < 0 || f.DropAfter < 0:
+			return errors.New("fault delay_ms, truncate and drop_after cannot be negative")
+		}
+	}
+	for _, tag := range r.Tags {
+		if strings.TrimSpace(tag) == "" {
+			return errors.New("tags cannot be empty")
+		}
+	}
+	if r.JWT != nil {
+		if r.Mock != nil || r.Block != nil {
+			return errors.New("jwt cannot be combined with mock or block")
+		}
+		if err := r.JWT.validate(); err != nil {
+			return err
+		}
+	}
+	if r.Session != nil {
+		if r.Mock != nil || r.Block != nil {
+			return errors.New("session cannot be combined with mock or block")
+		}
+		if err := r.Session.validate(); err != nil {
+			return err
+		}
+	}
+	if r.Cookies != nil {
+		for _, pattern := range r.Cookies.Drop {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid cookie pattern %q", pattern)
+			}
+		}
+	}
+	return r.Match.Validate()
+}
+
+var templateParam = regexp.MustCompile(`\{[^/{}]+\}`)
+
+// Matches reports whether req is selected by m.
+func (m Match) Matches(req *http.Request) bool {
+	if len(m.Methods) > 0 {
+		found := false
+		for _, method := range m.Methods {
+			if strings.EqualFold(method, req.Method) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if m.Host != "" || len(m.Hosts) > 0 {
+		host := req.URL.Host
+		if host == "" {
+			host = req.Host
+		}
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if !matchHost(m.Host, m.Hosts, strings.ToLower(host)) {
+			return false
+		}
+	}
+
+	if m.Path != "" {
+		pattern := templateParam.ReplaceAllString(m.Path, "*")
+		if ok, _ := path.Match(pattern, req.URL.Path); !ok {
+			return false
+		}
+	}
+
+	if len(m.Clients) > 0 && !matchClient(m.Clients, req.RemoteAddr) {
+		return false
+	}
+
+	if m.URL != "" || m.URLRegex != "" {
+		u := req.URL.String()
+		if m.URL != "" && !compiled(globPattern(m.URL)).MatchString(u) {
+			return false
+		}
+		if m.URLRegex != "" && !compiled(m.URLRegex).MatchString(u) {
+			return false
+		}
+	}
+
+	if m.Operation != "" && !slices.ContainsFunc(graphql.Operations(req), func(op graphql.Operation) bool {
+		ok, _ := path.Match(m.Operation, op.Name)
+		return ok
+	}) {
+		return false
+	}
+
+	return true
+}
+
+func matchHost(host string, hosts []string, reqHost string) bool {
+	if ok, _ := path.Match(strings.ToLower(host), reqHost); ok && host != "" {
+		return true
+	}
+	for _, h := range hosts {
+		if ok, _ := path.Match(strings.ToLower(h), reqHost); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// globPattern turns a URL glob into an anchored regular expression.
+func globPattern(glob string) string {
+	var b strings.Builder
+	b.WriteString("(?i)^")
+	for _, r := range glob {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return b.String()
+}
+
+// patterns caches compiled URL patterns, which are checked on every
+// request.
+var patterns sync.Map
+
+// compiled returns the compiled form of a pattern that passed Validate.
+// Invalid patterns never match.
+func compiled(pattern string) *regexp.Regexp {
+	if re, ok := patterns.Load(pattern); ok {
+		return re.(*regexp.Regexp)
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		re = regexp.MustCompile(`[^\s\S]`)
+	}
+	patterns.Store(pattern, re)
+	return re
+}
+
+// Validate reports values of m that can never match.
+func (m Match) Validate() error {
+	if _, err := path.Match(m.Operation, ""); err != nil {
+		return fmt.Errorf("invalid operation pattern %q", m.Operation)
+	}
+	if m.URLRegex != "" {
+		if _, err := regexp.Compile(m.URLRegex); err != nil {
+			return fmt.Errorf("invalid url_regex: %w", err)
+		}
+	}
+	for _, c := range m.Clients {
+		if _, err := netip.ParsePrefix(c); err == nil {
+			continue
+		}
+		if _, err := netip.ParseAddr(c); err != nil {
+			return fmt.Errorf("invalid client %q (want an IP or CIDR prefix)", c)
+		}
+	}
+	return nil
+}
+
+func matchClient(clients []string, remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	ip = ip.Unmap()
+	for _, c := range clients {
+		if p, err := netip.ParsePrefix(c); err == nil {
+			if p.Contains(ip) {
+				return true
+			}
+		} else if a, err := netip.ParseAddr(c); err == nil && a.Unmap() == ip {
+			return true
+		}
+	}
+	return false
+}
+
+// Set is an ordered list of rules; the first enabled match wins. It is safe
+// for concurrent use.
+type Set struct {
+	mu    sync.RWMutex
+	rules []Rule
+}
+
+// NewSet returns a set holding rules.
+func NewSet(rules ...Rule) *Set {
+	return &Set{rules: rules}
+}
+
+// Add appends rules to the set.
+func (s *Set) Add(rules ...Rule) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rules = append(s.rules, rules...)
+}
+
+// Update replaces the first rule named name with r, reporting whether
+// there was one.
+func (s *Set) Update(name string, r Rule) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.rules {
+		if s.rules[i].Name == name {
+			s.rules[i] = r
+			return true
+		}
+	}
+	return false
+}
+
+// Delete removes the first rule named name, reporting whether there was
+// one.
+func (s *Set) Delete(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.rules {
+		if s.rules[i].Name == name {
+			s.rules = slices.Delete(s.rules, i, i+1)
+			return true
+		}
+	}
+	return false
+}
+
+// Rules returns a copy of the rules in order.
+func (s *Set) Rules() []Rule {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]Rule(nil), s.rules...)
+}
+
+// Match returns the first enabled rule matching req.
+func (s *Set) Match(req *http.Request) (Rule, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, r := range s.rules {
+		if !r.Disabled && r.Match.Matches(req) {
+			return r, true
+		}
+	}
+	return Rule{}, false
+}
+
+// file is the on-disk layout of a rules file.
+type file struct {
+	Rules []Rule `json:"rules" yaml:"rules"`
+}
+
+// Load reads rules from a YAML or JSON file, chosen by extension.
+func Load(name string) ([]Rule, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var f file
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".json":
+		d := json.NewDecoder(bytes.NewReader(data))
+		d.DisallowUnknownFields()
+		err = d.Decode(&f)
+	default:
+		d := yaml.NewDecoder(bytes.NewReader(data))
+		d.KnownFields(true)
+		err = d.Decode(&f)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	for _, r := range f.Rules {
+		if err := r.Validate(); err != nil {
+			return nil, fmt.Errorf("%s: rule %q: %w", name, r.Name, err)
+		}
+	}
+	return f.Rules, nil
+}
+
+// Save writes rules to name as YAML, or JSON for a .json extension.
+func Save(name string, rules []Rule) error {
+	var data []byte
+	var err error
+	if strings.ToLower(filepath.Ext(name)) == ".json" {
+		data, err = json.MarshalIndent(file{Rules: rules}, "", "  ")
+	} else {
+		data, err = yaml.Marshal(file{Rules: rules})
+	}
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(name, data, 0644)
+}
+
+// Append adds rules to the end of the rules file name, creating it if it
+// does not exist. The file is rewritten, so comments are not kept.
+func Append(name string, rules ...Rule) error {
+	existing, err := Load(name)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return Save(name, append(existing, rules...))
+}
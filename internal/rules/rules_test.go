@@ -0,0 +1,383 @@
+package rules
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	m := Match{Methods: []string{"get"}, Host: "*.example.com", Path: "/users/{id}/posts"}
+
+	tests := []struct {
+		method, url string
+		want        bool
+	}{
+		{"GET", "http://api.example.com/users/42/posts", true},
+		{"GET", "http://api.example.com:8443/users/42/posts", true},
+		{"POST", "http://api.example.com/users/42/posts", false},
+		{"GET", "http://example.org/users/42/posts", false},
+		{"GET", "http://api.example.com/users/42/7/posts", false},
+	}
+	for _, tt := range tests {
+		if got := m.Matches(httptest.NewRequest(tt.method, tt.url, nil)); got != tt.want {
+			t.Errorf("%s %s: got %v, want %v", tt.method, tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestMatchClients(t *testing.T) {
+	m := Match{Clients: []string{"10.0.0.0/8", "2001:db8::1"}}
+	for addr, want := range map[string]bool{
+		"10.1.2.3:5000":        true,
+		"[::ffff:10.1.2.3]:80": true,
+		"[2001:db8::1]:443":    true,
+		"192.0.2.1:5000":       false,
+		"@":                    false,
+	} {
+		req := httptest.NewRequest("GET", "http://x/", nil)
+		req.RemoteAddr = addr
+		if got := m.Matches(req); got != want {
+			t.Errorf("%s: got %v, want %v", addr, got, want)
+		}
+	}
+	if err := (Match{Clients: []string{"10.0.0.0/33"}}).Validate(); err == nil {
+		t.Error("expected an invalid prefix to be rejected")
+	}
+}
+
+func TestMatchURLs(t *testing.T) {
+	tests := []struct {
+		name string
+		m    Match
+		url  string
+		want bool
+	}{
+		{"hosts", Match{Hosts: []string{"ads.example", "*.doubleclick.net"}}, "http://stats.g.doubleclick.net/p", true},
+		{"hosts miss", Match{Hosts: []string{"ads.example"}}, "http://example.com/", false},
+		{"url glob", Match{URL: "https://*.example.com/v1/telemetry*"}, "https://api.example.com/v1/telemetry/batch?x=1", true},
+		{"url glob anchored", Match{URL: "https://example.com/track"}, "https://example.com/track/more", false},
+		{"url regex", Match{URLRegex: `/(collect|beacon)\b`}, "https://example.com/g/collect?v=2", true},
+		{"url regex miss", Match{URLRegex: `/beacon$`}, "https://example.com/beacons", false},
+	}
+	for _, tt := range tests {
+		if got := tt.m.Matches(httptest.NewRequest("GET", tt.url, nil)); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMatchOperation(t *testing.T) {
+	m := Match{Path: "/graphql", Operation: "Track*"}
+	for body, want := range map[string]bool{
+		`{"query":"mutation TrackView($id: ID!) { track(id: $id) }"}`:                     true,
+		`{"query":"query GetUser { me { id } }"}`:                                         false,
+		`[{"query":"query GetUser { me { id } }"},{"query":"mutation TrackClick { t }"}]`: true,
+		`{"query":"{ me { id } }"}`:                                                       false,
+	} {
+		req := httptest.NewRequest("POST", "https://api.example.com/graphql", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if got := m.Matches(req); got != want {
+			t.Errorf("%s: got %v, want %v", body, got, want)
+		}
+	}
+}
+
+func TestRuleValidate(t *testing.T) {
+	tests := []struct {
+		rule Rule
+		ok   bool
+	}{
+		{Rule{Name: "block", Block: &Block{Status: 404}}, true},
+		{Rule{Name: "reset", Block: &Block{Reset: true}}, true},
+		{Rule{Name: "both", Mock: &Mock{}, Block: &Block{}}, false},
+		{Rule{Name: "status", Block: &Block{Status: 999}}, false},
+		{Rule{Name: "regex", Match: Match{URLRegex: "("}, Block: &Block{}}, false},
+		{Rule{Name: "operation", Match: Match{Operation: "Get["}, Block: &Block{}}, false},
+		{Rule{Name: "fault", Fault: &Fault{Probability: 0.2, Status: 503}}, true},
+		{Rule{Name: "probability", Fault: &Fault{Probability: 1.5}}, false},
+		{Rule{Name: "block and fault", Block: &Block{}, Fault: &Fault{}}, false},
+		{Rule{Name: "cookies", Cookies: &CookieOps{Drop: []string{"["}}}, false},
+		{Rule{Name: "rewrite", Rewrite: &Rewrite{Response: []BodyEdit{{Regex: "a+", Replace: "b"}, {JSONPath: "$.x", Delete: true}}}}, true},
+		{Rule{Name: "rewrite regex", Rewrite: &Rewrite{Request: []BodyEdit{{Regex: "("}}}}, false},
+		{Rule{Name: "rewrite path", Rewrite: &Rewrite{Response: []BodyEdit{{JSONPath: "items[0]", Set: 1}}}}, false},
+		{Rule{Name: "rewrite no op", Rewrite: &Rewrite{Response: []BodyEdit{{JSONPath: "$.a"}}}}, false},
+		{Rule{Name: "rewrite mock", Mock: &Mock{}, Rewrite: &Rewrite{}}, false},
+		{Rule{Name: "map remote", MapRemote: &MapRemote{Scheme: "http", Host: "localhost", Port: 3000}}, true},
+		{Rule{Name: "map remote empty", MapRemote: &MapRemote{}}, false},
+		{Rule{Name: "map remote scheme", MapRemote: &MapRemote{Scheme: "ftp"}}, false},
+		{Rule{Name: "map remote url", MapRemote: &MapRemote{Host: "http://localhost/"}}, false},
+		{Rule{Name: "jwt", JWT: &JWT{Claims: map[string]any{"role": "admin"}, Key: "secret"}}, true},
+		{Rule{Name: "jwt none", JWT: &JWT{Alg: "none"}}, true},
+		{Rule{Name: "jwt alg", JWT: &JWT{Alg: "HS999", Key: "secret"}}, false},
+		{Rule{Name: "jwt no key", JWT: &JWT{Alg: "RS256"}}, false},
+		{Rule{Name: "jwt key file", JWT: &JWT{Alg: "RS256", KeyFile: "testdata/missing.pem"}}, false},
+		{Rule{Name: "jwt mock", Mock: &Mock{}, JWT: &JWT{}}, false},
+		{Rule{Name: "session", Session: &Session{Refresh: Refresh{URL: "https://auth.test/token"}, Token: TokenSource{JSONPath: "$.token"}, Inject: TokenInject{Header: "Authorization"}}}, true},
+		{Rule{Name: "session url", Session: &Session{Refresh: Refresh{URL: "/token"}, Token: TokenSource{Header: "X-Token"}, Inject: TokenInject{Cookie: "sid"}}}, false},
+		{Rule{Name: "session token", Session: &Session{Refresh: Refresh{URL: "https://auth.test/"}, Token: TokenSource{Header: "X-Token", Cookie: "sid"}, Inject: TokenInject{Cookie: "sid"}}}, false},
+		{Rule{Name: "session inject", Session: &Session{Refresh: Refresh{URL: "https://auth.test/"}, Token: TokenSource{Cookie: "sid"}}}, false},
+		{Rule{Name: "session status", Session: &Session{Expired: Expired{Statuses: []int{999}}, Refresh: Refresh{URL: "https://auth.test/"}, Token: TokenSource{Cookie: "sid"}, Inject: TokenInject{Cookie: "sid"}}}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.rule.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: got %v, want ok=%v", tt.rule.Name, err, tt.ok)
+		}
+	}
+}
+
+func TestMapRemote(t *testing.T) {
+	tests := []struct {
+		m    MapRemote
+		in   string
+		want string
+	}{
+		{MapRemote{Scheme: "http", Host: "localhost", Port: 3000}, "https://api.prod.com/v1/users?id=1", "http://localhost:3000/v1/users?id=1"},
+		{MapRemote{Host: "staging.example.com"}, "https://api.example.com:8443/x", "https://staging.example.com:8443/x"},
+		{MapRemote{Scheme: "https", Host: "edge.example.com"}, "http://api.example.com:8080/", "https://edge.example.com/"},
+		{MapRemote{Port: 9000}, "http://api.example.com/", "http://api.example.com:9000/"},
+		{MapRemote{Host: "::1", Port: 80}, "http://api.example.com/", "http://[::1]:80/"},
+	}
+	for _, tt := range tests {
+		u, _ := url.Parse(tt.in)
+		tt.m.Apply(u)
+		if u.String() != tt.want {
+			t.Errorf("%+v on %s: got %s, want %s", tt.m, tt.in, u, tt.want)
+		}
+	}
+}
+
+func TestApplyEdits(t *testing.T) {
+	tests := []struct {
+		name  string
+		edits []BodyEdit
+		in    string
+		want  string
+	}{
+		{"regex", []BodyEdit{{Regex: `"price":\s*(\d+)`, Replace: `"price": 0`}}, `{"price": 42}`, `{"price": 0}`},
+		{"groups", []BodyEdit{{Regex: `(\w+)@example\.com`, Replace: "${1}@test.invalid"}}, "mail ann@example.com", "mail ann@test.invalid"},
+		{"set keeps order", []BodyEdit{{JSONPath: "$.b", Set: "<x>"}}, `{"c": 1, "b": 2, "a": 3}`, `{"c":1,"b":"<x>","a":3}`},
+		{"set adds", []BodyEdit{{JSONPath: "$.debug.enabled", Set: true}}, `{"a": 1.50}`, `{"a":1.50,"debug":{"enabled":true}}`},
+		{"set wildcard", []BodyEdit{{JSONPath: "$.items[*].price", Set: 0}}, `{"items": [{"price": 3}, {"price": 4}]}`, `{"items":[{"price":0},{"price":0}]}`},
+		{"set object", []BodyEdit{{JSONPath: "$['user']", Set: map[string]any{"id": 1}}}, `{"user": null}`, `{"user":{"id":1}}`},
+		{"delete member", []BodyEdit{{JSONPath: "$.token", Delete: true}}, `{"token": "s", "ok": true}`, `{"ok":true}`},
+		{"delete element", []BodyEdit{{JSONPath: "$[1]", Delete: true}}, `[1, 2, 3]`, `[1,3]`},
+		{"root", []BodyEdit{{JSONPath: "$", Set: []any{}}}, `{"a": 1}`, `[]`},
+		{"in order", []BodyEdit{{JSONPath: "$.a", Set: "x"}, {Regex: "x", Replace: "y"}}, `{"a": 1}`, `{"a":"y"}`},
+		{"not json", []BodyEdit{{JSONPath: "$.a", Delete: true}}, `a=1`, `a=1`},
+		{"no match", []BodyEdit{{JSONPath: "$.missing", Delete: true}}, `{"a": 1}`, `{"a": 1}`},
+	}
+	for _, tt := range tests {
+		for _, e := range tt.edits {
+			if err := e.validate(); err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+		}
+		got, changed := ApplyEdits(tt.edits, []byte(tt.in))
+		if string(got) != tt.want || changed != (tt.in != tt.want) {
+			t.Errorf("%s: got %s (changed %v), want %s", tt.name, got, changed, tt.want)
+		}
+	}
+}
+
+func TestHeaderOps(t *testing.T) {
+	h := http.Header{}
+	h.Set("X-Tracking-Id", "1")
+	h.Set("X-Tracking-Session", "2")
+	h.Set("Accept", "*/*")
+	h.Set("Authorization", "old")
+
+	HeaderOps{
+		Remove: []string{"x-tracking-*"},
+		Set:    map[string]string{"authorization": "Bearer token"},
+		Add:    map[string]string{"Accept": "application/json"},
+	}.Apply(h)
+
+	if h.Get("X-Tracking-Id") != "" || h.Get("X-Tracking-Session") != "" {
+		t.Errorf("tracking headers kept: %v", h)
+	}
+	if h.Get("Authorization") != "Bearer token" || len(h.Values("Accept")) != 2 {
+		t.Errorf("unexpected headers %v", h)
+	}
+
+	p := Profiles{"strip-tracking": {}}
+	if _, ok := p.Lookup("Strip-Tracking"); !ok {
+		t.Error("profile names should be case-insensitive")
+	}
+	if err := p.Check("strip-tracking", "add-auth-token"); err == nil {
+		t.Error("expected an error for an unknown profile")
+	}
+	if err := (Profiles{"bad": {Request: HeaderOps{Remove: []string{"["}}}}).Validate(); err == nil {
+		t.Error("expected an error for a malformed pattern")
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	want := []Rule{
+		{Name: "off", Disabled: true, Match: Match{Path: "/a"}, Mock: &Mock{Status: 500}},
+		{Name: "teapot", Match: Match{Path: "/a"}, Mock: &Mock{Status: 418, Body: "short and stout"}},
+	}
+
+	for _, name := range []string{"rules.yaml", "rules.json"} {
+		path := filepath.Join(t.TempDir(), name)
+		if err := Save(path, want); err != nil {
+			t.Fatal(err)
+		}
+		got, err := Load(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		set := NewSet(got...)
+		rule, ok := set.Match(httptest.NewRequest("GET", "http://x/a", nil))
+		if !ok || rule.Name != "teapot" || rule.Mock.Body != "short and stout" {
+			t.Errorf("%s: disabled rules must be skipped, matched %+v", name, rule)
+		}
+	}
+}
+
+func TestBuildFromExchange(t *testing.T) {
+	ex := Exchange{
+		Method:  "GET",
+		URL:     "https://api.example.com/users/42?full=1",
+		Status:  200,
+		Headers: map[string]string{"Content-Type": "application/json", "Content-Length": "9", "Date": "today"},
+		Body:    `{"id":42}`,
+	}
+
+	m, err := MatchExchange(ex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Host != "api.example.com" || m.Path != "/users/42" || m.Methods[0] != "GET" {
+		t.Errorf("match = %+v", m)
+	}
+
+	mock, faithful := ReplayMock(ex)
+	if !faithful || mock.Status != 200 || mock.Body != ex.Body || len(mock.Headers) != 1 {
+		t.Errorf("mock = %+v, faithful %v", mock, faithful)
+	}
+	ex.Headers["Content-Encoding"] = "gzip"
+	if _, faithful := ReplayMock(ex); faithful {
+		t.Error("an encoded body must not be reported as faithful")
+	}
+
+	req, err := ex.Request()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Path = "/users/{id}"
+	rule := Rule{Name: "user", Match: m, Mock: mock}
+	if p := NewSet().Preview(rule, req); !p.Matches || p.Shadowed != "" {
+		t.Errorf("preview = %+v", p)
+	}
+	set := NewSet(Rule{Name: "everything", Match: Match{Host: "*.example.com"}})
+	if p := set.Preview(rule, req); p.Shadowed != "everything" {
+		t.Errorf("preview = %+v, want shadowed", p)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	set := NewSet()
+	h := &Handler{Set: set}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/rules", strings.NewReader(`{"name":"teapot","match":{"path":"/a"},"mock":{"status":418}}`)))
+	if w.Code != 201 {
+		t.Fatalf("POST: %d %s", w.Code, w.Body)
+	}
+	if _, ok := set.Match(httptest.NewRequest("GET", "http://x/a", nil)); !ok {
+		t.Error("posted rule is not live")
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/rules", strings.NewReader(`{"name":"x","bogus":1}`)))
+	if w.Code != 400 {
+		t.Errorf("unknown field: %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/rules", nil))
+	if !strings.Contains(w.Body.String(), `"teapot"`) {
+		t.Errorf("GET: %s", w.Body)
+	}
+}
+
+func TestAppend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	for _, name := range []string{"a", "b"} {
+		if err := Append(path, Rule{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := Load(path)
+	if err != nil || len(got) != 2 || got[1].Name != "b" {
+		t.Errorf("got %+v, %v", got, err)
+	}
+}
+
+func TestSessionToken(t *testing.T) {
+	res := &http.Response{Header: http.Header{"X-Token": {"h1"}, "Set-Cookie": {"sid=c1; Path=/"}}}
+	body := []byte(`{"data":{"token":"j1","expires":3600}}`)
+	for src, want := range map[TokenSource]string{
+		{JSONPath: "$.data.token"}:   "j1",
+		{JSONPath: "$.data.expires"}: "3600",
+		{Regex: `"token":"([^"]+)"`}: "j1",
+		{Header: "X-Token"}:          "h1",
+		{Cookie: "sid"}:              "c1",
+	} {
+		if got, ok := src.Extract(res, body); !ok || got != want {
+			t.Errorf("%+v: got %q, %v, want %q", src, got, ok, want)
+		}
+	}
+	if _, ok := (TokenSource{JSONPath: "$.missing"}).Extract(res, body); ok {
+		t.Error("found a missing member")
+	}
+
+	h := http.Header{"Cookie": {"a=1; sid=old"}}
+	TokenInject{Cookie: "sid"}.Apply(h, "new")
+	TokenInject{Header: "Authorization"}.Apply(h, "t")
+	TokenInject{Header: "X-Api-Key", Format: "key={token}"}.Apply(h, "k")
+	if h.Get("Cookie") != "a=1; sid=new" || h.Get("Authorization") != "Bearer t" || h.Get("X-Api-Key") != "key=k" {
+		t.Errorf("Apply left %v", h)
+	}
+
+	e := Expired{Statuses: []int{401, 403}, BodyRegex: "expired"}
+	if !e.Matches(403, []byte("session expired")) || e.Matches(403, []byte("forbidden")) || e.Matches(500, []byte("expired")) {
+		t.Error("Expired matched the wrong responses")
+	}
+}
+
+func TestRuleExpand(t *testing.T) {
+	vars := map[string]string{"api": "api.staging.test", "token": `t"1`}
+	expand := func(s string) (string, error) {
+		for k, v := range vars {
+			s = strings.ReplaceAll(s, "{{"+k+"}}", v)
+		}
+		return s, nil
+	}
+	r := Rule{
+		Name:  "mock",
+		Match: Match{Host: "{{api}}"},
+		Mock:  &Mock{Status: 200, Headers: map[string]string{"X-Token": "{{token}}"}, Body: `{"token":"{{token}}"}`},
+		Tags:  []string{"env"},
+	}
+	got, err := r.Expand(expand)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Match.Host != "api.staging.test" || got.Mock.Headers["X-Token"] != `t"1` || got.Mock.Body != `{"token":"t"1"}` || got.Mock.Status != 200 {
+		t.Errorf("Expand = %+v %+v", got.Match, got.Mock)
+	}
+	if r.Mock.Body != `{"token":"{{token}}"}` {
+		t.Error("Expand changed the original rule")
+	}
+
+	r = Rule{Name: "remote", MapRemote: &MapRemote{Host: "{{api}}/x"}}
+	if _, err := r.Expand(expand); err == nil {
+		t.Error("an expanded rule that is invalid passed")
+	}
+}
@@ -0,0 +1,195 @@
+package rules
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Session keeps the requests of a rule authenticated. When a response shows
+// the session has expired, the Refresh request is sent, a new token is read
+// from its response as Token says, and the request is retried once with the
+// token put where Inject says. Later matching requests carry the new token
+// from the start.
+type Session struct {
+	Expired Expired     `json:"expired,omitempty" yaml:"expired,omitempty"`
+	Refresh Refresh     `json:"refresh" yaml:"refresh"`
+	Token   TokenSource `json:"token" yaml:"token"`
+	Inject  TokenInject `json:"inject" yaml:"inject"`
+}
+
+// Expired recognises responses to requests sent with an expired session:
+// those with one of Statuses (401 when empty) whose body, if BodyRegex is
+// set, matches it.
+type Expired struct {
+	Statuses  []int  `json:"statuses,omitempty" yaml:"statuses,omitempty"`
+	BodyRegex string `json:"body_regex,omitempty" yaml:"body_regex,omitempty"`
+}
+
+// NeedsBody reports whether Matches looks at the body.
+func (e Expired) NeedsBody() bool {
+	return e.BodyRegex != ""
+}
+
+// MatchesStatus reports whether a response with status may show an
+// expired session, before its body is looked at.
+func (e Expired) MatchesStatus(status int) bool {
+	if len(e.Statuses) == 0 {
+		return status == http.StatusUnauthorized
+	}
+	return slices.Contains(e.Statuses, status)
+}
+
+// Matches reports whether a response with status and body shows an expired
+// session.
+func (e Expired) Matches(status int, body []byte) bool {
+	return e.MatchesStatus(status) && (e.BodyRegex == "" || compiled(e.BodyRegex).Match(body))
+}
+
+// Refresh is the request that obtains a new token, such as a login or an
+// OAuth refresh_token grant. Method defaults to POST with a body and GET
+// without.
+type Refresh struct {
+	Method  string            `json:"method,omitempty" yaml:"method,omitempty"`
+	URL     string            `json:"url" yaml:"url"`
+	Headers map[string]string `json:"headers,omitempty" yaml:"headers,omitempty"`
+	Body    string            `json:"body,omitempty" yaml:"body,omitempty"`
+}
+
+// Request builds the refresh request.
+func (r Refresh) Request() (*http.Request, error) {
+	method := r.Method
+	if method == "" {
+		method = http.MethodGet
+		if r.Body != "" {
+			method = http.MethodPost
+		}
+	}
+	req, err := http.NewRequest(method, r.URL, strings.NewReader(r.Body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range r.Headers {
+		req.Header.Set(k, v)
+	}
+	return req, nil
+}
+
+// TokenSource says where the refresh response holds the token: at JSONPath
+// in a JSON body, in the first group of Regex (or its whole match) in the
+// body, in a response Header, or in a Cookie it sets. Exactly one is set.
+type TokenSource struct {
+	JSONPath string `json:"jsonpath,omitempty" yaml:"jsonpath,omitempty"`
+	Regex    string `json:"regex,omitempty" yaml:"regex,omitempty"`
+	Header   string `json:"header,omitempty" yaml:"header,omitempty"`
+	Cookie   string `json:"cookie,omitempty" yaml:"cookie,omitempty"`
+}
+
+// Extract reads the token from a refresh response and its body.
+func (s TokenSource) Extract(res *http.Response, body []byte) (string, bool) {
+	switch {
+	case s.JSONPath != "":
+		p, err := ParseJSONPath(s.JSONPath)
+		if err != nil {
+			return "", false
+		}
+		return p.Lookup(body)
+	case s.Regex != "":
+		m := compiled(s.Regex).FindSubmatch(body)
+		if m == nil {
+			return "", false
+		}
+		return string(m[len(m)-1]), true
+	case s.Header != "":
+		v := res.Header.Get(s.Header)
+		return v, v != ""
+	case s.Cookie != "":
+		for _, c := range res.Cookies() {
+			if c.Name == s.Cookie && c.Value != "" {
+				return c.Value, true
+			}
+		}
+	}
+	return "", false
+}
+
+// TokenInject says where requests carry the token: in Header, as Format
+// with {token} standing for the token ("Bearer {token}" for Authorization
+// and "{token}" otherwise when empty), or in Cookie.
+type TokenInject struct {
+	Header string `json:"header,omitempty" yaml:"header,omitempty"`
+	Format string `json:"format,omitempty" yaml:"format,omitempty"`
+	Cookie string `json:"cookie,omitempty" yaml:"cookie,omitempty"`
+}
+
+// Apply puts token into h, replacing any value it held.
+func (i TokenInject) Apply(h http.Header, token string) {
+	if i.Header != "" {
+		format := i.Format
+		if format == "" {
+			format = "{token}"
+			if strings.EqualFold(i.Header, "Authorization") {
+				format = "Bearer {token}"
+			}
+		}
+		h.Set(i.Header, strings.ReplaceAll(format, "{token}", token))
+		return
+	}
+	var out []string
+	for _, line := range h.Values("Cookie") {
+		cs, _ := http.ParseCookie(line)
+		for _, c := range cs {
+			if c.Name != i.Cookie {
+				out = append(out, c.Name+"="+c.Value)
+			}
+		}
+	}
+	h.Set("Cookie", strings.Join(append(out, i.Cookie+"="+token), "; "))
+}
+
+func (s Session) validate() error {
+	for _, status := range s.Expired.Statuses {
+		if http.StatusText(status) == "" {
+			return fmt.Errorf("invalid session expired status %d", status)
+		}
+	}
+	if s.Expired.BodyRegex != "" {
+		if _, err := regexp.Compile(s.Expired.BodyRegex); err != nil {
+			return fmt.Errorf("invalid session expired body_regex: %w", err)
+		}
+	}
+	u, err := url.Parse(s.Refresh.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("session refresh url %q is not an absolute http or https URL", s.Refresh.URL)
+	}
+	sources := 0
+	for _, v := range []string{s.Token.JSONPath, s.Token.Regex, s.Token.Header, s.Token.Cookie} {
+		if v != "" {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return errors.New("session token needs exactly one of jsonpath, regex, header and cookie")
+	}
+	if s.Token.JSONPath != "" {
+		if _, err := ParseJSONPath(s.Token.JSONPath); err != nil {
+			return err
+		}
+	}
+	if s.Token.Regex != "" {
+		if _, err := regexp.Compile(s.Token.Regex); err != nil {
+			return fmt.Errorf("invalid session token regex: %w", err)
+		}
+	}
+	if (s.Inject.Header == "") == (s.Inject.Cookie == "") {
+		return errors.New("session inject needs either header or cookie")
+	}
+	if s.Inject.Cookie != "" && s.Inject.Format != "" {
+		return errors.New("session inject format applies to headers")
+	}
+	return nil
+}
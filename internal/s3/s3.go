@@ -0,0 +1,270 @@
+// Package s3 recognises S3-compatible object store requests and can redact
+// their AWS Signature Version 4 credentials from the session log.
+package s3
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/standrze/rogue/internal/logger"
+)
+
+const redacted = "REDACTED"
+
+// Info is the structured form of one S3 API call.
+type Info struct {
+	Operation   string `json:"operation"`
+	Bucket      string `json:"bucket,omitempty"`
+	Key         string `json:"key,omitempty"`
+	Region      string `json:"region,omitempty"`
+	AccessKeyID string `json:"access_key_id,omitempty"`
+	Presigned   bool   `json:"presigned,omitempty"`
+	// ExpiresIn is the lifetime of a presigned URL in seconds.
+	ExpiresIn  int    `json:"expires_in,omitempty"`
+	UploadID   string `json:"upload_id,omitempty"`
+	PartNumber int    `json:"part_number,omitempty"`
+}
+
+// credential is the scope of a SigV4 signature.
+type credential struct {
+	accessKey string
+	region    string
+	service   string
+}
+
+// parseCredential splits AKID/date/region/service/aws4_request.
+func parseCredential(s string) (credential, bool) {
+	parts := strings.Split(s, "/")
+	if len(parts) != 5 || parts[4] != "aws4_request" {
+		return credential{}, false
+	}
+	return credential{accessKey: parts[0], region: parts[2], service: parts[3]}, true
+}
+
+// requestCredential returns the SigV4 scope from a presigned query string or
+// the Authorization header, and whether the request is presigned.
+func requestCredential(req *http.Request) (cred credential, signed, presigned bool) {
+	q := req.URL.Query()
+	if q.Get("X-Amz-Algorithm") == "AWS4-HMAC-SHA256" {
+		cred, signed = parseCredential(q.Get("X-Amz-Credential"))
+		return cred, signed, true
+	}
+
+	auth, ok := strings.CutPrefix(req.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ")
+	if !ok {
+		return cred, false, false
+	}
+	for _, field := range strings.Split(auth, ",") {
+		if v, ok := strings.CutPrefix(strings.TrimSpace(field), "Credential="); ok {
+			cred, signed = parseCredential(v)
+			return cred, signed, false
+		}
+	}
+	return cred, false, false
+}
+
+// awsEndpoint splits an amazonaws.com S3 host into the virtual-hosted bucket
+// (if any) and the region. It reports false for other hosts.
+func awsEndpoint(host string) (bucket, region string, ok bool) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	rest, ok := strings.CutSuffix(strings.ToLower(host), ".amazonaws.com")
+	if !ok {
+		return "", "", false
+	}
+
+	labels := strings.Split(rest, ".")
+	for i, l := range labels {
+		if l != "s3" && !strings.HasPrefix(l, "s3-") {
+			continue
+		}
+		bucket = strings.Join(labels[:i], ".")
+		// Legacy endpoints put the region in the label (s3-eu-west-1),
+		// current ones after it (s3.eu-west-1, s3.dualstack.eu-west-1).
+		region = strings.TrimPrefix(l, "s3")
+		region = strings.TrimPrefix(region, "-")
+		for _, r := range labels[i+1:] {
+			if r != "dualstack" {
+				region = r
+			}
+		}
+		if region == "external-1" {
+			region = ""
+		}
+		return bucket, region, true
+	}
+	return "", "", false
+}
+
+// Parse recognises req as an S3 call. Requests are accepted when they target
+// an amazonaws.com S3 endpoint or carry a SigV4 signature scoped to the s3
+// service, which covers compatible stores such as MinIO. Outside AWS, buckets
+// are assumed to be addressed path-style.
+func Parse(req *http.Request) (Info, bool) {
+	var info Info
+
+	cred, signed, presigned := requestCredential(req)
+	bucket, region, aws := awsEndpoint(req.Host)
+	if !aws && (!signed || cred.service != "s3") {
+		return info, false
+	}
+
+	q := req.URL.Query()
+	info.Presigned = presigned
+	info.Region = region
+	if signed {
+		info.AccessKeyID = cred.accessKey
+		info.Region = cred.region
+	}
+	if presigned {
+		info.ExpiresIn, _ = strconv.Atoi(q.Get("X-Amz-Expires"))
+	}
+
+	key := strings.TrimPrefix(req.URL.Path, "/")
+	if bucket == "" {
+		bucket, key, _ = strings.Cut(key, "/")
+	}
+	info.Bucket = bucket
+	info.Key = key
+
+	info.UploadID = q.Get("uploadId")
+	info.PartNumber, _ = strconv.Atoi(q.Get("partNumber"))
+	info.Operation = operation(req.Method, info, q, req.Header)
+	return info, true
+}
+
+// bucketSubresources maps query subresources to the operation suffix used in
+// the S3 API reference (GetBucketAcl, PutBucketPolicy, ...).
+var bucketSubresources = []struct{ query, name string }{
+	{"acl", "Acl"},
+	{"cors", "Cors"},
+	{"encryption", "Encryption"},
+	{"lifecycle", "LifecycleConfiguration"},
+	{"location", "Location"},
+	{"logging", "Logging"},
+	{"notification", "NotificationConfiguration"},
+	{"policy", "Policy"},
+	{"replication", "Replication"},
+	{"tagging", "Tagging"},
+	{"versioning", "Versioning"},
+	{"website", "Website"},
+}
+
+var verbs = map[string]string{
+	http.MethodGet:    "Get",
+	http.MethodPut:    "Put",
+	http.MethodDelete: "Delete",
+}
+
+func operation(method string, info Info, q url.Values, h http.Header) string {
+	if info.Bucket == "" {
+		return "ListBuckets"
+	}
+
+	if info.Key == "" {
+		for _, sr := range bucketSubresources {
+			if verb, ok := verbs[method]; ok && q.Has(sr.query) {
+				return verb + "Bucket" + sr.name
+			}
+		}
+		switch {
+		case method == http.MethodGet && q.Has("uploads"):
+			return "ListMultipartUploads"
+		case method == http.MethodGet && q.Has("versions"):
+			return "ListObjectVersions"
+		case method == http.MethodGet && q.Get("list-type") == "2":
+			return "ListObjectsV2"
+		case method == http.MethodGet:
+			return "ListObjects"
+		case method == http.MethodPost && q.Has("delete"):
+			return "DeleteObjects"
+		case method == http.MethodHead:
+			return "HeadBucket"
+		case method == http.MethodPut:
+			return "CreateBucket"
+		case method == http.MethodDelete:
+			return "DeleteBucket"
+		}
+		return method + " bucket"
+	}
+
+	verb, hasVerb := verbs[method]
+	copied := h.Get("X-Amz-Copy-Source") != ""
+	switch {
+	case hasVerb && q.Has("tagging"):
+		return verb + "ObjectTagging"
+	case hasVerb && q.Has("acl"):
+		return verb + "ObjectAcl"
+	case method == http.MethodPost && q.Has("uploads"):
+		return "CreateMultipartUpload"
+	case method == http.MethodPost && info.UploadID != "":
+		return "CompleteMultipartUpload"
+	case method == http.MethodPut && info.UploadID != "" && copied:
+		return "UploadPartCopy"
+	case method == http.MethodPut && info.UploadID != "":
+		return "UploadPart"
+	case method == http.MethodGet && info.UploadID != "":
+		return "ListParts"
+	case method == http.MethodDelete && info.UploadID != "":
+		return "AbortMultipartUpload"
+	case method == http.MethodPut && copied:
+		return "CopyObject"
+	case method == http.MethodHead:
+		return "HeadObject"
+	case hasVerb:
+		return verb + "Object"
+	}
+	return method + " object"
+}
+
+// Detector implements logger.ProtocolDetector for S3 calls.
+type Detector struct{}
+
+func (Detector) DetectProtocol(req *http.Request, res *http.Response) *logger.Protocol {
+	info, ok := Parse(req)
+	if !ok {
+		return nil
+	}
+	return &logger.Protocol{Name: "s3", Fields: info}
+}
+
+var signatureField = regexp.MustCompile(`(Signature=)[0-9a-fA-F]+`)
+
+// Redactor implements logger.Redactor, replacing SigV4 signatures and
+// session tokens in URLs and headers.
+type Redactor struct{}
+
+func (Redactor) RedactURL(u *url.URL) string {
+	q := u.Query()
+	changed := false
+	for _, k := range []string{"X-Amz-Signature", "X-Amz-Security-Token"} {
+		if q.Has(k) {
+			q.Set(k, redacted)
+			changed = true
+		}
+	}
+	if !changed {
+		return u.String()
+	}
+
+	c := *u
+	c.RawQuery = q.Encode()
+	return c.String()
+}
+
+func (Redactor) RedactHeader(name, value string) string {
+	switch http.CanonicalHeaderKey(name) {
+	case "Authorization":
+		if strings.HasPrefix(value, "AWS4-HMAC-SHA256 ") {
+			return signatureField.ReplaceAllString(value, "${1}"+redacted)
+		}
+	case "X-Amz-Security-Token":
+		return redacted
+	}
+	return value
+}
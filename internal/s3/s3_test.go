@@ -0,0 +1,77 @@
+package s3
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const auth = "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20240101/eu-west-1/s3/aws4_request, " +
+	"SignedHeaders=host;x-amz-date, Signature=fe5f80f77d5fa3beca038a248ff027d0445342fe2855ddc963176630326f1024"
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		method, url string
+		auth        string
+		want        Info
+	}{
+		{
+			"GET", "https://photos.s3.eu-west-1.amazonaws.com/2024/cat.jpg", "",
+			Info{Operation: "GetObject", Bucket: "photos", Key: "2024/cat.jpg", Region: "eu-west-1"},
+		},
+		{
+			"GET", "https://s3.amazonaws.com/photos?list-type=2&prefix=2024/", "",
+			Info{Operation: "ListObjectsV2", Bucket: "photos"},
+		},
+		{
+			"PUT", "http://minio.local:9000/backups/db.tar?partNumber=2&uploadId=abc", auth,
+			Info{Operation: "UploadPart", Bucket: "backups", Key: "db.tar", Region: "eu-west-1", AccessKeyID: "AKIDEXAMPLE", UploadID: "abc", PartNumber: 2},
+		},
+		{
+			"GET", "https://photos.s3-us-west-2.amazonaws.com/?acl", "",
+			Info{Operation: "GetBucketAcl", Bucket: "photos", Region: "us-west-2"},
+		},
+		{
+			"GET", "https://s3.amazonaws.com/photos/cat.jpg?X-Amz-Algorithm=AWS4-HMAC-SHA256" +
+				"&X-Amz-Credential=AKIDEXAMPLE%2F20240101%2Fus-east-1%2Fs3%2Faws4_request&X-Amz-Expires=900&X-Amz-Signature=abc", "",
+			Info{Operation: "GetObject", Bucket: "photos", Key: "cat.jpg", Region: "us-east-1", AccessKeyID: "AKIDEXAMPLE", Presigned: true, ExpiresIn: 900},
+		},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.url, nil)
+		if tt.auth != "" {
+			req.Header.Set("Authorization", tt.auth)
+		}
+		got, ok := Parse(req)
+		if !ok {
+			t.Errorf("%s %s not recognised", tt.method, tt.url)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s %s = %+v, want %+v", tt.method, tt.url, got, tt.want)
+		}
+	}
+
+	if _, ok := Parse(httptest.NewRequest("GET", "http://example.com/bucket/key", nil)); ok {
+		t.Error("Unsigned request to a non-AWS host was recognised")
+	}
+}
+
+func TestRedactor(t *testing.T) {
+	var r Redactor
+
+	got := r.RedactHeader("Authorization", auth)
+	if strings.Contains(got, "fe5f80f7") || !strings.Contains(got, "Credential=AKIDEXAMPLE") {
+		t.Errorf("Authorization not redacted correctly: %q", got)
+	}
+	if got := r.RedactHeader("X-Amz-Security-Token", "token"); got != redacted {
+		t.Errorf("Security token not redacted: %q", got)
+	}
+
+	req, _ := http.NewRequest("GET", "https://s3.amazonaws.com/b/k?X-Amz-Signature=abc123&X-Amz-Expires=60", nil)
+	if got := r.RedactURL(req.URL); strings.Contains(got, "abc123") || !strings.Contains(got, "X-Amz-Expires=60") {
+		t.Errorf("URL not redacted correctly: %q", got)
+	}
+}
@@ -0,0 +1,155 @@
+// Package scope decides which traffic is in scope for an engagement. The
+// same scope limits what the proxy records, intercepts and scans and what
+// reports export, so out-of-scope traffic is ignored everywhere alike.
+package scope
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Scope holds include and exclude patterns. A URL is in scope when it
+// matches an include pattern, or there are none, and no exclude pattern.
+// The nil Scope contains everything.
+type Scope struct {
+	include []pattern
+	exclude []pattern
+}
+
+// pattern is a parsed [SCHEME://]HOST[/PATH] pattern. HOST is a glob
+// matched against the host name without port; PATH is a glob where *
+// matches any run of characters, slashes included.
+type pattern struct {
+	scheme string
+	host   string
+	path   *regexp.Regexp
+}
+
+// New parses include and exclude patterns such as "*.example.com",
+// "https://api.example.com/v2/*" or "cdn.example.com/*.js".
+func New(include, exclude []string) (*Scope, error) {
+	s := &Scope{}
+	for _, list := range []struct {
+		raw []string
+		dst *[]pattern
+	}{{include, &s.include}, {exclude, &s.exclude}} {
+		for _, raw := range list.raw {
+			p, err := parse(raw)
+			if err != nil {
+				return nil, err
+			}
+			*list.dst = append(*list.dst, p)
+		}
+	}
+	return s, nil
+}
+
+func parse(raw string) (pattern, error) {
+	var p pattern
+	rest := strings.TrimSpace(raw)
+	if scheme, after, ok := strings.Cut(rest, "://"); ok {
+		if scheme != "http" && scheme != "https" {
+			return p, fmt.Errorf("scope pattern %q: scheme must be http or https", raw)
+		}
+		p.scheme, rest = scheme, after
+	}
+	host, pathGlob, hasPath := strings.Cut(rest, "/")
+	if host == "" {
+		return p, fmt.Errorf("scope pattern %q has no host", raw)
+	}
+	if _, err := path.Match(host, ""); err != nil {
+		return p, fmt.Errorf("scope pattern %q: invalid host glob", raw)
+	}
+	p.host = strings.ToLower(host)
+	if hasPath && pathGlob != "" && pathGlob != "*" {
+		expr := "^/" + strings.ReplaceAll(regexp.QuoteMeta(pathGlob), `\*`, ".*") + "$"
+		p.path = regexp.MustCompile(expr)
+	}
+	return p, nil
+}
+
+func (p pattern) matchHost(scheme, host string) bool {
+	if p.scheme != "" && scheme != "" && p.scheme != scheme {
+		return false
+	}
+	ok, _ := path.Match(p.host, host)
+	return ok
+}
+
+func (p pattern) match(scheme, host, urlPath string) bool {
+	if !p.matchHost(scheme, host) {
+		return false
+	}
+	if urlPath == "" {
+		urlPath = "/"
+	}
+	return p.path == nil || p.path.MatchString(urlPath)
+}
+
+// Empty reports whether s has no patterns and so contains everything.
+func (s *Scope) Empty() bool {
+	return s == nil || len(s.include) == 0 && len(s.exclude) == 0
+}
+
+// Contains reports whether u is in scope.
+func (s *Scope) Contains(u *url.URL) bool {
+	if s.Empty() {
+		return true
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, p := range s.exclude {
+		if p.match(u.Scheme, host, u.Path) {
+			return false
+		}
+	}
+	if len(s.include) == 0 {
+		return true
+	}
+	for _, p := range s.include {
+		if p.match(u.Scheme, host, u.Path) {
+			return true
+		}
+	}
+	return false
+}
+
+// ContainsURL reports whether the URL raw is in scope. URLs that do not
+// parse are not.
+func (s *Scope) ContainsURL(raw string) bool {
+	if s.Empty() {
+		return true
+	}
+	u, err := url.Parse(raw)
+	return err == nil && s.Contains(u)
+}
+
+// ContainsHost reports whether some of host, given with or without a port,
+// may be in scope: it matches an include pattern, whatever its path, and
+// no exclude pattern covers the whole host.
+func (s *Scope) ContainsHost(host string) bool {
+	if s.Empty() {
+		return true
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+	for _, p := range s.exclude {
+		if p.path == nil && p.scheme == "" && p.matchHost("", host) {
+			return false
+		}
+	}
+	if len(s.include) == 0 {
+		return true
+	}
+	for _, p := range s.include {
+		if p.matchHost("", host) {
+			return true
+		}
+	}
+	return false
+}
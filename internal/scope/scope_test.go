@@ -0,0 +1,63 @@
+package scope
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestContains(t *testing.T) {
+	s, err := New(
+		[]string{"*.example.com", "example.com", "https://api.partner.test/v2/*"},
+		[]string{"logout.example.com", "*.example.com/static/*", "example.com/*.png"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for raw, want := range map[string]bool{
+		"https://example.com/":                 true,
+		"http://shop.example.com:8080/cart":    true,
+		"https://SHOP.example.com/":            true,
+		"https://shop.example.com/static/a.js": false,
+		"https://example.com/img/logo.png":     false,
+		"https://logout.example.com/":          false,
+		"https://api.partner.test/v2/users/1":  true,
+		"http://api.partner.test/v2/users/1":   false,
+		"https://api.partner.test/v1/users":    false,
+		"https://google.com/":                  false,
+	} {
+		u, _ := url.Parse(raw)
+		if got := s.Contains(u); got != want {
+			t.Errorf("Contains(%s) = %v, want %v", raw, got, want)
+		}
+	}
+
+	for host, want := range map[string]bool{
+		"shop.example.com:443":   true,
+		"api.partner.test:443":   true,
+		"logout.example.com:443": false,
+		"tracker.analytics.test": false,
+	} {
+		if got := s.ContainsHost(host); got != want {
+			t.Errorf("ContainsHost(%s) = %v, want %v", host, got, want)
+		}
+	}
+}
+
+func TestExcludeOnly(t *testing.T) {
+	s, err := New(nil, []string{"*.googleapis.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.ContainsURL("https://app.test/") || s.ContainsURL("https://fonts.googleapis.com/css") || s.ContainsHost("fonts.googleapis.com:443") {
+		t.Error("exclude-only scope")
+	}
+	var none *Scope
+	if !none.ContainsURL("https://anything.test/") || !none.ContainsHost("anything.test") {
+		t.Error("the nil scope should contain everything")
+	}
+	for _, bad := range []string{"ftp://x.test", "/path", "[.test"} {
+		if _, err := New([]string{bad}, nil); err == nil {
+			t.Errorf("New accepted %q", bad)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+// Package sensitive detects secrets and personal data in traffic, such as
+// card numbers, email addresses, AWS keys and bearer tokens, so that an
+// audit can show where they cross the proxy.
+package sensitive
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// The built-in detectors, by the names matches carry and detectors are
+// disabled by.
+const (
+	CreditCard   = "credit-card"
+	Email        = "email"
+	AWSAccessKey = "aws-access-key"
+	BearerToken  = "bearer-token"
+)
+
+// Builtins lists every built-in detector.
+var Builtins = []string{CreditCard, Email, AWSAccessKey, BearerToken}
+
+// MaxBody is how much of a body is scanned.
+const MaxBody = 256 << 10
+
+// Detector finds one kind of sensitive data.
+type Detector struct {
+	Name    string
+	Pattern *regexp.Regexp
+	// Valid, when set, rejects pattern matches that are not the real thing,
+	// such as digit runs failing the Luhn check.
+	Valid func(string) bool
+	// Group, when non-zero, is the submatch that holds the value.
+	Group int
+}
+
+var builtins = map[string]Detector{
+	CreditCard: {
+		Name:    CreditCard,
+		Pattern: regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`),
+		Valid:   luhn,
+	},
+	Email: {
+		Name:    Email,
+		Pattern: regexp.MustCompile(`\b[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}\b`),
+	},
+	AWSAccessKey: {
+		Name:    AWSAccessKey,
+		Pattern: regexp.MustCompile(`\b(?:AKIA|ASIA)[A-Z0-9]{16}\b`),
+	},
+	BearerToken: {
+		Name:    BearerToken,
+		Pattern: regexp.MustCompile(`(?i)\bbearer\s+([A-Za-z0-9._~+/-]{16,}=*)`),
+		Group:   1,
+	},
+}
+
+// Match is sensitive data found by a detector.
+type Match struct {
+	Detector string `json:"detector"`
+	// Sample is the first value found, masked.
+	Sample string `json:"sample"`
+	Count  int    `json:"count"`
+}
+
+// Scanner runs a set of detectors.
+type Scanner struct {
+	detectors []Detector
+}
+
+// NewScanner returns a scanner running every built-in detector but those
+// in disabled, followed by the custom detectors, which map names to regular
+// expressions.
+func NewScanner(disabled []string, custom map[string]string) (*Scanner, error) {
+	for _, name := range disabled {
+		if !slices.Contains(Builtins, name) {
+			return nil, fmt.Errorf("unknown detector %q (have %s)", name, strings.Join(Builtins, ", "))
+		}
+	}
+	s := &Scanner{}
+	for _, name := range Builtins {
+		if !slices.Contains(disabled, name) {
+			s.detectors = append(s.detectors, builtins[name])
+		}
+	}
+	names := make([]string, 0, len(custom))
+	for name := range custom {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if slices.Contains(Builtins, name) {
+			return nil, fmt.Errorf("detector %q is built in", name)
+		}
+		re, err := regexp.Compile(custom[name])
+		if err != nil {
+			return nil, fmt.Errorf("detector %q: %w", name, err)
+		}
+		s.detectors = append(s.detectors, Detector{Name: name, Pattern: re})
+	}
+	return s, nil
+}
+
+// Empty reports whether the scanner has no detectors.
+func (s *Scanner) Empty() bool {
+	return len(s.detectors) == 0
+}
+
+// Scan returns what each detector finds in data, in detector order.
+func (s *Scanner) Scan(data []byte) []Match {
+	if len(data) == 0 {
+		return nil
+	}
+	var out []Match
+	for _, d := range s.detectors {
+		var m Match
+		for _, loc := range d.Pattern.FindAllSubmatchIndex(data, -1) {
+			if 2*d.Group+1 >= len(loc) || loc[2*d.Group] < 0 {
+				continue
+			}
+			v := string(data[loc[2*d.Group]:loc[2*d.Group+1]])
+			if d.Valid != nil && !d.Valid(v) {
+				continue
+			}
+			if m.Count == 0 {
+				m = Match{Detector: d.Name, Sample: Mask(v)}
+			}
+			m.Count++
+		}
+		if m.Count > 0 {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+// Mask hides all but the ends of v, so a report shows which value was seen
+// without repeating it.
+func Mask(v string) string {
+	r := []rune(v)
+	switch {
+	case len(r) <= 4:
+		return strings.Repeat("*", len(r))
+	case len(r) <= 12:
+		return string(r[:2]) + "…" + string(r[len(r)-2:])
+	}
+	return string(r[:4]) + "…" + string(r[len(r)-4:])
+}
+
+// luhn reports whether the digits of v pass the Luhn checksum card numbers
+// carry.
+func luhn(v string) bool {
+	var digits []int
+	for _, c := range v {
+		if c >= '0' && c <= '9' {
+			digits = append(digits, int(c-'0'))
+		}
+	}
+	if len(digits) < 13 || len(digits) > 19 {
+		return false
+	}
+	sum := 0
+	for i := range digits {
+		d := digits[len(digits)-1-i]
+		if i%2 == 1 {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return sum%10 == 0
+}
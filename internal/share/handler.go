@@ -0,0 +1,343 @@
+package share
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/standrze/rogue/internal/admin"
+	"github.com/standrze/rogue/internal/display"
+	"github.com/standrze/rogue/internal/filter"
+	"github.com/standrze/rogue/internal/logger"
+	"github.com/standrze/rogue/internal/viewer"
+)
+
+// DefaultTTL is how long a link lasts when no expiry is given.
+const DefaultTTL = 24 * time.Hour
+
+// Link describes a share without its password hash.
+type Link struct {
+	ID   string `json:"id"`
+	Path string `json:"path"`
+	// URL is the full link when the handler knows where shares are served.
+	URL     string        `json:"url,omitempty"`
+	Session string        `json:"session"`
+	Filter  viewer.Filter `json:"filter"`
+	Created time.Time     `json:"created"`
+	Expires time.Time     `json:"expires"`
+}
+
+func (h *Handler) link(sh *Share) Link {
+	l := Link{ID: sh.ID, Path: "/share/" + sh.ID + "/", Session: sh.Session, Filter: sh.Filter, Created: sh.Created, Expires: sh.Expires}
+	if h.BaseURL != "" {
+		l.URL = strings.TrimSuffix(h.BaseURL, "/") + l.Path
+	}
+	return l
+}
+
+// Request is the body of a request creating a share. Expires is a
+// duration such as "24h".
+type Request struct {
+	Session  string        `json:"session"`
+	Password string        `json:"password"`
+	Expires  string        `json:"expires"`
+	Filter   viewer.Filter `json:"filter"`
+}
+
+// Handler serves shares from the sessions in SessionDir.
+type Handler struct {
+	Store      *Store
+	SessionDir string
+	// Formatter, if set, shows times and numbers in shared views.
+	Formatter *display.Formatter
+	// Filters are the saved filters where expressions may refer to.
+	Filters filter.Saved
+	// BaseURL, if set, is where Public is served, such as a listener
+	// reachable by colleagues, and is used to build full links.
+	BaseURL string
+
+	mu sync.Mutex
+	// failures locks out clients guessing passwords.
+	failures *admin.Lockout
+	// verified holds a digest of the password last accepted for each
+	// link, so later requests skip the key derivation.
+	verified map[string][sha256.Size]byte
+	// views keeps each link's viewer until its session file changes.
+	views map[string]*view
+}
+
+// view is a link's viewer over the session file as it was at modTime.
+type view struct {
+	modTime time.Time
+	size    int64
+	handler http.Handler
+}
+
+// Admin serves the management endpoints: GET /shares lists links, POST
+// /shares creates one from JSON or the form at GET /shares/new, and
+// DELETE /shares/{id} revokes one.
+func (h *Handler) Admin() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /shares", h.handleList)
+	mux.HandleFunc("POST /shares", h.handleCreate)
+	mux.HandleFunc("GET /shares/new", h.handleForm)
+	mux.HandleFunc("DELETE /shares/{id}", h.handleRevoke)
+	return mux
+}
+
+// Public serves shared sessions under /share/{id}/ to anyone with the
+// password, sent as the password of HTTP basic authentication. Clients
+// failing it repeatedly are refused for a while.
+func (h *Handler) Public() http.Handler {
+	return http.HandlerFunc(h.handleShare)
+}
+
+func (h *Handler) handleList(w http.ResponseWriter, r *http.Request) {
+	links := make([]Link, 0)
+	for _, sh := range h.Store.List() {
+		links = append(links, h.link(sh))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(links)
+}
+
+func (h *Handler) handleCreate(w http.ResponseWriter, r *http.Request) {
+	form := !strings.HasPrefix(r.Header.Get("Content-Type"), "application/json")
+	var req Request
+	if form {
+		req = Request{
+			Session:  r.PostFormValue("session"),
+			Password: r.PostFormValue("password"),
+			Expires:  r.PostFormValue("expires"),
+		}
+		var err error
+		if req.Filter, err = viewer.FilterFromQuery(r.PostForm, h.Filters); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	sh, err := h.create(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	l := h.link(sh)
+	if form {
+		url := l.URL
+		if url == "" {
+			url = "http://" + r.Host + l.Path
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		pages.ExecuteTemplate(w, "created", map[string]any{"Link": l, "URL": url})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(l)
+}
+
+func (h *Handler) create(req Request) (*Share, error) {
+	if req.Session == "" || req.Session != filepath.Base(req.Session) {
+		return nil, fmt.Errorf("invalid session %q", req.Session)
+	}
+	if _, err := h.sessionPath(req.Session); err != nil {
+		return nil, err
+	}
+	ttl := DefaultTTL
+	if req.Expires != "" {
+		d, err := time.ParseDuration(req.Expires)
+		if err != nil {
+			return nil, fmt.Errorf("invalid expiry %q", req.Expires)
+		}
+		ttl = d
+	}
+	return h.Store.Create(req.Session, req.Filter, req.Password, ttl)
+}
+
+// sessionPath finds a session file, following it if it has since been
+// compressed.
+func (h *Handler) sessionPath(name string) (string, error) {
+	path := filepath.Join(h.SessionDir, name)
+	for _, p := range []string{path, path + ".gz"} {
+		if _, err := os.Stat(p); err == nil {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("session %q not found", name)
+}
+
+func (h *Handler) handleForm(w http.ResponseWriter, r *http.Request) {
+	sessions, err := logger.ListSessions(h.SessionDir)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	pages.ExecuteTemplate(w, "form", sessions)
+}
+
+func (h *Handler) handleRevoke(w http.ResponseWriter, r *http.Request) {
+	ok, err := h.Store.Revoke(r.PathValue("id"))
+	switch {
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	case !ok:
+		http.NotFound(w, r)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (h *Handler) handleShare(w http.ResponseWriter, r *http.Request) {
+	id, _, found := strings.Cut(strings.TrimPrefix(r.URL.Path, "/share/"), "/")
+	sh := h.Store.Get(id)
+	if sh == nil {
+		http.NotFound(w, r)
+		return
+	}
+	client := admin.ClientIP(r)
+	if !h.lockout().Allow(client) {
+		http.Error(w, "too many failed attempts, try again later", http.StatusTooManyRequests)
+		return
+	}
+	_, password, ok := r.BasicAuth()
+	if !ok || !h.checkPassword(sh, password) {
+		if ok {
+			h.lockout().Fail(client)
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="rogue share", charset="UTF-8"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !found {
+		http.Redirect(w, r, "/share/"+id+"/", http.StatusMovedPermanently)
+		return
+	}
+
+	path, err := h.sessionPath(sh.Session)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	v, err := h.view(sh, path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	http.StripPrefix("/share/"+id, v).ServeHTTP(w, r)
+}
+
+func (h *Handler) lockout() *admin.Lockout {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.failures == nil {
+		h.failures = admin.NewLockout()
+	}
+	return h.failures
+}
+
+// checkPassword checks password against sh, deriving its key only if it
+// is not the password accepted last.
+func (h *Handler) checkPassword(sh *Share, password string) bool {
+	digest := sha256.Sum256([]byte(password))
+	h.mu.Lock()
+	known, ok := h.verified[sh.ID]
+	h.mu.Unlock()
+	if ok && subtle.ConstantTimeCompare(known[:], digest[:]) == 1 {
+		return true
+	}
+	if !sh.CheckPassword(password) {
+		return false
+	}
+	h.mu.Lock()
+	if h.verified == nil {
+		h.verified = map[string][sha256.Size]byte{}
+	}
+	h.verified[sh.ID] = digest
+	h.mu.Unlock()
+	return true
+}
+
+// view returns the viewer of sh over the session at path, loading it
+// again only when the file has changed. Views of links that are gone are
+// dropped meanwhile.
+func (h *Handler) view(sh *Share, path string) (http.Handler, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	h.mu.Lock()
+	cached := h.views[sh.ID]
+	h.mu.Unlock()
+	if cached != nil && cached.modTime.Equal(info.ModTime()) && cached.size == info.Size() {
+		return cached.handler, nil
+	}
+
+	flows, err := logger.LoadFlows(path)
+	if err != nil {
+		return nil, err
+	}
+	shown := flows[:0]
+	for _, f := range flows {
+		if sh.Filter.Match(f) {
+			shown = append(shown, f)
+		}
+	}
+	v := viewer.New(sh.Session, shown)
+	if h.Formatter != nil {
+		v.SetFormatter(h.Formatter)
+	}
+	v.SetSavedFilters(h.Filters)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.views == nil {
+		h.views = map[string]*view{}
+	}
+	for id := range h.views {
+		if h.Store.Get(id) == nil {
+			delete(h.views, id)
+			delete(h.verified, id)
+		}
+	}
+	handler := v.Handler()
+	h.views[sh.ID] = &view{modTime: info.ModTime(), size: info.Size(), handler: handler}
+	return handler, nil
+}
+
+var pages = template.Must(template.New("").Parse(`
+{{define "form"}}<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Share a session · Rogue</title></head>
+<body style="font-family: system-ui, sans-serif; margin: 2rem">
+<h1>Share a session</h1>
+<form method="post" action="/shares">
+<p><label>Session <select name="session">{{range .}}<option>{{.}}</option>{{end}}</select></label></p>
+<p>Only flows matching: <input name="q" placeholder="text"> <input name="method" placeholder="method" size="7"> <input name="status" placeholder="status, e.g. 5xx" size="12"> <input name="where" placeholder="expression, e.g. status >= 500" size="30"></p>
+<p><label>Password <input type="password" name="password" required></label></p>
+<p><label>Expires after <input name="expires" value="24h" size="6"></label></p>
+<p><button>Create link</button></p>
+</form>
+</body></html>
+{{end}}
+{{define "created"}}<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Share created · Rogue</title></head>
+<body style="font-family: system-ui, sans-serif; margin: 2rem">
+<h1>Share created</h1>
+<p><a href="{{.URL}}">{{.URL}}</a> shows {{.Link.Session}} until {{.Link.Expires.Format "2006-01-02 15:04 MST"}}.
+Send the link and the password separately.</p>
+</body></html>
+{{end}}
+`))
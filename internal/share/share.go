@@ -0,0 +1,174 @@
+// Package share hands out read-only links to recorded sessions. Each link
+// carries a random ID, expires, and is protected by a password, so a
+// capture can be reviewed without access to the rest of the admin server.
+package share
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/standrze/rogue/internal/viewer"
+)
+
+// pbkdf2Iterations is the work factor for share passwords.
+const pbkdf2Iterations = 100_000
+
+// Share is a link to a session, optionally narrowed to a filtered view.
+type Share struct {
+	ID      string        `json:"id"`
+	Session string        `json:"session"`
+	Filter  viewer.Filter `json:"filter"`
+	Created time.Time     `json:"created"`
+	Expires time.Time     `json:"expires"`
+
+	Salt []byte `json:"salt"`
+	Hash []byte `json:"hash"`
+}
+
+// Expired reports whether s can no longer be used at t.
+func (s *Share) Expired(t time.Time) bool {
+	return !t.Before(s.Expires)
+}
+
+// CheckPassword reports whether password opens s.
+func (s *Share) CheckPassword(password string) bool {
+	hash, err := hashPassword(password, s.Salt)
+	return err == nil && subtle.ConstantTimeCompare(hash, s.Hash) == 1
+}
+
+func hashPassword(password string, salt []byte) ([]byte, error) {
+	return pbkdf2.Key(sha256.New, password, salt, pbkdf2Iterations, 32)
+}
+
+// Store keeps shares in a JSON file so links survive restarts. It is safe
+// for concurrent use.
+type Store struct {
+	path string
+
+	mu     sync.Mutex
+	shares map[string]*Share
+}
+
+// Open loads the shares kept at path, dropping expired ones. A missing
+// file is an empty store.
+func Open(path string) (*Store, error) {
+	s := &Store{path: path, shares: map[string]*Share{}}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var list []*Share
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	for _, sh := range list {
+		if !sh.Expired(now) {
+			s.shares[sh.ID] = sh
+		}
+	}
+	return s, nil
+}
+
+// Create adds a share of session valid for ttl.
+func (s *Store) Create(session string, filter viewer.Filter, password string, ttl time.Duration) (*Share, error) {
+	if password == "" {
+		return nil, errors.New("a password is required")
+	}
+	if ttl <= 0 {
+		return nil, errors.New("the expiry must be in the future")
+	}
+
+	id := make([]byte, 18)
+	salt := make([]byte, 16)
+	rand.Read(id)
+	rand.Read(salt)
+	hash, err := hashPassword(password, salt)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	sh := &Share{
+		ID:      base64.RawURLEncoding.EncodeToString(id),
+		Session: session,
+		Filter:  filter,
+		Created: now,
+		Expires: now.Add(ttl),
+		Salt:    salt,
+		Hash:    hash,
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.shares[sh.ID] = sh
+	return sh, s.save()
+}
+
+// Get returns the share with id, or nil if there is none or it expired.
+func (s *Store) Get(id string) *Share {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sh, ok := s.shares[id]
+	if !ok || sh.Expired(time.Now()) {
+		return nil
+	}
+	return sh
+}
+
+// List returns the shares that have not expired, newest first.
+func (s *Store) List() []*Share {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	var out []*Share
+	for _, sh := range s.shares {
+		if !sh.Expired(now) {
+			out = append(out, sh)
+		}
+	}
+	slices.SortFunc(out, func(a, b *Share) int { return b.Created.Compare(a.Created) })
+	return out
+}
+
+// Revoke deletes the share with id and reports whether it existed.
+func (s *Store) Revoke(id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.shares[id]; !ok {
+		return false, nil
+	}
+	delete(s.shares, id)
+	return true, s.save()
+}
+
+// save writes the store. s.mu must be held.
+func (s *Store) save() error {
+	now := time.Now()
+	list := make([]*Share, 0, len(s.shares))
+	for _, sh := range s.shares {
+		if !sh.Expired(now) {
+			list = append(list, sh)
+		}
+	}
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
@@ -0,0 +1,179 @@
+package share
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/standrze/rogue/internal/viewer"
+)
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".shares")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Create("s.json", viewer.Filter{}, "", time.Hour); err == nil {
+		t.Error("created a share without a password")
+	}
+	sh, err := s.Create("s.json", viewer.Filter{Status: "5xx"}, "hunter2", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sh.CheckPassword("hunter2") || sh.CheckPassword("hunter3") {
+		t.Error("password check is wrong")
+	}
+	if sh.Expired(time.Now()) || !sh.Expired(time.Now().Add(2*time.Hour)) {
+		t.Error("expiry is wrong")
+	}
+
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := reopened.Get(sh.ID)
+	if got == nil || got.Filter.Status != "5xx" || !got.CheckPassword("hunter2") {
+		t.Fatalf("share not kept across restarts: %+v", got)
+	}
+
+	if ok, err := reopened.Revoke(sh.ID); !ok || err != nil {
+		t.Fatalf("revoke: %v %v", ok, err)
+	}
+	if reopened.Get(sh.ID) != nil || len(reopened.List()) != 0 {
+		t.Error("revoked share is still served")
+	}
+}
+
+func TestHandler(t *testing.T) {
+	dir := t.TempDir()
+	data := `[
+		{"type": "request", "data": {"request_id": "aaaa", "method": "GET", "url": "http://ok.example/"}},
+		{"type": "response", "data": {"request_id": "aaaa", "status_code": 200}},
+		{"type": "request", "data": {"request_id": "bbbb", "method": "GET", "url": "http://broken.example/"}},
+		{"type": "response", "data": {"request_id": "bbbb", "status_code": 502}}
+	]`
+	if err := os.WriteFile(filepath.Join(dir, "session_20250101_000000.json"), []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	store, err := Open(filepath.Join(dir, ".shares"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &Handler{Store: store, SessionDir: dir}
+
+	body, _ := json.Marshal(Request{Session: "session_20250101_000000.json", Password: "pw", Filter: viewer.Filter{Status: "5xx"}})
+	req := httptest.NewRequest("POST", "/shares", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	h.Admin().ServeHTTP(rec, req)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create: %d %s", rec.Code, rec.Body)
+	}
+	var l Link
+	json.Unmarshal(rec.Body.Bytes(), &l)
+
+	get := func(password string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", l.Path, nil)
+		if password != "" {
+			req.SetBasicAuth("", password)
+		}
+		rec := httptest.NewRecorder()
+		h.Public().ServeHTTP(rec, req)
+		return rec
+	}
+	if rec := get(""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("no password: got %d", rec.Code)
+	}
+	if rec := get("wrong"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("wrong password: got %d", rec.Code)
+	}
+	rec = get("pw")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("shared view: %d %s", rec.Code, rec.Body)
+	}
+	if page := rec.Body.String(); !strings.Contains(page, "broken.example") || strings.Contains(page, "ok.example") {
+		t.Error("shared view is not limited to its filter")
+	}
+
+	req = httptest.NewRequest("DELETE", "/shares/"+l.ID, nil)
+	rec = httptest.NewRecorder()
+	h.Admin().ServeHTTP(rec, req)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("revoke: got %d", rec.Code)
+	}
+	if rec := get("pw"); rec.Code != http.StatusNotFound {
+		t.Errorf("revoked share: got %d", rec.Code)
+	}
+}
+
+func TestHandlerLockoutAndCache(t *testing.T) {
+	dir := t.TempDir()
+	session := filepath.Join(dir, "session_20250101_000000.json")
+	write := func(host string) {
+		t.Helper()
+		data := `[{"type": "request", "data": {"request_id": "aaaa", "method": "GET", "url": "http://` + host + `/"}}]`
+		if err := os.WriteFile(session, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("first.example")
+	store, err := Open(filepath.Join(dir, ".shares"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &Handler{Store: store, SessionDir: dir, BaseURL: "http://share.example:8090/"}
+	sh, err := h.create(Request{Session: filepath.Base(session), Password: "pw"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l := h.link(sh); l.URL != "http://share.example:8090/share/"+sh.ID+"/" {
+		t.Errorf("link URL = %q", l.URL)
+	}
+
+	get := func(client, password string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/share/"+sh.ID+"/", nil)
+		req.RemoteAddr = client + ":1234"
+		req.SetBasicAuth("", password)
+		rec := httptest.NewRecorder()
+		h.Public().ServeHTTP(rec, req)
+		return rec
+	}
+
+	// A guessing client is locked out, even with the right password, while
+	// others are served.
+	for range 5 {
+		if rec := get("10.0.0.1", "wrong"); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("wrong password: got %d", rec.Code)
+		}
+	}
+	if rec := get("10.0.0.1", "pw"); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("locked out client: got %d", rec.Code)
+	}
+	if rec := get("10.0.0.2", "pw"); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "first.example") {
+		t.Fatalf("other client: got %d", rec.Code)
+	}
+	if _, ok := h.verified[sh.ID]; !ok {
+		t.Error("accepted password was not remembered")
+	}
+	if rec := get("10.0.0.2", "wrong"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("wrong password after a right one: got %d", rec.Code)
+	}
+
+	// The view is kept until the session file changes.
+	cached := h.views[sh.ID]
+	get("10.0.0.2", "pw")
+	if h.views[sh.ID] != cached {
+		t.Error("unchanged session was loaded again")
+	}
+	write("second.example.org")
+	if rec := get("10.0.0.2", "pw"); !strings.Contains(rec.Body.String(), "second.example.org") {
+		t.Error("changed session was not loaded again")
+	}
+}
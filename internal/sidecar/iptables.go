@@ -0,0 +1,51 @@
+package sidecar
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Chain is the nat chain holding the redirect rules.
+const Chain = "ROGUE_OUTPUT"
+
+// Redirect describes how the pod's outbound connections reach the proxy.
+type Redirect struct {
+	// ProxyPort is where the proxy listens in transparent mode.
+	ProxyPort int
+	// ProxyUID is the user the proxy runs as; its own connections to
+	// origins are not redirected.
+	ProxyUID int
+	// Ports are the destination ports redirected.
+	Ports []int
+	// ExcludeCIDRs are destinations reached directly, such as the
+	// cluster's service network.
+	ExcludeCIDRs []string
+}
+
+// Rules returns the iptables invocations, as argument lists, that set up
+// r. They add Chain to the nat table and send every outbound TCP
+// connection through it.
+func (r Redirect) Rules() ([][]string, error) {
+	if r.ProxyPort <= 0 || r.ProxyPort > 65535 {
+		return nil, fmt.Errorf("invalid proxy port %d", r.ProxyPort)
+	}
+	if len(r.Ports) == 0 {
+		return nil, fmt.Errorf("no ports to redirect")
+	}
+	rules := [][]string{
+		{"-t", "nat", "-N", Chain},
+		{"-t", "nat", "-A", Chain, "-m", "owner", "--uid-owner", strconv.Itoa(r.ProxyUID), "-j", "RETURN"},
+		{"-t", "nat", "-A", Chain, "-d", "127.0.0.0/8", "-j", "RETURN"},
+	}
+	for _, cidr := range r.ExcludeCIDRs {
+		rules = append(rules, []string{"-t", "nat", "-A", Chain, "-d", cidr, "-j", "RETURN"})
+	}
+	for _, port := range r.Ports {
+		if port <= 0 || port > 65535 {
+			return nil, fmt.Errorf("invalid port %d", port)
+		}
+		rules = append(rules, []string{"-t", "nat", "-A", Chain, "-p", "tcp", "--dport", strconv.Itoa(port),
+			"-j", "REDIRECT", "--to-ports", strconv.Itoa(r.ProxyPort)})
+	}
+	return append(rules, []string{"-t", "nat", "-A", "OUTPUT", "-p", "tcp", "-j", Chain}), nil
+}
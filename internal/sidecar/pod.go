@@ -0,0 +1,94 @@
+// Package sidecar runs rogue as an egress sidecar in a Kubernetes pod. It
+// builds the iptables rules that redirect the pod's outbound traffic to the
+// proxy, reads the pod's metadata from the downward API and fetches the CA
+// from a secret in the pod's namespace.
+package sidecar
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// namespaceFile holds the namespace of the pod's service account.
+const namespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// Pod describes the pod the proxy runs in.
+type Pod struct {
+	Name      string
+	Namespace string
+	Labels    map[string]string
+}
+
+// LoadPod reads the pod's metadata from a downward API volume mounted at
+// dir, with the files "name", "namespace" and "labels". Missing files are
+// skipped: the name falls back to POD_NAME and HOSTNAME, and the namespace
+// to POD_NAMESPACE and that of the service account.
+func LoadPod(dir string) (*Pod, error) {
+	name, err := readField(filepath.Join(dir, "name"))
+	if err != nil {
+		return nil, err
+	}
+	namespace, err := readField(filepath.Join(dir, "namespace"))
+	if err != nil {
+		return nil, err
+	}
+	if namespace == "" && os.Getenv("POD_NAMESPACE") == "" {
+		if namespace, err = readField(namespaceFile); err != nil {
+			return nil, err
+		}
+	}
+	pod := &Pod{
+		Name:      cmp.Or(name, os.Getenv("POD_NAME"), os.Getenv("HOSTNAME")),
+		Namespace: cmp.Or(namespace, os.Getenv("POD_NAMESPACE")),
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "labels"))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if pod.Labels, err = ParseLabels(data); err != nil {
+		return nil, fmt.Errorf("%s: %w", filepath.Join(dir, "labels"), err)
+	}
+	return pod, nil
+}
+
+// readField returns the trimmed contents of path, or "" if it does not
+// exist.
+func readField(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	}
+	return strings.TrimSpace(string(data)), err
+}
+
+// ParseLabels parses labels in the downward API's format: one key="value"
+// per line, with the value quoted as a Go string.
+func ParseLabels(data []byte) (map[string]string, error) {
+	labels := map[string]string{}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		key, quoted, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid label %q", line)
+		}
+		value, err := strconv.Unquote(quoted)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of label %s: %w", key, err)
+		}
+		labels[key] = value
+	}
+	return labels, sc.Err()
+}
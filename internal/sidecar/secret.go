@@ -0,0 +1,94 @@
+package sidecar
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// serviceAccountDir holds the credentials of the pod's service account.
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// Client reads secrets from the Kubernetes API server.
+type Client struct {
+	// Server is the API server's base URL.
+	Server string
+	Token  string
+	HTTP   *http.Client
+}
+
+// InCluster returns a client authenticated as the pod's service account.
+func InCluster() (*Client, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("not running in a Kubernetes pod: KUBERNETES_SERVICE_HOST is not set")
+	}
+	token, err := os.ReadFile(serviceAccountDir + "/token")
+	if err != nil {
+		return nil, err
+	}
+	ca, err := os.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, err
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificates in %s/ca.crt", serviceAccountDir)
+	}
+	return &Client{
+		Server: "https://" + net.JoinHostPort(host, port),
+		Token:  strings.TrimSpace(string(token)),
+		HTTP: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}},
+		},
+	}, nil
+}
+
+// Secret returns the data of the secret name in namespace.
+func (c *Client) Secret(ctx context.Context, namespace, name string) (map[string][]byte, error) {
+	u := fmt.Sprintf("%s/api/v1/namespaces/%s/secrets/%s", c.Server, url.PathEscape(namespace), url.PathEscape(name))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.Token)
+	req.Header.Set("Accept", "application/json")
+	res, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("secret %s/%s: %s", namespace, name, res.Status)
+	}
+	var secret struct {
+		Data map[string][]byte `json:"data"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&secret); err != nil {
+		return nil, fmt.Errorf("secret %s/%s: %w", namespace, name, err)
+	}
+	return secret.Data, nil
+}
+
+// CA returns the certificate and key of a kubernetes.io/tls secret holding
+// the CA, under the keys tls.crt and tls.key.
+func (c *Client) CA(ctx context.Context, namespace, name string) (certPEM, keyPEM []byte, err error) {
+	data, err := c.Secret(ctx, namespace, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	certPEM, keyPEM = data["tls.crt"], data["tls.key"]
+	if len(certPEM) == 0 || len(keyPEM) == 0 {
+		return nil, nil, fmt.Errorf("secret %s/%s: no tls.crt and tls.key", namespace, name)
+	}
+	return certPEM, keyPEM, nil
+}
@@ -0,0 +1,88 @@
+package sidecar
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadPod(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "name"), []byte("web-7d4b9\n"), 0o644)
+	os.WriteFile(filepath.Join(dir, "namespace"), []byte("shop\n"), 0o644)
+	os.WriteFile(filepath.Join(dir, "labels"), []byte("app=\"web\"\napp.kubernetes.io/version=\"1.2 \\\"beta\\\"\"\n"), 0o644)
+
+	pod, err := LoadPod(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pod.Name != "web-7d4b9" || pod.Namespace != "shop" {
+		t.Errorf("pod = %s/%s", pod.Namespace, pod.Name)
+	}
+	if len(pod.Labels) != 2 || pod.Labels["app"] != "web" || pod.Labels["app.kubernetes.io/version"] != `1.2 "beta"` {
+		t.Errorf("labels = %q", pod.Labels)
+	}
+
+	if _, err := ParseLabels([]byte("app=web")); err == nil {
+		t.Error("ParseLabels accepted an unquoted value")
+	}
+}
+
+func TestRedirectRules(t *testing.T) {
+	rules, err := Redirect{ProxyPort: 8080, ProxyUID: 1337, Ports: []int{80, 443}, ExcludeCIDRs: []string{"10.96.0.0/12"}}.Rules()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	for _, r := range rules {
+		lines = append(lines, strings.Join(r, " "))
+	}
+	want := []string{
+		"-t nat -N ROGUE_OUTPUT",
+		"-t nat -A ROGUE_OUTPUT -m owner --uid-owner 1337 -j RETURN",
+		"-t nat -A ROGUE_OUTPUT -d 127.0.0.0/8 -j RETURN",
+		"-t nat -A ROGUE_OUTPUT -d 10.96.0.0/12 -j RETURN",
+		"-t nat -A ROGUE_OUTPUT -p tcp --dport 80 -j REDIRECT --to-ports 8080",
+		"-t nat -A ROGUE_OUTPUT -p tcp --dport 443 -j REDIRECT --to-ports 8080",
+		"-t nat -A OUTPUT -p tcp -j ROGUE_OUTPUT",
+	}
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Errorf("rules:\n%s", strings.Join(lines, "\n"))
+	}
+
+	if _, err := (Redirect{ProxyPort: 8080}).Rules(); err == nil {
+		t.Error("expected an error without ports")
+	}
+}
+
+func TestClientCA(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != "/api/v1/namespaces/shop/secrets/rogue-ca" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		// Secret data is base64 encoded.
+		w.Write([]byte(`{"kind":"Secret","data":{"tls.crt":"Y2VydA==","tls.key":"a2V5"}}`))
+	}))
+	defer srv.Close()
+
+	c := &Client{Server: srv.URL, Token: "token", HTTP: srv.Client()}
+	certPEM, keyPEM, err := c.CA(context.Background(), "shop", "rogue-ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(certPEM) != "cert" || string(keyPEM) != "key" {
+		t.Errorf("CA = %q, %q", certPEM, keyPEM)
+	}
+	if _, _, err := c.CA(context.Background(), "billing", "rogue-ca"); err == nil {
+		t.Error("expected an error for a missing secret")
+	}
+}
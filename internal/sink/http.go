@@ -0,0 +1,62 @@
+package sink
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// HTTP posts each batch as a JSON array of entries.
+type HTTP struct {
+	url         string
+	headers     map[string]string
+	contentType string
+	client      *http.Client
+}
+
+// NewHTTP returns a sink posting to url with the given extra headers.
+func NewHTTP(url string, headers map[string]string, timeout time.Duration) *HTTP {
+	return &HTTP{
+		url:         url,
+		headers:     headers,
+		contentType: "application/json",
+		client:      &http.Client{Timeout: timeout},
+	}
+}
+
+func (h *HTTP) Send(entries [][]byte) error {
+	var body bytes.Buffer
+	body.WriteByte('[')
+	body.Write(bytes.Join(entries, []byte(",")))
+	body.WriteByte(']')
+	return h.post(&body)
+}
+
+func (h *HTTP) post(body io.Reader) error {
+	req, err := http.NewRequest(http.MethodPost, h.url, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", h.contentType)
+	for k, v := range h.headers {
+		req.Header.Set(k, v)
+	}
+
+	res, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, res.Body)
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("%s: %s", h.url, res.Status)
+	}
+	return nil
+}
+
+func (h *HTTP) Close() error {
+	h.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,56 @@
+// Package sink ships session entries to remote collectors: an HTTP
+// endpoint, a syslog server or a Kafka topic, or to standard output.
+package sink
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/standrze/rogue/internal/logger"
+)
+
+// Sink types.
+const (
+	TypeHTTP   = "http"
+	TypeSyslog = "syslog"
+	TypeKafka  = "kafka"
+	TypeStdout = "stdout"
+)
+
+// Config describes one sink. Fields not used by its Type are ignored.
+type Config struct {
+	Type string
+	// URL is the HTTP endpoint, or for Kafka the base URL of a Kafka REST
+	// proxy.
+	URL     string
+	Headers map[string]string
+	// Network ("udp", "tcp" or "unix") and Address locate a syslog server.
+	Network  string
+	Address  string
+	Facility string
+	Tag      string
+	Topic    string
+	Timeout  time.Duration
+}
+
+// New returns the sink described by cfg.
+func New(cfg Config) (logger.Sink, error) {
+	switch cfg.Type {
+	case TypeHTTP:
+		if cfg.URL == "" {
+			return nil, fmt.Errorf("http sink: no url")
+		}
+		return NewHTTP(cfg.URL, cfg.Headers, cfg.Timeout), nil
+	case TypeSyslog:
+		return NewSyslog(cfg.Network, cfg.Address, cfg.Facility, cfg.Tag, cfg.Timeout)
+	case TypeKafka:
+		if cfg.URL == "" || cfg.Topic == "" {
+			return nil, fmt.Errorf("kafka sink: url and topic are required")
+		}
+		return NewKafka(cfg.URL, cfg.Topic, cfg.Headers, cfg.Timeout), nil
+	case TypeStdout:
+		return NewWriter(os.Stdout), nil
+	}
+	return nil, fmt.Errorf("unknown sink type %q (want http, syslog, kafka or stdout)", cfg.Type)
+}
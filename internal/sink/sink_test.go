@@ -0,0 +1,166 @@
+package sink
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var entries = [][]byte{
+	[]byte(`{"data":{"request_id":"1"},"type":"request"}`),
+	[]byte(`{"data":{"request_id":"1"},"type":"response"}`),
+}
+
+func TestHTTP(t *testing.T) {
+	var got []json.RawMessage
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Token") != "secret" {
+			t.Errorf("X-Token = %q", r.Header.Get("X-Token"))
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer srv.Close()
+
+	s, err := New(Config{Type: TypeHTTP, URL: srv.URL, Headers: map[string]string{"X-Token": "secret"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Send(entries); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || string(got[1]) != string(entries[1]) {
+		t.Errorf("posted %s", got)
+	}
+}
+
+func TestKafka(t *testing.T) {
+	var path, contentType string
+	var got struct {
+		Records []struct {
+			Value json.RawMessage `json:"value"`
+		} `json:"records"`
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, contentType = r.URL.Path, r.Header.Get("Content-Type")
+		json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer srv.Close()
+
+	s, err := New(Config{Type: TypeKafka, URL: srv.URL + "/", Topic: "rogue.sessions"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Send(entries); err != nil {
+		t.Fatal(err)
+	}
+	if path != "/topics/rogue.sessions" || contentType != kafkaContentType {
+		t.Errorf("posted to %s as %s", path, contentType)
+	}
+	if len(got.Records) != 2 || string(got.Records[0].Value) != string(entries[0]) {
+		t.Errorf("records = %+v", got.Records)
+	}
+}
+
+func TestSyslogUDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	s, err := NewSyslog("udp", pc.LocalAddr().String(), "local3", "", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := s.Send(entries[:1]); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 2048)
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := string(buf[:n])
+	// local3.info is 19*8+6.
+	if !strings.HasPrefix(msg, "<158>1 ") || !strings.Contains(msg, " rogue ") ||
+		!strings.HasSuffix(msg, " request - "+string(entries[0])) {
+		t.Errorf("message = %q", msg)
+	}
+}
+
+func TestSyslogTCPFraming(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	got := make(chan []string, 1)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		r := bufio.NewReader(c)
+		var msgs []string
+		for range entries {
+			n, err := r.ReadString(' ')
+			if err != nil {
+				break
+			}
+			size, _ := strconv.Atoi(strings.TrimSpace(n))
+			msg := make([]byte, size)
+			if _, err := io.ReadFull(r, msg); err != nil {
+				break
+			}
+			msgs = append(msgs, string(msg))
+		}
+		got <- msgs
+	}()
+
+	s, err := NewSyslog("tcp", l.Addr().String(), "", "", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := s.Send(entries); err != nil {
+		t.Fatal(err)
+	}
+
+	msgs := <-got
+	if len(msgs) != 2 || !strings.HasSuffix(msgs[1], string(entries[1])) {
+		t.Errorf("messages = %q", msgs)
+	}
+}
+
+func TestWriter(t *testing.T) {
+	var buf strings.Builder
+	w := NewWriter(&buf)
+	if err := w.Send(entries); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	want := string(entries[0]) + "\n" + string(entries[1]) + "\n"
+	if buf.String() != want {
+		t.Errorf("output = %q, want %q", buf.String(), want)
+	}
+}
+
+func TestNewRejectsUnknownType(t *testing.T) {
+	if _, err := New(Config{Type: "carrier-pigeon"}); err == nil {
+		t.Error("expected an error")
+	}
+}
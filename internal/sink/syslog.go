@@ -0,0 +1,139 @@
+package sink
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// severityInfo is the syslog severity entries are sent with.
+const severityInfo = 6
+
+var facilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5,
+	"lpr": 6, "news": 7, "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// Syslog sends each entry as an RFC 5424 message whose MSGID is the entry
+// type. Stream connections use octet-counting framing (RFC 6587).
+type Syslog struct {
+	network string
+	address string
+	pri     int
+	tag     string
+	host    string
+	timeout time.Duration
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// NewSyslog returns a sink for the syslog server at address. network
+// defaults to "udp", facility to "local0" and tag to "rogue".
+func NewSyslog(network, address, facility, tag string, timeout time.Duration) (*Syslog, error) {
+	if address == "" {
+		return nil, fmt.Errorf("syslog sink: no address")
+	}
+	if network == "" {
+		network = "udp"
+	}
+	if facility == "" {
+		facility = "local0"
+	}
+	f, ok := facilities[facility]
+	if !ok {
+		return nil, fmt.Errorf("syslog sink: unknown facility %q", facility)
+	}
+	if tag == "" {
+		tag = "rogue"
+	}
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "-"
+	}
+	return &Syslog{
+		network: network,
+		address: address,
+		pri:     f*8 + severityInfo,
+		tag:     tag,
+		host:    host,
+		timeout: timeout,
+	}, nil
+}
+
+func (s *Syslog) Send(entries [][]byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, e := range entries {
+		msg := s.format(e)
+		// A stale connection is redialled once.
+		if err := s.write(msg); err != nil {
+			s.reset()
+			if err := s.write(msg); err != nil {
+				s.reset()
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// format renders an entry as an RFC 5424 message.
+func (s *Syslog) format(entry []byte) []byte {
+	var head struct {
+		Type string `json:"type"`
+	}
+	json.Unmarshal(entry, &head)
+	msgID := head.Type
+	if msgID == "" {
+		msgID = "-"
+	}
+	msg := fmt.Appendf(nil, "<%d>1 %s %s %s %d %s - ", s.pri,
+		time.Now().UTC().Format(time.RFC3339Nano), s.host, s.tag, os.Getpid(), msgID)
+	msg = append(msg, entry...)
+
+	if s.stream() {
+		return append([]byte(strconv.Itoa(len(msg))+" "), msg...)
+	}
+	return msg
+}
+
+func (s *Syslog) stream() bool {
+	return s.network == "tcp" || s.network == "tcp4" || s.network == "tcp6" || s.network == "unix"
+}
+
+func (s *Syslog) write(msg []byte) error {
+	if s.conn == nil {
+		c, err := net.DialTimeout(s.network, s.address, s.timeout)
+		if err != nil {
+			return err
+		}
+		s.conn = c
+	}
+	if s.timeout > 0 {
+		s.conn.SetWriteDeadline(time.Now().Add(s.timeout))
+	}
+	_, err := s.conn.Write(msg)
+	return err
+}
+
+func (s *Syslog) reset() {
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+}
+
+func (s *Syslog) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reset()
+	return nil
+}
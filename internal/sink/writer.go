@@ -0,0 +1,36 @@
+package sink
+
+import (
+	"bufio"
+	"io"
+	"sync"
+)
+
+// Writer writes each entry as a line of JSON to w, for collectors that read
+// a container's standard output.
+type Writer struct {
+	mu sync.Mutex
+	w  *bufio.Writer
+}
+
+// NewWriter returns a sink writing to w. Closing it flushes w but does not
+// close it.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriter(w)}
+}
+
+func (s *Writer) Send(entries [][]byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range entries {
+		s.w.Write(e)
+		s.w.WriteByte('\n')
+	}
+	return s.w.Flush()
+}
+
+func (s *Writer) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Flush()
+}
@@ -0,0 +1,274 @@
+// Package stats aggregates the flows of a recorded session: request counts
+// per host and status, bytes transferred, latency percentiles, redirect
+// chains and the slowest and largest exchanges.
+package stats
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/standrze/rogue/internal/display"
+	"github.com/standrze/rogue/internal/logger"
+)
+
+// Count is the number of requests sharing a key, such as a host.
+type Count struct {
+	Key   string `json:"key"`
+	Count int    `json:"count"`
+}
+
+// Exchange is a single flow in a top-N list.
+type Exchange struct {
+	ID      string        `json:"id"`
+	Method  string        `json:"method"`
+	URL     string        `json:"url"`
+	Status  int           `json:"status"`
+	Bytes   int64         `json:"bytes"`
+	Latency time.Duration `json:"latency_ns"`
+}
+
+// Summary holds the statistics of a session.
+type Summary struct {
+	Requests  int `json:"requests"`
+	Responses int `json:"responses"`
+	// BytesSent and BytesReceived total request and response body sizes.
+	BytesSent     int64   `json:"bytes_sent"`
+	BytesReceived int64   `json:"bytes_received"`
+	Hosts         []Count `json:"hosts"`
+	Statuses      []Count `json:"statuses"`
+	// Latency percentiles are measured from when a request was logged to
+	// when its response headers were, over flows that have both.
+	P50 time.Duration `json:"p50_ns"`
+	P95 time.Duration `json:"p95_ns"`
+	Max time.Duration `json:"max_ns"`
+
+	Redirects Redirects `json:"redirects"`
+	// Hints counts the failures diagnosed during the session by class,
+	// such as untrusted_ca or dns.
+	Hints []Count `json:"hints"`
+
+	Slowest []Exchange `json:"slowest"`
+	Largest []Exchange `json:"largest"`
+}
+
+// Redirects summarizes the chains of requests that followed redirects,
+// linked by their redirect_of. A chain's length counts its requests, the
+// first included, and its final status is that of its last response.
+type Redirects struct {
+	Chains        int     `json:"chains"`
+	Lengths       []Count `json:"lengths,omitempty"`
+	FinalStatuses []Count `json:"final_statuses,omitempty"`
+}
+
+// Compute summarizes flows, keeping the top slowest and largest exchanges.
+func Compute(flows []*logger.Flow, top int) *Summary {
+	s := &Summary{}
+	hosts := map[string]int{}
+	statuses := map[string]int{}
+	var exchanges []Exchange
+	var latencies []time.Duration
+
+	for _, f := range flows {
+		if f.Request == nil {
+			continue
+		}
+		s.Requests++
+		s.BytesSent += f.Request.BodySize
+		hosts[host(f.Request.URL)]++
+
+		ex := Exchange{ID: f.ID, Method: f.Request.Method, URL: f.Request.URL}
+		if f.Response != nil {
+			s.Responses++
+			s.BytesReceived += f.Response.BodySize
+			statuses[fmt.Sprint(f.Response.StatusCode)]++
+			ex.Status = f.Response.StatusCode
+			ex.Bytes = f.Response.BodySize
+			if !f.Request.Timestamp.IsZero() && !f.Response.Timestamp.IsZero() {
+				ex.Latency = f.Response.Timestamp.Sub(f.Request.Timestamp)
+				latencies = append(latencies, ex.Latency)
+			}
+		} else {
+			statuses["none"]++
+		}
+		exchanges = append(exchanges, ex)
+	}
+
+	s.Hosts = sorted(hosts)
+	s.Statuses = sorted(statuses)
+
+	slices.Sort(latencies)
+	s.P50 = percentile(latencies, 50)
+	s.P95 = percentile(latencies, 95)
+	if len(latencies) > 0 {
+		s.Max = latencies[len(latencies)-1]
+	}
+
+	s.Redirects = redirects(flows)
+	s.Slowest = topBy(exchanges, top, func(e Exchange) int64 { return int64(e.Latency) })
+	s.Largest = topBy(exchanges, top, func(e Exchange) int64 { return e.Bytes })
+	return s
+}
+
+// redirects walks the chains starting at each flow that was followed but
+// follows none recorded in the session.
+func redirects(flows []*logger.Flow) Redirects {
+	byID := map[string]*logger.Flow{}
+	next := map[string]*logger.Flow{}
+	for _, f := range flows {
+		if f.Request == nil {
+			continue
+		}
+		byID[f.ID] = f
+		if of := f.Request.RedirectOf; of != "" {
+			next[of] = f
+		}
+	}
+
+	var r Redirects
+	lengths := map[string]int{}
+	finals := map[string]int{}
+	for _, f := range flows {
+		if f.Request == nil || next[f.ID] == nil || byID[f.Request.RedirectOf] != nil {
+			continue
+		}
+		n, last := 1, f
+		seen := map[string]bool{f.ID: true}
+		for g := next[f.ID]; g != nil && !seen[g.ID]; g = next[g.ID] {
+			seen[g.ID] = true
+			n++
+			last = g
+		}
+		r.Chains++
+		lengths[fmt.Sprint(n)]++
+		if last.Response != nil {
+			finals[fmt.Sprint(last.Response.StatusCode)]++
+		} else {
+			finals["none"]++
+		}
+	}
+	if r.Chains > 0 {
+		r.Lengths = sorted(lengths)
+		r.FinalStatuses = sorted(finals)
+	}
+	return r
+}
+
+// CountHints counts the hint entries of a session by class.
+func (s *Summary) CountHints(entries []logger.Entry) {
+	classes := map[string]int{}
+	for _, e := range entries {
+		if e.Type != "hint" {
+			continue
+		}
+		var h logger.HintLog
+		if json.Unmarshal(e.Data, &h) == nil && h.Class != "" {
+			classes[h.Class]++
+		}
+	}
+	s.Hints = sorted(classes)
+}
+
+func host(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return "(unknown)"
+	}
+	return u.Host
+}
+
+// sorted orders counts by descending count, then key.
+func sorted(m map[string]int) []Count {
+	counts := make([]Count, 0, len(m))
+	for k, n := range m {
+		counts = append(counts, Count{Key: k, Count: n})
+	}
+	slices.SortFunc(counts, func(a, b Count) int {
+		return cmp.Or(cmp.Compare(b.Count, a.Count), cmp.Compare(a.Key, b.Key))
+	})
+	return counts
+}
+
+// percentile returns the nearest-rank percentile p of sorted values.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := (p*len(sorted) + 99) / 100
+	return sorted[max(rank, 1)-1]
+}
+
+// topBy returns the n exchanges with the largest positive key.
+func topBy(exchanges []Exchange, n int, key func(Exchange) int64) []Exchange {
+	var out []Exchange
+	for _, e := range exchanges {
+		if key(e) > 0 {
+			out = append(out, e)
+		}
+	}
+	slices.SortStableFunc(out, func(a, b Exchange) int { return cmp.Compare(key(b), key(a)) })
+	return out[:min(n, len(out))]
+}
+
+// Write prints s as a text report.
+func Write(w io.Writer, s *Summary, f *display.Formatter) {
+	if f == nil {
+		f = display.Default
+	}
+	fmt.Fprintf(w, "Requests:  %s (%s with responses)\n", f.Number(s.Requests), f.Number(s.Responses))
+	fmt.Fprintf(w, "Sent:      %s bytes\n", f.Number(s.BytesSent))
+	fmt.Fprintf(w, "Received:  %s bytes\n", f.Number(s.BytesReceived))
+	if s.Responses > 0 {
+		fmt.Fprintf(w, "Latency:   p50 %s  p95 %s  max %s\n", ms(f, s.P50), ms(f, s.P95), ms(f, s.Max))
+	}
+
+	fmt.Fprintln(w, "\nHosts:")
+	for _, c := range s.Hosts {
+		fmt.Fprintf(w, "  %8s  %s\n", f.Number(c.Count), c.Key)
+	}
+	fmt.Fprintln(w, "\nStatus codes:")
+	for _, c := range s.Statuses {
+		fmt.Fprintf(w, "  %8s  %s\n", f.Number(c.Count), c.Key)
+	}
+
+	if len(s.Hints) > 0 {
+		fmt.Fprintln(w, "\nFailure hints:")
+		for _, c := range s.Hints {
+			fmt.Fprintf(w, "  %8s  %s\n", f.Number(c.Count), c.Key)
+		}
+	}
+
+	if s.Redirects.Chains > 0 {
+		fmt.Fprintf(w, "\nRedirect chains: %s\n", f.Number(s.Redirects.Chains))
+		fmt.Fprintln(w, "  By length in requests:")
+		for _, c := range s.Redirects.Lengths {
+			fmt.Fprintf(w, "  %8s  %s\n", f.Number(c.Count), c.Key)
+		}
+		fmt.Fprintln(w, "  By final status code:")
+		for _, c := range s.Redirects.FinalStatuses {
+			fmt.Fprintf(w, "  %8s  %s\n", f.Number(c.Count), c.Key)
+		}
+	}
+
+	if len(s.Slowest) > 0 {
+		fmt.Fprintln(w, "\nSlowest:")
+		for _, e := range s.Slowest {
+			fmt.Fprintf(w, "  %10s  %s %s (%d) [%s]\n", ms(f, e.Latency), e.Method, e.URL, e.Status, e.ID)
+		}
+	}
+	if len(s.Largest) > 0 {
+		fmt.Fprintln(w, "\nLargest responses:")
+		for _, e := range s.Largest {
+			fmt.Fprintf(w, "  %10s B  %s %s (%d) [%s]\n", f.Number(e.Bytes), e.Method, e.URL, e.Status, e.ID)
+		}
+	}
+}
+
+// ms formats d in milliseconds.
+func ms(f *display.Formatter, d time.Duration) string {
+	return f.Number(float64(d)/float64(time.Millisecond)) + " ms"
+}
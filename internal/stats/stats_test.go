@@ -0,0 +1,124 @@
+package stats
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/standrze/rogue/internal/display"
+	"github.com/standrze/rogue/internal/logger"
+)
+
+func flow(id, method, url string, status int, size int64, latency time.Duration) *logger.Flow {
+	at := time.Date(2026, 1, 2, 10, 0, 0, 0, time.UTC)
+	f := &logger.Flow{ID: id, Request: &logger.RequestLog{Timestamp: at, Method: method, URL: url, BodySize: 10, RequestID: id}}
+	if status != 0 {
+		f.Response = &logger.ResponseLog{Timestamp: at.Add(latency), StatusCode: status, BodySize: size, RequestID: id}
+	}
+	return f
+}
+
+func TestCompute(t *testing.T) {
+	var flows []*logger.Flow
+	for i := 1; i <= 20; i++ {
+		flows = append(flows, flow(fmt.Sprint(i), "GET", "https://api.example.com/items", 200, int64(i*100), time.Duration(i)*time.Millisecond))
+	}
+	flows = append(flows,
+		flow("slow", "POST", "https://slow.example.com/upload", 503, 5, time.Second),
+		flow("lost", "GET", "https://gone.example.com/", 0, 0, 0),
+	)
+
+	s := Compute(flows, 2)
+	if s.Requests != 22 || s.Responses != 21 || s.BytesSent != 220 || s.BytesReceived != 21005 {
+		t.Errorf("totals = %+v", s)
+	}
+	if s.Hosts[0] != (Count{"api.example.com", 20}) || len(s.Hosts) != 3 {
+		t.Errorf("hosts = %v", s.Hosts)
+	}
+	if s.Statuses[0] != (Count{"200", 20}) || len(s.Statuses) != 3 {
+		t.Errorf("statuses = %v", s.Statuses)
+	}
+	if s.P50 != 11*time.Millisecond || s.P95 != 20*time.Millisecond || s.Max != time.Second {
+		t.Errorf("p50 %v p95 %v max %v", s.P50, s.P95, s.Max)
+	}
+	if len(s.Slowest) != 2 || s.Slowest[0].ID != "slow" || s.Slowest[1].ID != "20" {
+		t.Errorf("slowest = %+v", s.Slowest)
+	}
+	if len(s.Largest) != 2 || s.Largest[0].ID != "20" || s.Largest[0].Bytes != 2000 {
+		t.Errorf("largest = %+v", s.Largest)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	f, err := display.New("UTC", "en")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out strings.Builder
+	Write(&out, Compute([]*logger.Flow{flow("1", "GET", "https://a.example/", 200, 1500, 1500*time.Millisecond)}, 5), f)
+
+	for _, want := range []string{"Requests:  1 (1 with responses)", "Received:  1,500 bytes", "p50 1,500.00 ms", "1  a.example", "1,500 B  GET https://a.example/ (200) [1]"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("report lacks %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestRedirects(t *testing.T) {
+	redirect := func(f *logger.Flow, of string) *logger.Flow {
+		f.Request.RedirectOf = of
+		return f
+	}
+	flows := []*logger.Flow{
+		// http -> https -> login page.
+		flow("a1", "GET", "http://app.example/", 301, 0, 0),
+		redirect(flow("a2", "GET", "https://app.example/", 302, 0, 0), "a1"),
+		redirect(flow("a3", "GET", "https://app.example/login", 200, 0, 0), "a2"),
+		// A single redirect to a missing page.
+		flow("b1", "GET", "https://old.example/", 308, 0, 0),
+		redirect(flow("b2", "GET", "https://new.example/", 404, 0, 0), "b1"),
+		// One whose next request was never answered.
+		flow("c1", "GET", "https://c.example/", 302, 0, 0),
+		redirect(flow("c2", "GET", "https://c.example/next", 0, 0, 0), "c1"),
+		// Not followed, and following a flow from another session.
+		flow("d1", "GET", "https://d.example/", 302, 0, 0),
+		redirect(flow("e2", "GET", "https://e.example/", 200, 0, 0), "elsewhere"),
+	}
+
+	r := Compute(flows, 5).Redirects
+	if r.Chains != 3 {
+		t.Errorf("chains = %d, want 3", r.Chains)
+	}
+	if fmt.Sprint(r.Lengths) != "[{2 2} {3 1}]" {
+		t.Errorf("lengths = %v", r.Lengths)
+	}
+	if fmt.Sprint(r.FinalStatuses) != "[{200 1} {404 1} {none 1}]" {
+		t.Errorf("final statuses = %v", r.FinalStatuses)
+	}
+
+	var out strings.Builder
+	Write(&out, Compute(flows, 5), nil)
+	if !strings.Contains(out.String(), "Redirect chains: 3\n  By length in requests:\n         2  2\n") {
+		t.Errorf("report:\n%s", out.String())
+	}
+}
+
+func TestCountHints(t *testing.T) {
+	entries := []logger.Entry{
+		{Type: "request", Data: []byte(`{"request_id": "a"}`)},
+		{Type: "hint", Data: []byte(`{"class": "dns", "host": "a.example"}`)},
+		{Type: "hint", Data: []byte(`{"class": "untrusted_ca", "host": "b.example"}`)},
+		{Type: "hint", Data: []byte(`{"class": "dns", "host": "c.example"}`)},
+	}
+	s := Compute(nil, 5)
+	s.CountHints(entries)
+	if fmt.Sprint(s.Hints) != "[{dns 2} {untrusted_ca 1}]" {
+		t.Errorf("hints = %v", s.Hints)
+	}
+	var out strings.Builder
+	Write(&out, s, nil)
+	if !strings.Contains(out.String(), "Failure hints:\n         2  dns\n         1  untrusted_ca\n") {
+		t.Errorf("report:\n%s", out.String())
+	}
+}
@@ -0,0 +1,83 @@
+package sysproxy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// GNOME sets the manual proxy of GNOME and desktops sharing its settings,
+// such as Cinnamon and Unity, with gsettings.
+type GNOME struct{}
+
+func (GNOME) Name() string { return "GNOME" }
+
+// gnomeKeys are the settings changed, as schema and key.
+var gnomeKeys = [][2]string{
+	{"org.gnome.system.proxy", "mode"},
+	{"org.gnome.system.proxy.http", "host"},
+	{"org.gnome.system.proxy.http", "port"},
+	{"org.gnome.system.proxy.https", "host"},
+	{"org.gnome.system.proxy.https", "port"},
+}
+
+func (GNOME) Snapshot(run Runner) ([]Command, error) {
+	var cmds []Command
+	for _, k := range gnomeKeys {
+		out, err := run("gsettings", "get", k[0], k[1])
+		if err != nil {
+			return nil, err
+		}
+		// Values are printed in the GVariant text format set accepts.
+		cmds = append(cmds, Command{"gsettings", "set", k[0], k[1], strings.TrimSpace(out)})
+	}
+	return cmds, nil
+}
+
+func (GNOME) Enable(run Runner, host string, port int) ([]Command, error) {
+	p := strconv.Itoa(port)
+	return []Command{
+		{"gsettings", "set", "org.gnome.system.proxy.http", "host", host},
+		{"gsettings", "set", "org.gnome.system.proxy.http", "port", p},
+		{"gsettings", "set", "org.gnome.system.proxy.https", "host", host},
+		{"gsettings", "set", "org.gnome.system.proxy.https", "port", p},
+		{"gsettings", "set", "org.gnome.system.proxy", "mode", "manual"},
+	}, nil
+}
+
+// KDE sets the manual proxy of KDE Plasma in kioslaverc with the
+// kreadconfig and kwriteconfig of Version ("5" or "6").
+type KDE struct {
+	Version string
+}
+
+func (KDE) Name() string { return "KDE" }
+
+// kdeKeys are the settings changed in the "Proxy Settings" group.
+var kdeKeys = []string{"ProxyType", "httpProxy", "httpsProxy"}
+
+func (k KDE) Snapshot(run Runner) ([]Command, error) {
+	var cmds []Command
+	for _, key := range kdeKeys {
+		out, err := run("kreadconfig"+k.Version, "--file", "kioslaverc", "--group", "Proxy Settings", "--key", key)
+		if err != nil {
+			return nil, err
+		}
+		cmds = append(cmds, k.write(key, strings.TrimSpace(out)))
+	}
+	return cmds, nil
+}
+
+func (k KDE) Enable(run Runner, host string, port int) ([]Command, error) {
+	// KDE separates the port with a space.
+	proxy := fmt.Sprintf("http://%s %d", host, port)
+	return []Command{
+		k.write("httpProxy", proxy),
+		k.write("httpsProxy", proxy),
+		k.write("ProxyType", "1"),
+	}, nil
+}
+
+func (k KDE) write(key, value string) Command {
+	return Command{"kwriteconfig" + k.Version, "--file", "kioslaverc", "--group", "Proxy Settings", "--key", key, value}
+}
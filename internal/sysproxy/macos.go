@@ -0,0 +1,88 @@
+package sysproxy
+
+import (
+	"bufio"
+	"strconv"
+	"strings"
+)
+
+// MacOS sets the web and secure web proxies of every enabled network
+// service with networksetup.
+type MacOS struct{}
+
+func (MacOS) Name() string { return "macOS" }
+
+// macProxyKinds are the networksetup proxy types changed.
+var macProxyKinds = []string{"web", "secureweb"}
+
+func (m MacOS) Snapshot(run Runner) ([]Command, error) {
+	services, err := m.services(run)
+	if err != nil {
+		return nil, err
+	}
+	var cmds []Command
+	for _, svc := range services {
+		for _, kind := range macProxyKinds {
+			out, err := run("networksetup", "-get"+kind+"proxy", svc)
+			if err != nil {
+				return nil, err
+			}
+			fields := parseFields(out, ":")
+			if server := fields["Server"]; server != "" {
+				cmds = append(cmds, Command{"networksetup", "-set" + kind + "proxy", svc, server, fields["Port"]})
+			}
+			state := "off"
+			if fields["Enabled"] == "Yes" {
+				state = "on"
+			}
+			cmds = append(cmds, Command{"networksetup", "-set" + kind + "proxystate", svc, state})
+		}
+	}
+	return cmds, nil
+}
+
+func (m MacOS) Enable(run Runner, host string, port int) ([]Command, error) {
+	services, err := m.services(run)
+	if err != nil {
+		return nil, err
+	}
+	var cmds []Command
+	for _, svc := range services {
+		for _, kind := range macProxyKinds {
+			cmds = append(cmds,
+				Command{"networksetup", "-set" + kind + "proxy", svc, host, strconv.Itoa(port)},
+				Command{"networksetup", "-set" + kind + "proxystate", svc, "on"})
+		}
+	}
+	return cmds, nil
+}
+
+// services lists the enabled network services.
+func (MacOS) services(run Runner) ([]string, error) {
+	out, err := run("networksetup", "-listallnetworkservices")
+	if err != nil {
+		return nil, err
+	}
+	var services []string
+	sc := bufio.NewScanner(strings.NewReader(out))
+	for first := true; sc.Scan(); first = false {
+		// The first line explains that disabled services are starred.
+		line := strings.TrimSpace(sc.Text())
+		if first || line == "" || strings.HasPrefix(line, "*") {
+			continue
+		}
+		services = append(services, line)
+	}
+	return services, nil
+}
+
+// parseFields reads "name<sep> value" lines.
+func parseFields(out, sep string) map[string]string {
+	fields := map[string]string{}
+	for _, line := range strings.Split(out, "\n") {
+		if k, v, ok := strings.Cut(line, sep); ok {
+			fields[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+	}
+	return fields
+}
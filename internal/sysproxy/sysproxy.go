@@ -0,0 +1,162 @@
+// Package sysproxy points the operating system's HTTP and HTTPS proxy
+// settings at rogue and puts them back afterwards: with networksetup on
+// macOS, in the WinINET settings of the registry on Windows, and with
+// gsettings or kwriteconfig on GNOME and KDE desktops.
+package sysproxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Command is a program and its arguments.
+type Command []string
+
+// Runner runs a program, returning its standard output.
+type Runner func(name string, args ...string) (string, error)
+
+// Exec runs programs with os/exec.
+func Exec(name string, args ...string) (string, error) {
+	out, err := exec.Command(name, args...).Output()
+	if ee, ok := err.(*exec.ExitError); ok && len(ee.Stderr) > 0 {
+		err = fmt.Errorf("%w: %s", err, strings.TrimSpace(string(ee.Stderr)))
+	}
+	return string(out), err
+}
+
+// Platform changes the proxy settings of one operating system or desktop.
+type Platform interface {
+	Name() string
+	// Snapshot returns the commands that restore the current settings.
+	Snapshot(run Runner) ([]Command, error)
+	// Enable returns the commands that send HTTP and HTTPS through
+	// host:port.
+	Enable(run Runner, host string, port int) ([]Command, error)
+}
+
+// Detect returns the platform of this system.
+func Detect() (Platform, error) {
+	switch runtime.GOOS {
+	case "darwin":
+		return MacOS{}, nil
+	case "windows":
+		return WinINET{}, nil
+	}
+	if strings.Contains(strings.ToUpper(os.Getenv("XDG_CURRENT_DESKTOP")), "KDE") {
+		for _, v := range []string{"6", "5"} {
+			if _, err := exec.LookPath("kwriteconfig" + v); err == nil {
+				return KDE{Version: v}, nil
+			}
+		}
+	}
+	if _, err := exec.LookPath("gsettings"); err == nil {
+		return GNOME{}, nil
+	}
+	return nil, fmt.Errorf("no supported proxy settings on this system (macOS, Windows, GNOME or KDE)")
+}
+
+// State holds what is needed to undo Enable. It is saved before anything is
+// changed, so the settings can be restored even if rogue does not exit
+// cleanly.
+type State struct {
+	Platform string    `json:"platform"`
+	Proxy    string    `json:"proxy"`
+	Changed  time.Time `json:"changed"`
+	Restore  []Command `json:"restore"`
+}
+
+// ReadState returns the state saved in path, or nil if there is none.
+func ReadState(path string) (*State, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var s State
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &s, nil
+}
+
+// Enable saves the current settings to stateFile and points the system at
+// host:port. Settings saved by an earlier Enable that were never restored
+// are kept, as the current ones are rogue's.
+func Enable(p Platform, run Runner, stateFile, host string, port int) error {
+	state, err := ReadState(stateFile)
+	if err != nil {
+		return err
+	}
+	if state == nil {
+		restore, err := p.Snapshot(run)
+		if err != nil {
+			return fmt.Errorf("reading the %s proxy settings: %w", p.Name(), err)
+		}
+		state = &State{Platform: p.Name(), Changed: time.Now(), Restore: restore}
+	}
+	state.Proxy = net.JoinHostPort(host, strconv.Itoa(port))
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(stateFile), 0o700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(stateFile, data, 0o600); err != nil {
+		return err
+	}
+	cmds, err := p.Enable(run, host, port)
+	if err != nil {
+		return err
+	}
+	return Run(run, cmds)
+}
+
+// Disable restores the settings saved in stateFile and removes it. It
+// reports false if there was nothing to restore.
+func Disable(run Runner, stateFile string) (bool, error) {
+	state, err := ReadState(stateFile)
+	if err != nil || state == nil {
+		return false, err
+	}
+	if err := Run(run, state.Restore); err != nil {
+		return false, err
+	}
+	return true, os.Remove(stateFile)
+}
+
+// RestoreStale restores the settings saved in stateFile if the proxy they
+// were pointed at is no longer up, as happens when the process that would
+// have restored them was killed. It returns the state it restored, or nil.
+func RestoreStale(run Runner, stateFile string, up func(addr string) bool) (*State, error) {
+	state, err := ReadState(stateFile)
+	if err != nil || state == nil || up(state.Proxy) {
+		return nil, err
+	}
+	if _, err := Disable(run, stateFile); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// Run executes cmds in order, stopping at the first failure.
+func Run(run Runner, cmds []Command) error {
+	for _, c := range cmds {
+		if _, err := run(c[0], c[1:]...); err != nil {
+			return fmt.Errorf("%s: %w", strings.Join(c, " "), err)
+		}
+	}
+	return nil
+}
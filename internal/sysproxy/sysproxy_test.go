@@ -0,0 +1,166 @@
+package sysproxy
+
+import (
+	"errors"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// fakeSystem answers commands from canned output and records the rest.
+type fakeSystem struct {
+	output map[string]string
+	ran    []string
+}
+
+func (f *fakeSystem) run(name string, args ...string) (string, error) {
+	line := strings.Join(append([]string{name}, args...), " ")
+	if out, ok := f.output[line]; ok {
+		return out, nil
+	}
+	if slices.Contains(args, "query") || slices.Contains(args, "get") {
+		return "", errors.New("not found")
+	}
+	f.ran = append(f.ran, line)
+	return "", nil
+}
+
+func lines(cmds []Command) []string {
+	var out []string
+	for _, c := range cmds {
+		out = append(out, strings.Join(c, " "))
+	}
+	return out
+}
+
+func TestMacOS(t *testing.T) {
+	sys := &fakeSystem{output: map[string]string{
+		"networksetup -listallnetworkservices":  "An asterisk (*) denotes that a network service is disabled.\nWi-Fi\n*Thunderbolt Bridge\n",
+		"networksetup -getwebproxy Wi-Fi":       "Enabled: Yes\nServer: corp.example\nPort: 3128\nAuthenticated Proxy Enabled: 0\n",
+		"networksetup -getsecurewebproxy Wi-Fi": "Enabled: No\nServer: \nPort: 0\nAuthenticated Proxy Enabled: 0\n",
+	}}
+	restore, err := MacOS{}.Snapshot(sys.run)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"networksetup -setwebproxy Wi-Fi corp.example 3128",
+		"networksetup -setwebproxystate Wi-Fi on",
+		"networksetup -setsecurewebproxystate Wi-Fi off",
+	}
+	if got := lines(restore); !slices.Equal(got, want) {
+		t.Errorf("restore = %q", got)
+	}
+
+	enable, err := MacOS{}.Enable(sys.run, "127.0.0.1", 8080)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := lines(enable); len(got) != 4 || got[0] != "networksetup -setwebproxy Wi-Fi 127.0.0.1 8080" {
+		t.Errorf("enable = %q", got)
+	}
+}
+
+func TestWinINET(t *testing.T) {
+	sys := &fakeSystem{output: map[string]string{
+		"reg query " + internetSettings + " /v ProxyEnable": "\r\nHKEY_CURRENT_USER\\Software\\Microsoft\\Windows\\CurrentVersion\\Internet Settings\r\n    ProxyEnable    REG_DWORD    0x1\r\n\r\n",
+	}}
+	restore, err := WinINET{}.Snapshot(sys.run)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"reg add " + internetSettings + " /v ProxyEnable /t REG_DWORD /d 1 /f",
+		"reg delete " + internetSettings + " /v ProxyServer /f",
+	}
+	if got := lines(restore); !slices.Equal(got, want) {
+		t.Errorf("restore = %q", got)
+	}
+
+	sys.output["reg query "+internetSettings+" /v ProxyServer"] = "    ProxyServer    REG_SZ    http=corp:3128;https=corp:3128\r\n"
+	restore, _ = WinINET{}.Snapshot(sys.run)
+	if got := lines(restore)[1]; !strings.HasSuffix(got, "/d http=corp:3128;https=corp:3128 /f") {
+		t.Errorf("restore = %q", got)
+	}
+}
+
+func TestEnableDisable(t *testing.T) {
+	sys := &fakeSystem{output: map[string]string{
+		"gsettings get org.gnome.system.proxy mode":       "'none'\n",
+		"gsettings get org.gnome.system.proxy.http host":  "''\n",
+		"gsettings get org.gnome.system.proxy.http port":  "0\n",
+		"gsettings get org.gnome.system.proxy.https host": "''\n",
+		"gsettings get org.gnome.system.proxy.https port": "0\n",
+	}}
+	stateFile := filepath.Join(t.TempDir(), "rogue", "system-proxy.json")
+	if err := Enable(GNOME{}, sys.run, stateFile, "127.0.0.1", 8080); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(sys.ran, "gsettings set org.gnome.system.proxy mode manual") {
+		t.Errorf("ran %q", sys.ran)
+	}
+
+	// A second Enable keeps the settings from before the first.
+	sys.output["gsettings get org.gnome.system.proxy mode"] = "'manual'\n"
+	if err := Enable(GNOME{}, sys.run, stateFile, "127.0.0.1", 9090); err != nil {
+		t.Fatal(err)
+	}
+
+	sys.ran = nil
+	restored, err := Disable(sys.run, stateFile)
+	if err != nil || !restored {
+		t.Fatalf("Disable = %v, %v", restored, err)
+	}
+	if len(sys.ran) != 5 || sys.ran[0] != "gsettings set org.gnome.system.proxy mode 'none'" {
+		t.Errorf("restored with %q", sys.ran)
+	}
+	if restored, err := Disable(sys.run, stateFile); restored || err != nil {
+		t.Errorf("second Disable = %v, %v", restored, err)
+	}
+}
+
+func TestRestoreStale(t *testing.T) {
+	sys := &fakeSystem{output: map[string]string{
+		"gsettings get org.gnome.system.proxy mode":       "'none'\n",
+		"gsettings get org.gnome.system.proxy.http host":  "''\n",
+		"gsettings get org.gnome.system.proxy.http port":  "0\n",
+		"gsettings get org.gnome.system.proxy.https host": "''\n",
+		"gsettings get org.gnome.system.proxy.https port": "0\n",
+	}}
+	stateFile := filepath.Join(t.TempDir(), "system-proxy.json")
+	if state, err := RestoreStale(sys.run, stateFile, func(string) bool { return false }); state != nil || err != nil {
+		t.Fatalf("RestoreStale without state = %v, %v", state, err)
+	}
+
+	if err := Enable(GNOME{}, sys.run, stateFile, "127.0.0.1", 8080); err != nil {
+		t.Fatal(err)
+	}
+	// The enable that was killed pointed the settings at 9090.
+	if err := Enable(GNOME{}, sys.run, stateFile, "127.0.0.1", 9090); err != nil {
+		t.Fatal(err)
+	}
+	var dialed []string
+	up := func(addr string) bool {
+		dialed = append(dialed, addr)
+		return false
+	}
+
+	sys.ran = nil
+	if state, err := RestoreStale(sys.run, stateFile, func(string) bool { return true }); state != nil || err != nil || sys.ran != nil {
+		t.Fatalf("RestoreStale with the proxy up = %v, %v, ran %q", state, err, sys.ran)
+	}
+	state, err := RestoreStale(sys.run, stateFile, up)
+	if err != nil || state == nil {
+		t.Fatalf("RestoreStale = %v, %v", state, err)
+	}
+	if !slices.Equal(dialed, []string{"127.0.0.1:9090"}) {
+		t.Errorf("checked %q", dialed)
+	}
+	if len(sys.ran) != 5 || sys.ran[0] != "gsettings set org.gnome.system.proxy mode 'none'" {
+		t.Errorf("restored with %q", sys.ran)
+	}
+	if s, _ := ReadState(stateFile); s != nil {
+		t.Errorf("state left behind: %+v", s)
+	}
+}
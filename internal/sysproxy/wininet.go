@@ -0,0 +1,63 @@
+package sysproxy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// internetSettings is the registry key of the per-user WinINET settings.
+const internetSettings = `HKCU\Software\Microsoft\Windows\CurrentVersion\Internet Settings`
+
+// WinINET sets the proxy of the current user's WinINET settings, used by
+// Windows itself, Edge, Chrome and most other programs, with reg. Programs
+// already running may only notice once they open new connections.
+type WinINET struct{}
+
+func (WinINET) Name() string { return "Windows" }
+
+func (WinINET) Snapshot(run Runner) ([]Command, error) {
+	enable, ok := regValue(run, "ProxyEnable")
+	if !ok {
+		enable = "0"
+	}
+	n, err := strconv.ParseUint(enable, 0, 32)
+	if err != nil {
+		return nil, fmt.Errorf("ProxyEnable %q: %w", enable, err)
+	}
+	cmds := []Command{{"reg", "add", internetSettings, "/v", "ProxyEnable", "/t", "REG_DWORD", "/d", strconv.FormatUint(n, 10), "/f"}}
+	if server, ok := regValue(run, "ProxyServer"); ok {
+		cmds = append(cmds, Command{"reg", "add", internetSettings, "/v", "ProxyServer", "/t", "REG_SZ", "/d", server, "/f"})
+	} else {
+		cmds = append(cmds, Command{"reg", "delete", internetSettings, "/v", "ProxyServer", "/f"})
+	}
+	return cmds, nil
+}
+
+func (WinINET) Enable(run Runner, host string, port int) ([]Command, error) {
+	addr := fmt.Sprintf("%s:%d", host, port)
+	return []Command{
+		{"reg", "add", internetSettings, "/v", "ProxyServer", "/t", "REG_SZ", "/d", "http=" + addr + ";https=" + addr, "/f"},
+		{"reg", "add", internetSettings, "/v", "ProxyEnable", "/t", "REG_DWORD", "/d", "1", "/f"},
+	}, nil
+}
+
+// regValue reads a value of internetSettings, reporting false if it is not
+// set. reg query prints it as "name    type    data".
+func regValue(run Runner, name string) (string, bool) {
+	out, err := run("reg", "query", internetSettings, "/v", name)
+	if err != nil {
+		return "", false
+	}
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && strings.EqualFold(fields[0], name) && strings.HasPrefix(fields[1], "REG_") {
+			rest := strings.TrimSpace(line)
+			for _, f := range fields[:2] {
+				rest = strings.TrimSpace(strings.TrimPrefix(rest, f))
+			}
+			return rest, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,204 @@
+// Package tail prints the flows of a session as one line each while it is
+// being recorded, optionally collapsing repeated identical exchanges such
+// as polling into a single row with a counter.
+package tail
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/standrze/rogue/internal/display"
+	"github.com/standrze/rogue/internal/logger"
+)
+
+// timeLayout is how row times are shown.
+const timeLayout = "15:04:05"
+
+// row is a printed exchange and the repeats folded into it.
+type row struct {
+	key     string
+	method  string
+	url     string
+	status  int
+	size    int64
+	first   time.Time
+	last    time.Time
+	repeats int
+	// operation names the GraphQL operations of the request, if any.
+	operation string
+}
+
+// Printer writes a line per completed exchange. With a positive Collapse,
+// an exchange with the same method, URL and status as one of the last
+// Collapse rows is counted on that row instead of printed. On a terminal
+// the row is redrawn in place; otherwise it is printed again with its count
+// once it leaves the window, or on Flush.
+type Printer struct {
+	// Match, if set, selects the exchanges that are printed.
+	Match func(*logger.Flow) bool
+	// Color highlights status codes by class.
+	Color bool
+
+	w        io.Writer
+	f        *display.Formatter
+	terminal bool
+	collapse int
+
+	pending map[string]*logger.RequestLog
+	window  []*row
+}
+
+// New returns a Printer writing to w. f formats times and sizes; nil means
+// display.Default.
+func New(w io.Writer, f *display.Formatter, terminal bool, collapse int) *Printer {
+	if f == nil {
+		f = display.Default
+	}
+	return &Printer{
+		w:        w,
+		f:        f,
+		terminal: terminal,
+		collapse: collapse,
+		pending:  make(map[string]*logger.RequestLog),
+	}
+}
+
+// Add handles the next session entry. Rows are printed when a response
+// completes an exchange.
+func (p *Printer) Add(e logger.Entry) error {
+	switch e.Type {
+	case "request":
+		var req logger.RequestLog
+		if err := json.Unmarshal(e.Data, &req); err != nil {
+			return err
+		}
+		p.pending[req.RequestID] = &req
+	case "response":
+		var res logger.ResponseLog
+		if err := json.Unmarshal(e.Data, &res); err != nil {
+			return err
+		}
+		req, ok := p.pending[res.RequestID]
+		if !ok {
+			return nil
+		}
+		delete(p.pending, res.RequestID)
+		if p.Match != nil && !p.Match(&logger.Flow{ID: res.RequestID, Request: req, Response: &res}) {
+			return nil
+		}
+		op := operations(req.GraphQL)
+		p.add(&row{
+			key:       fmt.Sprintf("%s %s %s %d", req.Method, req.URL, op, res.StatusCode),
+			method:    req.Method,
+			url:       req.URL,
+			operation: op,
+			status:    res.StatusCode,
+			size:      res.BodySize,
+			first:     res.Timestamp,
+			last:      res.Timestamp,
+		})
+	}
+	return nil
+}
+
+func (p *Printer) add(r *row) {
+	if p.collapse > 0 {
+		for i, seen := range p.window {
+			if seen.key == r.key {
+				seen.repeats++
+				seen.last = r.last
+				if p.terminal {
+					p.redraw(i)
+				}
+				return
+			}
+		}
+	}
+
+	fmt.Fprintln(p.w, p.line(r))
+	if p.collapse <= 0 {
+		return
+	}
+	p.window = append(p.window, r)
+	if len(p.window) > p.collapse {
+		p.evict(p.window[0])
+		p.window = p.window[1:]
+	}
+}
+
+// redraw rewrites the row at window index i, which is on screen above the
+// cursor, and returns the cursor to the line below the window.
+func (p *Printer) redraw(i int) {
+	up := len(p.window) - i
+	fmt.Fprintf(p.w, "\x1b[%dA\r\x1b[K%s\r\x1b[%dB", up, p.line(p.window[i]), up)
+}
+
+// evict reports the repeats of a row leaving the window when they could
+// not be shown in place.
+func (p *Printer) evict(r *row) {
+	if !p.terminal && r.repeats > 0 {
+		fmt.Fprintln(p.w, p.line(r))
+	}
+}
+
+// Flush reports the repeats of rows still in the window.
+func (p *Printer) Flush() {
+	for _, r := range p.window {
+		p.evict(r)
+	}
+	p.window = nil
+}
+
+func (p *Printer) line(r *row) string {
+	status := fmt.Sprintf("%3d", r.status)
+	if p.Color {
+		status = colorStatus(r.status, status)
+	}
+	url := r.url
+	if r.operation != "" {
+		url += " (" + r.operation + ")"
+	}
+	s := fmt.Sprintf("%s  %-7s %s  %s  %s B", p.f.Time(r.first, timeLayout), r.method, status, url, p.f.Number(r.size))
+	if r.repeats > 0 {
+		s += fmt.Sprintf("  x%s (last %s)", p.f.Number(r.repeats+1), p.f.Time(r.last, timeLayout))
+	}
+	return s
+}
+
+// operations names GraphQL operations for a row, such as "query GetUser".
+func operations(ops []logger.GraphQLOperation) string {
+	names := make([]string, 0, len(ops))
+	for _, op := range ops {
+		name := op.Name
+		if name == "" {
+			name = "anonymous"
+		}
+		if op.Type != "" {
+			name = op.Type + " " + name
+		}
+		names = append(names, name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// colorStatus wraps s in the ANSI color for the class of status: green for
+// success, cyan for redirects, yellow for client and red for server errors.
+func colorStatus(status int, s string) string {
+	var code string
+	switch {
+	case status >= 500:
+		code = "31"
+	case status >= 400:
+		code = "33"
+	case status >= 300:
+		code = "36"
+	case status >= 200:
+		code = "32"
+	default:
+		return s
+	}
+	return "\x1b[" + code + "m" + s + "\x1b[0m"
+}
@@ -0,0 +1,124 @@
+package tail
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/standrze/rogue/internal/display"
+	"github.com/standrze/rogue/internal/logger"
+)
+
+func exchange(t *testing.T, id, url string, status int) []logger.Entry {
+	t.Helper()
+	at := time.Date(2026, 1, 2, 10, 0, 0, 0, time.UTC)
+	req, _ := json.Marshal(logger.RequestLog{Timestamp: at, Method: "GET", URL: url, RequestID: id})
+	res, _ := json.Marshal(logger.ResponseLog{Timestamp: at, StatusCode: status, BodySize: 1200, RequestID: id})
+	return []logger.Entry{{Type: "request", Data: req}, {Type: "response", Data: res}}
+}
+
+func feed(t *testing.T, p *Printer, urls ...string) {
+	t.Helper()
+	for i, u := range urls {
+		for _, e := range exchange(t, string(rune('a'+i)), u, 200) {
+			if err := p.Add(e); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	p.Flush()
+}
+
+func formatter(t *testing.T) *display.Formatter {
+	f, err := display.New("UTC", "en")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestPrinterWithoutCollapse(t *testing.T) {
+	var out strings.Builder
+	feed(t, New(&out, formatter(t), false, 0), "http://x/poll", "http://x/poll")
+
+	want := "10:00:00  GET     200  http://x/poll  1,200 B\n" +
+		"10:00:00  GET     200  http://x/poll  1,200 B\n"
+	if out.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
+
+func TestPrinterCollapse(t *testing.T) {
+	var out strings.Builder
+	feed(t, New(&out, formatter(t), false, 2),
+		"http://x/poll", "http://x/a", "http://x/poll", "http://x/poll", "http://x/b", "http://x/c")
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	var urls []string
+	for _, l := range lines {
+		urls = append(urls, strings.Fields(l)[3])
+	}
+	// /poll is counted while it is in the window, then reported with its
+	// count when /b pushes it out.
+	if got := strings.Join(urls, " "); got != "http://x/poll http://x/a http://x/b http://x/poll http://x/c" {
+		t.Errorf("rows:\n%s", out.String())
+	}
+	if !strings.HasSuffix(lines[3], "x3 (last 10:00:00)") {
+		t.Errorf("evicted row %q lacks its count", lines[3])
+	}
+}
+
+func TestPrinterCollapseOnTerminal(t *testing.T) {
+	var out strings.Builder
+	feed(t, New(&out, formatter(t), true, 5), "http://x/poll", "http://x/a", "http://x/poll")
+
+	if !strings.Contains(out.String(), "\x1b[2A\r\x1b[K10:00:00  GET     200  http://x/poll  1,200 B  x2") {
+		t.Errorf("row was not redrawn in place: %q", out.String())
+	}
+	if strings.Count(out.String(), "\n") != 2 {
+		t.Errorf("repeat printed a new row: %q", out.String())
+	}
+}
+
+func TestPrinterMatchAndColor(t *testing.T) {
+	var out strings.Builder
+	p := New(&out, formatter(t), false, 0)
+	p.Match = func(f *logger.Flow) bool { return f.Response.StatusCode >= 500 }
+	p.Color = true
+	for i, status := range []int{200, 503, 404} {
+		for _, e := range exchange(t, string(rune('a'+i)), "http://x/", status) {
+			p.Add(e)
+		}
+	}
+
+	want := "10:00:00  GET     \x1b[31m503\x1b[0m  http://x/  1,200 B\n"
+	if out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+}
+
+func TestPrinterGraphQL(t *testing.T) {
+	var out strings.Builder
+	p := New(&out, formatter(t), false, 5)
+	at := time.Date(2026, 1, 2, 10, 0, 0, 0, time.UTC)
+	for i, op := range []string{"GetUser", "GetCart", "GetUser"} {
+		id := string(rune('a' + i))
+		req, _ := json.Marshal(logger.RequestLog{Timestamp: at, Method: "POST", URL: "http://x/graphql", RequestID: id,
+			GraphQL: []logger.GraphQLOperation{{Name: op, Type: "query"}}})
+		res, _ := json.Marshal(logger.ResponseLog{Timestamp: at, StatusCode: 200, BodySize: 10, RequestID: id})
+		for _, e := range []logger.Entry{{Type: "request", Data: req}, {Type: "response", Data: res}} {
+			if err := p.Add(e); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	p.Flush()
+
+	want := "10:00:00  POST    200  http://x/graphql (query GetUser)  10 B\n" +
+		"10:00:00  POST    200  http://x/graphql (query GetCart)  10 B\n" +
+		"10:00:00  POST    200  http://x/graphql (query GetUser)  10 B  x2 (last 10:00:00)\n"
+	if out.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
@@ -0,0 +1,58 @@
+// Package tracing exports OpenTelemetry spans for proxied exchanges to an
+// OTLP/HTTP collector such as Jaeger.
+package tracing
+
+import (
+	"context"
+	"net/url"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.40.0"
+)
+
+// tracesPath is where OTLP/HTTP collectors accept spans.
+const tracesPath = "/v1/traces"
+
+// Config describes where spans are exported.
+type Config struct {
+	// Endpoint is the collector's base URL, such as http://localhost:4318;
+	// /v1/traces is appended when it has no path.
+	Endpoint    string
+	Headers     map[string]string
+	ServiceName string
+	// SampleRatio is the fraction of new traces recorded. Exchanges that
+	// carry a sampled traceparent are always recorded.
+	SampleRatio float64
+}
+
+// NewProvider returns a tracer provider batching spans to cfg.Endpoint.
+// Shut it down to flush pending spans.
+func NewProvider(ctx context.Context, cfg Config) (*sdktrace.TracerProvider, error) {
+	u, err := url.Parse(cfg.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = tracesPath
+	}
+
+	exp, err := otlptracehttp.New(ctx,
+		otlptracehttp.WithEndpointURL(u.String()),
+		otlptracehttp.WithHeaders(cfg.Headers),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	name := cfg.ServiceName
+	if name == "" {
+		name = "rogue"
+	}
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(name))),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	), nil
+}
@@ -0,0 +1,42 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestNewProviderExports(t *testing.T) {
+	var mu sync.Mutex
+	var paths []string
+	var auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		paths = append(paths, r.URL.Path)
+		auth = r.Header.Get("Authorization")
+	}))
+	defer srv.Close()
+
+	tp, err := NewProvider(context.Background(), Config{
+		Endpoint:    srv.URL,
+		Headers:     map[string]string{"Authorization": "Bearer token"},
+		SampleRatio: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, span := tp.Tracer("test").Start(context.Background(), "GET")
+	span.End()
+	if err := tp.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(paths) != 1 || paths[0] != tracesPath || auth != "Bearer token" {
+		t.Errorf("collector saw %v with Authorization %q", paths, auth)
+	}
+}
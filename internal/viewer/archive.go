@@ -0,0 +1,184 @@
+package viewer
+
+import (
+	"cmp"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/standrze/rogue/internal/display"
+	"github.com/standrze/rogue/internal/logger"
+)
+
+// Archive serves single flows by ID from every session in a directory.
+// Flows are read from the session files on demand, so a link to one keeps
+// working after the proxy restarts or the session is rotated.
+type Archive struct {
+	dir string
+	t   *template.Template
+
+	mu sync.Mutex
+	// sessions remembers which session a flow was found in.
+	sessions map[string]string
+}
+
+// NewArchive returns an archive over the sessions in dir.
+func NewArchive(dir string) *Archive {
+	return &Archive{dir: dir, t: templates, sessions: map[string]string{}}
+}
+
+// SetFormatter shows times and numbers with f.
+func (a *Archive) SetFormatter(f *display.Formatter) {
+	a.t = template.Must(templates.Clone()).Funcs(f.Funcs())
+}
+
+// Handler serves /flows/{id} as a page, /api/flows/{id} as JSON and the
+// stored request or response body at /api/flows/{id}/body/{side}.
+func (a *Archive) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /flows/{id}", a.handleFlow)
+	mux.HandleFunc("GET /api/flows/{id}", a.handleAPIFlow)
+	mux.HandleFunc("GET /api/flows/{id}/body/{side}", a.handleAPIBody)
+	return mux
+}
+
+// Find returns the flow with id and the name of the session holding it.
+// Newer sessions are searched first.
+func (a *Archive) Find(id string) (*logger.Flow, string, error) {
+	a.mu.Lock()
+	name, ok := a.sessions[id]
+	a.mu.Unlock()
+	if ok {
+		if f, err := a.load(name, id); f != nil || err != nil {
+			return f, name, err
+		}
+	}
+
+	names, err := logger.ListSessions(a.dir)
+	if err != nil {
+		return nil, "", err
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(names)))
+	for _, name := range names {
+		f, err := a.load(name, id)
+		if err != nil {
+			continue
+		}
+		if f != nil {
+			a.mu.Lock()
+			a.sessions[id] = name
+			a.mu.Unlock()
+			return f, name, nil
+		}
+	}
+	return nil, "", nil
+}
+
+// load returns flow id of a session, or nil if it has no such flow. A
+// session that has since been compressed is looked up under its new name.
+func (a *Archive) load(name, id string) (*logger.Flow, error) {
+	path := filepath.Join(a.dir, name)
+	if _, err := os.Stat(path); os.IsNotExist(err) && filepath.Ext(name) == ".json" {
+		path += ".gz"
+	}
+	flows, err := logger.LoadFlows(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range flows {
+		if f.ID == id {
+			return f, nil
+		}
+	}
+	return nil, nil
+}
+
+func (a *Archive) handleFlow(w http.ResponseWriter, r *http.Request) {
+	f, session, err := a.Find(r.PathValue("id"))
+	switch {
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	case f == nil:
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := a.t.ExecuteTemplate(w, "flow.html", map[string]any{
+		"Session": session,
+		"Flow":    f,
+	}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (a *Archive) handleAPIFlow(w http.ResponseWriter, r *http.Request) {
+	f, session, err := a.Find(r.PathValue("id"))
+	switch {
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	case f == nil:
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"session": session, "flow": f})
+}
+
+// handleAPIBody writes a stored body as it was sent, with the Content-Type
+// and Content-Encoding it was logged with. X-Rogue-Body-Size gives the size
+// of the original body, and X-Rogue-Body-Truncated is set when logging kept
+// only part of it.
+func (a *Archive) handleAPIBody(w http.ResponseWriter, r *http.Request) {
+	f, _, err := a.Find(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var (
+		headers   map[string]string
+		body      []byte
+		size      int64
+		truncated bool
+	)
+	switch side := r.PathValue("side"); {
+	case side == "request" && f != nil && f.Request != nil:
+		headers, size, truncated = f.Request.Headers, f.Request.BodySize, f.Request.Truncated
+		body, err = f.Request.RawBody()
+	case side == "response" && f != nil && f.Response != nil:
+		headers, size, truncated = f.Response.Headers, f.Response.BodySize, f.Response.Truncated
+		body, err = f.Response.RawBody()
+	case side != "request" && side != "response":
+		http.Error(w, "side must be request or response", http.StatusBadRequest)
+		return
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", cmp.Or(headers["Content-Type"], "application/octet-stream"))
+	if ce := headers["Content-Encoding"]; ce != "" {
+		w.Header().Set("Content-Encoding", ce)
+	}
+	w.Header().Set("Content-Disposition", "attachment")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("X-Rogue-Body-Size", strconv.FormatInt(size, 10))
+	if truncated {
+		w.Header().Set("X-Rogue-Body-Truncated", "true")
+	}
+	w.Write(body)
+}
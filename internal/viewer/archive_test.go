@@ -0,0 +1,91 @@
+package viewer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestArchive(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, id, url string) {
+		data := `[{"type": "request", "data": {"request_id": "` + id + `", "method": "GET", "url": "` + url + `"}}]`
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("session_20250101_000000.json", "aaaa", "http://old.example/")
+	write("session_20250102_000000.json", "bbbb", "http://new.example/")
+
+	h := NewArchive(dir).Handler()
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		return rec
+	}
+
+	rec := get("/flows/aaaa")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "http://old.example/") {
+		t.Errorf("flow page: %d %s", rec.Code, rec.Body)
+	}
+	rec = get("/api/flows/bbbb")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"session":"session_20250102_000000.json"`) {
+		t.Errorf("flow API: %d %s", rec.Code, rec.Body)
+	}
+	if rec := get("/flows/cccc"); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown flow: got %d", rec.Code)
+	}
+
+	// A link found once still resolves after its session is compressed.
+	old := filepath.Join(dir, "session_20250101_000000.json")
+	data, _ := os.ReadFile(old)
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(data)
+	zw.Close()
+	os.WriteFile(old+".gz", buf.Bytes(), 0o644)
+	os.Remove(old)
+	if rec := get("/flows/aaaa"); rec.Code != http.StatusOK {
+		t.Errorf("compressed session: got %d", rec.Code)
+	}
+}
+
+func TestArchiveBody(t *testing.T) {
+	dir := t.TempDir()
+	data := `[
+  {"type": "request", "data": {"request_id": "aaaa", "method": "POST", "url": "http://a.example/", "body": "q=1", "body_size": 3}},
+  {"type": "response", "data": {"request_id": "aaaa", "status_code": 200, "headers": {"Content-Type": "image/png"}, "body": "iVBORw0KGgo=", "body_encoding": "base64", "body_size": 4096, "body_truncated": true}}
+]`
+	if err := os.WriteFile(filepath.Join(dir, "session_20250101_000000.json"), []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	h := NewArchive(dir).Handler()
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		return rec
+	}
+
+	rec := get("/api/flows/aaaa/body/response")
+	if rec.Code != http.StatusOK || rec.Body.String() != "\x89PNG\r\n\x1a\n" {
+		t.Errorf("response body: %d %q", rec.Code, rec.Body)
+	}
+	if rec.Header().Get("Content-Type") != "image/png" || rec.Header().Get("X-Rogue-Body-Size") != "4096" || rec.Header().Get("X-Rogue-Body-Truncated") != "true" {
+		t.Errorf("response headers: %v", rec.Header())
+	}
+	if rec := get("/api/flows/aaaa/body/request"); rec.Body.String() != "q=1" || rec.Header().Get("X-Rogue-Body-Truncated") != "" {
+		t.Errorf("request body: %q %v", rec.Body, rec.Header())
+	}
+	if rec := get("/api/flows/aaaa/body/trailers"); rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown side: got %d", rec.Code)
+	}
+	if rec := get("/api/flows/bbbb/body/response"); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown flow: got %d", rec.Code)
+	}
+}
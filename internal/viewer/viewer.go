@@ -0,0 +1,263 @@
+package viewer
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/standrze/rogue/internal/codec"
+	"github.com/standrze/rogue/internal/display"
+	"github.com/standrze/rogue/internal/filter"
+	"github.com/standrze/rogue/internal/logger"
+)
+
+//go:embed templates/*.html
+var templateFS embed.FS
+
+var templates = template.Must(template.New("").Funcs(template.FuncMap{
+	"sortedHeaders": sortedHeaders,
+	"render": func(headers map[string]string, body string, decoded *logger.Decoded) string {
+		return codec.Render(headers["Content-Type"], body, decoded)
+	},
+	"image": func(headers map[string]string, body, encoding string) template.URL {
+		uri, _ := codec.ImageURI(headers["Content-Type"], body, encoding)
+		return template.URL(uri)
+	},
+	"query": func(rawURL string) []codec.Field {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			return nil
+		}
+		fields, _ := codec.Fields(u.RawQuery)
+		return fields
+	},
+}).Funcs(display.Default.Funcs()).ParseFS(templateFS, "templates/*.html"))
+
+// Viewer serves a read-only browser over the flows of a recorded session.
+type Viewer struct {
+	name  string
+	flows []*logger.Flow
+	byID  map[string]*logger.Flow
+	t     *template.Template
+	saved filter.Saved
+}
+
+// New returns a viewer for flows, titled with the session name.
+func New(name string, flows []*logger.Flow) *Viewer {
+	byID := make(map[string]*logger.Flow, len(flows))
+	for _, f := range flows {
+		byID[f.ID] = f
+	}
+	return &Viewer{name: name, flows: flows, byID: byID, t: templates}
+}
+
+// SetFormatter shows times and numbers with f.
+func (v *Viewer) SetFormatter(f *display.Formatter) {
+	v.t = template.Must(templates.Clone()).Funcs(f.Funcs())
+}
+
+// SetSavedFilters lets filter expressions refer to saved ones as @name.
+func (v *Viewer) SetSavedFilters(saved filter.Saved) {
+	v.saved = saved
+}
+
+// Handler returns the HTTP handler for the viewer.
+func (v *Viewer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", v.handleIndex)
+	mux.HandleFunc("GET /flows/{id}", v.handleFlow)
+	mux.HandleFunc("GET /api/flows", v.handleAPIFlows)
+	return mux
+}
+
+// Filter narrows a flow list by free-text search, method, status and a
+// filter expression.
+type Filter struct {
+	Query  string `json:"query,omitempty"`
+	Method string `json:"method,omitempty"`
+	// Status matches an exact code ("404") or a class ("5xx").
+	Status string       `json:"status,omitempty"`
+	Where  *filter.Expr `json:"where,omitempty"`
+}
+
+// FilterFromQuery builds a Filter from the q, method, status and where
+// query parameters, resolving saved filters in where from saved.
+func FilterFromQuery(q url.Values, saved filter.Saved) (Filter, error) {
+	flt := Filter{
+		Query:  q.Get("q"),
+		Method: q.Get("method"),
+		Status: q.Get("status"),
+	}
+	if w := q.Get("where"); w != "" {
+		var err error
+		if flt.Where, err = saved.Parse(w); err != nil {
+			return flt, err
+		}
+	}
+	return flt, nil
+}
+
+// ParseFilter builds a Filter from command-line terms: "method=POST",
+// "status=404" or "status=5xx", "where=<expression>", and anything else as
+// the search text.
+func ParseFilter(terms []string, saved filter.Saved) (Filter, error) {
+	var flt Filter
+	for _, t := range terms {
+		key, value, ok := strings.Cut(t, "=")
+		switch {
+		case ok && key == "method":
+			flt.Method = value
+		case ok && key == "status":
+			if !validStatus(value) {
+				return flt, fmt.Errorf("invalid status %q (want a code such as 404 or a class such as 5xx)", value)
+			}
+			flt.Status = value
+		case ok && key == "where":
+			if flt.Where != nil {
+				return flt, fmt.Errorf("only one where expression is allowed; combine them with &&")
+			}
+			var err error
+			if flt.Where, err = saved.Parse(value); err != nil {
+				return flt, err
+			}
+		case flt.Query != "":
+			return flt, fmt.Errorf("only one search text is allowed, got %q and %q", flt.Query, t)
+		default:
+			flt.Query = t
+		}
+	}
+	return flt, nil
+}
+
+func validStatus(s string) bool {
+	if len(s) != 3 || s[0] < '1' || s[0] > '5' {
+		return false
+	}
+	if strings.EqualFold(s[1:], "xx") {
+		return true
+	}
+	_, err := strconv.Atoi(s)
+	return err == nil
+}
+
+// Match reports whether f satisfies the filter.
+func (flt Filter) Match(f *logger.Flow) bool {
+	if !flt.Where.Match(f) {
+		return false
+	}
+
+	if flt.Method != "" && (f.Request == nil || !strings.EqualFold(f.Request.Method, flt.Method)) {
+		return false
+	}
+
+	if flt.Status != "" {
+		if f.Response == nil {
+			return false
+		}
+		code := strconv.Itoa(f.Response.StatusCode)
+		if strings.HasSuffix(strings.ToLower(flt.Status), "xx") {
+			if code[:1] != flt.Status[:1] {
+				return false
+			}
+		} else if code != flt.Status {
+			return false
+		}
+	}
+
+	if flt.Query != "" {
+		q := strings.ToLower(flt.Query)
+		var haystack []string
+		if f.Request != nil {
+			haystack = append(haystack, f.Request.URL, f.Request.Body)
+		}
+		if f.Response != nil {
+			haystack = append(haystack, f.Response.Body)
+		}
+		found := false
+		for _, h := range haystack {
+			if strings.Contains(strings.ToLower(h), q) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (v *Viewer) filtered(flt Filter) []*logger.Flow {
+	out := make([]*logger.Flow, 0)
+	for _, f := range v.flows {
+		if flt.Match(f) {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+func (v *Viewer) handleIndex(w http.ResponseWriter, r *http.Request) {
+	flt, err := FilterFromQuery(r.URL.Query(), v.saved)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	v.render(w, "index.html", map[string]any{
+		"Session": v.name,
+		"Home":    ".",
+		"Filter":  flt,
+		"Flows":   v.filtered(flt),
+		"Total":   len(v.flows),
+	})
+}
+
+func (v *Viewer) handleFlow(w http.ResponseWriter, r *http.Request) {
+	f, ok := v.byID[r.PathValue("id")]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	v.render(w, "flow.html", map[string]any{
+		"Session": v.name,
+		"Home":    "..",
+		"Flow":    f,
+	})
+}
+
+func (v *Viewer) handleAPIFlows(w http.ResponseWriter, r *http.Request) {
+	flt, err := FilterFromQuery(r.URL.Query(), v.saved)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v.filtered(flt))
+}
+
+func (v *Viewer) render(w http.ResponseWriter, name string, data any) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := v.t.ExecuteTemplate(w, name, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+type header struct {
+	Name, Value string
+}
+
+func sortedHeaders(h map[string]string) []header {
+	out := make([]header, 0, len(h))
+	for k, v := range h {
+		out = append(out, header{k, v})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
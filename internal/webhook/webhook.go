@@ -0,0 +1,184 @@
+// Package webhook notifies an HTTP endpoint when a session file is complete,
+// so every capture can be picked up by automation.
+package webhook
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/standrze/rogue/internal/logger"
+)
+
+// Reasons a session file was completed.
+const (
+	ReasonRotated = "rotated"
+	ReasonClosed  = "closed"
+)
+
+// Config describes the endpoint and what is sent to it.
+type Config struct {
+	URL     string
+	Headers map[string]string
+	// IncludeFile uploads the session file with the manifest as a
+	// multipart/form-data request instead of posting the manifest alone.
+	IncludeFile bool
+	// LocationPrefix is prepended to the file name to give the manifest's
+	// location, for example the URL of a bucket the session directory is
+	// synced to.
+	LocationPrefix string
+	Timeout        time.Duration
+	// Retries is how many times a failed delivery is retried.
+	Retries int
+}
+
+// Manifest describes a completed session file.
+type Manifest struct {
+	Session  string    `json:"session"`
+	Path     string    `json:"path"`
+	Location string    `json:"location,omitempty"`
+	Size     int64     `json:"size"`
+	SHA256   string    `json:"sha256"`
+	Entries  int       `json:"entries"`
+	Started  time.Time `json:"started"`
+	Closed   time.Time `json:"closed"`
+	Reason   string    `json:"reason"`
+}
+
+// Notifier delivers manifests to the configured endpoint.
+type Notifier struct {
+	cfg    Config
+	client *http.Client
+	// backoff is the delay before the first retry; it doubles each time.
+	backoff time.Duration
+}
+
+// New returns a Notifier for cfg.
+func New(cfg Config) *Notifier {
+	return &Notifier{
+		cfg:     cfg,
+		client:  &http.Client{Timeout: cfg.Timeout},
+		backoff: time.Second,
+	}
+}
+
+// NewManifest describes f, hashing its contents.
+func NewManifest(f logger.SessionFile, locationPrefix string) (*Manifest, error) {
+	file, err := os.Open(f.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(h, file)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Manifest{
+		Session: f.Name,
+		Path:    f.Path,
+		Size:    size,
+		SHA256:  hex.EncodeToString(h.Sum(nil)),
+		Entries: f.Entries,
+		Started: f.Opened,
+		Closed:  f.Closed,
+		Reason:  ReasonClosed,
+	}
+	if locationPrefix != "" {
+		m.Location = locationPrefix + f.Name
+	}
+	if f.Rotated {
+		m.Reason = ReasonRotated
+	}
+	return m, nil
+}
+
+// Notify posts the manifest of f, retrying failed deliveries.
+func (n *Notifier) Notify(f logger.SessionFile) error {
+	m, err := NewManifest(f, n.cfg.LocationPrefix)
+	if err != nil {
+		return err
+	}
+
+	delay := n.backoff
+	for attempt := 0; ; attempt++ {
+		err = n.post(m)
+		if err == nil || attempt >= n.cfg.Retries {
+			return err
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+func (n *Notifier) post(m *Manifest) error {
+	manifest, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	body, contentType := io.Reader(bytes.NewReader(manifest)), "application/json"
+	if n.cfg.IncludeFile {
+		if body, contentType, err = multipartBody(manifest, m.Path); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodPost, n.cfg.URL, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	for k, v := range n.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+
+	res, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, res.Body)
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("webhook %s: %s", n.cfg.URL, res.Status)
+	}
+	return nil
+}
+
+// multipartBody builds a form with the manifest in the "manifest" field and
+// the session file in the "session" field.
+func multipartBody(manifest []byte, path string) (io.Reader, string, error) {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	if err := w.WriteField("manifest", string(manifest)); err != nil {
+		return nil, "", err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, "", err
+	}
+	defer file.Close()
+
+	part, err := w.CreateFormFile("session", filepath.Base(path))
+	if err != nil {
+		return nil, "", err
+	}
+	if _, err := io.Copy(part, file); err != nil {
+		return nil, "", err
+	}
+	if err := w.Close(); err != nil {
+		return nil, "", err
+	}
+	return &buf, w.FormDataContentType(), nil
+}
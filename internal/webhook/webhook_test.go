@@ -0,0 +1,96 @@
+package webhook
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/standrze/rogue/internal/logger"
+)
+
+func sessionFile(t *testing.T) logger.SessionFile {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "session_1.json")
+	if err := os.WriteFile(path, []byte("[\n]"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return logger.SessionFile{Name: "session_1.json", Path: path, Entries: 3, Rotated: true}
+}
+
+func TestNotify(t *testing.T) {
+	var calls atomic.Int32
+	var got Manifest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			http.Error(w, "try again", http.StatusServiceUnavailable)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer token" {
+			t.Errorf("Authorization = %q", r.Header.Get("Authorization"))
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer srv.Close()
+
+	n := New(Config{
+		URL:            srv.URL,
+		Headers:        map[string]string{"Authorization": "Bearer token"},
+		LocationPrefix: "s3://captures/",
+		Retries:        1,
+	})
+	n.backoff = time.Millisecond
+	if err := n.Notify(sessionFile(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	if calls.Load() != 2 {
+		t.Errorf("calls = %d, want 2", calls.Load())
+	}
+	if got.Session != "session_1.json" || got.Location != "s3://captures/session_1.json" ||
+		got.Size != 3 || got.Entries != 3 || got.Reason != ReasonRotated || len(got.SHA256) != 64 {
+		t.Errorf("manifest = %+v", got)
+	}
+}
+
+func TestNotifyIncludeFile(t *testing.T) {
+	var manifest, session string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		manifest = r.FormValue("manifest")
+		f, _, err := r.FormFile("session")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		b, _ := io.ReadAll(f)
+		session = string(b)
+	}))
+	defer srv.Close()
+
+	if err := New(Config{URL: srv.URL, IncludeFile: true}).Notify(sessionFile(t)); err != nil {
+		t.Fatal(err)
+	}
+	if session != "[\n]" {
+		t.Errorf("session = %q", session)
+	}
+	var m Manifest
+	if err := json.Unmarshal([]byte(manifest), &m); err != nil || m.Session != "session_1.json" {
+		t.Errorf("manifest = %q, %v", manifest, err)
+	}
+}
+
+func TestNotifyFails(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	if err := New(Config{URL: srv.URL}).Notify(sessionFile(t)); err == nil {
+		t.Error("expected an error")
+	}
+}